@@ -0,0 +1,113 @@
+package LingSIP
+
+import (
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/constants"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultAPIPageSize pagination size when the query does not set limit
+	DefaultAPIPageSize = 20
+	// MaxAPIPageSize upper bound of limit for call and session queries
+	MaxAPIPageSize = 500
+)
+
+// WithDB injects db into the request context for WebObject handlers
+func WithDB(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(constants.DbField, db)
+		c.Next()
+	}
+}
+
+// RegisterCallAPIs registers read-only endpoints for SIP calls (/calls) and
+// AI phone sessions with their step executions (/ai-sessions). GET /:id reads
+// one record, POST queries with a QueryForm; besides the regular filters a
+// "phone" filter matches either side of the call. The group must be
+// protected by auth.RequireAdmin.
+func RegisterCallAPIs(r *gin.RouterGroup) {
+	RegisterObjects(r, CallObjects())
+}
+
+// CallObjects returns the WebObjects behind RegisterCallAPIs
+func CallObjects() []WebObject {
+	return []WebObject{
+		{
+			Model:        &models.SipCall{},
+			Name:         "calls",
+			Desc:         "SIP call records",
+			AuthRequired: true,
+			AllowMethods: GET | QUERY,
+			Filterables:  []string{"Status", "Direction", "Disposition", "FromUsername", "ToUsername", "CallID", "StartTime", "CreatedAt"},
+			Orderables:   []string{"ID", "StartTime", "Duration", "CreatedAt"},
+			Searchables:  []string{"FromUsername", "ToUsername", "CallID"},
+			PrepareQuery: pagedPrepareQuery("from_username", "to_username"),
+		},
+		{
+			Model:        &models.AIPhoneSession{},
+			Name:         "ai-sessions",
+			Desc:         "AI phone sessions",
+			AuthRequired: true,
+			AllowMethods: GET | QUERY,
+			Filterables:  []string{"Status", "ScriptID", "CallerNumber", "CalleeNumber", "CallID", "StartTime", "CreatedAt"},
+			Orderables:   []string{"ID", "StartTime", "Duration", "EstimatedCost", "CreatedAt"},
+			Searchables:  []string{"CallerNumber", "CalleeNumber", "ScriptName", "CallID"},
+			GetDB: func(c *gin.Context, isCreate bool) *gorm.DB {
+				db := c.MustGet(constants.DbField).(*gorm.DB)
+				return db.Preload("StepExecutions", func(tx *gorm.DB) *gorm.DB {
+					return tx.Order("start_time ASC")
+				})
+			},
+			PrepareQuery: pagedPrepareQuery("caller_number", "callee_number"),
+		},
+	}
+}
+
+// pagedPrepareQuery bounds limit to MaxAPIPageSize and turns the "phone"
+// filter into an OR over the two phone number columns
+func pagedPrepareQuery(phoneColumns ...string) PrepareQuery {
+	return func(db *gorm.DB, c *gin.Context) (*gorm.DB, *QueryForm, error) {
+		var form QueryForm
+		if c.Request.ContentLength > 0 {
+			if err := c.BindJSON(&form); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if form.Pos < 0 {
+			form.Pos = 0
+		}
+		if form.Limit <= 0 {
+			form.Limit = DefaultAPIPageSize
+		}
+		if form.Limit > MaxAPIPageSize {
+			form.Limit = MaxAPIPageSize
+		}
+
+		filters := form.Filters[:0]
+		for _, filter := range form.Filters {
+			if filter.Name != "phone" {
+				filters = append(filters, filter)
+				continue
+			}
+			phone, ok := filter.Value.(string)
+			if !ok || phone == "" {
+				continue
+			}
+			tx := db.Session(&gorm.Session{NewDB: true})
+			for i, column := range phoneColumns {
+				if i == 0 {
+					tx = tx.Where(column+" = ?", phone)
+				} else {
+					tx = tx.Or(column+" = ?", phone)
+				}
+			}
+			db = db.Where(tx)
+		}
+		form.Filters = filters
+
+		return db, &form, nil
+	}
+}
@@ -138,11 +138,26 @@ func RunMigrations(db *gorm.DB) error {
 		&models.SipCall{},
 		&models.SipSession{},
 		&models.SipUser{},
+		&models.SipRegistration{},
 		&models.SIPTrunk{},
 		&models.AIPhoneScript{},
 		&models.AIPhoneScriptStep{},
 		&models.ScriptPhoneMapping{},
 		&models.AIPhoneSession{},
 		&models.StepExecution{},
+		&models.DialRule{},
+		&models.AuditLog{},
+		&models.DeletionCertificate{},
+		&models.SipMessage{},
+		&models.LLMExchange{},
+		&models.PromptTemplate{},
+		&models.Campaign{},
+		&models.CampaignContact{},
+		&models.ReprocessJob{},
+		&models.ReprocessResult{},
+		&models.DoNotCallEntry{},
+		&models.DoNotCallSuppression{},
+		&models.AccessRule{},
+		&models.ProviderUsage{},
 	})
 }
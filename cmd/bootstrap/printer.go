@@ -3,7 +3,10 @@ package bootstrap
 import (
 	"fmt"
 	"os"
+	"sort"
 	"strings"
+
+	"github.com/LingByte/LingSIP/pkg/buildinfo"
 )
 
 // PrintBannerFromFile Read file and print, auto-generate if file doesn't exist
@@ -38,3 +41,23 @@ func PrintBannerFromFile(filename string, defaultText string) error {
 	}
 	return nil
 }
+
+// PrintBuildInfo Print version and enabled feature flags below the banner
+func PrintBuildInfo(info buildinfo.Info, flags map[string]bool) {
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	toggles := make([]string, 0, len(names))
+	for _, name := range names {
+		state := "off"
+		if flags[name] {
+			state = "on"
+		}
+		toggles = append(toggles, name+"="+state)
+	}
+	fmt.Println("\x1b[38;5;189mVersion: " + info.String() + "\x1b[0m")
+	fmt.Println("\x1b[38;5;189mFeatures: " + strings.Join(toggles, " ") + "\x1b[0m")
+}
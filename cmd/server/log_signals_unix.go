@@ -0,0 +1,32 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// watchLogLevelSignals SIGUSR1 switches components to debug, SIGUSR2 restores the global level
+func watchLogLevelSignals(components []string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			switch sig {
+			case syscall.SIGUSR1:
+				for _, component := range components {
+					if err := logger.SetComponentLevel(component, "debug"); err != nil {
+						logger.Warn("Failed to set component log level", zap.String("component", component), zap.Error(err))
+					}
+				}
+			case syscall.SIGUSR2:
+				logger.ResetComponentLevels()
+			}
+		}
+	}()
+}
@@ -0,0 +1,6 @@
+//go:build windows
+
+package main
+
+// watchLogLevelSignals SIGUSR1/SIGUSR2 are not available on Windows, use the /api/log-levels API
+func watchLogLevelSignals(components []string) {}
@@ -2,21 +2,36 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	LingSIP "github.com/LingByte/LingSIP"
 	"github.com/LingByte/LingSIP/cmd/bootstrap"
 	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/auth"
+	"github.com/LingByte/LingSIP/pkg/buildinfo"
 	"github.com/LingByte/LingSIP/pkg/config"
+	"github.com/LingByte/LingSIP/pkg/features"
 	"github.com/LingByte/LingSIP/pkg/llm"
 	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/secrets"
 	sip1 "github.com/LingByte/LingSIP/pkg/sip"
 	"github.com/LingByte/LingSIP/pkg/sip/ua"
 	"github.com/LingByte/LingSIP/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gorm.io/gorm"
 )
 
 func main() {
@@ -24,6 +39,10 @@ func main() {
 	mode := flag.String("mode", "", "running environment (development, test, production)")
 	init := flag.Bool("init", false, "initialize database")
 	initSQL := flag.String("init-sql", "", "path to database init .sql script (optional)")
+	subjectExport := flag.String("subject-export", "", "print all data of a caller number as JSON and exit")
+	subjectDelete := flag.String("subject-delete", "", "hard-delete all data of a caller number and exit")
+	subjectActor := flag.String("subject-actor", "", "who requested -subject-delete, recorded in the deletion certificate")
+	subjectReason := flag.String("subject-reason", "", "reference for -subject-delete, e.g. request ticket, required")
 	flag.Parse()
 
 	// 2. Set Environment Variables
@@ -41,10 +60,14 @@ func main() {
 		panic(err)
 	}
 
-	// 5. Print Banner
+	// 5. Load Feature Flags and Print Banner
+	if err := features.LoadEnv(); err != nil {
+		logger.Warn("Feature flags fall back to defaults", zap.Error(err))
+	}
 	if err := bootstrap.PrintBannerFromFile("banner.txt", config.GlobalConfig.Server.Name); err != nil {
 		log.Fatalf("unload banner: %v", err)
 	}
+	bootstrap.PrintBuildInfo(buildinfo.Get(), features.Snapshot())
 
 	// 7. Load Data Source
 	db, err := bootstrap.SetupDatabase(os.Stdout, &bootstrap.Options{
@@ -57,10 +80,19 @@ func main() {
 		return
 	}
 
+	// Subject access and deletion requests run against the database and exit
+	if *subjectExport != "" || *subjectDelete != "" {
+		if err := runDataSubjectCommand(db, *subjectExport, *subjectDelete, *subjectActor, *subjectReason); err != nil {
+			logger.Error("data subject command failed", zap.Error(err))
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 8. Load Base Configs
 	var addr = config.GlobalConfig.Server.Addr
 	if addr == "" {
-		addr = ":7075"
+		addr = "127.0.0.1:7075"
 	}
 
 	var DBDriver = config.GlobalConfig.Database.Driver
@@ -90,7 +122,14 @@ func main() {
 		Out:       os.Stdout,
 		Formatter: &logrus.TextFormatter{},
 		Level:     logrus.InfoLevel,
+		Hooks:     make(logrus.LevelHooks),
 	}
+	llmLogger.AddHook(logger.LogrusHook())
+	logger.OnComponentLevel(logger.ComponentLLM, func(level zapcore.Level) {
+		if lvl, err := logrus.ParseLevel(level.String()); err == nil {
+			llmLogger.SetLevel(lvl)
+		}
+	})
 	llmService := llm.NewService(llmConfig, llmLogger)
 
 	// Initialize LLM with system prompt
@@ -121,33 +160,102 @@ func main() {
 		logger.Info("LLM service initialized successfully")
 	}
 
+	// SIP state storage: database by default, redis shares sessions and registrations between instances
+	storageType := ua.StorageType(utils.GetEnv("SIP_STORAGE_TYPE"))
+	if storageType == "" {
+		storageType = ua.StorageTypeDatabase
+	}
+	var redisClient *redis.Client
+	if redisAddr := utils.GetEnv("SIP_REDIS_ADDR"); redisAddr != "" {
+		redisPassword, err := secrets.Resolve(ctx, utils.GetEnv("SIP_REDIS_PASSWORD"))
+		if err != nil {
+			panic("redis password: " + err.Error())
+		}
+		redisClient = redis.NewClient(&redis.Options{
+			Addr:     redisAddr,
+			Password: redisPassword,
+			DB:       int(utils.GetIntEnv("SIP_REDIS_DB")),
+		})
+		defer redisClient.Close()
+	}
+
+	tenantCallLimits, err := sip1.ParseCallLimits(utils.GetEnv("SIP_TENANT_CALL_LIMITS"))
+	if err != nil {
+		panic("SIP_TENANT_CALL_LIMITS: " + err.Error())
+	}
+	var iceServers []string
+	if servers := utils.GetEnv("SIP_ICE_SERVERS"); servers != "" {
+		iceServers = strings.Split(servers, ",")
+	}
+
 	server, err := sip1.NewSipServer(10000, 5060, &ua.UAConfig{
-		Host:                  "0.0.0.0",
-		Port:                  5060,
-		UserAgentName:         ua.DEFAULT_USER_AGENT,
-		LocalRTPPort:          10000, // 修改为12000避免端口冲突
-		RegisterTimeout:       30 * time.Second,
-		TransactionTimeout:    30 * time.Second,
-		KeepAliveInterval:     60 * time.Second,
-		MaxForwards:           70,
-		EnableAuthentication:  false,
-		AuthenticationRealm:   ua.DEFAULT_REALM_NAME,
-		EnableTLS:             false,
-		TLSCertFile:           "",
-		TLSKeyFile:            "",
-		LogLevel:              "info",
-		LogFile:               "",
-		RTPBufferSize:         1500, // standard 以太网 MTU Size
-		MaxConcurrentSessions: 100,
-		SessionTimeout:        10 * time.Minute,
-		NetworkInterface:      "",
-		EnableICE:             false,
-		StorageType:           ua.StorageTypeDatabase,
-		RegisteredUsers:       make(map[string]string),
-		PendingSessions:       make(map[string]string),
-		MemoryCalls:           make(map[string]*models.SipCall),
-		ActiveSessions:        make(map[string]*ua.SessionInfo),
-		Db:                    db,
+		Host:                   utils.GetEnv("SIP_HOST"),
+		Port:                   5060,
+		UserAgentName:          ua.DEFAULT_USER_AGENT,
+		LocalRTPPort:           10000, // 修改为12000避免端口冲突
+		RegisterTimeout:        30 * time.Second,
+		TransactionTimeout:     30 * time.Second,
+		KeepAliveInterval:      time.Duration(utils.GetIntEnv("SIP_KEEPALIVE_INTERVAL_SEC")) * time.Second,
+		MaxForwards:            70,
+		EnableAuthentication:   false,
+		AuthenticationRealm:    ua.DEFAULT_REALM_NAME,
+		EnableTLS:              false,
+		TLSCertFile:            "",
+		TLSKeyFile:             "",
+		LogLevel:               "info",
+		LogFile:                "",
+		RTPBufferSize:          1500, // standard 以太网 MTU Size
+		MaxConcurrentSessions:  int(utils.GetIntEnv("SIP_MAX_CONCURRENT_SESSIONS")),
+		SessionTimeout:         10 * time.Minute,
+		NetworkInterface:       "",
+		EnableICE:              utils.GetBoolEnv("SIP_ENABLE_ICE"),
+		StorageType:            storageType,
+		RejectUnknownSources:   utils.GetBoolEnv("SIP_REJECT_UNKNOWN_SOURCES"),
+		ProvisionalResponse:    int(utils.GetIntEnv("SIP_PROVISIONAL_RESPONSE")),
+		AnswerDelay:            time.Duration(utils.GetIntEnv("SIP_ANSWER_DELAY_MS")) * time.Millisecond,
+		RegisteredUsers:        make(map[string][]ua.Binding),
+		PendingSessions:        make(map[string]string),
+		MemoryCalls:            make(map[string]*models.SipCall),
+		ActiveSessions:         make(map[string]*ua.SessionInfo),
+		Db:                     db,
+		JitterBufferDepth:      int(utils.GetIntEnv("SIP_JITTER_BUFFER_DEPTH")),
+		EchoSuppression:        utils.GetBoolEnv("SIP_ECHO_SUPPRESSION"),
+		FileRetention:          time.Duration(utils.GetIntEnv("SIP_FILE_RETENTION_HOURS")) * time.Hour,
+		FileMaxBytes:           utils.GetIntEnv("SIP_FILE_MAX_MB") << 20,
+		FileSync:               utils.GetBoolEnv("SIP_FILE_SYNC"),
+		SlowStorageThreshold:   time.Duration(utils.GetIntEnv("SIP_STORAGE_SLOW_MS")) * time.Millisecond,
+		HoldMusicFile:          utils.GetEnv("SIP_HOLD_MUSIC_FILE"),
+		HoldTimeout:            time.Duration(utils.GetIntEnv("SIP_HOLD_TIMEOUT_SEC")) * time.Second,
+		SessionExpires:         time.Duration(utils.GetIntEnv("SIP_SESSION_EXPIRES_SEC")) * time.Second,
+		MinSE:                  time.Duration(utils.GetIntEnv("SIP_MIN_SE_SEC")) * time.Second,
+		MessageAutoReply:       utils.GetBoolEnv("SIP_MESSAGE_AUTO_REPLY"),
+		MessageFrom:            utils.GetEnv("SIP_MESSAGE_FROM"),
+		SessionSummary:         utils.GetBoolEnv("SIP_SESSION_SUMMARY"),
+		DNCCheckInbound:        utils.GetBoolEnv("SIP_DNC_CHECK_INBOUND"),
+		LLMTranscript:          utils.GetBoolEnv("SIP_LLM_TRANSCRIPT"),
+		LLMTranscriptRedaction: utils.GetEnv("SIP_LLM_TRANSCRIPT_REDACTION"),
+		MaxCPUPercent:          int(utils.GetIntEnv("SIP_MAX_CPU_PERCENT")),
+		MaxRTPPacketRate:       int(utils.GetIntEnv("SIP_MAX_RTP_PPS")),
+		OverloadRetryAfter:     time.Duration(utils.GetIntEnv("SIP_OVERLOAD_RETRY_AFTER_SEC")) * time.Second,
+		CallQueueSize:          int(utils.GetIntEnv("SIP_CALL_QUEUE_SIZE")),
+		CallQueueTimeout:       time.Duration(utils.GetIntEnv("SIP_CALL_QUEUE_TIMEOUT_SEC")) * time.Second,
+		ProbeFailures:          int(utils.GetIntEnv("SIP_PROBE_FAILURES")),
+		UnbindUnreachable:      utils.GetBoolEnv("SIP_UNBIND_UNREACHABLE"),
+		ICEServers:             iceServers,
+		ICEUsername:            utils.GetEnv("SIP_ICE_USERNAME"),
+		ICECredential:          utils.GetEnv("SIP_ICE_CREDENTIAL"),
+		MaxBodySize:            int(utils.GetIntEnv("SIP_MAX_BODY_BYTES")),
+		SIPTrace:               utils.GetBoolEnv("SIP_TRACE"),
+		TraceDir:               utils.GetEnv("SIP_TRACE_DIR"),
+		PromptDir:              utils.GetEnv("SIP_PROMPT_DIR"),
+		DBProbeInterval:        time.Duration(utils.GetIntEnv("SIP_DB_PROBE_INTERVAL_SEC")) * time.Second,
+		JournalDir:             utils.GetEnv("SIP_JOURNAL_DIR"),
+		DrainTimeout:           time.Duration(utils.GetIntEnv("SIP_DRAIN_TIMEOUT_SEC")) * time.Second,
+		ShutdownMessage:        utils.GetEnv("SIP_SHUTDOWN_MESSAGE"),
+		Redis:                  redisClient,
+		RedisKeyPrefix:         utils.GetEnv("SIP_REDIS_PREFIX"),
+		InstanceID:             utils.GetEnv("SIP_INSTANCE_ID"),
+		TenantCallLimits:       tenantCallLimits,
 	})
 	if err != nil {
 		panic(err)
@@ -159,8 +267,146 @@ func main() {
 		server.GetAIPhoneEngine().SetLLMService(llmService)
 		logger.Info("LLM service attached to AI Phone Engine")
 	}
-	logger.Info("SIP Server Started AT 5060")
-	server.Start()
 
-	select {}
+	// 12. Start HTTP API
+	adminTokens, err := auth.ParseAdminTokens(config.GlobalConfig.Server.AdminTokens)
+	if err != nil {
+		panic("ADMIN_TOKENS: " + err.Error())
+	}
+	if len(adminTokens) == 0 {
+		logger.Warn("ADMIN_TOKENS not set, the HTTP API only accepts local requests")
+	}
+	requireAdmin := auth.RequireAdmin(adminTokens)
+	router := gin.New()
+	router.Use(gin.Recovery(), LingSIP.WithDB(db))
+	api := router.Group("/api", requireAdmin)
+	LingSIP.RegisterCallAPIs(api)
+	sip1.RegisterTraceAPIs(api, server.Tracer())
+	sip1.RegisterConfigAPIs(api, server)
+	sip1.RegisterLogLevelAPIs(api)
+	sip1.RegisterVersionAPIs(api, server)
+	sip1.RegisterRequestStatsAPIs(api, server)
+	sip1.RegisterCallQuotaAPIs(api, server)
+	sip1.RegisterScriptCanaryAPIs(api, server)
+	sip1.RegisterScriptReviewAPIs(api, server)
+	sip1.RegisterScriptAnalyticsAPIs(api, server)
+	sip1.RegisterSipUserAPIs(api, server)
+	sip1.RegisterRegistrationAPIs(api, server)
+	sip1.RegisterTranscriptAPIs(api, server)
+	sip1.RegisterDataSubjectAPIs(api, server)
+	webrtcGateway := server.WebRTC()
+	if numbers := utils.GetEnv("SIP_WEBRTC_NUMBERS"); numbers != "" {
+		webrtcGateway.Numbers = strings.Split(numbers, ",")
+	}
+	if iceServers := utils.GetEnv("SIP_WEBRTC_ICE_SERVERS"); iceServers != "" {
+		webrtcGateway.ICEServers = strings.Split(iceServers, ",")
+	}
+	if origins := utils.GetEnv("SIP_WEBRTC_ALLOWED_ORIGINS"); origins != "" {
+		webrtcGateway.AllowedOrigins = strings.Split(origins, ",")
+	}
+	webrtcGateway.MaxSessions = int(utils.GetIntEnv("SIP_WEBRTC_MAX_SESSIONS"))
+	sip1.RegisterWebRTCAPIs(router.Group("/api"), api, server)
+	sip1.RegisterMessageAPIs(api, server)
+	sip1.RegisterBridgeAPIs(api, server)
+	sip1.RegisterChatAPIs(api, server)
+	sip1.RegisterPromptAPIs(api, server)
+	sip1.RegisterPromptTemplateAPIs(api, server)
+	sip1.RegisterCampaignAPIs(api, server)
+	sip1.RegisterReprocessAPIs(api, server)
+	sip1.RegisterDoNotCallAPIs(api, server)
+	sip1.RegisterAccessRuleAPIs(api, server)
+	sip1.RegisterProviderUsageAPIs(api, server)
+	monitor := server.Monitor()
+	if origins := utils.GetEnv("MONITOR_ALLOWED_ORIGINS"); origins != "" {
+		monitor.AllowedOrigins = strings.Split(origins, ",")
+	}
+	router.GET("/ws/monitor", auth.RequireAdminUpgrade(adminTokens), gin.WrapH(monitor))
+	httpServer := &http.Server{Addr: addr, Handler: router}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("HTTP API server stopped", zap.Error(err))
+		}
+	}()
+	logger.Info("HTTP API Started", zap.String("addr", addr))
+
+	// 13. Start SIP Server
+	go server.Start()
+	logger.Info("SIP Server Started AT 5060", zap.Any("version", server.Version()))
+
+	// 14. Hot reload safe config changes when the env file is modified
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	if interval := time.Duration(utils.GetIntEnv("CONFIG_WATCH_INTERVAL_SEC")) * time.Second; interval > 0 {
+		go config.WatchEnvFile(interval, stopWatch, func() {
+			if _, err := server.ReloadConfig(); err != nil {
+				logger.Warn("Config reload failed", zap.Error(err))
+			}
+		})
+	}
+
+	// Re-resolve secret: references in config and trunk passwords when the backend rotates them
+	if interval := time.Duration(utils.GetIntEnv("SECRETS_REFRESH_INTERVAL_SEC")) * time.Second; interval > 0 {
+		go secrets.Default().Watch(interval, stopWatch, func(rotated []string) {
+			logger.Info("Secrets rotated", zap.Int("count", len(rotated)))
+			if err := server.RefreshSecrets(); err != nil {
+				logger.Warn("Failed to apply rotated secrets", zap.Error(err))
+			}
+		}, func(err error) {
+			logger.Warn("Secrets refresh failed", zap.Error(err))
+		})
+	}
+
+	// 15. Per-component debug logging on SIGUSR1, reset on SIGUSR2
+	debugComponents := logger.Components
+	if components := utils.GetEnv("LOG_DEBUG_COMPONENTS"); components != "" {
+		debugComponents = strings.Split(components, ",")
+	}
+	watchLogLevelSignals(debugComponents)
+
+	// 16. Graceful shutdown: drain calls on SIGINT/SIGTERM, a second signal forces exit
+	quit := make(chan os.Signal, 2)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	logger.Info("Shutdown signal received, draining calls", zap.String("signal", sig.String()))
+
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-quit:
+			logger.Warn("Second shutdown signal received, closing immediately")
+			cancel()
+		case <-shutdownCtx.Done():
+		}
+	}()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("SIP server shutdown incomplete", zap.Error(err))
+	}
+
+	httpCtx, httpCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer httpCancel()
+	if err := httpServer.Shutdown(httpCtx); err != nil {
+		logger.Warn("HTTP API shutdown incomplete", zap.Error(err))
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+	logger.Info("Shutdown complete")
+}
+
+// runDataSubjectCommand prints the export of a number, or deletes its data and prints the deletion certificate
+func runDataSubjectCommand(db *gorm.DB, exportNumber, deleteNumber, actor, reason string) error {
+	var result interface{}
+	var err error
+	if deleteNumber != "" {
+		result, err = sip1.DeleteDataSubject(db, deleteNumber, actor, reason)
+	} else {
+		result, err = sip1.ExportDataSubject(db, exportNumber)
+	}
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
 }
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/constants"
+	"gorm.io/gorm"
+)
+
+// AccessRuleKind 访问控制规则匹配的对象
+type AccessRuleKind string
+
+const (
+	AccessRuleKindIP     AccessRuleKind = "ip"     // 请求来源IP
+	AccessRuleKindNumber AccessRuleKind = "number" // From号码
+)
+
+// AccessRuleAction 访问控制规则动作
+type AccessRuleAction string
+
+const (
+	AccessRuleActionAllow AccessRuleAction = "allow" // 放行，不再匹配后续规则
+	AccessRuleActionDeny  AccessRuleAction = "deny"  // 拒绝请求
+)
+
+// AccessRule REGISTER/INVITE访问控制规则表，按优先级从小到大匹配，第一条命中的规则生效
+type AccessRule struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+
+	Kind        AccessRuleKind   `json:"kind" gorm:"size:16;not null"`          // ip / number
+	Pattern     string           `json:"pattern" gorm:"size:64;not null"`       // IP、CIDR或通配符，如 10.0.0.0/8、192.168.1.*、+8610*
+	Action      AccessRuleAction `json:"action" gorm:"size:16;not null"`        // allow / deny
+	Methods     string           `json:"methods,omitempty" gorm:"size:32"`      // 逗号分隔的REGISTER、INVITE，为空时都适用
+	Priority    int              `json:"priority" gorm:"default:0;index"`       // 越小越先匹配
+	Log         bool             `json:"log"`                                   // 命中时记录日志
+	Description string           `json:"description,omitempty" gorm:"size:256"` // 描述
+	Enabled     bool             `json:"enabled" gorm:"default:true"`           // 是否启用
+}
+
+// TableName 指定表名
+func (AccessRule) TableName() string {
+	return constants.TABLE_ACCESS_RULES
+}
+
+// CreateAccessRule 创建访问控制规则
+func CreateAccessRule(db *gorm.DB, rule *AccessRule) error {
+	return db.Create(rule).Error
+}
+
+// GetAccessRule 获取访问控制规则
+func GetAccessRule(db *gorm.DB, id uint) (*AccessRule, error) {
+	var rule AccessRule
+	if err := db.First(&rule, id).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// UpdateAccessRule 保存访问控制规则的全部字段
+func UpdateAccessRule(db *gorm.DB, rule *AccessRule) error {
+	return db.Save(rule).Error
+}
+
+// DeleteAccessRule 删除访问控制规则，返回是否存在
+func DeleteAccessRule(db *gorm.DB, id uint) (bool, error) {
+	result := db.Delete(&AccessRule{}, id)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListAccessRules 按匹配顺序列出全部访问控制规则
+func ListAccessRules(db *gorm.DB) ([]AccessRule, error) {
+	var rules []AccessRule
+	err := db.Order("priority ASC, id ASC").Find(&rules).Error
+	return rules, err
+}
+
+// GetEnabledAccessRules 按匹配顺序获取所有启用的访问控制规则
+func GetEnabledAccessRules(db *gorm.DB) ([]AccessRule, error) {
+	var rules []AccessRule
+	err := db.Where("enabled = ?", true).Order("priority ASC, id ASC").Find(&rules).Error
+	return rules, err
+}
@@ -17,6 +17,11 @@ const (
 	ScriptStatusActive   ScriptStatus = "active"   // 激活
 	ScriptStatusInactive ScriptStatus = "inactive" // 停用
 	ScriptStatusArchived ScriptStatus = "archived" // 归档
+
+	// 审批流程：草稿提交后待审批，另一人批准后才能上线
+	ScriptStatusPendingReview ScriptStatus = "pending_review" // 待审批
+	ScriptStatusApproved      ScriptStatus = "approved"       // 已批准，未上线
+	ScriptStatusRejected      ScriptStatus = "rejected"       // 已驳回
 )
 
 // StepType 步骤类型
@@ -32,8 +37,37 @@ const (
 	StepTypeWait      StepType = "wait"      // 等待
 	StepTypeRecord    StepType = "record"    // 录音
 	StepTypeDTMF      StepType = "dtmf"      // DTMF按键检测
+	StepTypeConsent   StepType = "consent"   // 录音告知
+	StepTypeTranslate StepType = "translate" // 翻译对话
+)
+
+// 内置录音告知模板
+const (
+	ConsentStepID      = "__consent"
+	DefaultConsentText = "本次通话将被录音，如不同意录音请按1或直接说不同意，通话不受影响。"
+	DefaultOptOutText  = "好的，本次通话不会录音。"
+	DefaultOptOutDigit = "1"
 )
 
+// ConsentStepTemplate 录音告知步骤模板，可作为脚本步骤的起点修改，结束后进入nextStep
+func ConsentStepTemplate(nextStep string) AIPhoneScriptStep {
+	return AIPhoneScriptStep{
+		StepID:  ConsentStepID,
+		Name:    "录音告知",
+		Type:    StepTypeConsent,
+		Enabled: true,
+		Timeout: 30000,
+		Data: StepData{
+			AudioText:      DefaultConsentText,
+			OptOutDigits:   DefaultOptOutDigit,
+			OptOutKeywords: []string{"不同意", "不要录音", "拒绝录音", "别录音"},
+			OptOutTimeout:  5000,
+			OptOutText:     DefaultOptOutText,
+			NextStep:       nextStep,
+		},
+	}
+}
+
 // StepData 步骤数据结构
 type StepData struct {
 	// AI对话相关
@@ -60,11 +94,16 @@ type StepData struct {
 
 	// 转接相关
 	TransferTo   string `json:"transferTo,omitempty"`   // 转接目标
-	TransferType string `json:"transferType,omitempty"` // 转接类型：human, ivr, external
+	TransferType string `json:"transferType,omitempty"` // 转接类型：human, ivr, external, attended（咨询转接）
+	TransferMode string `json:"transferMode,omitempty"` // 转接方式：refer（默认，REFER交给对端转接）, bridge（我方呼出后桥接两条腿）
 
 	// 等待相关
 	WaitTime int `json:"waitTime,omitempty"` // 等待时长(ms)
 
+	// 等待音乐相关（等待、转接期间循环播放）
+	HoldAudioFile string `json:"holdAudioFile,omitempty"` // 等待音乐WAV文件
+	HoldText      string `json:"holdText,omitempty"`      // 等待提示语（TTS）
+
 	// 录音相关
 	RecordTime   int    `json:"recordTime,omitempty"`   // 录音时长(ms)
 	RecordPrompt string `json:"recordPrompt,omitempty"` // 录音提示语
@@ -75,6 +114,20 @@ type StepData struct {
 	DTMFTerminator string            `json:"dtmfTerminator,omitempty"` // 结束按键（如#）
 	DTMFOptions    map[string]string `json:"dtmfOptions,omitempty"`    // 按键选项映射 {"1": "next_step_id"}
 	DTMFPrompt     string            `json:"dtmfPrompt,omitempty"`     // DTMF提示语
+	DNCDigit       string            `json:"dncDigit,omitempty"`       // 退订按键，如"9"，按下后对端号码加入免打扰名单
+	DNCConfirmText string            `json:"dncConfirmText,omitempty"` // 退订后的确认语
+	DNCNext        string            `json:"dncNext,omitempty"`        // 退订后的下一步，为空时结束脚本
+
+	// 录音告知相关，告知语使用AudioFile/AudioText
+	OptOutDigits   string   `json:"optOutDigits,omitempty"`   // 拒绝录音的按键，如"1"
+	OptOutKeywords []string `json:"optOutKeywords,omitempty"` // 拒绝录音的语音关键词
+	OptOutTimeout  int      `json:"optOutTimeout,omitempty"`  // 告知后等待拒绝的时长(ms)
+	OptOutText     string   `json:"optOutText,omitempty"`     // 拒绝后的确认语
+
+	// 翻译对话相关，其余配置同AI对话；开场白和提示词使用BotLanguage
+	CallerLanguage string `json:"callerLanguage,omitempty"` // 来电者的语言，如English、粤语
+	BotLanguage    string `json:"botLanguage,omitempty"`    // 提示词和AI回复的语言，默认中文
+	CallerDialect  string `json:"callerDialect,omitempty"`  // 识别来电者语音的方言回退顺序，为空时使用脚本的dialect
 
 	// 通用
 	NextStep  string                 `json:"nextStep,omitempty"`  // 下一步骤ID
@@ -99,6 +152,97 @@ func (sd *StepData) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, sd)
 }
 
+// GreetingConfig 开场问候规则。节假日问候优先，其次按时段，都不命中时用Default；
+// 回头客在问候之后追加RepeatCaller，如"下午好，欢迎再次来电"
+type GreetingConfig struct {
+	TimeSlots        []GreetingTimeSlot `json:"timeSlots,omitempty"`        // 按时段的问候语
+	Default          string             `json:"default,omitempty"`          // 不在任何时段时的问候语
+	Holidays         []GreetingHoliday  `json:"holidays,omitempty"`         // 节假日问候，命中时替换时段问候
+	RepeatCaller     string             `json:"repeatCaller,omitempty"`     // 回头客追加的问候语
+	RepeatWithinDays int                `json:"repeatWithinDays,omitempty"` // 多少天内来电过算回头客，0不限
+	Timezone         string             `json:"timezone,omitempty"`         // 时段和日期的时区，如Asia/Shanghai，为空用服务器时区
+	SpeakerID        string             `json:"speakerId,omitempty"`        // TTS音色ID，为空用脚本默认音色
+}
+
+// GreetingTimeSlot 时段问候，End小于Start时跨过午夜
+type GreetingTimeSlot struct {
+	Start string `json:"start"` // 开始时间 HH:MM，含
+	End   string `json:"end"`   // 结束时间 HH:MM，不含
+	Text  string `json:"text"`  // 问候语，如"早上好"
+}
+
+// GreetingHoliday 节假日问候
+type GreetingHoliday struct {
+	Name    string `json:"name,omitempty"`
+	Date    string `json:"date"`              // 开始日期 YYYY-MM-DD，或MM-DD表示每年
+	EndDate string `json:"endDate,omitempty"` // 结束日期（含），格式同Date，为空只有当天
+	Text    string `json:"text"`              // 问候语，如"国庆节快乐"
+}
+
+// Enabled 是否配置了问候语
+func (g GreetingConfig) Enabled() bool {
+	return g.Default != "" || len(g.TimeSlots) > 0 || len(g.Holidays) > 0 || g.RepeatCaller != ""
+}
+
+// Value 实现 driver.Valuer 接口
+func (g GreetingConfig) Value() (driver.Value, error) {
+	return json.Marshal(g)
+}
+
+// Scan 实现 sql.Scanner 接口
+func (g *GreetingConfig) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok || len(bytes) == 0 {
+		return nil
+	}
+	return json.Unmarshal(bytes, g)
+}
+
+// ASRModelSelection 按通话协商的采样率选择ASR模型，如方言、行业模型；为空的一项使用全局配置
+type ASRModelSelection struct {
+	Narrowband string `json:"narrowband,omitempty"` // 8kHz编码（PCMU、PCMA、G.729）使用的模型，如8k_zh
+	Wideband   string `json:"wideband,omitempty"`   // 16kHz及以上编码（G.722、Opus）使用的模型，如16k_zh、16k_zh_dialect
+}
+
+// Model 返回采样率对应的模型，未配置时为空
+func (s ASRModelSelection) Model(sampleRate int) string {
+	if sampleRate >= 16000 {
+		return s.Wideband
+	}
+	return s.Narrowband
+}
+
+// Or 未配置的一项取fallback的配置
+func (s ASRModelSelection) Or(fallback ASRModelSelection) ASRModelSelection {
+	if s.Narrowband == "" {
+		s.Narrowband = fallback.Narrowband
+	}
+	if s.Wideband == "" {
+		s.Wideband = fallback.Wideband
+	}
+	return s
+}
+
+// Value 实现 driver.Valuer 接口
+func (s ASRModelSelection) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+// Scan 实现 sql.Scanner 接口
+func (s *ASRModelSelection) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok || len(bytes) == 0 {
+		return nil
+	}
+	return json.Unmarshal(bytes, s)
+}
+
 // AIPhoneScript AI电话脚本表
 type AIPhoneScript struct {
 	ID        uint       `json:"id" gorm:"primaryKey"`
@@ -128,6 +272,35 @@ type AIPhoneScript struct {
 	MaxSteps      int    `json:"maxSteps" gorm:"default:50"`             // 最大步骤数
 	TimeoutAction string `json:"timeoutAction,omitempty" gorm:"size:32"` // 超时动作
 
+	// 并发限制
+	Tenant             string `json:"tenant,omitempty" gorm:"size:64;index"` // 所属租户，按租户限制并发见SIP_TENANT_CALL_LIMITS
+	MaxConcurrentCalls int    `json:"maxConcurrentCalls" gorm:"default:0"`   // 脚本最大并发通话数，0不限制
+
+	// 灰度发布：观察期内新版本只接收部分呼叫，其余仍交给旧版本
+	PreviousScriptID     uint       `json:"previousScriptId,omitempty"`            // 灰度中的旧版本脚本ID，0表示未在灰度
+	CanaryPercent        int        `json:"canaryPercent" gorm:"default:0"`        // 新版本接收的呼叫百分比
+	CanaryUntil          *time.Time `json:"canaryUntil,omitempty"`                 // 观察期结束时间，之后全部呼叫使用新版本
+	CanaryMaxFailureRate float64    `json:"canaryMaxFailureRate" gorm:"default:0"` // 观察期内失败率超过该值自动回滚，0使用默认值
+
+	// 审批：编辑提交后需另一人查看与上一版本的差异并批准
+	BaseScriptID  uint       `json:"baseScriptId,omitempty"`               // 对比差异的上一版本脚本ID
+	SubmittedBy   string     `json:"submittedBy,omitempty" gorm:"size:64"` // 提交人
+	SubmittedAt   *time.Time `json:"submittedAt,omitempty"`                // 提交时间
+	ApprovedBy    string     `json:"approvedBy,omitempty" gorm:"size:64"`  // 批准人，不能是提交人
+	ApprovedAt    *time.Time `json:"approvedAt,omitempty"`                 // 批准时间，为空时不能上线
+	ReviewComment string     `json:"reviewComment,omitempty" gorm:"type:text"`
+
+	// 录音告知：开启后脚本开始前先执行录音告知步骤，对方拒绝时只关闭录音，通话继续
+	RequireConsent bool `json:"requireConsent" gorm:"default:false"`
+
+	// 开场问候：第一步之前按节假日、时段和来电历史播放问候语
+	Greeting GreetingConfig `json:"greeting" gorm:"type:json"`
+
+	// ASR模型：按协商的采样率选择，优先于中继和全局配置
+	ASRModels ASRModelSelection `json:"asrModels" gorm:"type:json"`
+	// 识别方言：逗号分隔的回退顺序，如sichuanese,dialect，服务商都不支持时使用普通话；为空使用普通话
+	Dialect string `json:"dialect,omitempty" gorm:"size:64"`
+
 	// 统计信息
 	ExecuteCount int        `json:"executeCount" gorm:"default:0"` // 执行次数
 	SuccessCount int        `json:"successCount" gorm:"default:0"` // 成功次数
@@ -197,6 +370,9 @@ type ScriptPhoneMapping struct {
 	Enabled     bool   `json:"enabled" gorm:"default:true"`           // 是否启用
 	Description string `json:"description,omitempty" gorm:"size:256"` // 描述
 
+	// 并发限制
+	MaxConcurrentCalls int `json:"maxConcurrentCalls" gorm:"default:0"` // 该号码最大并发通话数，0不限制
+
 	// 时间限制
 	StartTime string `json:"startTime,omitempty" gorm:"size:8"` // 开始时间 HH:MM:SS
 	EndTime   string `json:"endTime,omitempty" gorm:"size:8"`   // 结束时间 HH:MM:SS
@@ -215,6 +391,11 @@ func (ScriptPhoneMapping) TableName() string {
 	return constants.TABLE_SCRIPT_PHONE_MAPPINGS
 }
 
+// CallWindow 号码映射的时间限制
+func (m *ScriptPhoneMapping) CallWindow() CallWindow {
+	return CallWindow{StartTime: m.StartTime, EndTime: m.EndTime, WeekDays: m.WeekDays}
+}
+
 // CRUD 操作函数
 
 // CreateAIPhoneScript 创建AI电话脚本
@@ -252,6 +433,16 @@ func GetAIPhoneScriptByPhone(db *gorm.DB, phoneNumber string) (*AIPhoneScript, e
 	return &mapping.Script, nil
 }
 
+// GetScriptPhoneMapping 根据电话号码获取启用的号码映射及其脚本
+func GetScriptPhoneMapping(db *gorm.DB, phoneNumber string) (*ScriptPhoneMapping, error) {
+	var mapping ScriptPhoneMapping
+	err := db.Preload("Script").Where("phone_number = ? AND enabled = ?", phoneNumber, true).First(&mapping).Error
+	if err != nil {
+		return nil, err
+	}
+	return &mapping, nil
+}
+
 // GetActiveAIPhoneScripts 获取所有激活的脚本
 func GetActiveAIPhoneScripts(db *gorm.DB) ([]AIPhoneScript, error) {
 	var scripts []AIPhoneScript
@@ -338,6 +529,21 @@ func (s *AIPhoneScript) GetStartStep() *AIPhoneScriptStep {
 	return s.GetStepByID(s.StartStepID)
 }
 
+// ConsentStep 脚本开始前执行的录音告知步骤：优先使用脚本中的consent步骤，没有时使用模板，结束后进入起始步骤
+func (s *AIPhoneScript) ConsentStep() *AIPhoneScriptStep {
+	for _, step := range s.Steps {
+		if step.Type == StepTypeConsent && step.Enabled {
+			if step.StepID != s.StartStepID {
+				step.Data.NextStep = s.StartStepID
+			}
+			return &step
+		}
+	}
+	step := ConsentStepTemplate(s.StartStepID)
+	step.ScriptID = s.ID
+	return &step
+}
+
 // IncrementExecuteCount 增加执行次数
 func (s *AIPhoneScript) IncrementExecuteCount(db *gorm.DB) error {
 	now := time.Now()
@@ -351,3 +557,94 @@ func (s *AIPhoneScript) IncrementExecuteCount(db *gorm.DB) error {
 func (s *AIPhoneScript) IncrementSuccessCount(db *gorm.DB) error {
 	return db.Model(s).Update("success_count", gorm.Expr("success_count + 1")).Error
 }
+
+// IsApproved 脚本版本是否已通过审批，可以上线
+func (s *AIPhoneScript) IsApproved() bool {
+	return s.ApprovedAt != nil
+}
+
+// ActivateAIPhoneScript 上线脚本：previousID不为0时把旧版本的号码映射切换到新版本并停用旧版本
+func ActivateAIPhoneScript(db *gorm.DB, scriptID, previousID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if previousID != 0 {
+			if err := tx.Model(&ScriptPhoneMapping{}).Where("script_id = ?", previousID).Update("script_id", scriptID).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&AIPhoneScript{}).Where("id = ?", previousID).Update("status", ScriptStatusInactive).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&AIPhoneScript{}).Where("id = ?", scriptID).Update("status", ScriptStatusActive).Error
+	})
+}
+
+// InCanary 脚本是否处于灰度观察期
+func (s *AIPhoneScript) InCanary(now time.Time) bool {
+	return s.PreviousScriptID != 0 && s.CanaryUntil != nil && now.Before(*s.CanaryUntil)
+}
+
+// StartScriptCanary 开始灰度发布：把旧版本的号码映射切换到新版本并激活，观察期内由
+// CanaryPercent决定新版本接收的呼叫比例
+func StartScriptCanary(db *gorm.DB, scriptID, previousID uint, percent int, until time.Time, maxFailureRate float64) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ScriptPhoneMapping{}).Where("script_id = ?", previousID).Update("script_id", scriptID).Error; err != nil {
+			return err
+		}
+		return tx.Model(&AIPhoneScript{}).Where("id = ?", scriptID).Updates(map[string]interface{}{
+			"status":                  ScriptStatusActive,
+			"previous_script_id":      previousID,
+			"canary_percent":          percent,
+			"canary_until":            until,
+			"canary_max_failure_rate": maxFailureRate,
+		}).Error
+	})
+}
+
+// RollbackScriptCanary 回滚灰度中的脚本：号码映射切回旧版本，新版本停用；脚本已不在灰度中时不做任何事
+func RollbackScriptCanary(db *gorm.DB, scriptID uint) (bool, error) {
+	rolledBack := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var script AIPhoneScript
+		if err := tx.First(&script, scriptID).Error; err != nil {
+			return err
+		}
+		if script.PreviousScriptID == 0 {
+			return nil
+		}
+		if err := tx.Model(&ScriptPhoneMapping{}).Where("script_id = ?", scriptID).Update("script_id", script.PreviousScriptID).Error; err != nil {
+			return err
+		}
+		rolledBack = true
+		return tx.Model(&script).Updates(map[string]interface{}{
+			"status":             ScriptStatusInactive,
+			"previous_script_id": 0,
+			"canary_percent":     0,
+			"canary_until":       nil,
+		}).Error
+	})
+	return rolledBack, err
+}
+
+// PromoteScriptCanary 结束灰度，全部呼叫使用新版本，旧版本停用；脚本已不在灰度中时不做任何事
+func PromoteScriptCanary(db *gorm.DB, scriptID uint) (bool, error) {
+	promoted := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var script AIPhoneScript
+		if err := tx.First(&script, scriptID).Error; err != nil {
+			return err
+		}
+		if script.PreviousScriptID == 0 {
+			return nil
+		}
+		if err := tx.Model(&AIPhoneScript{}).Where("id = ?", script.PreviousScriptID).Update("status", ScriptStatusInactive).Error; err != nil {
+			return err
+		}
+		promoted = true
+		return tx.Model(&script).Updates(map[string]interface{}{
+			"previous_script_id": 0,
+			"canary_percent":     0,
+			"canary_until":       nil,
+		}).Error
+	})
+	return promoted, err
+}
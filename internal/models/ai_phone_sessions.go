@@ -15,6 +15,7 @@ type SessionStatus string
 const (
 	SessionStatusStarting    SessionStatus = "starting"    // 启动中
 	SessionStatusRunning     SessionStatus = "running"     // 运行中
+	SessionStatusHeld        SessionStatus = "held"        // 被对端保持，脚本暂停
 	SessionStatusCompleted   SessionStatus = "completed"   // 已完成
 	SessionStatusFailed      SessionStatus = "failed"      // 失败
 	SessionStatusTimeout     SessionStatus = "timeout"     // 超时
@@ -22,6 +23,22 @@ const (
 	SessionStatusTransferred SessionStatus = "transferred" // 已转接
 )
 
+// SessionChannel 会话渠道，同一脚本的各渠道会话共用统计
+type SessionChannel string
+
+const (
+	SessionChannelVoice SessionChannel = "voice" // 电话、网页通话等语音渠道
+	SessionChannelText  SessionChannel = "text"  // 网页客服、微信等文字渠道，不经过识别和合成
+)
+
+// ConsentDecision 录音告知结果
+type ConsentDecision string
+
+const (
+	ConsentAccepted ConsentDecision = "accepted"  // 未拒绝，正常录音
+	ConsentOptedOut ConsentDecision = "opted_out" // 拒绝录音，已关闭录音
+)
+
 // StepExecutionStatus 步骤执行状态
 type StepExecutionStatus string
 
@@ -101,6 +118,34 @@ func (ch *ConversationHistory) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, ch)
 }
 
+// ActionItems 通话摘要提取出的待办事项
+type ActionItems []string
+
+// Value 实现 driver.Valuer 接口
+func (ai ActionItems) Value() (driver.Value, error) {
+	if len(ai) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(ai)
+}
+
+// Scan 实现 sql.Scanner 接口
+func (ai *ActionItems) Scan(value interface{}) error {
+	if value == nil {
+		*ai = make(ActionItems, 0)
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	if len(bytes) == 0 {
+		*ai = make(ActionItems, 0)
+		return nil
+	}
+	return json.Unmarshal(bytes, ai)
+}
+
 // AIPhoneSession AI电话会话表
 type AIPhoneSession struct {
 	ID        uint       `json:"id" gorm:"primaryKey"`
@@ -123,6 +168,9 @@ type AIPhoneSession struct {
 	CalleeNumber  string `json:"calleeNumber,omitempty" gorm:"size:20;index"` // 被叫号码
 	ClientRTPAddr string `json:"clientRtpAddr,omitempty" gorm:"size:128"`     // 客户端RTP地址
 
+	// 会话渠道，为空的旧记录按语音渠道
+	Channel SessionChannel `json:"channel,omitempty" gorm:"size:16;index"`
+
 	// 执行状态
 	CurrentStepID string     `json:"currentStepId,omitempty" gorm:"size:64"` // 当前步骤ID
 	StartTime     time.Time  `json:"startTime"`                              // 开始时间
@@ -142,12 +190,21 @@ type AIPhoneSession struct {
 	Result       string `json:"result,omitempty" gorm:"type:text"`       // 执行结果
 	ErrorMessage string `json:"errorMessage,omitempty" gorm:"type:text"` // 错误信息
 
+	// 会话结束后由LLM生成的摘要和待办事项，未开启或生成失败时为空
+	Summary     string      `json:"summary,omitempty" gorm:"type:text"`
+	ActionItems ActionItems `json:"actionItems,omitempty" gorm:"type:json"`
+
 	// 音频信息
 	RecordingURL  string `json:"recordingUrl,omitempty" gorm:"size:500"` // 录音文件URL
 	AudioDuration int    `json:"audioDuration" gorm:"default:0"`         // 音频时长（秒）
 
+	// 录音告知结果，未告知时为空
+	RecordingConsent ConsentDecision `json:"recordingConsent,omitempty" gorm:"size:20;index"`
+	ConsentAt        *time.Time      `json:"consentAt,omitempty"`
+
 	// 质量评估
 	QualityScore     float32 `json:"qualityScore" gorm:"default:0"`     // 质量评分（0-100）
+	EstimatedCost    float64 `json:"estimatedCost" gorm:"default:0"`    // 预估费用（中继+ASR/TTS/LLM）
 	UserSatisfaction int     `json:"userSatisfaction" gorm:"default:0"` // 用户满意度（1-5）
 
 	// 关联关系
@@ -260,15 +317,39 @@ func GetAIPhoneSessionsByScriptID(db *gorm.DB, scriptID uint, limit int) ([]AIPh
 // GetRunningAIPhoneSessions 获取运行中的会话
 func GetRunningAIPhoneSessions(db *gorm.DB) ([]AIPhoneSession, error) {
 	var sessions []AIPhoneSession
-	err := db.Where("status IN ?", []SessionStatus{SessionStatusStarting, SessionStatusRunning}).Find(&sessions).Error
+	err := db.Where("status IN ?", []SessionStatus{SessionStatusStarting, SessionStatusRunning, SessionStatusHeld}).Find(&sessions).Error
 	return sessions, err
 }
 
+// HasCalledBefore 主叫号码此前是否呼叫过该被叫号码，since不为零时只看此后的会话，不含excludeID
+func HasCalledBefore(db *gorm.DB, callerNumber, calleeNumber string, since time.Time, excludeID uint) (bool, error) {
+	query := db.Model(&AIPhoneSession{}).Where("caller_number = ? AND callee_number = ? AND id != ?", callerNumber, calleeNumber, excludeID)
+	if !since.IsZero() {
+		query = query.Where("start_time >= ?", since)
+	}
+	var count int64
+	err := query.Count(&count).Error
+	return count > 0, err
+}
+
 // UpdateAIPhoneSession 更新会话
 func UpdateAIPhoneSession(db *gorm.DB, session *AIPhoneSession) error {
 	return db.Save(session).Error
 }
 
+// UpdateAIPhoneSessionSummary 保存会话摘要和待办事项
+func UpdateAIPhoneSessionSummary(db *gorm.DB, id uint, summary string, actionItems ActionItems) error {
+	return db.Model(&AIPhoneSession{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"summary":      summary,
+		"action_items": actionItems,
+	}).Error
+}
+
+// UpdateAIPhoneSessionQualityScore 保存会话的质量评分
+func UpdateAIPhoneSessionQualityScore(db *gorm.DB, id uint, score float32) error {
+	return db.Model(&AIPhoneSession{}).Where("id = ?", id).Update("quality_score", score).Error
+}
+
 // DeleteAIPhoneSession 删除会话（软删除）
 func DeleteAIPhoneSession(db *gorm.DB, id uint) error {
 	return db.Delete(&AIPhoneSession{}, id).Error
@@ -300,7 +381,7 @@ func DeleteStepExecution(db *gorm.DB, id uint) error {
 
 // IsRunning 检查会话是否运行中
 func (s *AIPhoneSession) IsRunning() bool {
-	return s.Status == SessionStatusRunning || s.Status == SessionStatusStarting
+	return s.Status == SessionStatusRunning || s.Status == SessionStatusStarting || s.Status == SessionStatusHeld
 }
 
 // IsCompleted 检查会话是否已完成
@@ -393,3 +474,110 @@ func (se *StepExecution) MarkFailed(db *gorm.DB, errorMessage string) error {
 	se.Duration = int(now.Sub(se.StartTime).Milliseconds())
 	return db.Save(se).Error
 }
+
+// StepDropOff 脚本步骤的进入和流失统计
+type StepDropOff struct {
+	StepID   string   `json:"stepId"`
+	StepName string   `json:"stepName"`
+	StepType StepType `json:"stepType"`
+	Entered  int      `json:"entered"`  // 进入过该步骤的会话数
+	HungUp   int      `json:"hungUp"`   // 在该步骤被挂断、超时的会话数
+	Failed   int      `json:"failed"`   // 在该步骤执行失败的会话数
+	DropRate float64  `json:"dropRate"` // (HungUp+Failed)/Entered
+}
+
+// DropOffFilter 流失统计的会话范围，零值字段不过滤
+type DropOffFilter struct {
+	Since   time.Time
+	Until   time.Time
+	Channel SessionChannel
+}
+
+// GetScriptStepDropOff 统计脚本每个步骤进入和流失的会话数：会话最后执行的步骤即结束的步骤，
+// 结束时为取消、超时算挂断，失败算失败；挂断步骤本身是正常结束，不计流失。按脚本步骤顺序返回
+func GetScriptStepDropOff(db *gorm.DB, scriptID uint, filter DropOffFilter) ([]StepDropOff, error) {
+	query := db.Table(constants.TABLE_STEP_EXECUTIONS+" AS e").
+		Select("e.session_id, e.step_id, e.step_name, e.step_type, s.status").
+		Joins("JOIN "+constants.TABLE_AI_PHONE_SESSIONS+" AS s ON s.id = e.session_id").
+		Where("s.script_id = ? AND e.deleted_at IS NULL AND s.deleted_at IS NULL", scriptID)
+	if !filter.Since.IsZero() {
+		query = query.Where("s.start_time >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("s.start_time < ?", filter.Until)
+	}
+	switch filter.Channel {
+	case "":
+	case SessionChannelVoice:
+		query = query.Where("(s.channel = ? OR s.channel = '' OR s.channel IS NULL)", filter.Channel)
+	default:
+		query = query.Where("s.channel = ?", filter.Channel)
+	}
+
+	var rows []struct {
+		SessionID uint
+		StepID    string
+		StepName  string
+		StepType  StepType
+		Status    SessionStatus
+	}
+	if err := query.Order("e.session_id, e.id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]*StepDropOff)
+	var seen []string
+	type sessionStep struct {
+		sessionID uint
+		stepID    string
+	}
+	entered := make(map[sessionStep]bool)
+	for i, row := range rows {
+		stat, exists := stats[row.StepID]
+		if !exists {
+			stat = &StepDropOff{StepID: row.StepID, StepName: row.StepName, StepType: row.StepType}
+			stats[row.StepID] = stat
+			seen = append(seen, row.StepID)
+		}
+		if key := (sessionStep{row.SessionID, row.StepID}); !entered[key] {
+			entered[key] = true
+			stat.Entered++
+		}
+		// 会话的最后一条执行记录
+		if i+1 < len(rows) && rows[i+1].SessionID == row.SessionID || row.StepType == StepTypeHangup {
+			continue
+		}
+		switch row.Status {
+		case SessionStatusCancelled, SessionStatusTimeout:
+			stat.HungUp++
+		case SessionStatusFailed:
+			stat.Failed++
+		}
+	}
+
+	steps, err := GetScriptStepsByScriptID(db, scriptID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]StepDropOff, 0, len(steps)+len(seen))
+	for _, step := range steps {
+		stat, exists := stats[step.StepID]
+		if !exists {
+			stat = &StepDropOff{StepID: step.StepID, StepName: step.Name, StepType: step.Type}
+		}
+		delete(stats, step.StepID)
+		result = append(result, *stat)
+	}
+	// 已从脚本删除的步骤排在最后
+	for _, stepID := range seen {
+		if stat, exists := stats[stepID]; exists {
+			result = append(result, *stat)
+		}
+	}
+	for i := range result {
+		if result[i].Entered > 0 {
+			result[i].DropRate = float64(result[i].HungUp+result[i].Failed) / float64(result[i].Entered)
+		}
+	}
+	return result, nil
+}
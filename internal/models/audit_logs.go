@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/constants"
+	"gorm.io/gorm"
+)
+
+// AuditLog 审计日志表，记录谁在什么时候对什么对象做了什么操作
+type AuditLog struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt" gorm:"autoCreateTime;index"`
+
+	Actor      string `json:"actor" gorm:"size:64;index"`                       // 操作人，系统自动操作为system
+	Action     string `json:"action" gorm:"size:64;not null;index"`             // 操作，如 script.approved
+	TargetType string `json:"targetType" gorm:"size:32;index:idx_audit_target"` // 对象类型，如 script
+	TargetID   uint   `json:"targetId" gorm:"index:idx_audit_target"`           // 对象ID
+	Detail     string `json:"detail,omitempty" gorm:"type:text"`                // 详情（JSON）
+}
+
+// TableName 指定表名
+func (AuditLog) TableName() string {
+	return constants.TABLE_AUDIT_LOGS
+}
+
+// CreateAuditLog 写入审计日志，detail序列化为JSON
+func CreateAuditLog(db *gorm.DB, actor, action, targetType string, targetID uint, detail interface{}) error {
+	entry := &AuditLog{Actor: actor, Action: action, TargetType: targetType, TargetID: targetID}
+	if detail != nil {
+		data, err := json.Marshal(detail)
+		if err != nil {
+			return err
+		}
+		entry.Detail = string(data)
+	}
+	return db.Create(entry).Error
+}
+
+// GetAuditLogs 按时间倒序获取对象的审计日志
+func GetAuditLogs(db *gorm.DB, targetType string, targetID uint, limit int) ([]AuditLog, error) {
+	var logs []AuditLog
+	err := db.Where("target_type = ? AND target_id = ?", targetType, targetID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&logs).Error
+	return logs, err
+}
@@ -0,0 +1,312 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/constants"
+	"gorm.io/gorm"
+)
+
+// CampaignStatus 外呼任务状态
+type CampaignStatus string
+
+const (
+	CampaignStatusDraft     CampaignStatus = "draft"     // 已创建，尚未开始
+	CampaignStatusRunning   CampaignStatus = "running"   // 拨号中
+	CampaignStatusPaused    CampaignStatus = "paused"    // 已暂停，进行中的通话不受影响
+	CampaignStatusCompleted CampaignStatus = "completed" // 联系人均已处理或超过结束时间
+)
+
+// CallWindow 允许拨打的时段，按服务器本地时间；结束早于开始时跨过午夜，都为空时不限制
+type CallWindow struct {
+	StartTime string `json:"startTime,omitempty" gorm:"size:8"` // 开始时间 HH:MM[:SS]
+	EndTime   string `json:"endTime,omitempty" gorm:"size:8"`   // 结束时间 HH:MM[:SS]
+	WeekDays  string `json:"weekDays,omitempty" gorm:"size:16"` // 允许的星期 1,2,3,4,5，7为周日，为空时每天
+}
+
+// IsZero 是否未设置时段
+func (w CallWindow) IsZero() bool {
+	return w.StartTime == "" && w.EndTime == "" && w.WeekDays == ""
+}
+
+// CampaignRetryRule 一种通话结果的重试规则
+type CampaignRetryRule struct {
+	MaxAttempts int     `json:"maxAttempts"` // 该结果最多重试次数，0不重试
+	Delay       int     `json:"delay"`       // 首次重试间隔（秒）
+	Backoff     float64 `json:"backoff"`     // 每次重试间隔的倍数
+}
+
+// CampaignRetryRules 按通话结果的重试规则，未配置的结果使用默认规则
+type CampaignRetryRules map[SipCallDisposition]CampaignRetryRule
+
+// Value 实现 driver.Valuer 接口
+func (r CampaignRetryRules) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return json.Marshal(r)
+}
+
+// Scan 实现 sql.Scanner 接口
+func (r *CampaignRetryRules) Scan(value interface{}) error {
+	return scanJSON(value, r)
+}
+
+// DispositionCounts 各通话结果出现的次数
+type DispositionCounts map[SipCallDisposition]int
+
+// Value 实现 driver.Valuer 接口
+func (c DispositionCounts) Value() (driver.Value, error) {
+	if c == nil {
+		return nil, nil
+	}
+	return json.Marshal(c)
+}
+
+// Scan 实现 sql.Scanner 接口
+func (c *DispositionCounts) Scan(value interface{}) error {
+	return scanJSON(value, c)
+}
+
+func scanJSON(value interface{}, target interface{}) error {
+	var bytes []byte
+	switch data := value.(type) {
+	case []byte:
+		bytes = data
+	case string:
+		bytes = []byte(data)
+	}
+	if len(bytes) == 0 {
+		return nil
+	}
+	return json.Unmarshal(bytes, target)
+}
+
+// Campaign 外呼任务表：按节奏通过中继拨打联系人列表，接通后执行指定脚本
+type Campaign struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Name        string `json:"name" gorm:"size:128;not null"`
+	Description string `json:"description,omitempty" gorm:"size:500"`
+	ScriptID    uint   `json:"scriptId" gorm:"not null;index"`    // 接通后执行的脚本
+	TrunkID     uint   `json:"trunkId"`                           // 外呼中继，0为默认中继
+	CallerID    string `json:"callerId,omitempty" gorm:"size:64"` // 主叫号码，为空时使用中继的主叫号码
+	GroupID     uint   `json:"groupId"`                           // 按该组织的拨号规则检查目的号码，0只检查全局规则
+
+	Status      CampaignStatus `json:"status" gorm:"size:20;index;default:draft"`
+	StartAt     *time.Time     `json:"startAt,omitempty"`   // 开始拨号的时间，为空时立即开始
+	EndAt       *time.Time     `json:"endAt,omitempty"`     // 超过后不再拨号，任务结束
+	StartedAt   *time.Time     `json:"startedAt,omitempty"` // 首次开始的时间
+	CompletedAt *time.Time     `json:"completedAt,omitempty"`
+
+	// 拨打时段，未设置时使用主叫号码在脚本号码映射上的时段
+	Window     CallWindow         `json:"window" gorm:"embedded;embeddedPrefix:window_"`
+	RetryRules CampaignRetryRules `json:"retryRules,omitempty" gorm:"type:json"` // 按通话结果覆盖默认重试规则
+
+	// 拨号节奏
+	MaxConcurrentCalls int `json:"maxConcurrentCalls" gorm:"default:1"` // 同时进行的呼叫数
+	CallsPerMinute     int `json:"callsPerMinute"`                      // 每分钟最多发起的呼叫数，0不限制
+	RingTimeout        int `json:"ringTimeout" gorm:"default:30"`       // 振铃超时（秒）
+	MaxAttempts        int `json:"maxAttempts" gorm:"default:3"`        // 每个联系人最多拨打次数，含首次
+
+	// 费用预算，已结算加进行中通话的估算费用达到上限后暂停任务
+	BudgetLimit float64 `json:"budgetLimit"` // 预算上限，0不限
+	BudgetSpent float64 `json:"budgetSpent"` // 已结束通话的估算费用，只由AddCampaignSpent累加
+
+	CreatedBy string `json:"createdBy,omitempty" gorm:"size:64"`
+}
+
+// TableName 指定表名
+func (Campaign) TableName() string {
+	return constants.TABLE_CAMPAIGNS
+}
+
+// CampaignContactStatus 联系人的拨打状态
+type CampaignContactStatus string
+
+const (
+	CampaignContactPending   CampaignContactStatus = "pending"   // 等待拨打或重试
+	CampaignContactDialing   CampaignContactStatus = "dialing"   // 振铃中
+	CampaignContactInCall    CampaignContactStatus = "in_call"   // 已接通，脚本执行中
+	CampaignContactCompleted CampaignContactStatus = "completed" // 已接通并结束
+	CampaignContactFailed    CampaignContactStatus = "failed"    // 重试次数用完或号码无效
+)
+
+// CampaignContact 外呼任务的联系人及其拨打结果
+type CampaignContact struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	CampaignID  uint            `json:"campaignId" gorm:"not null;index:idx_campaign_contact_status"`
+	PhoneNumber string          `json:"phoneNumber" gorm:"size:64;not null;index"`
+	Name        string          `json:"name,omitempty" gorm:"size:128"`
+	Variables   PromptVariables `json:"variables,omitempty" gorm:"type:json"` // 写入会话上下文的变量，脚本中以{{变量}}引用
+
+	Window        CallWindow            `json:"window" gorm:"embedded;embeddedPrefix:window_"` // 该联系人可接听的时段，与任务的时段同时满足
+	Status        CampaignContactStatus `json:"status" gorm:"size:20;default:pending;index:idx_campaign_contact_status"`
+	Attempts      int                   `json:"attempts"`                            // 已拨打次数
+	Outcomes      DispositionCounts     `json:"outcomes,omitempty" gorm:"type:json"` // 各通话结果的次数，按结果计算重试
+	NextAttemptAt *time.Time            `json:"nextAttemptAt,omitempty"`             // 预约或重试时间，为空时尽快拨打
+	LastAttemptAt *time.Time            `json:"lastAttemptAt,omitempty"`
+
+	Disposition   SipCallDisposition `json:"disposition,omitempty" gorm:"size:20"`   // 最近一次拨打的结果
+	CallID        string             `json:"callId,omitempty" gorm:"size:128"`       // 最近一次拨打的Call-ID
+	SessionID     string             `json:"sessionId,omitempty" gorm:"size:64"`     // 接通后的AI会话
+	SessionStatus string             `json:"sessionStatus,omitempty" gorm:"size:20"` // AI会话的结束状态
+	Error         string             `json:"error,omitempty" gorm:"size:500"`
+}
+
+// TableName 指定表名
+func (CampaignContact) TableName() string {
+	return constants.TABLE_CAMPAIGN_CONTACTS
+}
+
+// CreateCampaign 创建外呼任务
+func CreateCampaign(db *gorm.DB, campaign *Campaign) error {
+	return db.Create(campaign).Error
+}
+
+// GetCampaign 根据ID获取外呼任务
+func GetCampaign(db *gorm.DB, id uint) (*Campaign, error) {
+	var campaign Campaign
+	if err := db.First(&campaign, id).Error; err != nil {
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+// ListCampaigns 按创建时间倒序获取外呼任务，status为空时获取全部
+func ListCampaigns(db *gorm.DB, status CampaignStatus) ([]Campaign, error) {
+	var campaigns []Campaign
+	query := db.Order("created_at DESC, id DESC")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err := query.Find(&campaigns).Error
+	return campaigns, err
+}
+
+// UpdateCampaign 保存外呼任务，不覆盖进行中通话结算的费用
+func UpdateCampaign(db *gorm.DB, campaign *Campaign) error {
+	return db.Omit("budget_spent").Save(campaign).Error
+}
+
+// AddCampaignSpent 累加通话结束时结算的费用
+func AddCampaignSpent(db *gorm.DB, campaignID uint, cost float64) error {
+	return db.Model(&Campaign{}).Where("id = ?", campaignID).
+		UpdateColumn("budget_spent", gorm.Expr("budget_spent + ?", cost)).Error
+}
+
+// AddCampaignContacts 批量添加联系人
+func AddCampaignContacts(db *gorm.DB, campaignID uint, contacts []CampaignContact) error {
+	if len(contacts) == 0 {
+		return nil
+	}
+	for i := range contacts {
+		contacts[i].ID = 0
+		contacts[i].CampaignID = campaignID
+		contacts[i].Status = CampaignContactPending
+	}
+	return db.CreateInBatches(contacts, 100).Error
+}
+
+// ListCampaignContacts 分页获取任务的联系人，status为空时获取全部
+func ListCampaignContacts(db *gorm.DB, campaignID uint, status CampaignContactStatus, offset, limit int) ([]CampaignContact, int64, error) {
+	query := db.Model(&CampaignContact{}).Where("campaign_id = ?", campaignID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var contacts []CampaignContact
+	err := query.Order("id ASC").Offset(offset).Limit(limit).Find(&contacts).Error
+	return contacts, total, err
+}
+
+// CountCampaignContacts 按状态统计任务的联系人数
+func CountCampaignContacts(db *gorm.DB, campaignID uint) (map[CampaignContactStatus]int64, error) {
+	var rows []struct {
+		Status CampaignContactStatus
+		Count  int64
+	}
+	err := db.Model(&CampaignContact{}).
+		Select("status, COUNT(*) AS count").
+		Where("campaign_id = ?", campaignID).
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[CampaignContactStatus]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// ClaimNextCampaignContact 取出到期最早的待拨联系人并标记为拨打中，没有时返回nil；
+// 按状态条件更新，多个实例同时拨打时一个联系人只会被取出一次
+func ClaimNextCampaignContact(db *gorm.DB, campaignID uint, now time.Time) (*CampaignContact, error) {
+	for {
+		// 每次检查都会查询，用Find避免没有到期联系人时记录未找到日志
+		var contacts []CampaignContact
+		err := db.Where("campaign_id = ? AND status = ?", campaignID, CampaignContactPending).
+			Where("next_attempt_at IS NULL OR next_attempt_at <= ?", now).
+			Order("next_attempt_at ASC, id ASC").
+			Limit(1).Find(&contacts).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(contacts) == 0 {
+			return nil, nil
+		}
+		contact := contacts[0]
+		result := db.Model(&CampaignContact{}).
+			Where("id = ? AND status = ?", contact.ID, CampaignContactPending).
+			Update("status", CampaignContactDialing)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			contact.Status = CampaignContactDialing
+			return &contact, nil
+		}
+	}
+}
+
+// UpdateCampaignContact 保存联系人的拨打结果
+func UpdateCampaignContact(db *gorm.DB, contact *CampaignContact) error {
+	return db.Save(contact).Error
+}
+
+// HasOpenCampaignContacts 任务是否还有待拨、重试或进行中的联系人
+func HasOpenCampaignContacts(db *gorm.DB, campaignID uint) (bool, error) {
+	var count int64
+	err := db.Model(&CampaignContact{}).
+		Where("campaign_id = ? AND status IN ?", campaignID,
+			[]CampaignContactStatus{CampaignContactPending, CampaignContactDialing, CampaignContactInCall}).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// ResetCampaignContacts 服务重启后恢复上次未结束的拨打：振铃中的恢复为待拨，
+// 已接通的通话随重启中断，记为已结束以免重复打扰
+func ResetCampaignContacts(db *gorm.DB, campaignID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&CampaignContact{}).
+			Where("campaign_id = ? AND status = ?", campaignID, CampaignContactDialing).
+			Updates(map[string]interface{}{"status": CampaignContactPending, "next_attempt_at": nil}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&CampaignContact{}).
+			Where("campaign_id = ? AND status = ?", campaignID, CampaignContactInCall).
+			Updates(map[string]interface{}{"status": CampaignContactCompleted, "error": "call interrupted by restart"}).Error
+	})
+}
@@ -0,0 +1,265 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/constants"
+	"gorm.io/gorm"
+)
+
+// DataSubjectRecords 与一个号码（通话的任一方）关联的全部数据，用于个人信息查阅和删除请求
+type DataSubjectRecords struct {
+	Number       string            `json:"number"`
+	Calls        []SipCall         `json:"calls"`
+	Sessions     []AIPhoneSession  `json:"sessions"` // 含步骤执行记录和对话历史
+	SipSessions  []SipSession      `json:"sipSessions"`
+	Profiles     []SipUser         `json:"profiles"`     // 绑定该号码的SIP用户
+	Messages     []SipMessage      `json:"messages"`     // 与该号码往来的SIP短信
+	LLMExchanges []LLMExchange     `json:"llmExchanges"` // 关联会话发送给对话服务的请求
+	Contacts     []CampaignContact `json:"contacts"`     // 外呼任务中该号码的联系人
+}
+
+// CallIDs 关联通话和会话的Call-ID，去重
+func (r *DataSubjectRecords) CallIDs() []string {
+	seen := make(map[string]bool)
+	var callIDs []string
+	add := func(callID string) {
+		if callID != "" && !seen[callID] {
+			seen[callID] = true
+			callIDs = append(callIDs, callID)
+		}
+	}
+	for _, call := range r.Calls {
+		add(call.CallID)
+	}
+	for _, session := range r.Sessions {
+		add(session.CallID)
+	}
+	return callIDs
+}
+
+// StepExecutionCount 关联会话的步骤执行记录数
+func (r *DataSubjectRecords) StepExecutionCount() int {
+	count := 0
+	for _, session := range r.Sessions {
+		count += len(session.StepExecutions)
+	}
+	return count
+}
+
+func (r *DataSubjectRecords) sessionIDs() []uint {
+	ids := make([]uint, 0, len(r.Sessions))
+	for _, session := range r.Sessions {
+		ids = append(ids, session.ID)
+	}
+	return ids
+}
+
+// FindDataSubjectRecords 查找号码作为主叫或被叫的通话、AI会话及其步骤、SIP会话和绑定该号码的用户
+func FindDataSubjectRecords(db *gorm.DB, number string) (*DataSubjectRecords, error) {
+	records := &DataSubjectRecords{Number: number}
+	if err := db.Where("from_username = ? OR to_username = ?", number, number).
+		Order("start_time ASC").Find(&records.Calls).Error; err != nil {
+		return nil, fmt.Errorf("failed to find calls: %w", err)
+	}
+
+	callIDs := records.CallIDs()
+	sessions := db.Where("caller_number = ? OR callee_number = ?", number, number)
+	if len(callIDs) > 0 {
+		sessions = sessions.Or("call_id IN ?", callIDs)
+	}
+	if err := sessions.Preload("StepExecutions", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("start_time ASC")
+	}).Order("start_time ASC").Find(&records.Sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to find AI sessions: %w", err)
+	}
+
+	if len(records.Sessions) > 0 {
+		if err := db.Where("session_id IN ?", records.sessionIDs()).
+			Order("created_at ASC").Find(&records.LLMExchanges).Error; err != nil {
+			return nil, fmt.Errorf("failed to find LLM exchanges: %w", err)
+		}
+	}
+
+	if callIDs = records.CallIDs(); len(callIDs) > 0 {
+		if err := db.Where("call_id IN ? OR call_id_ref IN ?", callIDs, callIDs).
+			Find(&records.SipSessions).Error; err != nil {
+			return nil, fmt.Errorf("failed to find SIP sessions: %w", err)
+		}
+	}
+
+	if err := db.Where("bound_phone_number = ?", number).Find(&records.Profiles).Error; err != nil {
+		return nil, fmt.Errorf("failed to find SIP users: %w", err)
+	}
+
+	if err := db.Where("from_username = ? OR to_username = ?", number, number).
+		Order("created_at ASC").Find(&records.Messages).Error; err != nil {
+		return nil, fmt.Errorf("failed to find SIP messages: %w", err)
+	}
+
+	if err := db.Where("phone_number = ?", number).Order("id ASC").Find(&records.Contacts).Error; err != nil {
+		return nil, fmt.Errorf("failed to find campaign contacts: %w", err)
+	}
+	return records, nil
+}
+
+// DeleteDataSubjectRecords 在一个事务中彻底删除查到的记录；SIP用户是分配的线路，只解除号码绑定
+func DeleteDataSubjectRecords(db *gorm.DB, records *DataSubjectRecords) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if len(records.Sessions) > 0 {
+			sessionIDs := records.sessionIDs()
+			if err := tx.Where("session_id IN ?", sessionIDs).Delete(&LLMExchange{}).Error; err != nil {
+				return fmt.Errorf("failed to delete LLM exchanges: %w", err)
+			}
+			if err := tx.Where("session_id IN ?", sessionIDs).Delete(&StepExecution{}).Error; err != nil {
+				return fmt.Errorf("failed to delete step executions: %w", err)
+			}
+			if err := tx.Delete(&AIPhoneSession{}, sessionIDs).Error; err != nil {
+				return fmt.Errorf("failed to delete AI sessions: %w", err)
+			}
+		}
+		if len(records.SipSessions) > 0 {
+			ids := make([]uint, 0, len(records.SipSessions))
+			for _, session := range records.SipSessions {
+				ids = append(ids, session.ID)
+			}
+			if err := tx.Delete(&SipSession{}, ids).Error; err != nil {
+				return fmt.Errorf("failed to delete SIP sessions: %w", err)
+			}
+		}
+		if len(records.Calls) > 0 {
+			ids := make([]uint, 0, len(records.Calls))
+			for _, call := range records.Calls {
+				ids = append(ids, call.ID)
+			}
+			if err := tx.Delete(&SipCall{}, ids).Error; err != nil {
+				return fmt.Errorf("failed to delete calls: %w", err)
+			}
+		}
+		if len(records.Messages) > 0 {
+			ids := make([]uint, 0, len(records.Messages))
+			for _, message := range records.Messages {
+				ids = append(ids, message.ID)
+			}
+			if err := tx.Delete(&SipMessage{}, ids).Error; err != nil {
+				return fmt.Errorf("failed to delete SIP messages: %w", err)
+			}
+		}
+		if len(records.Contacts) > 0 {
+			ids := make([]uint, 0, len(records.Contacts))
+			for _, contact := range records.Contacts {
+				ids = append(ids, contact.ID)
+			}
+			if err := tx.Delete(&CampaignContact{}, ids).Error; err != nil {
+				return fmt.Errorf("failed to delete campaign contacts: %w", err)
+			}
+		}
+		if len(records.Profiles) > 0 {
+			if err := tx.Model(&SipUser{}).Where("bound_phone_number = ?", records.Number).
+				Update("bound_phone_number", "").Error; err != nil {
+				return fmt.Errorf("failed to unbind SIP users: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// DeletionCertificate 删除证明：记录删除了哪些数据，不保存号码本身，只保存加盐哈希用于事后核对
+type DeletionCertificate struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+
+	CertificateID string `json:"certificateId" gorm:"size:64;uniqueIndex;not null"` // 证明编号
+	SubjectHash   string `json:"subjectHash" gorm:"size:64;index"`                  // sha256(证明编号:号码)
+	Actor         string `json:"actor" gorm:"size:64;index"`                        // 执行人
+	Reason        string `json:"reason,omitempty" gorm:"size:500"`                  // 删除依据，如请求编号
+
+	// 删除的数据量
+	Calls           int `json:"calls"`
+	Sessions        int `json:"sessions"`
+	StepExecutions  int `json:"stepExecutions"`
+	SipSessions     int `json:"sipSessions"`
+	Profiles        int `json:"profiles"`
+	Recordings      int `json:"recordings"`
+	RecordingErrors int `json:"recordingErrors"` // 删除失败的录音文件数
+	Messages        int `json:"messages"`        // SIP短信
+	LLMExchanges    int `json:"llmExchanges"`    // 对话服务请求记录
+	Contacts        int `json:"contacts"`        // 外呼任务联系人
+
+	Digest string `json:"digest" gorm:"size:64"` // 以上内容的sha256，用于发现篡改
+}
+
+// TableName 指定表名
+func (DeletionCertificate) TableName() string {
+	return constants.TABLE_DELETION_CERTS
+}
+
+// SubjectHashOf 号码在该证明下的哈希
+func (c *DeletionCertificate) SubjectHashOf(number string) string {
+	sum := sha256.Sum256([]byte(c.CertificateID + ":" + number))
+	return hex.EncodeToString(sum[:])
+}
+
+// Matches 证明是否针对该号码
+func (c *DeletionCertificate) Matches(number string) bool {
+	return c.SubjectHash == c.SubjectHashOf(number)
+}
+
+func (c *DeletionCertificate) digest() string {
+	content := fmt.Sprintf("%s|%s|%s|%s|%d|%d|%d|%d|%d|%d|%d|%d",
+		c.CertificateID, c.SubjectHash, c.Actor, c.Reason, c.CreatedAt.Unix(),
+		c.Calls, c.Sessions, c.StepExecutions, c.SipSessions, c.Profiles, c.Recordings, c.RecordingErrors)
+	// 短信数为0时不参与摘要，支持短信前签发的证明仍能校验
+	if c.Messages > 0 {
+		content += fmt.Sprintf("|%d", c.Messages)
+	}
+	// 同理，对话服务请求记录数为0时不参与摘要
+	if c.LLMExchanges > 0 {
+		content += fmt.Sprintf("|llm:%d", c.LLMExchanges)
+	}
+	if c.Contacts > 0 {
+		content += fmt.Sprintf("|campaign:%d", c.Contacts)
+	}
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify 证明内容是否与摘要一致
+func (c *DeletionCertificate) Verify() bool {
+	return c.Digest == c.digest()
+}
+
+// CreateDeletionCertificate 计算号码哈希和摘要后保存删除证明，CertificateID需已设置
+func CreateDeletionCertificate(db *gorm.DB, cert *DeletionCertificate, number string) error {
+	if cert.CreatedAt.IsZero() {
+		cert.CreatedAt = time.Now()
+	}
+	// 摘要按秒计算，不受数据库时间精度影响
+	cert.CreatedAt = cert.CreatedAt.Truncate(time.Second)
+	cert.SubjectHash = cert.SubjectHashOf(number)
+	cert.Digest = cert.digest()
+	return db.Create(cert).Error
+}
+
+// GetDeletionCertificate 根据证明编号获取删除证明
+func GetDeletionCertificate(db *gorm.DB, certificateID string) (*DeletionCertificate, error) {
+	var cert DeletionCertificate
+	if err := db.Where("certificate_id = ?", certificateID).First(&cert).Error; err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// ListDeletionCertificates 按时间倒序分页获取删除证明
+func ListDeletionCertificates(db *gorm.DB, offset, limit int) ([]DeletionCertificate, int64, error) {
+	var certs []DeletionCertificate
+	var total int64
+	if err := db.Model(&DeletionCertificate{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := db.Order("created_at DESC, id DESC").Offset(offset).Limit(limit).Find(&certs).Error
+	return certs, total, err
+}
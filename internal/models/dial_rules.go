@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/constants"
+	"gorm.io/gorm"
+)
+
+// DialRuleAction 拨号规则动作
+type DialRuleAction string
+
+const (
+	DialRuleActionBlock DialRuleAction = "block" // 禁止拨打
+	DialRuleActionAllow DialRuleAction = "allow" // 允许拨打
+)
+
+// DialRule 外呼目的号码规则表（前缀匹配，GroupID为0表示全局规则）
+type DialRule struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time  `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt time.Time  `json:"updatedAt" gorm:"autoUpdateTime"`
+	DeletedAt *time.Time `json:"-" gorm:"index"`
+
+	GroupID     uint           `json:"groupId" gorm:"index;default:0"`        // 组织ID，0为全局
+	Prefix      string         `json:"prefix" gorm:"size:32;not null;index"`  // 号码前缀，如 00、+、1900
+	Action      DialRuleAction `json:"action" gorm:"size:16;not null"`        // block / allow
+	Description string         `json:"description,omitempty" gorm:"size:256"` // 描述
+	Enabled     bool           `json:"enabled" gorm:"default:true"`           // 是否启用
+}
+
+// TableName 指定表名
+func (DialRule) TableName() string {
+	return constants.TABLE_DIAL_RULES
+}
+
+// CreateDialRule 创建拨号规则
+func CreateDialRule(db *gorm.DB, rule *DialRule) error {
+	return db.Create(rule).Error
+}
+
+// DeleteDialRule 删除拨号规则（软删除）
+func DeleteDialRule(db *gorm.DB, id uint) error {
+	return db.Delete(&DialRule{}, id).Error
+}
+
+// GetEnabledDialRules 获取所有启用的拨号规则
+func GetEnabledDialRules(db *gorm.DB) ([]DialRule, error) {
+	var rules []DialRule
+	err := db.Where("enabled = ?", true).Find(&rules).Error
+	return rules, err
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/constants"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 号码加入免打扰名单的来源
+const (
+	DoNotCallSourceAPI  = "api"  // 接口添加
+	DoNotCallSourceCSV  = "csv"  // CSV导入
+	DoNotCallSourceDTMF = "dtmf" // 通话中按键退订
+)
+
+// DoNotCallEntry 免打扰名单中的号码，外呼前检查，命中时不拨打
+type DoNotCallEntry struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	PhoneNumber string `json:"phoneNumber" gorm:"size:64;uniqueIndex;not null"` // 去掉+86等国家码后的号码
+	Source      string `json:"source" gorm:"size:16"`
+	Reason      string `json:"reason,omitempty" gorm:"size:255"`
+	CallID      string `json:"callId,omitempty" gorm:"size:128"` // 按键退订时的通话
+	CreatedBy   string `json:"createdBy,omitempty" gorm:"size:64"`
+}
+
+// TableName 指定表名
+func (DoNotCallEntry) TableName() string {
+	return constants.TABLE_DNC_ENTRIES
+}
+
+// DoNotCallSuppression 因免打扰名单未拨打的一次外呼
+type DoNotCallSuppression struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+
+	PhoneNumber string `json:"phoneNumber" gorm:"size:64;index"` // 同名单记录，去掉国家码后的号码
+	EntryID     uint   `json:"entryId"`                          // 命中的名单记录
+	GroupID     uint   `json:"groupId,omitempty"`                // 发起外呼的组织
+}
+
+// TableName 指定表名
+func (DoNotCallSuppression) TableName() string {
+	return constants.TABLE_DNC_SUPPRESSIONS
+}
+
+// AddDoNotCallEntries 添加号码，已在名单中的号码跳过，返回新增数
+func AddDoNotCallEntries(db *gorm.DB, entries []DoNotCallEntry) (int64, error) {
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	result := db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "phone_number"}}, DoNothing: true}).
+		CreateInBatches(entries, 500)
+	return result.RowsAffected, result.Error
+}
+
+// FindDoNotCallEntry 查找号码的名单记录，不在名单中时返回nil
+func FindDoNotCallEntry(db *gorm.DB, phoneNumber string) (*DoNotCallEntry, error) {
+	var entries []DoNotCallEntry
+	if err := db.Where("phone_number = ?", phoneNumber).Limit(1).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return &entries[0], nil
+}
+
+// ListDoNotCallEntries 分页列出名单，number不为空时按号码前缀过滤，最新的在前
+func ListDoNotCallEntries(db *gorm.DB, number string, offset, limit int) ([]DoNotCallEntry, int64, error) {
+	query := db.Model(&DoNotCallEntry{})
+	if number != "" {
+		query = query.Where("phone_number LIKE ?", number+"%")
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var entries []DoNotCallEntry
+	err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&entries).Error
+	return entries, total, err
+}
+
+// DeleteDoNotCallEntry 从名单中移除号码，返回是否存在
+func DeleteDoNotCallEntry(db *gorm.DB, phoneNumber string) (bool, error) {
+	result := db.Where("phone_number = ?", phoneNumber).Delete(&DoNotCallEntry{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// CreateDoNotCallSuppression 记录一次被拦截的外呼
+func CreateDoNotCallSuppression(db *gorm.DB, suppression *DoNotCallSuppression) error {
+	return db.Create(suppression).Error
+}
+
+// ListDoNotCallSuppressions 分页列出被拦截的外呼，number不为空时只看该号码，最新的在前
+func ListDoNotCallSuppressions(db *gorm.DB, number string, offset, limit int) ([]DoNotCallSuppression, int64, error) {
+	query := db.Model(&DoNotCallSuppression{})
+	if number != "" {
+		query = query.Where("phone_number = ?", number)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var suppressions []DoNotCallSuppression
+	err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&suppressions).Error
+	return suppressions, total, err
+}
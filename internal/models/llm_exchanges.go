@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/constants"
+	"gorm.io/gorm"
+)
+
+// LLMExchange 一次对话服务请求：发送给模型的完整请求（系统提示、历史和工具定义）与回复，关联步骤执行记录，
+// 用于提示词调试和合规审查
+type LLMExchange struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt" gorm:"autoCreateTime;index"`
+
+	// 关联信息
+	SessionID       uint   `json:"sessionId" gorm:"not null;index"`    // AI会话ID
+	StepExecutionID uint   `json:"stepExecutionId" gorm:"index"`       // 步骤执行记录ID，0表示不在步骤中
+	CallID          string `json:"callId" gorm:"size:128;index"`       // 通话Call-ID
+	StepID          string `json:"stepId,omitempty" gorm:"size:64"`    // 步骤ID
+	Model           string `json:"model,omitempty" gorm:"size:64"`     // 模型名称
+	Redaction       string `json:"redaction,omitempty" gorm:"size:20"` // 保存时的脱敏方式：numbers、content，空表示原样保存
+
+	// 请求与回复
+	Request  string `json:"request" gorm:"type:text"`            // 请求JSON：messages与tools
+	Response string `json:"response,omitempty" gorm:"type:text"` // 模型回复
+	ToolCall string `json:"toolCall,omitempty" gorm:"size:500"`  // 模型请求的挂断或转接
+	Error    string `json:"error,omitempty" gorm:"type:text"`    // 请求失败或被取消的原因
+	Latency  int    `json:"latency" gorm:"default:0"`            // 请求耗时（毫秒）
+}
+
+// TableName 指定表名
+func (LLMExchange) TableName() string {
+	return constants.TABLE_LLM_EXCHANGES
+}
+
+// CreateLLMExchange 保存对话服务请求记录
+func CreateLLMExchange(db *gorm.DB, exchange *LLMExchange) error {
+	return db.Create(exchange).Error
+}
+
+// GetLLMExchangesByCallID 按时间顺序列出通话的对话服务请求，stepExecutionID非0时只列出该步骤执行的请求
+func GetLLMExchangesByCallID(db *gorm.DB, callID string, stepExecutionID uint) ([]LLMExchange, error) {
+	var exchanges []LLMExchange
+	query := db.Where("call_id = ?", callID)
+	if stepExecutionID != 0 {
+		query = query.Where("step_execution_id = ?", stepExecutionID)
+	}
+	err := query.Order("created_at ASC, id ASC").Find(&exchanges).Error
+	return exchanges, err
+}
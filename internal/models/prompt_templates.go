@@ -0,0 +1,100 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/constants"
+	"gorm.io/gorm"
+)
+
+// PromptVariables 模板变量的默认值，会话上下文中有同名变量时以上下文为准
+type PromptVariables map[string]string
+
+// Value 实现 driver.Valuer 接口
+func (v PromptVariables) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// Scan 实现 sql.Scanner 接口
+func (v *PromptVariables) Scan(value interface{}) error {
+	var bytes []byte
+	switch data := value.(type) {
+	case []byte:
+		bytes = data
+	case string:
+		bytes = []byte(data)
+	}
+	if len(bytes) == 0 {
+		return nil
+	}
+	return json.Unmarshal(bytes, v)
+}
+
+// PromptTemplate 提示词模板表：语气、合规条款、部门话术等可复用片段，步骤提示词中以{{template:名称}}引用。
+// 每次修改保存为新版本，引用默认使用最新版本，{{template:名称@版本}}固定到指定版本
+type PromptTemplate struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt" gorm:"autoCreateTime"`
+
+	Name        string          `json:"name" gorm:"size:64;not null;uniqueIndex:idx_prompt_template_version"` // 模板名称
+	Version     int             `json:"version" gorm:"not null;uniqueIndex:idx_prompt_template_version"`      // 版本号，从1递增
+	Content     string          `json:"content" gorm:"type:text;not null"`                                    // 模板内容，可包含{{变量}}和其他模板
+	Variables   PromptVariables `json:"variables,omitempty" gorm:"type:json"`                                 // 变量默认值
+	Description string          `json:"description,omitempty" gorm:"size:256"`                                // 本版本的修改说明
+	CreatedBy   string          `json:"createdBy,omitempty" gorm:"size:64"`                                   // 创建人
+}
+
+// TableName 指定表名
+func (PromptTemplate) TableName() string {
+	return constants.TABLE_PROMPT_TEMPLATES
+}
+
+// CreatePromptTemplateVersion 保存模板的新版本，版本号为该名称已有的最大版本加一
+func CreatePromptTemplateVersion(db *gorm.DB, template *PromptTemplate) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var latest int
+		if err := tx.Model(&PromptTemplate{}).
+			Where("name = ?", template.Name).
+			Select("COALESCE(MAX(version), 0)").
+			Scan(&latest).Error; err != nil {
+			return err
+		}
+		template.ID = 0
+		template.Version = latest + 1
+		return tx.Create(template).Error
+	})
+}
+
+// GetPromptTemplate 获取模板的指定版本，version为0时获取最新版本
+func GetPromptTemplate(db *gorm.DB, name string, version int) (*PromptTemplate, error) {
+	var template PromptTemplate
+	query := db.Where("name = ?", name)
+	if version > 0 {
+		query = query.Where("version = ?", version)
+	}
+	err := query.Order("version DESC").First(&template).Error
+	if err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// GetPromptTemplateVersions 按版本倒序获取模板的所有版本
+func GetPromptTemplateVersions(db *gorm.DB, name string) ([]PromptTemplate, error) {
+	var templates []PromptTemplate
+	err := db.Where("name = ?", name).Order("version DESC").Find(&templates).Error
+	return templates, err
+}
+
+// GetLatestPromptTemplates 按名称获取每个模板的最新版本
+func GetLatestPromptTemplates(db *gorm.DB) ([]PromptTemplate, error) {
+	var templates []PromptTemplate
+	latest := db.Model(&PromptTemplate{}).Select("MAX(id)").Group("name")
+	err := db.Where("id IN (?)", latest).Order("name ASC").Find(&templates).Error
+	return templates, err
+}
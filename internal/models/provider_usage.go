@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/constants"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 计量用量的服务类型
+const (
+	ProviderServiceASR = "asr" // 用量为识别的音频分钟数
+	ProviderServiceTTS = "tts" // 用量为合成的字数
+	ProviderServiceLLM = "llm" // 用量为估算的token数
+)
+
+// ProviderUsage 服务商每月用量，多个实例累加到同一行
+type ProviderUsage struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Month          string  `json:"month" gorm:"size:7;not null;uniqueIndex:idx_provider_usage_month"`     // 2026-01
+	Service        string  `json:"service" gorm:"size:8;not null;uniqueIndex:idx_provider_usage_month"`   // asr / tts / llm
+	Provider       string  `json:"provider" gorm:"size:32;not null;uniqueIndex:idx_provider_usage_month"` // 服务商，如 qcloud
+	Amount         float64 `json:"amount"`                                                                // 本月用量
+	AlertedPercent int     `json:"alertedPercent"`                                                        // 本月已发出的最高告警比例
+}
+
+// TableName 指定表名
+func (ProviderUsage) TableName() string {
+	return constants.TABLE_PROVIDER_USAGE
+}
+
+// AddProviderUsage 累加服务商当月用量，不存在时创建
+func AddProviderUsage(db *gorm.DB, month, service, provider string, amount float64) error {
+	usage := &ProviderUsage{Month: month, Service: service, Provider: provider, Amount: amount}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "month"}, {Name: "service"}, {Name: "provider"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"amount":     gorm.Expr(constants.TABLE_PROVIDER_USAGE+".amount + ?", amount),
+			"updated_at": time.Now(),
+		}),
+	}).Create(usage).Error
+}
+
+// ListProviderUsage 列出某月各服务商的用量
+func ListProviderUsage(db *gorm.DB, month string) ([]ProviderUsage, error) {
+	var usage []ProviderUsage
+	err := db.Where("month = ?", month).Order("service ASC, provider ASC").Find(&usage).Error
+	return usage, err
+}
+
+// MarkProviderUsageAlerted 记录已发出的告警比例，已发出同级或更高告警时返回false，多个实例只有一个发出告警
+func MarkProviderUsageAlerted(db *gorm.DB, id uint, percent int) (bool, error) {
+	result := db.Model(&ProviderUsage{}).
+		Where("id = ? AND alerted_percent < ?", id, percent).
+		Update("alerted_percent", percent)
+	return result.RowsAffected > 0, result.Error
+}
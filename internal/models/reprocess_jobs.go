@@ -0,0 +1,207 @@
+package models
+
+import (
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/constants"
+	"gorm.io/gorm"
+)
+
+// ReprocessKind 重新处理的类型
+type ReprocessKind string
+
+const (
+	ReprocessTranscription ReprocessKind = "transcription" // 重新转录通话录音
+	ReprocessScoring       ReprocessKind = "scoring"       // 重新评估AI会话质量
+)
+
+// ReprocessJobStatus 重新处理任务状态
+type ReprocessJobStatus string
+
+const (
+	ReprocessJobRunning   ReprocessJobStatus = "running"   // 处理中，服务重启后从游标继续
+	ReprocessJobCompleted ReprocessJobStatus = "completed" // 范围内的记录均已处理
+	ReprocessJobCancelled ReprocessJobStatus = "cancelled" // 已取消，已处理的结果保留
+	ReprocessJobFailed    ReprocessJobStatus = "failed"    // 无法继续，如服务未配置
+)
+
+// ReprocessJob 对历史录音批量重新转录或对历史会话重新评分的任务
+type ReprocessJob struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Kind   ReprocessKind      `json:"kind" gorm:"size:20;not null"`
+	Status ReprocessJobStatus `json:"status" gorm:"size:20;index"`
+
+	// 处理范围，按通话或会话的开始时间
+	From     *time.Time `json:"from,omitempty"`
+	To       *time.Time `json:"to,omitempty"`
+	ScriptID uint       `json:"scriptId,omitempty"` // 只处理该脚本的会话，0不限制
+	Apply    bool       `json:"apply"`              // 是否用新结果替换记录上的转录或评分，否则只用于对比
+
+	// 进度
+	Total     int64 `json:"total"`     // 创建时范围内的记录数
+	Processed int   `json:"processed"` // 已处理数，含失败
+	Failed    int   `json:"failed"`
+	Changed   int   `json:"changed"` // 新结果与原结果不同的记录数
+	Cursor    uint  `json:"-"`       // 最后处理的记录ID
+
+	Error       string     `json:"error,omitempty" gorm:"size:500"`
+	CreatedBy   string     `json:"createdBy,omitempty" gorm:"size:64"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// TableName 指定表名
+func (ReprocessJob) TableName() string {
+	return constants.TABLE_REPROCESS_JOBS
+}
+
+// ReprocessResult 一条记录重新处理前后的结果
+type ReprocessResult struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	JobID    uint   `json:"jobId" gorm:"not null;index"`
+	TargetID string `json:"targetId" gorm:"size:128;index"` // 转录为Call-ID，评分为会话ID
+
+	// 重新转录
+	OldText    string  `json:"oldText,omitempty" gorm:"type:text"`
+	NewText    string  `json:"newText,omitempty" gorm:"type:text"`
+	Similarity float64 `json:"similarity"` // 新旧转录的相似度（1-字错误率），0-1
+
+	// 重新评分
+	OldScore float32 `json:"oldScore"`
+	NewScore float32 `json:"newScore"`
+
+	Error string `json:"error,omitempty" gorm:"size:500"`
+}
+
+// TableName 指定表名
+func (ReprocessResult) TableName() string {
+	return constants.TABLE_REPROCESS_RESULTS
+}
+
+// ReprocessSummary 任务中成功处理的记录的平均结果
+type ReprocessSummary struct {
+	Count      int64   `json:"count"`
+	Similarity float64 `json:"similarity"`
+	OldScore   float64 `json:"oldScore"`
+	NewScore   float64 `json:"newScore"`
+}
+
+// CreateReprocessJob 创建重新处理任务
+func CreateReprocessJob(db *gorm.DB, job *ReprocessJob) error {
+	return db.Create(job).Error
+}
+
+// GetReprocessJob 按ID获取任务
+func GetReprocessJob(db *gorm.DB, id uint) (*ReprocessJob, error) {
+	var job ReprocessJob
+	if err := db.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListReprocessJobs 列出任务，status为空时不过滤，最新的在前
+func ListReprocessJobs(db *gorm.DB, status ReprocessJobStatus) ([]ReprocessJob, error) {
+	query := db.Order("id DESC")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var jobs []ReprocessJob
+	err := query.Find(&jobs).Error
+	return jobs, err
+}
+
+// UpdateReprocessProgress 保存任务进度，不覆盖状态，取消后仍在处理的记录不会把任务改回运行中
+func UpdateReprocessProgress(db *gorm.DB, job *ReprocessJob) error {
+	return db.Model(job).Select("processed", "failed", "changed", "cursor").Updates(job).Error
+}
+
+// FinishReprocessJob 结束运行中的任务，任务已结束时返回false
+func FinishReprocessJob(db *gorm.DB, id uint, status ReprocessJobStatus, reason string) (bool, error) {
+	now := time.Now()
+	result := db.Model(&ReprocessJob{}).Where("id = ? AND status = ?", id, ReprocessJobRunning).Updates(map[string]interface{}{
+		"status":       status,
+		"error":        reason,
+		"completed_at": &now,
+	})
+	return result.RowsAffected > 0, result.Error
+}
+
+// reprocessTargets 任务范围内的记录：有录音的通话或已结束的会话
+func reprocessTargets(db *gorm.DB, job *ReprocessJob) *gorm.DB {
+	var query *gorm.DB
+	if job.Kind == ReprocessScoring {
+		query = db.Model(&AIPhoneSession{}).Where("end_time IS NOT NULL AND conversation IS NOT NULL")
+		if job.ScriptID != 0 {
+			query = query.Where("script_id = ?", job.ScriptID)
+		}
+	} else {
+		query = db.Model(&SipCall{}).Where("record_url <> ''")
+	}
+	if job.From != nil {
+		query = query.Where("start_time >= ?", *job.From)
+	}
+	if job.To != nil {
+		query = query.Where("start_time < ?", *job.To)
+	}
+	return query
+}
+
+// CountReprocessTargets 统计任务范围内的记录数
+func CountReprocessTargets(db *gorm.DB, job *ReprocessJob) (int64, error) {
+	var total int64
+	err := reprocessTargets(db, job).Count(&total).Error
+	return total, err
+}
+
+// NextReprocessCalls 游标之后待重新转录的通话，按ID顺序
+func NextReprocessCalls(db *gorm.DB, job *ReprocessJob, limit int) ([]SipCall, error) {
+	var calls []SipCall
+	err := reprocessTargets(db, job).Where("id > ?", job.Cursor).Order("id ASC").Limit(limit).Find(&calls).Error
+	return calls, err
+}
+
+// NextReprocessSessions 游标之后待重新评分的会话，按ID顺序
+func NextReprocessSessions(db *gorm.DB, job *ReprocessJob, limit int) ([]AIPhoneSession, error) {
+	var sessions []AIPhoneSession
+	err := reprocessTargets(db, job).Where("id > ?", job.Cursor).Order("id ASC").Limit(limit).Find(&sessions).Error
+	return sessions, err
+}
+
+// CreateReprocessResult 保存一条记录的处理结果
+func CreateReprocessResult(db *gorm.DB, result *ReprocessResult) error {
+	return db.Create(result).Error
+}
+
+// ListReprocessResults 分页列出任务的处理结果
+func ListReprocessResults(db *gorm.DB, jobID uint, offset, limit int) ([]ReprocessResult, int64, error) {
+	query := db.Model(&ReprocessResult{}).Where("job_id = ?", jobID)
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var results []ReprocessResult
+	err := query.Order("id ASC").Offset(offset).Limit(limit).Find(&results).Error
+	return results, total, err
+}
+
+// GetReprocessResults 按记录ID获取任务的处理结果
+func GetReprocessResults(db *gorm.DB, jobID uint, targetIDs []string) ([]ReprocessResult, error) {
+	var results []ReprocessResult
+	err := db.Where("job_id = ? AND target_id IN ? AND error = ''", jobID, targetIDs).Find(&results).Error
+	return results, err
+}
+
+// SummarizeReprocessResults 计算任务中成功处理的记录的平均相似度和评分
+func SummarizeReprocessResults(db *gorm.DB, jobID uint) (ReprocessSummary, error) {
+	var summary ReprocessSummary
+	err := db.Model(&ReprocessResult{}).
+		Select("COUNT(*) AS count, COALESCE(AVG(similarity), 0) AS similarity, COALESCE(AVG(old_score), 0) AS old_score, COALESCE(AVG(new_score), 0) AS new_score").
+		Where("job_id = ? AND error = ''", jobID).
+		Scan(&summary).Error
+	return summary, err
+}
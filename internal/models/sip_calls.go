@@ -1,6 +1,9 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/LingByte/LingSIP/pkg/constants"
@@ -18,6 +21,39 @@ const (
 	SipCallStatusEnded     SipCallStatus = "ended"     // 已结束
 )
 
+// SipCallDisposition 通话结果归类（用于外呼失败原因统计和重试）
+type SipCallDisposition string
+
+const (
+	SipCallDispositionAnswered    SipCallDisposition = "answered"    // 已接通
+	SipCallDispositionBusy        SipCallDisposition = "busy"        // 忙线 486/600
+	SipCallDispositionNoAnswer    SipCallDisposition = "no_answer"   // 无人接听 408/480/487
+	SipCallDispositionInvalid     SipCallDisposition = "invalid"     // 号码不存在 404/484/604
+	SipCallDispositionRejected    SipCallDisposition = "rejected"    // 被拒接 403/603
+	SipCallDispositionUnavailable SipCallDisposition = "unavailable" // 服务不可用 502/503/504
+	SipCallDispositionFailed      SipCallDisposition = "failed"      // 其他失败
+)
+
+// DispositionFromSIPStatus 将SIP最终响应码映射为通话结果
+func DispositionFromSIPStatus(code int) SipCallDisposition {
+	switch {
+	case code >= 200 && code < 300:
+		return SipCallDispositionAnswered
+	case code == 486 || code == 600:
+		return SipCallDispositionBusy
+	case code == 408 || code == 480 || code == 487:
+		return SipCallDispositionNoAnswer
+	case code == 404 || code == 484 || code == 604:
+		return SipCallDispositionInvalid
+	case code == 403 || code == 603:
+		return SipCallDispositionRejected
+	case code == 502 || code == 503 || code == 504:
+		return SipCallDispositionUnavailable
+	default:
+		return SipCallDispositionFailed
+	}
+}
+
 // SipCallDirection 通话方向
 type SipCallDirection string
 
@@ -26,35 +62,107 @@ const (
 	SipCallDirectionOutbound SipCallDirection = "outbound" // 呼出
 )
 
+// 录音转录状态
+const (
+	TranscriptionPending    = "pending"
+	TranscriptionProcessing = "processing"
+	TranscriptionCompleted  = "completed"
+	TranscriptionFailed     = "failed"
+)
+
+// TranscriptSegment 转录中一个说话人的一段话，时间为相对录音开始的毫秒数
+type TranscriptSegment struct {
+	Speaker string `json:"speaker"`
+	StartMs int    `json:"startMs"`
+	EndMs   int    `json:"endMs"`
+	Text    string `json:"text"`
+}
+
+// TranscriptSegments 按开始时间排序的分角色转录
+type TranscriptSegments []TranscriptSegment
+
+// Value 实现 driver.Valuer 接口
+func (ts TranscriptSegments) Value() (driver.Value, error) {
+	if len(ts) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(ts)
+}
+
+// Scan 实现 sql.Scanner 接口
+func (ts *TranscriptSegments) Scan(value interface{}) error {
+	if value == nil {
+		*ts = make(TranscriptSegments, 0)
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	if len(bytes) == 0 {
+		*ts = make(TranscriptSegments, 0)
+		return nil
+	}
+	return json.Unmarshal(bytes, ts)
+}
+
+// TalkTime 每个说话人的讲话总时长（毫秒），供质检统计说话占比
+func (ts TranscriptSegments) TalkTime() map[string]int {
+	talk := make(map[string]int)
+	for _, segment := range ts {
+		talk[segment.Speaker] += segment.EndMs - segment.StartMs
+	}
+	return talk
+}
+
+// Text 按"说话人: 内容"逐行拼接，保存为通话的转录文本便于检索
+func (ts TranscriptSegments) Text() string {
+	lines := make([]string, len(ts))
+	for i, segment := range ts {
+		lines[i] = segment.Speaker + ": " + segment.Text
+	}
+	return strings.Join(lines, "\n")
+}
+
+// CallTranscription 通话录音的转录结果
+type CallTranscription struct {
+	Status   string             `json:"status"`
+	Text     string             `json:"text,omitempty"`
+	Error    string             `json:"error,omitempty"`
+	Segments TranscriptSegments `json:"segments,omitempty"`
+}
+
 // SipCall SIP通话记录表
 type SipCall struct {
-	ID                  uint             `json:"id" gorm:"primaryKey"`
-	CreatedAt           time.Time        `json:"createdAt" gorm:"autoCreateTime"`
-	UpdatedAt           time.Time        `json:"updatedAt" gorm:"autoUpdateTime"`
-	DeletedAt           *time.Time       `json:"-" gorm:"index"`
-	CallID              string           `json:"callId" gorm:"size:128;index;not null"`        // SIP Call-ID
-	Direction           SipCallDirection `json:"direction" gorm:"size:20;index"`               // 通话方向
-	Status              SipCallStatus    `json:"status" gorm:"size:20;index"`                  // 通话状态
-	FromUsername        string           `json:"fromUsername,omitempty" gorm:"size:128"`       // 主叫用户名
-	FromURI             string           `json:"fromUri,omitempty" gorm:"size:256"`            // 主叫URI
-	FromIP              string           `json:"fromIp,omitempty" gorm:"size:64"`              // 主叫IP
-	ToUsername          string           `json:"toUsername,omitempty" gorm:"size:128"`         // 被叫用户名
-	ToURI               string           `json:"toUri,omitempty" gorm:"size:256"`              // 被叫URI
-	ToIP                string           `json:"toIp,omitempty" gorm:"size:64"`                // 被叫IP
-	LocalRTPAddr        string           `json:"localRtpAddr,omitempty" gorm:"size:128"`       // 本地RTP地址
-	RemoteRTPAddr       string           `json:"remoteRtpAddr,omitempty" gorm:"size:128"`      // 远程RTP地址
-	StartTime           time.Time        `json:"startTime"`                                    // 开始时间
-	AnswerTime          *time.Time       `json:"answerTime,omitempty"`                         // 接通时间
-	EndTime             *time.Time       `json:"endTime,omitempty"`                            // 结束时间
-	Duration            int              `json:"duration" gorm:"default:0"`                    // 通话时长（秒）
-	ErrorCode           int              `json:"errorCode,omitempty"`                          // 错误代码
-	ErrorMessage        string           `json:"errorMessage,omitempty" gorm:"size:500"`       // 错误消息
-	RecordURL           string           `json:"recordUrl,omitempty" gorm:"size:500"`          // 通话录音文件URL
-	Transcription       string           `json:"transcription,omitempty" gorm:"type:text"`     // 转录文本
-	TranscriptionStatus string           `json:"transcriptionStatus,omitempty" gorm:"size:20"` // 转录状态：pending, processing, completed, failed
-	TranscriptionError  string           `json:"transcriptionError,omitempty" gorm:"size:500"` // 转录错误信息
-	Metadata            string           `json:"metadata,omitempty" gorm:"type:text"`          // JSON格式的额外信息
-	Notes               string           `json:"notes,omitempty" gorm:"type:text"`             // 备注
+	ID                  uint               `json:"id" gorm:"primaryKey"`
+	CreatedAt           time.Time          `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt           time.Time          `json:"updatedAt" gorm:"autoUpdateTime"`
+	DeletedAt           *time.Time         `json:"-" gorm:"index"`
+	CallID              string             `json:"callId" gorm:"size:128;index;not null"`         // SIP Call-ID
+	Direction           SipCallDirection   `json:"direction" gorm:"size:20;index"`                // 通话方向
+	Status              SipCallStatus      `json:"status" gorm:"size:20;index"`                   // 通话状态
+	FromUsername        string             `json:"fromUsername,omitempty" gorm:"size:128"`        // 主叫用户名
+	FromURI             string             `json:"fromUri,omitempty" gorm:"size:256"`             // 主叫URI
+	FromIP              string             `json:"fromIp,omitempty" gorm:"size:64"`               // 主叫IP
+	ToUsername          string             `json:"toUsername,omitempty" gorm:"size:128"`          // 被叫用户名
+	ToURI               string             `json:"toUri,omitempty" gorm:"size:256"`               // 被叫URI
+	ToIP                string             `json:"toIp,omitempty" gorm:"size:64"`                 // 被叫IP
+	LocalRTPAddr        string             `json:"localRtpAddr,omitempty" gorm:"size:128"`        // 本地RTP地址
+	RemoteRTPAddr       string             `json:"remoteRtpAddr,omitempty" gorm:"size:128"`       // 远程RTP地址
+	StartTime           time.Time          `json:"startTime"`                                     // 开始时间
+	AnswerTime          *time.Time         `json:"answerTime,omitempty"`                          // 接通时间
+	EndTime             *time.Time         `json:"endTime,omitempty"`                             // 结束时间
+	Duration            int                `json:"duration" gorm:"default:0"`                     // 通话时长（秒）
+	ErrorCode           int                `json:"errorCode,omitempty"`                           // 错误代码
+	ErrorMessage        string             `json:"errorMessage,omitempty" gorm:"size:500"`        // 错误消息
+	Disposition         SipCallDisposition `json:"disposition,omitempty" gorm:"size:20;index"`    // 通话结果归类
+	RecordURL           string             `json:"recordUrl,omitempty" gorm:"size:500"`           // 通话录音文件URL
+	Transcription       string             `json:"transcription,omitempty" gorm:"type:text"`      // 转录文本
+	TranscriptionStatus string             `json:"transcriptionStatus,omitempty" gorm:"size:20"`  // 转录状态：pending, processing, completed, failed
+	TranscriptionError  string             `json:"transcriptionError,omitempty" gorm:"size:500"`  // 转录错误信息
+	TranscriptSegments  TranscriptSegments `json:"transcriptSegments,omitempty" gorm:"type:json"` // 按说话人标注的转录
+	Metadata            string             `json:"metadata,omitempty" gorm:"type:text"`           // JSON格式的额外信息
+	Notes               string             `json:"notes,omitempty" gorm:"type:text"`              // 备注
 }
 
 // TableName get tables
@@ -77,6 +185,16 @@ func GetSipCallByCallID(db *gorm.DB, callID string) (*SipCall, error) {
 	return &sipCall, nil
 }
 
+// MarkFailedWithStatus 根据SIP失败响应码标记通话失败
+func (c *SipCall) MarkFailedWithStatus(code int, reason string) {
+	now := time.Now()
+	c.Status = SipCallStatusFailed
+	c.ErrorCode = code
+	c.ErrorMessage = reason
+	c.Disposition = DispositionFromSIPStatus(code)
+	c.EndTime = &now
+}
+
 // UpdateSipCall 更新SIP通话记录
 func UpdateSipCall(db *gorm.DB, sipCall *SipCall) error {
 	return db.Save(sipCall).Error
@@ -103,3 +221,15 @@ func GetSipCallsByStatus(db *gorm.DB, status SipCallStatus, limit int) ([]SipCal
 	err := query.Find(&sipCalls).Error
 	return sipCalls, err
 }
+
+// SearchSipCallTranscripts 按关键词检索已完成转录的通话，最新的在前
+func SearchSipCallTranscripts(db *gorm.DB, keyword string, limit int) ([]SipCall, error) {
+	var sipCalls []SipCall
+	query := db.Where("transcription_status = ? AND transcription LIKE ?", TranscriptionCompleted, "%"+keyword+"%").
+		Order("start_time DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&sipCalls).Error
+	return sipCalls, err
+}
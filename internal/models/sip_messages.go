@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/constants"
+	"gorm.io/gorm"
+)
+
+// SipMessageDirection 短信方向
+type SipMessageDirection string
+
+const (
+	SipMessageInbound  SipMessageDirection = "inbound"  // 收到的MESSAGE
+	SipMessageOutbound SipMessageDirection = "outbound" // 发出的MESSAGE
+)
+
+// SipMessageStatus 短信状态
+type SipMessageStatus string
+
+const (
+	SipMessageReceived SipMessageStatus = "received" // 已收到
+	SipMessageSending  SipMessageStatus = "sending"  // 正在发送
+	SipMessageSent     SipMessageStatus = "sent"     // 对端已回复2xx
+	SipMessageFailed   SipMessageStatus = "failed"   // 发送失败
+)
+
+// SipMessage SIP MESSAGE（RFC 3428）即时消息记录
+type SipMessage struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt" gorm:"autoCreateTime;index"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+
+	CallID       string              `json:"callId" gorm:"size:128;index"`          // MESSAGE请求的Call-ID
+	Direction    SipMessageDirection `json:"direction" gorm:"size:16;index"`        // 方向
+	FromUsername string              `json:"fromUsername" gorm:"size:64;index"`     // 发送方
+	ToUsername   string              `json:"toUsername" gorm:"size:64;index"`       // 接收方
+	ContentType  string              `json:"contentType" gorm:"size:64"`            // 内容类型
+	Body         string              `json:"body" gorm:"type:text"`                 // 消息内容
+	Status       SipMessageStatus    `json:"status" gorm:"size:16;index"`           // 状态
+	StatusCode   int                 `json:"statusCode,omitempty"`                  // 发送时对端的最终响应码
+	Error        string              `json:"error,omitempty" gorm:"size:500"`       // 发送失败原因
+	ReplyToID    uint                `json:"replyToId,omitempty" gorm:"index"`      // 自动回复对应的收到的消息
+	RemoteAddr   string              `json:"remoteAddr,omitempty" gorm:"size:64"`   // 收到时的来源地址，发出时的目标地址
+	Source       string              `json:"source,omitempty" gorm:"size:32;index"` // 发出方式：api或llm
+}
+
+// TableName 指定表名
+func (SipMessage) TableName() string {
+	return constants.TABLE_SIP_MESSAGES
+}
+
+// CreateSipMessage 保存短信
+func CreateSipMessage(db *gorm.DB, message *SipMessage) error {
+	return db.Create(message).Error
+}
+
+// UpdateSipMessage 更新短信状态
+func UpdateSipMessage(db *gorm.DB, message *SipMessage) error {
+	return db.Save(message).Error
+}
+
+// ListSipMessages 按时间倒序分页获取与用户往来的短信，username为空时获取全部
+func ListSipMessages(db *gorm.DB, username string, offset, limit int) ([]SipMessage, int64, error) {
+	query := db.Model(&SipMessage{})
+	if username != "" {
+		query = query.Where("from_username = ? OR to_username = ?", username, username)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var messages []SipMessage
+	err := query.Order("created_at DESC, id DESC").Offset(offset).Limit(limit).Find(&messages).Error
+	return messages, total, err
+}
+
+// RecentSipMessages 与用户往来的最近limit条短信，按时间正序，用于拼接对话上下文
+func RecentSipMessages(db *gorm.DB, username string, limit int) ([]SipMessage, error) {
+	var messages []SipMessage
+	err := db.Where("from_username = ? OR to_username = ?", username, username).
+		Order("created_at DESC, id DESC").Limit(limit).Find(&messages).Error
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, err
+}
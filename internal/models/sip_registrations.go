@@ -0,0 +1,94 @@
+package models
+
+import (
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/constants"
+	"gorm.io/gorm"
+)
+
+// SipRegistration SIP用户的注册绑定表，同一用户的多个终端各有一条，按Contact URI区分
+type SipRegistration struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+
+	Username    string    `json:"username" gorm:"size:128;not null;uniqueIndex:idx_sip_registration_binding"` // SIP用户名
+	Contact     string    `json:"contact" gorm:"size:256;not null;uniqueIndex:idx_sip_registration_binding"`  // Contact地址（完整URI）
+	ContactIP   string    `json:"contactIp" gorm:"size:64"`                                                   // Contact IP地址
+	ContactPort int       `json:"contactPort"`                                                                // Contact端口
+	Q           float64   `json:"q" gorm:"default:1"`                                                         // 优先级（q值），高的先呼叫
+	ExpiresAt   time.Time `json:"expiresAt" gorm:"index"`                                                     // 过期时间点
+	UserAgent   string    `json:"userAgent,omitempty" gorm:"size:256"`                                        // 用户代理（User-Agent）
+	RemoteIP    string    `json:"remoteIp,omitempty" gorm:"size:64"`                                          // 远程IP地址
+}
+
+// TableName 指定表名
+func (SipRegistration) TableName() string {
+	return constants.TABLE_SIP_REGISTRATIONS
+}
+
+// SaveSipRegistration 保存注册绑定，同一用户同一Contact的绑定会被刷新
+func SaveSipRegistration(db *gorm.DB, registration *SipRegistration) error {
+	return db.Where("username = ? AND contact = ?", registration.Username, registration.Contact).
+		Assign(SipRegistration{
+			ContactIP:   registration.ContactIP,
+			ContactPort: registration.ContactPort,
+			Q:           registration.Q,
+			ExpiresAt:   registration.ExpiresAt,
+			UserAgent:   registration.UserAgent,
+			RemoteIP:    registration.RemoteIP,
+		}).
+		FirstOrCreate(registration).Error
+}
+
+// DeleteSipRegistration 删除用户的一个注册绑定
+func DeleteSipRegistration(db *gorm.DB, username, contact string) error {
+	return db.Where("username = ? AND contact = ?", username, contact).Delete(&SipRegistration{}).Error
+}
+
+// DeleteSipRegistrations 删除用户的所有注册绑定
+func DeleteSipRegistrations(db *gorm.DB, username string) error {
+	return db.Where("username = ?", username).Delete(&SipRegistration{}).Error
+}
+
+// GetSipRegistrations 获取用户未过期的注册绑定，q值高的在前
+func GetSipRegistrations(db *gorm.DB, username string, now time.Time) ([]SipRegistration, error) {
+	var registrations []SipRegistration
+	err := db.Where("username = ? AND expires_at > ?", username, now).
+		Order("q DESC, updated_at DESC").
+		Find(&registrations).Error
+	return registrations, err
+}
+
+// GetActiveSipRegistrations 获取所有未过期的注册绑定，按用户名和q值排序
+func GetActiveSipRegistrations(db *gorm.DB, now time.Time) ([]SipRegistration, error) {
+	var registrations []SipRegistration
+	err := db.Where("expires_at > ?", now).
+		Order("username, q DESC, updated_at DESC").
+		Find(&registrations).Error
+	return registrations, err
+}
+
+// DeleteExpiredSipRegistrations 删除过期时间早于now的注册绑定并返回它们
+func DeleteExpiredSipRegistrations(db *gorm.DB, now time.Time) ([]SipRegistration, error) {
+	var registrations []SipRegistration
+	if err := db.Where("expires_at <= ?", now).Find(&registrations).Error; err != nil || len(registrations) == 0 {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(registrations))
+	for _, registration := range registrations {
+		ids = append(ids, registration.ID)
+	}
+	if err := db.Delete(&SipRegistration{}, ids).Error; err != nil {
+		return nil, err
+	}
+	return registrations, nil
+}
+
+// CountSipRegistrations 统计用户未过期的注册绑定数
+func CountSipRegistrations(db *gorm.DB, username string, now time.Time) (int64, error) {
+	var count int64
+	err := db.Model(&SipRegistration{}).Where("username = ? AND expires_at > ?", username, now).Count(&count).Error
+	return count, err
+}
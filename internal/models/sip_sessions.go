@@ -71,6 +71,21 @@ func DeleteSipSessionByCallID(db *gorm.DB, callID string) error {
 	return db.Where("call_id = ?", callID).Delete(&SipSession{}).Error
 }
 
+// DeletePendingSipSessionsBefore 删除创建时间早于before仍在等待ACK的会话，返回被删除会话的CallID
+func DeletePendingSipSessionsBefore(db *gorm.DB, before time.Time) ([]string, error) {
+	var callIDs []string
+	err := db.Model(&SipSession{}).
+		Where("status = ? AND created_time < ?", SipSessionStatusPending, before).
+		Pluck("call_id", &callIDs).Error
+	if err != nil || len(callIDs) == 0 {
+		return nil, err
+	}
+	if err := db.Where("call_id IN ?", callIDs).Delete(&SipSession{}).Error; err != nil {
+		return nil, err
+	}
+	return callIDs, nil
+}
+
 // GetActiveSipSessions 获取所有活跃的会话
 func GetActiveSipSessions(db *gorm.DB) ([]SipSession, error) {
 	var sessions []SipSession
@@ -3,6 +3,8 @@ package models
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/LingByte/LingSIP/pkg/constants"
@@ -28,6 +30,23 @@ const (
 	SIPTrunkProviderCustom  SIPTrunkProvider = "custom"  // 自定义
 )
 
+// SIPTrunkAuthMode SIP中继认证方式
+type SIPTrunkAuthMode string
+
+const (
+	SIPTrunkAuthRegister SIPTrunkAuthMode = "register" // 账号注册认证
+	SIPTrunkAuthIP       SIPTrunkAuthMode = "ip"       // 源IP白名单认证，无需注册
+)
+
+// ComfortNoiseMode 监听用户输入期间的舒适噪音方式
+type ComfortNoiseMode string
+
+const (
+	ComfortNoiseNone  ComfortNoiseMode = "none"  // 不发送
+	ComfortNoiseAudio ComfortNoiseMode = "noise" // 用通话编码发送低电平白噪音
+	ComfortNoiseCN    ComfortNoiseMode = "cn"    // 发送RFC 3389 CN包，对端未协商CN时退回noise
+)
+
 // CodecConfig 编解码器配置
 type CodecConfig struct {
 	Name     string `json:"name"`     // 编解码器名称 (PCMU, PCMA, G722, etc.)
@@ -91,6 +110,34 @@ func (pn *PhoneNumbers) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, pn)
 }
 
+// IPRanges IP或CIDR列表
+type IPRanges []string
+
+// Value 实现 driver.Valuer 接口
+func (r IPRanges) Value() (driver.Value, error) {
+	if r == nil || len(r) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(r)
+}
+
+// Scan 实现 sql.Scanner 接口
+func (r *IPRanges) Scan(value interface{}) error {
+	if value == nil {
+		*r = make(IPRanges, 0)
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	if len(bytes) == 0 {
+		*r = make(IPRanges, 0)
+		return nil
+	}
+	return json.Unmarshal(bytes, r)
+}
+
 // SIPTrunk SIP中继配置表
 type SIPTrunk struct {
 	ID        uint       `json:"id" gorm:"primaryKey"`
@@ -115,6 +162,10 @@ type SIPTrunk struct {
 	Password string `json:"-" gorm:"size:256"`                  // 认证密码（加密存储）
 	AuthName string `json:"authName,omitempty" gorm:"size:128"` // 认证名称（如果与用户名不同）
 
+	// IP认证（运营商按源地址下发DID，不注册）
+	AuthMode       SIPTrunkAuthMode `json:"authMode" gorm:"size:20;default:'register'"` // 认证方式: register, ip
+	AllowedSources IPRanges         `json:"allowedSources,omitempty" gorm:"type:json"`  // 允许的来源IP/CIDR/主机名，为空时使用SIP服务器地址
+
 	// 号码配置
 	PhoneNumbers PhoneNumbers `json:"phoneNumbers" gorm:"type:json"`     // 分配的电话号码列表
 	CallerID     string       `json:"callerId,omitempty" gorm:"size:32"` // 默认主叫显示号码
@@ -136,11 +187,21 @@ type SIPTrunk struct {
 	CallTimeout        int `json:"callTimeout" gorm:"default:30"`        // 呼叫超时（秒）
 	RegisterInterval   int `json:"registerInterval" gorm:"default:3600"` // 注册间隔（秒）
 
+	// 计费配置
+	RatePerMinute    float64 `json:"ratePerMinute" gorm:"default:0"`     // 每分钟通话费率
+	BillingIncrement int     `json:"billingIncrement" gorm:"default:60"` // 计费步长（秒）
+
 	// 质量配置
 	JitterBuffer   int  `json:"jitterBuffer" gorm:"default:50"`     // 抖动缓冲区大小（ms）
 	EchoCancel     bool `json:"echoCancel" gorm:"default:true"`     // 回声消除
 	NoiseReduction bool `json:"noiseReduction" gorm:"default:true"` // 噪声抑制
 
+	// 舒适噪音，避免监听期间无媒体导致运营商拆线或主叫以为断线
+	ComfortNoise ComfortNoiseMode `json:"comfortNoise" gorm:"size:16;default:'noise'"`
+
+	// ASR模型：按协商的采样率选择，经该中继的通话在脚本未配置时使用
+	ASRModels ASRModelSelection `json:"asrModels" gorm:"type:json"`
+
 	// 统计信息
 	TotalCalls   int        `json:"totalCalls" gorm:"default:0"`   // 总呼叫数
 	SuccessCalls int        `json:"successCalls" gorm:"default:0"` // 成功呼叫数
@@ -167,6 +228,57 @@ func (st *SIPTrunk) IsActive() bool {
 	return st.Status == SIPTrunkStatusActive && st.Enabled
 }
 
+// UsesIPAuth 是否使用源IP认证
+func (st *SIPTrunk) UsesIPAuth() bool {
+	return st.AuthMode == SIPTrunkAuthIP
+}
+
+// MatchesSource 检查来源IP是否属于该中继；既不是IP也不是CIDR的条目按主机名处理，
+// 由resolve解析（通常带缓存），resolve为nil时主机名不匹配
+func (st *SIPTrunk) MatchesSource(ip net.IP, resolve func(host string) []net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, source := range st.Sources() {
+		if strings.Contains(source, "/") {
+			if _, ipNet, err := net.ParseCIDR(source); err == nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if allowed := net.ParseIP(source); allowed != nil {
+			if allowed.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if resolve == nil {
+			continue
+		}
+		for _, resolved := range resolve(source) {
+			if resolved.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Sources 允许的来源列表，AllowedSources为空时使用SIP服务器地址
+func (st *SIPTrunk) Sources() []string {
+	sources := []string(st.AllowedSources)
+	if len(sources) == 0 {
+		sources = []string{st.SIPServer}
+	}
+	result := make([]string, 0, len(sources))
+	for _, source := range sources {
+		if source = strings.TrimSpace(source); source != "" {
+			result = append(result, source)
+		}
+	}
+	return result
+}
+
 // GetPhoneNumber 获取指定的电话号码，如果不存在则返回第一个
 func (st *SIPTrunk) GetPhoneNumber(number string) string {
 	if number != "" {
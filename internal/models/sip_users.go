@@ -1,11 +1,15 @@
 package models
 
 import (
+	"crypto/md5"
+	"crypto/subtle"
 	"database/sql/driver"
+	"encoding/hex"
 	"encoding/json"
 	"time"
 
 	"github.com/LingByte/LingSIP/pkg/constants"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -61,6 +65,46 @@ func (kr *KeywordReplies) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, kr)
 }
 
+// CallForwarding 呼叫前转规则。目标可以是注册用户名、号码或SIP地址，"script"表示转入被叫号码的AI脚本，
+// "script:<号码>"转入该号码的AI脚本，用作语音信箱
+type CallForwarding struct {
+	Always          string `json:"always,omitempty"`          // 无条件前转，不振铃本用户
+	Busy            string `json:"busy,omitempty"`            // 遇忙（486/600）前转
+	NoAnswer        string `json:"noAnswer,omitempty"`        // 无应答前转
+	Unreachable     string `json:"unreachable,omitempty"`     // 未注册、联系地址不可达或呼叫失败时前转
+	NoAnswerTimeout int    `json:"noAnswerTimeout,omitempty"` // 振铃本用户多久（秒）算无应答，0使用默认值
+	ForwardTimeout  int    `json:"forwardTimeout,omitempty"`  // 前转目标的最长振铃时间（秒），0使用默认值
+}
+
+// IsZero 是否没有配置任何前转
+func (cf CallForwarding) IsZero() bool {
+	return cf.Always == "" && cf.Busy == "" && cf.NoAnswer == "" && cf.Unreachable == ""
+}
+
+// Value 实现 driver.Valuer 接口
+func (cf CallForwarding) Value() (driver.Value, error) {
+	if cf == (CallForwarding{}) {
+		return nil, nil
+	}
+	return json.Marshal(cf)
+}
+
+// Scan 实现 sql.Scanner 接口
+func (cf *CallForwarding) Scan(value interface{}) error {
+	*cf = CallForwarding{}
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	}
+	if len(bytes) == 0 {
+		return nil
+	}
+	return json.Unmarshal(bytes, cf)
+}
+
 // SipUser SIP用户表（代接方案）
 type SipUser struct {
 	ID        uint       `json:"id" gorm:"primaryKey"`
@@ -74,7 +118,12 @@ type SipUser struct {
 
 	// ========== SIP认证信息 ==========
 	Username string `json:"username" gorm:"size:128;uniqueIndex;not null"` // SIP用户名（唯一）
-	Password string `json:"-" gorm:"size:128"`                             // SIP密码（可选，用于认证）
+	Password string `json:"-" gorm:"size:128"`                             // SIP明文密码（已弃用，设置密码时清空，见SetPassword）
+
+	// 只保存密码哈希：bcrypt用于校验密码，HA1(MD5(username:realm:password))用于摘要认证
+	PasswordHash string `json:"-" gorm:"size:128"`
+	DigestHA1    string `json:"-" gorm:"size:32"`
+	DigestRealm  string `json:"-" gorm:"size:128"` // 计算HA1时的认证域，认证域变更后需重新设置密码
 
 	// ========== SIP注册信息 ==========
 	Contact     string     `json:"contact,omitempty" gorm:"size:256"`  // Contact地址（完整URI）
@@ -88,6 +137,11 @@ type SipUser struct {
 	LastRegister   *time.Time    `json:"lastRegister,omitempty"`                             // 最后注册时间
 	LastUnregister *time.Time    `json:"lastUnregister,omitempty"`                           // 最后注销时间
 
+	// ========== 可达性（OPTIONS探测） ==========
+	Reachable     bool       `json:"reachable" gorm:"default:true"`  // Contact是否可达
+	LastProbeAt   *time.Time `json:"lastProbeAt,omitempty"`          // 最后探测时间
+	ProbeFailures int        `json:"probeFailures" gorm:"default:0"` // 连续探测失败次数
+
 	// ========== 客户端信息 ==========
 	UserAgent         string         `json:"userAgent,omitempty" gorm:"size:256"`             // 用户代理（User-Agent）
 	RemoteIP          string         `json:"remoteIp,omitempty" gorm:"size:64"`               // 远程IP地址
@@ -105,6 +159,7 @@ type SipUser struct {
 	MessageDuration   int            `json:"messageDuration" gorm:"default:20"`               // 留言时长（秒，默认20秒）
 	MessagePrompt     string         `json:"messagePrompt,omitempty" gorm:"type:text"`        // 留言提示语（如"请在嘀声后留言"）
 	BoundPhoneNumber  string         `json:"boundPhoneNumber,omitempty" gorm:"size:20;index"` // 绑定的手机号（被叫号码）
+	Forwarding        CallForwarding `json:"forwarding" gorm:"type:json"`                     // 呼叫前转规则
 	DisplayName       string         `json:"displayName,omitempty" gorm:"size:128"`           // 显示名称
 	Alias             string         `json:"alias,omitempty" gorm:"size:128"`                 // 别名
 	RegisterCount     int            `json:"registerCount" gorm:"default:0"`                  // 注册次数
@@ -135,6 +190,44 @@ func (su *SipUser) IsExpired() bool {
 	return time.Now().After(*su.ExpiresAt)
 }
 
+// SetPassword 设置密码：保存bcrypt哈希和realm下的摘要认证HA1，清空旧的明文密码
+func (su *SipUser) SetPassword(password, realm string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	su.PasswordHash = string(hash)
+	su.DigestHA1 = DigestHA1(su.Username, realm, password)
+	su.DigestRealm = realm
+	su.Password = ""
+	return nil
+}
+
+// CheckPassword 校验密码，兼容尚未设置哈希的旧明文密码
+func (su *SipUser) CheckPassword(password string) bool {
+	if su.PasswordHash != "" {
+		return bcrypt.CompareHashAndPassword([]byte(su.PasswordHash), []byte(password)) == nil
+	}
+	return su.Password != "" && subtle.ConstantTimeCompare([]byte(su.Password), []byte(password)) == 1
+}
+
+// DigestCredentials 返回realm下摘要认证使用的HA1，没有可用凭据时返回false
+func (su *SipUser) DigestCredentials(realm string) (string, bool) {
+	if su.DigestHA1 != "" && su.DigestRealm == realm {
+		return su.DigestHA1, true
+	}
+	if su.PasswordHash == "" && su.Password != "" {
+		return DigestHA1(su.Username, realm, su.Password), true
+	}
+	return "", false
+}
+
+// DigestHA1 摘要认证的HA1 = MD5(username:realm:password)（RFC 2617 3.2.2.2）
+func DigestHA1(username, realm, password string) string {
+	sum := md5.Sum([]byte(username + ":" + realm + ":" + password))
+	return hex.EncodeToString(sum[:])
+}
+
 // UpdateExpiresAt 根据Expires字段更新过期时间
 func (su *SipUser) UpdateExpiresAt() {
 	if su.Expires > 0 {
@@ -158,6 +251,21 @@ func GetSipUserByUsername(db *gorm.DB, username string) (*SipUser, error) {
 	return &sipUser, nil
 }
 
+// ListSipUsers 按ID顺序分页列出SIP用户，enabled非nil时只列出对应启用状态的用户
+func ListSipUsers(db *gorm.DB, enabled *bool, offset, limit int) ([]SipUser, int64, error) {
+	query := db.Model(&SipUser{})
+	if enabled != nil {
+		query = query.Where("enabled = ?", *enabled)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var sipUsers []SipUser
+	err := query.Order("id ASC").Offset(offset).Limit(limit).Find(&sipUsers).Error
+	return sipUsers, total, err
+}
+
 // GetSipUserByID 根据ID获取SIP用户
 func GetSipUserByID(db *gorm.DB, id uint) (*SipUser, error) {
 	var sipUser SipUser
@@ -185,6 +293,36 @@ func GetRegisteredSipUsers(db *gorm.DB) ([]SipUser, error) {
 	return sipUsers, err
 }
 
+// ExpireSipUserRegistrations 将过期时间早于now的已注册用户标记为已过期，返回这些用户名
+func ExpireSipUserRegistrations(db *gorm.DB, now time.Time) ([]string, error) {
+	var usernames []string
+	err := db.Model(&SipUser{}).
+		Where("status = ? AND expires_at < ?", SipUserStatusRegistered, now).
+		Pluck("username", &usernames).Error
+	if err != nil || len(usernames) == 0 {
+		return nil, err
+	}
+	err = db.Model(&SipUser{}).
+		Where("username IN ? AND status = ?", usernames, SipUserStatusRegistered).
+		Update("status", SipUserStatusExpired).Error
+	if err != nil {
+		return nil, err
+	}
+	return usernames, nil
+}
+
+// UpdateSipUserReachability 更新SIP用户的可达性探测结果
+func UpdateSipUserReachability(db *gorm.DB, username string, reachable bool, failures int) error {
+	now := time.Now()
+	return db.Model(&SipUser{}).
+		Where("username = ?", username).
+		Updates(map[string]interface{}{
+			"reachable":      reachable,
+			"last_probe_at":  &now,
+			"probe_failures": failures,
+		}).Error
+}
+
 // GetSipUsersByUserID 根据系统用户ID获取SIP用户列表（代接方案列表）
 func GetSipUsersByUserID(db *gorm.DB, userID uint) ([]SipUser, error) {
 	var sipUsers []SipUser
@@ -12,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/LingByte/LingSIP/pkg/auth"
 	"github.com/LingByte/LingSIP/pkg/constants"
 	"github.com/LingByte/LingSIP/pkg/response"
 	"github.com/gin-gonic/gin"
@@ -199,29 +200,29 @@ func (obj *WebObject) RegisterObject(r *gin.RouterGroup) error {
 
 	primaryKeyPath := obj.BuildPrimaryPath(p)
 	if allowMethods&GET != 0 {
-		r.GET(primaryKeyPath, func(c *gin.Context) {
+		r.GET(primaryKeyPath, obj.checkAuth, func(c *gin.Context) {
 			handleGetObject(c, obj)
 		})
 	}
 	if allowMethods&CREATE != 0 {
-		r.PUT(p, func(c *gin.Context) {
+		r.PUT(p, obj.checkAuth, func(c *gin.Context) {
 			handleCreateObject(c, obj)
 		})
 	}
 	if allowMethods&EDIT != 0 {
-		r.PATCH(primaryKeyPath, func(c *gin.Context) {
+		r.PATCH(primaryKeyPath, obj.checkAuth, func(c *gin.Context) {
 			handleEditObject(c, obj)
 		})
 	}
 
 	if allowMethods&DELETE != 0 {
-		r.DELETE(primaryKeyPath, func(c *gin.Context) {
+		r.DELETE(primaryKeyPath, obj.checkAuth, func(c *gin.Context) {
 			handleDeleteObject(c, obj)
 		})
 	}
 
 	if allowMethods&QUERY != 0 {
-		r.POST(p, func(c *gin.Context) {
+		r.POST(p, obj.checkAuth, func(c *gin.Context) {
 			handleQueryObject(c, obj, obj.PrepareQuery)
 		})
 	}
@@ -234,7 +235,7 @@ func (obj *WebObject) RegisterObject(r *gin.RouterGroup) error {
 		if v.Method == "" {
 			v.Method = http.MethodPost
 		}
-		r.Handle(v.Method, filepath.Join(p, v.Path), func(ctx *gin.Context) {
+		r.Handle(v.Method, filepath.Join(p, v.Path), obj.checkAuth, func(ctx *gin.Context) {
 			handleQueryObject(ctx, obj, v.Prepare)
 		})
 	}
@@ -242,6 +243,15 @@ func (obj *WebObject) RegisterObject(r *gin.RouterGroup) error {
 	return nil
 }
 
+// checkAuth rejects requests to an AuthRequired object that were not authenticated by auth.RequireAdmin
+func (obj *WebObject) checkAuth(c *gin.Context) {
+	if obj.AuthRequired && auth.CurrentActor(c) == "" {
+		AbortWithJSONError(c, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	c.Next()
+}
+
 func (obj *WebObject) BuildPrimaryPath(prefix string) string {
 	var primaryKeyPath []string
 	for _, v := range obj.uniqueKeys {
@@ -0,0 +1,21 @@
+package LingSIP
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWebObjectAuthRequired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	obj := &WebObject{AuthRequired: true}
+	router := gin.New()
+	router.GET("/objects", obj.checkAuth, func(c *gin.Context) { c.Status(http.StatusOK) })
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/objects", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d without RequireAdmin", w.Code, http.StatusUnauthorized)
+	}
+}
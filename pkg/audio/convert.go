@@ -0,0 +1,73 @@
+package audio
+
+import (
+	"github.com/LingByte/LingSIP/pkg/media"
+)
+
+// SamplesToBytes converts 16-bit samples to little-endian bytes
+func SamplesToBytes(samples []int16) []byte {
+	data := make([]byte, len(samples)*2)
+	for i, sample := range samples {
+		data[i*2] = byte(sample)
+		data[i*2+1] = byte(sample >> 8)
+	}
+	return data
+}
+
+// BytesToSamples converts little-endian bytes to 16-bit samples, ignoring a trailing odd byte
+func BytesToSamples(data []byte) []int16 {
+	samples := make([]int16, len(data)/2)
+	for i := range samples {
+		samples[i] = int16(data[i*2]) | int16(data[i*2+1])<<8
+	}
+	return samples
+}
+
+// ToMono averages interleaved channels down to one channel
+func ToMono(samples []int16, channels int) []int16 {
+	if channels <= 1 {
+		return samples
+	}
+	mono := make([]int16, len(samples)/channels)
+	for i := range mono {
+		var sum int
+		for c := 0; c < channels; c++ {
+			sum += int(samples[i*channels+c])
+		}
+		mono[i] = int16(sum / channels)
+	}
+	return mono
+}
+
+// SplitChannels de-interleaves samples into one mono slice per channel
+func SplitChannels(samples []int16, channels int) [][]int16 {
+	if channels <= 1 {
+		return [][]int16{samples}
+	}
+	split := make([][]int16, channels)
+	frames := len(samples) / channels
+	for c := range split {
+		split[c] = make([]int16, frames)
+		for i := 0; i < frames; i++ {
+			split[c][i] = samples[i*channels+c]
+		}
+	}
+	return split
+}
+
+// Resample converts mono samples between sample rates
+func Resample(samples []int16, inputRate, outputRate int) ([]int16, error) {
+	if inputRate == outputRate {
+		return samples, nil
+	}
+	data, err := media.ResamplePCM(SamplesToBytes(samples), inputRate, outputRate)
+	if err != nil {
+		return nil, err
+	}
+	return BytesToSamples(data), nil
+}
+
+// Convert converts samples in format f to mono at sampleRate
+func Convert(f Format, samples []int16, sampleRate int) ([]int16, error) {
+	return Resample(ToMono(samples, f.Channels), f.SampleRate, sampleRate)
+}
@@ -0,0 +1,226 @@
+package audio
+
+import "math"
+
+const (
+	// loudnessBlock and loudnessStep are the BS.1770 gating block length and hop in seconds
+	loudnessBlock = 0.4
+	loudnessStep  = 0.1
+	// loudnessAbsoluteGate drops silent blocks, loudnessRelativeGate blocks quieter than the ungated mean
+	loudnessAbsoluteGate = -70.0
+	loudnessRelativeGate = -10.0
+)
+
+// biquad is a direct form I second-order filter
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeighting returns the BS.1770 pre-filter (high shelf) and RLB high-pass filter for sampleRate,
+// designed by bilinear transform so they match the published 48 kHz coefficients
+func kWeighting(sampleRate int) (*biquad, *biquad) {
+	fs := float64(sampleRate)
+
+	// High shelf: +4 dB above about 1.7 kHz
+	k := math.Tan(math.Pi * 1681.974450955533 / fs)
+	q := 0.7071752369554196
+	vh := math.Pow(10, 3.999843853973347/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := &biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	// High pass at about 38 Hz
+	k = math.Tan(math.Pi * 38.13547087602444 / fs)
+	q = 0.5003270373238773
+	a0 = 1 + k/q + k*k
+	highPass := &biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return shelf, highPass
+}
+
+// Loudness returns the integrated loudness of mono samples in LUFS (ITU-R BS.1770 with gating).
+// Audio shorter than one block is measured as a single block; silence returns -Inf.
+func Loudness(samples []int16, sampleRate int) float64 {
+	if len(samples) == 0 || sampleRate <= 0 {
+		return math.Inf(-1)
+	}
+	shelf, highPass := kWeighting(sampleRate)
+	power := make([]float64, len(samples))
+	for i, sample := range samples {
+		y := highPass.process(shelf.process(float64(sample) / 32768))
+		power[i] = y * y
+	}
+
+	blockSize := int(loudnessBlock * float64(sampleRate))
+	stepSize := int(loudnessStep * float64(sampleRate))
+	blockSize = min(blockSize, len(samples))
+	var blocks []float64
+	for start := 0; start+blockSize <= len(samples); start += stepSize {
+		var sum float64
+		for _, p := range power[start : start+blockSize] {
+			sum += p
+		}
+		blocks = append(blocks, sum/float64(blockSize))
+	}
+
+	gated := gateBlocks(blocks, math.Inf(-1), loudnessAbsoluteGate)
+	if len(gated) == 0 {
+		return math.Inf(-1)
+	}
+	relative := blockLoudness(mean(gated)) + loudnessRelativeGate
+	gated = gateBlocks(gated, relative, loudnessAbsoluteGate)
+	if len(gated) == 0 {
+		return math.Inf(-1)
+	}
+	return blockLoudness(mean(gated))
+}
+
+// Normalize scales samples in place so their integrated loudness reaches targetLUFS, lowering the gain
+// if the peak would exceed ceiling (0 uses 30000), and returns the applied gain; silence is left untouched
+func Normalize(samples []int16, sampleRate int, targetLUFS float64, ceiling int) float64 {
+	loudness := Loudness(samples, sampleRate)
+	if math.IsInf(loudness, -1) {
+		return 1
+	}
+	if ceiling <= 0 || ceiling > math.MaxInt16 {
+		ceiling = 30000
+	}
+	gain := math.Pow(10, (targetLUFS-loudness)/20)
+
+	var peak float64
+	for _, sample := range samples {
+		peak = max(peak, math.Abs(float64(sample)))
+	}
+	if peak*gain > float64(ceiling) {
+		gain = float64(ceiling) / peak
+	}
+	if gain == 1 {
+		return gain
+	}
+	for i, sample := range samples {
+		value := math.Round(float64(sample) * gain)
+		samples[i] = int16(max(min(value, float64(ceiling)), -float64(ceiling)))
+	}
+	return gain
+}
+
+// StreamNormalizer applies Normalize to audio that arrives in pieces, such as streaming TTS. The first
+// gating block is buffered and normalized as a whole; its gain is then held for the rest of the stream,
+// with samples limited to the ceiling. Leading silence is passed through and measuring starts at the
+// first audible block, so playback starts at most one block (400 ms) later than without normalizing.
+type StreamNormalizer struct {
+	sampleRate int
+	targetLUFS float64
+	ceiling    int
+	window     int
+
+	buffered []int16
+	gain     float64
+	measured bool
+}
+
+// NewStreamNormalizer creates a normalizer for mono samples at sampleRate, see Normalize for the parameters
+func NewStreamNormalizer(sampleRate int, targetLUFS float64, ceiling int) *StreamNormalizer {
+	if ceiling <= 0 || ceiling > math.MaxInt16 {
+		ceiling = 30000
+	}
+	return &StreamNormalizer{
+		sampleRate: sampleRate,
+		targetLUFS: targetLUFS,
+		ceiling:    ceiling,
+		window:     int(loudnessBlock * float64(sampleRate)),
+		gain:       1,
+	}
+}
+
+// Process scales samples in place and returns the samples ready to play, which are empty while the first
+// block is being buffered
+func (n *StreamNormalizer) Process(samples []int16) []int16 {
+	if n.measured {
+		n.apply(samples)
+		return samples
+	}
+	n.buffered = append(n.buffered, samples...)
+	if len(n.buffered) < n.window {
+		return nil
+	}
+	return n.measure(false)
+}
+
+// Flush returns the buffered samples normalized, for streams that end within the first block
+func (n *StreamNormalizer) Flush() []int16 {
+	if n.measured {
+		return nil
+	}
+	return n.measure(true)
+}
+
+// Gain returns the held gain, 1 until it is measured
+func (n *StreamNormalizer) Gain() float64 {
+	return n.gain
+}
+
+// measure fixes the gain on the buffered samples; a silent block is released as is unless final
+func (n *StreamNormalizer) measure(final bool) []int16 {
+	ready := n.buffered
+	n.buffered = nil
+	if !final && math.IsInf(Loudness(ready, n.sampleRate), -1) {
+		return ready
+	}
+	n.measured = true
+	n.gain = Normalize(ready, n.sampleRate, n.targetLUFS, n.ceiling)
+	return ready
+}
+
+func (n *StreamNormalizer) apply(samples []int16) {
+	if n.gain == 1 {
+		return
+	}
+	ceiling := float64(n.ceiling)
+	for i, sample := range samples {
+		value := math.Round(float64(sample) * n.gain)
+		samples[i] = int16(max(min(value, ceiling), -ceiling))
+	}
+}
+
+func blockLoudness(power float64) float64 {
+	return -0.691 + 10*math.Log10(power)
+}
+
+// gateBlocks keeps the blocks louder than both thresholds
+func gateBlocks(blocks []float64, relative, absolute float64) []float64 {
+	var kept []float64
+	for _, power := range blocks {
+		if l := blockLoudness(power); l > absolute && l > relative {
+			kept = append(kept, power)
+		}
+	}
+	return kept
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
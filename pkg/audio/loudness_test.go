@@ -0,0 +1,75 @@
+package audio
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sine(sampleRate int, freq, amplitude float64, seconds float64) []int16 {
+	samples := make([]int16, int(seconds*float64(sampleRate)))
+	for i := range samples {
+		samples[i] = int16(amplitude * 32767 * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate)))
+	}
+	return samples
+}
+
+func TestLoudness_Sine(t *testing.T) {
+	// A 1 kHz sine at -6 dBFS peak measures about -9 LUFS
+	assert.InDelta(t, -9.0, Loudness(sine(48000, 1000, 0.5, 2), 48000), 0.2)
+	assert.InDelta(t, -9.0, Loudness(sine(8000, 1000, 0.5, 2), 8000), 0.5)
+	assert.True(t, math.IsInf(Loudness(make([]int16, 8000), 8000), -1))
+}
+
+func TestNormalize_ReachesTarget(t *testing.T) {
+	samples := sine(8000, 440, 0.05, 2)
+	gain := Normalize(samples, 8000, -18, 0)
+	assert.Greater(t, gain, 1.0)
+	assert.InDelta(t, -18, Loudness(samples, 8000), 0.2)
+}
+
+func TestNormalize_LimitsPeak(t *testing.T) {
+	samples := sine(8000, 440, 0.5, 1)
+	Normalize(samples, 8000, 0, 20000)
+	for _, sample := range samples {
+		assert.LessOrEqual(t, math.Abs(float64(sample)), 20000.0)
+	}
+
+	silence := make([]int16, 800)
+	assert.Equal(t, 1.0, Normalize(silence, 8000, -18, 0))
+}
+
+func TestStreamNormalizer_MatchesNormalize(t *testing.T) {
+	whole := sine(8000, 440, 0.05, 2)
+	wantGain := Normalize(append([]int16(nil), whole[:3200]...), 8000, -18, 0)
+
+	n := NewStreamNormalizer(8000, -18, 0)
+	var played []int16
+	for start := 0; start < len(whole); start += 160 {
+		played = append(played, n.Process(append([]int16(nil), whole[start:start+160]...))...)
+	}
+	played = append(played, n.Flush()...)
+	assert.Len(t, played, len(whole))
+	assert.InDelta(t, wantGain, n.Gain(), 1e-9)
+	assert.InDelta(t, -18, Loudness(played, 8000), 0.3)
+}
+
+func TestStreamNormalizer_SilenceAndShortStreams(t *testing.T) {
+	// leading silence passes through and the gain is measured on the speech after it
+	n := NewStreamNormalizer(8000, -18, 20000)
+	assert.Len(t, n.Process(make([]int16, 3200)), 3200)
+	assert.Equal(t, 1.0, n.Gain())
+	n.Process(sine(8000, 440, 0.05, 0.4))
+	assert.Greater(t, n.Gain(), 1.0)
+	for _, sample := range n.Process(sine(8000, 440, 0.9, 0.1)) {
+		assert.LessOrEqual(t, math.Abs(float64(sample)), 20000.0)
+	}
+
+	// a stream shorter than one block is normalized on Flush
+	short := NewStreamNormalizer(8000, -18, 0)
+	assert.Empty(t, short.Process(sine(8000, 440, 0.05, 0.2)))
+	assert.Len(t, short.Flush(), 1600)
+	assert.Greater(t, short.Gain(), 1.0)
+	assert.Empty(t, short.Flush())
+}
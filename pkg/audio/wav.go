@@ -0,0 +1,245 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// HeaderSize is the size of the canonical 44-byte PCM WAV header written by this package
+const HeaderSize = 44
+
+var (
+	ErrNotWAV            = errors.New("not a RIFF/WAVE file")
+	ErrUnsupportedFormat = errors.New("unsupported WAV format")
+)
+
+// Format describes 16-bit PCM audio
+type Format struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+}
+
+// Mono16 returns a 16-bit mono format at the given sample rate
+func Mono16(sampleRate int) Format {
+	return Format{SampleRate: sampleRate, Channels: 1, BitsPerSample: 16}
+}
+
+// Validate checks the format is 16-bit PCM with 1-2 channels at 8-48 kHz
+func (f Format) Validate() error {
+	if f.BitsPerSample != 16 {
+		return fmt.Errorf("%w: %d bits per sample", ErrUnsupportedFormat, f.BitsPerSample)
+	}
+	if f.Channels < 1 || f.Channels > 2 {
+		return fmt.Errorf("%w: %d channels", ErrUnsupportedFormat, f.Channels)
+	}
+	if f.SampleRate < 8000 || f.SampleRate > 48000 {
+		return fmt.Errorf("%w: %d Hz", ErrUnsupportedFormat, f.SampleRate)
+	}
+	return nil
+}
+
+func (f Format) blockAlign() int {
+	return f.Channels * f.BitsPerSample / 8
+}
+
+// Header builds a WAV header for dataSize bytes of PCM
+func Header(f Format, dataSize uint32) []byte {
+	header := make([]byte, HeaderSize)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], 36+dataSize)
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16) // fmt chunk size
+	binary.LittleEndian.PutUint16(header[20:22], 1)  // PCM
+	binary.LittleEndian.PutUint16(header[22:24], uint16(f.Channels))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(f.SampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(f.SampleRate*f.blockAlign()))
+	binary.LittleEndian.PutUint16(header[32:34], uint16(f.blockAlign()))
+	binary.LittleEndian.PutUint16(header[34:36], uint16(f.BitsPerSample))
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], dataSize)
+	return header
+}
+
+// Encode returns a complete WAV file for the samples
+func Encode(f Format, samples []int16) []byte {
+	return append(Header(f, uint32(len(samples)*2)), SamplesToBytes(samples)...)
+}
+
+// WriteFile writes samples as a WAV file in one write
+func WriteFile(filename string, f Format, samples []int16) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, Encode(f, samples), 0644)
+}
+
+// Read parses a PCM WAV stream, skipping non-audio chunks such as LIST.
+// Samples are interleaved when the file has more than one channel.
+func Read(r io.Reader) (Format, []int16, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return Format{}, nil, fmt.Errorf("failed to read WAV header: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return Format{}, nil, ErrNotWAV
+	}
+
+	var format Format
+	var haveFormat bool
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return Format{}, nil, fmt.Errorf("failed to read WAV chunk: %w", err)
+		}
+		id := string(chunk[0:4])
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return Format{}, nil, fmt.Errorf("%w: fmt chunk too small", ErrUnsupportedFormat)
+			}
+			body := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return Format{}, nil, fmt.Errorf("failed to read fmt chunk: %w", err)
+			}
+			// 1 = PCM, 0xFFFE = WAVE_FORMAT_EXTENSIBLE
+			if audioFormat := binary.LittleEndian.Uint16(body[0:2]); audioFormat != 1 && audioFormat != 0xFFFE {
+				return Format{}, nil, fmt.Errorf("%w: audio format %d", ErrUnsupportedFormat, audioFormat)
+			}
+			format = Format{
+				Channels:      int(binary.LittleEndian.Uint16(body[2:4])),
+				SampleRate:    int(binary.LittleEndian.Uint32(body[4:8])),
+				BitsPerSample: int(binary.LittleEndian.Uint16(body[14:16])),
+			}
+			if err := format.Validate(); err != nil {
+				return Format{}, nil, err
+			}
+			haveFormat = true
+
+		case "data":
+			if !haveFormat {
+				return Format{}, nil, fmt.Errorf("%w: data chunk before fmt chunk", ErrUnsupportedFormat)
+			}
+			// Streaming writers may leave the size unset; read to EOF in that case
+			var data []byte
+			var err error
+			if size == 0 || size == 0xFFFFFFFF {
+				data, err = io.ReadAll(r)
+			} else {
+				data = make([]byte, size)
+				var n int
+				n, err = io.ReadFull(r, data)
+				if errors.Is(err, io.ErrUnexpectedEOF) {
+					// Truncated recording, keep what was written
+					data, err = data[:n], nil
+				}
+			}
+			if err != nil {
+				return Format{}, nil, fmt.Errorf("failed to read WAV data: %w", err)
+			}
+			return format, BytesToSamples(data), nil
+
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size+size%2)); err != nil {
+				return Format{}, nil, fmt.Errorf("failed to skip %q chunk: %w", id, err)
+			}
+		}
+	}
+}
+
+// ReadFile reads a PCM WAV file
+func ReadFile(filename string) (Format, []int16, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return Format{}, nil, err
+	}
+	defer file.Close()
+	return Read(bufio.NewReader(file))
+}
+
+// Writer writes a WAV file incrementally. Samples are buffered and flushed
+// periodically with the header sizes patched, so the file is always playable
+// and a crash loses at most one flush interval.
+type Writer struct {
+	file          *os.File
+	writer        *bufio.Writer
+	format        Format
+	dataSize      uint32
+	flushInterval time.Duration
+	lastFlush     time.Time
+}
+
+// Create creates a WAV file for incremental writing; a zero flushInterval only flushes on Close
+func Create(filename string, f Format, flushInterval time.Duration) (*Writer, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create WAV file: %w", err)
+	}
+	if _, err := file.Write(Header(f, 0)); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write WAV header: %w", err)
+	}
+	return &Writer{
+		file:          file,
+		writer:        bufio.NewWriterSize(file, 64*1024),
+		format:        f,
+		flushInterval: flushInterval,
+		lastFlush:     time.Now(),
+	}, nil
+}
+
+// Write appends samples, flushing when the flush interval has elapsed
+func (w *Writer) Write(samples []int16) error {
+	if _, err := w.writer.Write(SamplesToBytes(samples)); err != nil {
+		return fmt.Errorf("failed to write WAV samples: %w", err)
+	}
+	w.dataSize += uint32(len(samples) * 2)
+
+	if w.flushInterval > 0 && time.Since(w.lastFlush) >= w.flushInterval {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush writes buffered samples and updates the RIFF and data sizes
+func (w *Writer) Flush() error {
+	w.lastFlush = time.Now()
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush WAV samples: %w", err)
+	}
+	var sizes [4]byte
+	binary.LittleEndian.PutUint32(sizes[:], 36+w.dataSize)
+	if _, err := w.file.WriteAt(sizes[:], 4); err != nil {
+		return fmt.Errorf("failed to update WAV header: %w", err)
+	}
+	binary.LittleEndian.PutUint32(sizes[:], w.dataSize)
+	if _, err := w.file.WriteAt(sizes[:], 40); err != nil {
+		return fmt.Errorf("failed to update WAV header: %w", err)
+	}
+	return nil
+}
+
+// Samples returns the number of samples written
+func (w *Writer) Samples() int {
+	return int(w.dataSize / 2)
+}
+
+// Close flushes and closes the file
+func (w *Writer) Close() error {
+	flushErr := w.Flush()
+	if err := w.file.Close(); err != nil && flushErr == nil {
+		return fmt.Errorf("failed to close WAV file: %w", err)
+	}
+	return flushErr
+}
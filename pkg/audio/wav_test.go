@@ -0,0 +1,104 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeRead_RoundTrip(t *testing.T) {
+	samples := []int16{0, 1, -1, 32767, -32768, 1234}
+	data := Encode(Mono16(8000), samples)
+	assert.Len(t, data, HeaderSize+len(samples)*2)
+
+	format, decoded, err := Read(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, Mono16(8000), format)
+	assert.Equal(t, samples, decoded)
+}
+
+func TestRead_SkipsExtraChunks(t *testing.T) {
+	samples := []int16{10, 20, 30}
+	header := Header(Format{SampleRate: 16000, Channels: 2, BitsPerSample: 16}, uint32(len(samples)*2))
+
+	// Insert a LIST chunk (odd size, padded) between fmt and data
+	var buf bytes.Buffer
+	buf.Write(header[:36])
+	buf.WriteString("LIST")
+	binary.Write(&buf, binary.LittleEndian, uint32(3))
+	buf.Write([]byte{1, 2, 3, 0})
+	buf.Write(header[36:])
+	buf.Write(SamplesToBytes(samples))
+
+	format, decoded, err := Read(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, format.Channels)
+	assert.Equal(t, 16000, format.SampleRate)
+	assert.Equal(t, samples, decoded)
+}
+
+func TestRead_RejectsInvalid(t *testing.T) {
+	_, _, err := Read(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00AVI ")))
+	assert.ErrorIs(t, err, ErrNotWAV)
+
+	data := Encode(Format{SampleRate: 8000, Channels: 1, BitsPerSample: 8}, nil)
+	_, _, err = Read(bytes.NewReader(data))
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	data = Encode(Mono16(96000), nil)
+	_, _, err = Read(bytes.NewReader(data))
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+func TestRead_TruncatedData(t *testing.T) {
+	data := Encode(Mono16(8000), []int16{1, 2, 3, 4})
+	_, decoded, err := Read(bytes.NewReader(data[:len(data)-4]))
+	assert.NoError(t, err)
+	assert.Equal(t, []int16{1, 2}, decoded)
+}
+
+func TestWriter_IncrementalFlush(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "rec.wav")
+	w, err := Create(filename, Mono16(8000), time.Nanosecond)
+	assert.NoError(t, err)
+
+	assert.NoError(t, w.Write([]int16{1, 2, 3}))
+
+	// Readable before Close
+	_, decoded, err := ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, []int16{1, 2, 3}, decoded)
+
+	assert.NoError(t, w.Write([]int16{4}))
+	assert.NoError(t, w.Close())
+	assert.Equal(t, 4, w.Samples())
+
+	_, decoded, err = ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, []int16{1, 2, 3, 4}, decoded)
+}
+
+func TestWriteFile_ValidatesFormat(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "bad.wav")
+	err := WriteFile(filename, Format{SampleRate: 8000, Channels: 3, BitsPerSample: 16}, nil)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+	_, statErr := os.Stat(filename)
+	assert.True(t, os.IsNotExist(statErr))
+}
+
+func TestToMono(t *testing.T) {
+	assert.Equal(t, []int16{15, -5}, ToMono([]int16{10, 20, -10, 0}, 2))
+	assert.Equal(t, []int16{1, 2}, ToMono([]int16{1, 2}, 1))
+}
+
+func TestConvert_Resamples(t *testing.T) {
+	samples := make([]int16, 1600)
+	converted, err := Convert(Format{SampleRate: 16000, Channels: 2, BitsPerSample: 16}, samples, 8000)
+	assert.NoError(t, err)
+	assert.InDelta(t, 400, len(converted), 10)
+}
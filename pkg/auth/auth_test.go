@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseAdminTokens(t *testing.T) {
+	tokens, err := ParseAdminTokens(" alice:s3cret , bob:t0ken:with:colons,")
+	if err != nil {
+		t.Fatalf("ParseAdminTokens() error = %v", err)
+	}
+	if len(tokens) != 2 || tokens[0] != (AdminToken{"alice", "s3cret"}) || tokens[1] != (AdminToken{"bob", "t0ken:with:colons"}) {
+		t.Errorf("ParseAdminTokens() = %v", tokens)
+	}
+	for _, value := range []string{"alice", "alice:", ":s3cret"} {
+		if _, err := ParseAdminTokens(value); err == nil {
+			t.Errorf("ParseAdminTokens(%q) expected error", value)
+		}
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tokens := []AdminToken{{Name: "alice", Token: "s3cret"}}
+	tests := []struct {
+		name    string
+		tokens  []AdminToken
+		remote  string
+		header  string
+		query   string
+		upgrade bool // RequireAdminUpgrade with a WebSocket upgrade request
+		status  int
+		actor   string
+	}{
+		{"bearer token", tokens, "203.0.113.5:4000", "Bearer s3cret", "", false, http.StatusOK, "alice"},
+		{"lowercase scheme", tokens, "203.0.113.5:4000", "bearer s3cret", "", false, http.StatusOK, "alice"},
+		{"query token on api route", tokens, "203.0.113.5:4000", "", "s3cret", false, http.StatusUnauthorized, ""},
+		{"query token for websocket", tokens, "203.0.113.5:4000", "", "s3cret", true, http.StatusOK, "alice"},
+		{"bearer token for websocket", tokens, "203.0.113.5:4000", "Bearer s3cret", "", true, http.StatusOK, "alice"},
+		{"wrong token", tokens, "203.0.113.5:4000", "Bearer nope", "", false, http.StatusUnauthorized, ""},
+		{"missing token", tokens, "127.0.0.1:4000", "", "", false, http.StatusUnauthorized, ""},
+		{"basic scheme", tokens, "203.0.113.5:4000", "Basic s3cret", "", false, http.StatusUnauthorized, ""},
+		{"no tokens loopback", nil, "127.0.0.1:4000", "", "", false, http.StatusOK, LocalActor},
+		{"no tokens remote", nil, "203.0.113.5:4000", "", "", false, http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actor := ""
+			middleware := RequireAdmin(tt.tokens)
+			if tt.upgrade {
+				middleware = RequireAdminUpgrade(tt.tokens)
+			}
+			router := gin.New()
+			router.GET("/api/ping", middleware, func(c *gin.Context) {
+				actor = CurrentActor(c)
+				c.Status(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/api/ping", nil)
+			req.RemoteAddr = tt.remote
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.upgrade {
+				req.Header.Set("Connection", "Upgrade")
+				req.Header.Set("Upgrade", "websocket")
+			}
+			if tt.query != "" {
+				req.URL.RawQuery = "access_token=" + tt.query
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if actor != tt.actor {
+				t.Errorf("actor = %q, want %q", actor, tt.actor)
+			}
+		})
+	}
+}
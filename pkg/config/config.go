@@ -39,6 +39,9 @@ type ServerConfig struct {
 	SSLEnabled    bool   `env:"SSL_ENABLED"`
 	SSLCertFile   string `env:"SSL_CERT_FILE"`
 	SSLKeyFile    string `env:"SSL_KEY_FILE"`
+
+	// bearer tokens for the HTTP API as name:token pairs, the name is recorded as the actor
+	AdminTokens string `env:"ADMIN_TOKENS"`
 }
 
 // DatabaseConfig database configuration
@@ -49,10 +52,61 @@ type DatabaseConfig struct {
 
 // ServicesConfig services configuration
 type ServicesConfig struct {
-	LLM  LLMConfig               `mapstructure:"llm"`
-	ASR  ASRConfig               `mapstructure:"asr"`
-	TTS  TTSConfig               `mapstructure:"tts"`
-	Mail notification.MailConfig `mapstructure:"mail"`
+	LLM     LLMConfig               `mapstructure:"llm"`
+	ASR     ASRConfig               `mapstructure:"asr"`
+	TTS     TTSConfig               `mapstructure:"tts"`
+	Mail    notification.MailConfig `mapstructure:"mail"`
+	Billing BillingConfig           `mapstructure:"billing"`
+	Quotas  QuotaConfig             `mapstructure:"quotas"`
+	AGC     AGCConfig               `mapstructure:"agc"`
+	VAD     VADConfig               `mapstructure:"vad"`
+}
+
+// VADConfig energy-based voice activity detection on caller audio
+type VADConfig struct {
+	Threshold   int     `env:"VAD_THRESHOLD"`    // sample amplitude counted as voiced
+	SpeechRatio float64 `env:"VAD_SPEECH_RATIO"` // voiced sample ratio that marks a frame as speech
+}
+
+// AGCConfig automatic gain control for synthesized speech and caller audio
+type AGCConfig struct {
+	TTS         bool    `env:"AGC_TTS"`          // normalize TTS output before playback
+	Inbound     bool    `env:"AGC_INBOUND"`      // normalize caller audio before ASR
+	TargetLevel int     `env:"AGC_TARGET_LEVEL"` // target RMS level, 0-32767
+	MaxGain     float64 `env:"AGC_MAX_GAIN"`     // maximum amplification
+	Ceiling     int     `env:"AGC_CEILING"`      // limiter ceiling for peaks
+
+	// integrated loudness that prompts and whole TTS clips are normalized to, 0 disables
+	TargetLUFS float64 `env:"AGC_TARGET_LUFS"`
+}
+
+// BillingConfig provider usage rates used for per-call cost estimation
+type BillingConfig struct {
+	ASRPerMinute   float64 `env:"BILLING_ASR_PER_MINUTE"`    // cost per minute of recognized audio
+	TTSPer1KChars  float64 `env:"BILLING_TTS_PER_1K_CHARS"`  // cost per 1000 synthesized characters
+	LLMPer1KTokens float64 `env:"BILLING_LLM_PER_1K_TOKENS"` // cost per 1000 LLM tokens
+}
+
+// QuotaConfig monthly usage quotas of the configured ASR/TTS/LLM providers, 0 disables a quota
+type QuotaConfig struct {
+	ASRMinutes float64 `env:"QUOTA_ASR_MINUTES"` // minutes of recognized audio per month
+	TTSChars   int     `env:"QUOTA_TTS_CHARS"`   // synthesized characters per month
+	LLMTokens  int     `env:"QUOTA_LLM_TOKENS"`  // estimated LLM tokens per month
+
+	// providers switched to once the primary provider's quota is used up, read from ASR_FALLBACK_* and TTS_FALLBACK_*
+	ASRFallback FallbackProvider
+	TTSFallback FallbackProvider
+
+	AlertEmail   string `env:"QUOTA_ALERT_EMAIL"`   // recipient of quota warnings, sent through the mail config
+	AlertWebhook string `env:"QUOTA_ALERT_WEBHOOK"` // URL quota warnings are POSTed to as JSON
+}
+
+// FallbackProvider provider and credentials of a fallback ASR or TTS service, empty Provider disables fallback
+type FallbackProvider struct {
+	Provider  string
+	AppID     string
+	SecretID  string
+	SecretKey string
 }
 
 // LLMConfig LLM service configuration
@@ -63,6 +117,7 @@ type LLMConfig struct {
 	Model       string  `env:"LLM_MODEL"`
 	Temperature float32 `env:"LLM_TEMPERATURE"`
 	MaxTokens   int     `env:"LLM_MAX_TOKENS"`
+	ReferTarget string  `env:"LLM_REFER_TARGET"` // transfer target offered to the model as the refer tool, empty disables it
 }
 
 // ASRConfig ASR service configuration
@@ -73,7 +128,13 @@ type ASRConfig struct {
 	SecretKey string `env:"ASR_SECRET_KEY"`
 	Region    string `env:"ASR_REGION"`
 	ModelType string `env:"ASR_MODEL_TYPE"` // 8k_zh, 16k_zh, etc.
-	Language  string `env:"ASR_LANGUAGE"`   // zh-CN, en-US, etc.
+	// model for calls with a 16 kHz or wider codec, empty uses ModelType
+	WidebandModelType string `env:"ASR_WIDEBAND_MODEL_TYPE"`
+	Language          string `env:"ASR_LANGUAGE"` // zh-CN, en-US, etc.
+	// dialect=model overrides of the built-in dialect options, e.g. cantonese=16k_yue (language codes for google)
+	DialectModels string `env:"ASR_DIALECT_MODELS"`
+
+	FeedSpeed float64 `env:"ASR_FEED_SPEED"` // max feed speed relative to real time for buffered audio, 0 = unlimited
 }
 
 // TTSConfig TTS service configuration
@@ -87,6 +148,7 @@ type TTSConfig struct {
 	SampleRate int    `env:"TTS_SAMPLE_RATE"` // 8000, 16000, etc.
 	Codec      string `env:"TTS_CODEC"`       // pcm, mp3, etc.
 	Language   string `env:"TTS_LANGUAGE"`    // zh-CN, en-US, etc.
+	Streaming  bool   `env:"TTS_STREAMING"`   // play audio chunks while synthesizing
 }
 
 // MiddlewareConfig middleware configuration
@@ -97,11 +159,14 @@ type MiddlewareConfig struct {
 	Timeout TimeoutConfig
 	// Circuit breaker configuration
 	CircuitBreaker CircuitBreakerConfig
+	// SIP request rate limiting per source IP
+	SIPRateLimit SIPRateLimitConfig
 	// Whether to enable each middleware
 	EnableRateLimit      bool `env:"ENABLE_RATE_LIMIT"`
 	EnableTimeout        bool `env:"ENABLE_TIMEOUT"`
 	EnableCircuitBreaker bool `env:"ENABLE_CIRCUIT_BREAKER"`
 	EnableOperationLog   bool `env:"ENABLE_OPERATION_LOG"`
+	EnableSIPRateLimit   bool `env:"ENABLE_SIP_RATE_LIMIT"`
 }
 
 // RateLimiterConfig rate limiting configuration
@@ -117,6 +182,14 @@ type RateLimiterConfig struct {
 	IPWindow     time.Duration // IP time window
 }
 
+// SIPRateLimitConfig token buckets per source IP for new REGISTER and INVITE requests, a rate of 0 disables the limit
+type SIPRateLimitConfig struct {
+	RegisterRPS   float64 `env:"SIP_RATE_LIMIT_REGISTER_RPS"`   // REGISTER requests per second per IP
+	RegisterBurst int     `env:"SIP_RATE_LIMIT_REGISTER_BURST"` // REGISTER burst per IP
+	InviteRPS     float64 `env:"SIP_RATE_LIMIT_INVITE_RPS"`     // INVITE requests per second per IP
+	InviteBurst   int     `env:"SIP_RATE_LIMIT_INVITE_BURST"`   // INVITE burst per IP
+}
+
 // TimeoutConfig timeout configuration
 type TimeoutConfig struct {
 	DefaultTimeout   time.Duration `env:"DEFAULT_TIMEOUT"`
@@ -143,8 +216,18 @@ func Load() error {
 		log.Printf("Note: .env file not found or failed to load: %v (using default values)", err)
 	}
 
-	// 2. Load global configuration
-	GlobalConfig = &Config{
+	// 2. Load global configuration, resolving secret: references through the secrets backend
+	cfg := build()
+	if err := resolveSecrets(cfg); err != nil {
+		return err
+	}
+	GlobalConfig = cfg
+	return nil
+}
+
+// build 按当前环境变量构建配置
+func build() *Config {
+	return &Config{
 		MachineID: utils.GetIntEnv("MACHINE_ID"),
 		Server: ServerConfig{
 			Name:          getStringOrDefault("SERVER_NAME", ""),
@@ -152,7 +235,7 @@ func Load() error {
 			URL:           getStringOrDefault("SERVER_URL", ""),
 			Logo:          getStringOrDefault("SERVER_LOGO", ""),
 			TermsURL:      getStringOrDefault("SERVER_TERMS_URL", ""),
-			Addr:          getStringOrDefault("ADDR", ":7072"),
+			Addr:          getStringOrDefault("ADDR", "127.0.0.1:7072"),
 			Mode:          getStringOrDefault("MODE", "development"),
 			DocsPrefix:    getStringOrDefault("DOCS_PREFIX", "/api/docs"),
 			APIPrefix:     getStringOrDefault("API_PREFIX", "/api"),
@@ -162,6 +245,7 @@ func Load() error {
 			SSLEnabled:    getBoolOrDefault("SSL_ENABLED", false),
 			SSLCertFile:   getStringOrDefault("SSL_CERT_FILE", ""),
 			SSLKeyFile:    getStringOrDefault("SSL_KEY_FILE", ""),
+			AdminTokens:   getStringOrDefault("ADMIN_TOKENS", ""),
 		},
 		Database: DatabaseConfig{
 			Driver: getStringOrDefault("DB_DRIVER", "sqlite"),
@@ -174,6 +258,11 @@ func Load() error {
 			MaxAge:     getIntOrDefault("LOG_MAX_AGE", 30),
 			MaxBackups: getIntOrDefault("LOG_MAX_BACKUPS", 5),
 			Daily:      getBoolOrDefault("LOG_DAILY", true),
+
+			ScrubPII:          getBoolOrDefault("LOG_SCRUB_PII", true),
+			RedactTranscripts: getBoolOrDefault("LOG_REDACT_TRANSCRIPTS", false),
+			SampleInitial:     getIntOrDefault("LOG_SAMPLE_INITIAL", 0),
+			SampleThereafter:  getIntOrDefault("LOG_SAMPLE_THEREAFTER", 100),
 		},
 		Services: ServicesConfig{
 			LLM: LLMConfig{
@@ -183,15 +272,19 @@ func Load() error {
 				Model:       getStringOrDefault("LLM_MODEL", "gpt-3.5-turbo"),
 				Temperature: float32(getFloatOrDefault("LLM_TEMPERATURE", 0.7)),
 				MaxTokens:   getIntOrDefault("LLM_MAX_TOKENS", 2000),
+				ReferTarget: getStringOrDefault("LLM_REFER_TARGET", ""),
 			},
 			ASR: ASRConfig{
-				Provider:  getStringOrDefault("ASR_PROVIDER", "qcloud"),
-				AppID:     getStringOrDefault("ASR_APP_ID", ""),
-				SecretID:  getStringOrDefault("ASR_SECRET_ID", ""),
-				SecretKey: getStringOrDefault("ASR_SECRET_KEY", ""),
-				Region:    getStringOrDefault("ASR_REGION", "ap-beijing"),
-				ModelType: getStringOrDefault("ASR_MODEL_TYPE", "8k_zh"),
-				Language:  getStringOrDefault("ASR_LANGUAGE", "zh-CN"),
+				Provider:          getStringOrDefault("ASR_PROVIDER", "qcloud"),
+				AppID:             getStringOrDefault("ASR_APP_ID", ""),
+				SecretID:          getStringOrDefault("ASR_SECRET_ID", ""),
+				SecretKey:         getStringOrDefault("ASR_SECRET_KEY", ""),
+				Region:            getStringOrDefault("ASR_REGION", "ap-beijing"),
+				ModelType:         getStringOrDefault("ASR_MODEL_TYPE", "8k_zh"),
+				WidebandModelType: getStringOrDefault("ASR_WIDEBAND_MODEL_TYPE", ""),
+				Language:          getStringOrDefault("ASR_LANGUAGE", "zh-CN"),
+				DialectModels:     getStringOrDefault("ASR_DIALECT_MODELS", ""),
+				FeedSpeed:         getFloatOrDefault("ASR_FEED_SPEED", 0),
 			},
 			TTS: TTSConfig{
 				Provider:   getStringOrDefault("TTS_PROVIDER", "qcloud"),
@@ -203,6 +296,7 @@ func Load() error {
 				SampleRate: getIntOrDefault("TTS_SAMPLE_RATE", 8000),
 				Codec:      getStringOrDefault("TTS_CODEC", "pcm"),
 				Language:   getStringOrDefault("TTS_LANGUAGE", "zh-CN"),
+				Streaming:  getBoolOrDefault("TTS_STREAMING", false),
 			},
 			Mail: notification.MailConfig{
 				Host:     getStringOrDefault("MAIL_HOST", ""),
@@ -211,10 +305,45 @@ func Load() error {
 				Port:     int64(getIntOrDefault("MAIL_PORT", 587)),
 				From:     getStringOrDefault("MAIL_FROM", ""),
 			},
+			Billing: BillingConfig{
+				ASRPerMinute:   getFloatOrDefault("BILLING_ASR_PER_MINUTE", 0),
+				TTSPer1KChars:  getFloatOrDefault("BILLING_TTS_PER_1K_CHARS", 0),
+				LLMPer1KTokens: getFloatOrDefault("BILLING_LLM_PER_1K_TOKENS", 0),
+			},
+			Quotas: QuotaConfig{
+				ASRMinutes:   getFloatOrDefault("QUOTA_ASR_MINUTES", 0),
+				TTSChars:     getIntOrDefault("QUOTA_TTS_CHARS", 0),
+				LLMTokens:    getIntOrDefault("QUOTA_LLM_TOKENS", 0),
+				ASRFallback:  fallbackProvider("ASR_FALLBACK_"),
+				TTSFallback:  fallbackProvider("TTS_FALLBACK_"),
+				AlertEmail:   getStringOrDefault("QUOTA_ALERT_EMAIL", ""),
+				AlertWebhook: getStringOrDefault("QUOTA_ALERT_WEBHOOK", ""),
+			},
+			AGC: AGCConfig{
+				TTS:         getBoolOrDefault("AGC_TTS", true),
+				Inbound:     getBoolOrDefault("AGC_INBOUND", true),
+				TargetLevel: getIntOrDefault("AGC_TARGET_LEVEL", 3000),
+				MaxGain:     getFloatOrDefault("AGC_MAX_GAIN", 4),
+				Ceiling:     getIntOrDefault("AGC_CEILING", 30000),
+				TargetLUFS:  getFloatOrDefault("AGC_TARGET_LUFS", -18),
+			},
+			VAD: VADConfig{
+				Threshold:   getIntOrDefault("VAD_THRESHOLD", 500),
+				SpeechRatio: getFloatOrDefault("VAD_SPEECH_RATIO", 0.2),
+			},
 		},
 		Middleware: loadMiddlewareConfig(),
 	}
-	return nil
+}
+
+// fallbackProvider reads the PROVIDER, APP_ID, SECRET_ID and SECRET_KEY variables with the given prefix
+func fallbackProvider(prefix string) FallbackProvider {
+	return FallbackProvider{
+		Provider:  getStringOrDefault(prefix+"PROVIDER", ""),
+		AppID:     getStringOrDefault(prefix+"APP_ID", ""),
+		SecretID:  getStringOrDefault(prefix+"SECRET_ID", ""),
+		SecretKey: getStringOrDefault(prefix+"SECRET_KEY", ""),
+	}
 }
 
 // Validate validates the configuration
@@ -324,10 +453,17 @@ func loadMiddlewareConfig() MiddlewareConfig {
 				OpenTimeout:           30 * time.Second,
 				MaxConcurrentRequests: 200,
 			},
+			SIPRateLimit: SIPRateLimitConfig{
+				RegisterRPS:   2,
+				RegisterBurst: 10,
+				InviteRPS:     5,
+				InviteBurst:   20,
+			},
 			EnableRateLimit:      true,
 			EnableTimeout:        true,
 			EnableCircuitBreaker: true,
 			EnableOperationLog:   true,
+			EnableSIPRateLimit:   true,
 		}
 	} else {
 		defaultConfig = MiddlewareConfig{
@@ -357,10 +493,17 @@ func loadMiddlewareConfig() MiddlewareConfig {
 				OpenTimeout:           60 * time.Second,
 				MaxConcurrentRequests: 1000,
 			},
+			SIPRateLimit: SIPRateLimitConfig{
+				RegisterRPS:   10,
+				RegisterBurst: 50,
+				InviteRPS:     20,
+				InviteBurst:   100,
+			},
 			EnableRateLimit:      true,
 			EnableTimeout:        true,
 			EnableCircuitBreaker: false,
 			EnableOperationLog:   true,
+			EnableSIPRateLimit:   true,
 		}
 	}
 	return MiddlewareConfig{
@@ -386,9 +529,16 @@ func loadMiddlewareConfig() MiddlewareConfig {
 			OpenTimeout:           parseDuration(getStringOrDefault("CIRCUIT_BREAKER_OPEN_TIMEOUT", "30s"), defaultConfig.CircuitBreaker.OpenTimeout),
 			MaxConcurrentRequests: getIntOrDefault("CIRCUIT_BREAKER_MAX_CONCURRENT", defaultConfig.CircuitBreaker.MaxConcurrentRequests),
 		},
+		SIPRateLimit: SIPRateLimitConfig{
+			RegisterRPS:   getFloatOrDefault("SIP_RATE_LIMIT_REGISTER_RPS", defaultConfig.SIPRateLimit.RegisterRPS),
+			RegisterBurst: getIntOrDefault("SIP_RATE_LIMIT_REGISTER_BURST", defaultConfig.SIPRateLimit.RegisterBurst),
+			InviteRPS:     getFloatOrDefault("SIP_RATE_LIMIT_INVITE_RPS", defaultConfig.SIPRateLimit.InviteRPS),
+			InviteBurst:   getIntOrDefault("SIP_RATE_LIMIT_INVITE_BURST", defaultConfig.SIPRateLimit.InviteBurst),
+		},
 		EnableRateLimit:      getBoolOrDefault("ENABLE_RATE_LIMIT", defaultConfig.EnableRateLimit),
 		EnableTimeout:        getBoolOrDefault("ENABLE_TIMEOUT", defaultConfig.EnableTimeout),
 		EnableCircuitBreaker: getBoolOrDefault("ENABLE_CIRCUIT_BREAKER", defaultConfig.EnableCircuitBreaker),
 		EnableOperationLog:   getBoolOrDefault("ENABLE_OPERATION_LOG", defaultConfig.EnableOperationLog),
+		EnableSIPRateLimit:   getBoolOrDefault("ENABLE_SIP_RATE_LIMIT", defaultConfig.EnableSIPRateLimit),
 	}
 }
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/secrets"
+	"github.com/LingByte/LingSIP/pkg/utils"
+)
+
+// reloadMutex 串行化Reload
+var reloadMutex sync.Mutex
+
+// ReloadResult result of re-reading the env file
+type ReloadResult struct {
+	Applied         []string `json:"applied"`         // changed keys that took effect
+	RestartRequired []string `json:"restartRequired"` // changed keys ignored until restart
+}
+
+// applyReloadable copies the settings that are safe to change while calls are active
+func applyReloadable(dst, src *Config) {
+	dst.Log.Level = src.Log.Level
+	dst.Services.LLM.Model = src.Services.LLM.Model
+	dst.Services.LLM.Temperature = src.Services.LLM.Temperature
+	dst.Services.LLM.MaxTokens = src.Services.LLM.MaxTokens
+	dst.Services.TTS.VoiceType = src.Services.TTS.VoiceType
+	dst.Services.TTS.Streaming = src.Services.TTS.Streaming
+	dst.Services.AGC = src.Services.AGC
+	dst.Services.VAD = src.Services.VAD
+	dst.Services.Billing = src.Services.Billing
+	dst.Services.Quotas = src.Services.Quotas
+	dst.Middleware.RateLimit = src.Middleware.RateLimit
+	dst.Middleware.SIPRateLimit = src.Middleware.SIPRateLimit
+	dst.Middleware.EnableSIPRateLimit = src.Middleware.EnableSIPRateLimit
+
+	// provider credentials, so rotated secrets apply to new requests
+	dst.Services.LLM.APIKey = src.Services.LLM.APIKey
+	dst.Services.ASR.AppID = src.Services.ASR.AppID
+	dst.Services.ASR.SecretID = src.Services.ASR.SecretID
+	dst.Services.ASR.SecretKey = src.Services.ASR.SecretKey
+	dst.Services.TTS.AppID = src.Services.TTS.AppID
+	dst.Services.TTS.SecretID = src.Services.TTS.SecretID
+	dst.Services.TTS.SecretKey = src.Services.TTS.SecretKey
+	dst.Services.Mail.Password = src.Services.Mail.Password
+}
+
+// Reload re-reads the env file and swaps in a new GlobalConfig with only the reloadable
+// settings changed. Readers holding the previous config keep a consistent snapshot.
+func Reload() (*ReloadResult, error) {
+	return reload(true)
+}
+
+// RefreshSecrets re-resolves secret references after the secrets backend rotated values,
+// without re-reading the env file
+func RefreshSecrets() (*ReloadResult, error) {
+	return reload(false)
+}
+
+func reload(readEnv bool) (*ReloadResult, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	current := GlobalConfig
+	if current == nil {
+		return nil, errors.New("config not loaded")
+	}
+	if readEnv {
+		if err := utils.LoadEnv(os.Getenv("APP_ENV")); err != nil {
+			return nil, fmt.Errorf("failed to read env file: %w", err)
+		}
+	}
+	fresh := build()
+	if err := resolveSecrets(fresh); err != nil {
+		return nil, err
+	}
+	next := *current
+	applyReloadable(&next, fresh)
+
+	result := &ReloadResult{
+		Applied:         diffFields("", reflect.ValueOf(*current), reflect.ValueOf(next)),
+		RestartRequired: diffFields("", reflect.ValueOf(next), reflect.ValueOf(*fresh)),
+	}
+	if len(result.Applied) > 0 {
+		GlobalConfig = &next
+	}
+	return result, nil
+}
+
+// resolveSecrets replaces every secret: reference in cfg with its value
+func resolveSecrets(cfg *Config) error {
+	return resolveSecretFields("", reflect.ValueOf(cfg).Elem())
+}
+
+func resolveSecretFields(prefix string, v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		path := field.Name
+		if prefix != "" {
+			path = prefix + "." + field.Name
+		}
+		value := v.Field(i)
+		switch value.Kind() {
+		case reflect.Struct:
+			if err := resolveSecretFields(path, value); err != nil {
+				return err
+			}
+		case reflect.String:
+			if !secrets.IsRef(value.String()) {
+				continue
+			}
+			secret, err := secrets.Resolve(context.Background(), value.String())
+			if err != nil {
+				return fmt.Errorf("failed to resolve %s: %w", path, err)
+			}
+			value.SetString(secret)
+		}
+	}
+	return nil
+}
+
+// diffFields returns the dotted paths of the fields that differ between a and b
+func diffFields(prefix string, a, b reflect.Value) []string {
+	if a.Kind() != reflect.Struct {
+		if reflect.DeepEqual(a.Interface(), b.Interface()) {
+			return nil
+		}
+		return []string{prefix}
+	}
+	var changed []string
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		path := field.Name
+		if prefix != "" {
+			path = prefix + "." + field.Name
+		}
+		changed = append(changed, diffFields(path, a.Field(i), b.Field(i))...)
+	}
+	return changed
+}
+
+// EnvFile returns the env file used for the current APP_ENV
+func EnvFile() string {
+	if env := os.Getenv("APP_ENV"); env != "" {
+		return ".env." + env
+	}
+	return ".env"
+}
+
+// WatchEnvFile polls the env file every interval and calls onChange after it is modified,
+// until stop is closed
+func WatchEnvFile(interval time.Duration, stop <-chan struct{}, onChange func()) {
+	modTime := func() time.Time {
+		info, err := os.Stat(EnvFile())
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+	last := modTime()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if current := modTime(); !current.Equal(last) {
+			last = current
+			onChange()
+		}
+	}
+}
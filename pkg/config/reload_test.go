@@ -0,0 +1,50 @@
+package config
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	originalGlobalConfig := GlobalConfig
+	defer func() {
+		GlobalConfig = originalGlobalConfig
+	}()
+
+	t.Chdir(t.TempDir())
+	t.Setenv("APP_ENV", "")
+	t.Setenv("LLM_MODEL", "")
+	t.Setenv("DSN", "")
+	if err := os.WriteFile(".env", []byte("LLM_MODEL=model-a\nDSN=./a.db\n"), 0644); err != nil {
+		t.Fatalf("write env: %v", err)
+	}
+	if err := Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	before := GlobalConfig
+
+	if err := os.WriteFile(".env", []byte("LLM_MODEL=model-b\nDSN=./b.db\n"), 0644); err != nil {
+		t.Fatalf("write env: %v", err)
+	}
+	result, err := Reload()
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if !slices.Equal(result.Applied, []string{"Services.LLM.Model"}) {
+		t.Errorf("Applied = %v", result.Applied)
+	}
+	if !slices.Equal(result.RestartRequired, []string{"Database.DSN"}) {
+		t.Errorf("RestartRequired = %v", result.RestartRequired)
+	}
+	if GlobalConfig.Services.LLM.Model != "model-b" {
+		t.Errorf("Expected reloaded model 'model-b', got '%s'", GlobalConfig.Services.LLM.Model)
+	}
+	if GlobalConfig.Database.DSN != "./a.db" {
+		t.Errorf("Expected DSN to stay './a.db' until restart, got '%s'", GlobalConfig.Database.DSN)
+	}
+	if before.Services.LLM.Model != "model-a" {
+		t.Errorf("Previous config snapshot was modified")
+	}
+}
@@ -3,12 +3,27 @@ package constants
 const (
 	TABLE_SIP_CALLS             = "sip_calls"
 	TABLE_SIP_USERS             = "sip_users"
+	TABLE_SIP_REGISTRATIONS     = "sip_registrations"
 	TABLE_SIP_TRUNKS            = "sip_trunks"
 	TABLE_AI_PHONE_SCRIPTS      = "ai_phone_scripts"
 	TABLE_AI_PHONE_SCRIPT_STEPS = "ai_phone_script_steps"
 	TABLE_SCRIPT_PHONE_MAPPINGS = "script_phone_mappings"
 	TABLE_AI_PHONE_SESSIONS     = "ai_phone_sessions"
 	TABLE_STEP_EXECUTIONS       = "step_executions"
+	TABLE_DIAL_RULES            = "dial_rules"
+	TABLE_AUDIT_LOGS            = "audit_logs"
+	TABLE_DELETION_CERTS        = "deletion_certificates"
+	TABLE_SIP_MESSAGES          = "sip_messages"
+	TABLE_LLM_EXCHANGES         = "llm_exchanges"
+	TABLE_PROMPT_TEMPLATES      = "prompt_templates"
+	TABLE_CAMPAIGNS             = "campaigns"
+	TABLE_CAMPAIGN_CONTACTS     = "campaign_contacts"
+	TABLE_REPROCESS_JOBS        = "reprocess_jobs"
+	TABLE_REPROCESS_RESULTS     = "reprocess_results"
+	TABLE_DNC_ENTRIES           = "do_not_call_entries"
+	TABLE_DNC_SUPPRESSIONS      = "do_not_call_suppressions"
+	TABLE_ACCESS_RULES          = "access_rules"
+	TABLE_PROVIDER_USAGE        = "provider_usage"
 )
 
 const (
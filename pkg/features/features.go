@@ -0,0 +1,123 @@
+// Package features holds the feature flags that turn risky subsystems on or off per
+// deployment. Each flag defaults to its registered value and is overridden by the
+// FEATURE_<NAME> env var, e.g. FEATURE_BARGE_IN=false, or at runtime through Set.
+package features
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/LingByte/LingSIP/pkg/utils"
+)
+
+// known flags
+const (
+	// StreamingASR keeps one recognition connection open per call instead of one per turn
+	StreamingASR = "streaming_asr"
+	// BargeIn stops prompt playback when the caller starts speaking
+	BargeIn = "barge_in"
+)
+
+// Flag a registered feature flag
+type Flag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Default     bool   `json:"default"`
+	Enabled     bool   `json:"enabled"`
+}
+
+var (
+	mutex sync.RWMutex
+	flags = map[string]*Flag{}
+)
+
+func init() {
+	Register(StreamingASR, "reuse one ASR connection for all turns of a call", true)
+	Register(BargeIn, "interrupt prompts when the caller speaks", true)
+}
+
+// Register adds a flag with its default value. Registering an existing name resets it.
+func Register(name, description string, defaultValue bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	flags[name] = &Flag{Name: name, Description: description, Default: defaultValue, Enabled: defaultValue}
+}
+
+// Enabled reports whether a flag is on. Unknown flags are off.
+func Enabled(name string) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	flag, ok := flags[name]
+	return ok && flag.Enabled
+}
+
+// Set changes a flag at runtime
+func Set(name string, enabled bool) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	flag, ok := flags[name]
+	if !ok {
+		return fmt.Errorf("unknown feature flag %q", name)
+	}
+	flag.Enabled = enabled
+	return nil
+}
+
+// LoadEnv applies FEATURE_<NAME> overrides on top of the defaults. Invalid values are
+// reported and leave the flag at its default.
+func LoadEnv() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	var invalid []string
+	for name, flag := range flags {
+		key := EnvKey(name)
+		value := utils.GetEnv(key)
+		if value == "" {
+			flag.Enabled = flag.Default
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			invalid = append(invalid, key+"="+value)
+			flag.Enabled = flag.Default
+			continue
+		}
+		flag.Enabled = enabled
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return fmt.Errorf("invalid feature flag values: %s", strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+// EnvKey returns the env var that overrides a flag
+func EnvKey(name string) string {
+	return "FEATURE_" + strings.ToUpper(name)
+}
+
+// All returns every flag sorted by name
+func All() []Flag {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	all := make([]Flag, 0, len(flags))
+	for _, flag := range flags {
+		all = append(all, *flag)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// Snapshot returns the enabled state of every flag
+func Snapshot() map[string]bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	snapshot := make(map[string]bool, len(flags))
+	for name, flag := range flags {
+		snapshot[name] = flag.Enabled
+	}
+	return snapshot
+}
@@ -0,0 +1,44 @@
+package features
+
+import "testing"
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("FEATURE_BARGE_IN", "false")
+	t.Setenv("FEATURE_STREAMING_ASR", "")
+	if err := LoadEnv(); err != nil {
+		t.Fatalf("LoadEnv: %v", err)
+	}
+	if Enabled(BargeIn) {
+		t.Errorf("barge_in should be disabled by env")
+	}
+	if !Enabled(StreamingASR) {
+		t.Errorf("streaming_asr should keep its default")
+	}
+	if Enabled("unknown") {
+		t.Errorf("unknown flag should be disabled")
+	}
+
+	t.Setenv("FEATURE_STREAMING_ASR", "maybe")
+	if err := LoadEnv(); err == nil {
+		t.Errorf("expected error for invalid value")
+	}
+	if !Enabled(StreamingASR) {
+		t.Errorf("invalid value should leave the default: %v", Snapshot())
+	}
+}
+
+func TestSet(t *testing.T) {
+	defer Set(BargeIn, true)
+	if err := Set(BargeIn, false); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if Enabled(BargeIn) {
+		t.Errorf("barge_in should be disabled")
+	}
+	if err := Set("unknown", true); err == nil {
+		t.Errorf("expected error for unknown flag")
+	}
+	if all := All(); len(all) < 2 || all[0].Name != BargeIn {
+		t.Errorf("unexpected flags: %+v", all)
+	}
+}
@@ -17,6 +17,7 @@ type Config struct {
 	Temperature  float32 `json:"temperature" yaml:"temperature"`
 	MaxTokens    int     `json:"max_tokens" yaml:"max_tokens"`
 	StreamingTTS bool    `json:"streaming_tts" yaml:"streaming_tts"`
+	ReferTarget  string  `json:"refer_target" yaml:"refer_target"`
 }
 
 // DefaultConfig returns a default configuration using global config
@@ -43,6 +44,7 @@ func DefaultConfig() *Config {
 		Temperature:  llmConfig.Temperature,
 		MaxTokens:    llmConfig.MaxTokens,
 		StreamingTTS: false,
+		ReferTarget:  llmConfig.ReferTarget,
 	}
 }
 
@@ -76,6 +78,7 @@ func (s *Service) Initialize(ctx context.Context, systemPrompt string) error {
 	}
 
 	s.handler = NewLLMHandler(ctx, s.config.APIKey, s.config.BaseURL, systemPrompt, s.logger)
+	s.handler.ReferTarget = s.config.ReferTarget
 
 	s.logger.WithFields(logrus.Fields{
 		"provider": s.config.Provider,
@@ -109,6 +112,22 @@ func (s *Service) QueryStream(text string, client TTSClient, referCaller string)
 	return s.handler.QueryStream(s.config.Model, text, s.config.StreamingTTS, client, referCaller)
 }
 
+// QueryStreamContext performs a streaming query that stops when ctx is done, see LLMHandler.QueryStreamContext
+func (s *Service) QueryStreamContext(ctx context.Context, text string, client TTSClient, referCaller string) (string, error) {
+	if s.handler == nil {
+		return "", fmt.Errorf("LLM service not initialized")
+	}
+
+	return s.handler.QueryStreamContext(ctx, s.config.Model, text, s.config.StreamingTTS, client, referCaller)
+}
+
+// Interrupt cancels the streaming query in flight
+func (s *Service) Interrupt() {
+	if s.handler != nil {
+		s.handler.Interrupt()
+	}
+}
+
 // Reset resets the conversation history
 func (s *Service) Reset() {
 	if s.handler != nil {
@@ -121,6 +140,26 @@ func (s *Service) UpdateConfig(config *Config) {
 	s.config = config
 }
 
+// ReloadConfig applies the model settings and rotated API key of the reloaded global configuration
+func (s *Service) ReloadConfig() {
+	if config.GlobalConfig == nil {
+		return
+	}
+	llmConfig := config.GlobalConfig.Services.LLM
+	next := *s.config
+	if llmConfig.APIKey != "" && llmConfig.APIKey != s.config.APIKey {
+		next.APIKey = llmConfig.APIKey
+		if s.handler != nil {
+			s.handler.SetCredentials(llmConfig.APIKey, s.config.BaseURL)
+			s.logger.Info("LLM API key rotated")
+		}
+	}
+	next.Model = llmConfig.Model
+	next.Temperature = llmConfig.Temperature
+	next.MaxTokens = llmConfig.MaxTokens
+	s.config = &next
+}
+
 // GetConfig returns the current configuration
 func (s *Service) GetConfig() *Config {
 	return s.config
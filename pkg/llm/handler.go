@@ -3,6 +3,7 @@ package llm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
 	"sync"
@@ -110,7 +111,7 @@ func NewStreamingTTSWriter(client TTSClient, playID string, logger *logrus.Logge
 
 func (w *StreamingTTSWriter) Write(delta string, endOfStream, autoHangup bool) error {
 	// Don't send empty content unless it's specifically needed for endOfStream signaling
-	if delta == "" {
+	if delta == "" && !endOfStream {
 		return nil
 	}
 	return w.client.StreamTTS(delta, "", w.playID, endOfStream, autoHangup, nil, nil, false)
@@ -160,6 +161,17 @@ type LLMHandler struct {
 	ReferTarget string
 }
 
+// SetCredentials replaces the API client, keeping the conversation history
+func (h *LLMHandler) SetCredentials(apiKey, endpoint string) {
+	config := openai.DefaultConfig(apiKey)
+	config.BaseURL = endpoint
+	client := openai.NewClientWithConfig(config)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.client = client
+}
+
 // ToolCall represents a function call from the LLM
 type HangupTool struct {
 	Reason string `json:"reason"`
@@ -216,11 +228,55 @@ func NewLLMHandler(ctx context.Context, apiKey, endpoint, systemPrompt string, l
 	}
 }
 
+// ErrInterrupted is returned with the partial reply when a streaming completion is cancelled
+var ErrInterrupted = errors.New("LLM stream interrupted")
+
+// RequestObserver receives each chat completion request just before it is sent. It runs with the handler
+// locked and must not keep request.Messages, which the handler appends to afterwards
+type RequestObserver func(request openai.ChatCompletionRequest)
+
+type requestObserverKey struct{}
+
+// WithRequestObserver returns a context that reports the requests of QueryStreamContext to observe
+func WithRequestObserver(ctx context.Context, observe RequestObserver) context.Context {
+	return context.WithValue(ctx, requestObserverKey{}, observe)
+}
+
+// Interrupt cancels the streaming completion in flight; it has no effect when none is running
+func (h *LLMHandler) Interrupt() {
+	select {
+	case h.interruptCh <- struct{}{}:
+	default:
+	}
+}
+
 // QueryStream processes the LLM response as a stream and sends segments to TTS as they arrive
 func (h *LLMHandler) QueryStream(model, text string, streamingTTS bool, client TTSClient, referCaller string) (string, error) {
+	return h.QueryStreamContext(h.ctx, model, text, streamingTTS, client, referCaller)
+}
+
+// QueryStreamContext is QueryStream cancelled when ctx is done or Interrupt is called. The partial reply
+// is kept in the history and returned with ErrInterrupted, and nothing more is sent to TTS
+func (h *LLMHandler) QueryStreamContext(ctx context.Context, model, text string, streamingTTS bool, client TTSClient, referCaller string) (string, error) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
+	// Drop an interrupt that arrived while nothing was streaming
+	select {
+	case <-h.interruptCh:
+	default:
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(h.ctx, cancel)()
+	go func() {
+		select {
+		case <-h.interruptCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
 	// Add user message to history
 	h.messages = append(h.messages, openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
@@ -267,9 +323,16 @@ func (h *LLMHandler) QueryStream(model, text string, streamingTTS bool, client T
 	// Create tools handler
 	tools := NewDefaultTools(client, h.logger, h.ReferTarget, referCaller)
 
+	if observe, ok := ctx.Value(requestObserverKey{}).(RequestObserver); ok && observe != nil {
+		observe(request)
+	}
+
 	// Stream for handling responses
-	stream, err := h.client.CreateChatCompletionStream(h.ctx, request)
+	stream, err := h.client.CreateChatCompletionStream(ctx, request)
 	if err != nil {
+		if ctx.Err() != nil {
+			return "", ErrInterrupted
+		}
 		return "", fmt.Errorf("error creating chat completion stream: %w", err)
 	}
 	defer stream.Close()
@@ -278,11 +341,16 @@ func (h *LLMHandler) QueryStream(model, text string, streamingTTS bool, client T
 	var shouldHangup bool
 	var shouldRefer bool
 	hasTextBeforeHangup := false
+	interrupted := false
 
 	// Process the stream of responses
 	for {
 		response, err := stream.Recv()
 		if err != nil {
+			if ctx.Err() != nil {
+				interrupted = true
+				break
+			}
 			if err.Error() == "EOF" {
 				// Stream closed normally - send any remaining content as final segment
 				break
@@ -357,7 +425,7 @@ func (h *LLMHandler) QueryStream(model, text string, streamingTTS bool, client T
 	}
 
 	// Send final buffered content if not already handled by tool calls or finish reason
-	if !shouldHangup && !shouldRefer && hasTextBeforeHangup {
+	if !interrupted && !shouldHangup && !shouldRefer && hasTextBeforeHangup {
 		// Flush any remaining buffered content as final segment
 		if err := ttsWriter.Write("", true, false); err != nil {
 			h.logger.WithError(err).Error("Failed to flush final TTS buffer")
@@ -386,8 +454,12 @@ func (h *LLMHandler) QueryStream(model, text string, streamingTTS bool, client T
 		"fullResponse": fullResponse,
 		"hangup":       shouldHangup,
 		"refer":        shouldRefer,
+		"interrupted":  interrupted,
 	}).Info("LLM stream completed")
 
+	if interrupted {
+		return fullResponse, ErrInterrupted
+	}
 	return fullResponse, nil
 }
 
@@ -41,6 +41,10 @@ func NewTTSAdapter(
 // TTS implements the TTSClient interface for regular TTS
 func (a *TTSAdapter) TTS(text, voice, playID string, endOfStream, autoHangup bool, onStart, onEnd func(), interrupt bool) error {
 	if text == "" {
+		// An empty final segment still carries the hangup requested after the reply
+		if autoHangup {
+			return a.hangupFunc("Auto hangup after TTS")
+		}
 		return nil
 	}
 
@@ -0,0 +1,212 @@
+package logger
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// 可单独调整日志级别的组件
+const (
+	ComponentSIP    = "sip"
+	ComponentMedia  = "media"
+	ComponentEngine = "engine"
+	ComponentLLM    = "llm"
+)
+
+// Components 支持单独设置级别的组件
+var Components = []string{ComponentSIP, ComponentMedia, ComponentEngine, ComponentLLM}
+
+// componentSources 按调用方源文件归属组件，先匹配的优先；files为空表示目录下所有文件
+var componentSources = []struct {
+	component string
+	dir       string
+	files     []string
+}{
+	{ComponentEngine, "pkg/sip", []string{"ai_", "asr_", "audio_pipeline", "script_", "session_journal"}},
+	{ComponentSIP, "pkg/sip", nil},
+	{ComponentMedia, "pkg/media", nil},
+	{ComponentLLM, "pkg/llm", nil},
+}
+
+var (
+	// componentLevels 组件级别覆盖，写时复制
+	componentLevels atomic.Pointer[map[string]zapcore.Level]
+	levelMutex      sync.Mutex
+	levelHooks      = make(map[string][]func(zapcore.Level))
+)
+
+// SetComponentLevel 运行时修改单个组件的日志级别，level为空时恢复使用全局级别
+func SetComponentLevel(component, level string) error {
+	if !knownComponent(component) {
+		return fmt.Errorf("unknown log component %q", component)
+	}
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+
+	next := make(map[string]zapcore.Level)
+	if current := componentLevels.Load(); current != nil {
+		for name, l := range *current {
+			next[name] = l
+		}
+	}
+	effective := atomicLevel.Level()
+	if level == "" {
+		delete(next, component)
+	} else {
+		if err := effective.UnmarshalText([]byte(level)); err != nil {
+			return err
+		}
+		next[component] = effective
+	}
+	componentLevels.Store(&next)
+
+	for _, hook := range levelHooks[component] {
+		hook(effective)
+	}
+	if Lg != nil {
+		Lg.Info("Log level changed", zap.String("component", component), zap.String("level", effective.String()))
+	}
+	return nil
+}
+
+// ResetComponentLevels 清除所有组件级别覆盖
+func ResetComponentLevels() {
+	for component := range Levels().Components {
+		_ = SetComponentLevel(component, "")
+	}
+}
+
+// OnComponentLevel 组件级别变化时回调，用于同步不经过zap的日志，如LLM服务的logrus
+func OnComponentLevel(component string, hook func(zapcore.Level)) {
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+	levelHooks[component] = append(levelHooks[component], hook)
+}
+
+// LevelStatus 当前日志级别
+type LevelStatus struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
+}
+
+// Levels 返回全局级别和各组件的覆盖级别
+func Levels() LevelStatus {
+	status := LevelStatus{Level: atomicLevel.Level().String(), Components: make(map[string]string)}
+	if current := componentLevels.Load(); current != nil {
+		for name, l := range *current {
+			status.Components[name] = l.String()
+		}
+	}
+	return status
+}
+
+func knownComponent(component string) bool {
+	for _, name := range Components {
+		if name == component {
+			return true
+		}
+	}
+	return false
+}
+
+// componentOf 根据源文件路径判断组件，不属于任何组件时返回空
+func componentOf(file string) string {
+	dir := filepath.ToSlash(filepath.Dir(file)) + "/"
+	base := filepath.Base(file)
+	for _, source := range componentSources {
+		if !strings.Contains(dir, "/"+source.dir+"/") {
+			continue
+		}
+		if len(source.files) == 0 {
+			return source.component
+		}
+		// 子包（如pkg/sip/ua）不按文件名细分
+		if !strings.HasSuffix(dir, "/"+source.dir+"/") {
+			continue
+		}
+		for _, prefix := range source.files {
+			if strings.HasPrefix(base, prefix) {
+				return source.component
+			}
+		}
+	}
+	return ""
+}
+
+// callerComponent 跳过zap和本包的栈帧，按第一个业务调用方判断组件
+func callerComponent() string {
+	var pcs [16]uintptr
+	n := runtime.Callers(2, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		inLogger := strings.Contains(frame.File, "/pkg/logger/") && !strings.HasSuffix(frame.File, "_test.go")
+		if !inLogger && !strings.HasPrefix(frame.Function, "go.uber.org/zap") {
+			return componentOf(frame.File)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// levelCore 按组件覆盖或全局级别过滤日志。没有覆盖时不取调用栈
+type levelCore struct {
+	zapcore.Core
+	component func() string
+}
+
+func newLevelCore(core zapcore.Core) zapcore.Core {
+	return &levelCore{Core: core, component: callerComponent}
+}
+
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	if atomicLevel.Enabled(l) {
+		return true
+	}
+	if current := componentLevels.Load(); current != nil {
+		for _, override := range *current {
+			if override.Enabled(l) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), component: c.component}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.enabledFor(ent.Level) {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
+
+func (c *levelCore) enabledFor(l zapcore.Level) bool {
+	current := componentLevels.Load()
+	if current == nil || len(*current) == 0 {
+		return atomicLevel.Enabled(l)
+	}
+	// 所有级别都放行时不必判断组件
+	allEnabled := atomicLevel.Enabled(l)
+	for _, override := range *current {
+		allEnabled = allEnabled && override.Enabled(l)
+	}
+	if allEnabled {
+		return true
+	}
+	if override, ok := (*current)[c.component()]; ok {
+		return override.Enabled(l)
+	}
+	return atomicLevel.Enabled(l)
+}
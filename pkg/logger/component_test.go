@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestComponentOf(t *testing.T) {
+	cases := map[string]string{
+		"/src/LingSIP/pkg/sip/sip_server.go":             ComponentSIP,
+		"/src/LingSIP/pkg/sip/ua/storage.go":             ComponentSIP,
+		"/src/LingSIP/pkg/sip/ai_phone_audio.go":         ComponentEngine,
+		"/src/LingSIP/pkg/sip/script_manager.go":         ComponentEngine,
+		"github.com/LingByte/LingSIP/pkg/media/cache.go": ComponentMedia,
+		"/src/LingSIP/pkg/llm/handler.go":                ComponentLLM,
+		"/src/LingSIP/cmd/server/main.go":                "",
+	}
+	for file, want := range cases {
+		if got := componentOf(file); got != want {
+			t.Errorf("componentOf(%q) = %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestComponentLevel(t *testing.T) {
+	logPath := makeTmpLogFile(t, "component.log")
+	cfg := &LogConfig{
+		Level:      "info",
+		Filename:   logPath,
+		MaxSize:    5,
+		MaxAge:     1,
+		MaxBackups: 1,
+	}
+	if err := Init(cfg, "prod"); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	defer ResetComponentLevels()
+
+	var hooked zapcore.Level
+	OnComponentLevel(ComponentMedia, func(l zapcore.Level) { hooked = l })
+
+	if err := SetComponentLevel("rtp", "debug"); err == nil {
+		t.Errorf("expected error for unknown component")
+	}
+	if err := SetComponentLevel(ComponentMedia, "debug"); err != nil {
+		t.Fatalf("SetComponentLevel: %v", err)
+	}
+	if hooked != zapcore.DebugLevel {
+		t.Errorf("hook got %v, want debug", hooked)
+	}
+	if err := SetComponentLevel(ComponentSIP, "error"); err != nil {
+		t.Fatalf("SetComponentLevel: %v", err)
+	}
+
+	component := ComponentMedia
+	core := &levelCore{Core: Lg.Core().(*levelCore).Core, component: func() string { return component }}
+	log := zap.New(core)
+	log.Debug("media debug")
+	component = ComponentSIP
+	log.Warn("sip warn")
+	log.Error("sip error")
+	component = ""
+	log.Debug("other debug")
+	log.Info("other info")
+	Sync()
+
+	content := readFile(t, logPath)
+	for _, want := range []string{"media debug", "sip error", "other info"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("log missing %q: %s", want, content)
+		}
+	}
+	for _, dropped := range []string{"sip warn", "other debug"} {
+		if strings.Contains(content, dropped) {
+			t.Errorf("log contains %q: %s", dropped, content)
+		}
+	}
+
+	ResetComponentLevels()
+	if levels := Levels(); len(levels.Components) != 0 || levels.Level != "info" {
+		t.Errorf("unexpected levels after reset: %+v", levels)
+	}
+	if hooked != zapcore.InfoLevel {
+		t.Errorf("hook got %v after reset, want info", hooked)
+	}
+}
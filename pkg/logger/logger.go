@@ -17,21 +17,32 @@ type LogConfig struct {
 	MaxAge     int    `mapstructure:"max_age"`
 	MaxBackups int    `mapstructure:"max_backups"`
 	Daily      bool   `mapstructure:"daily"`
+
+	// 日志脱敏：遮盖号码中间数字，可选隐藏ASR文本、提示词等转写内容
+	ScrubPII          bool `mapstructure:"scrub_pii"`
+	RedactTranscripts bool `mapstructure:"redact_transcripts"`
+	// Debug日志采样：每秒同一消息先输出SampleInitial条，之后每SampleThereafter条输出一条，SampleInitial为0不采样
+	SampleInitial    int `mapstructure:"sample_initial"`
+	SampleThereafter int `mapstructure:"sample_thereafter"`
 }
 
 var (
 	Lg *zap.Logger
+	// atomicLevel 日志文件的全局级别，运行时可通过SetLevel调整，组件级别见SetComponentLevel
+	atomicLevel = zap.NewAtomicLevel()
 )
 
 // Init 初始化logger
 func Init(cfg *LogConfig, mode string) (err error) {
 	writeSyncer := getLogWriter(cfg.Filename, cfg.MaxSize, cfg.MaxBackups, cfg.MaxAge, cfg.Daily)
-	encoder := getEncoder()
+	encoder := newScrubEncoder(getEncoder(), cfg)
+	setLogrusScrubber(cfg)
 	var l = new(zapcore.Level)
 	err = l.UnmarshalText([]byte(cfg.Level))
 	if err != nil {
 		return
 	}
+	atomicLevel.SetLevel(*l)
 	var core zapcore.Core
 	if mode == "dev" || mode == "development" {
 		// 进入开发模式，日志输出到终端，启用带色彩的编码器
@@ -65,7 +76,7 @@ func Init(cfg *LogConfig, mode string) (err error) {
 		consoleEncoderConfig.EncodeCaller = func(caller zapcore.EntryCaller, enc zapcore.PrimitiveArrayEncoder) {
 			enc.AppendString("\x1b[90m" + caller.TrimmedPath() + "\x1b[0m")
 		}
-		consoleEncoder := zapcore.NewConsoleEncoder(consoleEncoderConfig)
+		consoleEncoder := newScrubEncoder(zapcore.NewConsoleEncoder(consoleEncoderConfig), cfg)
 
 		// 为不同日志级别设置不同的颜色以增强可读性
 		highPriority := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
@@ -76,14 +87,15 @@ func Init(cfg *LogConfig, mode string) (err error) {
 		})
 
 		core = zapcore.NewTee(
-			zapcore.NewCore(encoder, writeSyncer, l),
+			newLevelCore(zapcore.NewCore(encoder, writeSyncer, zapcore.DebugLevel)),
 			zapcore.NewCore(consoleEncoder, zapcore.Lock(os.Stdout), lowPriority),
 			zapcore.NewCore(consoleEncoder, zapcore.Lock(os.Stderr), highPriority),
 		)
 	} else {
-		core = zapcore.NewCore(encoder, writeSyncer, l)
+		core = newLevelCore(zapcore.NewCore(encoder, writeSyncer, zapcore.DebugLevel))
 	}
 	// 复习回顾：日志默认输出到app.log，如何将err日志单独在 app.err.log 记录一份
+	core = newDebugSampler(core, cfg.SampleInitial, cfg.SampleThereafter)
 
 	Lg = zap.New(core, zap.AddCaller()) // zap.AddCaller() 添加调用栈信息
 
@@ -122,6 +134,16 @@ func getLogWriter(filename string, maxSize, maxBackup, maxAge int, daily bool) z
 	return zapcore.AddSync(lumberJackLogger)
 }
 
+// SetLevel 运行时修改日志级别，无需重新Init
+func SetLevel(text string) error {
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(text)); err != nil {
+		return err
+	}
+	atomicLevel.SetLevel(l)
+	return nil
+}
+
 // Info 通用 info 日志方法
 func Info(msg string, fields ...zap.Field) {
 	Lg.Info(msg, fields...)
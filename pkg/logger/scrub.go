@@ -0,0 +1,207 @@
+package logger
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// redactedText 转写文本脱敏后的占位
+const redactedText = "[redacted]"
+
+// sampleTick 调试日志采样周期
+const sampleTick = time.Second
+
+// phoneNumber 7位以上的连续数字，可带+前缀
+var phoneNumber = regexp.MustCompile(`\+?\d{7,}`)
+
+// transcriptKeys 记录ASR识别文本、提示词和模型回复的字段
+var transcriptKeys = map[string]bool{
+	"text":          true,
+	"input":         true,
+	"user_input":    true,
+	"current_input": true,
+	"prompt":        true,
+	"response":      true,
+	"transcript":    true,
+}
+
+// MaskNumbers 遮盖字符串中7到15位电话号码的中间数字，如 13812345678 -> 138****5678
+func MaskNumbers(s string) string {
+	return phoneNumber.ReplaceAllStringFunc(s, func(match string) string {
+		start := 0
+		if match[0] == '+' {
+			start = 1
+		}
+		digits := match[start:]
+		// E.164号码最多15位，更长的数字串不是号码
+		if len(digits) > 15 {
+			return match
+		}
+		keepHead, keepTail := 3, 4
+		if len(digits) < 11 {
+			keepHead, keepTail = 2, 2
+		}
+		return match[:start] + digits[:keepHead] + strings.Repeat("*", len(digits)-keepHead-keepTail) + digits[len(digits)-keepTail:]
+	})
+}
+
+// scrubber 按配置清洗日志中的个人信息
+type scrubber struct {
+	maskNumbers       bool
+	redactTranscripts bool
+}
+
+// idField ID类字段（call_id、session_id等）中的数字不是号码，保留原样便于排查
+func idField(key string) bool {
+	return key == "id" || strings.HasSuffix(key, "_id") || strings.HasSuffix(key, "_sid")
+}
+
+func (s scrubber) scrubString(key, value string) string {
+	if s.redactTranscripts && transcriptKeys[key] && value != "" {
+		return redactedText
+	}
+	if s.maskNumbers && !idField(key) {
+		return MaskNumbers(value)
+	}
+	return value
+}
+
+// scrubField 清洗字符串、错误和Stringer字段，其他类型原样返回
+func (s scrubber) scrubField(field zapcore.Field) zapcore.Field {
+	switch field.Type {
+	case zapcore.StringType:
+		field.String = s.scrubString(field.Key, field.String)
+	case zapcore.ErrorType:
+		if err, ok := field.Interface.(error); ok && err != nil {
+			return zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: s.scrubString(field.Key, err.Error())}
+		}
+	case zapcore.StringerType:
+		if stringer, ok := field.Interface.(fmt.Stringer); ok && stringer != nil {
+			return zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: s.scrubString(field.Key, stringer.String())}
+		}
+	}
+	return field
+}
+
+// scrubEncoder 在编码前清洗消息和字段，With添加的字段经AddString清洗
+type scrubEncoder struct {
+	zapcore.Encoder
+	scrubber scrubber
+}
+
+func (e *scrubEncoder) Clone() zapcore.Encoder {
+	return &scrubEncoder{Encoder: e.Encoder.Clone(), scrubber: e.scrubber}
+}
+
+func (e *scrubEncoder) AddString(key, value string) {
+	e.Encoder.AddString(key, e.scrubber.scrubString(key, value))
+}
+
+func (e *scrubEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	if e.scrubber.maskNumbers {
+		ent.Message = MaskNumbers(ent.Message)
+	}
+	scrubbed := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		scrubbed[i] = e.scrubber.scrubField(field)
+	}
+	return e.Encoder.EncodeEntry(ent, scrubbed)
+}
+
+// newScrubEncoder 未开启任何清洗时返回原编码器
+func newScrubEncoder(enc zapcore.Encoder, cfg *LogConfig) zapcore.Encoder {
+	if !cfg.ScrubPII && !cfg.RedactTranscripts {
+		return enc
+	}
+	return &scrubEncoder{Encoder: enc, scrubber: scrubber{maskNumbers: cfg.ScrubPII, redactTranscripts: cfg.RedactTranscripts}}
+}
+
+// scrubHook 对logrus日志做与zap相同的清洗，LLM服务和ua包仍使用logrus
+type scrubHook struct {
+	mutex    sync.RWMutex
+	scrubber scrubber
+}
+
+// logrusHook 所有logrus logger共用，Init时更新清洗配置
+var (
+	logrusHook        = &scrubHook{}
+	installLogrusHook sync.Once
+)
+
+// LogrusHook 返回按当前日志配置清洗的logrus Hook，自建的logrus.Logger需自行AddHook
+func LogrusHook() logrus.Hook {
+	return logrusHook
+}
+
+func (h *scrubHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire 清洗消息和字段，entry是logrus为本条日志复制的，不影响调用方的WithFields
+func (h *scrubHook) Fire(entry *logrus.Entry) error {
+	h.mutex.RLock()
+	s := h.scrubber
+	h.mutex.RUnlock()
+	if !s.maskNumbers && !s.redactTranscripts {
+		return nil
+	}
+	if s.maskNumbers {
+		entry.Message = MaskNumbers(entry.Message)
+	}
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = s.scrubString(key, v)
+		case error:
+			if v != nil {
+				entry.Data[key] = s.scrubString(key, v.Error())
+			}
+		case fmt.Stringer:
+			if v != nil {
+				entry.Data[key] = s.scrubString(key, v.String())
+			}
+		}
+	}
+	return nil
+}
+
+// setLogrusScrubber 更新清洗配置，首次调用时挂到logrus的全局logger上
+func setLogrusScrubber(cfg *LogConfig) {
+	logrusHook.mutex.Lock()
+	logrusHook.scrubber = scrubber{maskNumbers: cfg.ScrubPII, redactTranscripts: cfg.RedactTranscripts}
+	logrusHook.mutex.Unlock()
+	installLogrusHook.Do(func() { logrus.AddHook(logrusHook) })
+}
+
+// debugSampler 只对Debug级别采样：每秒同一消息先输出initial条，之后每thereafter条输出一条。
+// 逐包的调试日志（RTP、音频分析）量大，其他级别不受影响
+type debugSampler struct {
+	zapcore.Core
+	sampled zapcore.Core
+}
+
+func (c *debugSampler) With(fields []zapcore.Field) zapcore.Core {
+	return &debugSampler{Core: c.Core.With(fields), sampled: c.sampled.With(fields)}
+}
+
+func (c *debugSampler) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if ent.Level == zapcore.DebugLevel {
+		return c.sampled.Check(ent, ce)
+	}
+	return c.Core.Check(ent, ce)
+}
+
+// newDebugSampler initial为0时不采样
+func newDebugSampler(core zapcore.Core, initial, thereafter int) zapcore.Core {
+	if initial <= 0 {
+		return core
+	}
+	return &debugSampler{Core: core, sampled: zapcore.NewSamplerWithOptions(core, sampleTick, initial, thereafter)}
+}
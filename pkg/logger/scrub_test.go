@@ -0,0 +1,139 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+)
+
+func TestMaskNumbers(t *testing.T) {
+	cases := map[string]string{
+		"13812345678":              "138****5678",
+		"call from +8613812345678": "call from +861******5678",
+		"sip:6001234@pbx.local":    "sip:60***34@pbx.local",
+		"ext 1001 port 5060":       "ext 1001 port 5060",
+		"1234567890123456789":      "1234567890123456789",
+		"192.168.1.100:5060":       "192.168.1.100:5060",
+	}
+	for in, want := range cases {
+		if got := MaskNumbers(in); got != want {
+			t.Errorf("MaskNumbers(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestScrubPII(t *testing.T) {
+	logPath := makeTmpLogFile(t, "scrub.log")
+	cfg := &LogConfig{
+		Level:             "debug",
+		Filename:          logPath,
+		MaxSize:           5,
+		MaxAge:            1,
+		MaxBackups:        1,
+		ScrubPII:          true,
+		RedactTranscripts: true,
+	}
+	if err := Init(cfg, "prod"); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+
+	Lg.With(zap.String("phone", "13812345678")).Info("incoming call 13900001111",
+		zap.String("call_id", "12345678901@host"),
+		zap.String("text", "我的号码是13812345678"),
+		zap.Error(errors.New("dial 13700002222 failed")))
+	Sync()
+
+	content := readFile(t, logPath)
+	for _, leaked := range []string{"13812345678", "13900001111", "13700002222", "我的号码是"} {
+		if strings.Contains(content, leaked) {
+			t.Errorf("log contains %q: %s", leaked, content)
+		}
+	}
+	for _, want := range []string{"138****5678", "139****1111", "137****2222", "12345678901@host", redactedText} {
+		if !strings.Contains(content, want) {
+			t.Errorf("log missing %q: %s", want, content)
+		}
+	}
+}
+
+func TestDebugSampling(t *testing.T) {
+	logPath := makeTmpLogFile(t, "sample.log")
+	cfg := &LogConfig{
+		Level:            "debug",
+		Filename:         logPath,
+		MaxSize:          5,
+		MaxAge:           1,
+		MaxBackups:       1,
+		SampleInitial:    2,
+		SampleThereafter: 100,
+	}
+	if err := Init(cfg, "prod"); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		Debug("rtp packet")
+		Info("call event")
+	}
+	Sync()
+
+	content := readFile(t, logPath)
+	if n := strings.Count(content, "rtp packet"); n != 2 {
+		t.Errorf("expected 2 sampled debug entries, got %d", n)
+	}
+	if n := strings.Count(content, "call event"); n != 50 {
+		t.Errorf("expected all 50 info entries, got %d", n)
+	}
+}
+
+func TestScrubLogrus(t *testing.T) {
+	cfg := &LogConfig{
+		Level:             "debug",
+		Filename:          makeTmpLogFile(t, "logrus.log"),
+		MaxSize:           5,
+		MaxAge:            1,
+		MaxBackups:        1,
+		ScrubPII:          true,
+		RedactTranscripts: true,
+	}
+	if err := Init(cfg, "prod"); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+
+	var out bytes.Buffer
+	lg := logrus.New()
+	lg.Out = &out
+	lg.AddHook(LogrusHook())
+	fields := logrus.Fields{"start_line": "OPTIONS sip:13812345678@pbx.local SIP/2.0", "call_id": "12345678901@host", "text": "我的号码是13812345678"}
+	lg.WithFields(fields).WithError(errors.New("dial 13700002222 failed")).Info("call from 13900001111")
+
+	content := out.String()
+	for _, leaked := range []string{"13812345678", "13900001111", "13700002222", "我的号码是"} {
+		if strings.Contains(content, leaked) {
+			t.Errorf("logrus output contains %q: %s", leaked, content)
+		}
+	}
+	for _, want := range []string{"138****5678", "139****1111", "137****2222", "12345678901@host", redactedText} {
+		if !strings.Contains(content, want) {
+			t.Errorf("logrus output missing %q: %s", want, content)
+		}
+	}
+	if fields["start_line"] != "OPTIONS sip:13812345678@pbx.local SIP/2.0" {
+		t.Error("hook modified the caller's fields")
+	}
+
+	// 关闭清洗后原样输出
+	cfg.ScrubPII, cfg.RedactTranscripts = false, false
+	if err := Init(cfg, "prod"); err != nil {
+		t.Fatalf("Init error: %v", err)
+	}
+	out.Reset()
+	lg.Info("call from 13900001111")
+	if !strings.Contains(out.String(), "13900001111") {
+		t.Errorf("logrus output scrubbed with scrubbing disabled: %s", out.String())
+	}
+}
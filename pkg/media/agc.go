@@ -0,0 +1,114 @@
+package media
+
+import "math"
+
+const (
+	// agcNoiseFloor is the RMS below which a frame is treated as silence and the gain is held
+	agcNoiseFloor = 100.0
+	// agcAttack and agcRelease smooth gain changes: reductions apply quickly, increases slowly
+	agcAttack  = 0.5
+	agcRelease = 0.05
+)
+
+// AGCConfig configures an AGC. Zero values fall back to the defaults.
+type AGCConfig struct {
+	TargetLevel int     // target RMS level, default 3000 (about -20 dBFS)
+	MaxGain     float64 // maximum amplification, default 4
+	Ceiling     int     // peak limiter ceiling, default 30000
+}
+
+// AGC is an automatic gain control with a peak limiter for 16-bit PCM.
+// It keeps its gain between calls, so use one instance per audio stream.
+type AGC struct {
+	target  float64
+	maxGain float64
+	ceiling float64
+	gain    float64
+}
+
+// NewAGC creates an AGC starting at unity gain
+func NewAGC(cfg AGCConfig) *AGC {
+	agc := &AGC{
+		target:  float64(cfg.TargetLevel),
+		maxGain: cfg.MaxGain,
+		ceiling: float64(cfg.Ceiling),
+		gain:    1,
+	}
+	if agc.target <= 0 {
+		agc.target = 3000
+	}
+	if agc.maxGain <= 0 {
+		agc.maxGain = 4
+	}
+	if agc.ceiling <= 0 || agc.ceiling > math.MaxInt16 {
+		agc.ceiling = 30000
+	}
+	return agc
+}
+
+// Gain returns the current gain
+func (a *AGC) Gain() float64 {
+	return a.gain
+}
+
+// Process adjusts one frame in place and returns it
+func (a *AGC) Process(samples []int16) []int16 {
+	if len(samples) == 0 {
+		return samples
+	}
+
+	var sum float64
+	var peak float64
+	for _, sample := range samples {
+		value := float64(sample)
+		sum += value * value
+		if value = math.Abs(value); value > peak {
+			peak = value
+		}
+	}
+	rms := math.Sqrt(sum / float64(len(samples)))
+
+	// Silence keeps the previous gain so background noise is not pumped up
+	if rms >= agcNoiseFloor {
+		desired := min(a.target/rms, a.maxGain)
+		coeff := agcRelease
+		if desired < a.gain {
+			coeff = agcAttack
+		}
+		a.gain += (desired - a.gain) * coeff
+	}
+
+	gain := a.gain
+	// The limiter lowers the gain for this frame when its peak would exceed the ceiling
+	if peak*gain > a.ceiling {
+		gain = a.ceiling / peak
+	}
+	if gain == 1 {
+		return samples
+	}
+	for i, sample := range samples {
+		value := float64(sample) * gain
+		value = max(min(value, a.ceiling), -a.ceiling)
+		samples[i] = int16(value)
+	}
+	return samples
+}
+
+// ProcessBlock runs a whole buffer through the AGC in frames of frameSamples
+func (a *AGC) ProcessBlock(samples []int16, frameSamples int) []int16 {
+	if frameSamples <= 0 {
+		frameSamples = len(samples)
+	}
+	for start := 0; start < len(samples); start += frameSamples {
+		a.Process(samples[start:min(start+frameSamples, len(samples))])
+	}
+	return samples
+}
+
+// Filter applies the AGC to pipeline frames, leaving echo frames untouched
+func (a *AGC) Filter(frame *PCMFrame) (*PCMFrame, error) {
+	if !frame.Echo {
+		a.Process(frame.Samples)
+	}
+	return frame, nil
+}
@@ -0,0 +1,183 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// ParticipantRole identifies a leg in a bridged call
+type ParticipantRole string
+
+const (
+	RoleCaller     ParticipantRole = "caller"
+	RoleAgent      ParticipantRole = "agent"
+	RoleSupervisor ParticipantRole = "supervisor"
+)
+
+// SupervisorMode controls what a supervisor leg hears and who hears it
+type SupervisorMode string
+
+const (
+	// SupervisorListen hears both sides, nobody (not even other supervisors) hears the supervisor
+	SupervisorListen SupervisorMode = "listen"
+	// SupervisorWhisper hears both sides, only agents hear the supervisor
+	SupervisorWhisper SupervisorMode = "whisper"
+	// SupervisorBarge joins the conversation, everyone hears everyone
+	SupervisorBarge SupervisorMode = "barge"
+)
+
+// ErrParticipantNotFound is returned for unknown participant ids
+var ErrParticipantNotFound = errors.New("participant not found")
+
+// MixerQueueFrames is how many pushed frames a participant may have waiting, the oldest is dropped beyond it
+const MixerQueueFrames = 5
+
+type mixerParticipant struct {
+	role   ParticipantRole
+	mode   SupervisorMode
+	frames [][]int16
+}
+
+// ConferenceMixer mixes 16-bit little-endian PCM frames between call legs.
+// Every participant receives the sum of the legs it is allowed to hear, never its own audio.
+// Pushed frames are queued per participant and each Mix consumes one, so legs whose packets
+// arrive off the mixing clock are neither overwritten nor skipped.
+type ConferenceMixer struct {
+	mu           sync.Mutex
+	participants map[string]*mixerParticipant
+}
+
+// NewConferenceMixer creates an empty mixer
+func NewConferenceMixer() *ConferenceMixer {
+	return &ConferenceMixer{
+		participants: make(map[string]*mixerParticipant),
+	}
+}
+
+// AddParticipant adds a leg, supervisors start in listen-only mode
+func (m *ConferenceMixer) AddParticipant(id string, role ParticipantRole) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := &mixerParticipant{role: role}
+	if role == RoleSupervisor {
+		p.mode = SupervisorListen
+	}
+	m.participants[id] = p
+}
+
+// RemoveParticipant removes a leg from the mix
+func (m *ConferenceMixer) RemoveParticipant(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.participants, id)
+}
+
+// SetSupervisorMode switches a supervisor between listen, whisper and barge
+func (m *ConferenceMixer) SetSupervisorMode(id string, mode SupervisorMode) error {
+	switch mode {
+	case SupervisorListen, SupervisorWhisper, SupervisorBarge:
+	default:
+		return fmt.Errorf("unsupported supervisor mode: %s", mode)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.participants[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrParticipantNotFound, id)
+	}
+	if p.role != RoleSupervisor {
+		return fmt.Errorf("participant %s is not a supervisor", id)
+	}
+	p.mode = mode
+	return nil
+}
+
+// Len returns the number of participants
+func (m *ConferenceMixer) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.participants)
+}
+
+// Push queues the next inbound PCM frame of a participant
+func (m *ConferenceMixer) Push(id string, frame []byte) error {
+	samples := make([]int16, len(frame)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(frame[i*2:]))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.participants[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrParticipantNotFound, id)
+	}
+	if len(p.frames) == MixerQueueFrames {
+		p.frames = p.frames[1:]
+	}
+	p.frames = append(p.frames, samples)
+	return nil
+}
+
+// Mix produces one outbound frame per participant from the oldest queued frame of every leg it hears
+func (m *ConferenceMixer) Mix() map[string][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := make(map[string][]int16, len(m.participants))
+	for id, p := range m.participants {
+		if len(p.frames) > 0 {
+			current[id] = p.frames[0]
+			p.frames = p.frames[1:]
+		}
+	}
+
+	out := make(map[string][]byte, len(m.participants))
+	for listenerID, listener := range m.participants {
+		var acc []int32
+		for speakerID, speaker := range m.participants {
+			frame := current[speakerID]
+			if speakerID == listenerID || len(frame) == 0 || !canHear(listener, speaker) {
+				continue
+			}
+			if len(frame) > len(acc) {
+				acc = append(acc, make([]int32, len(frame)-len(acc))...)
+			}
+			for i, s := range frame {
+				acc[i] += int32(s)
+			}
+		}
+
+		buf := make([]byte, len(acc)*2)
+		for i, v := range acc {
+			if v > math.MaxInt16 {
+				v = math.MaxInt16
+			} else if v < math.MinInt16 {
+				v = math.MinInt16
+			}
+			binary.LittleEndian.PutUint16(buf[i*2:], uint16(int16(v)))
+		}
+		out[listenerID] = buf
+	}
+	return out
+}
+
+// canHear reports whether listener receives speaker's audio
+func canHear(listener, speaker *mixerParticipant) bool {
+	if speaker.role != RoleSupervisor {
+		return true
+	}
+	switch speaker.mode {
+	case SupervisorBarge:
+		return true
+	case SupervisorWhisper:
+		return listener.role == RoleAgent
+	default:
+		// listen is receive-only, other supervisors do not hear it either
+		return false
+	}
+}
@@ -0,0 +1,123 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+// pcmFrame 生成取值恒为value的PCM帧
+func pcmFrame(value int16, samples int) []byte {
+	frame := make([]byte, samples*2)
+	for i := 0; i < samples; i++ {
+		binary.LittleEndian.PutUint16(frame[i*2:], uint16(value))
+	}
+	return frame
+}
+
+// firstSample 返回帧的第一个样本，空帧返回0
+func firstSample(frame []byte) int16 {
+	if len(frame) < 2 {
+		return 0
+	}
+	return int16(binary.LittleEndian.Uint16(frame))
+}
+
+func newTestMixer(t *testing.T, mode SupervisorMode) *ConferenceMixer {
+	t.Helper()
+	m := NewConferenceMixer()
+	m.AddParticipant("caller", RoleCaller)
+	m.AddParticipant("agent", RoleAgent)
+	m.AddParticipant("sup1", RoleSupervisor)
+	m.AddParticipant("sup2", RoleSupervisor)
+	if err := m.SetSupervisorMode("sup1", mode); err != nil {
+		t.Fatalf("SetSupervisorMode() error = %v", err)
+	}
+	return m
+}
+
+func TestConferenceMixerSupervisorModes(t *testing.T) {
+	tests := []struct {
+		mode SupervisorMode
+		want map[string]int16 // 只有sup1说话时各腿听到的声音
+	}{
+		{SupervisorListen, map[string]int16{"caller": 0, "agent": 0, "sup2": 0}},
+		{SupervisorWhisper, map[string]int16{"caller": 0, "agent": 100, "sup2": 0}},
+		{SupervisorBarge, map[string]int16{"caller": 100, "agent": 100, "sup2": 100}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			m := newTestMixer(t, tt.mode)
+			if err := m.Push("sup1", pcmFrame(100, 160)); err != nil {
+				t.Fatal(err)
+			}
+			out := m.Mix()
+			for id, want := range tt.want {
+				if got := firstSample(out[id]); got != want {
+					t.Errorf("%s hears %d, want %d", id, got, want)
+				}
+			}
+			if len(out["sup1"]) != 0 {
+				t.Error("supervisor hears itself")
+			}
+		})
+	}
+}
+
+func TestConferenceMixerCallerAndAgent(t *testing.T) {
+	m := newTestMixer(t, SupervisorListen)
+	m.Push("caller", pcmFrame(100, 160))
+	m.Push("agent", pcmFrame(200, 160))
+	out := m.Mix()
+	want := map[string]int16{"caller": 200, "agent": 100, "sup1": 300, "sup2": 300}
+	for id, sample := range want {
+		if got := firstSample(out[id]); got != sample {
+			t.Errorf("%s hears %d, want %d", id, got, sample)
+		}
+	}
+}
+
+func TestConferenceMixerQueuesFrames(t *testing.T) {
+	m := newTestMixer(t, SupervisorListen)
+	// 两个包在同一个混音周期内到达，依次在两个周期中播出
+	m.Push("caller", pcmFrame(1, 160))
+	m.Push("caller", pcmFrame(2, 160))
+	for tick, want := range []int16{1, 2, 0} {
+		if got := firstSample(m.Mix()["agent"]); got != want {
+			t.Errorf("tick %d: agent hears %d, want %d", tick, got, want)
+		}
+	}
+
+	// 超过队列长度时丢弃最旧的帧
+	for i := 1; i <= MixerQueueFrames+2; i++ {
+		m.Push("caller", pcmFrame(int16(i), 160))
+	}
+	for tick := 0; tick < MixerQueueFrames; tick++ {
+		if got, want := firstSample(m.Mix()["agent"]), int16(tick+3); got != want {
+			t.Errorf("after overflow tick %d: agent hears %d, want %d", tick, got, want)
+		}
+	}
+	if out := m.Mix()["agent"]; len(out) != 0 {
+		t.Errorf("queue not drained, agent hears %v", out)
+	}
+}
+
+func TestConferenceMixerClipsAndValidates(t *testing.T) {
+	m := newTestMixer(t, SupervisorBarge)
+	m.Push("caller", pcmFrame(math.MaxInt16, 160))
+	m.Push("sup1", pcmFrame(math.MaxInt16, 160))
+	if got := firstSample(m.Mix()["agent"]); got != math.MaxInt16 {
+		t.Errorf("agent hears %d, want clipped %d", got, math.MaxInt16)
+	}
+
+	if err := m.Push("nobody", pcmFrame(1, 160)); !errors.Is(err, ErrParticipantNotFound) {
+		t.Errorf("Push(unknown) error = %v, want ErrParticipantNotFound", err)
+	}
+	if err := m.SetSupervisorMode("agent", SupervisorBarge); err == nil {
+		t.Error("SetSupervisorMode(agent) expected error")
+	}
+	if err := m.SetSupervisorMode("sup1", "shout"); err == nil {
+		t.Error("SetSupervisorMode(unknown mode) expected error")
+	}
+}
@@ -0,0 +1,144 @@
+package media
+
+import (
+	"errors"
+	"sync"
+)
+
+// PCMFrame is one frame of mono 16-bit PCM flowing through a FramePipeline.
+// Filters annotate it in place; sinks decide what to do based on the annotations.
+type PCMFrame struct {
+	Samples    []int16
+	SampleRate int
+	// Echo marks frames recognised as playback echo, they never count as speech
+	Echo bool
+	// Speech and SpeechRatio are set by a voice activity filter
+	Speech      bool
+	SpeechRatio float64
+}
+
+// FrameSource produces frames for a pipeline, returning an error when none is available
+type FrameSource interface {
+	ReadFrame() (*PCMFrame, error)
+}
+
+// FrameFilter transforms or annotates a frame. Returning nil drops the frame.
+type FrameFilter interface {
+	Filter(frame *PCMFrame) (*PCMFrame, error)
+}
+
+// FrameSink consumes processed frames
+type FrameSink interface {
+	WriteFrame(frame *PCMFrame) error
+}
+
+// FrameSourceFunc adapts a function to FrameSource
+type FrameSourceFunc func() (*PCMFrame, error)
+
+func (f FrameSourceFunc) ReadFrame() (*PCMFrame, error) { return f() }
+
+// FrameFilterFunc adapts a function to FrameFilter
+type FrameFilterFunc func(frame *PCMFrame) (*PCMFrame, error)
+
+func (f FrameFilterFunc) Filter(frame *PCMFrame) (*PCMFrame, error) { return f(frame) }
+
+// FrameSinkFunc adapts a function to FrameSink
+type FrameSinkFunc func(frame *PCMFrame) error
+
+func (f FrameSinkFunc) WriteFrame(frame *PCMFrame) error { return f(frame) }
+
+// ErrNoFrameSource is returned by Next on a pipeline built without a source
+var ErrNoFrameSource = errors.New("pipeline has no frame source")
+
+// FramePipeline runs frames synchronously through source → filters → sinks.
+// Stages can be added while the pipeline is in use, e.g. to start a recording mid-call.
+type FramePipeline struct {
+	mu      sync.RWMutex
+	source  FrameSource
+	filters []FrameFilter
+	sinks   []FrameSink
+}
+
+// NewFramePipeline creates a pipeline reading from source, which may be nil when frames are pushed
+func NewFramePipeline(source FrameSource) *FramePipeline {
+	return &FramePipeline{source: source}
+}
+
+// Use appends filters, run in the order added
+func (p *FramePipeline) Use(filters ...FrameFilter) *FramePipeline {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.filters = append(p.filters, filters...)
+	return p
+}
+
+// To appends sinks, every surviving frame is delivered to all of them
+func (p *FramePipeline) To(sinks ...FrameSink) *FramePipeline {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sinks = append(p.sinks, sinks...)
+	return p
+}
+
+// Next reads one frame from the source and processes it.
+// It returns the processed frame, or nil when a filter dropped it.
+func (p *FramePipeline) Next() (*PCMFrame, error) {
+	if p.source == nil {
+		return nil, ErrNoFrameSource
+	}
+	frame, err := p.source.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	return p.Process(frame)
+}
+
+// Process runs a frame through the filters and sinks without using the source.
+// Every sink receives the frame even if an earlier one fails; the first error is returned.
+func (p *FramePipeline) Process(frame *PCMFrame) (*PCMFrame, error) {
+	p.mu.RLock()
+	filters := p.filters
+	sinks := p.sinks
+	p.mu.RUnlock()
+
+	for _, filter := range filters {
+		var err error
+		if frame, err = filter.Filter(frame); err != nil {
+			return nil, err
+		}
+		if frame == nil {
+			return nil, nil
+		}
+	}
+
+	var firstErr error
+	for _, sink := range sinks {
+		if err := sink.WriteFrame(frame); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return frame, firstErr
+}
+
+// EnergyVAD marks frames as speech when more than MinRatio of the samples exceed Threshold in magnitude
+type EnergyVAD struct {
+	Threshold int16
+	MinRatio  float64
+}
+
+func (v EnergyVAD) Filter(frame *PCMFrame) (*PCMFrame, error) {
+	frame.Speech = false
+	frame.SpeechRatio = 0
+	if frame.Echo || len(frame.Samples) == 0 {
+		return frame, nil
+	}
+	active := 0
+	for _, sample := range frame.Samples {
+		if sample > v.Threshold || sample < -v.Threshold {
+			active++
+		}
+	}
+	frame.SpeechRatio = float64(active) / float64(len(frame.Samples))
+	frame.Speech = frame.SpeechRatio > v.MinRatio
+	return frame, nil
+}
@@ -0,0 +1,88 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// AWSProvider reads secrets from AWS Secrets Manager. Names are "secret-id#key", the key
+// selects a value from a JSON secret string. Credentials come from the default AWS chain.
+type AWSProvider struct {
+	config aws.Config
+	signer *v4.Signer
+	client *http.Client
+}
+
+// NewAWSProvider loads the default AWS configuration, region overrides AWS_REGION
+func NewAWSProvider(region string) (*AWSProvider, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("SECRETS_AWS_REGION or AWS_REGION is required for the aws backend")
+	}
+	return &AWSProvider{config: cfg, signer: v4.NewSigner(), client: &http.Client{}}, nil
+}
+
+func (p *AWSProvider) Get(ctx context.Context, name string) (string, error) {
+	secretID, field := splitField(name)
+	payload, err := json.Marshal(map[string]string{"SecretId": secretID})
+	if err != nil {
+		return "", err
+	}
+	endpoint := fmt.Sprintf("https://secretsmanager.%s.amazonaws.com/", p.config.Region)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+
+	creds, err := p.config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	hash := sha256.Sum256(payload)
+	if err := p.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "secretsmanager", p.config.Region, time.Now()); err != nil {
+		return "", fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("secrets manager request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("secrets manager returned %s", resp.Status)
+	}
+
+	var body struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid secrets manager response: %w", err)
+	}
+	if field == "" {
+		return body.SecretString, nil
+	}
+	var values map[string]any
+	if err := json.Unmarshal([]byte(body.SecretString), &values); err != nil {
+		return "", fmt.Errorf("secret %q is not a JSON object: %w", secretID, err)
+	}
+	return pickField(values, field)
+}
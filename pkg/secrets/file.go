@@ -0,0 +1,116 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// FileProvider reads secrets from a JSON object encrypted with AES-256-GCM: the file holds
+// the nonce followed by the ciphertext. The file is re-read on every lookup, so replacing it
+// rotates the secrets.
+type FileProvider struct {
+	path string
+	key  []byte
+}
+
+// NewFileProvider opens the encrypted file at path, key is 32 bytes in hex or base64
+func NewFileProvider(path, key string) (*FileProvider, error) {
+	if path == "" {
+		return nil, fmt.Errorf("SECRETS_FILE is required for the file backend")
+	}
+	rawKey, err := parseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &FileProvider{path: path, key: rawKey}, nil
+}
+
+func (p *FileProvider) Get(ctx context.Context, name string) (string, error) {
+	values, err := p.read()
+	if err != nil {
+		return "", err
+	}
+	value, ok := values[name]
+	if !ok {
+		return "", fmt.Errorf("secret not found in %s", p.path)
+	}
+	return value, nil
+}
+
+func (p *FileProvider) read() (map[string]string, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets file: %w", err)
+	}
+	gcm, err := newGCM(p.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("secrets file too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secrets file: %w", err)
+	}
+	var values map[string]string
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, fmt.Errorf("invalid secrets file: %w", err)
+	}
+	return values, nil
+}
+
+// SealFile writes values to path in the format read by FileProvider
+func SealFile(path, key string, values map[string]string) error {
+	rawKey, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(rawKey)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+
+	// 先写临时文件再替换，读取方不会读到写了一半的文件
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, sealed, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// parseKey decodes a 32-byte key given in hex or base64
+func parseKey(key string) ([]byte, error) {
+	if raw, err := hex.DecodeString(key); err == nil && len(raw) == 32 {
+		return raw, nil
+	}
+	if raw, err := base64.StdEncoding.DecodeString(key); err == nil && len(raw) == 32 {
+		return raw, nil
+	}
+	return nil, fmt.Errorf("SECRETS_FILE_KEY must be 32 bytes in hex or base64")
+}
@@ -0,0 +1,77 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+)
+
+const gcpScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// GCPProvider reads secrets from Google Secret Manager with application default credentials.
+// Names are a secret id in project (latest version), "id/versions/N", or a full
+// "projects/.../secrets/.../versions/..." resource.
+type GCPProvider struct {
+	project string
+	client  *http.Client
+}
+
+// NewGCPProvider creates a provider for secrets in project
+func NewGCPProvider(project string) (*GCPProvider, error) {
+	client, err := google.DefaultClient(context.Background(), gcpScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GCP credentials: %w", err)
+	}
+	return &GCPProvider{project: project, client: client}, nil
+}
+
+func (p *GCPProvider) Get(ctx context.Context, name string) (string, error) {
+	resource, err := p.resource(name)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://secretmanager.googleapis.com/v1/"+resource+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("secret manager request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("secret manager returned %s", resp.Status)
+	}
+
+	var body struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid secret manager response: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(body.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("invalid secret payload: %w", err)
+	}
+	return string(data), nil
+}
+
+func (p *GCPProvider) resource(name string) (string, error) {
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	if strings.HasPrefix(name, "projects/") {
+		return name, nil
+	}
+	if p.project == "" {
+		return "", fmt.Errorf("SECRETS_GCP_PROJECT is required for secret %q", name)
+	}
+	return fmt.Sprintf("projects/%s/secrets/%s", p.project, name), nil
+}
@@ -0,0 +1,203 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RefPrefix marks a config value or DB column as a reference resolved through the secrets
+// backend, e.g. LLM_API_KEY=secret:lingsip/llm#api_key. Other values are used verbatim.
+const RefPrefix = "secret:"
+
+// fetchTimeout bounds a single backend lookup
+const fetchTimeout = 10 * time.Second
+
+// ErrNoBackend is returned when a reference is resolved without a configured backend
+var ErrNoBackend = errors.New("no secrets backend configured")
+
+// Provider fetches a secret by its backend-specific name
+type Provider interface {
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// IsRef reports whether value is a secret reference
+func IsRef(value string) bool {
+	return strings.HasPrefix(value, RefPrefix)
+}
+
+// Manager resolves references through a Provider and caches the values until Refresh
+type Manager struct {
+	provider Provider
+	mutex    sync.RWMutex
+	cache    map[string]string
+}
+
+// NewManager creates a manager backed by provider
+func NewManager(provider Provider) *Manager {
+	return &Manager{provider: provider, cache: make(map[string]string)}
+}
+
+// Resolve returns value unchanged unless it is a reference, which is looked up in the cache
+// or fetched from the backend
+func (m *Manager) Resolve(ctx context.Context, value string) (string, error) {
+	if !IsRef(value) {
+		return value, nil
+	}
+	name := strings.TrimPrefix(value, RefPrefix)
+	if m == nil || m.provider == nil {
+		return "", fmt.Errorf("resolve %q: %w", name, ErrNoBackend)
+	}
+
+	m.mutex.RLock()
+	cached, ok := m.cache[name]
+	m.mutex.RUnlock()
+	if ok {
+		return cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	secret, err := m.provider.Get(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("resolve %q: %w", name, err)
+	}
+	m.mutex.Lock()
+	m.cache[name] = secret
+	m.mutex.Unlock()
+	return secret, nil
+}
+
+// Refresh re-fetches every cached secret and returns the names whose value rotated. A secret
+// that fails to fetch keeps its previous value.
+func (m *Manager) Refresh(ctx context.Context) ([]string, error) {
+	if m == nil || m.provider == nil {
+		return nil, nil
+	}
+	m.mutex.RLock()
+	names := make([]string, 0, len(m.cache))
+	for name := range m.cache {
+		names = append(names, name)
+	}
+	m.mutex.RUnlock()
+	sort.Strings(names)
+
+	var rotated []string
+	var errs []error
+	for _, name := range names {
+		fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+		secret, err := m.provider.Get(fetchCtx, name)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("refresh %q: %w", name, err))
+			continue
+		}
+		m.mutex.Lock()
+		if m.cache[name] != secret {
+			m.cache[name] = secret
+			rotated = append(rotated, name)
+		}
+		m.mutex.Unlock()
+	}
+	return rotated, errors.Join(errs...)
+}
+
+// Watch calls Refresh every interval and onRotate after any secret changed, until stop is closed
+func (m *Manager) Watch(interval time.Duration, stop <-chan struct{}, onRotate func(rotated []string), onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		rotated, err := m.Refresh(context.Background())
+		if err != nil && onError != nil {
+			onError(err)
+		}
+		if len(rotated) > 0 {
+			onRotate(rotated)
+		}
+	}
+}
+
+var (
+	defaultMutex   sync.RWMutex
+	defaultManager *Manager
+)
+
+// Default returns the process-wide manager created from the environment on first use
+func Default() *Manager {
+	defaultMutex.RLock()
+	manager := defaultManager
+	defaultMutex.RUnlock()
+	if manager != nil {
+		return manager
+	}
+
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	if defaultManager == nil {
+		provider, err := ProviderFromEnv()
+		if err != nil {
+			// references fail with this error instead of silently resolving to nothing
+			provider = failingProvider{err: err}
+		}
+		defaultManager = NewManager(provider)
+	}
+	return defaultManager
+}
+
+// SetDefault replaces the process-wide manager
+func SetDefault(manager *Manager) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	defaultManager = manager
+}
+
+// Resolve resolves value through the default manager
+func Resolve(ctx context.Context, value string) (string, error) {
+	if !IsRef(value) {
+		return value, nil
+	}
+	return Default().Resolve(ctx, value)
+}
+
+// ProviderFromEnv creates the backend selected by SECRETS_BACKEND (vault, aws, gcp, file).
+// An empty SECRETS_BACKEND returns a nil provider, so only references fail.
+func ProviderFromEnv() (Provider, error) {
+	switch backend := os.Getenv("SECRETS_BACKEND"); backend {
+	case "":
+		return nil, nil
+	case "vault":
+		return NewVaultProvider(os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN"), os.Getenv("SECRETS_VAULT_MOUNT"))
+	case "aws":
+		return NewAWSProvider(os.Getenv("SECRETS_AWS_REGION"))
+	case "gcp":
+		return NewGCPProvider(os.Getenv("SECRETS_GCP_PROJECT"))
+	case "file":
+		return NewFileProvider(os.Getenv("SECRETS_FILE"), os.Getenv("SECRETS_FILE_KEY"))
+	default:
+		return nil, fmt.Errorf("unknown secrets backend %q", backend)
+	}
+}
+
+type failingProvider struct {
+	err error
+}
+
+func (p failingProvider) Get(ctx context.Context, name string) (string, error) {
+	return "", p.err
+}
+
+// splitField splits "path#field" used by backends that store several values per secret
+func splitField(name string) (path, field string) {
+	path, field, _ = strings.Cut(name, "#")
+	return path, field
+}
@@ -0,0 +1,123 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestResolveLiteral(t *testing.T) {
+	var m *Manager
+	got, err := m.Resolve(context.Background(), "plain-value")
+	if err != nil || got != "plain-value" {
+		t.Fatalf("Resolve literal = %q, %v", got, err)
+	}
+	if _, err := m.Resolve(context.Background(), "secret:llm"); !errors.Is(err, ErrNoBackend) {
+		t.Fatalf("expected ErrNoBackend, got %v", err)
+	}
+}
+
+func TestFileProviderRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	if err := SealFile(path, testKey, map[string]string{"llm": "key-1", "trunk": "pw"}); err != nil {
+		t.Fatalf("SealFile: %v", err)
+	}
+	provider, err := NewFileProvider(path, testKey)
+	if err != nil {
+		t.Fatalf("NewFileProvider: %v", err)
+	}
+	m := NewManager(provider)
+
+	got, err := m.Resolve(context.Background(), "secret:llm")
+	if err != nil || got != "key-1" {
+		t.Fatalf("Resolve = %q, %v", got, err)
+	}
+	if _, err := m.Resolve(context.Background(), "secret:missing"); err == nil {
+		t.Fatal("expected error for missing secret")
+	}
+
+	if err := SealFile(path, testKey, map[string]string{"llm": "key-2", "trunk": "pw"}); err != nil {
+		t.Fatalf("SealFile: %v", err)
+	}
+	// cached until refreshed
+	if got, _ := m.Resolve(context.Background(), "secret:llm"); got != "key-1" {
+		t.Fatalf("expected cached value, got %q", got)
+	}
+	rotated, err := m.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if !slices.Equal(rotated, []string{"llm"}) {
+		t.Fatalf("rotated = %v", rotated)
+	}
+	if got, _ := m.Resolve(context.Background(), "secret:llm"); got != "key-2" {
+		t.Fatalf("expected rotated value, got %q", got)
+	}
+
+	if _, err := NewFileProvider(path, "short"); err == nil {
+		t.Fatal("expected error for invalid key")
+	}
+	wrongKey := strings.Repeat("ff", 32)
+	wrong, _ := NewFileProvider(path, wrongKey)
+	if _, err := wrong.Get(context.Background(), "llm"); err == nil {
+		t.Fatal("expected decrypt error with wrong key")
+	}
+}
+
+func TestVaultProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/lingsip/providers":
+			w.Write([]byte(`{"data":{"data":{"llm_api_key":"sk-1","tts_secret":"t-1"}}}`))
+		case "/v1/secret/data/lingsip/single":
+			w.Write([]byte(`{"data":{"data":{"value":"only"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewVaultProvider(server.URL, "token", "")
+	if err != nil {
+		t.Fatalf("NewVaultProvider: %v", err)
+	}
+	cases := map[string]string{
+		"lingsip/providers#llm_api_key": "sk-1",
+		"lingsip/single":                "only",
+	}
+	for name, want := range cases {
+		if got, err := provider.Get(context.Background(), name); err != nil || got != want {
+			t.Errorf("Get(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"lingsip/providers", "lingsip/providers#nope", "lingsip/unknown#x"} {
+		if _, err := provider.Get(context.Background(), name); err == nil {
+			t.Errorf("Get(%q) expected error", name)
+		}
+	}
+}
+
+func TestGCPResource(t *testing.T) {
+	p := &GCPProvider{project: "proj"}
+	cases := map[string]string{
+		"llm-key":                         "projects/proj/secrets/llm-key/versions/latest",
+		"llm-key/versions/3":              "projects/proj/secrets/llm-key/versions/3",
+		"projects/other/secrets/trunk-pw": "projects/other/secrets/trunk-pw/versions/latest",
+	}
+	for name, want := range cases {
+		if got, err := p.resource(name); err != nil || got != want {
+			t.Errorf("resource(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
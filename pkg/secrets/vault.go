@@ -0,0 +1,84 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// VaultProvider reads secrets from a HashiCorp Vault KV v2 engine. Names are "path#field",
+// the field can be omitted when the secret holds a single value.
+type VaultProvider struct {
+	addr   string
+	token  string
+	mount  string
+	client *http.Client
+}
+
+// NewVaultProvider creates a provider for the KV v2 engine mounted at mount (default "secret")
+func NewVaultProvider(addr, token, mount string) (*VaultProvider, error) {
+	if addr == "" || token == "" {
+		return nil, fmt.Errorf("VAULT_ADDR and VAULT_TOKEN are required for the vault backend")
+	}
+	if mount == "" {
+		mount = "secret"
+	}
+	return &VaultProvider{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		mount:  strings.Trim(mount, "/"),
+		client: &http.Client{},
+	}, nil
+}
+
+func (p *VaultProvider) Get(ctx context.Context, name string) (string, error) {
+	path, field := splitField(name)
+	endpoint := fmt.Sprintf("%s/v1/%s/data/%s", p.addr, p.mount, (&url.URL{Path: strings.Trim(path, "/")}).EscapedPath())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid vault response: %w", err)
+	}
+	return pickField(body.Data.Data, field)
+}
+
+// pickField returns field from a multi-value secret, or the only value when field is empty
+func pickField(values map[string]any, field string) (string, error) {
+	if field == "" {
+		if len(values) != 1 {
+			return "", fmt.Errorf("secret has %d fields, name one with #field", len(values))
+		}
+		for key := range values {
+			field = key
+		}
+	}
+	value, ok := values[field]
+	if !ok {
+		return "", fmt.Errorf("field %q not found", field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,294 @@
+package sip1
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const auditTargetAccessRule = "access_rule"
+
+// accessRule 编译后的访问控制规则
+type accessRule struct {
+	models.AccessRule
+	methods map[sip.RequestMethod]bool // 为空时适用于REGISTER和INVITE
+	network *net.IPNet
+	ip      net.IP
+	hits    *atomic.Int64
+}
+
+// compileAccessRule 校验并编译规则：ip规则支持单个IP、CIDR和通配符，number规则支持通配符
+func compileAccessRule(rule models.AccessRule) (*accessRule, error) {
+	compiled := &accessRule{AccessRule: rule}
+	pattern := strings.TrimSpace(rule.Pattern)
+	if pattern == "" {
+		return nil, errors.New("pattern is required")
+	}
+	compiled.Pattern = pattern
+
+	switch rule.Action {
+	case models.AccessRuleActionAllow, models.AccessRuleActionDeny:
+	default:
+		return nil, fmt.Errorf("invalid action: %q", rule.Action)
+	}
+
+	for _, method := range strings.Split(rule.Methods, ",") {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == "" {
+			continue
+		}
+		if method != string(sip.REGISTER) && method != string(sip.INVITE) {
+			return nil, fmt.Errorf("unsupported method: %q", method)
+		}
+		if compiled.methods == nil {
+			compiled.methods = make(map[sip.RequestMethod]bool)
+		}
+		compiled.methods[sip.RequestMethod(method)] = true
+	}
+
+	switch rule.Kind {
+	case models.AccessRuleKindIP:
+		if strings.Contains(pattern, "/") {
+			_, network, err := net.ParseCIDR(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", pattern, err)
+			}
+			compiled.network = network
+			return compiled, nil
+		}
+		if ip := net.ParseIP(pattern); ip != nil {
+			compiled.ip = ip
+			return compiled, nil
+		}
+		if !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("invalid IP pattern: %q", pattern)
+		}
+	case models.AccessRuleKindNumber:
+	default:
+		return nil, fmt.Errorf("invalid kind: %q", rule.Kind)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid wildcard %q: %w", pattern, err)
+	}
+	return compiled, nil
+}
+
+// matches 判断规则是否命中请求，号码同时比对原始From用户名和规范化后的号码
+func (r *accessRule) matches(method sip.RequestMethod, sourceIP, fromUser string) bool {
+	if r.methods != nil && !r.methods[method] {
+		return false
+	}
+	if r.Kind == models.AccessRuleKindIP {
+		ip := net.ParseIP(sourceIP)
+		switch {
+		case r.network != nil:
+			return ip != nil && r.network.Contains(ip)
+		case r.ip != nil:
+			return ip != nil && r.ip.Equal(ip)
+		}
+		ok, _ := path.Match(r.Pattern, sourceIP)
+		return ok
+	}
+	if fromUser == "" {
+		return false
+	}
+	if ok, _ := path.Match(r.Pattern, fromUser); ok {
+		return true
+	}
+	number := normalizeDialNumber(fromUser)
+	ok, _ := path.Match(r.Pattern, number)
+	return number != "" && ok
+}
+
+// AccessControl REGISTER/INVITE的来源IP和From号码访问控制，按优先级匹配，未命中任何规则时放行
+type AccessControl struct {
+	rules []*accessRule
+	hits  map[uint]*atomic.Int64 // 规则ID -> 命中次数，重新加载规则时保留
+	mutex sync.RWMutex
+}
+
+// NewAccessControl 创建没有规则的访问控制
+func NewAccessControl() *AccessControl {
+	return &AccessControl{hits: make(map[uint]*atomic.Int64)}
+}
+
+// SetRules 替换全部规则，规则应已按优先级排序；无法编译的规则跳过并返回第一个错误
+func (a *AccessControl) SetRules(rules []models.AccessRule) error {
+	var firstErr error
+	compiled := make([]*accessRule, 0, len(rules))
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	hits := make(map[uint]*atomic.Int64, len(rules))
+	for _, rule := range rules {
+		c, err := compileAccessRule(rule)
+		if err != nil {
+			logger.Warn("Skipping invalid access rule", zap.Uint("rule_id", rule.ID), zap.String("pattern", rule.Pattern), zap.Error(err))
+			if firstErr == nil {
+				firstErr = fmt.Errorf("access rule %d: %w", rule.ID, err)
+			}
+			continue
+		}
+		c.hits = a.hits[rule.ID]
+		if c.hits == nil {
+			c.hits = &atomic.Int64{}
+		}
+		hits[rule.ID] = c.hits
+		compiled = append(compiled, c)
+	}
+	a.rules = compiled
+	a.hits = hits
+	return firstErr
+}
+
+// Load 从数据库加载启用的规则
+func (a *AccessControl) Load(db *gorm.DB) error {
+	rules, err := models.GetEnabledAccessRules(db)
+	if err != nil {
+		return fmt.Errorf("failed to load access rules: %w", err)
+	}
+	return a.SetRules(rules)
+}
+
+// match 返回第一条命中的规则，没有命中时返回nil
+func (a *AccessControl) match(method sip.RequestMethod, sourceIP, fromUser string) *accessRule {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	for _, rule := range a.rules {
+		if rule.matches(method, sourceIP, fromUser) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Check 返回命中的规则，未命中时ok为false
+func (a *AccessControl) Check(method sip.RequestMethod, sourceIP, fromUser string) (models.AccessRule, bool) {
+	if rule := a.match(method, sourceIP, fromUser); rule != nil {
+		return rule.AccessRule, true
+	}
+	return models.AccessRule{}, false
+}
+
+// Hits 返回已加载规则的命中次数
+func (a *AccessControl) Hits() map[uint]int64 {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	hits := make(map[uint]int64, len(a.hits))
+	for id, counter := range a.hits {
+		hits[id] = counter.Load()
+	}
+	return hits
+}
+
+// enforceAccessRules 按访问控制规则拒绝REGISTER/INVITE，开启Log的规则命中时记录日志
+func (as *SipServer) enforceAccessRules(next sipgo.RequestHandler) sipgo.RequestHandler {
+	return func(req *sip.Request, tx sip.ServerTransaction) {
+		var fromUser string
+		if from := req.From(); from != nil {
+			fromUser = from.Address.User
+		}
+		sourceIP := requestSourceIP(req)
+		rule := as.accessControl.match(req.Method, sourceIP, fromUser)
+		if rule == nil {
+			next(req, tx)
+			return
+		}
+		rule.hits.Add(1)
+
+		deny := rule.Action == models.AccessRuleActionDeny
+		log := logger.Debug
+		if rule.Log && deny {
+			log = logger.Warn
+		} else if rule.Log {
+			log = logger.Info
+		}
+		log("SIP request matched access rule",
+			zap.Uint("rule_id", rule.ID),
+			zap.String("pattern", rule.Pattern),
+			zap.String("action", string(rule.Action)),
+			zap.String("method", req.Method.String()),
+			zap.String("source", sourceIP),
+			zap.String("from", fromUser),
+			zap.String("call_id", req.CallID().Value()))
+
+		if deny {
+			tx.Respond(sip.NewResponseFromRequest(req, sip.StatusForbidden, "Forbidden", nil))
+			return
+		}
+		next(req, tx)
+	}
+}
+
+// CreateAccessRule 校验并保存规则后重新加载
+func (as *SipServer) CreateAccessRule(rule *models.AccessRule, actor string) error {
+	db := as.config.Db
+	if db == nil {
+		return errors.New("database not configured")
+	}
+	rule.Pattern = strings.TrimSpace(rule.Pattern)
+	if _, err := compileAccessRule(*rule); err != nil {
+		return err
+	}
+	if err := models.CreateAccessRule(db, rule); err != nil {
+		return err
+	}
+	as.auditAccessRule(actor, "access_rule.created", rule.ID, rule)
+	return as.reloadAccessRules()
+}
+
+// UpdateAccessRule 校验并保存规则的全部字段后重新加载
+func (as *SipServer) UpdateAccessRule(rule *models.AccessRule, actor string) error {
+	db := as.config.Db
+	if db == nil {
+		return errors.New("database not configured")
+	}
+	rule.Pattern = strings.TrimSpace(rule.Pattern)
+	if _, err := compileAccessRule(*rule); err != nil {
+		return err
+	}
+	if err := models.UpdateAccessRule(db, rule); err != nil {
+		return err
+	}
+	as.auditAccessRule(actor, "access_rule.updated", rule.ID, rule)
+	return as.reloadAccessRules()
+}
+
+// DeleteAccessRule 删除规则后重新加载
+func (as *SipServer) DeleteAccessRule(id uint, actor string) error {
+	db := as.config.Db
+	if db == nil {
+		return errors.New("database not configured")
+	}
+	removed, err := models.DeleteAccessRule(db, id)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("access rule %d not found", id)
+	}
+	as.auditAccessRule(actor, "access_rule.deleted", id, nil)
+	return as.reloadAccessRules()
+}
+
+func (as *SipServer) reloadAccessRules() error {
+	return as.accessControl.Load(as.config.Db)
+}
+
+// auditAccessRule 写入规则变更的审计日志，失败只记录日志
+func (as *SipServer) auditAccessRule(actor, action string, id uint, detail interface{}) {
+	if err := models.CreateAuditLog(as.config.Db, actor, action, auditTargetAccessRule, id, detail); err != nil {
+		logger.Error("Failed to write audit log", zap.String("action", action), zap.Error(err))
+	}
+}
@@ -0,0 +1,162 @@
+package sip1
+
+import (
+	"testing"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/emiago/sipgo/sip"
+)
+
+func TestAccessRuleMatches(t *testing.T) {
+	ip := func(pattern string) models.AccessRule {
+		return models.AccessRule{Kind: models.AccessRuleKindIP, Pattern: pattern, Action: models.AccessRuleActionDeny}
+	}
+	number := func(pattern string) models.AccessRule {
+		return models.AccessRule{Kind: models.AccessRuleKindNumber, Pattern: pattern, Action: models.AccessRuleActionDeny}
+	}
+	tests := []struct {
+		name   string
+		rule   models.AccessRule
+		method sip.RequestMethod
+		ip     string
+		from   string
+		want   bool
+	}{
+		{"cidr inside", ip("10.0.0.0/8"), sip.INVITE, "10.1.2.3", "", true},
+		{"cidr outside", ip("10.0.0.0/8"), sip.INVITE, "11.0.0.1", "", false},
+		{"cidr invalid source", ip("10.0.0.0/8"), sip.INVITE, "not-an-ip", "", false},
+		{"ipv6 cidr", ip("2001:db8::/32"), sip.INVITE, "2001:db8::1", "", true},
+		{"exact ip", ip("192.168.1.10"), sip.REGISTER, "192.168.1.10", "", true},
+		{"exact ip differs", ip("192.168.1.10"), sip.REGISTER, "192.168.1.100", "", false},
+		{"exact ip normalized", ip("::ffff:192.168.1.10"), sip.REGISTER, "192.168.1.10", "", true},
+		{"ip wildcard", ip("192.168.*"), sip.INVITE, "192.168.7.7", "", true},
+		{"ip wildcard miss", ip("192.168.*"), sip.INVITE, "192.169.7.7", "", false},
+		{"ip single char wildcard", ip("10.0.0.?"), sip.INVITE, "10.0.0.7", "", true},
+		{"number prefix", number("1380*"), sip.INVITE, "1.2.3.4", "13800000000", true},
+		{"number prefix miss", number("1380*"), sip.INVITE, "1.2.3.4", "13900000000", false},
+		{"number normalized", number("1380*"), sip.INVITE, "1.2.3.4", "138-0000-0000", true},
+		{"number raw user", number("anonymous"), sip.INVITE, "1.2.3.4", "anonymous", true},
+		{"number empty from", number("*"), sip.INVITE, "1.2.3.4", "", false},
+		{"number exact", number("+8613800000000"), sip.INVITE, "1.2.3.4", "+8613800000000", true},
+		{"method filtered", models.AccessRule{Kind: models.AccessRuleKindIP, Pattern: "10.0.0.0/8", Action: models.AccessRuleActionDeny, Methods: "REGISTER"}, sip.INVITE, "10.0.0.1", "", false},
+		{"method listed", models.AccessRule{Kind: models.AccessRuleKindIP, Pattern: "10.0.0.0/8", Action: models.AccessRuleActionDeny, Methods: "register, invite"}, sip.INVITE, "10.0.0.1", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := compileAccessRule(tt.rule)
+			if err != nil {
+				t.Fatalf("compileAccessRule() error = %v", err)
+			}
+			if got := rule.matches(tt.method, tt.ip, tt.from); got != tt.want {
+				t.Errorf("matches(%s, %q, %q) = %v, want %v", tt.method, tt.ip, tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileAccessRuleRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule models.AccessRule
+	}{
+		{"empty pattern", models.AccessRule{Kind: models.AccessRuleKindIP, Pattern: " ", Action: models.AccessRuleActionDeny}},
+		{"bad cidr", models.AccessRule{Kind: models.AccessRuleKindIP, Pattern: "10.0.0.0/33", Action: models.AccessRuleActionDeny}},
+		{"bad ip", models.AccessRule{Kind: models.AccessRuleKindIP, Pattern: "example.com", Action: models.AccessRuleActionDeny}},
+		{"bad wildcard", models.AccessRule{Kind: models.AccessRuleKindNumber, Pattern: "138[", Action: models.AccessRuleActionDeny}},
+		{"bad action", models.AccessRule{Kind: models.AccessRuleKindIP, Pattern: "10.0.0.1", Action: "drop"}},
+		{"bad kind", models.AccessRule{Kind: "domain", Pattern: "10.0.0.1", Action: models.AccessRuleActionDeny}},
+		{"bad method", models.AccessRule{Kind: models.AccessRuleKindIP, Pattern: "10.0.0.1", Action: models.AccessRuleActionDeny, Methods: "OPTIONS"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileAccessRule(tt.rule); err == nil {
+				t.Errorf("compileAccessRule(%+v) expected error", tt.rule)
+			}
+		})
+	}
+}
+
+func TestAccessControlPriority(t *testing.T) {
+	db := newTestDB(t, &models.AccessRule{})
+	// 按创建顺序与优先级相反插入，匹配顺序只取决于priority和id
+	rules := []models.AccessRule{
+		{Kind: models.AccessRuleKindIP, Pattern: "10.0.0.0/8", Action: models.AccessRuleActionDeny, Priority: 30, Enabled: true},
+		{Kind: models.AccessRuleKindIP, Pattern: "10.1.0.0/16", Action: models.AccessRuleActionAllow, Priority: 20, Enabled: true},
+		{Kind: models.AccessRuleKindIP, Pattern: "10.1.1.1", Action: models.AccessRuleActionDeny, Priority: 10, Enabled: true},
+		{Kind: models.AccessRuleKindNumber, Pattern: "1001", Action: models.AccessRuleActionDeny, Priority: 20, Enabled: true},
+		{Kind: models.AccessRuleKindIP, Pattern: "*", Action: models.AccessRuleActionDeny, Priority: 1, Enabled: false},
+	}
+	for i := range rules {
+		if err := models.CreateAccessRule(db, &rules[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// gorm不会写入零值的Enabled，显式关闭最后一条
+	if err := db.Model(&models.AccessRule{}).Where("id = ?", rules[4].ID).Update("enabled", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	ac := NewAccessControl()
+	if err := ac.Load(db); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		ip     string
+		from   string
+		want   uint // 命中的规则ID，0表示未命中
+		action models.AccessRuleAction
+	}{
+		{"highest priority wins", "10.1.1.1", "", rules[2].ID, models.AccessRuleActionDeny},
+		{"allow stops later deny", "10.1.2.3", "", rules[1].ID, models.AccessRuleActionAllow},
+		{"equal priority by id", "10.1.2.3", "1001", rules[1].ID, models.AccessRuleActionAllow},
+		{"falls through to broad rule", "10.2.0.1", "", rules[0].ID, models.AccessRuleActionDeny},
+		{"number rule", "192.168.0.1", "1001", rules[3].ID, models.AccessRuleActionDeny},
+		{"disabled rule ignored", "192.168.0.1", "1002", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, ok := ac.Check(sip.INVITE, tt.ip, tt.from)
+			if ok != (tt.want != 0) || rule.ID != tt.want || rule.Action != tt.action {
+				t.Errorf("Check(%q, %q) = rule %d %s (ok %v), want rule %d %s", tt.ip, tt.from, rule.ID, rule.Action, ok, tt.want, tt.action)
+			}
+		})
+	}
+}
+
+func TestEnforceAccessRules(t *testing.T) {
+	server := &SipServer{accessControl: NewAccessControl()}
+	err := server.accessControl.SetRules([]models.AccessRule{
+		{ID: 1, Kind: models.AccessRuleKindIP, Pattern: "10.0.0.1", Action: models.AccessRuleActionAllow, Priority: 1},
+		{ID: 2, Kind: models.AccessRuleKindIP, Pattern: "10.0.0.0/8", Action: models.AccessRuleActionDeny, Priority: 2, Log: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		source string
+		passed bool
+	}{
+		{"allowed by rule", "10.0.0.1:5060", true},
+		{"denied by rule", "10.0.0.2:5060", false},
+		{"default allows", "192.168.0.1:5060", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passed := false
+			handler := server.enforceAccessRules(func(req *sip.Request, tx sip.ServerTransaction) { passed = true })
+			tx := newRecordingTx()
+			handler(newTestRequest(t, sip.INVITE, tt.source, ""), tx)
+			if passed != tt.passed {
+				t.Fatalf("passed = %v, want %v", passed, tt.passed)
+			}
+			if !tt.passed && (len(tx.responses) != 1 || tx.responses[0].StatusCode != sip.StatusForbidden) {
+				t.Errorf("responses = %v, want 403", tx.responses)
+			}
+		})
+	}
+	if hits := server.accessControl.Hits(); hits[1] != 1 || hits[2] != 1 {
+		t.Errorf("Hits() = %v, want one hit per rule", hits)
+	}
+}
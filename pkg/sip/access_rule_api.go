@@ -0,0 +1,143 @@
+package sip1
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/auth"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/emiago/sipgo/sip"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// accessRuleForm 创建和修改访问控制规则的请求体
+type accessRuleForm struct {
+	Kind        models.AccessRuleKind   `json:"kind" binding:"required"`
+	Pattern     string                  `json:"pattern" binding:"required"`
+	Action      models.AccessRuleAction `json:"action" binding:"required"`
+	Methods     string                  `json:"methods"`
+	Priority    int                     `json:"priority"`
+	Log         bool                    `json:"log"`
+	Description string                  `json:"description"`
+	Enabled     *bool                   `json:"enabled"`
+}
+
+func (f *accessRuleForm) apply(rule *models.AccessRule) {
+	rule.Kind = f.Kind
+	rule.Pattern = f.Pattern
+	rule.Action = f.Action
+	rule.Methods = f.Methods
+	rule.Priority = f.Priority
+	rule.Log = f.Log
+	rule.Description = f.Description
+	rule.Enabled = f.Enabled == nil || *f.Enabled
+}
+
+// accessRuleID 解析路径中的规则ID，失败时已写入响应
+func accessRuleID(c *gin.Context, server *SipServer) (uint, bool) {
+	if server.config.Db == nil {
+		response.Fail(c, "database not configured", nil)
+		return 0, false
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		response.Fail(c, "invalid access rule id", err.Error())
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// RegisterAccessRuleAPIs 注册访问控制规则接口：GET /access-rules 按匹配顺序列出规则及本实例的命中次数，
+// POST /access-rules {"kind":"ip","pattern":"10.0.0.0/8","action":"deny","methods":"REGISTER,INVITE","priority":10,"log":true}
+// 创建规则（kind为number时pattern匹配From号码，支持*、?通配符），PUT /access-rules/:id 修改规则，
+// DELETE /access-rules/:id 删除规则，POST /access-rules/check {"method":"INVITE","ip":"1.2.3.4","from":"1001"} 查看请求会命中的规则；
+// 规则按priority从小到大匹配，第一条命中的规则生效，未命中时放行，变更写入审计日志并立即生效
+func RegisterAccessRuleAPIs(r gin.IRoutes, server *SipServer) {
+	r.GET("/access-rules", func(c *gin.Context) {
+		if server.config.Db == nil {
+			response.Fail(c, "database not configured", nil)
+			return
+		}
+		rules, err := models.ListAccessRules(server.config.Db)
+		if err != nil {
+			response.Fail(c, "failed to list access rules", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"items": rules, "hits": server.accessControl.Hits()})
+	})
+
+	r.POST("/access-rules", requireActor, func(c *gin.Context) {
+		var form accessRuleForm
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		rule := &models.AccessRule{}
+		form.apply(rule)
+		if err := server.CreateAccessRule(rule, auth.CurrentActor(c)); err != nil {
+			response.Fail(c, "failed to create access rule", err.Error())
+			return
+		}
+		response.Success(c, "ok", rule)
+	})
+
+	r.PUT("/access-rules/:id", requireActor, func(c *gin.Context) {
+		id, ok := accessRuleID(c, server)
+		if !ok {
+			return
+		}
+		var form accessRuleForm
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		rule, err := models.GetAccessRule(server.config.Db, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			response.Fail(c, "access rule not found", nil)
+			return
+		}
+		if err != nil {
+			response.Fail(c, "failed to load access rule", err.Error())
+			return
+		}
+		form.apply(rule)
+		if err := server.UpdateAccessRule(rule, auth.CurrentActor(c)); err != nil {
+			response.Fail(c, "failed to update access rule", err.Error())
+			return
+		}
+		response.Success(c, "ok", rule)
+	})
+
+	r.DELETE("/access-rules/:id", requireActor, func(c *gin.Context) {
+		id, ok := accessRuleID(c, server)
+		if !ok {
+			return
+		}
+		if err := server.DeleteAccessRule(id, auth.CurrentActor(c)); err != nil {
+			response.Fail(c, "failed to delete access rule", err.Error())
+			return
+		}
+		response.Success(c, "ok", nil)
+	})
+
+	r.POST("/access-rules/check", func(c *gin.Context) {
+		var form struct {
+			Method string `json:"method" binding:"required"`
+			IP     string `json:"ip"`
+			From   string `json:"from"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		rule, matched := server.accessControl.Check(sip.RequestMethod(strings.ToUpper(form.Method)), form.IP, form.From)
+		if !matched {
+			response.Success(c, "ok", gin.H{"matched": false, "allowed": true})
+			return
+		}
+		response.Success(c, "ok", gin.H{"matched": true, "allowed": rule.Action == models.AccessRuleActionAllow, "rule": rule})
+	})
+}
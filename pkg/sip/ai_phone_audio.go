@@ -2,15 +2,17 @@ package sip1
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"net"
+	"strings"
 	"time"
 
-	"github.com/LingByte/LingSIP/pkg/config"
+	"github.com/LingByte/LingSIP/pkg/audio"
+	"github.com/LingByte/LingSIP/pkg/features"
 	"github.com/LingByte/LingSIP/pkg/logger"
-	"github.com/LingByte/LingSIP/pkg/recognizer"
 	"github.com/LingByte/LingSIP/pkg/synthesizer"
-	"github.com/LingByte/LingSIP/pkg/utils"
 	"github.com/pion/rtp"
 	"go.uber.org/zap"
 )
@@ -20,86 +22,223 @@ func (engine *AIPhoneEngine) playTTSAudio(session *ScriptSession, text, speakerI
 	if text == "" {
 		return nil
 	}
+	if session.text != nil {
+		session.text.say(text)
+		return nil
+	}
 
 	logger.Info("Playing TTS audio",
 		zap.String("call_id", session.CallID),
 		zap.String("text", text),
 		zap.String("speaker_id", speakerID))
 
+	if session.Cost != nil {
+		session.Cost.AddTTSText(text)
+	}
+
+	// 流式模式边合成边播放
+	if streamingTTS() {
+		return engine.streamTTSAudio(session, text, speakerID, nil)
+	}
+
 	// 调用TTS服务生成音频
-	audioData, err := engine.callTTSService(text, speakerID)
+	audioData, err := engine.callTTSService(text, speakerID, session.Codec.SampleRate)
 	if err != nil {
 		return fmt.Errorf("TTS service failed: %w", err)
 	}
 
 	// 播放音频
-	return engine.playAudioBlocking(session.ClientAddr, audioData)
+	return engine.playAudioBlocking(session, audioData)
 }
 
-// playAudioBlocking 阻塞式音频播放
-func (engine *AIPhoneEngine) playAudioBlocking(clientAddr string, audioData []int16) error {
-	if len(audioData) == 0 {
-		return nil
+// playTTSAudioWithBargeIn 播放TTS音频，同时检测用户插话；用户开口时立即停止播放并返回已捕获的语音
+func (engine *AIPhoneEngine) playTTSAudioWithBargeIn(session *ScriptSession, text, speakerID string) ([]int16, error) {
+	if text == "" {
+		return nil, nil
+	}
+	// 关闭插话功能时完整播放，文字会话直接发送
+	if !features.Enabled(features.BargeIn) || session.text != nil {
+		return nil, engine.playTTSAudio(session, text, speakerID)
 	}
 
-	addr, err := net.ResolveUDPAddr("udp", clientAddr)
-	if err != nil {
-		return fmt.Errorf("failed to resolve client address: %w", err)
+	logger.Info("Playing TTS audio with barge-in",
+		zap.String("call_id", session.CallID),
+		zap.String("text", text),
+		zap.String("speaker_id", speakerID))
+
+	if session.Cost != nil {
+		session.Cost.AddTTSText(text)
 	}
 
-	// 每20ms发送一个RTP包（160个样本，8000Hz采样率）
-	samplesPerPacket := 160
-	sequenceNumber := uint16(1)
-	timestamp := uint32(0)
-	ssrc := uint32(12345) // 固定SSRC
+	clientAddr, err := net.ResolveUDPAddr("udp", session.ClientAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve client address: %w", err)
+	}
 
-	for i := 0; i < len(audioData); i += samplesPerPacket {
-		end := i + samplesPerPacket
-		if end > len(audioData) {
-			end = len(audioData)
+	var audioData []int16
+	streaming := streamingTTS()
+	if !streaming {
+		audioData, err = engine.callTTSService(text, speakerID, session.Codec.SampleRate)
+		if err != nil {
+			return nil, fmt.Errorf("TTS service failed: %w", err)
 		}
+	}
+
+	interrupt := make(chan struct{})
+	done := make(chan struct{})
+	captured := make(chan []int16, 1)
+	go func() {
+		captured <- engine.detectBargeIn(session, clientAddr, interrupt, done, false)
+	}()
+
+	var playErr error
+	if streaming {
+		playErr = engine.streamTTSAudio(session, text, speakerID, interrupt)
+	} else {
+		playErr = engine.playAudio(session, audioData, interrupt)
+	}
+	close(done)
+	speech := <-captured
+
+	if len(speech) > 0 {
+		logger.Info("Playback interrupted by caller speech",
+			zap.String("call_id", session.CallID),
+			zap.Int("captured_samples", len(speech)))
+	}
+	return speech, playErr
+}
+
+// detectBargeIn 播放期间监听入向RTP，检测到持续语音（digits为true时还有RFC 2833按键）时关闭interrupt，
+// 并持续收集语音直到done关闭
+func (engine *AIPhoneEngine) detectBargeIn(session *ScriptSession, clientAddr *net.UDPAddr, interrupt, done chan struct{}, digits bool) []int16 {
+	pipeline, inbound, err := engine.newReceivePipeline(session, clientAddr)
+	if err != nil {
+		logger.Error("Failed to create RTP decoder for barge-in",
+			zap.String("call_id", session.CallID),
+			zap.Error(err))
+		<-done
+		return nil
+	}
+
+	triggerPackets := 5 // 连续5个语音包（100ms）才认为用户插话，避免回声和噪音误触发
+	prerollPackets := 10
 
-		// 转换PCM为μ-law
-		mulawData := make([]byte, end-i)
-		for j, sample := range audioData[i:end] {
-			mulawData[j] = linearToMulaw(sample)
+	var preroll [][]int16
+	var speech []int16
+	consecutiveSpeech := 0
+	triggered := false
+
+	defer engine.sessionConn(session).SetReadDeadline(time.Time{})
+
+	if digits {
+		// 与pipeline.Next在同一goroutine中回调，triggered无需加锁
+		detector := newTelephoneEventDetector(session.Codec.TelephoneEvent)
+		onEvent := inbound.onEvent
+		inbound.onEvent = func(packet *rtp.Packet) {
+			if onEvent != nil {
+				onEvent(packet)
+			}
+			if len(detector.Push(packet)) > 0 && !triggered {
+				triggered = true
+				close(interrupt)
+				logger.Info("Barge-in detected by DTMF", zap.String("call_id", session.CallID))
+			}
 		}
+	}
 
-		// 创建RTP包
-		packet := &rtp.Packet{
-			Header: rtp.Header{
-				Version:        2,
-				Padding:        false,
-				Extension:      false,
-				Marker:         false,
-				PayloadType:    0, // PCMU
-				SequenceNumber: sequenceNumber,
-				Timestamp:      timestamp,
-				SSRC:           ssrc,
-			},
-			Payload: mulawData,
+	for {
+		select {
+		case <-done:
+			return speech
+		default:
 		}
 
-		// 序列化RTP包
-		data, err := packet.Marshal()
-		if err != nil {
-			logger.Error("Failed to marshal RTP packet", zap.Error(err))
+		frame, err := pipeline.Next()
+		if err != nil || frame == nil {
 			continue
 		}
+		samples := frame.Samples
 
-		// 发送RTP包
-		_, err = engine.server.rtpConn.WriteToUDP(data, addr)
-		if err != nil {
-			logger.Error("Failed to send RTP packet", zap.Error(err))
+		if triggered {
+			speech = append(speech, samples...)
 			continue
 		}
 
-		// 更新序列号和时间戳
-		sequenceNumber++
-		timestamp += uint32(len(mulawData))
+		// 保留最近的音频作为前导，避免丢失句首
+		preroll = append(preroll, samples)
+		if len(preroll) > prerollPackets {
+			preroll = preroll[1:]
+		}
+
+		if frame.Speech {
+			consecutiveSpeech++
+		} else {
+			consecutiveSpeech = 0
+		}
+
+		if consecutiveSpeech >= triggerPackets {
+			triggered = true
+			for _, p := range preroll {
+				speech = append(speech, p...)
+			}
+			close(interrupt)
+			logger.Info("Barge-in detected", zap.String("call_id", session.CallID))
+		}
+	}
+}
+
+// playAudioFile 播放WAV提示音，转换为单声道、重采样到通话编码的采样率并按目标响度归一
+func (engine *AIPhoneEngine) playAudioFile(session *ScriptSession, filename string) error {
+	if session.text != nil {
+		logger.Debug("Audio file skipped in text session", zap.String("call_id", session.CallID), zap.String("file", filename))
+		return nil
+	}
+	format, samples, err := audio.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	samples, err = audio.Convert(format, samples, session.Codec.SampleRate)
+	if err != nil {
+		return fmt.Errorf("failed to convert %s: %w", filename, err)
+	}
+	normalizeLoudness(samples, session.Codec.SampleRate)
+	return engine.playAudioBlocking(session, samples)
+}
+
+// playAudioBlocking 阻塞式音频播放
+func (engine *AIPhoneEngine) playAudioBlocking(session *ScriptSession, audioData []int16) error {
+	return engine.playAudio(session, audioData, nil)
+}
+
+// playAudio 播放音频，stop关闭时立即停止发送
+func (engine *AIPhoneEngine) playAudio(session *ScriptSession, audioData []int16, stop <-chan struct{}) error {
+	if len(audioData) == 0 {
+		return nil
+	}
+	clientAddr := session.ClientAddr
+
+	sender, err := engine.newRTPSender(session)
+	if err != nil {
+		return err
+	}
+
+	// 每20ms发送一个RTP包，保持期间暂停，恢复后从断点继续
+	for i := 0; i < len(audioData); i += sender.frameSamples {
+		session.waitResumed(stop)
+		select {
+		case <-stop:
+			logger.Debug("Audio playback stopped",
+				zap.String("client_addr", clientAddr),
+				zap.Int("played_samples", i))
+			return nil
+		default:
+		}
 
-		// 等待20ms（模拟实时播放）
-		time.Sleep(20 * time.Millisecond)
+		end := i + sender.frameSamples
+		if end > len(audioData) {
+			end = len(audioData)
+		}
+		sender.send(audioData[i:end])
 	}
 
 	logger.Debug("Audio playback completed",
@@ -109,15 +248,268 @@ func (engine *AIPhoneEngine) playAudioBlocking(clientAddr string, audioData []in
 	return nil
 }
 
+// rtpSender 按20ms节奏向客户端发送RTP包，按协商编码转码，保持序列号和时间戳连续
+type rtpSender struct {
+	engine         *AIPhoneEngine
+	session        *ScriptSession
+	callID         string
+	addr           *net.UDPAddr
+	codec          rtpCodec
+	encode         rtpEncoder
+	echo           *echoSuppressor
+	clock          Clock
+	frameSamples   int // 每20ms帧的PCM样本数
+	sequenceNumber uint16
+	timestamp      uint32
+	ssrc           uint32
+}
+
+// callSSRC 通话的本端SSRC：已开始RTCP报告时沿用报告中的SSRC，否则随机生成，
+// 固定SSRC会让同一对端的多路通话无法按SSRC区分
+func (engine *AIPhoneEngine) callSSRC(callID string) uint32 {
+	if engine.server != nil {
+		if rs := engine.server.rtcpSessionFor(callID); rs != nil {
+			return rs.localSSRC()
+		}
+	}
+	return rand.Uint32()
+}
+
+// newRTPSender 创建RTP发送器，输入PCM采样率与协商编码一致（宽带编码为16kHz）
+func (engine *AIPhoneEngine) newRTPSender(session *ScriptSession) (*rtpSender, error) {
+	addr, err := net.ResolveUDPAddr("udp", session.ClientAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve client address: %w", err)
+	}
+	encode, err := newRTPEncoder(session.Codec, session.Codec.SampleRate)
+	if err != nil {
+		return nil, err
+	}
+	return &rtpSender{
+		engine:         engine,
+		session:        session,
+		callID:         session.CallID,
+		addr:           addr,
+		codec:          session.Codec,
+		encode:         encode,
+		echo:           session.Echo,
+		clock:          engine.getClock(),
+		frameSamples:   session.Codec.FrameSamples(),
+		sequenceNumber: 1,
+		ssrc:           session.SSRC,
+	}, nil
+}
+
+// send 编码并发送一帧音频，然后等待20ms（模拟实时播放）
+func (s *rtpSender) send(samples []int16) {
+	// 帧编码器要求完整的20ms帧，不足部分补静音
+	if s.codec.Name != codecPCMU.Name && len(samples) < s.frameSamples {
+		samples = append(append(make([]int16, 0, s.frameSamples), samples...), make([]int16, s.frameSamples-len(samples))...)
+	}
+
+	payload, err := s.encode(samples)
+	if err != nil {
+		logger.Error("Failed to encode RTP payload", zap.String("codec", s.codec.Name), zap.Error(err))
+		return
+	}
+	if len(payload) == 0 {
+		return
+	}
+
+	if !s.writePacket(s.codec.PayloadType, payload, len(samples)) {
+		return
+	}
+	s.echo.AddReference(samples)
+
+	s.clock.Sleep(20 * time.Millisecond)
+}
+
+// writePacket 发送一个RTP包，samples为该包覆盖的PCM样本数，用于推进时间戳
+func (s *rtpSender) writePacket(payloadType uint8, payload []byte, samples int) bool {
+	// 被保持时对端不接收媒体，舒适噪音等后台发送直接丢弃
+	if s.session != nil && s.session.isHeld() {
+		return false
+	}
+
+	// 创建RTP包
+	packet := &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Padding:        false,
+			Extension:      false,
+			Marker:         false,
+			PayloadType:    payloadType,
+			SequenceNumber: s.sequenceNumber,
+			Timestamp:      s.timestamp,
+			SSRC:           s.ssrc,
+		},
+		Payload: payload,
+	}
+
+	// 序列化RTP包
+	data, err := packet.Marshal()
+	if err != nil {
+		logger.Error("Failed to marshal RTP packet", zap.Error(err))
+		return false
+	}
+
+	// 对端已锁定到NAT后的地址时改发到该地址
+	if s.session != nil {
+		if peer := s.session.latchedPeer.Load(); peer != nil {
+			s.addr = peer
+		}
+	}
+
+	// 发送RTP包
+	if _, err := s.engine.sessionConn(s.session).WriteToUDP(data, s.addr); err != nil {
+		logger.Error("Failed to send RTP packet", zap.Error(err))
+		return false
+	}
+	if s.engine.server != nil {
+		if rs := s.engine.server.rtcpSessionFor(s.callID); rs != nil {
+			rs.onSent(s.ssrc, s.timestamp, len(payload))
+		}
+	}
+
+	// 更新序列号和时间戳，时间戳按编码时钟频率递增
+	s.sequenceNumber++
+	s.timestamp += uint32(samples * s.codec.ClockRate / s.codec.SampleRate)
+	return true
+}
+
+// streamTTSAudio 流式合成并播放，收到首段音频即开始发送RTP，stop关闭时中止合成和播放
+func (engine *AIPhoneEngine) streamTTSAudio(session *ScriptSession, text, speakerID string, stop <-chan struct{}) error {
+	sender, err := engine.newRTPSender(session)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	stream := synthesizer.NewSynthesisStream(64)
+	synthErr := make(chan error, 1)
+	go func() {
+		defer stream.Close()
+		synthErr <- engine.services().Synthesizer.Synthesize(ctx, stream, text, session.Codec.SampleRate)
+	}()
+
+	agc := newTTSAGC()
+	normalizer := newTTSNormalizer(session.Codec.SampleRate)
+	startTime := time.Now()
+	firstAudio := true
+	var pending []int16
+	var leftover []byte
+	played := 0
+
+	// stopPlayback 取消合成并排空通道，让合成协程退出
+	stopPlayback := func() error {
+		cancel()
+		for range stream.Chunks() {
+		}
+		logger.Debug("Streaming playback stopped",
+			zap.String("call_id", session.CallID),
+			zap.Int("played_samples", played))
+		return nil
+	}
+
+	for {
+		select {
+		case <-stop:
+			return stopPlayback()
+		case chunk, ok := <-stream.Chunks():
+			if !ok {
+				// 合成结束，发送归一缓冲中和不足一帧的样本
+				if normalizer != nil {
+					pending = append(pending, normalizer.Flush()...)
+				}
+				for len(pending) > 0 {
+					session.waitResumed(stop)
+					select {
+					case <-stop:
+						return stopPlayback()
+					default:
+					}
+					frame := pending[:min(len(pending), sender.frameSamples)]
+					if agc != nil {
+						agc.Process(frame)
+					}
+					sender.send(frame)
+					played += len(frame)
+					pending = pending[len(frame):]
+				}
+				if err := <-synthErr; err != nil {
+					return fmt.Errorf("TTS synthesis failed: %w", err)
+				}
+				if normalizer != nil {
+					logger.Debug("Streaming TTS loudness normalized", zap.Float64("gain", normalizer.Gain()))
+				}
+				logger.Info("Streaming TTS playback completed",
+					zap.String("call_id", session.CallID),
+					zap.String("text", text),
+					zap.Int("samples", played))
+				return nil
+			}
+
+			if firstAudio {
+				firstAudio = false
+				logger.Debug("Streaming TTS first audio",
+					zap.String("call_id", session.CallID),
+					zap.Duration("ttfa", time.Since(startTime)))
+			}
+
+			// 小端16位PCM，分块边界可能落在样本中间
+			data := append(leftover, chunk...)
+			samples := make([]int16, len(data)/2)
+			for i := range samples {
+				samples[i] = int16(data[i*2]) | int16(data[i*2+1])<<8
+			}
+			leftover = append([]byte(nil), data[len(samples)*2:]...)
+			// 按首段语音测得的增益归一，与整段合成的响度一致
+			if normalizer != nil {
+				samples = normalizer.Process(samples)
+			}
+			pending = append(pending, samples...)
+
+			for len(pending) >= sender.frameSamples {
+				session.waitResumed(stop)
+				if agc != nil {
+					agc.Process(pending[:sender.frameSamples])
+				}
+				sender.send(pending[:sender.frameSamples])
+				played += sender.frameSamples
+				pending = pending[sender.frameSamples:]
+
+				// 发送过程中也要及时响应停止
+				select {
+				case <-stop:
+					return stopPlayback()
+				default:
+				}
+			}
+		}
+	}
+}
+
 // listenForUserInput 监听用户语音输入
 func (engine *AIPhoneEngine) listenForUserInput(session *ScriptSession, timeout time.Duration) (string, error) {
+	return engine.listenForUserInputWithPreroll(session, timeout, nil)
+}
+
+// listenForUserInputWithPreroll 监听用户语音输入，preroll为插话时已捕获的语音，会直接作为句首送入ASR
+func (engine *AIPhoneEngine) listenForUserInputWithPreroll(session *ScriptSession, timeout time.Duration, preroll []int16) (string, error) {
 	logger.Info("Listening for user input",
 		zap.String("call_id", session.CallID),
-		zap.Duration("timeout", timeout))
+		zap.Duration("timeout", timeout),
+		zap.Int("preroll_samples", len(preroll)))
+	if session.text != nil {
+		return engine.listenForText(session, timeout)
+	}
 
 	// 清空音频缓冲区，确保对话隔离
 	session.mutex.Lock()
 	session.audioBuffer = make([]int16, 0, 96000) // 重新分配，确保完全清空
+	session.audioBuffer = append(session.audioBuffer, preroll...)
 	session.isListening = true
 	session.mutex.Unlock()
 
@@ -127,14 +519,21 @@ func (engine *AIPhoneEngine) listenForUserInput(session *ScriptSession, timeout
 		session.mutex.Unlock()
 	}()
 
+	// 监听期间发送舒适噪音，避免线路无媒体
+	defer engine.startComfortNoise(session)()
+
 	// 解析客户端地址
 	clientAddr, err := net.ResolveUDPAddr("udp", session.ClientAddr)
 	if err != nil {
 		return "", fmt.Errorf("failed to resolve client address: %w", err)
 	}
 
-	// 音频收集参数
-	buffer := make([]byte, 1500)
+	// 入向流水线：解码 → 回声判断 → 扩展阶段 → VAD → ASR缓冲
+	pipeline, inbound, err := engine.newReceivePipeline(session, clientAddr, audioBufferSink(session))
+	if err != nil {
+		return "", err
+	}
+	sampleRate := session.Codec.SampleRate
 
 	// 等待阶段参数
 	waitingForSpeech := true
@@ -149,81 +548,58 @@ func (engine *AIPhoneEngine) listenForUserInput(session *ScriptSession, timeout
 	consecutiveSilencePackets := 0
 	maxConsecutiveSilence := 100 // 连续静音包数量阈值（2秒）
 
-	// 音频质量检测参数
-	silenceThreshold := int16(500) // 静音阈值，提高阈值避免背景噪音
-	validAudioThreshold := 0.2     // 有效音频样本比例阈值，降低阈值
-
-	startTime := time.Now()
+	clock := engine.getClock()
+	startTime := clock.Now()
 
-	for time.Since(startTime) < timeout && audioPacketCount < maxAudioPackets {
-		// 设置读取超时
-		engine.server.rtpConn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	// 用户已经在插话，跳过等待阶段
+	if len(preroll) > 0 {
+		waitingForSpeech = false
+		hasValidAudio = true
+		audioPacketCount = len(preroll) / session.Codec.FrameSamples()
+		speechStartTime = startTime.Add(-time.Duration(len(preroll)) * time.Second / time.Duration(sampleRate))
+	}
 
-		n, receivedAddr, err := engine.server.rtpConn.ReadFromUDP(buffer)
+	for clock.Now().Sub(startTime) < timeout && audioPacketCount < maxAudioPackets {
+		// 保持期间不识别对端的等待音乐，恢复后重新计时
+		if session.waitResumed(nil) {
+			startTime = clock.Now()
+		}
+		frame, err := pipeline.Next()
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				// 检查是否在等待用户开始说话阶段超时
-				if waitingForSpeech && time.Since(startTime) > noSpeechTimeout {
+				if waitingForSpeech && clock.Now().Sub(startTime) > noSpeechTimeout {
 					logger.Info("No speech detected within timeout",
 						zap.String("call_id", session.CallID),
-						zap.Duration("waited", time.Since(startTime)))
+						zap.Duration("waited", clock.Now().Sub(startTime)))
 					return "", nil // 用户没有说话
 				}
 				continue
 			}
+			if errors.Is(err, errUndecodableFrame) {
+				logger.Debug("Failed to decode RTP payload", zap.String("codec", session.Codec.Name))
+				continue
+			}
 			logger.Error("Failed to read RTP data", zap.Error(err))
 			continue
 		}
 
-		// 检查是否来自目标客户端
-		if !receivedAddr.IP.Equal(clientAddr.IP) {
-			continue
-		}
-
-		// 解析RTP包
-		packet := &rtp.Packet{}
-		if err := packet.Unmarshal(buffer[:n]); err != nil {
-			logger.Debug("Failed to parse RTP packet", zap.Error(err))
-			continue
-		}
-
-		// 只处理PCMU (payload type 0)
-		if packet.PayloadType != 0 {
-			continue
-		}
-
 		audioPacketCount++
 
-		// 解码μ-law为PCM并检查音频质量
-		validSamples := 0
-		totalSamples := len(packet.Payload)
-		packetSamples := make([]int16, totalSamples)
-
-		session.mutex.Lock()
-		for i, mulawByte := range packet.Payload {
-			pcm := mulawToLinear(mulawByte)
-			packetSamples[i] = pcm
-			session.audioBuffer = append(session.audioBuffer, pcm)
-
-			// 检查是否有有效音频信号（超过静音阈值）
-			if pcm > silenceThreshold || pcm < -silenceThreshold {
-				validSamples++
-			}
+		// 提示音回声或被扩展阶段丢弃的帧按静音处理，不送入ASR
+		if frame == nil || frame.Echo {
+			consecutiveSilencePackets++
+			continue
 		}
-		session.mutex.Unlock()
-
-		// 判断这个包是否包含有效音频
-		validRatio := float64(validSamples) / float64(totalSamples)
-		isValidPacket := validRatio > validAudioThreshold
+		isValidPacket := frame.Speech
 
 		// 添加调试信息
 		if audioPacketCount%50 == 0 { // 每50个包打印一次调试信息
 			logger.Debug("Audio packet analysis",
 				zap.String("call_id", session.CallID),
 				zap.Int("packet_count", audioPacketCount),
-				zap.Int("valid_samples", validSamples),
-				zap.Int("total_samples", totalSamples),
-				zap.Float64("valid_ratio", validRatio),
+				zap.Int("total_samples", len(frame.Samples)),
+				zap.Float64("valid_ratio", frame.SpeechRatio),
 				zap.Bool("is_valid", isValidPacket),
 				zap.Int("consecutive_silence", consecutiveSilencePackets))
 		}
@@ -232,10 +608,10 @@ func (engine *AIPhoneEngine) listenForUserInput(session *ScriptSession, timeout
 			if waitingForSpeech {
 				// 检测到用户开始说话
 				waitingForSpeech = false
-				speechStartTime = time.Now()
+				speechStartTime = clock.Now()
 				logger.Info("Speech detected, starting recording",
 					zap.String("call_id", session.CallID),
-					zap.Duration("wait_time", time.Since(startTime)))
+					zap.Duration("wait_time", clock.Now().Sub(startTime)))
 			}
 			hasValidAudio = true
 			consecutiveSilencePackets = 0
@@ -252,39 +628,45 @@ func (engine *AIPhoneEngine) listenForUserInput(session *ScriptSession, timeout
 		if audioPacketCount >= minAudioPackets && hasValidAudio &&
 			consecutiveSilencePackets >= maxConsecutiveSilence {
 			// 额外检查：确保已经录制了至少2秒的音频
-			if !speechStartTime.IsZero() && time.Since(speechStartTime) >= 2*time.Second {
+			if !speechStartTime.IsZero() && clock.Now().Sub(speechStartTime) >= 2*time.Second {
 				logger.Info("Speech end detected by consecutive silence",
 					zap.String("call_id", session.CallID),
 					zap.Int("total_packets", audioPacketCount),
 					zap.Int("silence_packets", consecutiveSilencePackets),
-					zap.Duration("speech_duration", time.Since(speechStartTime)))
+					zap.Duration("speech_duration", clock.Now().Sub(speechStartTime)))
 				break
 			} else {
 				// 如果录制时间不够，重置静音计数，继续录制
 				consecutiveSilencePackets = maxConsecutiveSilence / 2 // 减少一半静音计数而不是完全重置
 				logger.Debug("Speech too short, continuing recording",
 					zap.String("call_id", session.CallID),
-					zap.Duration("current_duration", time.Since(speechStartTime)))
+					zap.Duration("current_duration", clock.Now().Sub(speechStartTime)))
 			}
 		}
 
 		// 如果语音时间过长，也要结束
-		if !speechStartTime.IsZero() && time.Since(speechStartTime) > 10*time.Second {
+		if !speechStartTime.IsZero() && clock.Now().Sub(speechStartTime) > 10*time.Second {
 			logger.Info("Speech duration limit reached",
 				zap.String("call_id", session.CallID),
-				zap.Duration("duration", time.Since(speechStartTime)))
+				zap.Duration("duration", clock.Now().Sub(speechStartTime)))
 			break
 		}
 	}
 
 	// 清除读取超时
-	engine.server.rtpConn.SetReadDeadline(time.Time{})
+	engine.sessionConn(session).SetReadDeadline(time.Time{})
+	logger.Debug("Jitter buffer stats",
+		zap.String("call_id", session.CallID),
+		zap.Int("reordered", inbound.jitter.Reordered),
+		zap.Int("late", inbound.jitter.Late),
+		zap.Int("lost", inbound.jitter.Lost),
+		zap.Int("resyncs", inbound.jitter.Resyncs))
 
 	// 如果还在等待用户说话阶段，说明用户没有回应
 	if waitingForSpeech {
 		logger.Info("User did not respond within timeout",
 			zap.String("call_id", session.CallID),
-			zap.Duration("total_wait", time.Since(startTime)))
+			zap.Duration("total_wait", clock.Now().Sub(startTime)))
 		return "", nil
 	}
 
@@ -295,9 +677,9 @@ func (engine *AIPhoneEngine) listenForUserInput(session *ScriptSession, timeout
 	session.mutex.Unlock()
 
 	// 检查音频长度和质量
-	audioLengthMs := len(audioData) * 1000 / 8000 // 8kHz采样率
+	audioLengthMs := len(audioData) * 1000 / sampleRate
 
-	if len(audioData) < 8000 { // 少于1秒的音频认为无效
+	if len(audioData) < sampleRate { // 少于1秒的音频认为无效
 		logger.Info("Audio too short, considered invalid",
 			zap.String("call_id", session.CallID),
 			zap.Int("samples", len(audioData)),
@@ -313,10 +695,14 @@ func (engine *AIPhoneEngine) listenForUserInput(session *ScriptSession, timeout
 		zap.Int("length_ms", audioLengthMs),
 		zap.Int("packets", audioPacketCount),
 		zap.Bool("has_valid_audio", hasValidAudio),
-		zap.Duration("speech_duration", time.Since(speechStartTime)))
+		zap.Duration("speech_duration", clock.Now().Sub(speechStartTime)))
+
+	if session.Cost != nil {
+		session.Cost.AddASRAudio(time.Duration(len(audioData)) * time.Second / time.Duration(sampleRate))
+	}
 
 	// 调用ASR服务识别语音
-	return engine.callASRService(audioData)
+	return engine.callASRService(session, audioData, sampleRate)
 }
 
 // listenForDTMF 监听DTMF按键输入
@@ -326,6 +712,9 @@ func (engine *AIPhoneEngine) listenForDTMF(session *ScriptSession, timeout time.
 		zap.Duration("timeout", timeout),
 		zap.Int("max_digits", maxDigits),
 		zap.String("terminator", terminator))
+	if session.text != nil {
+		return engine.listenForTextDigits(session, timeout, maxDigits, terminator)
+	}
 
 	// 解析客户端地址
 	clientAddr, err := net.ResolveUDPAddr("udp", session.ClientAddr)
@@ -333,10 +722,14 @@ func (engine *AIPhoneEngine) listenForDTMF(session *ScriptSession, timeout time.
 		return "", fmt.Errorf("failed to resolve client address: %w", err)
 	}
 
+	defer engine.startComfortNoise(session)()
+
 	// DTMF检测参数
 	buffer := make([]byte, 1500)
+	detector := newTelephoneEventDetector(session.Codec.TelephoneEvent)
 	dtmfInput := ""
-	startTime := time.Now()
+	clock := engine.getClock()
+	startTime := clock.Now()
 
 	// DTMF频率检测表（简化版本）
 	dtmfFreqs := map[string]string{
@@ -346,11 +739,14 @@ func (engine *AIPhoneEngine) listenForDTMF(session *ScriptSession, timeout time.
 		"*": "941,1209", "0": "941,1336", "#": "941,1477", "D": "941,1633",
 	}
 
-	for time.Since(startTime) < timeout && len(dtmfInput) < maxDigits {
+	for clock.Now().Sub(startTime) < timeout && len(dtmfInput) < maxDigits {
+		if session.waitResumed(nil) {
+			startTime = clock.Now()
+		}
 		// 设置读取超时
-		engine.server.rtpConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		engine.sessionConn(session).SetReadDeadline(clock.Now().Add(100 * time.Millisecond))
 
-		n, receivedAddr, err := engine.server.rtpConn.ReadFromUDP(buffer)
+		n, receivedAddr, err := engine.sessionConn(session).ReadFromUDP(buffer)
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				continue
@@ -360,7 +756,7 @@ func (engine *AIPhoneEngine) listenForDTMF(session *ScriptSession, timeout time.
 		}
 
 		// 检查是否来自目标客户端
-		if !receivedAddr.IP.Equal(clientAddr.IP) {
+		if !engine.acceptRTP(session, clientAddr, receivedAddr) {
 			continue
 		}
 
@@ -370,60 +766,45 @@ func (engine *AIPhoneEngine) listenForDTMF(session *ScriptSession, timeout time.
 			continue
 		}
 
-		// 检查是否是DTMF事件包 (payload type 101)
-		if packet.PayloadType == 101 {
-			// 解析DTMF事件
-			if len(packet.Payload) >= 4 {
-				event := packet.Payload[0]
-				// end := (packet.Payload[1] & 0x80) != 0
-				// volume := packet.Payload[1] & 0x3F
-				// duration := binary.BigEndian.Uint16(packet.Payload[2:4])
-
-				// DTMF事件映射
-				dtmfMap := map[byte]string{
-					0: "0", 1: "1", 2: "2", 3: "3", 4: "4", 5: "5", 6: "6", 7: "7", 8: "8", 9: "9",
-					10: "*", 11: "#", 12: "A", 13: "B", 14: "C", 15: "D",
-				}
-
-				if digit, exists := dtmfMap[event]; exists {
-					dtmfInput += digit
-					logger.Info("DTMF digit detected",
-						zap.String("call_id", session.CallID),
-						zap.String("digit", digit),
-						zap.String("current_input", dtmfInput))
-
-					// 检查是否遇到终止符
-					if digit == terminator {
-						// 移除终止符
-						if len(dtmfInput) > 0 {
-							dtmfInput = dtmfInput[:len(dtmfInput)-1]
-						}
-						break
-					}
-				}
-			}
-		} else if packet.PayloadType == 0 {
-			// 如果没有专门的DTMF事件，尝试从音频中检测DTMF（简化实现）
-			// 这里可以实现基于频率分析的DTMF检测，但比较复杂
-			// 暂时跳过音频DTMF检测
+		// 按协商的telephone-event载荷类型解析按键（未协商时为101）
+		if packet.PayloadType == session.Codec.PayloadType {
+			// 音频中的带内DTMF检测暂未实现
 			continue
 		}
+		done := false
+		for _, digit := range detector.Push(packet) {
+			if digit == terminator {
+				done = true
+				break
+			}
+			dtmfInput += digit
+			logger.Info("DTMF digit detected",
+				zap.String("call_id", session.CallID),
+				zap.String("digit", digit),
+				zap.String("current_input", dtmfInput))
+			if len(dtmfInput) >= maxDigits {
+				break
+			}
+		}
+		if done {
+			break
+		}
 	}
 
 	// 清除读取超时
-	engine.server.rtpConn.SetReadDeadline(time.Time{})
+	engine.sessionConn(session).SetReadDeadline(time.Time{})
 
 	if dtmfInput == "" {
 		logger.Info("No DTMF input detected within timeout",
 			zap.String("call_id", session.CallID),
-			zap.Duration("waited", time.Since(startTime)))
+			zap.Duration("waited", clock.Now().Sub(startTime)))
 		return "", nil
 	}
 
 	logger.Info("DTMF input completed",
 		zap.String("call_id", session.CallID),
 		zap.String("input", dtmfInput),
-		zap.Duration("duration", time.Since(startTime)))
+		zap.Duration("duration", clock.Now().Sub(startTime)))
 
 	// 避免编译器警告
 	_ = dtmfFreqs
@@ -461,88 +842,12 @@ func (engine *AIPhoneEngine) detectSpeechEnd(audioData []int16) bool {
 	return silenceRatio > 0.85
 }
 
-// callTTSService 调用TTS服务
-func (engine *AIPhoneEngine) callTTSService(text, speakerID string) ([]int16, error) {
+// callTTSService 调用TTS服务，sampleRate为通话编码的PCM采样率
+func (engine *AIPhoneEngine) callTTSService(text, speakerID string, sampleRate int) ([]int16, error) {
 	logger.Debug("Calling TTS service",
 		zap.String("text", text),
-		zap.String("speaker_id", speakerID))
-
-	// 从全局配置获取TTS配置
-	ttsConfig := config.GlobalConfig.Services.TTS
-
-	// 创建TTS配置
-	var ttsCredentialConfig synthesizer.TTSCredentialConfig
-
-	switch ttsConfig.Provider {
-	case "qcloud", "tencent":
-		ttsCredentialConfig = synthesizer.TTSCredentialConfig{
-			"provider":   "tencent",
-			"appId":      ttsConfig.AppID,
-			"secretId":   ttsConfig.SecretID,
-			"secretKey":  ttsConfig.SecretKey,
-			"voiceType":  ttsConfig.VoiceType,
-			"sampleRate": ttsConfig.SampleRate,
-			"codec":      ttsConfig.Codec,
-		}
-	case "baidu":
-		ttsCredentialConfig = synthesizer.TTSCredentialConfig{
-			"provider":   "baidu",
-			"appId":      ttsConfig.AppID,
-			"apiKey":     ttsConfig.SecretID,
-			"secretKey":  ttsConfig.SecretKey,
-			"voiceType":  ttsConfig.VoiceType,
-			"sampleRate": ttsConfig.SampleRate,
-			"codec":      ttsConfig.Codec,
-		}
-	case "aws":
-		ttsCredentialConfig = synthesizer.TTSCredentialConfig{
-			"provider":     "aws",
-			"accessKey":    ttsConfig.SecretID,
-			"secretKey":    ttsConfig.SecretKey,
-			"region":       ttsConfig.Region,
-			"voiceId":      ttsConfig.VoiceType,
-			"outputFormat": ttsConfig.Codec,
-			"sampleRate":   ttsConfig.SampleRate,
-		}
-	default:
-		// 默认使用腾讯云配置（向后兼容）
-		ttsCredentialConfig = synthesizer.TTSCredentialConfig{
-			"provider":   "tencent",
-			"appId":      utils.GetEnv("TTS_APP_ID"),
-			"secretId":   utils.GetEnv("TTS_SECRET_ID"),
-			"secretKey":  utils.GetEnv("TTS_SECRET_KEY"),
-			"voiceType":  utils.GetEnv("TTS_VOICE_TYPE"),
-			"sampleRate": utils.GetIntEnv("TTS_SAMPLE_RATE"),
-			"codec":      utils.GetEnv("TTS_CODEC"),
-		}
-
-		// 如果环境变量为空，使用默认值
-		if ttsCredentialConfig["appId"] == "" {
-			ttsCredentialConfig["appId"] = ""
-		}
-		if ttsCredentialConfig["secretId"] == "" {
-			ttsCredentialConfig["secretId"] = ""
-		}
-		if ttsCredentialConfig["secretKey"] == "" {
-			ttsCredentialConfig["secretKey"] = ""
-		}
-		if ttsCredentialConfig["voiceType"] == "" {
-			ttsCredentialConfig["voiceType"] = ""
-		}
-		if ttsCredentialConfig["sampleRate"] == int64(0) {
-			ttsCredentialConfig["sampleRate"] = 8000
-		}
-		if ttsCredentialConfig["codec"] == "" {
-			ttsCredentialConfig["codec"] = "pcm"
-		}
-	}
-
-	// 创建TTS服务
-	ttsService, err := synthesizer.NewSynthesisServiceFromCredential(ttsCredentialConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create TTS service: %w", err)
-	}
-	defer ttsService.Close()
+		zap.String("speaker_id", speakerID),
+		zap.Int("sample_rate", sampleRate))
 
 	// 创建音频缓冲区
 	buffer := &synthesizer.SynthesisBuffer{}
@@ -551,8 +856,7 @@ func (engine *AIPhoneEngine) callTTSService(text, speakerID string) ([]int16, er
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	err = ttsService.Synthesize(ctx, buffer, text)
-	if err != nil {
+	if err := engine.services().Synthesizer.Synthesize(ctx, buffer, text, sampleRate); err != nil {
 		return nil, fmt.Errorf("TTS synthesis failed: %w", err)
 	}
 
@@ -567,55 +871,55 @@ func (engine *AIPhoneEngine) callTTSService(text, speakerID string) ([]int16, er
 		audioData[i] = int16(buffer.Data[i*2]) | int16(buffer.Data[i*2+1])<<8
 	}
 
-	// 音频质量检查和调整
-	if len(audioData) > 0 {
-		// 检查音频幅度，如果太小则放大
-		maxAmplitude := int16(0)
-		for _, sample := range audioData {
-			if sample < 0 {
-				sample = -sample
-			}
-			if sample > maxAmplitude {
-				maxAmplitude = sample
-			}
-		}
-
-		// 如果音频太小，适当放大（但不要过度）
-		if maxAmplitude < 8000 && maxAmplitude > 0 {
-			amplifyRatio := float64(8000) / float64(maxAmplitude)
-			if amplifyRatio > 4.0 {
-				amplifyRatio = 4.0 // 最多放大4倍
-			}
-
-			for i := range audioData {
-				audioData[i] = int16(float64(audioData[i]) * amplifyRatio)
-			}
-
-			logger.Debug("Audio amplified",
-				zap.Float64("ratio", amplifyRatio),
-				zap.Int16("original_max", maxAmplitude))
-		}
+	// 整段按目标响度统一音量并限幅，不同服务商的音量保持一致
+	if _, enabled, _ := agcConfig(); enabled {
+		gain := normalizeLoudness(audioData, sampleRate)
+		logger.Debug("TTS audio loudness normalized", zap.Float64("gain", gain))
 	}
 
 	logger.Info("TTS synthesis completed",
-		zap.String("provider", ttsConfig.Provider),
 		zap.String("text", text),
 		zap.Int("samples", len(audioData)))
 
 	return audioData, nil
 }
 
-// callASRService 调用ASR服务
-func (engine *AIPhoneEngine) callASRService(audioData []int16) (string, error) {
-	logger.Debug("Calling ASR service", zap.Int("samples", len(audioData)))
+// asrSampleRate 根据ASR模型类型判断采样率，16k_开头的模型使用16kHz，其余按电话音质8kHz
+func asrSampleRate(modelType string) int {
+	if strings.HasPrefix(modelType, "16k") {
+		return 16000
+	}
+	return 8000
+}
+
+// sessionRecognizer 返回会话的识别服务，支持复用连接的服务每通电话只建立一个识别会话（streaming_asr开关关闭时每轮单独连接）
+func (engine *AIPhoneEngine) sessionRecognizer(session *ScriptSession) Recognizer {
+	recognizer := engine.services().Recognizer
+	opener, ok := recognizer.(SessionRecognizer)
+	if !ok || !features.Enabled(features.StreamingASR) {
+		return recognizer
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.asr == nil {
+		session.asr = opener.OpenSession(session.CallID)
+	}
+	return session.asr
+}
+
+// callASRService 调用ASR服务，sampleRate为audioData的采样率
+func (engine *AIPhoneEngine) callASRService(session *ScriptSession, audioData []int16, sampleRate int) (string, error) {
+	logger.Debug("Calling ASR service",
+		zap.Int("samples", len(audioData)),
+		zap.Int("sample_rate", sampleRate))
 
-	if len(audioData) < 8000 { // 少于1秒认为无效
+	if len(audioData) < sampleRate { // 少于1秒认为无效
 		logger.Info("Audio too short for ASR", zap.Int("samples", len(audioData)))
 		return "", nil
 	}
 
 	// 限制音频长度，避免过长的音频影响识别效果
-	maxSamples := 80000 // 最多10秒音频 (10 * 8000)
+	maxSamples := 10 * sampleRate // 最多10秒音频
 	if len(audioData) > maxSamples {
 		logger.Debug("Truncating audio data",
 			zap.Int("original_samples", len(audioData)),
@@ -623,167 +927,18 @@ func (engine *AIPhoneEngine) callASRService(audioData []int16) (string, error) {
 		audioData = audioData[:maxSamples]
 	}
 
-	// 从全局配置获取ASR配置
-	asrConfig := config.GlobalConfig.Services.ASR
-
-	var asr recognizer.TranscribeService
-	var err error
-
-	switch asrConfig.Provider {
-	case "qcloud", "tencent":
-		// 创建腾讯云ASR配置
-		qcloudConfig := recognizer.NewQcloudASROption(
-			asrConfig.AppID,
-			asrConfig.SecretID,
-			asrConfig.SecretKey,
-		)
-		qcloudConfig.ModelType = asrConfig.ModelType
-		if qcloudConfig.ModelType == "" {
-			qcloudConfig.ModelType = "8k_zh" // 默认8k中文模型，适合电话音质
-		}
-		asr = recognizer.NewQcloudASR(qcloudConfig)
-
-	case "google":
-		// 创建Google ASR配置
-		googleConfig := recognizer.GoogleASROption{
-			LanguageCode: asrConfig.Language,
-		}
-		if googleConfig.LanguageCode == "" {
-			googleConfig.LanguageCode = "zh-CN"
-		}
-		googleASR := recognizer.NewGoogleASR(googleConfig)
-		asr = &googleASR
-
-	case "qiniu":
-		// 创建七牛云ASR配置
-		qiniuConfig := recognizer.QiniuASROption{
-			APIKey: asrConfig.SecretID,
-		}
-		asr = recognizer.NewQiniuASR(qiniuConfig)
-
-	default:
-		// 默认使用腾讯云配置（向后兼容）
-		appID := utils.GetEnv("ASR_APP_ID")
-		secretID := utils.GetEnv("ASR_SECRET_ID")
-		secretKey := utils.GetEnv("ASR_SECRET_KEY")
-
-		// 如果环境变量为空，使用默认值
-		if appID == "" {
-			appID = ""
-		}
-		if secretID == "" {
-			secretID = ""
-		}
-		if secretKey == "" {
-			secretKey = ""
-		}
-
-		qcloudConfig := recognizer.NewQcloudASROption(appID, secretID, secretKey)
-		qcloudConfig.ModelType = "8k_zh" // 8k中文模型，适合电话音质
-		asr = recognizer.NewQcloudASR(qcloudConfig)
-	}
-
-	// 设置结果回调
-	var result string
-	var asrError error
-	done := make(chan bool, 1)
-
-	asr.Init(
-		func(text string, isFinal bool, duration time.Duration, dialogID string) {
-			logger.Debug("ASR callback received",
-				zap.String("text", text),
-				zap.Bool("is_final", isFinal),
-				zap.Duration("duration", duration))
-
-			if text != "" {
-				result = text
-				if isFinal {
-					logger.Info("ASR recognition completed",
-						zap.String("provider", asrConfig.Provider),
-						zap.String("text", text),
-						zap.Duration("duration", duration))
-					done <- true
-				}
-			} else if isFinal {
-				// 即使没有文本，如果是最终结果也要结束
-				logger.Info("ASR recognition completed with empty result")
-				done <- true
-			}
-		},
-		func(err error, isFinal bool) {
-			logger.Error("ASR error callback",
-				zap.String("provider", asrConfig.Provider),
-				zap.Error(err),
-				zap.Bool("is_final", isFinal))
-			if isFinal {
-				asrError = err
-				done <- true
-			}
-		},
-	)
-
-	// 启动ASR连接
-	dialogID := fmt.Sprintf("dialog_%d", time.Now().UnixNano())
-	err = asr.ConnAndReceive(dialogID)
-	if err != nil {
-		return "", fmt.Errorf("failed to connect ASR: %w", err)
-	}
-	defer asr.StopConn()
-
-	// 将PCM样本转换为字节数据
-	audioBytes := make([]byte, len(audioData)*2)
-	for i, sample := range audioData {
-		// 小端字节序
-		audioBytes[i*2] = byte(sample & 0xFF)
-		audioBytes[i*2+1] = byte((sample >> 8) & 0xFF)
-	}
-
-	logger.Debug("Sending audio data to ASR",
-		zap.String("provider", asrConfig.Provider),
-		zap.Int("audio_bytes", len(audioBytes)),
-		zap.Int("samples", len(audioData)),
-		zap.Int("duration_ms", len(audioData)*1000/8000))
-
-	// 分块发送音频数据（每次发送1600字节，约100ms的音频）
-	chunkSize := 1600
-	for i := 0; i < len(audioBytes); i += chunkSize {
-		end := i + chunkSize
-		if end > len(audioBytes) {
-			end = len(audioBytes)
-		}
-
-		chunk := audioBytes[i:end]
-		err = asr.SendAudioBytes(chunk)
-		if err != nil {
-			return "", fmt.Errorf("failed to send audio chunk: %w", err)
-		}
-
-		// 稍微延迟模拟实时发送
-		time.Sleep(50 * time.Millisecond)
-	}
-
-	// 发送结束标志
-	err = asr.SendEnd()
-	if err != nil {
-		return "", fmt.Errorf("failed to send end signal: %w", err)
-	}
-
-	logger.Debug("Audio data sent, waiting for ASR result")
-
-	// 等待识别结果（最多等待15秒）
-	select {
-	case <-done:
-		if asrError != nil {
-			return "", asrError
-		}
-		if result == "" {
-			logger.Info("ASR returned empty result")
-			return "", nil
-		}
-		return result, nil
-	case <-time.After(15 * time.Second):
-		return "", fmt.Errorf("ASR recognition timeout")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	ctx = withPartialResults(ctx, func(text string) {
+		event := session.monitorEvent(MonitorASRPartial)
+		event.Text = text
+		session.publish(event)
+	})
+	ctx = withASRModels(ctx, session.ASRModels)
+	if dialect := session.asrDialect(); dialect != "" {
+		ctx = withASRDialect(ctx, dialect)
 	}
+	return engine.sessionRecognizer(session).Recognize(ctx, audioData, sampleRate)
 }
 
 // callAIService 调用AI服务
@@ -793,11 +948,26 @@ func (engine *AIPhoneEngine) callAIService(session *ScriptSession, prompt string
 		zap.String("prompt", prompt))
 
 	// 如果有LLM服务，使用LLM服务
-	if engine.llmService != nil {
-		// 构建完整的提示词，包含上下文
-		fullPrompt := engine.buildPromptWithContext(session, prompt)
-
-		response, err := engine.llmService.Query(fullPrompt)
+	if assistant := engine.services().Assistant; assistant != nil {
+		// 展开模板和变量后构建完整的提示词，包含上下文
+		fullPrompt := engine.buildPromptWithContext(session, engine.renderPrompt(session, prompt))
+
+		ctx, release := session.assistantContext()
+		recorder := engine.newLLMRecorder(session, fullPrompt)
+		response, err := engine.queryAssistant(recorder.observe(ctx), session, assistant, fullPrompt)
+		cause := context.Cause(ctx)
+		release()
+		recorder.save(session, response, err, cause)
+		if session.Cost != nil {
+			session.Cost.AddLLMText(fullPrompt, response)
+		}
+		// 被插话、按键或挂断取消时不降级，由调用方处理
+		if err != nil && cause != nil {
+			logger.Info("LLM reply cancelled",
+				zap.String("call_id", session.CallID),
+				zap.String("reason", cause.Error()))
+			return "", cause
+		}
 		if err != nil {
 			logger.Error("LLM service call failed",
 				zap.String("call_id", session.CallID),
@@ -827,7 +997,7 @@ func (engine *AIPhoneEngine) callAIService(session *ScriptSession, prompt string
 func (engine *AIPhoneEngine) buildPromptWithContext(session *ScriptSession, basePrompt string) string {
 	// 构建对话历史
 	conversationHistory := ""
-	for _, msg := range session.Conversation {
+	for _, msg := range session.conversation() {
 		role := "用户"
 		if msg.Role == "assistant" {
 			role = "助手"
@@ -865,7 +1035,7 @@ func (engine *AIPhoneEngine) getMockAIResponse(session *ScriptSession) string {
 	}
 
 	// 根据对话轮数选择不同回复
-	index := len(session.Conversation) % len(mockResponses)
+	index := len(session.conversation()) % len(mockResponses)
 	return mockResponses[index]
 }
 
@@ -899,7 +1069,7 @@ func (engine *AIPhoneEngine) evaluateCondition(session *ScriptSession, condition
 		hasNegative := false
 		hasGreeting := false
 
-		for _, msg := range session.Conversation {
+		for _, msg := range session.conversation() {
 			if msg.Role == "user" {
 				content := msg.Content
 				// 明确的就业需求关键词
@@ -936,7 +1106,7 @@ func (engine *AIPhoneEngine) evaluateCondition(session *ScriptSession, condition
 			logger.Info("User greeting detected, need further inquiry",
 				zap.String("call_id", session.CallID))
 			// 这里我们可以设置一个标记，让脚本继续询问
-			session.Context["needs_further_inquiry"] = true
+			session.Context.Set("needs_further_inquiry", true)
 			return false, nil // 暂时返回false，但标记需要进一步询问
 		}
 
@@ -947,14 +1117,14 @@ func (engine *AIPhoneEngine) evaluateCondition(session *ScriptSession, condition
 
 	case "needs_further_inquiry":
 		// 检查是否需要进一步询问
-		if needsInquiry, exists := session.Context["needs_further_inquiry"]; exists && needsInquiry.(bool) {
+		if session.Context.GetBool("needs_further_inquiry") {
 			return true, nil
 		}
 		return false, nil
 
 	case "user_satisfied":
 		// 检查用户是否满意
-		for _, msg := range session.Conversation {
+		for _, msg := range session.conversation() {
 			if msg.Role == "user" {
 				content := msg.Content
 				if contains(content, []string{"满意", "好的", "可以", "谢谢", "行", "好"}) {
@@ -977,7 +1147,7 @@ func (engine *AIPhoneEngine) evaluateCondition(session *ScriptSession, condition
 
 	case "collect_success":
 		// 检查信息收集是否成功
-		if collectFailed, exists := session.Context["collect_failed"]; exists && collectFailed.(bool) {
+		if session.Context.GetBool("collect_failed") {
 			return false, nil
 		}
 		return isEngaged, nil
@@ -1017,18 +1187,18 @@ func contains(text string, keywords []string) bool {
 
 // getUserResponseStatus 获取用户回应状态
 func (engine *AIPhoneEngine) getUserResponseStatus(session *ScriptSession) string {
-	if noResponse, exists := session.Context["no_user_response"]; exists && noResponse.(bool) {
-		if retryCount, exists := session.Context["retry_count"]; exists {
-			return fmt.Sprintf("no_response_after_%d_attempts", retryCount.(int))
+	if session.Context.GetBool("no_user_response") {
+		if retryCount, exists := session.Context.GetInt("retry_count"); exists {
+			return fmt.Sprintf("no_response_after_%d_attempts", retryCount)
 		}
 		return "no_response"
 	}
 
-	if collectFailed, exists := session.Context["collect_failed"]; exists && collectFailed.(bool) {
+	if session.Context.GetBool("collect_failed") {
 		return "collect_failed"
 	}
 
-	if len(session.Conversation) > 0 {
+	if len(session.conversation()) > 0 {
 		return "has_response"
 	}
 
@@ -1039,7 +1209,7 @@ func (engine *AIPhoneEngine) getUserResponseStatus(session *ScriptSession) strin
 func (engine *AIPhoneEngine) isUserEngaged(session *ScriptSession) bool {
 	// 检查是否有有效的用户回应
 	userMessageCount := 0
-	for _, msg := range session.Conversation {
+	for _, msg := range session.conversation() {
 		if msg.Role == "user" && len(msg.Content) > 0 {
 			userMessageCount++
 		}
@@ -1047,7 +1217,7 @@ func (engine *AIPhoneEngine) isUserEngaged(session *ScriptSession) bool {
 
 	// 如果有用户消息且没有标记为无回应，认为用户参与了
 	if userMessageCount > 0 {
-		if noResponse, exists := session.Context["no_user_response"]; !exists || !noResponse.(bool) {
+		if !session.Context.GetBool("no_user_response") {
 			return true
 		}
 	}
@@ -1,12 +1,19 @@
 package sip1
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/LingByte/LingSIP/internal/models"
 	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/media"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
@@ -19,16 +26,32 @@ type AIPhoneEngine struct {
 	mutex    sync.RWMutex
 
 	// 服务接口
-	asrService interface{} // ASR服务接口
-	ttsService interface{} // TTS服务接口
-	aiService  interface{} // AI服务接口
-	llmService LLMService  // LLM服务接口
-}
+	recognizer  Recognizer
+	synthesizer Synthesizer
+	assistant   Assistant
+	translator  Translator
+
+	// 入向音频扩展阶段
+	receiveStages []ReceiveStageFactory
+
+	// 等待音乐缓存 file@sampleRate -> PCM
+	holdAudio map[string][]int16
+
+	// 排队呼叫接通前的实际等待时长，用于等待时间播报
+	waits *WaitStats
 
-// LLMService LLM服务接口
-type LLMService interface {
-	Query(text string) (string, error)
-	Reset()
+	// 灰度脚本的通话结果，用于自动回滚
+	canary *canaryTracker
+
+	// 时钟和RTP连接，为nil时使用系统时钟和SIP服务的共享连接
+	clock Clock
+	media RTPConn
+
+	// 通话实时监控
+	monitor *CallMonitor
+
+	// 会话和步骤事件的预写日志，未配置存储时为nil
+	journal *sessionJournal
 }
 
 // ScriptSession 脚本执行会话
@@ -37,6 +60,8 @@ type ScriptSession struct {
 	SessionID  string
 	CallID     string
 	ClientAddr string
+	Codec      rtpCodec // 协商出的RTP编码
+	SSRC       uint32   // 本端RTP的SSRC，与通话的RTCP报告一致
 
 	// 脚本信息
 	Script      *models.AIPhoneScript
@@ -44,7 +69,7 @@ type ScriptSession struct {
 
 	// 会话状态
 	Status       models.SessionStatus
-	Context      map[string]interface{}
+	Context      *ScriptContext
 	Conversation []models.ConversationMessage
 
 	// 数据库记录
@@ -58,69 +83,278 @@ type ScriptSession struct {
 	StartTime time.Time
 	StepCount int
 
+	// 费用估算
+	Cost *CallCostMeter
+
+	// 回声抑制，未开启时为nil
+	Echo *echoSuppressor
+
+	// 监听期间的舒适噪音方式
+	ComfortNoise models.ComfortNoiseMode
+
+	// ASR模型选择，脚本配置优先于中继，识别时按采样率取用
+	ASRModels models.ASRModelSelection
+
+	// 对话服务请求的挂断或转接，本轮回复播放完后执行
+	assistantAction *assistantAction
+	// 进行中的对话服务请求，插话、按键或挂断时取消
+	cancelAssistant context.CancelCauseFunc
+	// 当前步骤执行记录ID，对话服务请求记录关联到该执行
+	executionID uint
+
 	// 音频处理
 	audioBuffer []int16
 	isListening bool
 
+	// 入向音频扩展阶段，首次监听时创建
+	receiveFilters     []media.FrameFilter
+	receiveSinks       []media.FrameSink
+	receiveStagesReady bool
+	inboundAGC         *media.AGC
+
+	// 复用连接的识别会话
+	asr RecognizerSession
+
+	// 停泊、排队时的等待音乐
+	hold *holdPlayer
+
+	// 被对端保持期间暂停放音和识别，resumed在恢复或停止时关闭，holdTimer为保持超时挂断
+	held      bool
+	resumed   chan struct{}
+	holdTimer *time.Timer
+
+	// 监听语音期间的按键回调，录音告知时设置
+	onDigit func(digit string)
+
+	// 翻译对话步骤的语言设置，其余步骤为nil
+	translation *stepTranslation
+
+	// 对端号码：呼入为主叫，外呼为被叫，未知时为空
+	remoteNumber string
+
+	// 嵌入式通话的媒体连接，为nil时使用引擎的RTP连接
+	media RTPConn
+
+	// 对称RTP：rtpLatch在首个入向包后置位，latchedPeer为锁定到的NAT后实际地址，未改变地址时为nil
+	rtpLatch    atomic.Bool
+	latchedPeer atomic.Pointer[net.UDPAddr]
+
+	// 文字会话的收发通道，语音会话为nil
+	text *textChannel
+
+	// 已清理，通道已关闭
+	closed bool
+
+	// 请求停止时取消，见stopContext；stopRequested在首次取用context之前记录停止请求
+	stopCtx       context.Context
+	cancelStop    context.CancelFunc
+	stopRequested bool
+
+	// 通话实时监控
+	monitor *CallMonitor
+
 	mutex sync.RWMutex
 }
 
 // NewAIPhoneEngine 创建AI电话引擎
-func NewAIPhoneEngine(server *SipServer, db *gorm.DB) *AIPhoneEngine {
-	return &AIPhoneEngine{
-		server:   server,
-		db:       db,
-		sessions: make(map[string]*ScriptSession),
+func NewAIPhoneEngine(server *SipServer, db *gorm.DB, services AIServices) *AIPhoneEngine {
+	var usage *providerUsage
+	if server != nil {
+		usage = server.usage
+	}
+	services = services.withDefaults(usage)
+	engine := &AIPhoneEngine{
+		server:      server,
+		db:          db,
+		sessions:    make(map[string]*ScriptSession),
+		holdAudio:   make(map[string][]int16),
+		waits:       NewWaitStats(),
+		canary:      newCanaryTracker(),
+		recognizer:  services.Recognizer,
+		synthesizer: services.Synthesizer,
+		assistant:   services.Assistant,
+		translator:  services.Translator,
+		monitor:     NewCallMonitor(),
+	}
+	if server != nil && server.monitor != nil {
+		engine.monitor = server.monitor
+	}
+	engine.monitor.setSnapshot(engine.monitorSnapshot)
+	if server != nil && server.config != nil && db != nil {
+		engine.openJournal(server.config.JournalPath(sessionJournalFile), server.config.FileSync)
+	}
+	return engine
+}
+
+// openJournal 按上次运行的日志修正未结束的会话，然后开始新的日志
+func (engine *AIPhoneEngine) openJournal(path string, sync bool) {
+	recovered, err := recoverSessions(engine.db, path)
+	if err != nil {
+		// 保留日志，下次启动时再修正
+		logger.Error("Failed to recover sessions from journal", zap.Error(err))
+	} else {
+		if recovered > 0 {
+			logger.Info("Finalized sessions left by previous run", zap.Int("sessions", recovered))
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to clear session journal", zap.Error(err))
+		}
+	}
+
+	journal, err := openSessionJournal(path, sync)
+	if err != nil {
+		logger.Error("Session journal disabled", zap.Error(err))
+		return
 	}
+	engine.journal = journal
 }
 
-// SetServices 设置服务接口
-func (engine *AIPhoneEngine) SetServices(asrService, ttsService, aiService interface{}) {
-	engine.asrService = asrService
-	engine.ttsService = ttsService
-	engine.aiService = aiService
+// SetServices 替换服务实现，nil字段保持原有服务
+func (engine *AIPhoneEngine) SetServices(services AIServices) {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+	if services.Recognizer != nil {
+		engine.recognizer = services.Recognizer
+	}
+	if services.Synthesizer != nil {
+		engine.synthesizer = services.Synthesizer
+	}
+	if services.Assistant != nil {
+		engine.assistant = services.Assistant
+	}
+	if services.Translator != nil {
+		engine.translator = services.Translator
+	}
 }
 
 // SetLLMService 设置LLM服务
 func (engine *AIPhoneEngine) SetLLMService(llmService LLMService) {
-	engine.llmService = llmService
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+	engine.assistant = llmService
 }
 
-// StartScript 启动脚本执行
-func (engine *AIPhoneEngine) StartScript(callID, clientAddr, phoneNumber string) error {
-	// 根据电话号码获取脚本
-	script, err := models.GetAIPhoneScriptByPhone(engine.db, phoneNumber)
-	if err != nil {
-		logger.Error("Failed to get script by phone",
-			zap.String("phone", phoneNumber),
-			zap.Error(err))
-		return err
+// services 返回当前使用的服务实现，通话进行中也可能被替换
+func (engine *AIPhoneEngine) services() AIServices {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+	return AIServices{
+		Recognizer:  engine.recognizer,
+		Synthesizer: engine.synthesizer,
+		Assistant:   engine.assistant,
+		Translator:  engine.translator,
 	}
+}
+
+// scriptCall 启动脚本会话的通话参数
+type scriptCall struct {
+	callID       string
+	clientAddr   string
+	phoneNumber  string // 被叫号码，按号码查找脚本
+	callerNumber string
+	codec        rtpCodec
+	media        RTPConn // 为nil时使用引擎的RTP连接
+	text         *textChannel
+	script       *models.AIPhoneScript // 外呼任务指定的脚本，为nil时按号码查找
+	trunk        *models.SIPTrunk      // 外呼使用的中继，为nil时按号码查找
+	variables    map[string]string     // 写入会话上下文的变量，如外呼联系人的姓名
+	outbound     bool                  // 我方呼出，对端为被叫
+	cost         *CallCostMeter        // 外呼任务拨号前创建并计入预算的费用计量，为nil时新建
+}
+
+// StartScript 启动脚本执行，callerNumber为主叫号码，未知时为空
+func (engine *AIPhoneEngine) StartScript(callID, clientAddr, phoneNumber, callerNumber string) error {
+	codec := codecPCMU
+	if engine.server != nil {
+		codec = engine.server.getCallCodec(callID)
+	}
+	_, err := engine.startScript(scriptCall{callID: callID, clientAddr: clientAddr, phoneNumber: phoneNumber, callerNumber: callerNumber, codec: codec})
+	return err
+}
 
+// startScript 按被叫号码查找脚本，创建会话并开始执行
+func (engine *AIPhoneEngine) startScript(call scriptCall) (*ScriptSession, error) {
+	callID, clientAddr, phoneNumber := call.callID, call.clientAddr, call.phoneNumber
+	script := call.script
 	if script == nil {
-		logger.Warn("No script found for phone number", zap.String("phone", phoneNumber))
-		return fmt.Errorf("no script found for phone number: %s", phoneNumber)
+		// 根据电话号码获取脚本
+		var err error
+		script, err = models.GetAIPhoneScriptByPhone(engine.db, phoneNumber)
+		if err != nil {
+			logger.Error("Failed to get script by phone",
+				zap.String("phone", phoneNumber),
+				zap.Error(err))
+			return nil, err
+		}
+
+		if script == nil {
+			logger.Warn("No script found for phone number", zap.String("phone", phoneNumber))
+			return nil, fmt.Errorf("no script found for phone number: %s", phoneNumber)
+		}
+		// 灰度期间部分呼叫仍由旧版本处理
+		script = engine.canaryScript(script)
 	}
 
 	// 创建会话
+	trunk := call.trunk
+	if trunk == nil {
+		trunk = engine.lookupTrunk(phoneNumber)
+	}
+	channel := models.SessionChannelVoice
+	if call.text != nil {
+		// 文字会话不经过中继，不计中继费用
+		trunk = nil
+		channel = models.SessionChannelText
+	}
 	sessionID := fmt.Sprintf("%d", time.Now().UnixNano()) // 使用时间戳作为数字ID
 	session := &ScriptSession{
 		SessionID:    sessionID,
 		CallID:       callID,
 		ClientAddr:   clientAddr,
+		Codec:        call.codec,
+		SSRC:         engine.callSSRC(callID),
 		Script:       script,
 		Status:       models.SessionStatusStarting,
-		Context:      make(map[string]interface{}),
+		Context:      NewScriptContext(),
 		Conversation: make([]models.ConversationMessage, 0),
 		StopChan:     make(chan bool, 1),
 		AudioChan:    make(chan []int16, 100),
-		StartTime:    time.Now(),
+		StartTime:    engine.getClock().Now(),
+		Cost:         engine.callCostMeter(call, trunk),
+		ComfortNoise: comfortNoiseMode(trunk),
+		ASRModels:    sessionASRModels(script, trunk),
+		monitor:      engine.monitor,
+		media:        call.media,
+		text:         call.text,
+	}
+
+	if engine.server != nil && engine.server.config.EchoSuppression && call.text == nil {
+		session.Echo = newEchoSuppressor(session.Codec.SampleRate)
+	}
+	if call.text != nil {
+		session.ComfortNoise = models.ComfortNoiseNone
+	}
+
+	for key, value := range call.variables {
+		if err := session.Context.Set(key, value); err != nil {
+			return nil, fmt.Errorf("set context variable %s: %w", key, err)
+		}
+	}
+	session.remoteNumber = call.callerNumber
+	if call.outbound {
+		session.remoteNumber = phoneNumber
+	} else if engine.server != nil && engine.server.config.DNCCheckInbound {
+		engine.markInboundDoNotCall(session)
 	}
 
 	// 获取起始步骤
 	session.CurrentStep = script.GetStartStep()
 	if session.CurrentStep == nil {
-		return fmt.Errorf("start step not found: %s", script.StartStepID)
+		return nil, fmt.Errorf("start step not found: %s", script.StartStepID)
+	}
+	// 需要录音告知的脚本先告知，再进入起始步骤
+	if script.RequireConsent {
+		session.CurrentStep = script.ConsentStep()
 	}
 
 	// 创建数据库会话记录
@@ -131,25 +365,32 @@ func (engine *AIPhoneEngine) StartScript(callID, clientAddr, phoneNumber string)
 		ScriptID:      script.ID,
 		ScriptName:    script.Name,
 		ScriptVersion: script.Version,
+		CallerNumber:  call.callerNumber,
 		CalleeNumber:  phoneNumber,
 		ClientRTPAddr: clientAddr,
 		StartTime:     time.Now(),
-		Context:       models.SessionContext(session.Context),
-		Conversation:  models.ConversationHistory(session.Conversation),
+		Context:       models.SessionContext(session.Context.Snapshot()),
+		Conversation:  models.ConversationHistory(session.conversation()),
+		Channel:       channel,
 	}
 
 	if err := models.CreateAIPhoneSession(engine.db, dbSession); err != nil {
 		logger.Error("Failed to create session record", zap.Error(err))
-		return err
+		return nil, err
 	}
 
 	session.DBSession = dbSession
+	engine.journalSessionStarted(session)
 
 	// 保存会话
 	engine.mutex.Lock()
 	engine.sessions[callID] = session
 	engine.mutex.Unlock()
 
+	started := session.monitorEvent(MonitorSessionStarted)
+	started.Status = string(models.SessionStatusStarting)
+	session.publish(started)
+
 	// 启动脚本执行
 	go engine.executeScript(session)
 
@@ -158,7 +399,7 @@ func (engine *AIPhoneEngine) StartScript(callID, clientAddr, phoneNumber string)
 		zap.String("script", script.Name),
 		zap.String("session_id", sessionID))
 
-	return nil
+	return session, nil
 }
 
 // executeScript 执行脚本主循环
@@ -166,7 +407,7 @@ func (engine *AIPhoneEngine) executeScript(session *ScriptSession) {
 	defer engine.cleanupSession(session)
 
 	// 更新会话状态为运行中
-	session.Status = models.SessionStatusRunning
+	session.setStatus(models.SessionStatusRunning)
 	session.DBSession.Status = models.SessionStatusRunning
 	models.UpdateAIPhoneSession(engine.db, session.DBSession)
 
@@ -177,26 +418,36 @@ func (engine *AIPhoneEngine) executeScript(session *ScriptSession) {
 	// 增加脚本执行次数
 	session.Script.IncrementExecuteCount(engine.db)
 
+	// 第一步之前播放开场问候
+	engine.playGreeting(session)
+
 	// 执行步骤循环
-	for session.CurrentStep != nil && session.StepCount < session.Script.MaxSteps {
+steps:
+	for session.currentStep() != nil && session.StepCount < session.Script.MaxSteps {
 		select {
 		case <-session.StopChan:
 			logger.Info("Script execution stopped", zap.String("call_id", session.CallID))
 			return
 		default:
 			// 检查超时
-			if time.Since(session.StartTime) > time.Duration(session.Script.MaxDuration)*time.Millisecond {
+			if engine.getClock().Now().Sub(session.StartTime) > time.Duration(session.Script.MaxDuration)*time.Millisecond {
 				logger.Warn("Script execution timeout", zap.String("call_id", session.CallID))
 				session.markTimeout("Script execution timeout")
 				return
 			}
 
 			// 执行当前步骤
-			nextStepID, err := engine.executeStep(session, session.CurrentStep)
+			step := session.currentStep()
+			nextStepID, err := engine.executeStep(session, step)
+			if errors.Is(err, errTransferStopped) {
+				// 转接等待期间挂断或被停止，与StopChan相同按正常结束处理
+				logger.Info("Script execution stopped during transfer", zap.String("call_id", session.CallID))
+				return
+			}
 			if err != nil {
 				logger.Error("Step execution failed",
 					zap.String("call_id", session.CallID),
-					zap.String("step_id", session.CurrentStep.StepID),
+					zap.String("step_id", step.StepID),
 					zap.Error(err))
 				session.markFailed(fmt.Sprintf("Step execution failed: %v", err))
 				return
@@ -207,11 +458,10 @@ func (engine *AIPhoneEngine) executeScript(session *ScriptSession) {
 			// 获取下一步骤
 			if nextStepID == "" {
 				// 脚本结束
-				break
+				break steps
 			}
 
-			session.CurrentStep = session.Script.GetStepByID(nextStepID)
-			if session.CurrentStep == nil {
+			if session.setCurrentStep(session.Script.GetStepByID(nextStepID)) == nil {
 				logger.Error("Next step not found",
 					zap.String("call_id", session.CallID),
 					zap.String("next_step_id", nextStepID))
@@ -252,6 +502,16 @@ func (engine *AIPhoneEngine) executeStep(session *ScriptSession, step *models.AI
 	if err := models.CreateStepExecution(engine.db, execution); err != nil {
 		logger.Error("Failed to create step execution record", zap.Error(err))
 	}
+	session.mutex.Lock()
+	session.executionID = execution.ID
+	session.mutex.Unlock()
+	engine.journal.record(sessionJournalEvent{
+		Type:        journalStepStarted,
+		At:          execution.StartTime,
+		SessionID:   session.SessionID,
+		StepID:      step.StepID,
+		ExecutionID: execution.ID,
+	})
 
 	var nextStepID string
 	var err error
@@ -270,10 +530,14 @@ func (engine *AIPhoneEngine) executeStep(session *ScriptSession, step *models.AI
 		nextStepID, err = engine.executeWaitStep(session, step, execution)
 	case models.StepTypeDTMF:
 		nextStepID, err = engine.executeDTMFStep(session, step, execution)
+	case models.StepTypeConsent:
+		nextStepID, err = engine.executeConsentStep(session, step, execution)
+	case models.StepTypeTranslate:
+		nextStepID, err = engine.executeTranslateStep(session, step, execution)
 	case models.StepTypeRecord:
 		nextStepID, err = step.Data.NextStep, nil // TODO: 实现录音步骤
 	case models.StepTypeTransfer:
-		nextStepID, err = step.Data.NextStep, nil // TODO: 实现转接步骤
+		nextStepID, err = engine.executeTransferStep(session, step, execution)
 	case models.StepTypeHangup:
 		err = engine.executeHangupStep(session, step, execution)
 		nextStepID = "" // 结束脚本
@@ -282,7 +546,20 @@ func (engine *AIPhoneEngine) executeStep(session *ScriptSession, step *models.AI
 		nextStepID = step.Data.NextStep
 	}
 
-	// 更新步骤执行记录
+	// 更新步骤执行记录，先写日志
+	finished := sessionJournalEvent{
+		Type:        journalStepFinished,
+		At:          time.Now(),
+		SessionID:   session.SessionID,
+		StepID:      step.StepID,
+		ExecutionID: execution.ID,
+		Status:      string(models.StepStatusCompleted),
+	}
+	if err != nil {
+		finished.Status = string(models.StepStatusFailed)
+		finished.Error = err.Error()
+	}
+	engine.journal.record(finished)
 	if err != nil {
 		execution.MarkFailed(engine.db, err.Error())
 	} else {
@@ -296,12 +573,16 @@ func (engine *AIPhoneEngine) executeStep(session *ScriptSession, step *models.AI
 func (engine *AIPhoneEngine) executeCalloutStep(session *ScriptSession, step *models.AIPhoneScriptStep, execution *models.StepExecution) (string, error) {
 	data := step.Data
 
-	// 1. 播放开场白
+	// 1. 播放开场白（允许用户插话）
+	var bargeIn []int16
 	if data.Welcome != "" {
-		if err := engine.playTTSAudio(session, data.Welcome, data.SpeakerID); err != nil {
+		welcome := engine.toCallerLanguage(session, data.Welcome)
+		speech, err := engine.playTTSAudioWithBargeIn(session, welcome, data.SpeakerID)
+		if err != nil {
 			return "", fmt.Errorf("failed to play welcome message: %w", err)
 		}
-		execution.TTSText = data.Welcome
+		bargeIn = speech
+		execution.TTSText = welcome
 	}
 
 	// 2. 等待并处理用户输入
@@ -324,8 +605,9 @@ func (engine *AIPhoneEngine) executeCalloutStep(session *ScriptSession, step *mo
 			zap.Int("attempt", retryCount+1),
 			zap.Duration("timeout", currentTimeout))
 
-		// 监听用户输入
-		userText, err := engine.listenForUserInput(session, currentTimeout)
+		// 监听用户输入，插话捕获的语音只用于第一次
+		userText, err := engine.listenForUserInputWithPreroll(session, currentTimeout, bargeIn)
+		bargeIn = nil
 		if err != nil {
 			logger.Warn("Failed to get user input",
 				zap.String("call_id", session.CallID),
@@ -351,6 +633,7 @@ func (engine *AIPhoneEngine) executeCalloutStep(session *ScriptSession, step *mo
 				case 2:
 					promptText = "如果您能听到，请说话或者按任意键。"
 				}
+				promptText = engine.toCallerLanguage(session, promptText)
 
 				logger.Info("Playing retry prompt",
 					zap.String("call_id", session.CallID),
@@ -372,13 +655,22 @@ func (engine *AIPhoneEngine) executeCalloutStep(session *ScriptSession, step *mo
 			zap.String("input", userText),
 			zap.Int("attempt", retryCount+1))
 
-		// 添加用户消息到对话历史
-		session.addMessage("user", userText, step.StepID)
-		execution.UserInput = userText
+		// 添加用户消息到对话历史，翻译对话时记录译文，原文存入元数据
+		heard := engine.toBotLanguage(session, userText)
+		session.addTranslatedMessage("user", heard, userText, step.StepID)
+		execution.UserInput = heard
 		execution.ASRText = userText
 
-		// AI处理
-		aiResponse, err := engine.callAIService(session, data.Prompt)
+		// AI处理，生成期间主叫插话或按键时取消并重新监听，挂断时结束步骤
+		aiResponse, speech, err := engine.replyWithBargeIn(session, data.Prompt)
+		if errors.Is(err, errAssistantInterrupted) {
+			hasUserInput = false
+			bargeIn = speech
+			continue
+		}
+		if errors.Is(err, errSessionStopped) {
+			return data.NextStep, nil
+		}
 		if err != nil {
 			logger.Error("AI service call failed",
 				zap.String("call_id", session.CallID),
@@ -386,16 +678,20 @@ func (engine *AIPhoneEngine) executeCalloutStep(session *ScriptSession, step *mo
 			return "", fmt.Errorf("AI service failed: %w", err)
 		}
 
-		// 添加AI回复到对话历史
-		session.addMessage("assistant", aiResponse, step.StepID)
+		// 添加AI回复到对话历史，翻译对话时播放译文
+		spoken := engine.toCallerLanguage(session, aiResponse)
+		session.addTranslatedMessage("assistant", aiResponse, spoken, step.StepID)
 		execution.AIResponse = aiResponse
+		if spoken != aiResponse {
+			execution.TTSText = spoken
+		}
 
 		logger.Info("AI response generated",
 			zap.String("call_id", session.CallID),
 			zap.String("response", aiResponse))
 
 		// 播放AI回复
-		if err := engine.playTTSAudio(session, aiResponse, data.SpeakerID); err != nil {
+		if err := engine.playTTSAudio(session, spoken, data.SpeakerID); err != nil {
 			logger.Error("Failed to play AI response",
 				zap.String("call_id", session.CallID),
 				zap.Error(err))
@@ -403,10 +699,15 @@ func (engine *AIPhoneEngine) executeCalloutStep(session *ScriptSession, step *mo
 		}
 
 		// 更新数据库会话记录
-		session.DBSession.Conversation = models.ConversationHistory(session.Conversation)
-		session.DBSession.Context = models.SessionContext(session.Context)
+		session.DBSession.Conversation = models.ConversationHistory(session.conversation())
+		session.DBSession.Context = models.SessionContext(session.Context.Snapshot())
 		models.UpdateAIPhoneSession(engine.db, session.DBSession)
 
+		// 对话服务请求挂断或转接
+		if action := session.takeAssistantAction(); action != nil && engine.runAssistantAction(session, step, execution, action) {
+			return "", nil
+		}
+
 		// 检查是否需要结束对话
 		if engine.shouldEndConversation(aiResponse) {
 			logger.Info("Conversation ended by AI response",
@@ -423,11 +724,11 @@ func (engine *AIPhoneEngine) executeCalloutStep(session *ScriptSession, step *mo
 			zap.Int("total_attempts", retryCount))
 
 		// 设置上下文标记，表示用户没有回应
-		session.Context["no_user_response"] = true
-		session.Context["retry_count"] = retryCount
+		session.Context.Set("no_user_response", true)
+		session.Context.Set("retry_count", retryCount)
 	} else {
 		// 清除之前的无回应标记
-		delete(session.Context, "no_user_response")
+		session.Context.Delete("no_user_response")
 	}
 
 	return data.NextStep, nil
@@ -437,6 +738,14 @@ func (engine *AIPhoneEngine) executeCalloutStep(session *ScriptSession, step *mo
 func (engine *AIPhoneEngine) executePlayAudioStep(session *ScriptSession, step *models.AIPhoneScriptStep, execution *models.StepExecution) (string, error) {
 	data := step.Data
 
+	// 优先播放录制好的提示音文件；文字会话有对应文字时发送文字，没有时跳过
+	if data.AudioFile != "" && (session.text == nil || data.AudioText == "" && data.Welcome == "") {
+		if err := engine.playAudioFile(session, data.AudioFile); err != nil {
+			return "", fmt.Errorf("failed to play audio file: %w", err)
+		}
+		return data.NextStep, nil
+	}
+
 	var audioText string
 	if data.AudioText != "" {
 		audioText = data.AudioText
@@ -497,8 +806,14 @@ func (engine *AIPhoneEngine) executeWaitStep(session *ScriptSession, step *model
 		zap.String("call_id", session.CallID),
 		zap.Duration("duration", waitTime))
 
+	// 步骤配置了等待音乐时在等待期间播放
+	if data.HoldAudioFile != "" || data.HoldText != "" {
+		hold := engine.startHold(session, data.HoldAudioFile, data.HoldText, data.SpeakerID)
+		defer hold.Stop()
+	}
+
 	select {
-	case <-time.After(waitTime):
+	case <-engine.getClock().After(waitTime):
 		// 等待完成
 	case <-session.StopChan:
 		return "", fmt.Errorf("session stopped during wait")
@@ -511,11 +826,14 @@ func (engine *AIPhoneEngine) executeWaitStep(session *ScriptSession, step *model
 func (engine *AIPhoneEngine) executeCollectStep(session *ScriptSession, step *models.AIPhoneScriptStep, execution *models.StepExecution) (string, error) {
 	data := step.Data
 
-	// 播放提示语
+	// 播放提示语（允许用户插话）
+	var bargeIn []int16
 	if data.Welcome != "" {
-		if err := engine.playTTSAudio(session, data.Welcome, data.SpeakerID); err != nil {
+		speech, err := engine.playTTSAudioWithBargeIn(session, data.Welcome, data.SpeakerID)
+		if err != nil {
 			return "", fmt.Errorf("failed to play prompt: %w", err)
 		}
+		bargeIn = speech
 		execution.TTSText = data.Welcome
 	}
 
@@ -538,7 +856,8 @@ func (engine *AIPhoneEngine) executeCollectStep(session *ScriptSession, step *mo
 			zap.Int("attempt", retryCount+1),
 			zap.Duration("timeout", currentTimeout))
 
-		userText, err := engine.listenForUserInput(session, currentTimeout)
+		userText, err := engine.listenForUserInputWithPreroll(session, currentTimeout, bargeIn)
+		bargeIn = nil
 		if err != nil {
 			logger.Warn("Failed to collect user input",
 				zap.String("call_id", session.CallID),
@@ -556,7 +875,7 @@ func (engine *AIPhoneEngine) executeCollectStep(session *ScriptSession, step *mo
 
 			// 将输入保存到会话上下文中
 			if data.CollectKey != "" {
-				session.Context[data.CollectKey] = userText
+				session.Context.Set(data.CollectKey, userText)
 			}
 
 			logger.Info("User input collected successfully",
@@ -591,8 +910,8 @@ func (engine *AIPhoneEngine) executeCollectStep(session *ScriptSession, step *mo
 	}
 
 	// 收集失败，标记上下文
-	session.Context["collect_failed"] = true
-	session.Context["collect_retry_count"] = retryCount
+	session.Context.Set("collect_failed", true)
+	session.Context.Set("collect_retry_count", retryCount)
 
 	logger.Warn("Failed to collect user input after all attempts",
 		zap.String("call_id", session.CallID),
@@ -663,6 +982,10 @@ func (engine *AIPhoneEngine) executeDTMFStep(session *ScriptSession, step *model
 		zap.String("call_id", session.CallID),
 		zap.String("dtmf", dtmfInput))
 
+	if data.DNCDigit != "" && dtmfInput == data.DNCDigit {
+		return engine.optOutDoNotCall(session, step, execution)
+	}
+
 	// 根据DTMF选项决定下一步
 	if data.DTMFOptions != nil {
 		if nextStep, exists := data.DTMFOptions[dtmfInput]; exists {
@@ -678,23 +1001,295 @@ func (engine *AIPhoneEngine) executeDTMFStep(session *ScriptSession, step *model
 func (engine *AIPhoneEngine) executeHangupStep(session *ScriptSession, step *models.AIPhoneScriptStep, execution *models.StepExecution) error {
 	logger.Info("Hanging up call", zap.String("call_id", session.CallID))
 
-	// 这里可以发送BYE请求来主动挂断电话
-	// 目前只是标记会话结束
-	session.StopChan <- true
+	// 发送BYE并清理SIP层会话
+	if engine.server != nil {
+		engine.server.hangupCall(session.CallID)
+	}
+
+	// 标记会话结束
+	session.requestStop()
 
 	return nil
 }
 
-// cleanupSession 清理会话
+// executeTransferStep 执行转接步骤：REFER盲转、咨询转接或桥接
+func (engine *AIPhoneEngine) executeTransferStep(session *ScriptSession, step *models.AIPhoneScriptStep, execution *models.StepExecution) (string, error) {
+	data := step.Data
+
+	if data.TransferTo == "" {
+		return "", fmt.Errorf("transfer target is empty")
+	}
+	// 文字会话没有通话可转，按转接失败走脚本的失败分支
+	if session.text != nil {
+		session.Context.Set("transfer_failed", true)
+		session.Context.Set("transfer_error", "text session cannot be transferred")
+		if data.FalseNext != "" {
+			return data.FalseNext, nil
+		}
+		return data.NextStep, nil
+	}
+	if engine.server == nil {
+		return "", fmt.Errorf("sip server not available for transfer")
+	}
+
+	// 外部号码同样受拨号规则限制
+	if data.TransferType == "external" && engine.server.trunkManager != nil {
+		if err := engine.server.trunkManager.CheckDestination(0, data.TransferTo); err != nil {
+			logger.Warn("Transfer target blocked by dial policy",
+				zap.String("call_id", session.CallID),
+				zap.String("transfer_to", data.TransferTo),
+				zap.Error(err))
+			session.Context.Set("transfer_failed", true)
+			session.Context.Set("transfer_error", err.Error())
+			if data.FalseNext != "" {
+				return data.FalseNext, nil
+			}
+			return data.NextStep, nil
+		}
+	}
+
+	// 播放转接提示语
+	if data.Welcome != "" {
+		if err := engine.playTTSAudio(session, data.Welcome, data.SpeakerID); err != nil {
+			logger.Warn("Failed to play transfer prompt", zap.Error(err))
+		}
+		execution.TTSText = data.Welcome
+	}
+
+	timeout := time.Duration(data.WaitTime) * time.Millisecond
+	if timeout == 0 {
+		timeout = 30 * time.Second // 默认30秒等待转接结果
+	}
+
+	// bridge模式由我方呼出目标并桥接两条腿，其余使用REFER；咨询转接先接通目标再REFER
+	bridged := data.TransferMode == transferModeBridge
+	attended := !bridged && data.TransferType == transferTypeAttended
+	logger.Info("Transferring call",
+		zap.String("call_id", session.CallID),
+		zap.String("transfer_to", data.TransferTo),
+		zap.String("transfer_type", data.TransferType),
+		zap.Bool("bridge", bridged),
+		zap.Bool("attended", attended))
+
+	session.Context.Set("transfer_to", data.TransferTo)
+	session.Context.Set("transfer_type", data.TransferType)
+
+	// 等待转接结果期间播放等待音乐
+	hold := engine.startHold(session, data.HoldAudioFile, data.HoldText, data.SpeakerID)
+	var code int
+	var err error
+	switch {
+	case bridged:
+		err = engine.bridgeCall(session, data.TransferTo, timeout)
+	case attended:
+		code, err = engine.attendedTransferCall(session, data.TransferTo, timeout)
+	default:
+		code, err = engine.transferCall(session, data.TransferTo, timeout)
+	}
+	hold.Stop()
+	if errors.Is(err, errTransferStopped) {
+		return "", err
+	}
+	if err != nil {
+		logger.Warn("Call transfer failed",
+			zap.String("call_id", session.CallID),
+			zap.String("transfer_to", data.TransferTo),
+			zap.Error(err))
+		session.Context.Set("transfer_failed", true)
+		session.Context.Set("transfer_error", err.Error())
+		if code > 0 {
+			session.Context.Set("transfer_status", code)
+		}
+		execution.Output = fmt.Sprintf("transfer failed: %v", err)
+
+		// 转接失败时走失败分支，继续由脚本处理
+		if data.FalseNext != "" {
+			return data.FalseNext, nil
+		}
+		return data.NextStep, nil
+	}
+
+	execution.Output = fmt.Sprintf("transferred to %s", data.TransferTo)
+	if bridged {
+		execution.Output = fmt.Sprintf("bridged to %s", data.TransferTo)
+	} else {
+		session.Context.Set("transfer_status", code)
+	}
+	session.addMessage("system", fmt.Sprintf("Transferred to %s", data.TransferTo), step.StepID)
+
+	engine.markTransferred(session, execution.Output)
+
+	// REFER转接成功后由转接方挂断原通话；桥接时通话保持，AI会话退出
+	if !bridged {
+		engine.server.hangupCall(session.CallID)
+	}
+	session.requestStop()
+
+	return "", nil
+}
+
+// markTransferred 更新会话状态为已转接并落库
+func (engine *AIPhoneEngine) markTransferred(session *ScriptSession, result string) {
+	session.setStatus(models.SessionStatusTransferred)
+	session.DBSession.Status = models.SessionStatusTransferred
+	session.DBSession.Result = result
+	if err := models.UpdateAIPhoneSession(engine.db, session.DBSession); err != nil {
+		logger.Error("Failed to update transferred session", zap.Error(err))
+	}
+}
+
+// transferCall 发送REFER并等待NOTIFY上报最终结果
+func (engine *AIPhoneEngine) transferCall(session *ScriptSession, target string, timeout time.Duration) (int, error) {
+	transfer, err := engine.server.sendRefer(session.CallID, target)
+	if err != nil {
+		return 0, err
+	}
+
+	code, err := engine.server.waitTransferResult(session.stopContext(), transfer, timeout)
+	if err != nil {
+		return 0, err
+	}
+	if code >= 300 {
+		return code, fmt.Errorf("transfer target answered %d", code)
+	}
+	return code, nil
+}
+
+// attendedTransferCall 咨询转接：先接通目标，再让来电者替换咨询通话；转接没有完成时挂断咨询通话
+func (engine *AIPhoneEngine) attendedTransferCall(session *ScriptSession, target string, timeout time.Duration) (int, error) {
+	stop := session.stopContext()
+	ctx, cancel := context.WithTimeout(stop, timeout)
+	defer cancel()
+	transfer, consultID, err := engine.server.sendAttendedRefer(ctx, session.CallID, target)
+	if err != nil {
+		if stop.Err() != nil {
+			return 0, errTransferStopped
+		}
+		return 0, err
+	}
+
+	code, err := engine.server.waitTransferResult(stop, transfer, timeout)
+	if err == nil && code >= 300 {
+		err = fmt.Errorf("transfer target answered %d", code)
+	}
+	if err != nil {
+		if _, exists := engine.server.getDialog(consultID); exists {
+			engine.server.hangupCall(consultID)
+		}
+		return code, err
+	}
+	return code, nil
+}
+
+// lookupTrunk 按被叫号码查找中继，找不到时返回nil
+func (engine *AIPhoneEngine) lookupTrunk(phoneNumber string) *models.SIPTrunk {
+	if engine.db == nil || phoneNumber == "" {
+		return nil
+	}
+	trunk, err := models.GetSIPTrunkByPhoneNumber(engine.db, phoneNumber)
+	if err != nil {
+		return nil
+	}
+	return trunk
+}
+
+// newCostMeter 创建通话费用计量，使用中继费率
+func (engine *AIPhoneEngine) newCostMeter(trunk *models.SIPTrunk) *CallCostMeter {
+	meter := NewCallCostMeter(trunk, ProviderRatesFromConfig())
+	if engine.server != nil {
+		meter.usage = engine.server.usage
+	}
+	return meter
+}
+
+// callCostMeter 会话的费用计量，外呼任务使用拨号前创建的计量
+func (engine *AIPhoneEngine) callCostMeter(call scriptCall, trunk *models.SIPTrunk) *CallCostMeter {
+	meter := call.cost
+	if meter == nil {
+		meter = engine.newCostMeter(trunk)
+	}
+	// 脚本在ACK之后启动，此时通话已接通
+	meter.MarkAnswered(time.Now())
+	return meter
+}
+
+// cleanupSession 清理会话，脚本结束和Twilio挂断可能先后调用，只执行一次
 func (engine *AIPhoneEngine) cleanupSession(session *ScriptSession) {
+	session.mutex.Lock()
+	if session.closed {
+		session.mutex.Unlock()
+		return
+	}
+	session.closed = true
+	session.mutex.Unlock()
+
 	engine.mutex.Lock()
 	delete(engine.sessions, session.CallID)
 	engine.mutex.Unlock()
 
+	if session.DBSession != nil {
+		now := time.Now()
+		// 结算通话费用
+		if session.Cost != nil {
+			session.Cost.MarkEnded(now)
+			session.DBSession.EstimatedCost = session.Cost.Estimate(now)
+			logger.Info("Session cost estimated",
+				zap.String("call_id", session.CallID),
+				zap.Float64("cost", session.DBSession.EstimatedCost))
+		}
+
+		// 脚本被挂断打断时仍处于运行状态，按取消结束
+		if session.DBSession.IsRunning() {
+			session.setStatus(models.SessionStatusCancelled)
+			session.DBSession.Status = models.SessionStatusCancelled
+		}
+		if session.DBSession.EndTime == nil {
+			session.DBSession.EndTime = &now
+			session.DBSession.CalculateDuration()
+		}
+
+		// 先写日志再落库，落库后日志中的会话即可丢弃
+		engine.journal.record(sessionJournalEvent{
+			Type:      journalSessionEnded,
+			At:        *session.DBSession.EndTime,
+			SessionID: session.SessionID,
+			Status:    string(session.DBSession.Status),
+			Error:     session.DBSession.ErrorMessage,
+		})
+		if err := models.UpdateAIPhoneSession(engine.db, session.DBSession); err != nil {
+			logger.Error("Failed to save session", zap.Error(err))
+		} else {
+			engine.journal.finalize(session.SessionID)
+		}
+	}
+
+	// 关闭识别连接，停止等待音乐
+	session.mutex.Lock()
+	if session.asr != nil {
+		session.asr.Close()
+		session.asr = nil
+	}
+	hold := session.hold
+	session.hold = nil
+	session.mutex.Unlock()
+	hold.Stop()
+	session.text.close()
+
 	// 关闭通道
+	session.cancelStopContext()
 	close(session.StopChan)
 	close(session.AudioChan)
 
+	engine.recordCanaryOutcome(session)
+
+	ended := session.monitorEvent(MonitorSessionEnded)
+	ended.Status = string(session.GetStatus())
+	session.publish(ended)
+
+	if engine.summaryEnabled() {
+		go engine.summarizeSession(session)
+	}
+
 	logger.Info("Session cleaned up",
 		zap.String("call_id", session.CallID),
 		zap.String("session_id", session.SessionID))
@@ -702,36 +1297,131 @@ func (engine *AIPhoneEngine) cleanupSession(session *ScriptSession) {
 
 // addMessage 添加对话消息
 func (session *ScriptSession) addMessage(role, content, stepID string) {
-	session.mutex.Lock()
-	defer session.mutex.Unlock()
-
-	message := models.ConversationMessage{
+	session.appendMessage(models.ConversationMessage{
 		Role:      role,
 		Content:   content,
 		Timestamp: time.Now(),
 		StepID:    stepID,
-	}
+	})
+}
+
+// appendMessage 记录消息并推送到监控
+func (session *ScriptSession) appendMessage(message models.ConversationMessage) {
+	session.mutex.Lock()
 	session.Conversation = append(session.Conversation, message)
+	session.mutex.Unlock()
+
+	event := session.monitorEvent(MonitorMessage)
+	event.StepID = message.StepID
+	event.Role = message.Role
+	event.Text = message.Content
+	event.Timestamp = message.Timestamp
+	session.publish(event)
+}
+
+// conversation 返回对话历史的副本，供提示词构建、条件判断和落库使用
+func (session *ScriptSession) conversation() []models.ConversationMessage {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	return slices.Clone(session.Conversation)
+}
+
+// currentStep 返回当前步骤
+func (session *ScriptSession) currentStep() *models.AIPhoneScriptStep {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	return session.CurrentStep
+}
+
+// setCurrentStep 切换当前步骤，返回新步骤
+func (session *ScriptSession) setCurrentStep(step *models.AIPhoneScriptStep) *models.AIPhoneScriptStep {
+	session.mutex.Lock()
+	session.CurrentStep = step
+	session.mutex.Unlock()
+	if step != nil {
+		session.publish(session.monitorEvent(MonitorStepChanged))
+	}
+	return step
+}
+
+// GetStatus 返回会话状态
+func (session *ScriptSession) GetStatus() models.SessionStatus {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	return session.Status
+}
+
+func (session *ScriptSession) setStatus(status models.SessionStatus) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.Status = status
+}
+
+// requestStop 通知脚本停止，不阻塞；会话已清理时忽略。保持中的会话同时解除等待
+func (session *ScriptSession) requestStop() {
+	session.interruptAssistant(errSessionStopped)
+	session.leaveHold()
+	session.text.close()
+	session.cancelStopContext()
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	if session.closed {
+		return
+	}
+	select {
+	case session.StopChan <- true:
+	default:
+	}
+}
+
+// stopContext 会话请求停止或清理后取消的context。转接、桥接等步骤内的等待使用它，
+// 不从StopChan取走停止信号，脚本循环仍能看到
+func (session *ScriptSession) stopContext() context.Context {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.stopCtx == nil {
+		session.stopCtx, session.cancelStop = context.WithCancel(context.Background())
+		if session.stopRequested {
+			session.cancelStop()
+		}
+	}
+	return session.stopCtx
 }
 
-// markCompleted 标记会话完成
+// cancelStopContext 取消stopContext，尚未取用时记录下来
+func (session *ScriptSession) cancelStopContext() {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.stopRequested = true
+	if session.cancelStop != nil {
+		session.cancelStop()
+	}
+}
+
+// markCompleted 标记会话完成，随cleanupSession落库
 func (session *ScriptSession) markCompleted(result string) {
-	session.Status = models.SessionStatusCompleted
-	session.DBSession.MarkCompleted(nil, result) // 这里应该传入db实例
+	session.DBSession.Result = result
+	session.markEnded(models.SessionStatusCompleted)
 }
 
-// markFailed 标记会话失败
+// markFailed 标记会话失败，随cleanupSession落库
 func (session *ScriptSession) markFailed(errorMessage string) {
-	session.Status = models.SessionStatusFailed
-	session.DBSession.MarkFailed(nil, errorMessage) // 这里应该传入db实例
+	session.DBSession.ErrorMessage = errorMessage
+	session.markEnded(models.SessionStatusFailed)
 }
 
-// markTimeout 标记会话超时
+// markTimeout 标记会话超时，随cleanupSession落库
 func (session *ScriptSession) markTimeout(errorMessage string) {
-	session.Status = models.SessionStatusTimeout
-	session.DBSession.Status = models.SessionStatusTimeout
 	session.DBSession.ErrorMessage = errorMessage
-	// 更新数据库记录
+	session.markEnded(models.SessionStatusTimeout)
+}
+
+func (session *ScriptSession) markEnded(status models.SessionStatus) {
+	session.setStatus(status)
+	now := time.Now()
+	session.DBSession.Status = status
+	session.DBSession.EndTime = &now
+	session.DBSession.CalculateDuration()
 }
 
 // StopSession 停止会话
@@ -741,11 +1431,50 @@ func (engine *AIPhoneEngine) StopSession(callID string) {
 	engine.mutex.RUnlock()
 
 	if exists {
-		session.StopChan <- true
+		session.requestStop()
 		logger.Info("Session stop requested", zap.String("call_id", callID))
 	}
 }
 
+// migrateSession 会话随转接迁移到新通话，之后的音频发往新的RTP地址；会话已结束时返回false
+func (engine *AIPhoneEngine) migrateSession(oldCallID, newCallID, clientAddr string) bool {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+	session, exists := engine.sessions[oldCallID]
+	if !exists {
+		return false
+	}
+	session.mutex.Lock()
+	closed := session.closed
+	if !closed {
+		session.CallID = newCallID
+		session.ClientAddr = clientAddr
+	}
+	session.mutex.Unlock()
+	if closed {
+		return false
+	}
+	delete(engine.sessions, oldCallID)
+	engine.sessions[newCallID] = session
+	session.Context.Set("transferred_from", oldCallID)
+	logger.Info("Session moved to transferred call",
+		zap.String("call_id", oldCallID),
+		zap.String("new_call_id", newCallID),
+		zap.String("client_addr", clientAddr))
+	return true
+}
+
+// journalSessionStarted 记录会话开始
+func (engine *AIPhoneEngine) journalSessionStarted(session *ScriptSession) {
+	engine.journal.record(sessionJournalEvent{
+		Type:      journalSessionStarted,
+		At:        session.DBSession.StartTime,
+		SessionID: session.SessionID,
+		DBID:      session.DBSession.ID,
+		CallID:    session.CallID,
+	})
+}
+
 // GetSession 获取会话
 func (engine *AIPhoneEngine) GetSession(callID string) *ScriptSession {
 	engine.mutex.RLock()
@@ -774,11 +1503,12 @@ func (engine *AIPhoneEngine) CreateSession(callSid, from, to string, scriptID ui
 		ClientAddr:   from,
 		Script:       script,
 		Status:       models.SessionStatusStarting,
-		Context:      make(map[string]interface{}),
+		Context:      NewScriptContext(),
 		Conversation: make([]models.ConversationMessage, 0),
 		StopChan:     make(chan bool, 1),
 		AudioChan:    make(chan []int16, 100),
-		StartTime:    time.Now(),
+		StartTime:    engine.getClock().Now(),
+		monitor:      engine.monitor,
 	}
 
 	// 获取起始步骤
@@ -799,8 +1529,8 @@ func (engine *AIPhoneEngine) CreateSession(callSid, from, to string, scriptID ui
 		CalleeNumber:  to,
 		ClientRTPAddr: from,
 		StartTime:     time.Now(),
-		Context:       models.SessionContext(session.Context),
-		Conversation:  models.ConversationHistory(session.Conversation),
+		Context:       models.SessionContext(session.Context.Snapshot()),
+		Conversation:  models.ConversationHistory(session.conversation()),
 	}
 
 	if err := models.CreateAIPhoneSession(engine.db, dbSession); err != nil {
@@ -808,12 +1538,17 @@ func (engine *AIPhoneEngine) CreateSession(callSid, from, to string, scriptID ui
 	}
 
 	session.DBSession = dbSession
+	engine.journalSessionStarted(session)
 
 	// 保存会话
 	engine.mutex.Lock()
 	engine.sessions[callSid] = session
 	engine.mutex.Unlock()
 
+	started := session.monitorEvent(MonitorSessionStarted)
+	started.Status = string(models.SessionStatusStarting)
+	session.publish(started)
+
 	logger.Info("Twilio AI session created",
 		zap.String("call_sid", callSid),
 		zap.String("script", script.Name),
@@ -861,49 +1596,54 @@ func (engine *AIPhoneEngine) ProcessUserInput(callSid, userInput string) (string
 		return "抱歉，会话已结束。", false, fmt.Errorf("session not found: %s", callSid)
 	}
 
+	step := session.currentStep()
+	if step == nil {
+		return "抱歉，会话已结束。", false, fmt.Errorf("session has no current step: %s", callSid)
+	}
+
 	// 添加用户消息到对话历史
-	session.addMessage("user", userInput, session.CurrentStep.StepID)
+	session.addMessage("user", userInput, step.StepID)
 
 	// 根据当前步骤类型处理用户输入
-	switch session.CurrentStep.Type {
+	switch step.Type {
 	case models.StepTypeCallout:
-		return engine.processCalloutInput(session, userInput)
+		return engine.processCalloutInput(session, step, userInput)
 	case models.StepTypeCollect:
-		return engine.processCollectInput(session, userInput)
+		return engine.processCollectInput(session, step, userInput)
 	default:
 		// 默认使用AI处理
-		aiResponse, err := engine.callAIService(session, session.CurrentStep.Data.Prompt)
+		aiResponse, err := engine.callAIService(session, step.Data.Prompt)
 		if err != nil {
 			return "抱歉，系统出现错误。", false, err
 		}
 
-		session.addMessage("assistant", aiResponse, session.CurrentStep.StepID)
+		session.addMessage("assistant", aiResponse, step.StepID)
 
-		// 检查是否应该结束对话
-		shouldContinue := !engine.shouldEndConversation(aiResponse)
+		// 检查是否应该结束对话，对话服务请求挂断时同样结束
+		shouldContinue := !engine.shouldEndConversation(aiResponse) && !session.takeAssistantHangup()
 
 		return aiResponse, shouldContinue, nil
 	}
 }
 
 // processCalloutInput 处理对话步骤的用户输入
-func (engine *AIPhoneEngine) processCalloutInput(session *ScriptSession, userInput string) (string, bool, error) {
+func (engine *AIPhoneEngine) processCalloutInput(session *ScriptSession, step *models.AIPhoneScriptStep, userInput string) (string, bool, error) {
 	// 使用AI处理用户输入
-	aiResponse, err := engine.callAIService(session, session.CurrentStep.Data.Prompt)
+	aiResponse, err := engine.callAIService(session, step.Data.Prompt)
 	if err != nil {
 		return "抱歉，系统出现错误。", false, err
 	}
 
 	// 添加AI回复到对话历史
-	session.addMessage("assistant", aiResponse, session.CurrentStep.StepID)
+	session.addMessage("assistant", aiResponse, step.StepID)
 
 	// 更新数据库会话记录
-	session.DBSession.Conversation = models.ConversationHistory(session.Conversation)
-	session.DBSession.Context = models.SessionContext(session.Context)
+	session.DBSession.Conversation = models.ConversationHistory(session.conversation())
+	session.DBSession.Context = models.SessionContext(session.Context.Snapshot())
 	models.UpdateAIPhoneSession(engine.db, session.DBSession)
 
-	// 检查是否应该结束对话
-	shouldContinue := !engine.shouldEndConversation(aiResponse)
+	// 检查是否应该结束对话，对话服务请求挂断时同样结束
+	shouldContinue := !engine.shouldEndConversation(aiResponse) && !session.takeAssistantHangup()
 
 	logger.Info("AI response generated for Twilio",
 		zap.String("call_sid", session.CallID),
@@ -915,27 +1655,29 @@ func (engine *AIPhoneEngine) processCalloutInput(session *ScriptSession, userInp
 }
 
 // processCollectInput 处理收集步骤的用户输入
-func (engine *AIPhoneEngine) processCollectInput(session *ScriptSession, userInput string) (string, bool, error) {
+func (engine *AIPhoneEngine) processCollectInput(session *ScriptSession, step *models.AIPhoneScriptStep, userInput string) (string, bool, error) {
 	// 将输入保存到会话上下文中
-	if session.CurrentStep.Data.CollectKey != "" {
-		session.Context[session.CurrentStep.Data.CollectKey] = userInput
+	if step.Data.CollectKey != "" {
+		session.Context.Set(step.Data.CollectKey, userInput)
 	}
 
 	// 移动到下一步
-	nextStepID := session.CurrentStep.Data.NextStep
-	if nextStepID != "" {
-		session.CurrentStep = session.Script.GetStepByID(nextStepID)
+	if nextStepID := step.Data.NextStep; nextStepID != "" {
+		step = session.setCurrentStep(session.Script.GetStepByID(nextStepID))
 	}
 
 	// 返回确认消息
 	response := "好的，我已经记录了您的信息。"
-	if session.CurrentStep != nil && session.CurrentStep.Data.Welcome != "" {
-		response = session.CurrentStep.Data.Welcome
+	if step == nil {
+		return response, false, nil
+	}
+	if step.Data.Welcome != "" {
+		response = step.Data.Welcome
 	}
 
-	session.addMessage("assistant", response, session.CurrentStep.StepID)
+	session.addMessage("assistant", response, step.StepID)
 
-	return response, session.CurrentStep != nil, nil
+	return response, true, nil
 }
 
 // EndSession 结束会话
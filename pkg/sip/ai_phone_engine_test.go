@@ -0,0 +1,412 @@
+package sip1
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/synthesizer"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testWelcome        = "您好，这里是客服中心"
+	testRecognizedText = "我想查询订单"
+	testRetryPrompt    = "您好，请问您能听到我说话吗？如果能听到请回应一下。"
+)
+
+// fakeRecognizer 对任意音频返回固定文本并记录调用次数
+type fakeRecognizer struct {
+	mutex sync.Mutex
+	calls int
+	text  string
+}
+
+func (r *fakeRecognizer) Recognize(ctx context.Context, audio []int16, sampleRate int) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.calls++
+	return r.text, nil
+}
+
+func (r *fakeRecognizer) Calls() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.calls
+}
+
+// fakeSynthesizer 为每段文本合成100ms静音，onText在合成前调用
+type fakeSynthesizer struct {
+	mutex  sync.Mutex
+	texts  []string
+	onText func(text string)
+}
+
+func (s *fakeSynthesizer) Synthesize(ctx context.Context, handler synthesizer.SynthesisHandler, text string, sampleRate int) error {
+	s.mutex.Lock()
+	s.texts = append(s.texts, text)
+	onText := s.onText
+	s.mutex.Unlock()
+	if onText != nil {
+		onText(text)
+	}
+	handler.OnMessage(make([]byte, sampleRate/10*2))
+	return nil
+}
+
+func (s *fakeSynthesizer) Texts() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.texts...)
+}
+
+// fakeAssistant 返回固定回复
+type fakeAssistant struct {
+	reply string
+}
+
+func (a *fakeAssistant) Query(text string) (string, error) { return a.reply, nil }
+func (a *fakeAssistant) Reset()                            {}
+
+// engineHarness 使用假时钟、内存RTP连接和内存数据库的引擎
+type engineHarness struct {
+	engine      *AIPhoneEngine
+	clock       *fakeClock
+	conn        *fakeRTPConn
+	remote      *net.UDPAddr
+	recognizer  *fakeRecognizer
+	synthesizer *fakeSynthesizer
+	codec       rtpCodec
+	start       time.Time
+}
+
+func newEngineHarness(t *testing.T) *engineHarness {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.AIPhoneScript{}, &models.AIPhoneScriptStep{}, &models.AIPhoneSession{}, &models.StepExecution{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	h := &engineHarness{
+		start:       time.Date(2026, 1, 5, 10, 0, 0, 0, time.Local),
+		remote:      &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 40000},
+		recognizer:  &fakeRecognizer{text: testRecognizedText},
+		synthesizer: &fakeSynthesizer{},
+		codec:       codecPCMU,
+	}
+	h.clock = newFakeClock(h.start)
+	h.conn = newFakeRTPConn(h.remote, h.clock)
+	h.engine = NewAIPhoneEngine(nil, db, AIServices{
+		Recognizer:  h.recognizer,
+		Synthesizer: h.synthesizer,
+		Assistant:   &fakeAssistant{reply: "好的，已为您查询，再见"},
+	})
+	h.engine.SetClock(h.clock)
+	h.engine.SetRTPConn(h.conn)
+	return h
+}
+
+// startScript 开始执行脚本，返回会话
+func (h *engineHarness) startScript(t *testing.T, script *models.AIPhoneScript) *ScriptSession {
+	t.Helper()
+	if err := h.engine.db.Create(script).Error; err != nil {
+		t.Fatalf("create script: %v", err)
+	}
+	session, err := h.engine.startScript(scriptCall{
+		callID:       "call-" + script.Name,
+		clientAddr:   h.remote.String(),
+		phoneNumber:  "4001",
+		callerNumber: "13800138000",
+		codec:        h.codec,
+		script:       script,
+	})
+	if err != nil {
+		t.Fatalf("start script: %v", err)
+	}
+	return session
+}
+
+// waitEnded 等待会话清理完成
+func waitEnded(t *testing.T, session *ScriptSession) {
+	t.Helper()
+	session.waitClosed(10 * time.Second)
+	session.mutex.RLock()
+	closed := session.closed
+	session.mutex.RUnlock()
+	if !closed {
+		t.Fatalf("session %s did not end, status %s", session.CallID, session.GetStatus())
+	}
+}
+
+// speech 生成duration长的440Hz正弦波，VAD判为语音
+func speech(duration time.Duration) []int16 {
+	samples := make([]int16, int(duration.Seconds()*8000))
+	for i := range samples {
+		samples[i] = int16(8000 * math.Sin(float64(i)*2*math.Pi*440/8000))
+	}
+	return samples
+}
+
+func testScript(name string, maxDuration int, steps ...models.AIPhoneScriptStep) *models.AIPhoneScript {
+	for i := range steps {
+		steps[i].Enabled = true
+		steps[i].Order = i
+	}
+	return &models.AIPhoneScript{
+		Name:        name,
+		Status:      models.ScriptStatusActive,
+		StartStepID: steps[0].StepID,
+		MaxDuration: maxDuration,
+		MaxSteps:    10,
+		Steps:       steps,
+	}
+}
+
+func calloutStep(id, next string) models.AIPhoneScriptStep {
+	return models.AIPhoneScriptStep{
+		StepID: id,
+		Name:   id,
+		Type:   models.StepTypeCallout,
+		Data:   models.StepData{Welcome: testWelcome, Prompt: "回答客户问题", NextStep: next},
+	}
+}
+
+func TestExecuteScript(t *testing.T) {
+	tests := []struct {
+		name string
+		// maxDuration 脚本最长时长(ms)
+		maxDuration int
+		steps       []models.AIPhoneScriptStep
+		// setup 在开始执行前调用，可注入语音或设置合成回调
+		setup func(h *engineHarness)
+
+		status          models.SessionStatus
+		stepCount       int
+		noUserResponse  bool
+		retryCount      int
+		recognizerCalls int
+		prompts         []string // 依次合成的文本
+		minElapsed      time.Duration
+	}{
+		{
+			name:           "silence exhausts retries",
+			maxDuration:    300000,
+			steps:          []models.AIPhoneScriptStep{calloutStep("greet", "")},
+			status:         models.SessionStatusCompleted,
+			stepCount:      1,
+			noUserResponse: true,
+			retryCount:     3,
+			prompts:        []string{testWelcome, testRetryPrompt, "如果您能听到，请说话或者按任意键。"},
+			minElapsed:     3 * 8 * time.Second,
+		},
+		{
+			name:        "answer after retry prompt",
+			maxDuration: 300000,
+			steps:       []models.AIPhoneScriptStep{calloutStep("greet", "")},
+			setup: func(h *engineHarness) {
+				h.synthesizer.onText = func(text string) {
+					if text == testRetryPrompt {
+						h.conn.InjectPCM(codecPCMU, speech(3*time.Second))
+					}
+				}
+			},
+			status:          models.SessionStatusCompleted,
+			stepCount:       1,
+			recognizerCalls: 1,
+			prompts:         []string{testWelcome, testRetryPrompt, "好的，已为您查询，再见"},
+			minElapsed:      8 * time.Second,
+		},
+		{
+			name:        "barge-in during welcome",
+			maxDuration: 300000,
+			steps:       []models.AIPhoneScriptStep{calloutStep("greet", "")},
+			setup: func(h *engineHarness) {
+				h.conn.InjectPCM(codecPCMU, speech(3*time.Second))
+			},
+			status:          models.SessionStatusCompleted,
+			stepCount:       1,
+			recognizerCalls: 1,
+			prompts:         []string{testWelcome, "好的，已为您查询，再见"},
+		},
+		{
+			name:        "script timeout after silent step",
+			maxDuration: 10000,
+			steps: []models.AIPhoneScriptStep{
+				calloutStep("greet", "bye"),
+				{StepID: "bye", Name: "bye", Type: models.StepTypePlayAudio, Data: models.StepData{AudioText: "再见"}},
+			},
+			status:         models.SessionStatusTimeout,
+			stepCount:      1,
+			noUserResponse: true,
+			retryCount:     3,
+			prompts:        []string{testWelcome, testRetryPrompt, "如果您能听到，请说话或者按任意键。"},
+			minElapsed:     10 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newEngineHarness(t)
+			if tt.setup != nil {
+				tt.setup(h)
+			}
+			session := h.startScript(t, testScript(strings.ReplaceAll(tt.name, " ", "-"), tt.maxDuration, tt.steps...))
+			waitEnded(t, session)
+
+			if status := session.GetStatus(); status != tt.status {
+				t.Errorf("status = %s, want %s", status, tt.status)
+			}
+			if session.StepCount != tt.stepCount {
+				t.Errorf("step count = %d, want %d", session.StepCount, tt.stepCount)
+			}
+			noResponse, _ := session.Context.Get("no_user_response")
+			if (noResponse == true) != tt.noUserResponse {
+				t.Errorf("no_user_response = %v, want %v", noResponse, tt.noUserResponse)
+			}
+			if tt.retryCount > 0 {
+				if retries, _ := session.Context.GetString("retry_count"); retries != strconv.Itoa(tt.retryCount) {
+					t.Errorf("retry_count = %v, want %d", retries, tt.retryCount)
+				}
+			}
+			if calls := h.recognizer.Calls(); calls != tt.recognizerCalls {
+				t.Errorf("recognizer calls = %d, want %d", calls, tt.recognizerCalls)
+			}
+			if texts := h.synthesizer.Texts(); strings.Join(texts, "|") != strings.Join(tt.prompts, "|") {
+				t.Errorf("synthesized %q, want %q", texts, tt.prompts)
+			}
+			if elapsed := h.clock.Now().Sub(h.start); elapsed < tt.minElapsed {
+				t.Errorf("fake time advanced %v, want at least %v", elapsed, tt.minElapsed)
+			}
+
+			if tt.recognizerCalls > 0 {
+				conversation := session.conversation()
+				var heard bool
+				for _, message := range conversation {
+					if message.Role == "user" && message.Content == testRecognizedText {
+						heard = true
+					}
+				}
+				if !heard {
+					t.Errorf("conversation %v does not contain the recognized text", conversation)
+				}
+			}
+			if len(h.conn.Sent()) == 0 {
+				t.Error("no RTP sent to the caller")
+			}
+		})
+	}
+}
+
+// TestScriptSessionConcurrentAccess 在-race下运行：对话轮次进行中，按键回调、挂断和监控读取并发访问同一会话
+func TestScriptSessionConcurrentAccess(t *testing.T) {
+	const telephoneEvent = 101
+	h := newEngineHarness(t)
+	h.codec.TelephoneEvent = telephoneEvent
+	// 每轮开场白时主叫开口，同一步骤循环执行直到挂断
+	h.synthesizer.onText = func(text string) {
+		if text == testWelcome {
+			h.conn.InjectPCM(h.codec, speech(2*time.Second))
+		}
+	}
+	script := testScript("concurrent", 3600000, calloutStep("turn", "turn"))
+	script.MaxSteps = 1000
+	session := h.startScript(t, script)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	var digits sync.WaitGroup
+	digits.Add(1)
+	var once sync.Once
+
+	// 按键：回调在收包协程中写入上下文和对话
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		session.setDigitHandler(func(digit string) {
+			session.Context.Set("last_digit", digit)
+			session.addMessage("system", "pressed "+digit, "turn")
+			once.Do(digits.Done)
+		})
+		for i := 0; i < 200; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			h.conn.InjectDigit(telephoneEvent, uint8(i%10))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	// 读取：条件判断、提示词、监控快照和状态
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			session.conversation()
+			session.currentStep()
+			session.GetStatus()
+			session.speakerID()
+			h.engine.buildPromptWithContext(session, "回答客户问题")
+			h.engine.evaluateCondition(session, "has_job_need")
+			h.engine.monitorSnapshot()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	// 挂断：识别两轮并收到按键后，多个协程同时请求停止
+	deadline := time.Now().Add(30 * time.Second)
+	for h.recognizer.Calls() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	digits.Wait()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.engine.StopSession(session.CallID)
+			session.requestStop()
+		}()
+	}
+
+	waitEnded(t, session)
+	close(done)
+	wg.Wait()
+	// 会话已清理，再次停止不能向已关闭的通道发送
+	session.requestStop()
+
+	if calls := h.recognizer.Calls(); calls < 2 {
+		t.Fatalf("recognizer calls = %d, want at least 2 turns (status %s, conversation %v)", calls, session.GetStatus(), session.conversation())
+	}
+	if status := session.GetStatus(); status != models.SessionStatusCancelled {
+		t.Errorf("status = %s, want %s", status, models.SessionStatusCancelled)
+	}
+	if _, exists := session.Context.Get("last_digit"); !exists {
+		t.Error("digit handler did not run")
+	}
+	var turns int
+	for _, message := range session.conversation() {
+		if message.Role == "user" && message.Content == testRecognizedText {
+			turns++
+		}
+	}
+	if turns < 2 {
+		t.Errorf("conversation has %d user turns, want at least 2", turns)
+	}
+}
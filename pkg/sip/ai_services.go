@@ -0,0 +1,410 @@
+package sip1
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/config"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/media"
+	"github.com/LingByte/LingSIP/pkg/recognizer"
+	"github.com/LingByte/LingSIP/pkg/synthesizer"
+	"github.com/LingByte/LingSIP/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// Recognizer 语音识别服务，sampleRate为audio的采样率
+type Recognizer interface {
+	Recognize(ctx context.Context, audio []int16, sampleRate int) (string, error)
+}
+
+// Synthesizer 语音合成服务，按sampleRate合成16位PCM写入handler
+type Synthesizer interface {
+	Synthesize(ctx context.Context, handler synthesizer.SynthesisHandler, text string, sampleRate int) error
+}
+
+// Assistant 对话生成服务
+type Assistant interface {
+	Query(text string) (string, error)
+	Reset()
+}
+
+// Translator 机器翻译服务，from、to为语言名称，from为空时自动识别
+type Translator interface {
+	Translate(ctx context.Context, text, from, to string) (string, error)
+}
+
+// LLMService 兼容旧名称
+type LLMService = Assistant
+
+// AIServices 引擎依赖的服务，未设置的识别和合成服务按全局配置创建
+type AIServices struct {
+	Recognizer  Recognizer
+	Synthesizer Synthesizer
+	Assistant   Assistant
+	Translator  Translator // 未设置时由对话服务翻译
+}
+
+// withDefaults 补齐未注入的服务，按全局配置创建的服务在主服务商额度用完时切换到usage中的备用服务商
+func (s AIServices) withDefaults(usage *providerUsage) AIServices {
+	if s.Recognizer == nil {
+		recognizer := newConfigRecognizer()
+		recognizer.usage = usage
+		s.Recognizer = recognizer
+	}
+	if s.Synthesizer == nil {
+		synth := newConfigSynthesizer()
+		synth.usage = usage
+		s.Synthesizer = synth
+	}
+	return s
+}
+
+// configSynthesizer 按全局TTS配置合成，每个采样率复用一个服务实例，切换服务商后重新创建
+type configSynthesizer struct {
+	mutex    sync.Mutex
+	services map[int]synthesizer.SynthesisService
+	provider string
+	usage    *providerUsage
+}
+
+func newConfigSynthesizer() *configSynthesizer {
+	return &configSynthesizer{services: make(map[int]synthesizer.SynthesisService)}
+}
+
+func (s *configSynthesizer) Synthesize(ctx context.Context, handler synthesizer.SynthesisHandler, text string, sampleRate int) error {
+	if config.GlobalConfig == nil {
+		return fmt.Errorf("TTS not configured: config not loaded")
+	}
+	ttsConfig := s.usage.ttsConfig(config.GlobalConfig.Services.TTS)
+
+	s.mutex.Lock()
+	if ttsConfig.Provider != s.provider {
+		s.services = make(map[int]synthesizer.SynthesisService)
+		s.provider = ttsConfig.Provider
+	}
+	service, exists := s.services[sampleRate]
+	if !exists {
+		var err error
+		if service, err = newTTSService(ttsConfig, sampleRate); err != nil {
+			s.mutex.Unlock()
+			return err
+		}
+		s.services[sampleRate] = service
+	}
+	s.mutex.Unlock()
+
+	return service.Synthesize(ctx, handler, text)
+}
+
+// reset 丢弃缓存的服务实例，之后的合成按新的TTS配置创建，进行中的合成不受影响
+func (s *configSynthesizer) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.services = make(map[int]synthesizer.SynthesisService)
+}
+
+// TTSProviders newTTSService支持的TTS服务商，其他取值按腾讯云处理
+var TTSProviders = []string{"qcloud", "baidu", "aws"}
+
+// streamingTTS 是否边合成边播放，未加载全局配置时整句合成
+func streamingTTS() bool {
+	return config.GlobalConfig != nil && config.GlobalConfig.Services.TTS.Streaming
+}
+
+// newTTSService 根据TTS配置创建TTS服务，sampleRate>0时按通话编码的采样率合成
+func newTTSService(ttsConfig config.TTSConfig, sampleRate int) (synthesizer.SynthesisService, error) {
+	// 创建TTS配置
+	var ttsCredentialConfig synthesizer.TTSCredentialConfig
+
+	switch ttsConfig.Provider {
+	case "qcloud", "tencent":
+		ttsCredentialConfig = synthesizer.TTSCredentialConfig{
+			"provider":   "tencent",
+			"appId":      ttsConfig.AppID,
+			"secretId":   ttsConfig.SecretID,
+			"secretKey":  ttsConfig.SecretKey,
+			"voiceType":  ttsConfig.VoiceType,
+			"sampleRate": ttsConfig.SampleRate,
+			"codec":      ttsConfig.Codec,
+		}
+	case "baidu":
+		ttsCredentialConfig = synthesizer.TTSCredentialConfig{
+			"provider":   "baidu",
+			"appId":      ttsConfig.AppID,
+			"apiKey":     ttsConfig.SecretID,
+			"secretKey":  ttsConfig.SecretKey,
+			"voiceType":  ttsConfig.VoiceType,
+			"sampleRate": ttsConfig.SampleRate,
+			"codec":      ttsConfig.Codec,
+		}
+	case "aws":
+		ttsCredentialConfig = synthesizer.TTSCredentialConfig{
+			"provider":     "aws",
+			"accessKey":    ttsConfig.SecretID,
+			"secretKey":    ttsConfig.SecretKey,
+			"region":       ttsConfig.Region,
+			"voiceId":      ttsConfig.VoiceType,
+			"outputFormat": ttsConfig.Codec,
+			"sampleRate":   ttsConfig.SampleRate,
+		}
+	default:
+		// 默认使用腾讯云配置（向后兼容）
+		ttsCredentialConfig = synthesizer.TTSCredentialConfig{
+			"provider":   "tencent",
+			"appId":      utils.GetEnv("TTS_APP_ID"),
+			"secretId":   utils.GetEnv("TTS_SECRET_ID"),
+			"secretKey":  utils.GetEnv("TTS_SECRET_KEY"),
+			"voiceType":  utils.GetEnv("TTS_VOICE_TYPE"),
+			"sampleRate": utils.GetIntEnv("TTS_SAMPLE_RATE"),
+			"codec":      utils.GetEnv("TTS_CODEC"),
+		}
+
+		// 如果环境变量为空，使用默认值
+		if ttsCredentialConfig["appId"] == "" {
+			ttsCredentialConfig["appId"] = ""
+		}
+		if ttsCredentialConfig["secretId"] == "" {
+			ttsCredentialConfig["secretId"] = ""
+		}
+		if ttsCredentialConfig["secretKey"] == "" {
+			ttsCredentialConfig["secretKey"] = ""
+		}
+		if ttsCredentialConfig["voiceType"] == "" {
+			ttsCredentialConfig["voiceType"] = ""
+		}
+		if ttsCredentialConfig["sampleRate"] == int64(0) {
+			ttsCredentialConfig["sampleRate"] = 8000
+		}
+		if ttsCredentialConfig["codec"] == "" {
+			ttsCredentialConfig["codec"] = "pcm"
+		}
+	}
+
+	// 宽带通话直接合成16kHz音频，避免先合成8kHz再上采样
+	if sampleRate > 0 {
+		ttsCredentialConfig["sampleRate"] = sampleRate
+	}
+
+	// 创建TTS服务
+	ttsService, err := synthesizer.NewSynthesisServiceFromCredential(ttsCredentialConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TTS service: %w", err)
+	}
+	return ttsService, nil
+}
+
+// configRecognizer 按全局ASR配置识别
+type configRecognizer struct {
+	mutex  sync.RWMutex
+	config config.ASRConfig
+	usage  *providerUsage
+}
+
+func newConfigRecognizer() *configRecognizer {
+	r := &configRecognizer{}
+	r.reload()
+	return r
+}
+
+// reload 重新读取全局ASR配置，如轮换后的密钥，进行中的识别不受影响
+func (r *configRecognizer) reload() {
+	if config.GlobalConfig == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.config = config.GlobalConfig.Services.ASR
+}
+
+// ASRProviders newTranscriber支持的ASR服务商，其他取值按腾讯云处理
+var ASRProviders = []string{"qcloud", "google", "qiniu"}
+
+// newTranscriber 按配置创建ASR客户端，每次识别使用独立连接
+func newTranscriber(asrConfig config.ASRConfig) recognizer.TranscribeService {
+	var asr recognizer.TranscribeService
+
+	switch asrConfig.Provider {
+	case "qcloud", "tencent":
+		// 创建腾讯云ASR配置
+		qcloudConfig := recognizer.NewQcloudASROption(
+			asrConfig.AppID,
+			asrConfig.SecretID,
+			asrConfig.SecretKey,
+		)
+		qcloudConfig.ModelType = asrConfig.ModelType
+		if qcloudConfig.ModelType == "" {
+			qcloudConfig.ModelType = "8k_zh" // 默认8k中文模型，适合电话音质
+		}
+		asr = recognizer.NewQcloudASR(qcloudConfig)
+
+	case "google":
+		// 创建Google ASR配置
+		googleConfig := recognizer.GoogleASROption{
+			LanguageCode: asrConfig.Language,
+		}
+		if googleConfig.LanguageCode == "" {
+			googleConfig.LanguageCode = "zh-CN"
+		}
+		googleASR := recognizer.NewGoogleASR(googleConfig)
+		asr = &googleASR
+
+	case "qiniu":
+		// 创建七牛云ASR配置
+		qiniuConfig := recognizer.QiniuASROption{
+			APIKey: asrConfig.SecretID,
+		}
+		asr = recognizer.NewQiniuASR(qiniuConfig)
+
+	default:
+		// 默认使用腾讯云配置（向后兼容）
+		appID := utils.GetEnv("ASR_APP_ID")
+		secretID := utils.GetEnv("ASR_SECRET_ID")
+		secretKey := utils.GetEnv("ASR_SECRET_KEY")
+
+		// 如果环境变量为空，使用默认值
+		if appID == "" {
+			appID = ""
+		}
+		if secretID == "" {
+			secretID = ""
+		}
+		if secretKey == "" {
+			secretKey = ""
+		}
+
+		qcloudConfig := recognizer.NewQcloudASROption(appID, secretID, secretKey)
+		qcloudConfig.ModelType = "8k_zh" // 8k中文模型，适合电话音质
+		asr = recognizer.NewQcloudASR(qcloudConfig)
+	}
+	return asr
+}
+
+func (r *configRecognizer) Recognize(ctx context.Context, audioData []int16, sampleRate int) (string, error) {
+	r.mutex.RLock()
+	asrConfig := r.config
+	r.mutex.RUnlock()
+
+	asrConfig = asrConfigFor(ctx, r.usage.asrConfig(asrConfig), sampleRate)
+	audioData, sampleRate, err := resampleForASR(asrConfig.ModelType, audioData, sampleRate)
+	if err != nil {
+		return "", err
+	}
+
+	asr := newTranscriber(asrConfig)
+
+	// 设置结果回调
+	var result string
+	var asrError error
+	done := make(chan bool, 1)
+
+	asr.Init(
+		func(text string, isFinal bool, duration time.Duration, dialogID string) {
+			logger.Debug("ASR callback received",
+				zap.String("text", text),
+				zap.Bool("is_final", isFinal),
+				zap.Duration("duration", duration))
+
+			if text != "" {
+				result = text
+				if isFinal {
+					logger.Info("ASR recognition completed",
+						zap.String("provider", asrConfig.Provider),
+						zap.String("text", text),
+						zap.Duration("duration", duration))
+					done <- true
+				}
+			} else if isFinal {
+				// 即使没有文本，如果是最终结果也要结束
+				logger.Info("ASR recognition completed with empty result")
+				done <- true
+			}
+		},
+		func(err error, isFinal bool) {
+			logger.Error("ASR error callback",
+				zap.String("provider", asrConfig.Provider),
+				zap.Error(err),
+				zap.Bool("is_final", isFinal))
+			if isFinal {
+				asrError = err
+				done <- true
+			}
+		},
+	)
+
+	// 启动ASR连接
+	dialogID := fmt.Sprintf("dialog_%d", time.Now().UnixNano())
+	if err := asr.ConnAndReceive(dialogID); err != nil {
+		return "", fmt.Errorf("failed to connect ASR: %w", err)
+	}
+	defer asr.StopConn()
+
+	logger.Debug("Sending audio data to ASR",
+		zap.String("provider", asrConfig.Provider),
+		zap.Int("samples", len(audioData)),
+		zap.Int("duration_ms", len(audioData)*1000/sampleRate))
+
+	if err := feedASR(asr, audioData, sampleRate, asrConfig.FeedSpeed); err != nil {
+		return "", err
+	}
+
+	// 发送结束标志
+	if err := asr.SendEnd(); err != nil {
+		return "", fmt.Errorf("failed to send end signal: %w", err)
+	}
+
+	logger.Debug("Audio data sent, waiting for ASR result")
+
+	// 等待识别结果（最多等待15秒）
+	select {
+	case <-done:
+		if asrError != nil {
+			return "", asrError
+		}
+		if result == "" {
+			logger.Info("ASR returned empty result")
+			return "", nil
+		}
+		return result, nil
+	case <-time.After(15 * time.Second):
+		return "", fmt.Errorf("ASR recognition timeout")
+	case <-ctx.Done():
+		return "", fmt.Errorf("ASR recognition aborted: %w", ctx.Err())
+	}
+}
+
+// resampleForASR 重采样到ASR模型的采样率，宽带通话配合16k模型可获得更好的识别效果
+func resampleForASR(modelType string, audioData []int16, sampleRate int) ([]int16, int, error) {
+	asrRate := asrSampleRate(modelType)
+	if asrRate == sampleRate {
+		return audioData, sampleRate, nil
+	}
+	resampled, err := media.ResamplePCM(samplesToBytes(audioData), sampleRate, asrRate)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to resample audio for ASR: %w", err)
+	}
+	return bytesToSamples(resampled), asrRate, nil
+}
+
+// feedASR 分块发送已缓冲的音频（每次约100ms），speed>0时按实时速率的speed倍送音，否则不等待直接发送
+func feedASR(asr recognizer.TranscribeService, audioData []int16, sampleRate int, speed float64) error {
+	audioBytes := samplesToBytes(audioData)
+	chunkSize := sampleRate / 10 * 2
+	start := time.Now()
+	for i := 0; i < len(audioBytes); i += chunkSize {
+		end := min(i+chunkSize, len(audioBytes))
+		if err := asr.SendAudioBytes(audioBytes[i:end]); err != nil {
+			return fmt.Errorf("failed to send audio chunk: %w", err)
+		}
+
+		if speed > 0 {
+			sent := time.Duration(end/2) * time.Second / time.Duration(sampleRate)
+			if wait := time.Until(start.Add(time.Duration(float64(sent) / speed))); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+	}
+	return nil
+}
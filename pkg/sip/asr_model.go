@@ -0,0 +1,44 @@
+package sip1
+
+import (
+	"context"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/config"
+)
+
+type asrModelsKey struct{}
+
+// withASRModels 识别时按会话的脚本和中继配置选择ASR模型
+func withASRModels(ctx context.Context, selection models.ASRModelSelection) context.Context {
+	return context.WithValue(ctx, asrModelsKey{}, selection)
+}
+
+// asrModelsFrom 返回ctx中的ASR模型选择，未设置时为空
+func asrModelsFrom(ctx context.Context) models.ASRModelSelection {
+	selection, _ := ctx.Value(asrModelsKey{}).(models.ASRModelSelection)
+	return selection
+}
+
+// sessionASRModels 脚本配置的ASR模型优先，未配置的一项取中继的配置
+func sessionASRModels(script *models.AIPhoneScript, trunk *models.SIPTrunk) models.ASRModelSelection {
+	var selection models.ASRModelSelection
+	if script != nil {
+		selection = script.ASRModels
+	}
+	if trunk != nil {
+		selection = selection.Or(trunk.ASRModels)
+	}
+	return selection
+}
+
+// selectASRModel 按音频采样率选择模型：ctx中脚本或中继的配置优先，其次是全局的宽带模型和ModelType
+func selectASRModel(ctx context.Context, asrConfig config.ASRConfig, sampleRate int) string {
+	if model := asrModelsFrom(ctx).Model(sampleRate); model != "" {
+		return model
+	}
+	if sampleRate >= 16000 && asrConfig.WidebandModelType != "" {
+		return asrConfig.WidebandModelType
+	}
+	return asrConfig.ModelType
+}
@@ -0,0 +1,265 @@
+package sip1
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/config"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/recognizer"
+	"go.uber.org/zap"
+)
+
+const (
+	// asrTurnSilence 每轮语音后补发的静音，让服务端切分出完整句子
+	asrTurnSilence = 800 * time.Millisecond
+	// asrTurnSettle 识别结果保持不变这么久，视为本轮已结束
+	asrTurnSettle = 700 * time.Millisecond
+	// asrTurnTimeout 一直没有结果时改为发送结束标志强制出结果
+	asrTurnTimeout = 10 * time.Second
+	// asrFinalizeTimeout 发送结束标志后等待最终结果的时间
+	asrFinalizeTimeout = 5 * time.Second
+)
+
+// RecognizerSession 一通电话内保持连接的识别会话，每次Recognize识别一轮
+type RecognizerSession interface {
+	Recognizer
+	Close() error
+}
+
+// SessionRecognizer 支持按通话复用连接的识别服务
+type SessionRecognizer interface {
+	OpenSession(callID string) RecognizerSession
+}
+
+// OpenSession 为一通电话打开复用连接的识别会话
+func (r *configRecognizer) OpenSession(callID string) RecognizerSession {
+	return &asrSession{
+		callID: callID,
+		config: r.config,
+	}
+}
+
+type asrEvent struct {
+	text  string
+	final bool
+	err   error
+}
+
+// asrSession 多轮识别复用同一个ASR连接，连接断开或服务端结束识别后下一轮自动重连
+type asrSession struct {
+	mutex  sync.Mutex // 串行化各轮识别
+	callID string
+	config config.ASRConfig
+	// 当前连接使用的模型和语言，按首轮音频的采样率、会话的模型选择和方言确定
+	model    string
+	language string
+
+	client    recognizer.TranscribeService
+	events    chan asrEvent
+	delivered string // 会累积整通识别文本的服务已返回过的部分
+
+	Turns    int
+	Connects int
+}
+
+// connect 建立连接，回调结果写入事件通道
+func (s *asrSession) connect() error {
+	asrConfig := s.config
+	asrConfig.ModelType = s.model
+	asrConfig.Language = s.language
+	client := newTranscriber(asrConfig)
+	events := make(chan asrEvent, 64)
+	// 通道满时丢弃最旧的结果，保证句尾结果不丢
+	push := func(event asrEvent) {
+		for {
+			select {
+			case events <- event:
+				return
+			default:
+			}
+			select {
+			case <-events:
+			default:
+			}
+		}
+	}
+	client.Init(
+		func(text string, isFinal bool, duration time.Duration, dialogID string) {
+			push(asrEvent{text: text, final: isFinal})
+		},
+		func(err error, isFinal bool) {
+			logger.Error("ASR error callback",
+				zap.String("call_id", s.callID),
+				zap.String("provider", s.config.Provider),
+				zap.Error(err),
+				zap.Bool("is_final", isFinal))
+			if isFinal {
+				push(asrEvent{err: err, final: true})
+			}
+		},
+	)
+
+	dialogID := fmt.Sprintf("%s_%d", s.callID, time.Now().UnixNano())
+	if err := client.ConnAndReceive(dialogID); err != nil {
+		return fmt.Errorf("failed to connect ASR: %w", err)
+	}
+	s.client = client
+	s.events = events
+	s.delivered = ""
+	s.Connects++
+	logger.Debug("ASR session connected",
+		zap.String("call_id", s.callID),
+		zap.String("model", s.model),
+		zap.Int("connects", s.Connects))
+	return nil
+}
+
+// disconnect 关闭当前连接，下一轮重新连接
+func (s *asrSession) disconnect() {
+	if s.client == nil {
+		return
+	}
+	if err := s.client.StopConn(); err != nil {
+		logger.Debug("Failed to stop ASR connection", zap.String("call_id", s.callID), zap.Error(err))
+	}
+	s.client = nil
+	s.events = nil
+}
+
+// Recognize 识别一轮语音
+func (s *asrSession) Recognize(ctx context.Context, audioData []int16, sampleRate int) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// 模型变化时（如re-INVITE改变了编码）重新连接
+	if selected := asrConfigFor(ctx, s.config, sampleRate); selected.ModelType != s.model || selected.Language != s.language {
+		s.disconnect()
+		s.model, s.language = selected.ModelType, selected.Language
+	}
+	audioData, sampleRate, err := resampleForASR(s.model, audioData, sampleRate)
+	if err != nil {
+		return "", err
+	}
+
+	if s.client == nil || !s.client.Activity() {
+		s.disconnect()
+		if err := s.connect(); err != nil {
+			return "", err
+		}
+	}
+	s.Turns++
+
+	// 丢弃上一轮遗留的结果
+	for len(s.events) > 0 {
+		<-s.events
+	}
+
+	silence := make([]int16, sampleRate*int(asrTurnSilence/time.Millisecond)/1000)
+	if err := feedASR(s.client, slices.Concat(audioData, silence), sampleRate, s.config.FeedSpeed); err != nil {
+		s.disconnect()
+		return "", err
+	}
+
+	latest := ""
+	onPartial := partialResultsFrom(ctx)
+	settle := time.NewTimer(asrTurnSettle)
+	defer settle.Stop()
+	timeout := time.NewTimer(asrTurnTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case event := <-s.events:
+			if event.err != nil {
+				s.disconnect()
+				return "", event.err
+			}
+			if event.text != "" {
+				latest = event.text
+			}
+			if event.final {
+				// 服务端已结束本次识别，连接不能继续使用
+				s.disconnect()
+				return s.turnText(latest), nil
+			}
+			if onPartial != nil && event.text != "" {
+				onPartial(s.pendingText(latest))
+			}
+			settle.Reset(asrTurnSettle)
+		case <-settle.C:
+			if latest != "" {
+				return s.turnText(latest), nil
+			}
+			settle.Reset(asrTurnSettle)
+		case <-timeout.C:
+			return s.finalize(ctx, latest)
+		case <-ctx.Done():
+			s.disconnect()
+			return "", fmt.Errorf("ASR recognition aborted: %w", ctx.Err())
+		}
+	}
+}
+
+// finalize 发送结束标志等待最终结果，本轮之后重新连接
+func (s *asrSession) finalize(ctx context.Context, latest string) (string, error) {
+	defer s.disconnect()
+	if err := s.client.SendEnd(); err != nil {
+		return "", fmt.Errorf("failed to send end signal: %w", err)
+	}
+
+	deadline := time.After(asrFinalizeTimeout)
+	for {
+		select {
+		case event := <-s.events:
+			if event.err != nil {
+				return "", event.err
+			}
+			if event.text != "" {
+				latest = event.text
+			}
+			if event.final {
+				return s.turnText(latest), nil
+			}
+		case <-deadline:
+			if latest != "" {
+				return s.turnText(latest), nil
+			}
+			return "", fmt.Errorf("ASR recognition timeout")
+		case <-ctx.Done():
+			return "", fmt.Errorf("ASR recognition aborted: %w", ctx.Err())
+		}
+	}
+}
+
+// turnText 去掉累积型服务返回的前几轮文本
+func (s *asrSession) turnText(text string) string {
+	result := s.pendingText(text)
+	s.delivered = text
+	return result
+}
+
+// pendingText 本轮尚未确认的文本，不更新已返回部分
+func (s *asrSession) pendingText(text string) string {
+	if s.delivered != "" && strings.HasPrefix(text, s.delivered) {
+		text = text[len(s.delivered):]
+	}
+	return strings.TrimSpace(text)
+}
+
+// Close 结束识别会话
+func (s *asrSession) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	logger.Debug("ASR session closed",
+		zap.String("call_id", s.callID),
+		zap.Int("turns", s.Turns),
+		zap.Int("connects", s.Connects))
+	s.disconnect()
+	return nil
+}
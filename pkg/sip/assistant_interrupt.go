@@ -0,0 +1,88 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/LingByte/LingSIP/pkg/features"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+var (
+	// errAssistantInterrupted 对话服务生成回复期间主叫插话或按键
+	errAssistantInterrupted = errors.New("assistant reply interrupted by caller")
+	// errSessionStopped 对话服务生成回复期间会话结束（挂断、转接等）
+	errSessionStopped = errors.New("session stopped")
+)
+
+// assistantContext 为一次对话服务请求创建可取消的ctx，release在请求结束后调用
+func (session *ScriptSession) assistantContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	session.mutex.Lock()
+	session.cancelAssistant = cancel
+	session.mutex.Unlock()
+	return ctx, func() {
+		session.mutex.Lock()
+		session.cancelAssistant = nil
+		session.mutex.Unlock()
+		cancel(nil)
+	}
+}
+
+// interruptAssistant 以cause取消进行中的对话服务请求，流式生成随之停止，没有请求时不做处理
+func (session *ScriptSession) interruptAssistant(cause error) {
+	session.mutex.RLock()
+	cancel := session.cancelAssistant
+	session.mutex.RUnlock()
+	if cancel != nil {
+		cancel(cause)
+	}
+}
+
+// interruptAssistant 主叫按键（SIP INFO）时取消通话进行中的对话服务请求
+func (engine *AIPhoneEngine) interruptAssistant(callID string) {
+	if session := engine.GetSession(callID); session != nil {
+		session.interruptAssistant(errAssistantInterrupted)
+	}
+}
+
+// replyWithBargeIn 调用对话服务，开启插话时生成期间同时监听入向RTP，主叫说话或按键即取消请求，
+// 返回errAssistantInterrupted和已收到的语音，供下一轮识别作为前导
+func (engine *AIPhoneEngine) replyWithBargeIn(session *ScriptSession, prompt string) (string, []int16, error) {
+	if !features.Enabled(features.BargeIn) || session.text != nil {
+		response, err := engine.callAIService(session, prompt)
+		return response, nil, err
+	}
+	clientAddr, err := net.ResolveUDPAddr("udp", session.ClientAddr)
+	if err != nil {
+		response, err := engine.callAIService(session, prompt)
+		return response, nil, err
+	}
+
+	interrupt := make(chan struct{})
+	done := make(chan struct{})
+	captured := make(chan []int16, 1)
+	go func() {
+		captured <- engine.detectBargeIn(session, clientAddr, interrupt, done, true)
+	}()
+	go func() {
+		select {
+		case <-interrupt:
+			session.interruptAssistant(errAssistantInterrupted)
+		case <-done:
+		}
+	}()
+
+	response, err := engine.callAIService(session, prompt)
+	close(done)
+	speech := <-captured
+	if errors.Is(err, errAssistantInterrupted) {
+		logger.Info("Assistant reply interrupted by caller",
+			zap.String("call_id", session.CallID),
+			zap.Int("captured_samples", len(speech)))
+		return "", speech, err
+	}
+	return response, nil, err
+}
@@ -0,0 +1,181 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/llm"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// assistantTransferTimeout 对话服务请求转接时等待转接结果的最长时间
+const assistantTransferTimeout = 30 * time.Second
+
+// ToolAssistant 支持挂断、转接工具调用的对话服务，如llm.Service；工具调用经client上报，referCaller为通话的Call-ID，
+// ctx取消时停止生成
+type ToolAssistant interface {
+	QueryStreamContext(ctx context.Context, text string, client llm.TTSClient, referCaller string) (string, error)
+}
+
+// assistantAction 对话服务通过工具调用请求的挂断或转接，本轮回复播放完后执行
+type assistantAction struct {
+	hangup  bool
+	reason  string
+	referTo string
+}
+
+// assistantTools 实现llm.TTSClient，只记录工具调用；回复文字由引擎播放，回声抑制、打断和计费与普通回复一致
+type assistantTools struct {
+	mutex  sync.Mutex
+	action *assistantAction
+}
+
+func (t *assistantTools) set(action *assistantAction) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.action == nil {
+		t.action = action
+	}
+}
+
+func (t *assistantTools) get() *assistantAction {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.action
+}
+
+// TTS 回复播放完后挂断（autoHangup）时记录挂断请求
+func (t *assistantTools) TTS(text, voice, playID string, endOfStream, autoHangup bool, onStart, onEnd func(), interrupt bool) error {
+	if autoHangup {
+		t.set(&assistantAction{hangup: true, reason: "LLM requested hangup"})
+	}
+	return nil
+}
+
+func (t *assistantTools) StreamTTS(text, voice, playID string, endOfStream, autoHangup bool, onStart, onEnd func(), interrupt bool) error {
+	return t.TTS(text, voice, playID, endOfStream, autoHangup, onStart, onEnd, interrupt)
+}
+
+func (t *assistantTools) Hangup(reason string) error {
+	t.set(&assistantAction{hangup: true, reason: reason})
+	return nil
+}
+
+func (t *assistantTools) Refer(caller, target string, headers map[string]string) error {
+	t.set(&assistantAction{referTo: target})
+	return nil
+}
+
+// queryAssistant 调用对话服务，支持工具调用时记录挂断或转接请求到会话。ctx取消时立即返回，
+// 不支持取消的服务在后台完成后丢弃结果
+func (engine *AIPhoneEngine) queryAssistant(ctx context.Context, session *ScriptSession, assistant Assistant, prompt string) (string, error) {
+	toolAssistant, ok := assistant.(ToolAssistant)
+	if !ok {
+		type reply struct {
+			text string
+			err  error
+		}
+		replies := make(chan reply, 1)
+		go func() {
+			text, err := assistant.Query(prompt)
+			replies <- reply{text, err}
+		}()
+		select {
+		case r := <-replies:
+			return r.text, r.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	tools := &assistantTools{}
+	response, err := toolAssistant.QueryStreamContext(ctx, prompt, tools, session.CallID)
+	if action := tools.get(); action != nil {
+		session.mutex.Lock()
+		session.assistantAction = action
+		session.mutex.Unlock()
+	}
+	return response, err
+}
+
+// takeAssistantAction 取出并清除对话服务请求的挂断或转接
+func (session *ScriptSession) takeAssistantAction() *assistantAction {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	action := session.assistantAction
+	session.assistantAction = nil
+	return action
+}
+
+// takeAssistantHangup 取出对话服务的请求并判断是否为挂断，Twilio通话按返回值结束，不支持转接
+func (session *ScriptSession) takeAssistantHangup() bool {
+	action := session.takeAssistantAction()
+	return action != nil && action.hangup
+}
+
+// runAssistantAction 执行对话服务请求的挂断或转接，结束会话时返回true。挂断与挂断步骤相同；
+// 转接按步骤的TransferTo（未配置时用工具给出的目标）发送REFER，失败时写入transfer_failed并继续对话
+func (engine *AIPhoneEngine) runAssistantAction(session *ScriptSession, step *models.AIPhoneScriptStep, execution *models.StepExecution, action *assistantAction) bool {
+	if action.hangup {
+		logger.Info("Assistant requested hangup",
+			zap.String("call_id", session.CallID),
+			zap.String("reason", action.reason))
+		session.addMessage("system", fmt.Sprintf("Hangup requested by assistant: %s", action.reason), step.StepID)
+		execution.Output = fmt.Sprintf("hangup: %s", action.reason)
+		if err := engine.executeHangupStep(session, step, execution); err != nil {
+			logger.Warn("Failed to hang up for assistant", zap.String("call_id", session.CallID), zap.Error(err))
+		}
+		return true
+	}
+
+	target := step.Data.TransferTo
+	if target == "" {
+		target = action.referTo
+	}
+	fail := func(err error) bool {
+		logger.Warn("Assistant requested transfer failed",
+			zap.String("call_id", session.CallID),
+			zap.String("transfer_to", target),
+			zap.Error(err))
+		session.Context.Set("transfer_failed", true)
+		session.Context.Set("transfer_error", err.Error())
+		return false
+	}
+	switch {
+	case target == "":
+		return fail(fmt.Errorf("transfer target is empty"))
+	case session.text != nil:
+		return fail(fmt.Errorf("text session cannot be transferred"))
+	case engine.server == nil:
+		return fail(fmt.Errorf("sip server not available for transfer"))
+	}
+	if engine.server.trunkManager != nil {
+		if err := engine.server.trunkManager.CheckDestination(0, target); err != nil {
+			return fail(err)
+		}
+	}
+
+	logger.Info("Assistant requested transfer", zap.String("call_id", session.CallID), zap.String("transfer_to", target))
+	session.Context.Set("transfer_to", target)
+	code, err := engine.transferCall(session, target, assistantTransferTimeout)
+	if errors.Is(err, errTransferStopped) {
+		return true
+	}
+	if err != nil {
+		if code > 0 {
+			session.Context.Set("transfer_status", code)
+		}
+		return fail(err)
+	}
+	session.Context.Set("transfer_status", code)
+	execution.Output = fmt.Sprintf("transferred to %s", target)
+	session.addMessage("system", fmt.Sprintf("Transferred to %s", target), step.StepID)
+	engine.markTransferred(session, execution.Output)
+	engine.server.hangupCall(session.CallID)
+	session.requestStop()
+	return true
+}
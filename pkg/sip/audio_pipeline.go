@@ -0,0 +1,159 @@
+package sip1
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/config"
+	"github.com/LingByte/LingSIP/pkg/media"
+	"github.com/pion/rtp"
+)
+
+const (
+	// receiveSilenceThreshold 样本幅度超过该值才视为有声，未配置VAD时使用
+	receiveSilenceThreshold = int16(500)
+	// receiveSpeechRatio 有声样本比例超过该值的帧判为语音，未配置VAD时使用
+	receiveSpeechRatio = 0.2
+	// receiveReadWait 单次等待入向RTP的时间
+	receiveReadWait = 50 * time.Millisecond
+)
+
+// errUndecodableFrame RTP载荷无法解码，调用方跳过该包即可
+var errUndecodableFrame = errors.New("undecodable RTP payload")
+
+// receiveVAD 按全局VAD配置创建，配置热更新后从下一次收音开始生效
+func receiveVAD() media.EnergyVAD {
+	vad := media.EnergyVAD{Threshold: receiveSilenceThreshold, MinRatio: receiveSpeechRatio}
+	if config.GlobalConfig == nil {
+		return vad
+	}
+	cfg := config.GlobalConfig.Services.VAD
+	if cfg.Threshold > 0 && cfg.Threshold <= 32767 {
+		vad.Threshold = int16(cfg.Threshold)
+	}
+	if cfg.SpeechRatio > 0 && cfg.SpeechRatio <= 1 {
+		vad.MinRatio = cfg.SpeechRatio
+	}
+	return vad
+}
+
+// ReceiveStageFactory 为会话创建额外的入向音频处理阶段，filters在回声判断之后、VAD之前执行，
+// sinks在每帧处理完成后收到帧，用于接入降噪、录音分流等功能
+type ReceiveStageFactory func(session *ScriptSession) (filters []media.FrameFilter, sinks []media.FrameSink)
+
+// AddReceiveStages 注册入向音频处理阶段，每个会话首次监听时调用factory创建一次
+func (engine *AIPhoneEngine) AddReceiveStages(factory ReceiveStageFactory) {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+	engine.receiveStages = append(engine.receiveStages, factory)
+}
+
+// sessionReceiveStages 返回会话的额外处理阶段，同一会话的多次监听复用同一组有状态的阶段
+func (engine *AIPhoneEngine) sessionReceiveStages(session *ScriptSession) ([]media.FrameFilter, []media.FrameSink) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.receiveStagesReady {
+		return session.receiveFilters, session.receiveSinks
+	}
+
+	engine.mutex.RLock()
+	factories := engine.receiveStages
+	engine.mutex.RUnlock()
+	for _, factory := range factories {
+		filters, sinks := factory(session)
+		session.receiveFilters = append(session.receiveFilters, filters...)
+		session.receiveSinks = append(session.receiveSinks, sinks...)
+	}
+	session.receiveStagesReady = true
+	return session.receiveFilters, session.receiveSinks
+}
+
+// sessionInboundAGC 返回会话的入向AGC，多次监听之间保持增益
+func (engine *AIPhoneEngine) sessionInboundAGC(session *ScriptSession) *media.AGC {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.inboundAGC == nil {
+		session.inboundAGC = newInboundAGC()
+	}
+	return session.inboundAGC
+}
+
+// rtpFrameSource 从入向RTP读取并解码为PCM帧
+type rtpFrameSource struct {
+	inbound    *inboundRTP
+	decode     rtpDecoder
+	sampleRate int
+}
+
+func (s *rtpFrameSource) ReadFrame() (*media.PCMFrame, error) {
+	packet, err := s.inbound.next(receiveReadWait)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := s.decode(packet.Payload)
+	if err != nil || len(samples) == 0 {
+		return nil, errUndecodableFrame
+	}
+	return &media.PCMFrame{Samples: samples, SampleRate: s.sampleRate}, nil
+}
+
+// echoGate 标记提示音回声帧，后续VAD不把它当作语音
+func echoGate(echo *echoSuppressor) media.FrameFilter {
+	return media.FrameFilterFunc(func(frame *media.PCMFrame) (*media.PCMFrame, error) {
+		frame.Echo = echo.IsEcho(frame.Samples)
+		return frame, nil
+	})
+}
+
+// newReceivePipeline 组装入向音频流水线：RTP解码 → 回声判断 → 注册的处理阶段 → VAD → AGC → sinks
+func (engine *AIPhoneEngine) newReceivePipeline(session *ScriptSession, clientAddr *net.UDPAddr, sinks ...media.FrameSink) (*media.FramePipeline, *inboundRTP, error) {
+	// 按协商编码解码，宽带编码保留16kHz直接送入ASR
+	decode, err := newRTPDecoder(session.Codec, session.Codec.SampleRate)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 经抖动缓冲按序读取入向RTP
+	inbound := engine.newInboundRTP(engine.sessionConn(session), clientAddr, session.Codec.PayloadType)
+	inbound.accept = func(source *net.UDPAddr) bool {
+		return engine.acceptRTP(session, inbound.remote, source)
+	}
+	if onDigit := session.digitHandler(); onDigit != nil {
+		detector := newTelephoneEventDetector(session.Codec.TelephoneEvent)
+		inbound.onEvent = func(packet *rtp.Packet) {
+			for _, digit := range detector.Push(packet) {
+				onDigit(digit)
+			}
+		}
+	}
+
+	extraFilters, extraSinks := engine.sessionReceiveStages(session)
+	pipeline := media.NewFramePipeline(&rtpFrameSource{
+		inbound:    inbound,
+		decode:     decode,
+		sampleRate: session.Codec.SampleRate,
+	})
+	pipeline.Use(echoGate(session.Echo))
+	pipeline.Use(extraFilters...)
+	pipeline.Use(receiveVAD())
+	// VAD按原始电平判断，AGC只影响送入ASR和录音的音频
+	if agc := engine.sessionInboundAGC(session); agc != nil {
+		pipeline.Use(agc)
+	}
+	pipeline.To(sinks...)
+	pipeline.To(extraSinks...)
+	return pipeline, inbound, nil
+}
+
+// audioBufferSink 把非回声帧追加到会话的ASR缓冲
+func audioBufferSink(session *ScriptSession) media.FrameSink {
+	return media.FrameSinkFunc(func(frame *media.PCMFrame) error {
+		if frame.Echo {
+			return nil
+		}
+		session.mutex.Lock()
+		session.audioBuffer = append(session.audioBuffer, frame.Samples...)
+		session.mutex.Unlock()
+		return nil
+	})
+}
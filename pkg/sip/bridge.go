@@ -0,0 +1,414 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+const (
+	// bridgeReadTimeout 中继读取RTP的超时，用于定期检查桥接是否已全部结束
+	bridgeReadTimeout = time.Second
+	// transferModeBridge 转接步骤的TransferMode取该值时桥接，否则发送REFER
+	transferModeBridge = "bridge"
+)
+
+// callBridge 背靠背桥接的两条腿：呼入的来电者和我方呼出的坐席或外部号码，
+// 两条腿的媒体都在共享RTP端口上，按来源地址互相转发
+type callBridge struct {
+	callerID    string
+	agentID     string
+	target      string
+	caller      *net.UDPAddr
+	agent       *net.UDPAddr
+	callerLatch atomic.Bool
+	agentLatch  atomic.Bool
+	startedAt   time.Time
+
+	// 加入的监听腿 callID -> supervisor，有监听腿时语音经conference混音，不再直接互转
+	supervisors map[string]*bridgeSupervisor
+	conference  *bridgeConference
+}
+
+// BridgeInfo 进行中的桥接
+type BridgeInfo struct {
+	CallerCallID  string    `json:"callerCallId"`
+	AgentCallID   string    `json:"agentCallId"`
+	Target        string    `json:"target"`
+	CallerRTPAddr string    `json:"callerRtpAddr"`
+	AgentRTPAddr  string    `json:"agentRtpAddr"`
+	StartedAt     time.Time `json:"startedAt"`
+
+	Supervisors []SupervisorInfo `json:"supervisors,omitempty"`
+}
+
+func (b *callBridge) info() BridgeInfo {
+	info := BridgeInfo{
+		CallerCallID:  b.callerID,
+		AgentCallID:   b.agentID,
+		Target:        b.target,
+		CallerRTPAddr: b.caller.String(),
+		AgentRTPAddr:  b.agent.String(),
+		StartedAt:     b.startedAt,
+	}
+	for _, supervisor := range b.supervisors {
+		info.Supervisors = append(info.Supervisors, supervisor.info())
+	}
+	slices.SortFunc(info.Supervisors, func(a, b SupervisorInfo) int { return a.StartedAt.Compare(b.StartedAt) })
+	return info
+}
+
+// BridgeCall 呼叫target并与通话桥接：注册用户分叉呼叫其联系地址，其余目标直接发送INVITE，
+// 只提供来电者使用的编码，接通后两条腿的RTP原样互转，监听腿加入后改为混音（见SuperviseCall）。振铃时长由ctx控制；AI会话由调用方结束，
+// 任意一方挂断时另一方随之挂断
+func (as *SipServer) BridgeCall(ctx context.Context, callID, target string) (*BridgeInfo, error) {
+	dialog, exists := as.getDialog(callID)
+	if !exists {
+		return nil, fmt.Errorf("dialog not found: %s", callID)
+	}
+	if as.getBridge(callID) != nil {
+		return nil, fmt.Errorf("call %s is already bridged", callID)
+	}
+	callerAddr := as.callRTPPeer(callID)
+	if callerAddr == nil {
+		return nil, fmt.Errorf("call %s has no RTP address", callID)
+	}
+
+	agentID, recipient, agentAddr, err := as.dialBridgeLeg(ctx, callID, dialog, target, fmt.Sprintf("bridged with %s", callID))
+	if err != nil {
+		return nil, err
+	}
+
+	bridge := &callBridge{
+		callerID:  callID,
+		agentID:   agentID,
+		target:    recipient.String(),
+		caller:    cloneUDPAddr(callerAddr),
+		agent:     agentAddr,
+		startedAt: time.Now(),
+	}
+	// 来电者的地址AI会话已经锁定过
+	bridge.callerLatch.Store(true)
+	info := as.addBridge(bridge)
+
+	logger.Info("Call bridged",
+		zap.String("call_id", callID),
+		zap.String("agent_call_id", agentID),
+		zap.String("target", bridge.target),
+		zap.String("caller_rtp", callerAddr.String()),
+		zap.String("agent_rtp", agentAddr.String()))
+	return &info, nil
+}
+
+// dialBridgeLeg 为callID的桥接呼叫target：注册用户分叉呼叫其联系地址，其余目标直接发送INVITE，只提供callID使用的编码；
+// 接通后保存呼出记录并启动RTCP，返回新通话的callID、目标地址和RTP地址。振铃期间callID挂断时挂断新通话
+func (as *SipServer) dialBridgeLeg(ctx context.Context, callID string, dialog *CallDialog, target, notes string) (string, sip.Uri, *net.UDPAddr, error) {
+	var recipient sip.Uri
+	if err := parseURI(normalizeTransferTarget(dialog, target), &recipient); err != nil {
+		return "", recipient, nil, fmt.Errorf("invalid bridge target %q: %w", target, err)
+	}
+	// 桥接目标同样受拨号规则限制
+	if as.trunkManager != nil && recipient.User != "" {
+		if err := as.trunkManager.CheckDestination(0, recipient.User); err != nil {
+			return "", recipient, nil, err
+		}
+	}
+	if recipient.User != "" && !as.IsUserReachable(recipient.User) {
+		return "", recipient, nil, fmt.Errorf("bridge target %s is unreachable", target)
+	}
+
+	serverIP := localIPFor(recipient.Host)
+	if serverIP == "" {
+		serverIP = "127.0.0.1"
+	}
+	ref := &referral{dialog: dialog, target: recipient, serverIP: serverIP}
+	answer, err := as.inviteReferTarget(ctx, ref)
+	if err != nil {
+		return "", recipient, nil, err
+	}
+	legID := answer.Dialog.CallID
+	addr, err := referAnswerRTPAddr(answer.Response)
+	if err == nil {
+		// 振铃期间来电者可能已经挂断
+		if _, exists := as.getDialog(callID); !exists {
+			err = fmt.Errorf("call %s ended while ringing", callID)
+		}
+	}
+	if err != nil {
+		if byeErr := as.sendBye(legID); byeErr != nil {
+			logger.Warn("Failed to hang up bridged call", zap.String("call_id", legID), zap.Error(byeErr))
+		}
+		return "", recipient, nil, err
+	}
+
+	codec := as.getCallCodec(callID)
+	as.saveCallCodec(legID, codec)
+	as.saveReferredCall(ref.dialog.LocalURI, ref.serverIP, answer, addr, notes)
+	as.startRTCP(legID, addr, codec)
+	return legID, recipient, addr, nil
+}
+
+// addBridge 登记桥接的两条腿，没有中继协程时启动
+func (as *SipServer) addBridge(bridge *callBridge) BridgeInfo {
+	as.bridgeMutex.Lock()
+	defer as.bridgeMutex.Unlock()
+	as.bridges[bridge.callerID] = bridge
+	as.bridges[bridge.agentID] = bridge
+	if !as.bridgeRelaying {
+		as.bridgeRelaying = true
+		go as.relayBridges()
+	}
+	return bridge.info()
+}
+
+// relayBridges 读取共享RTP端口并在桥接的两条腿之间转发，全部桥接结束后退出
+func (as *SipServer) relayBridges() {
+	buffer := make([]byte, 1500)
+	for {
+		as.bridgeMutex.Lock()
+		if len(as.bridges) == 0 {
+			as.bridgeRelaying = false
+			as.bridgeMutex.Unlock()
+			return
+		}
+		as.bridgeMutex.Unlock()
+
+		as.media.SetReadDeadline(time.Now().Add(bridgeReadTimeout))
+		n, source, err := as.media.ReadFromUDP(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				as.bridgeMutex.Lock()
+				as.bridgeRelaying = false
+				as.bridgeMutex.Unlock()
+				return
+			}
+			continue
+		}
+
+		route := as.routeBridgedRTP(source)
+		if route.latched != nil {
+			as.setRTPPeer(route.latchedID, route.latched)
+			logger.Info("Bridged RTP peer latched to source address", zap.String("call_id", route.latchedID), zap.String("rtp_addr", route.latched.String()))
+		}
+		if route.conference != nil && route.conference.push(route.from, buffer[:n]) {
+			continue
+		}
+		if route.dest == nil {
+			continue
+		}
+		if _, err := as.media.WriteToUDP(buffer[:n], route.dest); err != nil {
+			logger.Debug("Failed to relay bridged RTP", zap.String("rtp_addr", route.dest.String()), zap.Error(err))
+		}
+	}
+}
+
+// bridgedRTPRoute 入向包所属的桥接腿和转发目标
+type bridgedRTPRoute struct {
+	from       string            // 包所属腿的callID
+	dest       *net.UDPAddr      // 直接转发的目标，监听腿的包没有
+	conference *bridgeConference // 有监听腿时语音交给混音
+	latchedID  string            // 锁定的腿和新地址，由调用方在释放锁后更新
+	latched    *net.UDPAddr
+}
+
+// routeBridgedRTP 返回入向包的路由，不属于任何桥接时为零值。先按完整地址匹配，
+// 两条腿经同一台设备（同IP不同端口）时不会串流；都不匹配时未锁定的监听腿按IP锁定，
+// 其余按matchLeg锁定对称RTP
+func (as *SipServer) routeBridgedRTP(source *net.UDPAddr) bridgedRTPRoute {
+	as.bridgeMutex.Lock()
+	defer as.bridgeMutex.Unlock()
+
+	for id, bridge := range as.bridges {
+		if id != bridge.callerID {
+			continue
+		}
+		if sameUDPAddr(source, bridge.caller) {
+			bridge.callerLatch.Store(true)
+			return bridgedRTPRoute{from: bridge.callerID, dest: cloneUDPAddr(bridge.agent), conference: bridge.conference}
+		}
+		if sameUDPAddr(source, bridge.agent) {
+			bridge.agentLatch.Store(true)
+			return bridgedRTPRoute{from: bridge.agentID, dest: cloneUDPAddr(bridge.caller), conference: bridge.conference}
+		}
+		for _, supervisor := range bridge.supervisors {
+			if sameUDPAddr(source, supervisor.addr) {
+				supervisor.latch.Store(true)
+				return bridgedRTPRoute{from: supervisor.callID, conference: bridge.conference}
+			}
+		}
+	}
+
+	for id, bridge := range as.bridges {
+		if id != bridge.callerID {
+			continue
+		}
+		for _, supervisor := range bridge.supervisors {
+			if source.IP.Equal(supervisor.addr.IP) && supervisor.latch.CompareAndSwap(false, true) {
+				*supervisor.addr = *cloneUDPAddr(source)
+				return bridgedRTPRoute{from: supervisor.callID, conference: bridge.conference, latchedID: supervisor.callID, latched: cloneUDPAddr(source)}
+			}
+		}
+	}
+
+	for id, bridge := range as.bridges {
+		if id != bridge.callerID {
+			continue
+		}
+		claimed := func(addr *net.UDPAddr) bool {
+			for _, other := range as.bridges {
+				if other != bridge && (addr.IP.Equal(other.caller.IP) || addr.IP.Equal(other.agent.IP)) {
+					return true
+				}
+			}
+			return as.aiEngine != nil && as.aiEngine.rtpPeerClaimed("", addr)
+		}
+		legID, leg, other, ok := bridge.matchLeg(source, claimed)
+		if leg == nil {
+			continue
+		}
+		route := bridgedRTPRoute{from: legID, dest: cloneUDPAddr(other), conference: bridge.conference}
+		if ok {
+			route.latchedID, route.latched = legID, cloneUDPAddr(leg)
+		}
+		return route
+	}
+	return bridgedRTPRoute{}
+}
+
+// matchLeg 没有完整匹配时判断source属于哪条腿：与一条腿同IP时属于该腿，未锁定时改到source的端口（NAT）；
+// 不属于其他通话的新地址先锁定未锁定的坐席，坐席已锁定时再锁定未锁定的来电者（呼叫注册用户时来电者
+// 没有经过AI会话锁定）。两条腿同IP时无法区分，不匹配
+func (b *callBridge) matchLeg(source *net.UDPAddr, claimed func(addr *net.UDPAddr) bool) (legID string, leg, other *net.UDPAddr, latched bool) {
+	if b.caller.IP.Equal(b.agent.IP) {
+		return "", nil, nil, false
+	}
+	var latch *atomic.Bool
+	switch {
+	case source.IP.Equal(b.caller.IP):
+		legID, leg, other, latch = b.callerID, b.caller, b.agent, &b.callerLatch
+	case source.IP.Equal(b.agent.IP) || !b.agentLatch.Load() && !claimed(source):
+		legID, leg, other, latch = b.agentID, b.agent, b.caller, &b.agentLatch
+	case !b.callerLatch.Load() && !claimed(source):
+		legID, leg, other, latch = b.callerID, b.caller, b.agent, &b.callerLatch
+	default:
+		return "", nil, nil, false
+	}
+	if latch.CompareAndSwap(false, true) {
+		*leg = *cloneUDPAddr(source)
+		latched = true
+	}
+	return legID, leg, other, latched
+}
+
+// setBridgePeer re-INVITE或对称RTP更换了桥接一条腿的RTP地址时更新转发目标，地址变化时重新锁定
+func (as *SipServer) setBridgePeer(callID string, addr *net.UDPAddr) {
+	as.bridgeMutex.Lock()
+	defer as.bridgeMutex.Unlock()
+	bridge := as.bridges[callID]
+	if bridge == nil {
+		return
+	}
+	leg, latch := bridge.caller, &bridge.callerLatch
+	if supervisor := bridge.supervisors[callID]; supervisor != nil {
+		leg, latch = supervisor.addr, &supervisor.latch
+	} else if callID == bridge.agentID {
+		leg, latch = bridge.agent, &bridge.agentLatch
+	}
+	if sameUDPAddr(leg, addr) {
+		return
+	}
+	*leg = *cloneUDPAddr(addr)
+	latch.Store(false)
+}
+
+// getBridge 返回通话所在的桥接，未桥接时为nil
+func (as *SipServer) getBridge(callID string) *callBridge {
+	as.bridgeMutex.Lock()
+	defer as.bridgeMutex.Unlock()
+	return as.bridges[callID]
+}
+
+// ListBridges 返回进行中的桥接
+func (as *SipServer) ListBridges() []BridgeInfo {
+	as.bridgeMutex.Lock()
+	defer as.bridgeMutex.Unlock()
+	bridges := make([]BridgeInfo, 0, len(as.bridges)/2)
+	for id, bridge := range as.bridges {
+		if id == bridge.callerID {
+			bridges = append(bridges, bridge.info())
+		}
+	}
+	slices.SortFunc(bridges, func(a, b BridgeInfo) int { return a.StartedAt.Compare(b.StartedAt) })
+	return bridges
+}
+
+// stopBridge 通话结束时解除桥接并挂断另一条腿和所有监听腿；监听腿挂断时只离开混音
+func (as *SipServer) stopBridge(callID string) {
+	as.bridgeMutex.Lock()
+	bridge := as.bridges[callID]
+	if bridge != nil && bridge.supervisors[callID] != nil {
+		conference := as.removeSupervisorLocked(bridge, callID)
+		as.bridgeMutex.Unlock()
+		conference.close()
+		logger.Info("Supervisor left bridged call", zap.String("call_id", bridge.callerID), zap.String("supervisor_call_id", callID))
+		return
+	}
+	var supervisors []string
+	if bridge != nil {
+		delete(as.bridges, bridge.callerID)
+		delete(as.bridges, bridge.agentID)
+		for id := range bridge.supervisors {
+			delete(as.bridges, id)
+			supervisors = append(supervisors, id)
+		}
+		bridge.conference.close()
+		bridge.conference = nil
+	}
+	as.bridgeMutex.Unlock()
+	if bridge == nil {
+		return
+	}
+
+	other := bridge.agentID
+	if callID == bridge.agentID {
+		other = bridge.callerID
+	}
+	logger.Info("Bridge ended",
+		zap.String("call_id", callID),
+		zap.String("other_call_id", other),
+		zap.Duration("duration", time.Since(bridge.startedAt)))
+	as.hangupCall(other)
+	for _, id := range supervisors {
+		as.hangupCall(id)
+	}
+}
+
+func cloneUDPAddr(addr *net.UDPAddr) *net.UDPAddr {
+	return &net.UDPAddr{IP: slices.Clone(addr.IP), Port: addr.Port, Zone: addr.Zone}
+}
+
+// bridgeCall 呼叫目标并与会话的通话桥接，振铃期间会话被停止时取消呼叫
+func (engine *AIPhoneEngine) bridgeCall(session *ScriptSession, target string, timeout time.Duration) error {
+	stop := session.stopContext()
+	ctx, cancel := context.WithTimeout(stop, timeout)
+	defer cancel()
+
+	if _, err := engine.server.BridgeCall(ctx, session.CallID, target); err != nil {
+		if stop.Err() != nil {
+			return errTransferStopped
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package sip1
+
+import (
+	"context"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/auth"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/media"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// RegisterBridgeAPIs 注册桥接接口：POST /calls/:callId/bridge {"target":"1001","timeout":30} 呼叫目标（分机、号码或SIP地址）
+// 并与通话桥接，接通后结束该通话的AI会话；GET /bridges 查看进行中的桥接。
+// 监听：POST /calls/:callId/supervisors {"target":"8001","mode":"listen","timeout":30} 呼叫监听方加入callId所在的桥接，
+// mode为listen、whisper或barge；PUT /calls/:callId/supervisors/:supervisorId {"mode":"barge"} 切换模式；
+// DELETE /calls/:callId/supervisors/:supervisorId 挂断监听方
+func RegisterBridgeAPIs(r gin.IRoutes, server *SipServer) {
+	r.POST("/calls/:callId/bridge", func(c *gin.Context) {
+		var form struct {
+			Target  string `json:"target" binding:"required"`
+			Timeout int    `json:"timeout"` // 振铃超时（秒），默认与REFER转接相同
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		timeout := referRingTimeout
+		if form.Timeout > 0 {
+			timeout = time.Duration(form.Timeout) * time.Second
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		callID := c.Param("callId")
+		bridge, err := server.BridgeCall(ctx, callID, form.Target)
+		if err != nil {
+			response.Fail(c, "failed to bridge call", err.Error())
+			return
+		}
+		if server.aiEngine != nil {
+			server.aiEngine.StopSession(callID)
+		}
+		response.Success(c, "ok", bridge)
+	})
+
+	r.GET("/bridges", func(c *gin.Context) {
+		response.Success(c, "ok", server.ListBridges())
+	})
+
+	r.POST("/calls/:callId/supervisors", func(c *gin.Context) {
+		var form struct {
+			Target  string               `json:"target" binding:"required"`
+			Mode    media.SupervisorMode `json:"mode"` // 默认listen
+			Timeout int                  `json:"timeout"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		if form.Mode == "" {
+			form.Mode = media.SupervisorListen
+		}
+		timeout := referRingTimeout
+		if form.Timeout > 0 {
+			timeout = time.Duration(form.Timeout) * time.Second
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		supervisor, err := server.SuperviseCall(ctx, c.Param("callId"), form.Target, form.Mode)
+		if err != nil {
+			response.Fail(c, "failed to supervise call", err.Error())
+			return
+		}
+		logger.Info("Bridged call supervised",
+			zap.String("call_id", c.Param("callId")),
+			zap.String("supervisor_call_id", supervisor.CallID),
+			zap.String("actor", auth.CurrentActor(c)))
+		response.Success(c, "ok", supervisor)
+	})
+
+	r.PUT("/calls/:callId/supervisors/:supervisorId", func(c *gin.Context) {
+		var form struct {
+			Mode media.SupervisorMode `json:"mode" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		supervisor, err := server.SetSupervisorMode(c.Param("callId"), c.Param("supervisorId"), form.Mode)
+		if err != nil {
+			response.Fail(c, "failed to change supervisor mode", err.Error())
+			return
+		}
+		response.Success(c, "ok", supervisor)
+	})
+
+	r.DELETE("/calls/:callId/supervisors/:supervisorId", func(c *gin.Context) {
+		if err := server.RemoveSupervisor(c.Param("callId"), c.Param("supervisorId")); err != nil {
+			response.Fail(c, "failed to remove supervisor", err.Error())
+			return
+		}
+		response.Success(c, "ok", nil)
+	})
+}
@@ -0,0 +1,359 @@
+package sip1
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/media"
+	"github.com/pion/rtp"
+	"go.uber.org/zap"
+)
+
+// conferenceFrameInterval 监听期间混音和发送的周期，与RTP打包时长一致
+const conferenceFrameInterval = 20 * time.Millisecond
+
+// bridgeSupervisor 加入桥接的监听腿，媒体与桥接的两条腿在同一共享RTP端口上
+type bridgeSupervisor struct {
+	callID    string
+	target    string
+	addr      *net.UDPAddr
+	latch     atomic.Bool
+	mode      media.SupervisorMode
+	startedAt time.Time
+}
+
+// SupervisorInfo 加入桥接的监听腿
+type SupervisorInfo struct {
+	CallID    string               `json:"callId"`
+	Target    string               `json:"target"`
+	Mode      media.SupervisorMode `json:"mode"`
+	RTPAddr   string               `json:"rtpAddr"`
+	StartedAt time.Time            `json:"startedAt"`
+}
+
+func (s *bridgeSupervisor) info() SupervisorInfo {
+	return SupervisorInfo{
+		CallID:    s.callID,
+		Target:    s.target,
+		Mode:      s.mode,
+		RTPAddr:   s.addr.String(),
+		StartedAt: s.startedAt,
+	}
+}
+
+// conferenceLeg 混音中一条腿的编解码器和发出RTP的序列号；解码只在中继协程、编码只在混音协程中使用
+type conferenceLeg struct {
+	decode    rtpDecoder
+	encode    rtpEncoder
+	ssrc      uint32
+	sequence  uint16
+	timestamp uint32
+}
+
+// bridgeConference 有监听腿时桥接的媒体：各腿的语音解码后交给ConferenceMixer，每帧按各腿能听到的声音
+// 混音后重新编码发给该腿。所有腿使用来电者的编码，按键等非语音包仍由中继直接转发
+type bridgeConference struct {
+	mixer *media.ConferenceMixer
+	codec rtpCodec
+
+	mutex sync.Mutex
+	legs  map[string]*conferenceLeg
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newBridgeConference(codec rtpCodec) *bridgeConference {
+	return &bridgeConference{
+		mixer: media.NewConferenceMixer(),
+		codec: codec,
+		legs:  make(map[string]*conferenceLeg),
+		stop:  make(chan struct{}),
+	}
+}
+
+// add 加入一条腿，监听腿以listen模式加入
+func (c *bridgeConference) add(callID string, role media.ParticipantRole) error {
+	decode, err := newRTPDecoder(c.codec, c.codec.SampleRate)
+	if err != nil {
+		return err
+	}
+	encode, err := newRTPEncoder(c.codec, c.codec.SampleRate)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.legs[callID] = &conferenceLeg{decode: decode, encode: encode, ssrc: rand.Uint32()}
+	c.mutex.Unlock()
+	c.mixer.AddParticipant(callID, role)
+	return nil
+}
+
+// remove 移除一条腿
+func (c *bridgeConference) remove(callID string) {
+	c.mixer.RemoveParticipant(callID)
+	c.mutex.Lock()
+	delete(c.legs, callID)
+	c.mutex.Unlock()
+}
+
+// push 解码一条腿的入向语音包并交给混音，不是语音的包返回false由调用方直接转发
+func (c *bridgeConference) push(callID string, data []byte) bool {
+	var packet rtp.Packet
+	if err := packet.Unmarshal(data); err != nil {
+		return true
+	}
+	if packet.PayloadType != c.codec.PayloadType {
+		return false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	leg := c.legs[callID]
+	if leg == nil {
+		return true
+	}
+	samples, err := leg.decode(packet.Payload)
+	if err != nil {
+		logger.Debug("Failed to decode bridged RTP", zap.String("call_id", callID), zap.Error(err))
+		return true
+	}
+	if err := c.mixer.Push(callID, samplesToBytes(samples)); err != nil {
+		logger.Debug("Failed to mix bridged RTP", zap.String("call_id", callID), zap.Error(err))
+	}
+	return true
+}
+
+// packet 把混音后的PCM帧编码为发给callID的RTP包，没有可发送的内容时返回nil
+func (c *bridgeConference) packet(callID string, frame []byte) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	leg := c.legs[callID]
+	if leg == nil || len(frame) == 0 {
+		return nil, nil
+	}
+	samples := bytesToSamples(frame)
+	payload, err := leg.encode(samples)
+	if err != nil || len(payload) == 0 {
+		return nil, err
+	}
+	packet := &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    c.codec.PayloadType,
+			SequenceNumber: leg.sequence,
+			Timestamp:      leg.timestamp,
+			SSRC:           leg.ssrc,
+		},
+		Payload: payload,
+	}
+	leg.sequence++
+	leg.timestamp += uint32(len(samples) * c.codec.ClockRate / c.codec.SampleRate)
+	return packet.Marshal()
+}
+
+// close 停止混音协程，nil安全
+func (c *bridgeConference) close() {
+	if c == nil {
+		return
+	}
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// SuperviseCall 呼叫target作为监听腿加入callID（桥接的任意一条腿）所在的桥接，mode为listen（只听）、
+// whisper（只有坐席能听到监听方）或barge（三方通话）。首个监听腿加入时桥接由RTP直接互转改为混音，
+// 最后一个监听腿离开后恢复互转；监听腿挂断只离开桥接，桥接结束时监听腿随之挂断
+func (as *SipServer) SuperviseCall(ctx context.Context, callID, target string, mode media.SupervisorMode) (*SupervisorInfo, error) {
+	switch mode {
+	case media.SupervisorListen, media.SupervisorWhisper, media.SupervisorBarge:
+	default:
+		return nil, fmt.Errorf("unsupported supervisor mode: %s", mode)
+	}
+	bridge := as.getBridge(callID)
+	if bridge == nil || callID != bridge.callerID && callID != bridge.agentID {
+		return nil, fmt.Errorf("call %s is not bridged", callID)
+	}
+	dialog, exists := as.getDialog(bridge.callerID)
+	if !exists {
+		return nil, fmt.Errorf("dialog not found: %s", bridge.callerID)
+	}
+
+	supervisorID, recipient, addr, err := as.dialBridgeLeg(ctx, bridge.callerID, dialog, target, fmt.Sprintf("supervising %s", bridge.callerID))
+	if err != nil {
+		return nil, err
+	}
+	supervisor := &bridgeSupervisor{
+		callID:    supervisorID,
+		target:    recipient.String(),
+		addr:      addr,
+		mode:      mode,
+		startedAt: time.Now(),
+	}
+	conference, started, err := as.addSupervisor(bridge, supervisor)
+	if err != nil {
+		as.hangupCall(supervisorID)
+		return nil, err
+	}
+	if started {
+		go as.mixBridge(bridge, conference)
+	}
+
+	logger.Info("Supervisor joined bridged call",
+		zap.String("call_id", bridge.callerID),
+		zap.String("supervisor_call_id", supervisorID),
+		zap.String("target", supervisor.target),
+		zap.String("mode", string(mode)),
+		zap.String("supervisor_rtp", addr.String()))
+	info := supervisor.info()
+	return &info, nil
+}
+
+// addSupervisor 登记监听腿，桥接还没有混音时创建，started为true时由调用方启动混音协程
+func (as *SipServer) addSupervisor(bridge *callBridge, supervisor *bridgeSupervisor) (conference *bridgeConference, started bool, err error) {
+	as.bridgeMutex.Lock()
+	defer as.bridgeMutex.Unlock()
+	if as.bridges[bridge.callerID] != bridge {
+		return nil, false, fmt.Errorf("bridge of call %s ended while ringing", bridge.callerID)
+	}
+
+	conference = bridge.conference
+	if conference == nil {
+		conference = newBridgeConference(as.getCallCodec(bridge.callerID))
+		if err := conference.add(bridge.callerID, media.RoleCaller); err != nil {
+			return nil, false, err
+		}
+		if err := conference.add(bridge.agentID, media.RoleAgent); err != nil {
+			return nil, false, err
+		}
+		started = true
+	}
+	if err := conference.add(supervisor.callID, media.RoleSupervisor); err != nil {
+		return nil, false, err
+	}
+	if err := conference.mixer.SetSupervisorMode(supervisor.callID, supervisor.mode); err != nil {
+		conference.remove(supervisor.callID)
+		return nil, false, err
+	}
+
+	bridge.conference = conference
+	if bridge.supervisors == nil {
+		bridge.supervisors = make(map[string]*bridgeSupervisor)
+	}
+	bridge.supervisors[supervisor.callID] = supervisor
+	as.bridges[supervisor.callID] = bridge
+	return conference, started, nil
+}
+
+// removeSupervisorLocked 监听腿离开桥接，最后一个离开时返回需要停止的混音；调用方持有bridgeMutex
+func (as *SipServer) removeSupervisorLocked(bridge *callBridge, supervisorID string) *bridgeConference {
+	delete(bridge.supervisors, supervisorID)
+	delete(as.bridges, supervisorID)
+	conference := bridge.conference
+	conference.remove(supervisorID)
+	if len(bridge.supervisors) > 0 {
+		return nil
+	}
+	bridge.conference = nil
+	return conference
+}
+
+// findSupervisor 返回callID所在桥接中的监听腿supervisorID，调用方持有bridgeMutex
+func (as *SipServer) findSupervisor(callID, supervisorID string) (*callBridge, *bridgeSupervisor, error) {
+	bridge := as.bridges[callID]
+	if bridge == nil || bridge.supervisors[callID] != nil {
+		return nil, nil, fmt.Errorf("call %s is not bridged", callID)
+	}
+	supervisor := bridge.supervisors[supervisorID]
+	if supervisor == nil {
+		return nil, nil, fmt.Errorf("%w: %s", media.ErrParticipantNotFound, supervisorID)
+	}
+	return bridge, supervisor, nil
+}
+
+// SetSupervisorMode 切换监听腿的模式
+func (as *SipServer) SetSupervisorMode(callID, supervisorID string, mode media.SupervisorMode) (*SupervisorInfo, error) {
+	as.bridgeMutex.Lock()
+	defer as.bridgeMutex.Unlock()
+	bridge, supervisor, err := as.findSupervisor(callID, supervisorID)
+	if err != nil {
+		return nil, err
+	}
+	if err := bridge.conference.mixer.SetSupervisorMode(supervisorID, mode); err != nil {
+		return nil, err
+	}
+	supervisor.mode = mode
+	logger.Info("Supervisor mode changed",
+		zap.String("call_id", bridge.callerID),
+		zap.String("supervisor_call_id", supervisorID),
+		zap.String("mode", string(mode)))
+	info := supervisor.info()
+	return &info, nil
+}
+
+// RemoveSupervisor 挂断监听腿，桥接的两条腿不受影响
+func (as *SipServer) RemoveSupervisor(callID, supervisorID string) error {
+	as.bridgeMutex.Lock()
+	_, _, err := as.findSupervisor(callID, supervisorID)
+	as.bridgeMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	as.hangupCall(supervisorID)
+	return nil
+}
+
+// mixBridge 每帧混音一次并发给各腿，混音停止后退出
+func (as *SipServer) mixBridge(bridge *callBridge, conference *bridgeConference) {
+	ticker := time.NewTicker(conferenceFrameInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-conference.stop:
+			return
+		case <-ticker.C:
+		}
+		as.sendConferenceFrames(bridge, conference)
+	}
+}
+
+// sendConferenceFrames 混音各腿本帧收到的语音，编码后发到各腿当前的RTP地址
+func (as *SipServer) sendConferenceFrames(bridge *callBridge, conference *bridgeConference) {
+	for callID, frame := range conference.mixer.Mix() {
+		data, err := conference.packet(callID, frame)
+		if err != nil {
+			logger.Debug("Failed to encode mixed RTP", zap.String("call_id", callID), zap.Error(err))
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		addr := as.bridgeLegAddr(bridge, callID)
+		if addr == nil {
+			continue
+		}
+		if _, err := as.media.WriteToUDP(data, addr); err != nil {
+			logger.Debug("Failed to send mixed RTP", zap.String("rtp_addr", addr.String()), zap.Error(err))
+		}
+	}
+}
+
+// bridgeLegAddr 返回桥接中一条腿当前的RTP地址
+func (as *SipServer) bridgeLegAddr(bridge *callBridge, callID string) *net.UDPAddr {
+	as.bridgeMutex.Lock()
+	defer as.bridgeMutex.Unlock()
+	switch {
+	case callID == bridge.callerID:
+		return cloneUDPAddr(bridge.caller)
+	case callID == bridge.agentID:
+		return cloneUDPAddr(bridge.agent)
+	case bridge.supervisors[callID] != nil:
+		return cloneUDPAddr(bridge.supervisors[callID].addr)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package sip1
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/media"
+	"github.com/pion/rtp"
+)
+
+// addrRecordingConn 记录发出的包和目标地址
+type addrRecordingConn struct {
+	mutex sync.Mutex
+	sent  map[string]int
+}
+
+func (c *addrRecordingConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	return 0, nil, net.ErrClosed
+}
+
+func (c *addrRecordingConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sent[addr.String()]++
+	return len(b), nil
+}
+
+func (c *addrRecordingConn) SetReadDeadline(t time.Time) error { return nil }
+
+// receivers 返回收到包的地址并清空记录
+func (c *addrRecordingConn) receivers() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	addrs := make([]string, 0, len(c.sent))
+	for addr := range c.sent {
+		addrs = append(addrs, addr)
+	}
+	slices.Sort(addrs)
+	c.sent = make(map[string]int)
+	return addrs
+}
+
+func TestBridgeSupervisorModes(t *testing.T) {
+	caller := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000}
+	agent := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 4000}
+	supervisorAddr := &net.UDPAddr{IP: net.ParseIP("10.0.0.3"), Port: 4000}
+	conn := &addrRecordingConn{sent: make(map[string]int)}
+	server := &SipServer{bridges: make(map[string]*callBridge), media: conn}
+	bridge := &callBridge{callerID: "caller", agentID: "agent", caller: cloneUDPAddr(caller), agent: cloneUDPAddr(agent), startedAt: time.Now()}
+	server.bridges["caller"], server.bridges["agent"] = bridge, bridge
+
+	conference, started, err := server.addSupervisor(bridge, &bridgeSupervisor{callID: "supervisor", addr: cloneUDPAddr(supervisorAddr), mode: media.SupervisorListen})
+	if err != nil || !started {
+		t.Fatalf("addSupervisor() started = %v, error = %v", started, err)
+	}
+
+	encode, err := newRTPEncoder(codecPCMU, codecPCMU.SampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	speech := make([]int16, codecPCMU.FrameSamples())
+	for i := range speech {
+		speech[i] = 4000
+	}
+	payload, err := encode(speech)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// speak 从source发一帧语音，经中继路由交给混音
+	speak := func(source *net.UDPAddr) {
+		t.Helper()
+		data, err := (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: codecPCMU.PayloadType}, Payload: payload}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		route := server.routeBridgedRTP(source)
+		if route.conference != conference || !route.conference.push(route.from, data) {
+			t.Fatalf("voice from %s was not mixed, route %+v", source, route)
+		}
+	}
+
+	speak(caller)
+	server.sendConferenceFrames(bridge, conference)
+	if got, want := conn.receivers(), []string{agent.String(), supervisorAddr.String()}; !slices.Equal(got, want) {
+		t.Errorf("caller heard by %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		mode media.SupervisorMode
+		want []string
+	}{
+		{media.SupervisorListen, []string{}},
+		{media.SupervisorWhisper, []string{agent.String()}},
+		{media.SupervisorBarge, []string{caller.String(), agent.String()}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			if _, err := server.SetSupervisorMode("agent", "supervisor", tt.mode); err != nil {
+				t.Fatalf("SetSupervisorMode() error = %v", err)
+			}
+			speak(supervisorAddr)
+			server.sendConferenceFrames(bridge, conference)
+			if got := conn.receivers(); !slices.Equal(got, tt.want) {
+				t.Errorf("supervisor heard by %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 按键不经过混音，仍直接转发
+	digit, _ := (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 101}, Payload: []byte{1, 0x8a, 0, 0xa0}}).Marshal()
+	if route := server.routeBridgedRTP(caller); route.conference.push(route.from, digit) || !sameUDPAddr(route.dest, agent) {
+		t.Errorf("telephone-event from caller was not relayed to the agent, route %+v", route)
+	}
+
+	// 监听方挂断后恢复直接互转
+	server.stopBridge("supervisor")
+	if route := server.routeBridgedRTP(caller); route.conference != nil || !sameUDPAddr(route.dest, agent) {
+		t.Errorf("route after supervisor left = %+v, want direct relay to the agent", route)
+	}
+	if server.getBridge("supervisor") != nil || server.getBridge("caller") != bridge {
+		t.Error("supervisor leaving changed the bridged legs")
+	}
+	select {
+	case <-conference.stop:
+	default:
+		t.Error("conference still running after the last supervisor left")
+	}
+}
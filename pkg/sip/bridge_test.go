@@ -0,0 +1,224 @@
+package sip1
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+)
+
+// newBridgeTestServer 创建RTP端口在本机的服务器，RTCP使用相邻端口
+func newBridgeTestServer(t *testing.T) *SipServer {
+	t.Helper()
+	config := ua.DefaultUAConfig()
+	config.Host = "127.0.0.1"
+	config.StorageType = ua.StorageTypeMemory
+	for attempt := 0; attempt < 10; attempt++ {
+		probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := probe.LocalAddr().(*net.UDPAddr).Port
+		probe.Close()
+		rtcp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port + 1})
+		if err != nil {
+			continue
+		}
+		rtcp.Close()
+		server, err := NewSipServer(port, 0, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(server.Close)
+		return server
+	}
+	t.Fatal("no free RTP port pair")
+	return nil
+}
+
+func listenRTPPeer(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestBridgeCallValidates(t *testing.T) {
+	server := &SipServer{dialogs: make(map[string]*CallDialog), bridges: make(map[string]*callBridge), config: ua.DefaultUAConfig()}
+	if _, err := server.BridgeCall(context.Background(), "missing", "1001"); err == nil {
+		t.Error("BridgeCall() without a dialog expected error")
+	}
+	server.dialogs["caller"] = &CallDialog{}
+	if _, err := server.BridgeCall(context.Background(), "caller", "1001"); err == nil {
+		t.Error("BridgeCall() without an RTP address expected error")
+	}
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000}
+	server.bridges["caller"] = &callBridge{callerID: "caller", agentID: "agent", caller: addr, agent: addr}
+	if _, err := server.BridgeCall(context.Background(), "caller", "1001"); err == nil {
+		t.Error("BridgeCall() on a bridged call expected error")
+	}
+}
+
+func TestBridgeRelaysRTP(t *testing.T) {
+	server := newBridgeTestServer(t)
+	mediaAddr := server.rtpConn.LocalAddr()
+	caller, agent := listenRTPPeer(t), listenRTPPeer(t)
+	bridge := &callBridge{
+		callerID:  "caller",
+		agentID:   "agent",
+		target:    "sip:1001@127.0.0.1",
+		caller:    cloneUDPAddr(caller.LocalAddr().(*net.UDPAddr)),
+		agent:     cloneUDPAddr(agent.LocalAddr().(*net.UDPAddr)),
+		startedAt: time.Now(),
+	}
+	bridge.callerLatch.Store(true)
+	info := server.addBridge(bridge)
+	if info.CallerCallID != "caller" || info.AgentCallID != "agent" {
+		t.Errorf("addBridge() = %+v", info)
+	}
+	if bridges := server.ListBridges(); len(bridges) != 1 || bridges[0].AgentRTPAddr != agent.LocalAddr().String() {
+		t.Errorf("ListBridges() = %+v", bridges)
+	}
+
+	// relay 从from发包，期望to原样收到
+	relay := func(from, to *net.UDPConn, payload []byte) {
+		t.Helper()
+		if _, err := from.WriteTo(payload, mediaAddr); err != nil {
+			t.Fatal(err)
+		}
+		to.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 1500)
+		n, err := to.Read(buf)
+		if err != nil || !bytes.Equal(buf[:n], payload) {
+			t.Fatalf("relayed %q, %v, want %q", buf[:n], err, payload)
+		}
+	}
+	relay(caller, agent, []byte("caller-1"))
+	relay(agent, caller, []byte("agent-1"))
+	relay(caller, agent, []byte("caller-2"))
+	if !bridge.agentLatch.Load() {
+		t.Error("agent leg not latched after its first packet")
+	}
+
+	// 一方挂断时解除桥接，全部桥接结束后中继协程退出
+	server.stopBridge("agent")
+	if server.getBridge("caller") != nil || server.getBridge("agent") != nil || len(server.ListBridges()) != 0 {
+		t.Error("bridge still registered after the agent hung up")
+	}
+	deadline := time.Now().Add(3 * bridgeReadTimeout)
+	for {
+		server.bridgeMutex.Lock()
+		relaying := server.bridgeRelaying
+		server.bridgeMutex.Unlock()
+		if !relaying {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("relay goroutine still running without bridges")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRouteBridgedRTP(t *testing.T) {
+	udpAddr := func(value string) *net.UDPAddr {
+		addr, err := net.ResolveUDPAddr("udp", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return addr
+	}
+	newServer := func(bridges ...*callBridge) *SipServer {
+		server := &SipServer{bridges: make(map[string]*callBridge)}
+		for _, bridge := range bridges {
+			server.bridges[bridge.callerID], server.bridges[bridge.agentID] = bridge, bridge
+		}
+		return server
+	}
+	newBridge := func(id, caller, agent string) *callBridge {
+		return &callBridge{callerID: id + "-caller", agentID: id + "-agent", caller: udpAddr(caller), agent: udpAddr(agent)}
+	}
+
+	t.Run("exact match", func(t *testing.T) {
+		server := newServer(newBridge("a", "10.0.0.1:4000", "10.0.0.2:4000"))
+		route := server.routeBridgedRTP(udpAddr("10.0.0.2:4000"))
+		if route.from != "a-agent" || route.dest.String() != "10.0.0.1:4000" || route.latched != nil {
+			t.Errorf("route = %+v", route)
+		}
+	})
+
+	t.Run("caller behind NAT latches once", func(t *testing.T) {
+		bridge := newBridge("a", "10.0.0.1:4000", "10.0.0.2:4000")
+		server := newServer(bridge)
+		route := server.routeBridgedRTP(udpAddr("10.0.0.1:5000"))
+		if route.from != "a-caller" || route.latchedID != "a-caller" || route.latched.String() != "10.0.0.1:5000" || bridge.caller.String() != "10.0.0.1:5000" {
+			t.Fatalf("route = %+v, caller %s", route, bridge.caller)
+		}
+		// 锁定后同IP的其他端口仍属于来电者，但不再改地址
+		route = server.routeBridgedRTP(udpAddr("10.0.0.1:6000"))
+		if route.from != "a-caller" || route.latched != nil || bridge.caller.String() != "10.0.0.1:5000" {
+			t.Errorf("route after latch = %+v, caller %s", route, bridge.caller)
+		}
+	})
+
+	t.Run("new address latches the agent", func(t *testing.T) {
+		bridge := newBridge("a", "10.0.0.1:4000", "192.168.1.2:4000")
+		bridge.callerLatch.Store(true)
+		server := newServer(bridge)
+		route := server.routeBridgedRTP(udpAddr("203.0.113.9:7000"))
+		if route.from != "a-agent" || route.dest.String() != "10.0.0.1:4000" || bridge.agent.String() != "203.0.113.9:7000" {
+			t.Errorf("route = %+v, agent %s", route, bridge.agent)
+		}
+		// 两条腿都已锁定，陌生地址不属于桥接
+		if route := server.routeBridgedRTP(udpAddr("198.51.100.1:7000")); route.from != "" || route.dest != nil {
+			t.Errorf("unknown source routed to %+v", route)
+		}
+	})
+
+	t.Run("address of another bridge is not latched", func(t *testing.T) {
+		bridge := newBridge("a", "10.0.0.1:4000", "192.168.1.2:4000")
+		bridge.callerLatch.Store(true)
+		other := newBridge("b", "10.0.0.5:4000", "10.0.0.6:4000")
+		other.callerLatch.Store(true)
+		other.agentLatch.Store(true)
+		server := newServer(bridge, other)
+		if route := server.routeBridgedRTP(udpAddr("10.0.0.6:9000")); route.from == "a-agent" {
+			t.Errorf("bridge a latched an address of bridge b: %+v", route)
+		}
+		if bridge.agent.String() != "192.168.1.2:4000" {
+			t.Errorf("agent address changed to %s", bridge.agent)
+		}
+	})
+
+	t.Run("legs on one host", func(t *testing.T) {
+		server := newServer(newBridge("a", "10.0.0.1:4000", "10.0.0.1:4002"))
+		if route := server.routeBridgedRTP(udpAddr("10.0.0.1:4002")); route.from != "a-agent" || route.dest.String() != "10.0.0.1:4000" {
+			t.Errorf("route = %+v", route)
+		}
+		// 同IP无法区分是哪条腿，不猜测
+		if route := server.routeBridgedRTP(udpAddr("10.0.0.1:5000")); route.dest != nil {
+			t.Errorf("ambiguous source routed to %+v", route)
+		}
+	})
+
+	t.Run("re-INVITE moves a leg", func(t *testing.T) {
+		bridge := newBridge("a", "10.0.0.1:4000", "10.0.0.2:4000")
+		bridge.agentLatch.Store(true)
+		server := newServer(bridge)
+		server.setBridgePeer("a-agent", udpAddr("10.0.0.3:4000"))
+		if bridge.agent.String() != "10.0.0.3:4000" || bridge.agentLatch.Load() {
+			t.Fatalf("agent = %s, latched %v", bridge.agent, bridge.agentLatch.Load())
+		}
+		// 新地址重新按对称RTP锁定
+		if route := server.routeBridgedRTP(udpAddr("10.0.0.3:4100")); route.latchedID != "a-agent" || bridge.agent.String() != "10.0.0.3:4100" {
+			t.Errorf("route = %+v, agent %s", route, bridge.agent)
+		}
+		server.setBridgePeer("unknown", udpAddr("10.0.0.9:4000"))
+	})
+}
@@ -0,0 +1,241 @@
+package sip1
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/config"
+)
+
+// ProviderRates ASR/TTS/LLM用量单价
+type ProviderRates struct {
+	ASRPerMinute   float64
+	TTSPer1KChars  float64
+	LLMPer1KTokens float64
+}
+
+// ProviderRatesFromConfig 从全局配置读取用量单价
+func ProviderRatesFromConfig() ProviderRates {
+	if config.GlobalConfig == nil {
+		return ProviderRates{}
+	}
+	billing := config.GlobalConfig.Services.Billing
+	return ProviderRates{
+		ASRPerMinute:   billing.ASRPerMinute,
+		TTSPer1KChars:  billing.TTSPer1KChars,
+		LLMPer1KTokens: billing.LLMPer1KTokens,
+	}
+}
+
+// CallCostMeter 单通电话的实时费用估算
+type CallCostMeter struct {
+	trunkRatePerMinute float64
+	billingIncrement   time.Duration
+	rates              ProviderRates
+
+	answerTime *time.Time
+	endTime    *time.Time
+	asrAudio   time.Duration
+	ttsChars   int
+	llmTokens  int
+
+	// usage 同时累计到服务商的月度用量，为空时不累计
+	usage *providerUsage
+
+	mutex sync.Mutex
+}
+
+// NewCallCostMeter 创建费用计量，trunk为空时不计中继费用
+func NewCallCostMeter(trunk *models.SIPTrunk, rates ProviderRates) *CallCostMeter {
+	meter := &CallCostMeter{rates: rates, billingIncrement: time.Minute}
+	if trunk != nil {
+		meter.trunkRatePerMinute = trunk.RatePerMinute
+		if trunk.BillingIncrement > 0 {
+			meter.billingIncrement = time.Duration(trunk.BillingIncrement) * time.Second
+		}
+	}
+	return meter
+}
+
+// MarkAnswered 记录接通时间，中继从接通开始计费
+func (m *CallCostMeter) MarkAnswered(t time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.answerTime = &t
+}
+
+// MarkEnded 记录结束时间
+func (m *CallCostMeter) MarkEnded(t time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.endTime = &t
+}
+
+// AddASRAudio 累计送入ASR的音频时长
+func (m *CallCostMeter) AddASRAudio(duration time.Duration) {
+	m.mutex.Lock()
+	m.asrAudio += duration
+	m.mutex.Unlock()
+	m.usage.record(models.ProviderServiceASR, duration.Minutes())
+}
+
+// AddTTSText 累计合成的文本字数
+func (m *CallCostMeter) AddTTSText(text string) {
+	chars := utf8.RuneCountInString(text)
+	m.mutex.Lock()
+	m.ttsChars += chars
+	m.mutex.Unlock()
+	m.usage.record(models.ProviderServiceTTS, float64(chars))
+}
+
+// AddLLMText 按字数粗略估算LLM token用量（中文约1字1token）
+func (m *CallCostMeter) AddLLMText(texts ...string) {
+	tokens := 0
+	for _, text := range texts {
+		tokens += utf8.RuneCountInString(text)
+	}
+	m.mutex.Lock()
+	m.llmTokens += tokens
+	m.mutex.Unlock()
+	m.usage.record(models.ProviderServiceLLM, float64(tokens))
+}
+
+// Estimate 估算截至now的费用，通话未结束时按当前时长计算
+func (m *CallCostMeter) Estimate(now time.Time) float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	cost := 0.0
+	if m.answerTime != nil && m.trunkRatePerMinute > 0 {
+		end := now
+		if m.endTime != nil {
+			end = *m.endTime
+		}
+		billed := billedDuration(end.Sub(*m.answerTime), m.billingIncrement)
+		cost += billed.Minutes() * m.trunkRatePerMinute
+	}
+	cost += m.asrAudio.Minutes() * m.rates.ASRPerMinute
+	cost += float64(m.ttsChars) / 1000 * m.rates.TTSPer1KChars
+	cost += float64(m.llmTokens) / 1000 * m.rates.LLMPer1KTokens
+	return math.Round(cost*10000) / 10000
+}
+
+// Reserve 通话持续duration的中继费用，拨号前按脚本最长时长预留预算
+func (m *CallCostMeter) Reserve(duration time.Duration) float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cost := billedDuration(duration, m.billingIncrement).Minutes() * m.trunkRatePerMinute
+	return math.Round(cost*10000) / 10000
+}
+
+// billedDuration 按计费步长向上取整
+func billedDuration(d, increment time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	if increment <= 0 {
+		return d
+	}
+	units := (d + increment - 1) / increment
+	return units * increment
+}
+
+// ErrBudgetExhausted 外呼任务预算已用完
+var ErrBudgetExhausted = errors.New("campaign budget exhausted")
+
+// CampaignBudget 外呼任务预算上限，已花费+进行中通话的费用达到上限后停止拨号；
+// 进行中的通话按拨号前预留的费用和实时估算中较大的一个计入，并发拨号不会超出预算
+type CampaignBudget struct {
+	limit    float64
+	spent    float64
+	active   map[string]*CallCostMeter
+	reserved map[string]float64
+	mutex    sync.Mutex
+}
+
+// NewCampaignBudget 创建预算，limit<=0表示不限；spent为此前已结算的费用
+func NewCampaignBudget(limit, spent float64) *CampaignBudget {
+	return &CampaignBudget{limit: limit, spent: spent, active: make(map[string]*CallCostMeter), reserved: make(map[string]float64)}
+}
+
+// Start 在拨号前调用并预留reserve，剩余预算不足以预留时返回ErrBudgetExhausted
+func (b *CampaignBudget) Start(callID string, meter *CallCostMeter, reserve float64) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !b.allowsLocked(reserve, time.Now()) {
+		return ErrBudgetExhausted
+	}
+	b.active[callID] = meter
+	b.reserved[callID] = reserve
+	return nil
+}
+
+// Finish 通话结束时释放预留并按实际估算结算费用
+func (b *CampaignBudget) Finish(callID string) float64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	meter, exists := b.active[callID]
+	if !exists {
+		return 0
+	}
+	delete(b.active, callID)
+	delete(b.reserved, callID)
+	cost := meter.Estimate(time.Now())
+	b.spent += cost
+	return cost
+}
+
+// Limit 当前的预算上限
+func (b *CampaignBudget) Limit() float64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.limit
+}
+
+// SetLimit 修改预算上限，进行中的通话和已结算费用不变
+func (b *CampaignBudget) SetLimit(limit float64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.limit = limit
+}
+
+// Allows 剩余预算是否足够再预留reserve
+func (b *CampaignBudget) Allows(reserve float64) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.allowsLocked(reserve, time.Now())
+}
+
+// Exhausted 预算是否已用完（含进行中通话）
+func (b *CampaignBudget) Exhausted() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.limit > 0 && b.totalLocked(time.Now()) >= b.limit
+}
+
+// Spent 已结算费用加进行中通话的费用
+func (b *CampaignBudget) Spent() float64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.totalLocked(time.Now())
+}
+
+func (b *CampaignBudget) allowsLocked(reserve float64, now time.Time) bool {
+	if b.limit <= 0 {
+		return true
+	}
+	total := b.totalLocked(now)
+	return total < b.limit && total+reserve <= b.limit
+}
+
+func (b *CampaignBudget) totalLocked(now time.Time) float64 {
+	total := b.spent
+	for callID, meter := range b.active {
+		total += max(meter.Estimate(now), b.reserved[callID])
+	}
+	return total
+}
@@ -0,0 +1,164 @@
+package sip1
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCallCostMeter(t *testing.T) {
+	trunk := &models.SIPTrunk{RatePerMinute: 0.1, BillingIncrement: 6}
+	meter := NewCallCostMeter(trunk, ProviderRates{ASRPerMinute: 0.2, TTSPer1KChars: 1, LLMPer1KTokens: 0.5})
+	answered := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	if got := meter.Estimate(answered.Add(time.Minute)); got != 0 {
+		t.Errorf("Estimate() before answer = %v, want 0", got)
+	}
+	meter.MarkAnswered(answered)
+	meter.AddASRAudio(30 * time.Second)
+	meter.AddTTSText("你好，请问是张三吗")
+	meter.AddLLMText("一二三四五六七八九十")
+	// 61秒按6秒步长计为66秒
+	want := 1.1*0.1 + 0.5*0.2 + 9.0/1000 + 10.0/1000*0.5
+	if got := meter.Estimate(answered.Add(61 * time.Second)); math.Abs(got-want) > 0.0001 {
+		t.Errorf("Estimate() = %v, want %v", got, want)
+	}
+	meter.MarkEnded(answered.Add(61 * time.Second))
+	if got := meter.Estimate(answered.Add(time.Hour)); math.Abs(got-want) > 0.0001 {
+		t.Errorf("Estimate() after end = %v, want %v", got, want)
+	}
+	if got := meter.Reserve(5 * time.Minute); got != 0.5 {
+		t.Errorf("Reserve(5m) = %v, want 0.5", got)
+	}
+}
+
+func TestCampaignBudgetConcurrentStart(t *testing.T) {
+	// 拨号中的通话估算为0，只有预留能阻止并发拨号超出预算
+	budget := NewCampaignBudget(10, 1)
+	trunk := &models.SIPTrunk{RatePerMinute: 1}
+	var started atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			meter := NewCallCostMeter(trunk, ProviderRates{})
+			if err := budget.Start(string(rune('a'+i)), meter, meter.Reserve(3*time.Minute)); err == nil {
+				started.Add(1)
+			} else if !errors.Is(err, ErrBudgetExhausted) {
+				t.Errorf("Start() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if started.Load() != 3 {
+		t.Fatalf("started %d calls, want 3 within the remaining budget of 9", started.Load())
+	}
+	if spent := budget.Spent(); spent != 10 || !budget.Exhausted() {
+		t.Errorf("Spent() = %v, Exhausted() = %v", spent, budget.Exhausted())
+	}
+	if budget.Allows(0) {
+		t.Error("Allows() with nothing left")
+	}
+}
+
+func TestCampaignBudgetSettles(t *testing.T) {
+	budget := NewCampaignBudget(5, 0)
+	meter := NewCallCostMeter(&models.SIPTrunk{RatePerMinute: 1}, ProviderRates{})
+	if err := budget.Start("call-1", meter, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := budget.Start("call-2", NewCallCostMeter(nil, ProviderRates{}), 2); !errors.Is(err, ErrBudgetExhausted) {
+		t.Errorf("Start() over the reserved budget error = %v", err)
+	}
+	// 挂断后按实际费用结算并释放预留
+	now := time.Now()
+	meter.MarkAnswered(now.Add(-30 * time.Second))
+	meter.MarkEnded(now)
+	if cost := budget.Finish("call-1"); cost != 1 {
+		t.Errorf("Finish() = %v, want 1", cost)
+	}
+	if budget.Spent() != 1 || !budget.Allows(4) || budget.Allows(4.5) {
+		t.Errorf("after settle Spent() = %v", budget.Spent())
+	}
+	if cost := budget.Finish("call-1"); cost != 0 {
+		t.Errorf("second Finish() = %v, want 0", cost)
+	}
+
+	// 提高上限后可以继续拨号，0表示不限
+	budget.SetLimit(1)
+	if budget.Allows(0) {
+		t.Error("Allows() after lowering the limit to the spent amount")
+	}
+	budget.SetLimit(0)
+	if !budget.Allows(100) || budget.Exhausted() {
+		t.Error("unlimited budget rejected a call")
+	}
+}
+
+func TestUpdateCampaignBudget(t *testing.T) {
+	db := newTestDB(t, &models.Campaign{}, &models.AuditLog{})
+	server := &SipServer{
+		config:       &ua.UAConfig{Db: db},
+		aiEngine:     &AIPhoneEngine{},
+		trunkManager: &TrunkManager{db: db},
+		campaigns:    newCampaignDialer(),
+	}
+	campaign := &models.Campaign{Name: "survey", ScriptID: 1, Status: models.CampaignStatusPaused, BudgetLimit: 10, BudgetSpent: 10}
+	if err := models.CreateCampaign(db, campaign); err != nil {
+		t.Fatal(err)
+	}
+	script := &models.AIPhoneScript{MaxDuration: 60000}
+	budget := server.campaigns.budget(campaign)
+
+	// 预算用完暂停的任务不能直接恢复
+	if err := server.checkCampaignBudget(campaign, script); !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("checkCampaignBudget() with exhausted budget error = %v", err)
+	}
+
+	router := newAPITestRouter(func(r gin.IRoutes) { RegisterCampaignAPIs(r, server) })
+	if res := callAPI(t, router, http.MethodPost, "/api/campaigns/1/budget", "", map[string]float64{"budgetLimit": 20}); res.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated update code = %d, want 401", res.Code)
+	}
+	if res := callAPI(t, router, http.MethodPost, "/api/campaigns/1/budget", "alice", map[string]float64{"budgetLimit": -1}); res.Code == 200 {
+		t.Error("negative budgetLimit accepted")
+	}
+	if res := callAPI(t, router, http.MethodPost, "/api/campaigns/1/budget", "alice", map[string]float64{"budgetLimit": 20}); res.Code != 200 {
+		t.Fatalf("update budget: %+v", res)
+	}
+	stored, err := models.GetCampaign(db, campaign.ID)
+	if err != nil || stored.BudgetLimit != 20 || stored.BudgetSpent != 10 {
+		t.Fatalf("stored campaign = %+v, %v", stored, err)
+	}
+	// 缓存的预算使用新上限，已结算费用不变
+	if budget.Limit() != 20 || budget.Spent() != 10 {
+		t.Errorf("cached budget limit/spent = %v/%v", budget.Limit(), budget.Spent())
+	}
+	if err := server.checkCampaignBudget(stored, script); err != nil {
+		t.Errorf("checkCampaignBudget() after raising the limit error = %v", err)
+	}
+	var logs []models.AuditLog
+	db.Where("action = ?", "campaign.budget_updated").Find(&logs)
+	if len(logs) != 1 || logs[0].Actor != "alice" {
+		t.Errorf("audit logs = %+v", logs)
+	}
+
+	// 任务结束后移除缓存，已结束的任务不能修改预算
+	server.completeCampaign(stored, "all contacts dialed")
+	server.campaigns.mutex.Lock()
+	_, cached := server.campaigns.budgets[campaign.ID]
+	server.campaigns.mutex.Unlock()
+	if cached {
+		t.Error("budget still cached after the campaign completed")
+	}
+	if _, err := server.UpdateCampaignBudget(campaign.ID, 30, "alice"); err == nil {
+		t.Error("UpdateCampaignBudget() on a completed campaign expected error")
+	}
+}
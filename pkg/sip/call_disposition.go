@@ -0,0 +1,54 @@
+package sip1
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+)
+
+// RetryRule 外呼失败后的重试规则
+type RetryRule struct {
+	MaxAttempts int           // 最大重试次数，0表示不重试
+	Delay       time.Duration // 首次重试间隔
+	Backoff     float64       // 每次重试间隔的倍数
+}
+
+// DefaultRetryRules 各通话结果的默认重试规则
+var DefaultRetryRules = map[models.SipCallDisposition]RetryRule{
+	models.SipCallDispositionBusy:        {MaxAttempts: 3, Delay: 5 * time.Minute, Backoff: 2},
+	models.SipCallDispositionNoAnswer:    {MaxAttempts: 2, Delay: 30 * time.Minute, Backoff: 2},
+	models.SipCallDispositionUnavailable: {MaxAttempts: 3, Delay: time.Minute, Backoff: 2},
+	models.SipCallDispositionFailed:      {MaxAttempts: 1, Delay: 5 * time.Minute, Backoff: 1},
+	// 号码不存在、被拒接不重试
+	models.SipCallDispositionInvalid:  {},
+	models.SipCallDispositionRejected: {},
+}
+
+// NextRetry 根据通话结果和已重试次数计算下次重试间隔，ok为false表示不再重试
+func NextRetry(rules map[models.SipCallDisposition]RetryRule, disposition models.SipCallDisposition, attempt int) (time.Duration, bool) {
+	if rules == nil {
+		rules = DefaultRetryRules
+	}
+	rule, exists := rules[disposition]
+	if !exists || attempt >= rule.MaxAttempts {
+		return 0, false
+	}
+
+	delay := rule.Delay
+	for i := 0; i < attempt && rule.Backoff > 1; i++ {
+		delay = time.Duration(float64(delay) * rule.Backoff)
+	}
+	return delay, true
+}
+
+// OutboundFailure 外呼腿收到的最终失败响应
+type OutboundFailure struct {
+	StatusCode  int
+	Reason      string
+	Disposition models.SipCallDisposition
+}
+
+func (f *OutboundFailure) Error() string {
+	return fmt.Sprintf("outbound call failed: %d %s (%s)", f.StatusCode, f.Reason, f.Disposition)
+}
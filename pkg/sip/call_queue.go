@@ -0,0 +1,365 @@
+package sip1
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// queueHoldText 没有配置等待音乐时排队期间循环播放的提示
+	queueHoldText = "当前坐席繁忙，请稍候"
+	// queueTimeoutText 排队超时挂断前的提示
+	queueTimeoutText = "当前等待人数较多，请您稍后再拨，再见"
+	// queueCheckInterval 检查排队超时和空余名额的间隔，名额释放时立即检查
+	queueCheckInterval = time.Second
+)
+
+// CallQueueStats 一个并发限制上的排队情况
+type CallQueueStats struct {
+	Queue       string  `json:"queue"`       // 超出的限制，如server:*、script:3
+	Waiting     int     `json:"waiting"`     // 正在排队的呼叫数
+	LongestWait float64 `json:"longestWait"` // 排队最久的呼叫已等待的秒数
+	AverageWait float64 `json:"averageWait"` // 最近接通的呼叫平均等待秒数
+	Enqueued    int64   `json:"enqueued"`
+	Served      int64   `json:"served"`    // 排到后开始执行脚本
+	Abandoned   int64   `json:"abandoned"` // 排队期间主叫挂机
+	TimedOut    int64   `json:"timedOut"`  // 超过CallQueueTimeout被挂断
+	Rejected    int64   `json:"rejected"`  // 队列已满回复486
+}
+
+// queuedCall 排队中的呼叫，ACK后才有播放等待音乐的会话
+type queuedCall struct {
+	callID       string
+	queue        string // 超出的限制，同一限制上的呼叫按入队顺序接通
+	quotas       []callQuota
+	enqueuedAt   time.Time
+	clientAddr   string
+	phoneNumber  string
+	callerNumber string
+	session      *ScriptSession
+	hold         *holdPlayer
+}
+
+// callQueue 按入队顺序保存超出并发限制的呼叫
+type callQueue struct {
+	mutex sync.Mutex
+	calls []*queuedCall
+	stats map[string]*CallQueueStats
+}
+
+func newCallQueue() *callQueue {
+	return &callQueue{stats: make(map[string]*CallQueueStats)}
+}
+
+func (q *callQueue) get(queue string) *CallQueueStats {
+	stats, ok := q.stats[queue]
+	if !ok {
+		stats = &CallQueueStats{Queue: queue}
+		q.stats[queue] = stats
+	}
+	return stats
+}
+
+func (q *callQueue) find(callID string) *queuedCall {
+	for _, call := range q.calls {
+		if call.callID == callID {
+			return call
+		}
+	}
+	return nil
+}
+
+// waitingFor 返回quotas中已有呼叫在排队的限制
+func (q *callQueue) waitingFor(quotas []callQuota) (callQuota, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, quota := range quotas {
+		if stats, ok := q.stats[quota.id()]; ok && stats.Waiting > 0 {
+			return quota, true
+		}
+	}
+	return callQuota{}, false
+}
+
+// position 返回呼叫所在的队列和排在它前面的呼叫数
+func (q *callQueue) position(callID string) (string, int, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	call := q.find(callID)
+	if call == nil {
+		return "", 0, false
+	}
+	ahead := 0
+	for _, other := range q.calls {
+		if other == call {
+			break
+		}
+		if other.queue == call.queue {
+			ahead++
+		}
+	}
+	return call.queue, ahead, true
+}
+
+// remove 移出队列，不在队列中时返回nil
+func (q *callQueue) remove(callID string) *queuedCall {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for i, call := range q.calls {
+		if call.callID == callID {
+			q.calls = append(q.calls[:i], q.calls[i+1:]...)
+			q.get(call.queue).Waiting--
+			return call
+		}
+	}
+	return nil
+}
+
+// enqueueCall 开启排队且队列未满时将超出限制的呼叫排到exceeded的队列末尾，接听后由ACK开始播放等待音乐
+func (as *SipServer) enqueueCall(callID string, exceeded callQuota, quotas []callQuota) bool {
+	if as.config.CallQueueSize <= 0 || as.aiEngine == nil {
+		return false
+	}
+	q := as.queue
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	stats := q.get(exceeded.id())
+	if stats.Waiting >= as.config.CallQueueSize {
+		stats.Rejected++
+		return false
+	}
+	stats.Waiting++
+	stats.Enqueued++
+	q.calls = append(q.calls, &queuedCall{
+		callID:     callID,
+		queue:      exceeded.id(),
+		quotas:     quotas,
+		enqueuedAt: time.Now(),
+	})
+	logger.Info("Queueing INVITE over concurrent call limit",
+		zap.String("call_id", callID),
+		zap.String("queue", exceeded.id()),
+		zap.Int("limit", exceeded.limit),
+		zap.Int("waiting", stats.Waiting))
+	return true
+}
+
+// parkQueuedCall ACK后为排队的呼叫循环播放等待音乐，每隔waitAnnounceInterval播报排队位置和预计等待时间；
+// 呼叫不在队列中时返回false
+func (as *SipServer) parkQueuedCall(callID, clientAddr, phoneNumber, callerNumber string) bool {
+	q := as.queue
+	q.mutex.Lock()
+	call := q.find(callID)
+	if call == nil {
+		q.mutex.Unlock()
+		return false
+	}
+	session := as.aiEngine.newQueueSession(callID, clientAddr, phoneNumber, as.getCallCodec(callID))
+	call.clientAddr, call.phoneNumber, call.callerNumber = clientAddr, phoneNumber, callerNumber
+	call.session = session
+	q.mutex.Unlock()
+
+	file, text := as.config.HoldMusicFile, ""
+	if file == "" {
+		text = queueHoldText
+	}
+	player := as.aiEngine.startAnnouncedHold(session, file, text, session.speakerID(), func() string {
+		return as.queueAnnouncement(callID)
+	})
+
+	// 开始播放前已经排到或挂机时停止播放
+	q.mutex.Lock()
+	queued := q.find(callID) == call
+	if queued {
+		call.hold = player
+	}
+	q.mutex.Unlock()
+	if !queued {
+		player.Stop()
+	}
+	logger.Info("Queued call waiting", zap.String("call_id", callID), zap.String("queue", call.queue))
+	return true
+}
+
+// newQueueSession 排队期间播放等待音乐用的会话，不登记到引擎；说话人使用号码对应脚本的默认说话人
+func (engine *AIPhoneEngine) newQueueSession(callID, clientAddr, phoneNumber string, codec rtpCodec) *ScriptSession {
+	session := &ScriptSession{
+		CallID:     callID,
+		ClientAddr: clientAddr,
+		Codec:      codec,
+		SSRC:       engine.callSSRC(callID),
+		StartTime:  engine.getClock().Now(),
+	}
+	if engine.db != nil && phoneNumber != "" {
+		if script, err := models.GetAIPhoneScriptByPhone(engine.db, phoneNumber); err == nil {
+			session.Script = script
+		}
+	}
+	return session
+}
+
+// leaveQueue 主叫挂机或呼叫失败时移出队列并停止等待音乐，不在队列中时无影响
+func (as *SipServer) leaveQueue(callID string) {
+	call := as.queue.remove(callID)
+	if call == nil {
+		return
+	}
+	as.queue.mutex.Lock()
+	as.queue.get(call.queue).Abandoned++
+	as.queue.mutex.Unlock()
+	// 等待音乐可能正在合成播报，不阻塞SIP请求处理
+	go call.hold.Stop()
+	logger.Info("Queued call abandoned",
+		zap.String("call_id", callID),
+		zap.String("queue", call.queue),
+		zap.Duration("waited", time.Since(call.enqueuedAt)))
+}
+
+// queueAnnouncement 排队位置和按队列最近实际等待时长估计的等待时间，如"您前面还有三位，预计等待约两分钟，请稍候"
+func (as *SipServer) queueAnnouncement(callID string) string {
+	queue, ahead, ok := as.queue.position(callID)
+	if !ok {
+		return ""
+	}
+	text := queuePositionText(ahead)
+	if average, ok := as.aiEngine.waits.Average(queue); ok {
+		return text + "，" + waitAnnouncement(average)
+	}
+	return text + "，请稍候"
+}
+
+// queuePositionText 排在前面的呼叫数的播报
+func queuePositionText(ahead int) string {
+	switch {
+	case ahead == 0:
+		return "您是下一位"
+	case ahead < 100:
+		return "您前面还有" + chineseCount(ahead) + "位"
+	default:
+		return fmt.Sprintf("您前面还有%d位", ahead)
+	}
+}
+
+// runCallQueue 开启排队时在名额释放后按入队顺序接通排队的呼叫，并挂断排队超时的呼叫，随服务关闭退出
+func (as *SipServer) runCallQueue() {
+	if as.config.CallQueueSize <= 0 {
+		return
+	}
+	ticker := time.NewTicker(queueCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-as.stopChan:
+			return
+		case <-as.quotas.released:
+		case <-ticker.C:
+		}
+		as.dispatchQueue(time.Now())
+	}
+}
+
+// dispatchQueue 每个队列从队首开始为已ACK的呼叫占用名额，占不到时同一队列后面的呼叫继续等待
+func (as *SipServer) dispatchQueue(now time.Time) {
+	q := as.queue
+	var ready, expired []*queuedCall
+	blocked := make(map[string]bool)
+
+	q.mutex.Lock()
+	remaining := q.calls[:0]
+	for _, call := range q.calls {
+		stats := q.get(call.queue)
+		if timeout := as.config.CallQueueTimeout; timeout > 0 && now.Sub(call.enqueuedAt) >= timeout {
+			stats.Waiting--
+			stats.TimedOut++
+			expired = append(expired, call)
+			continue
+		}
+		if !blocked[call.queue] && call.session != nil {
+			if _, ok := as.quotas.take(call.callID, call.quotas); ok {
+				stats.Waiting--
+				stats.Served++
+				ready = append(ready, call)
+				continue
+			}
+		}
+		blocked[call.queue] = true
+		remaining = append(remaining, call)
+	}
+	q.calls = remaining
+	q.mutex.Unlock()
+
+	for _, call := range ready {
+		go as.startQueuedCall(call, now)
+	}
+	for _, call := range expired {
+		go as.expireQueuedCall(call)
+	}
+}
+
+// startQueuedCall 停止等待音乐，记录等待时长并开始执行脚本，失败时挂断
+func (as *SipServer) startQueuedCall(call *queuedCall, now time.Time) {
+	call.hold.Stop()
+	wait := now.Sub(call.enqueuedAt)
+	as.aiEngine.RecordQueueWait(call.queue, wait)
+	logger.Info("Queued call dequeued",
+		zap.String("call_id", call.callID),
+		zap.String("queue", call.queue),
+		zap.Duration("waited", wait))
+
+	if err := as.aiEngine.StartScript(call.callID, call.clientAddr, call.phoneNumber, call.callerNumber); err != nil {
+		logger.Error("Failed to start AI phone script for queued call",
+			zap.String("call_id", call.callID),
+			zap.Error(err))
+		as.hangupCall(call.callID)
+	}
+}
+
+// expireQueuedCall 排队超时，播报后挂断
+func (as *SipServer) expireQueuedCall(call *queuedCall) {
+	call.hold.Stop()
+	logger.Info("Queued call timed out",
+		zap.String("call_id", call.callID),
+		zap.String("queue", call.queue),
+		zap.Duration("timeout", as.config.CallQueueTimeout))
+	if call.session != nil {
+		if err := as.aiEngine.playHoldAnnouncement(call.session, queueTimeoutText, call.session.speakerID(), nil); err != nil {
+			logger.Warn("Queue timeout announcement failed", zap.String("call_id", call.callID), zap.Error(err))
+		}
+	}
+	as.hangupCall(call.callID)
+}
+
+// CallQueues 返回各并发限制上的排队人数、等待时长和接通、放弃、超时、队列满的次数
+func (as *SipServer) CallQueues() []CallQueueStats {
+	now := time.Now()
+	q := as.queue
+	q.mutex.Lock()
+	result := make([]CallQueueStats, 0, len(q.stats))
+	for _, stats := range q.stats {
+		item := *stats
+		for _, call := range q.calls {
+			if call.queue == item.Queue {
+				item.LongestWait = now.Sub(call.enqueuedAt).Seconds()
+				break
+			}
+		}
+		result = append(result, item)
+	}
+	q.mutex.Unlock()
+
+	if as.aiEngine != nil {
+		for i := range result {
+			if average, ok := as.aiEngine.waits.Average(result[i].Queue); ok {
+				result[i].AverageWait = average.Seconds()
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Queue < result[j].Queue })
+	return result
+}
@@ -0,0 +1,272 @@
+package sip1
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// 并发限制的维度
+const (
+	QuotaScopeDID    = "did"
+	QuotaScopeScript = "script"
+	QuotaScopeTenant = "tenant"
+	QuotaScopeServer = "server"
+)
+
+// serverQuotaKey 整个服务的并发限制（MaxConcurrentSessions）使用的键
+const serverQuotaKey = "*"
+
+// callQuota 一个维度上的并发限制
+type callQuota struct {
+	scope string
+	key   string
+	limit int
+}
+
+func (q callQuota) id() string {
+	return q.scope + ":" + q.key
+}
+
+// CallQuotaUsage 并发限制的使用情况
+type CallQuotaUsage struct {
+	Scope    string `json:"scope"`
+	Key      string `json:"key"`      // 被叫号码、脚本ID、租户，整个服务为*
+	Active   int    `json:"active"`   // 占用名额的通话数
+	Limit    int    `json:"limit"`    // 最近一次呼入时的限制
+	Rejected int64  `json:"rejected"` // 超限排队或回复486的呼叫数
+}
+
+// callQuotas 按DID、脚本、租户统计进行中的呼入通话
+type callQuotas struct {
+	mutex sync.Mutex
+	usage map[string]*CallQuotaUsage
+	calls map[string][]string // callID -> 占用的限制
+	// released 有名额释放时通知排队的呼叫
+	released chan struct{}
+}
+
+func newCallQuotas() *callQuotas {
+	return &callQuotas{
+		usage:    make(map[string]*CallQuotaUsage),
+		calls:    make(map[string][]string),
+		released: make(chan struct{}, 1),
+	}
+}
+
+func (q *callQuotas) get(quota callQuota) *CallQuotaUsage {
+	usage, ok := q.usage[quota.id()]
+	if !ok {
+		usage = &CallQuotaUsage{Scope: quota.scope, Key: quota.key}
+		q.usage[quota.id()] = usage
+	}
+	return usage
+}
+
+// acquire 所有限制都有空余时为通话占用名额，否则返回超出的限制并计入超限次数
+func (q *callQuotas) acquire(callID string, quotas []callQuota) (callQuota, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	exceeded, ok := q.occupy(callID, quotas)
+	if !ok {
+		q.get(exceeded).Rejected++
+	}
+	return exceeded, ok
+}
+
+// take 同acquire，不计超限次数，排队的呼叫反复尝试时使用
+func (q *callQuotas) take(callID string, quotas []callQuota) (callQuota, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.occupy(callID, quotas)
+}
+
+func (q *callQuotas) occupy(callID string, quotas []callQuota) (callQuota, bool) {
+	if _, exists := q.calls[callID]; exists {
+		return callQuota{}, true
+	}
+	for _, quota := range quotas {
+		usage := q.get(quota)
+		usage.Limit = quota.limit
+		if usage.Active >= quota.limit {
+			return quota, false
+		}
+	}
+	ids := make([]string, 0, len(quotas))
+	for _, quota := range quotas {
+		q.get(quota).Active++
+		ids = append(ids, quota.id())
+	}
+	q.calls[callID] = ids
+	return callQuota{}, true
+}
+
+// release 通话结束时释放名额，重复调用无影响
+func (q *callQuotas) release(callID string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	ids, exists := q.calls[callID]
+	if !exists {
+		return
+	}
+	for _, id := range ids {
+		if usage, ok := q.usage[id]; ok && usage.Active > 0 {
+			usage.Active--
+		}
+	}
+	delete(q.calls, callID)
+	select {
+	case q.released <- struct{}{}:
+	default:
+	}
+}
+
+// rekey 通话转接到新Call-ID后名额随之转移
+func (q *callQuotas) rekey(oldCallID, newCallID string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if ids, exists := q.calls[oldCallID]; exists {
+		delete(q.calls, oldCallID)
+		q.calls[newCallID] = ids
+	}
+}
+
+func (q *callQuotas) snapshot() []CallQuotaUsage {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	result := make([]CallQuotaUsage, 0, len(q.usage))
+	for _, usage := range q.usage {
+		result = append(result, *usage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Scope != result[j].Scope {
+			return result[i].Scope < result[j].Scope
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// CallQuotas 返回各DID、脚本、租户的并发通话数和超限次数
+func (as *SipServer) CallQuotas() []CallQuotaUsage {
+	return as.quotas.snapshot()
+}
+
+// inviteQuotas 查找整个服务（MaxConcurrentSessions）、被叫号码、其脚本和脚本所属租户的并发限制，
+// 未配置（0）的维度不限制
+func (as *SipServer) inviteQuotas(phoneNumber string) []callQuota {
+	var quotas []callQuota
+	if as.config.MaxConcurrentSessions > 0 {
+		quotas = append(quotas, callQuota{scope: QuotaScopeServer, key: serverQuotaKey, limit: as.config.MaxConcurrentSessions})
+	}
+	if as.config.Db == nil || phoneNumber == "" {
+		return quotas
+	}
+	mapping, err := models.GetScriptPhoneMapping(as.config.Db, phoneNumber)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Warn("Failed to load call limits", zap.String("phone", phoneNumber), zap.Error(err))
+		}
+		return quotas
+	}
+
+	if mapping.MaxConcurrentCalls > 0 {
+		quotas = append(quotas, callQuota{scope: QuotaScopeDID, key: phoneNumber, limit: mapping.MaxConcurrentCalls})
+	}
+	return append(quotas, as.scriptQuotas(&mapping.Script)...)
+}
+
+// scriptQuotas 脚本及其租户的并发限制
+func (as *SipServer) scriptQuotas(script *models.AIPhoneScript) []callQuota {
+	var quotas []callQuota
+	if script.MaxConcurrentCalls > 0 {
+		quotas = append(quotas, callQuota{scope: QuotaScopeScript, key: strconv.FormatUint(uint64(script.ID), 10), limit: script.MaxConcurrentCalls})
+	}
+	if tenant := script.Tenant; tenant != "" && as.config.TenantCallLimits[tenant] > 0 {
+		quotas = append(quotas, callQuota{scope: QuotaScopeTenant, key: tenant, limit: as.config.TenantCallLimits[tenant]})
+	}
+	return quotas
+}
+
+// answerTransaction 记录INVITE是否已接听
+type answerTransaction struct {
+	sip.ServerTransaction
+	answered bool
+}
+
+func (tx *answerTransaction) Respond(res *sip.Response) error {
+	if res.IsSuccess() {
+		tx.answered = true
+	}
+	return tx.ServerTransaction.Respond(res)
+}
+
+// enforceCallQuotas 新呼入超出服务、DID、脚本或租户的并发限制时进入排队，未开启排队或队列已满时回复486，
+// 接听后的通话占用名额直到挂断，避免一条热线的突发呼入占满其他热线的容量
+func (as *SipServer) enforceCallQuotas(next sipgo.RequestHandler) sipgo.RequestHandler {
+	return func(req *sip.Request, tx sip.ServerTransaction) {
+		// 对话内的re-INVITE不占用名额
+		to := req.To()
+		if to.Params.Has("tag") {
+			next(req, tx)
+			return
+		}
+
+		callID := req.CallID().Value()
+		quotas := as.inviteQuotas(to.Address.User)
+		// 限制前已有呼叫排队时新呼叫排在它们后面，不抢先占用刚释放的名额
+		exceeded, queued := as.queue.waitingFor(quotas)
+		ok := false
+		if !queued {
+			exceeded, ok = as.quotas.acquire(callID, quotas)
+		}
+		if !ok && !as.enqueueCall(callID, exceeded, quotas) {
+			logger.Warn("Rejecting INVITE over concurrent call limit",
+				zap.String("call_id", callID),
+				zap.String("scope", exceeded.scope),
+				zap.String("key", exceeded.key),
+				zap.Int("limit", exceeded.limit))
+			tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBusyHere, "Busy Here", nil))
+			return
+		}
+
+		answer := &answerTransaction{ServerTransaction: tx}
+		next(req, answer)
+		// 取消、协商失败等未接听的呼叫立即释放名额、离开队列
+		if !answer.answered {
+			as.quotas.release(callID)
+			as.leaveQueue(callID)
+		}
+	}
+}
+
+// ParseCallLimits 解析 "名称=上限,名称=上限" 格式的并发限制，如SIP_TENANT_CALL_LIMITS
+func ParseCallLimits(value string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, limit, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid call limit %q, want name=limit", item)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(limit))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid call limit %q: limit must be a non-negative integer", item)
+		}
+		limits[strings.TrimSpace(name)] = n
+	}
+	return limits, nil
+}
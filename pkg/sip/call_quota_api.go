@@ -0,0 +1,23 @@
+package sip1
+
+import (
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterCallQuotaAPIs 注册并发限制接口：GET /call-quotas 查看整个服务和各DID、脚本、租户的并发通话数、限制和超限次数，
+// GET /call-queues 查看超出并发限制后排队的人数、最长和平均等待时长及接通、放弃、超时次数，
+// GET /load 查看最近一次采样的CPU使用率、RTP包速率和过载拒绝次数
+func RegisterCallQuotaAPIs(r gin.IRoutes, server *SipServer) {
+	r.GET("/call-quotas", func(c *gin.Context) {
+		response.Success(c, "ok", server.CallQuotas())
+	})
+
+	r.GET("/call-queues", func(c *gin.Context) {
+		response.Success(c, "ok", server.CallQueues())
+	})
+
+	r.GET("/load", func(c *gin.Context) {
+		response.Success(c, "ok", server.Load())
+	})
+}
@@ -0,0 +1,138 @@
+package sip1
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+)
+
+// callWindow 解析后的拨打时段，start等于end时全天
+type callWindow struct {
+	start, end time.Duration // 距午夜的时长
+	days       [8]bool       // 下标1-7为周一到周日
+	limited    bool          // 是否有任何限制
+}
+
+// parseCallWindow 解析时段，未设置时不限制
+func parseCallWindow(window models.CallWindow) (callWindow, error) {
+	var w callWindow
+	if window.IsZero() {
+		return w, nil
+	}
+	w.limited = true
+	var err error
+	if w.start, err = parseTimeOfDay(window.StartTime); err != nil {
+		return w, fmt.Errorf("invalid start time %q: %w", window.StartTime, err)
+	}
+	if w.end, err = parseTimeOfDay(window.EndTime); err != nil {
+		return w, fmt.Errorf("invalid end time %q: %w", window.EndTime, err)
+	}
+	if strings.TrimSpace(window.WeekDays) == "" {
+		for day := 1; day <= 7; day++ {
+			w.days[day] = true
+		}
+		return w, nil
+	}
+	for _, item := range strings.Split(window.WeekDays, ",") {
+		day, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || day < 1 || day > 7 {
+			return w, fmt.Errorf("invalid week day %q", item)
+		}
+		w.days[day] = true
+	}
+	return w, nil
+}
+
+// parseTimeOfDay 解析HH:MM或HH:MM:SS，为空时为午夜
+func parseTimeOfDay(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("expected HH:MM[:SS]")
+	}
+	limits := []int{23, 59, 59}
+	var offset time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second}[:len(parts)] {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 || n > limits[i] {
+			return 0, fmt.Errorf("expected HH:MM[:SS]")
+		}
+		offset += time.Duration(n) * unit
+	}
+	return offset, nil
+}
+
+// isoWeekday 周一为1，周日为7
+func isoWeekday(t time.Time) int {
+	if day := int(t.Weekday()); day != 0 {
+		return day
+	}
+	return 7
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// allows t是否在时段内；跨午夜的时段按开始那天的星期判断
+func (w callWindow) allows(t time.Time) bool {
+	if !w.limited {
+		return true
+	}
+	day := midnight(t)
+	offset := t.Sub(day)
+	switch {
+	case w.start == w.end:
+		return w.days[isoWeekday(t)]
+	case w.start < w.end:
+		return w.days[isoWeekday(t)] && offset >= w.start && offset < w.end
+	default:
+		if offset >= w.start {
+			return w.days[isoWeekday(t)]
+		}
+		return offset < w.end && w.days[isoWeekday(day.AddDate(0, 0, -1))]
+	}
+}
+
+// next t在时段内时返回t，否则返回之后最近的开始时间；星期都不允许时返回false
+func (w callWindow) next(t time.Time) (time.Time, bool) {
+	if w.allows(t) {
+		return t, true
+	}
+	day := midnight(t)
+	for i := 0; i <= 7; i++ {
+		date := day.AddDate(0, 0, i)
+		open := date.Add(w.start)
+		if open.After(t) && w.days[isoWeekday(date)] {
+			return open, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nextAllowed 同时满足所有时段的最早时间，不存在时返回false
+func nextAllowed(t time.Time, windows ...callWindow) (time.Time, bool) {
+	// 每轮推迟到某个时段的开始时间，两周内找不到共同时段时放弃
+	for limit := t.AddDate(0, 0, 14); !t.After(limit); {
+		moved := false
+		for _, w := range windows {
+			next, ok := w.next(t)
+			if !ok {
+				return time.Time{}, false
+			}
+			if next.After(t) {
+				t, moved = next, true
+			}
+		}
+		if !moved {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,120 @@
+package sip1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+)
+
+// localTime 解析服务器本地时间，2026-01-05为周一
+func localTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	at, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
+
+func TestParseCallWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  models.CallWindow
+		wantErr bool
+	}{
+		{name: "unset", window: models.CallWindow{}},
+		{name: "hours and days", window: models.CallWindow{StartTime: "09:00", EndTime: "18:00", WeekDays: "1, 2,3,4,5"}},
+		{name: "seconds", window: models.CallWindow{StartTime: "09:00:30", EndTime: "18:00:00"}},
+		{name: "days only", window: models.CallWindow{WeekDays: "6,7"}},
+		{name: "bad hour", window: models.CallWindow{StartTime: "24:00", EndTime: "18:00"}, wantErr: true},
+		{name: "bad minute", window: models.CallWindow{StartTime: "09:60", EndTime: "18:00"}, wantErr: true},
+		{name: "no minutes", window: models.CallWindow{StartTime: "9", EndTime: "18:00"}, wantErr: true},
+		{name: "bad end", window: models.CallWindow{StartTime: "09:00", EndTime: "6pm"}, wantErr: true},
+		{name: "day zero", window: models.CallWindow{WeekDays: "0,1"}, wantErr: true},
+		{name: "day name", window: models.CallWindow{WeekDays: "mon"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseCallWindow(tt.window); (err != nil) != tt.wantErr {
+				t.Errorf("parseCallWindow(%+v) error = %v, wantErr %v", tt.window, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCallWindowAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		window models.CallWindow
+		at     string
+		want   bool
+	}{
+		{"unset allows anything", models.CallWindow{}, "2026-01-04 03:00", true},
+		{"start inclusive", models.CallWindow{StartTime: "09:00", EndTime: "18:00"}, "2026-01-05 09:00", true},
+		{"end exclusive", models.CallWindow{StartTime: "09:00", EndTime: "18:00"}, "2026-01-05 18:00", false},
+		{"before start", models.CallWindow{StartTime: "09:00", EndTime: "18:00"}, "2026-01-05 08:59", false},
+		{"weekday excluded", models.CallWindow{StartTime: "09:00", EndTime: "18:00", WeekDays: "1,2,3,4,5"}, "2026-01-10 10:00", false},
+		{"sunday is 7", models.CallWindow{WeekDays: "7"}, "2026-01-11 10:00", true},
+		{"days only whole day", models.CallWindow{WeekDays: "1"}, "2026-01-05 23:59", true},
+		{"overnight before midnight", models.CallWindow{StartTime: "22:00", EndTime: "02:00"}, "2026-01-05 23:00", true},
+		{"overnight after midnight", models.CallWindow{StartTime: "22:00", EndTime: "02:00"}, "2026-01-06 01:00", true},
+		{"overnight gap", models.CallWindow{StartTime: "22:00", EndTime: "02:00"}, "2026-01-06 12:00", false},
+		// 跨午夜的时段按开始那天的星期判断：周五22点开始的时段延续到周六凌晨
+		{"overnight from allowed day", models.CallWindow{StartTime: "22:00", EndTime: "02:00", WeekDays: "5"}, "2026-01-10 01:00", true},
+		{"overnight into allowed day", models.CallWindow{StartTime: "22:00", EndTime: "02:00", WeekDays: "6"}, "2026-01-10 01:00", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, err := parseCallWindow(tt.window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := window.allows(localTime(t, tt.at)); got != tt.want {
+				t.Errorf("allows(%s) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextAllowed(t *testing.T) {
+	office := models.CallWindow{StartTime: "09:00", EndTime: "18:00", WeekDays: "1,2,3,4,5"}
+	tests := []struct {
+		name    string
+		windows []models.CallWindow
+		at      string
+		want    string // 为空表示没有共同时段
+	}{
+		{"already allowed", []models.CallWindow{office}, "2026-01-05 10:00", "2026-01-05 10:00"},
+		{"later today", []models.CallWindow{office}, "2026-01-05 07:30", "2026-01-05 09:00"},
+		{"next day", []models.CallWindow{office}, "2026-01-05 18:00", "2026-01-06 09:00"},
+		{"over the weekend", []models.CallWindow{office}, "2026-01-09 19:00", "2026-01-12 09:00"},
+		{"no windows", nil, "2026-01-05 03:00", "2026-01-05 03:00"},
+		{"intersection", []models.CallWindow{office, {StartTime: "17:00", EndTime: "20:00"}}, "2026-01-05 10:00", "2026-01-05 17:00"},
+		{"intersection on a later day", []models.CallWindow{office, {WeekDays: "3"}}, "2026-01-05 10:00", "2026-01-07 09:00"},
+		{"overnight window", []models.CallWindow{{StartTime: "22:00", EndTime: "02:00"}}, "2026-01-05 12:00", "2026-01-05 22:00"},
+		{"disjoint hours", []models.CallWindow{office, {StartTime: "19:00", EndTime: "21:00"}}, "2026-01-05 10:00", ""},
+		{"disjoint days", []models.CallWindow{office, {WeekDays: "6,7"}}, "2026-01-05 10:00", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows := make([]callWindow, len(tt.windows))
+			for i, w := range tt.windows {
+				var err error
+				if windows[i], err = parseCallWindow(w); err != nil {
+					t.Fatal(err)
+				}
+			}
+			next, ok := nextAllowed(localTime(t, tt.at), windows...)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("nextAllowed() = %v, want no common window", next)
+				}
+				return
+			}
+			if want := localTime(t, tt.want); !ok || !next.Equal(want) {
+				t.Errorf("nextAllowed() = %v, %v, want %v", next, ok, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,796 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+	"github.com/emiago/sipgo/sip"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// campaignCheckInterval 外呼任务检查到期联系人和空余并发的间隔
+	campaignCheckInterval = time.Second
+	// campaignSessionMargin 等待AI会话结束的时长在脚本最长时长之外的余量，超过后挂断
+	campaignSessionMargin = time.Minute
+
+	defaultCampaignConcurrency = 1
+	defaultCampaignRingTimeout = 30
+	defaultCampaignMaxAttempts = 3
+
+	auditTargetCampaign = "campaign"
+)
+
+// campaignDialer 本实例上运行中的外呼任务，每个任务一个拨号协程
+type campaignDialer struct {
+	mutex   sync.Mutex
+	runs    map[uint]chan struct{}   // 任务ID到停止通道
+	budgets map[uint]*CampaignBudget // 任务ID到预算，暂停后保留，恢复时仍计入进行中的通话；任务结束时移除
+}
+
+func newCampaignDialer() *campaignDialer {
+	return &campaignDialer{runs: make(map[uint]chan struct{}), budgets: make(map[uint]*CampaignBudget)}
+}
+
+// budget 任务的预算，首次使用时按任务记录的上限和已结算费用创建，之后按任务记录更新上限
+func (d *campaignDialer) budget(campaign *models.Campaign) *CampaignBudget {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	budget, exists := d.budgets[campaign.ID]
+	if !exists {
+		budget = NewCampaignBudget(campaign.BudgetLimit, campaign.BudgetSpent)
+		d.budgets[campaign.ID] = budget
+	} else {
+		budget.SetLimit(campaign.BudgetLimit)
+	}
+	return budget
+}
+
+// forget 任务结束后移除预算，进行中的通话仍持有预算并在挂断时结算
+func (d *campaignDialer) forget(id uint) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.budgets, id)
+}
+
+// start 任务未在运行时返回新的停止通道
+func (d *campaignDialer) start(id uint) (chan struct{}, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, running := d.runs[id]; running {
+		return nil, false
+	}
+	stop := make(chan struct{})
+	d.runs[id] = stop
+	return stop, true
+}
+
+// stop 停止任务的拨号协程，进行中的通话不受影响
+func (d *campaignDialer) stop(id uint) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if stop, running := d.runs[id]; running {
+		close(stop)
+		delete(d.runs, id)
+	}
+}
+
+// done 拨号协程退出时移除，任务已被重新启动时保留新的协程
+func (d *campaignDialer) done(id uint, stop chan struct{}) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.runs[id] == stop {
+		delete(d.runs, id)
+	}
+}
+
+// CreateCampaign 校验脚本后保存外呼任务和联系人，任务为草稿状态，需调用StartCampaign开始拨号
+func (as *SipServer) CreateCampaign(campaign *models.Campaign, contacts []models.CampaignContact) error {
+	db := as.config.Db
+	if db == nil {
+		return errors.New("database not configured")
+	}
+	if _, err := models.GetAIPhoneScriptByID(db, campaign.ScriptID); err != nil {
+		return fmt.Errorf("script %d: %w", campaign.ScriptID, err)
+	}
+	contacts, err := normalizeCampaignContacts(contacts)
+	if err != nil {
+		return err
+	}
+	if err := validateCampaignSchedule(campaign); err != nil {
+		return err
+	}
+	campaign.ID = 0
+	campaign.Status = models.CampaignStatusDraft
+	campaign.StartedAt, campaign.CompletedAt = nil, nil
+	if campaign.MaxConcurrentCalls <= 0 {
+		campaign.MaxConcurrentCalls = defaultCampaignConcurrency
+	}
+	if campaign.RingTimeout <= 0 {
+		campaign.RingTimeout = defaultCampaignRingTimeout
+	}
+	if campaign.MaxAttempts <= 0 {
+		campaign.MaxAttempts = defaultCampaignMaxAttempts
+	}
+	if campaign.CallsPerMinute < 0 {
+		campaign.CallsPerMinute = 0
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := models.CreateCampaign(tx, campaign); err != nil {
+			return err
+		}
+		return models.AddCampaignContacts(tx, campaign.ID, contacts)
+	}); err != nil {
+		return err
+	}
+	as.auditCampaign(campaign, campaign.CreatedBy, "campaign.created", map[string]interface{}{"contacts": len(contacts)})
+	return nil
+}
+
+// AddCampaignContacts 向未结束的任务追加联系人
+func (as *SipServer) AddCampaignContacts(id uint, contacts []models.CampaignContact, actor string) (int, error) {
+	db := as.config.Db
+	if db == nil {
+		return 0, errors.New("database not configured")
+	}
+	campaign, err := models.GetCampaign(db, id)
+	if err != nil {
+		return 0, err
+	}
+	if campaign.Status == models.CampaignStatusCompleted {
+		return 0, fmt.Errorf("campaign %d already completed", id)
+	}
+	contacts, err = normalizeCampaignContacts(contacts)
+	if err != nil {
+		return 0, err
+	}
+	if err := models.AddCampaignContacts(db, id, contacts); err != nil {
+		return 0, err
+	}
+	as.auditCampaign(campaign, actor, "campaign.contacts_added", map[string]interface{}{"contacts": len(contacts)})
+	return len(contacts), nil
+}
+
+// normalizeCampaignContacts 去掉号码两端空白，号码为空或时段无效时返回错误
+func normalizeCampaignContacts(contacts []models.CampaignContact) ([]models.CampaignContact, error) {
+	for i := range contacts {
+		contacts[i].PhoneNumber = strings.TrimSpace(contacts[i].PhoneNumber)
+		if contacts[i].PhoneNumber == "" {
+			return nil, fmt.Errorf("contact %d: phone number required", i)
+		}
+		if _, err := parseCallWindow(contacts[i].Window); err != nil {
+			return nil, fmt.Errorf("contact %d: %w", i, err)
+		}
+	}
+	return contacts, nil
+}
+
+// StartCampaign 开始或恢复草稿、已暂停的任务；脚本须为激活状态
+func (as *SipServer) StartCampaign(id uint, actor string) (*models.Campaign, error) {
+	db := as.config.Db
+	if db == nil || as.aiEngine == nil {
+		return nil, errors.New("AI phone engine not configured")
+	}
+	if as.trunkManager == nil {
+		return nil, errors.New("trunk manager not configured")
+	}
+	campaign, err := models.GetCampaign(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if campaign.Status != models.CampaignStatusDraft && campaign.Status != models.CampaignStatusPaused {
+		return nil, fmt.Errorf("campaign %d is %s", id, campaign.Status)
+	}
+	script, err := models.GetAIPhoneScriptByID(db, campaign.ScriptID)
+	if err != nil {
+		return nil, fmt.Errorf("script %d: %w", campaign.ScriptID, err)
+	}
+	if script.Status != models.ScriptStatusActive {
+		return nil, fmt.Errorf("script %s is %s, only active scripts can be dialed", script.Name, script.Status)
+	}
+	if err := as.checkCampaignBudget(campaign, script); err != nil {
+		return nil, err
+	}
+
+	action := "campaign.resumed"
+	if campaign.Status == models.CampaignStatusDraft {
+		action = "campaign.started"
+	}
+	now := time.Now()
+	campaign.Status = models.CampaignStatusRunning
+	if campaign.StartedAt == nil {
+		campaign.StartedAt = &now
+	}
+	if err := models.UpdateCampaign(db, campaign); err != nil {
+		return nil, err
+	}
+	as.auditCampaign(campaign, actor, action, nil)
+	as.runCampaign(campaign.ID)
+	return campaign, nil
+}
+
+// checkCampaignBudget 剩余预算不够拨打一通电话时拒绝开始，避免恢复后立即因预算再次暂停
+func (as *SipServer) checkCampaignBudget(campaign *models.Campaign, script *models.AIPhoneScript) error {
+	budget := as.campaigns.budget(campaign)
+	reserve := 0.0
+	if conn, err := as.campaignTrunk(campaign); err == nil {
+		reserve = as.aiEngine.newCostMeter(conn.Trunk).Reserve(campaignCallDuration(script))
+	}
+	if !budget.Allows(reserve) {
+		return fmt.Errorf("campaign %d: %w (budget %.2f, spent %.2f), raise budgetLimit first", campaign.ID, ErrBudgetExhausted, budget.Limit(), budget.Spent())
+	}
+	return nil
+}
+
+// campaignCallDuration 一通外呼电话的最长时长，按此预留预算
+func campaignCallDuration(script *models.AIPhoneScript) time.Duration {
+	return time.Duration(script.MaxDuration) * time.Millisecond
+}
+
+// UpdateCampaignBudget 修改未结束任务的预算上限，limit为0表示不限；运行中的任务下次拨号时生效
+func (as *SipServer) UpdateCampaignBudget(id uint, limit float64, actor string) (*models.Campaign, error) {
+	db := as.config.Db
+	if db == nil {
+		return nil, errors.New("database not configured")
+	}
+	if limit < 0 {
+		return nil, errors.New("budgetLimit must not be negative")
+	}
+	campaign, err := models.GetCampaign(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if campaign.Status == models.CampaignStatusCompleted {
+		return nil, fmt.Errorf("campaign %d already completed", id)
+	}
+	previous := campaign.BudgetLimit
+	campaign.BudgetLimit = limit
+	if err := models.UpdateCampaign(db, campaign); err != nil {
+		return nil, err
+	}
+	as.campaigns.budget(campaign)
+	as.auditCampaign(campaign, actor, "campaign.budget_updated", map[string]interface{}{"from": previous, "to": limit})
+	return campaign, nil
+}
+
+// PauseCampaign 暂停拨号，进行中的通话继续并记录结果
+func (as *SipServer) PauseCampaign(id uint, actor string) (*models.Campaign, error) {
+	return as.pauseCampaign(id, actor, nil)
+}
+
+// pauseCampaign 暂停任务，detail写入审计日志
+func (as *SipServer) pauseCampaign(id uint, actor string, detail map[string]interface{}) (*models.Campaign, error) {
+	db := as.config.Db
+	if db == nil {
+		return nil, errors.New("database not configured")
+	}
+	campaign, err := models.GetCampaign(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if campaign.Status != models.CampaignStatusRunning {
+		return nil, fmt.Errorf("campaign %d is %s", id, campaign.Status)
+	}
+	campaign.Status = models.CampaignStatusPaused
+	if err := models.UpdateCampaign(db, campaign); err != nil {
+		return nil, err
+	}
+	as.campaigns.stop(id)
+	as.auditCampaign(campaign, actor, "campaign.paused", detail)
+	return campaign, nil
+}
+
+// auditCampaign 写入任务审计日志，失败只记录日志
+func (as *SipServer) auditCampaign(campaign *models.Campaign, actor, action string, detail map[string]interface{}) {
+	if detail == nil {
+		detail = make(map[string]interface{})
+	}
+	detail["name"] = campaign.Name
+	if err := models.CreateAuditLog(as.config.Db, actor, action, auditTargetCampaign, campaign.ID, detail); err != nil {
+		logger.Error("Failed to write audit log",
+			zap.String("action", action),
+			zap.Uint("campaign_id", campaign.ID),
+			zap.Error(err))
+	}
+}
+
+// resumeCampaigns 服务启动时继续拨打运行中的任务，上次未结束的拨打先恢复
+func (as *SipServer) resumeCampaigns() {
+	if as.config.Db == nil || as.aiEngine == nil || as.trunkManager == nil {
+		return
+	}
+	campaigns, err := models.ListCampaigns(as.config.Db, models.CampaignStatusRunning)
+	if err != nil {
+		logger.Error("Failed to load running campaigns", zap.Error(err))
+		return
+	}
+	for _, campaign := range campaigns {
+		if err := models.ResetCampaignContacts(as.config.Db, campaign.ID); err != nil {
+			logger.Error("Failed to reset interrupted campaign contacts", zap.Uint("campaign_id", campaign.ID), zap.Error(err))
+			continue
+		}
+		logger.Info("Resuming campaign", zap.Uint("campaign_id", campaign.ID), zap.String("name", campaign.Name))
+		as.runCampaign(campaign.ID)
+	}
+}
+
+// runCampaign 任务未在本实例运行时启动拨号协程
+func (as *SipServer) runCampaign(id uint) {
+	stop, ok := as.campaigns.start(id)
+	if !ok {
+		return
+	}
+	go as.dialCampaign(id, stop)
+}
+
+// dialCampaign 任务的拨号循环：每次检查重新读取任务，在开始和结束时间内按并发和每分钟呼叫数拨打到期的联系人；
+// 没有待拨和进行中的联系人或超过结束时间时任务结束，暂停、服务关闭时退出
+func (as *SipServer) dialCampaign(id uint, stop chan struct{}) {
+	defer as.campaigns.done(id, stop)
+	var active atomic.Int32
+	var lastDial time.Time
+	var trunkErr string
+
+	ticker := time.NewTicker(campaignCheckInterval)
+	defer ticker.Stop()
+	for {
+		campaign, err := models.GetCampaign(as.config.Db, id)
+		now := time.Now()
+		switch {
+		case err != nil:
+			logger.Error("Failed to load campaign", zap.Uint("campaign_id", id), zap.Error(err))
+		case campaign.Status != models.CampaignStatusRunning:
+			return
+		case campaign.EndAt != nil && now.After(*campaign.EndAt):
+			as.completeCampaign(campaign, "end time reached")
+			return
+		case campaign.StartAt != nil && now.Before(*campaign.StartAt):
+		default:
+			conn, err := as.campaignTrunk(campaign)
+			if err != nil {
+				// 中继恢复前每秒都会失败，只在原因变化时记录
+				if err.Error() != trunkErr {
+					logger.Warn("Campaign trunk unavailable", zap.Uint("campaign_id", id), zap.Error(err))
+				}
+				trunkErr = err.Error()
+				break
+			}
+			trunkErr = ""
+			if as.dialDueContacts(campaign, conn, &active, &lastDial, now) {
+				return
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-as.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dialDueContacts 在空余并发、每分钟呼叫数和预算内拨打到期的联系人；
+// 没有可拨的联系人且没有进行中的呼叫时结束任务，预算用完时暂停任务，都返回true
+func (as *SipServer) dialDueContacts(campaign *models.Campaign, conn *TrunkConnection, active *atomic.Int32, lastDial *time.Time, now time.Time) bool {
+	db := as.config.Db
+	var interval time.Duration
+	if campaign.CallsPerMinute > 0 {
+		interval = time.Minute / time.Duration(campaign.CallsPerMinute)
+	}
+	script, err := models.GetAIPhoneScriptByID(db, campaign.ScriptID)
+	if err != nil {
+		logger.Error("Failed to load campaign script", zap.Uint("campaign_id", campaign.ID), zap.Error(err))
+		return false
+	}
+	window, err := campaignWindow(campaign, script, conn.Trunk)
+	if err != nil {
+		logger.Error("Invalid campaign calling window", zap.Uint("campaign_id", campaign.ID), zap.Error(err))
+		return false
+	}
+	if !window.allows(now) {
+		return false
+	}
+
+	for int(active.Load()) < campaign.MaxConcurrentCalls && now.Sub(*lastDial) >= interval && !as.isDraining() {
+		contact, err := models.ClaimNextCampaignContact(db, campaign.ID, now)
+		if err != nil {
+			logger.Error("Failed to claim campaign contact", zap.Uint("campaign_id", campaign.ID), zap.Error(err))
+			return false
+		}
+		if contact == nil {
+			if active.Load() == 0 {
+				if open, err := models.HasOpenCampaignContacts(db, campaign.ID); err == nil && !open {
+					as.completeCampaign(campaign, "all contacts dialed")
+					return true
+				}
+			}
+			return false
+		}
+		windows, ok := as.deferCampaignContact(campaign, contact, window, now)
+		if !ok {
+			continue
+		}
+
+		callID := uuid.NewString()
+		cost := as.aiEngine.newCostMeter(conn.Trunk)
+		budget := as.campaigns.budget(campaign)
+		if err := budget.Start(callID, cost, cost.Reserve(campaignCallDuration(script))); err != nil {
+			as.releaseCampaignContact(contact)
+			as.pauseExhaustedCampaign(campaign, budget)
+			return true
+		}
+		if exceeded, ok := as.quotas.take(callID, as.campaignQuotas(script)); !ok {
+			budget.Finish(callID)
+			// 并发已满不计拨打次数，等名额释放后再拨
+			as.releaseCampaignContact(contact)
+			logger.Debug("Campaign waiting for call quota",
+				zap.Uint("campaign_id", campaign.ID),
+				zap.String("quota", exceeded.id()))
+			return false
+		}
+		*lastDial = now
+		active.Add(1)
+		go func() {
+			defer active.Add(-1)
+			defer as.chargeCampaignCall(campaign, budget, callID)
+			as.dialCampaignContact(campaign, script, conn, contact, callID, cost, windows)
+		}()
+	}
+	return false
+}
+
+// releaseCampaignContact 未拨打的联系人恢复为待拨，不计拨打次数
+func (as *SipServer) releaseCampaignContact(contact *models.CampaignContact) {
+	contact.Status = models.CampaignContactPending
+	if err := models.UpdateCampaignContact(as.config.Db, contact); err != nil {
+		logger.Error("Failed to release campaign contact", zap.Uint("contact_id", contact.ID), zap.Error(err))
+	}
+}
+
+// pauseExhaustedCampaign 预算用完时暂停任务，审计日志记录预算和已花费
+func (as *SipServer) pauseExhaustedCampaign(campaign *models.Campaign, budget *CampaignBudget) {
+	spent := budget.Spent()
+	logger.Warn("Campaign budget exhausted, pausing",
+		zap.Uint("campaign_id", campaign.ID),
+		zap.Float64("budget", budget.Limit()),
+		zap.Float64("spent", spent))
+	detail := map[string]interface{}{"reason": ErrBudgetExhausted.Error(), "budget": budget.Limit(), "spent": spent}
+	if _, err := as.pauseCampaign(campaign.ID, auditActorSystem, detail); err != nil {
+		logger.Error("Failed to pause campaign", zap.Uint("campaign_id", campaign.ID), zap.Error(err))
+	}
+}
+
+// chargeCampaignCall 通话结束时按费用计量结算并累加到任务记录
+func (as *SipServer) chargeCampaignCall(campaign *models.Campaign, budget *CampaignBudget, callID string) {
+	cost := budget.Finish(callID)
+	if cost <= 0 {
+		return
+	}
+	if err := models.AddCampaignSpent(as.config.Db, campaign.ID, cost); err != nil {
+		logger.Error("Failed to save campaign spent",
+			zap.Uint("campaign_id", campaign.ID),
+			zap.String("call_id", callID),
+			zap.Float64("cost", cost),
+			zap.Error(err))
+	}
+}
+
+// completeCampaign 任务结束，剩余的待拨联系人保持待拨
+func (as *SipServer) completeCampaign(campaign *models.Campaign, reason string) {
+	now := time.Now()
+	campaign.Status = models.CampaignStatusCompleted
+	campaign.CompletedAt = &now
+	if err := models.UpdateCampaign(as.config.Db, campaign); err != nil {
+		logger.Error("Failed to complete campaign", zap.Uint("campaign_id", campaign.ID), zap.Error(err))
+		return
+	}
+	as.campaigns.forget(campaign.ID)
+	logger.Info("Campaign completed",
+		zap.Uint("campaign_id", campaign.ID),
+		zap.String("name", campaign.Name),
+		zap.String("reason", reason))
+}
+
+// campaignTrunk 任务指定的中继，未指定时使用默认中继
+func (as *SipServer) campaignTrunk(campaign *models.Campaign) (*TrunkConnection, error) {
+	if campaign.TrunkID == 0 {
+		return as.trunkManager.GetDefaultTrunk()
+	}
+	return as.trunkManager.GetTrunk(campaign.TrunkID)
+}
+
+// campaignQuotas 外呼占用服务器、脚本和租户的并发名额，与呼入共享
+func (as *SipServer) campaignQuotas(script *models.AIPhoneScript) []callQuota {
+	var quotas []callQuota
+	if as.config.MaxConcurrentSessions > 0 {
+		quotas = append(quotas, callQuota{scope: QuotaScopeServer, key: serverQuotaKey, limit: as.config.MaxConcurrentSessions})
+	}
+	return append(quotas, as.scriptQuotas(script)...)
+}
+
+// dialCampaignContact 拨打联系人，接通后执行任务的脚本并等待会话结束，记录结果；
+// 失败时按结果归类在windows内安排重试，拨打次数用完或不再重试时标记为失败
+func (as *SipServer) dialCampaignContact(campaign *models.Campaign, script *models.AIPhoneScript, conn *TrunkConnection, contact *models.CampaignContact, callID string, cost *CallCostMeter, windows []callWindow) {
+	db := as.config.Db
+	now := time.Now()
+	contact.Attempts++
+	contact.LastAttemptAt = &now
+	contact.NextAttemptAt = nil
+	contact.CallID = callID
+	contact.Disposition, contact.SessionID, contact.SessionStatus, contact.Error = "", "", "", ""
+	if err := models.UpdateCampaignContact(db, contact); err != nil {
+		logger.Error("Failed to save campaign contact", zap.Uint("contact_id", contact.ID), zap.Error(err))
+	}
+
+	if err := as.trunkManager.CheckDestination(campaign.GroupID, contact.PhoneNumber); err != nil {
+		as.quotas.release(callID)
+		contact.Status = models.CampaignContactFailed
+		contact.Disposition = models.SipCallDispositionFailed
+		contact.Error = err.Error()
+		as.saveCampaignContact(campaign, contact)
+		return
+	}
+
+	session, events, unsubscribe, err := as.originateCampaignCall(campaign, script, conn.Trunk, contact, callID, cost)
+	conn.countCall(err == nil)
+	if err != nil {
+		as.quotas.release(callID)
+		as.retryCampaignContact(campaign, contact, err, windows)
+		return
+	}
+	defer unsubscribe()
+
+	contact.Status = models.CampaignContactInCall
+	contact.Disposition = models.SipCallDispositionAnswered
+	countOutcome(contact, models.SipCallDispositionAnswered)
+	contact.SessionID = session.SessionID
+	if err := models.UpdateCampaignContact(db, contact); err != nil {
+		logger.Error("Failed to save campaign contact", zap.Uint("contact_id", contact.ID), zap.Error(err))
+	}
+
+	maxWait := campaignCallDuration(script) + campaignSessionMargin
+	contact.SessionStatus = as.waitCampaignSession(session, events, maxWait)
+	contact.Status = models.CampaignContactCompleted
+	as.saveCampaignContact(campaign, contact)
+}
+
+// originateCampaignCall 通过中继呼叫联系人，接通后登记通话并启动脚本；启动前订阅监控事件，不会错过会话结束
+func (as *SipServer) originateCampaignCall(campaign *models.Campaign, script *models.AIPhoneScript, trunk *models.SIPTrunk, contact *models.CampaignContact, callID string, cost *CallCostMeter) (*ScriptSession, <-chan MonitorEvent, func(), error) {
+	callerID := campaign.CallerID
+	if callerID == "" {
+		callerID = trunk.CallerID
+	}
+	domain := trunk.Domain
+	if domain == "" {
+		domain = trunk.SIPServer
+	}
+	var from, to sip.Uri
+	if err := parseURI(fmt.Sprintf("sip:%s@%s", callerID, uriHost(domain)), &from); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid caller %q: %w", callerID, err)
+	}
+	if err := parseURI(fmt.Sprintf("sip:%s@%s:%d", contact.PhoneNumber, uriHost(trunk.SIPServer), trunk.SIPPort), &to); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid destination %q: %w", contact.PhoneNumber, err)
+	}
+	serverIP := trunk.LocalIP
+	if serverIP == "" {
+		serverIP = localIPFor(trunk.SIPServer)
+	}
+
+	logger.Info("Dialing campaign contact",
+		zap.Uint("campaign_id", campaign.ID),
+		zap.Uint("contact_id", contact.ID),
+		zap.String("call_id", callID),
+		zap.String("trunk", trunk.Name),
+		zap.String("to", to.String()),
+		zap.Int("attempt", contact.Attempts))
+
+	offer := codecPCMU
+	offer.TelephoneEvent = defaultTelephoneEventPT
+	invite := as.newCampaignInvite(callID, from, to, serverIP, offer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(campaign.RingTimeout)*time.Second)
+	res, err := as.ringBranch(ctx, invite)
+	cancel()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dialog, err := as.confirmBranch(forkResult{invite: invite, res: res})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	as.saveDialog(dialog)
+	as.startOutboundSessionTimer(dialog, res)
+	answer := &ForkAnswer{Invite: invite, Response: res, Dialog: dialog}
+
+	rtpAddr, err := referAnswerRTPAddr(res)
+	if err != nil {
+		as.hangupCall(callID)
+		return nil, nil, nil, err
+	}
+	codec, err := negotiateCodec(string(res.Body()), nil)
+	if err != nil {
+		codec = offer
+	}
+	as.saveCallCodec(callID, codec)
+	as.config.SaveActiveSession(callID, &ua.SessionInfo{
+		ClientRTPAddr: rtpAddr,
+		StopRecording: make(chan bool, 1),
+		DTMFChannel:   make(chan string, 10),
+	})
+	as.saveReferredCall(from, serverIP, answer, rtpAddr, fmt.Sprintf("campaign %d contact %d", campaign.ID, contact.ID))
+	as.startRTCP(callID, rtpAddr, codec)
+
+	events, unsubscribe := as.monitor.Subscribe()
+	session, err := as.aiEngine.startScript(scriptCall{
+		callID:       callID,
+		clientAddr:   rtpAddr.String(),
+		phoneNumber:  contact.PhoneNumber,
+		callerNumber: callerID,
+		codec:        codec,
+		script:       script,
+		trunk:        trunk,
+		variables:    campaignVariables(contact),
+		outbound:     true,
+		cost:         cost,
+	})
+	if err != nil {
+		unsubscribe()
+		as.hangupCall(callID)
+		return nil, nil, nil, fmt.Errorf("failed to start script: %w", err)
+	}
+	return session, events, unsubscribe, nil
+}
+
+// newCampaignInvite 创建经中继呼叫联系人的INVITE，不应答401/407认证质询，中继需按来源地址放行外呼
+func (as *SipServer) newCampaignInvite(callID string, from, to sip.Uri, serverIP string, codec rtpCodec) *sip.Request {
+	invite := sip.NewRequest(sip.INVITE, &to)
+
+	fromHeader := &sip.FromHeader{Address: from, Params: sip.NewParams()}
+	fromHeader.Params.Add("tag", sip.GenerateTagN(16))
+	callIDHeader := sip.CallIDHeader(callID)
+	invite.AppendHeader(fromHeader)
+	invite.AppendHeader(&sip.ToHeader{Address: to, Params: sip.NewParams()})
+	invite.AppendHeader(&callIDHeader)
+	invite.AppendHeader(&sip.CSeqHeader{SeqNo: 1, MethodName: sip.INVITE})
+	invite.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: from.User, Host: uriHost(serverIP), Port: as.config.Port}})
+
+	contentType := sip.ContentTypeHeader("application/sdp")
+	invite.AppendHeader(&contentType)
+	invite.SetBody([]byte(generateSDP(serverIP, as.config.LocalRTPPort, codec)))
+	return invite
+}
+
+// campaignVariables 联系人的变量写入会话上下文，姓名未在变量中时写入name
+func campaignVariables(contact *models.CampaignContact) map[string]string {
+	variables := make(map[string]string, len(contact.Variables)+1)
+	for key, value := range contact.Variables {
+		variables[key] = value
+	}
+	if _, exists := variables["name"]; !exists && contact.Name != "" {
+		variables["name"] = contact.Name
+	}
+	return variables
+}
+
+// waitCampaignSession 等待AI会话结束并返回其状态；超过maxWait时挂断。监控事件可能因订阅方过慢被丢弃，定期检查会话是否仍在
+func (as *SipServer) waitCampaignSession(session *ScriptSession, events <-chan MonitorEvent, maxWait time.Duration) string {
+	timeout := time.NewTimer(maxWait)
+	defer timeout.Stop()
+	ticker := time.NewTicker(campaignCheckInterval)
+	defer ticker.Stop()
+
+	status := ""
+	for status == "" {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				status = string(session.GetStatus())
+			} else if event.Type == MonitorSessionEnded && event.CallID == session.CallID {
+				status = event.Status
+			}
+		case <-ticker.C:
+			if as.aiEngine.GetSession(session.CallID) != session {
+				status = string(session.GetStatus())
+			}
+		case <-timeout.C:
+			logger.Warn("Campaign call exceeded script duration, hanging up",
+				zap.String("call_id", session.CallID),
+				zap.Duration("max_wait", maxWait))
+			as.aiEngine.StopSession(session.CallID)
+			status = string(models.SessionStatusTimeout)
+		}
+	}
+	// 脚本结束时没有挂断步骤的，由拨号方挂断
+	if _, exists := as.getDialog(session.CallID); exists {
+		as.hangupCall(session.CallID)
+	}
+	return status
+}
+
+// retryCampaignContact 按呼叫失败的结果和任务的重试规则安排在拨打时段内重试，不再重试或拨打次数用完时标记为失败
+func (as *SipServer) retryCampaignContact(campaign *models.Campaign, contact *models.CampaignContact, err error, windows []callWindow) {
+	failure := campaignFailure(err)
+	contact.Disposition = failure.Disposition
+	contact.Error = err.Error()
+	contact.Status = models.CampaignContactFailed
+	retries := countOutcome(contact, failure.Disposition) - 1
+	if contact.Attempts < campaign.MaxAttempts {
+		if delay, ok := NextRetry(campaignRetryRules(campaign), failure.Disposition, retries); ok {
+			if next, ok := nextAllowed(time.Now().Add(delay), windows...); ok {
+				contact.Status = models.CampaignContactPending
+				contact.NextAttemptAt = &next
+			}
+		}
+	}
+	as.saveCampaignContact(campaign, contact)
+	as.saveFailedCampaignCall(campaign, contact, failure)
+}
+
+// campaignFailure 呼叫失败的SIP状态和结果归类，振铃超时视为无人接听
+func campaignFailure(err error) *OutboundFailure {
+	var rejected *inviteRejectedError
+	switch {
+	case errors.As(err, &rejected):
+		code := int(rejected.res.StatusCode)
+		return &OutboundFailure{StatusCode: code, Reason: rejected.res.Reason, Disposition: models.DispositionFromSIPStatus(code)}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &OutboundFailure{StatusCode: int(sip.StatusRequestTimeout), Reason: "Request Timeout", Disposition: models.SipCallDispositionNoAnswer}
+	default:
+		return &OutboundFailure{StatusCode: int(sip.StatusServiceUnavailable), Reason: err.Error(), Disposition: models.SipCallDispositionFailed}
+	}
+}
+
+// saveFailedCampaignCall 保存未接通的外呼记录，用于通话统计
+func (as *SipServer) saveFailedCampaignCall(campaign *models.Campaign, contact *models.CampaignContact, failure *OutboundFailure) {
+	sipCall := &models.SipCall{
+		CallID:     contact.CallID,
+		Direction:  models.SipCallDirectionOutbound,
+		ToUsername: contact.PhoneNumber,
+		StartTime:  *contact.LastAttemptAt,
+		Notes:      fmt.Sprintf("campaign %d contact %d", campaign.ID, contact.ID),
+	}
+	sipCall.MarkFailedWithStatus(failure.StatusCode, failure.Reason)
+	sipCall.Disposition = failure.Disposition
+	if err := as.config.SaveCall(sipCall); err != nil {
+		logger.Error("Failed to save campaign call", zap.String("call_id", sipCall.CallID), zap.Error(err))
+	}
+}
+
+// saveCampaignContact 保存一次拨打的最终结果
+func (as *SipServer) saveCampaignContact(campaign *models.Campaign, contact *models.CampaignContact) {
+	if err := models.UpdateCampaignContact(as.config.Db, contact); err != nil {
+		logger.Error("Failed to save campaign contact", zap.Uint("contact_id", contact.ID), zap.Error(err))
+		return
+	}
+	fields := []zap.Field{
+		zap.Uint("campaign_id", campaign.ID),
+		zap.Uint("contact_id", contact.ID),
+		zap.String("call_id", contact.CallID),
+		zap.String("status", string(contact.Status)),
+		zap.String("disposition", string(contact.Disposition)),
+		zap.Int("attempts", contact.Attempts),
+	}
+	if contact.SessionStatus != "" {
+		fields = append(fields, zap.String("session_status", contact.SessionStatus))
+	}
+	if contact.NextAttemptAt != nil {
+		fields = append(fields, zap.Time("next_attempt_at", *contact.NextAttemptAt))
+	}
+	if contact.Error != "" {
+		fields = append(fields, zap.String("error", contact.Error))
+	}
+	logger.Info("Campaign contact dialed", fields...)
+}
@@ -0,0 +1,258 @@
+package sip1
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/auth"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// campaignContactForm 添加联系人的请求项
+type campaignContactForm struct {
+	PhoneNumber string                 `json:"phoneNumber" binding:"required"`
+	Name        string                 `json:"name"`
+	Variables   models.PromptVariables `json:"variables"`
+	Window      models.CallWindow      `json:"window"`      // 该联系人可接听的时段
+	ScheduledAt *time.Time             `json:"scheduledAt"` // 预约的拨打时间，为空时尽快拨打
+}
+
+func campaignContacts(forms []campaignContactForm) []models.CampaignContact {
+	contacts := make([]models.CampaignContact, 0, len(forms))
+	for _, form := range forms {
+		contacts = append(contacts, models.CampaignContact{
+			PhoneNumber:   form.PhoneNumber,
+			Name:          form.Name,
+			Variables:     form.Variables,
+			Window:        form.Window,
+			NextAttemptAt: form.ScheduledAt,
+		})
+	}
+	return contacts
+}
+
+// campaignDB 返回数据库和路径中的任务ID，失败时已写入响应
+func campaignDB(c *gin.Context, server *SipServer) (*gorm.DB, uint, bool) {
+	if server.config.Db == nil {
+		response.Fail(c, "database not configured", nil)
+		return nil, 0, false
+	}
+	if c.Param("id") == "" {
+		return server.config.Db, 0, true
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		response.Fail(c, "invalid campaign id", err.Error())
+		return nil, 0, false
+	}
+	return server.config.Db, uint(id), true
+}
+
+// RegisterCampaignAPIs 注册外呼任务接口：POST /campaigns
+// {"name":"就业调查","scriptId":1,"trunkId":0,"maxConcurrentCalls":2,"callsPerMinute":10,"ringTimeout":30,"maxAttempts":3,
+// "startAt":"2026-01-01T09:00:00+08:00","endAt":"...","window":{"startTime":"09:00","endTime":"20:00","weekDays":"1,2,3,4,5"},
+// "retryRules":{"busy":{"maxAttempts":3,"delay":300,"backoff":2},"no_answer":{"maxAttempts":2,"delay":1800,"backoff":2}},
+// "contacts":[{"phoneNumber":"13800000000","name":"张三","variables":{"city":"北京"},"window":{...},"scheduledAt":"..."}]}
+// 创建草稿任务（未设置window时使用主叫号码在脚本号码映射上的时段），GET /campaigns?status=running 列出任务，GET /campaigns/:id 查看任务及各状态联系人数，
+// POST /campaigns/:id/contacts {"contacts":[...]} 追加联系人，单个预约呼叫即只有一个联系人的任务，
+// POST /campaigns/:id/schedule {"startAt":...,"endAt":...,"window":{...},"retryRules":{...}} 修改时间安排，
+// POST /campaigns/:id/budget {"budgetLimit":200} 修改预算上限，预算用完暂停后提高上限再恢复，
+// GET /campaigns/:id/contacts?status=failed&offset=0&limit=20 查看联系人的拨打结果，
+// POST /campaigns/:id/start、/pause、/resume 开始、暂停、恢复拨号，操作均写入审计日志
+func RegisterCampaignAPIs(r gin.IRoutes, server *SipServer) {
+	r.POST("/campaigns", requireActor, func(c *gin.Context) {
+		if _, _, ok := campaignDB(c, server); !ok {
+			return
+		}
+		var form struct {
+			Name               string                    `json:"name" binding:"required"`
+			Description        string                    `json:"description"`
+			ScriptID           uint                      `json:"scriptId" binding:"required"`
+			TrunkID            uint                      `json:"trunkId"`
+			CallerID           string                    `json:"callerId"`
+			GroupID            uint                      `json:"groupId"`
+			StartAt            *time.Time                `json:"startAt"`
+			EndAt              *time.Time                `json:"endAt"`
+			MaxConcurrentCalls int                       `json:"maxConcurrentCalls"`
+			CallsPerMinute     int                       `json:"callsPerMinute"`
+			RingTimeout        int                       `json:"ringTimeout"`
+			MaxAttempts        int                       `json:"maxAttempts"`
+			BudgetLimit        float64                   `json:"budgetLimit" binding:"min=0"`
+			Window             models.CallWindow         `json:"window"`
+			RetryRules         models.CampaignRetryRules `json:"retryRules"`
+			Contacts           []campaignContactForm     `json:"contacts" binding:"dive"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		campaign := &models.Campaign{
+			Name:               form.Name,
+			Description:        form.Description,
+			ScriptID:           form.ScriptID,
+			TrunkID:            form.TrunkID,
+			CallerID:           form.CallerID,
+			GroupID:            form.GroupID,
+			StartAt:            form.StartAt,
+			EndAt:              form.EndAt,
+			MaxConcurrentCalls: form.MaxConcurrentCalls,
+			CallsPerMinute:     form.CallsPerMinute,
+			RingTimeout:        form.RingTimeout,
+			MaxAttempts:        form.MaxAttempts,
+			BudgetLimit:        form.BudgetLimit,
+			Window:             form.Window,
+			RetryRules:         form.RetryRules,
+			CreatedBy:          auth.CurrentActor(c),
+		}
+		if err := server.CreateCampaign(campaign, campaignContacts(form.Contacts)); err != nil {
+			response.Fail(c, "failed to create campaign", err.Error())
+			return
+		}
+		response.Success(c, "ok", campaign)
+	})
+
+	r.GET("/campaigns", func(c *gin.Context) {
+		db, _, ok := campaignDB(c, server)
+		if !ok {
+			return
+		}
+		campaigns, err := models.ListCampaigns(db, models.CampaignStatus(c.Query("status")))
+		if err != nil {
+			response.Fail(c, "failed to list campaigns", err.Error())
+			return
+		}
+		response.Success(c, "ok", campaigns)
+	})
+
+	r.GET("/campaigns/:id", func(c *gin.Context) {
+		db, id, ok := campaignDB(c, server)
+		if !ok {
+			return
+		}
+		campaign, err := models.GetCampaign(db, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			response.Fail(c, "campaign not found", nil)
+			return
+		}
+		if err != nil {
+			response.Fail(c, "failed to load campaign", err.Error())
+			return
+		}
+		counts, err := models.CountCampaignContacts(db, id)
+		if err != nil {
+			response.Fail(c, "failed to count campaign contacts", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"campaign": campaign, "contacts": counts})
+	})
+
+	r.POST("/campaigns/:id/contacts", requireActor, func(c *gin.Context) {
+		_, id, ok := campaignDB(c, server)
+		if !ok {
+			return
+		}
+		var form struct {
+			Contacts []campaignContactForm `json:"contacts" binding:"required,min=1,dive"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		added, err := server.AddCampaignContacts(id, campaignContacts(form.Contacts), auth.CurrentActor(c))
+		if err != nil {
+			response.Fail(c, "failed to add campaign contacts", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"added": added})
+	})
+
+	r.GET("/campaigns/:id/contacts", func(c *gin.Context) {
+		db, id, ok := campaignDB(c, server)
+		if !ok {
+			return
+		}
+		offset, _ := strconv.Atoi(c.Query("offset"))
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		if limit <= 0 {
+			limit = sipUserPageSize
+		}
+		contacts, total, err := models.ListCampaignContacts(db, id, models.CampaignContactStatus(c.Query("status")), max(offset, 0), min(limit, maxSipUserPageSize))
+		if err != nil {
+			response.Fail(c, "failed to list campaign contacts", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"items": contacts, "total": total})
+	})
+
+	r.POST("/campaigns/:id/schedule", requireActor, func(c *gin.Context) {
+		_, id, ok := campaignDB(c, server)
+		if !ok {
+			return
+		}
+		var form struct {
+			CampaignSchedule
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		campaign, err := server.UpdateCampaignSchedule(id, form.CampaignSchedule, auth.CurrentActor(c))
+		if err != nil {
+			response.Fail(c, "failed to update campaign schedule", err.Error())
+			return
+		}
+		response.Success(c, "ok", campaign)
+	})
+
+	r.POST("/campaigns/:id/budget", requireActor, func(c *gin.Context) {
+		_, id, ok := campaignDB(c, server)
+		if !ok {
+			return
+		}
+		var form struct {
+			BudgetLimit *float64 `json:"budgetLimit" binding:"required,min=0"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		campaign, err := server.UpdateCampaignBudget(id, *form.BudgetLimit, auth.CurrentActor(c))
+		if err != nil {
+			response.Fail(c, "failed to update campaign budget", err.Error())
+			return
+		}
+		response.Success(c, "ok", campaign)
+	})
+
+	start := func(c *gin.Context) {
+		_, id, ok := campaignDB(c, server)
+		if !ok {
+			return
+		}
+		campaign, err := server.StartCampaign(id, auth.CurrentActor(c))
+		if err != nil {
+			response.Fail(c, "failed to start campaign", err.Error())
+			return
+		}
+		response.Success(c, "ok", campaign)
+	}
+	r.POST("/campaigns/:id/start", requireActor, start)
+	r.POST("/campaigns/:id/resume", requireActor, start)
+
+	r.POST("/campaigns/:id/pause", requireActor, func(c *gin.Context) {
+		_, id, ok := campaignDB(c, server)
+		if !ok {
+			return
+		}
+		campaign, err := server.PauseCampaign(id, auth.CurrentActor(c))
+		if err != nil {
+			response.Fail(c, "failed to pause campaign", err.Error())
+			return
+		}
+		response.Success(c, "ok", campaign)
+	})
+}
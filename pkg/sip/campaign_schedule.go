@@ -0,0 +1,141 @@
+package sip1
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// CampaignSchedule 任务的开始和结束时间、拨打时段和重试规则
+type CampaignSchedule struct {
+	StartAt    *time.Time                `json:"startAt"`
+	EndAt      *time.Time                `json:"endAt"`
+	Window     models.CallWindow         `json:"window"`
+	RetryRules models.CampaignRetryRules `json:"retryRules"`
+}
+
+// validateCampaignSchedule 检查结束时间、拨打时段和重试规则
+func validateCampaignSchedule(campaign *models.Campaign) error {
+	if campaign.StartAt != nil && campaign.EndAt != nil && !campaign.EndAt.After(*campaign.StartAt) {
+		return errors.New("endAt must be after startAt")
+	}
+	if _, err := parseCallWindow(campaign.Window); err != nil {
+		return err
+	}
+	for disposition, rule := range campaign.RetryRules {
+		if _, known := DefaultRetryRules[disposition]; !known {
+			return fmt.Errorf("unknown disposition in retry rules: %q", disposition)
+		}
+		if rule.MaxAttempts < 0 || rule.Delay < 0 || rule.Backoff < 0 {
+			return fmt.Errorf("retry rule for %s must not be negative", disposition)
+		}
+	}
+	return nil
+}
+
+// UpdateCampaignSchedule 修改未结束任务的时间安排，运行中的任务下次检查时生效；已安排的重试时间不变
+func (as *SipServer) UpdateCampaignSchedule(id uint, schedule CampaignSchedule, actor string) (*models.Campaign, error) {
+	db := as.config.Db
+	if db == nil {
+		return nil, errors.New("database not configured")
+	}
+	campaign, err := models.GetCampaign(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if campaign.Status == models.CampaignStatusCompleted {
+		return nil, fmt.Errorf("campaign %d already completed", id)
+	}
+	campaign.StartAt, campaign.EndAt = schedule.StartAt, schedule.EndAt
+	campaign.Window, campaign.RetryRules = schedule.Window, schedule.RetryRules
+	if err := validateCampaignSchedule(campaign); err != nil {
+		return nil, err
+	}
+	if err := models.UpdateCampaign(db, campaign); err != nil {
+		return nil, err
+	}
+	as.auditCampaign(campaign, actor, "campaign.rescheduled", map[string]interface{}{"schedule": schedule})
+	return campaign, nil
+}
+
+// campaignWindow 任务的拨打时段；任务未设置时使用主叫号码在脚本号码映射上的时段
+func campaignWindow(campaign *models.Campaign, script *models.AIPhoneScript, trunk *models.SIPTrunk) (callWindow, error) {
+	window := campaign.Window
+	if window.IsZero() {
+		callerID := campaign.CallerID
+		if callerID == "" {
+			callerID = trunk.CallerID
+		}
+		for i := range script.PhoneMappings {
+			if mapping := &script.PhoneMappings[i]; mapping.Enabled && mapping.PhoneNumber == callerID {
+				window = mapping.CallWindow()
+				break
+			}
+		}
+	}
+	return parseCallWindow(window)
+}
+
+// deferCampaignContact 联系人当前不在自己的时段内时推迟到任务和联系人时段都允许的最早时间，返回false；
+// 可拨打时返回安排重试需满足的时段
+func (as *SipServer) deferCampaignContact(campaign *models.Campaign, contact *models.CampaignContact, window callWindow, now time.Time) ([]callWindow, bool) {
+	own, err := parseCallWindow(contact.Window)
+	if err != nil {
+		// 创建时已校验，数据库被直接修改时忽略联系人的时段
+		logger.Warn("Invalid contact calling window", zap.Uint("contact_id", contact.ID), zap.Error(err))
+		return []callWindow{window}, true
+	}
+	windows := []callWindow{window, own}
+	if own.allows(now) {
+		return windows, true
+	}
+
+	contact.Status = models.CampaignContactPending
+	next, ok := nextAllowed(now, windows...)
+	if ok {
+		contact.NextAttemptAt = &next
+	} else {
+		contact.Status = models.CampaignContactFailed
+		contact.Error = "contact window never overlaps campaign window"
+	}
+	if err := models.UpdateCampaignContact(as.config.Db, contact); err != nil {
+		logger.Error("Failed to defer campaign contact", zap.Uint("contact_id", contact.ID), zap.Error(err))
+	}
+	logger.Debug("Campaign contact outside calling window",
+		zap.Uint("campaign_id", campaign.ID),
+		zap.Uint("contact_id", contact.ID),
+		zap.Timep("next_attempt_at", contact.NextAttemptAt))
+	return nil, false
+}
+
+// countOutcome 记录一次通话结果，返回该结果累计的次数
+func countOutcome(contact *models.CampaignContact, disposition models.SipCallDisposition) int {
+	if contact.Outcomes == nil {
+		contact.Outcomes = make(models.DispositionCounts)
+	}
+	contact.Outcomes[disposition]++
+	return contact.Outcomes[disposition]
+}
+
+// campaignRetryRules 默认重试规则叠加任务按通话结果配置的规则
+func campaignRetryRules(campaign *models.Campaign) map[models.SipCallDisposition]RetryRule {
+	if len(campaign.RetryRules) == 0 {
+		return DefaultRetryRules
+	}
+	rules := make(map[models.SipCallDisposition]RetryRule, len(DefaultRetryRules))
+	for disposition, rule := range DefaultRetryRules {
+		rules[disposition] = rule
+	}
+	for disposition, rule := range campaign.RetryRules {
+		rules[disposition] = RetryRule{
+			MaxAttempts: rule.MaxAttempts,
+			Delay:       time.Duration(rule.Delay) * time.Second,
+			Backoff:     rule.Backoff,
+		}
+	}
+	return rules
+}
@@ -0,0 +1,114 @@
+package sip1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+)
+
+func TestValidateCampaignSchedule(t *testing.T) {
+	start := time.Date(2026, 1, 5, 9, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+	tests := []struct {
+		name     string
+		campaign models.Campaign
+		wantErr  bool
+	}{
+		{name: "empty", campaign: models.Campaign{}},
+		{name: "start and end", campaign: models.Campaign{StartAt: &start, EndAt: &end}},
+		{name: "end only", campaign: models.Campaign{EndAt: &end}},
+		{name: "end before start", campaign: models.Campaign{StartAt: &end, EndAt: &start}, wantErr: true},
+		{name: "end equals start", campaign: models.Campaign{StartAt: &start, EndAt: &start}, wantErr: true},
+		{name: "invalid window", campaign: models.Campaign{Window: models.CallWindow{StartTime: "25:00"}}, wantErr: true},
+		{name: "known disposition", campaign: models.Campaign{RetryRules: models.CampaignRetryRules{models.SipCallDispositionBusy: {MaxAttempts: 2, Delay: 60, Backoff: 2}}}},
+		{name: "unknown disposition", campaign: models.Campaign{RetryRules: models.CampaignRetryRules{"voicemail": {MaxAttempts: 1}}}, wantErr: true},
+		{name: "negative delay", campaign: models.Campaign{RetryRules: models.CampaignRetryRules{models.SipCallDispositionBusy: {MaxAttempts: 1, Delay: -1}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateCampaignSchedule(&tt.campaign); (err != nil) != tt.wantErr {
+				t.Errorf("validateCampaignSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCampaignRetryRules(t *testing.T) {
+	rules := campaignRetryRules(&models.Campaign{})
+	if rules[models.SipCallDispositionBusy] != DefaultRetryRules[models.SipCallDispositionBusy] {
+		t.Errorf("rules without overrides = %+v", rules)
+	}
+
+	campaign := &models.Campaign{RetryRules: models.CampaignRetryRules{
+		models.SipCallDispositionBusy:     {MaxAttempts: 1, Delay: 90, Backoff: 1},
+		models.SipCallDispositionRejected: {MaxAttempts: 2, Delay: 3600},
+	}}
+	rules = campaignRetryRules(campaign)
+	if got := rules[models.SipCallDispositionBusy]; got != (RetryRule{MaxAttempts: 1, Delay: 90 * time.Second, Backoff: 1}) {
+		t.Errorf("busy rule = %+v", got)
+	}
+	if _, ok := NextRetry(rules, models.SipCallDispositionRejected, 1); !ok {
+		t.Error("overridden rejected rule not applied")
+	}
+	// 未覆盖的结果仍使用默认规则，默认规则本身不被修改
+	if rules[models.SipCallDispositionNoAnswer] != DefaultRetryRules[models.SipCallDispositionNoAnswer] {
+		t.Errorf("no_answer rule = %+v", rules[models.SipCallDispositionNoAnswer])
+	}
+	if DefaultRetryRules[models.SipCallDispositionBusy].Delay != 5*time.Minute {
+		t.Error("campaign overrides changed DefaultRetryRules")
+	}
+}
+
+func TestUpdateCampaignSchedule(t *testing.T) {
+	server := newCampaignTestServer(t)
+	campaign := createTestCampaign(t, server, &models.Campaign{}, "13800000000")
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	end := start.Add(24 * time.Hour)
+	schedule := CampaignSchedule{
+		StartAt:    &start,
+		EndAt:      &end,
+		Window:     models.CallWindow{StartTime: "09:00", EndTime: "18:00", WeekDays: "1,2,3,4,5"},
+		RetryRules: models.CampaignRetryRules{models.SipCallDispositionNoAnswer: {MaxAttempts: 1, Delay: 600}},
+	}
+	if _, err := server.UpdateCampaignSchedule(campaign.ID, schedule, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := models.GetCampaign(server.config.Db, campaign.ID)
+	if err != nil || !stored.StartAt.Equal(start) || !stored.EndAt.Equal(end) || stored.Window != schedule.Window || stored.RetryRules[models.SipCallDispositionNoAnswer].Delay != 600 {
+		t.Fatalf("stored schedule = %+v, %v", stored, err)
+	}
+
+	invalid := schedule
+	invalid.EndAt = &start
+	if _, err := server.UpdateCampaignSchedule(campaign.ID, invalid, "alice"); err == nil {
+		t.Error("UpdateCampaignSchedule() with endAt == startAt expected error")
+	}
+	server.completeCampaign(stored, "test")
+	if _, err := server.UpdateCampaignSchedule(campaign.ID, schedule, "alice"); err == nil {
+		t.Error("UpdateCampaignSchedule() on a completed campaign expected error")
+	}
+
+	var actions []string
+	server.config.Db.Model(&models.AuditLog{}).Where("action = ?", "campaign.rescheduled").Pluck("actor", &actions)
+	if len(actions) != 1 || actions[0] != "alice" {
+		t.Errorf("reschedule audit actors = %v", actions)
+	}
+}
+
+func TestCampaignContactsWindowValidated(t *testing.T) {
+	server := newCampaignTestServer(t)
+	campaign := createTestCampaign(t, server, &models.Campaign{}, "13800000000")
+	contacts := []models.CampaignContact{{PhoneNumber: " 13900000000 ", Window: models.CallWindow{StartTime: "19:00", EndTime: "21:00"}}}
+	if added, err := server.AddCampaignContacts(campaign.ID, contacts, "alice"); err != nil || added != 1 || contacts[0].PhoneNumber != "13900000000" {
+		t.Fatalf("AddCampaignContacts() = %d, %v (%q)", added, err, contacts[0].PhoneNumber)
+	}
+	for _, contacts := range [][]models.CampaignContact{
+		{{PhoneNumber: "13900000001", Window: models.CallWindow{WeekDays: "8"}}},
+		{{PhoneNumber: "  "}},
+	} {
+		if _, err := server.AddCampaignContacts(campaign.ID, contacts, "alice"); err == nil {
+			t.Errorf("AddCampaignContacts(%+v) expected error", contacts)
+		}
+	}
+}
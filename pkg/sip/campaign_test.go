@@ -0,0 +1,458 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+)
+
+func newCampaignTestServer(t *testing.T) *SipServer {
+	t.Helper()
+	db := newTestDB(t, &models.Campaign{}, &models.CampaignContact{}, &models.AIPhoneScript{}, &models.AIPhoneScriptStep{},
+		&models.ScriptPhoneMapping{}, &models.AuditLog{}, &models.DoNotCallEntry{}, &models.DoNotCallSuppression{})
+	config := ua.DefaultUAConfig()
+	config.Db = db
+	config.StorageType = ua.StorageTypeMemory
+	config.StoragePath = t.TempDir()
+	return &SipServer{
+		config:       config,
+		aiEngine:     &AIPhoneEngine{},
+		trunkManager: &TrunkManager{db: db, trunks: make(map[uint]*TrunkConnection), dialPolicy: NewDialPolicy()},
+		campaigns:    newCampaignDialer(),
+		quotas:       newCallQuotas(),
+	}
+}
+
+// createTestCampaign 保存激活的脚本和草稿任务，返回任务
+func createTestCampaign(t *testing.T, server *SipServer, campaign *models.Campaign, phones ...string) *models.Campaign {
+	t.Helper()
+	script := testScript("campaign", 60000, calloutStep("start", ""))
+	if err := models.CreateAIPhoneScript(server.config.Db, script); err != nil {
+		t.Fatal(err)
+	}
+	campaign.Name = "survey"
+	campaign.ScriptID = script.ID
+	contacts := make([]models.CampaignContact, len(phones))
+	for i, phone := range phones {
+		contacts[i].PhoneNumber = phone
+	}
+	if err := server.CreateCampaign(campaign, contacts); err != nil {
+		t.Fatal(err)
+	}
+	return campaign
+}
+
+func contactsByStatus(t *testing.T, server *SipServer, campaignID uint) map[models.CampaignContactStatus]int64 {
+	t.Helper()
+	counts, err := models.CountCampaignContacts(server.config.Db, campaignID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
+
+func TestCampaignWindow(t *testing.T) {
+	officeHours := models.CallWindow{StartTime: "09:00", EndTime: "18:00", WeekDays: "1,2,3,4,5"}
+	evening := models.CallWindow{StartTime: "19:00", EndTime: "21:00"}
+	script := &models.AIPhoneScript{PhoneMappings: []models.ScriptPhoneMapping{
+		{PhoneNumber: "4000", Enabled: true, StartTime: "19:00", EndTime: "21:00"},
+		{PhoneNumber: "4001", Enabled: false, StartTime: "09:00", EndTime: "10:00"},
+	}}
+	monday := func(clock string) time.Time {
+		t, _ := time.ParseInLocation("2006-01-02 15:04", "2026-01-05 "+clock, time.Local)
+		return t
+	}
+	tests := []struct {
+		name     string
+		campaign models.Campaign
+		trunk    models.SIPTrunk
+		allowed  []time.Time
+		denied   []time.Time
+	}{
+		{
+			name:     "campaign window",
+			campaign: models.Campaign{CallerID: "4000", Window: officeHours},
+			allowed:  []time.Time{monday("09:00"), monday("17:59")},
+			denied:   []time.Time{monday("08:59"), monday("18:00"), monday("09:00").AddDate(0, 0, 5)},
+		},
+		{
+			name:     "caller id mapping",
+			campaign: models.Campaign{CallerID: "4000"},
+			allowed:  []time.Time{monday("19:30")},
+			denied:   []time.Time{monday("10:00")},
+		},
+		{
+			name:    "trunk caller id mapping",
+			trunk:   models.SIPTrunk{CallerID: "4000"},
+			allowed: []time.Time{monday("20:59")},
+			denied:  []time.Time{monday("21:00")},
+		},
+		{
+			name:     "disabled mapping ignored",
+			campaign: models.Campaign{CallerID: "4001"},
+			allowed:  []time.Time{monday("03:00"), monday("12:00")},
+		},
+		{
+			name:     "explicit window wins over mapping",
+			campaign: models.Campaign{CallerID: "4000", Window: evening},
+			allowed:  []time.Time{monday("19:00")},
+			denied:   []time.Time{monday("22:00")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, err := campaignWindow(&tt.campaign, script, &tt.trunk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, at := range tt.allowed {
+				if !window.allows(at) {
+					t.Errorf("allows(%v) = false", at)
+				}
+			}
+			for _, at := range tt.denied {
+				if window.allows(at) {
+					t.Errorf("allows(%v) = true", at)
+				}
+			}
+		})
+	}
+}
+
+func TestDeferCampaignContact(t *testing.T) {
+	server := newCampaignTestServer(t)
+	window, _ := parseCallWindow(models.CallWindow{StartTime: "09:00", EndTime: "18:00", WeekDays: "1,2,3,4,5"})
+	at := func(date, clock string) time.Time {
+		t, _ := time.ParseInLocation("2006-01-02 15:04", date+" "+clock, time.Local)
+		return t
+	}
+	tests := []struct {
+		name    string
+		window  models.CallWindow
+		now     time.Time
+		dial    bool
+		status  models.CampaignContactStatus
+		next    time.Time
+		windows int
+	}{
+		{name: "inside both windows", window: models.CallWindow{StartTime: "14:00", EndTime: "16:00"}, now: at("2026-01-05", "15:00"), dial: true, windows: 2},
+		{name: "no contact window", now: at("2026-01-05", "10:00"), dial: true, windows: 2},
+		{name: "later the same day", window: models.CallWindow{StartTime: "14:00", EndTime: "16:00"}, now: at("2026-01-05", "10:00"), status: models.CampaignContactPending, next: at("2026-01-05", "14:00")},
+		// 周五16点之后推迟到下周一，周末不在任务时段内
+		{name: "after friday window", window: models.CallWindow{StartTime: "14:00", EndTime: "16:00"}, now: at("2026-01-09", "17:00"), status: models.CampaignContactPending, next: at("2026-01-12", "14:00")},
+		{name: "evening never overlaps", window: models.CallWindow{StartTime: "19:00", EndTime: "20:00"}, now: at("2026-01-05", "10:00"), status: models.CampaignContactFailed},
+		{name: "weekend never overlaps", window: models.CallWindow{WeekDays: "6,7"}, now: at("2026-01-05", "10:00"), status: models.CampaignContactFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contact := &models.CampaignContact{CampaignID: 1, PhoneNumber: "13800000000", Window: tt.window, Status: models.CampaignContactDialing}
+			windows, dial := server.deferCampaignContact(&models.Campaign{ID: 1}, contact, window, tt.now)
+			if dial != tt.dial || len(windows) != tt.windows {
+				t.Fatalf("deferCampaignContact() = %d windows, %v, want %d, %v", len(windows), dial, tt.windows, tt.dial)
+			}
+			if dial {
+				return
+			}
+			if contact.Status != tt.status {
+				t.Errorf("status = %s, want %s", contact.Status, tt.status)
+			}
+			switch {
+			case tt.next.IsZero() && contact.NextAttemptAt != nil:
+				t.Errorf("NextAttemptAt = %v, want none", contact.NextAttemptAt)
+			case !tt.next.IsZero() && (contact.NextAttemptAt == nil || !contact.NextAttemptAt.Equal(tt.next)):
+				t.Errorf("NextAttemptAt = %v, want %v", contact.NextAttemptAt, tt.next)
+			}
+		})
+	}
+}
+
+func TestRetryCampaignContact(t *testing.T) {
+	server := newCampaignTestServer(t)
+	rejected := func(code sip.StatusCode) error {
+		return &inviteRejectedError{res: sip.NewResponseFromRequest(newTestRequest(t, sip.INVITE, "10.0.0.1:5060", ""), code, "", nil)}
+	}
+	soon := time.Now().Add(3 * time.Hour)
+	later := soon.Add(time.Hour)
+	window, _ := parseCallWindow(models.CallWindow{StartTime: soon.Format("15:04"), EndTime: later.Format("15:04")})
+	tests := []struct {
+		name     string
+		campaign models.Campaign
+		outcomes models.DispositionCounts
+		attempts int
+		err      error
+		windows  []callWindow
+		want     models.SipCallDisposition
+		delay    time.Duration // 期望的重试间隔，0表示不再重试
+	}{
+		{name: "busy first retry", err: rejected(sip.StatusBusyHere), want: models.SipCallDispositionBusy, delay: 5 * time.Minute},
+		{name: "busy backoff", outcomes: models.DispositionCounts{models.SipCallDispositionBusy: 1}, attempts: 2, err: rejected(sip.StatusBusyHere), want: models.SipCallDispositionBusy, delay: 10 * time.Minute},
+		{name: "busy retries used up", outcomes: models.DispositionCounts{models.SipCallDispositionBusy: 3}, err: rejected(sip.StatusBusyHere), want: models.SipCallDispositionBusy},
+		{name: "ring timeout is no answer", err: context.DeadlineExceeded, want: models.SipCallDispositionNoAnswer, delay: 30 * time.Minute},
+		{name: "rejected not retried", err: rejected(603), want: models.SipCallDispositionRejected},
+		{name: "transport error", err: errors.New("network unreachable"), want: models.SipCallDispositionFailed, delay: 5 * time.Minute},
+		{name: "attempts used up", attempts: 3, err: rejected(sip.StatusBusyHere), want: models.SipCallDispositionBusy},
+		{
+			name:     "campaign rule overrides default",
+			campaign: models.Campaign{RetryRules: models.CampaignRetryRules{models.SipCallDispositionBusy: {MaxAttempts: 5, Delay: 60, Backoff: 3}}},
+			outcomes: models.DispositionCounts{models.SipCallDispositionBusy: 2},
+			err:      rejected(sip.StatusBusyHere),
+			want:     models.SipCallDispositionBusy,
+			delay:    9 * time.Minute,
+		},
+		{name: "retry moved into window", err: rejected(sip.StatusBusyHere), windows: []callWindow{window}, want: models.SipCallDispositionBusy, delay: time.Until(soon)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.campaign.ID, tt.campaign.MaxAttempts = 1, 3
+			attempted := time.Now()
+			contact := &models.CampaignContact{
+				CampaignID:    1,
+				PhoneNumber:   "13800000000",
+				Status:        models.CampaignContactDialing,
+				Attempts:      max(tt.attempts, 1),
+				Outcomes:      tt.outcomes,
+				LastAttemptAt: &attempted,
+				CallID:        "call-" + tt.name,
+			}
+			server.retryCampaignContact(&tt.campaign, contact, tt.err, tt.windows)
+			if contact.Disposition != tt.want || contact.Error != tt.err.Error() {
+				t.Errorf("disposition = %s (%s), want %s", contact.Disposition, contact.Error, tt.want)
+			}
+			if tt.delay == 0 {
+				if contact.Status != models.CampaignContactFailed || contact.NextAttemptAt != nil {
+					t.Errorf("status = %s, next = %v, want failed without retry", contact.Status, contact.NextAttemptAt)
+				}
+				return
+			}
+			if contact.Status != models.CampaignContactPending || contact.NextAttemptAt == nil {
+				t.Fatalf("status = %s, next = %v, want a pending retry", contact.Status, contact.NextAttemptAt)
+			}
+			// 时段按分钟开始，允许一分钟的误差
+			if got := contact.NextAttemptAt.Sub(attempted); got < tt.delay-time.Minute || got > tt.delay+time.Second {
+				t.Errorf("retry after %v, want %v", got, tt.delay)
+			}
+		})
+	}
+}
+
+func TestCampaignPauseResume(t *testing.T) {
+	server := newCampaignTestServer(t)
+	server.stopChan = make(chan struct{})
+	t.Cleanup(func() { close(server.stopChan) })
+	campaign := createTestCampaign(t, server, &models.Campaign{}, "13800000000")
+	if campaign.Status != models.CampaignStatusDraft || campaign.MaxConcurrentCalls != defaultCampaignConcurrency {
+		t.Fatalf("created campaign = %+v", campaign)
+	}
+	running := func() bool {
+		server.campaigns.mutex.Lock()
+		defer server.campaigns.mutex.Unlock()
+		_, ok := server.campaigns.runs[campaign.ID]
+		return ok
+	}
+
+	if _, err := server.PauseCampaign(campaign.ID, "alice"); err == nil {
+		t.Error("pausing a draft campaign expected error")
+	}
+	started, err := server.StartCampaign(campaign.ID, "alice")
+	if err != nil || started.Status != models.CampaignStatusRunning || started.StartedAt == nil || !running() {
+		t.Fatalf("StartCampaign() = %+v, %v", started, err)
+	}
+	if _, err := server.StartCampaign(campaign.ID, "alice"); err == nil {
+		t.Error("starting a running campaign expected error")
+	}
+
+	paused, err := server.PauseCampaign(campaign.ID, "bob")
+	if err != nil || paused.Status != models.CampaignStatusPaused || running() {
+		t.Fatalf("PauseCampaign() = %+v, %v (running %v)", paused, err, running())
+	}
+	// 恢复时保留首次开始时间
+	resumed, err := server.StartCampaign(campaign.ID, "alice")
+	if err != nil || resumed.Status != models.CampaignStatusRunning || !resumed.StartedAt.Equal(*started.StartedAt) || !running() {
+		t.Fatalf("resume = %+v, %v", resumed, err)
+	}
+	if _, err := server.PauseCampaign(campaign.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 脚本不再是激活状态时不能恢复
+	server.config.Db.Model(&models.AIPhoneScript{}).Where("id = ?", campaign.ScriptID).Update("status", models.ScriptStatusDraft)
+	if _, err := server.StartCampaign(campaign.ID, "alice"); err == nil || running() {
+		t.Errorf("resume with inactive script error = %v", err)
+	}
+
+	var actions []string
+	server.config.Db.Model(&models.AuditLog{}).Order("id").Pluck("action", &actions)
+	want := "campaign.created,campaign.started,campaign.paused,campaign.resumed,campaign.paused"
+	if strings.Join(actions, ",") != want {
+		t.Errorf("audit actions = %v, want %s", actions, want)
+	}
+
+	server.completeCampaign(paused, "test")
+	if _, err := server.StartCampaign(campaign.ID, "alice"); err == nil {
+		t.Error("starting a completed campaign expected error")
+	}
+	if _, err := server.AddCampaignContacts(campaign.ID, []models.CampaignContact{{PhoneNumber: "13900000000"}}, "alice"); err == nil {
+		t.Error("adding contacts to a completed campaign expected error")
+	}
+}
+
+// silentPeer 只接收INVITE不应答的中继，返回收到的Call-ID
+func silentPeer(t *testing.T) (int, <-chan string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	invites := make(chan string, 64)
+	go func() {
+		seen := make(map[string]bool)
+		buf := make([]byte, 65535)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			msg := string(buf[:n])
+			if !strings.HasPrefix(msg, "INVITE ") {
+				continue
+			}
+			for _, line := range strings.Split(msg, "\r\n") {
+				if callID, ok := strings.CutPrefix(line, "Call-ID: "); ok && !seen[callID] {
+					seen[callID] = true
+					invites <- callID
+				}
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port, invites
+}
+
+func TestDialDueContactsConcurrency(t *testing.T) {
+	server := newCampaignTestServer(t)
+	userAgent, err := sipgo.NewUA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { userAgent.Close() })
+	if server.client, err = sipgo.NewClient(userAgent, sipgo.WithClientHostname("127.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	server.config.TransactionTimeout = 50 * time.Millisecond
+	port, invites := silentPeer(t)
+	conn := &TrunkConnection{Trunk: &models.SIPTrunk{Name: "silent", SIPServer: "127.0.0.1", SIPPort: port, LocalIP: "127.0.0.1", CallerID: "4000"}}
+
+	outside := time.Now().Add(12 * time.Hour)
+	tests := []struct {
+		name        string
+		campaign    models.Campaign
+		scriptLimit int
+		want        int // 一次检查发起的呼叫数
+	}{
+		{name: "max concurrent calls", campaign: models.Campaign{MaxConcurrentCalls: 2}, want: 2},
+		{name: "calls per minute", campaign: models.Campaign{MaxConcurrentCalls: 3, CallsPerMinute: 1}, want: 1},
+		{name: "script quota", campaign: models.Campaign{MaxConcurrentCalls: 3}, scriptLimit: 1, want: 1},
+		{
+			name: "outside calling window",
+			campaign: models.Campaign{MaxConcurrentCalls: 2, Window: models.CallWindow{
+				StartTime: outside.Format("15:04"), EndTime: outside.Add(time.Minute).Format("15:04"),
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.campaign.RingTimeout = 1
+			campaign := createTestCampaign(t, server, &tt.campaign, "13800000001", "13800000002", "13800000003", "13800000004")
+			script, err := models.GetAIPhoneScriptByID(server.config.Db, campaign.ScriptID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.scriptLimit > 0 {
+				server.config.Db.Model(script).Update("max_concurrent_calls", tt.scriptLimit)
+			}
+
+			var active atomic.Int32
+			var lastDial time.Time
+			for i := 0; i < 2; i++ {
+				if server.dialDueContacts(campaign, conn, &active, &lastDial, time.Now()) {
+					t.Fatal("dialDueContacts() ended the campaign with contacts left")
+				}
+			}
+			if counts := contactsByStatus(t, server, campaign.ID); int(active.Load()) != tt.want || counts[models.CampaignContactDialing] != int64(tt.want) {
+				t.Fatalf("active = %d, contacts = %v, want %d dialing", active.Load(), counts, tt.want)
+			}
+			for i := 0; i < tt.want; i++ {
+				select {
+				case <-invites:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("trunk received %d INVITEs, want %d", i, tt.want)
+				}
+			}
+
+			// 振铃超时后按无人接听安排重试，名额释放
+			deadline := time.Now().Add(10 * time.Second)
+			for active.Load() > 0 && time.Now().Before(deadline) {
+				time.Sleep(20 * time.Millisecond)
+			}
+			if active.Load() != 0 {
+				t.Fatalf("%d calls still active after the ring timeout", active.Load())
+			}
+			contacts, _, err := models.ListCampaignContacts(server.config.Db, campaign.ID, models.CampaignContactPending, 0, 10)
+			if err != nil || len(contacts) != 4 {
+				t.Fatalf("pending contacts = %d, %v", len(contacts), err)
+			}
+			retried := 0
+			for _, contact := range contacts {
+				if contact.Attempts == 0 {
+					continue
+				}
+				retried++
+				if contact.Disposition != models.SipCallDispositionNoAnswer || contact.NextAttemptAt == nil || time.Until(*contact.NextAttemptAt) < 29*time.Minute {
+					t.Errorf("retried contact = %+v", contact)
+				}
+			}
+			if retried != tt.want {
+				t.Errorf("%d contacts retried, want %d", retried, tt.want)
+			}
+			for _, usage := range server.quotas.snapshot() {
+				if usage.Active != 0 {
+					t.Errorf("quota %+v still held", usage)
+				}
+			}
+		})
+	}
+}
+
+func TestDialDueContactsCompletes(t *testing.T) {
+	server := newCampaignTestServer(t)
+	campaign := createTestCampaign(t, server, &models.Campaign{}, "13800000000")
+	conn := &TrunkConnection{Trunk: &models.SIPTrunk{}}
+	server.campaigns.budget(campaign)
+
+	var active atomic.Int32
+	var lastDial time.Time
+	// 还有进行中的呼叫时不结束
+	server.config.Db.Model(&models.CampaignContact{}).Where("campaign_id = ?", campaign.ID).Update("status", models.CampaignContactInCall)
+	active.Store(1)
+	if server.dialDueContacts(campaign, conn, &active, &lastDial, time.Now()) {
+		t.Fatal("campaign ended with a call in progress")
+	}
+	active.Store(0)
+	server.config.Db.Model(&models.CampaignContact{}).Where("campaign_id = ?", campaign.ID).Update("status", models.CampaignContactCompleted)
+	if !server.dialDueContacts(campaign, conn, &active, &lastDial, time.Now()) {
+		t.Fatal("campaign not ended after every contact was dialed")
+	}
+	stored, err := models.GetCampaign(server.config.Db, campaign.ID)
+	if err != nil || stored.Status != models.CampaignStatusCompleted || stored.CompletedAt == nil {
+		t.Errorf("campaign = %+v, %v", stored, err)
+	}
+}
@@ -0,0 +1,95 @@
+package sip1
+
+import (
+	"context"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// chatReplyWait 接口等待脚本回复的最长时间，超时后返回已有的回复，其余回复随下一条消息返回
+const chatReplyWait = 30 * time.Second
+
+// RegisterChatAPIs 注册文字会话接口，供网页客服、微信公众号等渠道复用电话脚本：
+// POST /chat/sessions {"number":"4001","user":"openid"} 按号码对应的脚本开始会话并返回开场回复，
+// POST /chat/sessions/:id/messages {"text":"..."} 发送用户消息并返回脚本的回复，DELETE /chat/sessions/:id 结束会话；
+// 回复中ended为true表示脚本已结束
+func RegisterChatAPIs(r gin.IRoutes, server *SipServer) {
+	engine := func(c *gin.Context) (*AIPhoneEngine, bool) {
+		engine := server.GetAIPhoneEngine()
+		if engine == nil {
+			response.Fail(c, "AI phone engine not configured", nil)
+			return nil, false
+		}
+		return engine, true
+	}
+	replies := func(c *gin.Context, conversation *TextConversation) gin.H {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), chatReplyWait)
+		defer cancel()
+		replies, ended := conversation.Next(ctx)
+		if replies == nil {
+			replies = []string{}
+		}
+		return gin.H{"sessionId": conversation.CallID, "replies": replies, "ended": ended}
+	}
+
+	r.POST("/chat/sessions", func(c *gin.Context) {
+		engine, ok := engine(c)
+		if !ok {
+			return
+		}
+		var form struct {
+			Number string `json:"number" binding:"required"`
+			User   string `json:"user"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		conversation, err := engine.StartText(form.Number, TextOptions{User: form.User})
+		if err != nil {
+			response.Fail(c, "failed to start chat", err.Error())
+			return
+		}
+		response.Success(c, "ok", replies(c, conversation))
+	})
+
+	r.POST("/chat/sessions/:id/messages", func(c *gin.Context) {
+		engine, ok := engine(c)
+		if !ok {
+			return
+		}
+		var form struct {
+			Text string `json:"text" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		conversation := engine.TextConversation(c.Param("id"))
+		if conversation == nil {
+			response.Fail(c, "chat session not found", nil)
+			return
+		}
+		if err := conversation.Send(form.Text); err != nil {
+			response.Fail(c, "failed to send message", err.Error())
+			return
+		}
+		response.Success(c, "ok", replies(c, conversation))
+	})
+
+	r.DELETE("/chat/sessions/:id", func(c *gin.Context) {
+		engine, ok := engine(c)
+		if !ok {
+			return
+		}
+		conversation := engine.TextConversation(c.Param("id"))
+		if conversation == nil {
+			response.Fail(c, "chat session not found", nil)
+			return
+		}
+		conversation.End()
+		response.Success(c, "ok", nil)
+	})
+}
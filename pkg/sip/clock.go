@@ -0,0 +1,34 @@
+package sip1
+
+import "time"
+
+// Clock 引擎使用的时钟，测试中替换为手动推进的时钟以确定性地推进重试、超时和静音判断
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+// systemClock 系统时钟
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (systemClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+// SetClock 替换引擎时钟
+func (engine *AIPhoneEngine) SetClock(clock Clock) {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+	engine.clock = clock
+}
+
+// getClock 返回引擎时钟，未设置时为系统时钟
+func (engine *AIPhoneEngine) getClock() Clock {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+	if engine.clock == nil {
+		return systemClock{}
+	}
+	return engine.clock
+}
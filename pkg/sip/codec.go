@@ -0,0 +1,343 @@
+package sip1
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/media"
+	"github.com/LingByte/LingSIP/pkg/media/encoder"
+	"github.com/pion/sdp/v3"
+)
+
+// rtpCodec 通话协商出的RTP编解码参数
+type rtpCodec struct {
+	Name        string // 编解码注册名（pcmu、pcma、g722、opus）
+	PayloadType uint8
+	ClockRate   int // RTP时间戳时钟频率
+	Channels    int // SDP中声明的声道数
+	SampleRate  int // 编解码使用的PCM采样率
+
+	TelephoneEvent      uint8 // offer中telephone-event的载荷类型，0表示对端未提供
+	TelephoneEventClock int   // telephone-event的RTP时钟频率
+
+	ComfortNoise uint8 // offer中CN（RFC 3389）的载荷类型，0表示对端未提供
+}
+
+// codecPCMU G.711 μ-law，所有终端都支持的兜底编码
+var codecPCMU = rtpCodec{Name: encoder.CodecPCMU, PayloadType: 0, ClockRate: 8000, Channels: 1, SampleRate: 8000}
+
+// codecPCMA G.711 A-law
+var codecPCMA = rtpCodec{Name: encoder.CodecPCMA, PayloadType: 8, ClockRate: 8000, Channels: 1, SampleRate: 8000}
+
+// codecG722 G.722宽带编码，按RFC 3551 RTP时钟仍声明为8000，实际PCM为16kHz
+var codecG722 = rtpCodec{Name: encoder.CodecG722, PayloadType: 9, ClockRate: 8000, Channels: 1, SampleRate: 16000}
+
+// codecCN RFC 3389舒适噪音，只用于判断offer是否支持，不作为音频编码协商
+var codecCN = rtpCodec{Name: "cn", PayloadType: 13, ClockRate: 8000, Channels: 1, SampleRate: 8000}
+
+// newOpusCodec Opus使用动态载荷类型，SDP固定声明opus/48000/2，内部按16kHz单声道编解码供ASR使用
+func newOpusCodec(payloadType uint8) rtpCodec {
+	return rtpCodec{Name: encoder.CodecOPUS, PayloadType: payloadType, ClockRate: 48000, Channels: 2, SampleRate: 16000}
+}
+
+// newLinearCodec 嵌入式通话内部使用的16位小端线性PCM，不经过网络，不参与SDP协商
+func newLinearCodec(sampleRate int) rtpCodec {
+	return rtpCodec{
+		Name:                encoder.CodecPCM,
+		PayloadType:         96,
+		ClockRate:           sampleRate,
+		Channels:            1,
+		SampleRate:          sampleRate,
+		TelephoneEvent:      defaultTelephoneEventPT,
+		TelephoneEventClock: sampleRate,
+	}
+}
+
+// IsWideband 是否为宽带编码
+func (c rtpCodec) IsWideband() bool {
+	return c.SampleRate > 8000
+}
+
+// FrameSamples 每20ms帧的PCM样本数
+func (c rtpCodec) FrameSamples() int {
+	return c.SampleRate / 50
+}
+
+// rtpmap SDP a=rtpmap 属性值
+func (c rtpCodec) rtpmap() string {
+	name := strings.ToUpper(c.Name)
+	if c.Name == encoder.CodecOPUS {
+		name = "opus"
+	}
+	return fmt.Sprintf("%d %s/%d/%d", c.PayloadType, name, c.ClockRate, c.Channels)
+}
+
+// comfortNoiseRTPMap CN的 a=rtpmap 属性值
+func (c rtpCodec) comfortNoiseRTPMap() string {
+	return fmt.Sprintf("%d CN/%d", c.ComfortNoise, c.ClockRate)
+}
+
+// telephoneEventRTPMap telephone-event的 a=rtpmap 属性值
+func (c rtpCodec) telephoneEventRTPMap() string {
+	clock := c.TelephoneEventClock
+	if clock == 0 {
+		clock = 8000
+	}
+	return fmt.Sprintf("%d telephone-event/%d", c.TelephoneEvent, clock)
+}
+
+// fmtp SDP a=fmtp 属性值，没有则为空
+func (c rtpCodec) fmtp() string {
+	if c.Name == encoder.CodecOPUS {
+		return fmt.Sprintf("%d minptime=20;useinbandfec=1", c.PayloadType)
+	}
+	return ""
+}
+
+// DefaultCodecPriority 未配置编码优先级时使用，宽带编码优先
+var DefaultCodecPriority = models.CodecConfigs{
+	{Name: "OPUS", Priority: 1, Enabled: true},
+	{Name: "G722", Priority: 2, Enabled: true},
+	{Name: "PCMU", Priority: 3, Enabled: true},
+	{Name: "PCMA", Priority: 4, Enabled: true},
+}
+
+// ErrNoCommonCodec SDP offer与本地编码列表没有交集
+var ErrNoCommonCodec = errors.New("no common audio codec in SDP offer")
+
+// parseOfferedCodecs 解析SDP offer中m=audio提供的编码 name -> codec，以及telephone-event载荷类型 clock -> PT
+func parseOfferedCodecs(sdpBody string) (map[string]rtpCodec, map[int]uint8, error) {
+	var session sdp.SessionDescription
+	if err := session.Unmarshal([]byte(sdpBody)); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse SDP offer: %w", err)
+	}
+
+	// 只协商第一路音频
+	var md *sdp.MediaDescription
+	for _, m := range session.MediaDescriptions {
+		if m.MediaName.Media == "audio" {
+			md = m
+			break
+		}
+	}
+	if md == nil {
+		return nil, nil, errors.New("SDP offer has no audio media")
+	}
+
+	rtpmaps := make(map[string]string)
+	for _, attr := range md.Attributes {
+		if attr.Key != "rtpmap" {
+			continue
+		}
+		if pt, encoding, ok := strings.Cut(attr.Value, " "); ok {
+			rtpmaps[pt] = strings.ToLower(encoding)
+		}
+	}
+
+	offered := make(map[string]rtpCodec)
+	telephoneEvents := make(map[int]uint8)
+	for _, format := range md.MediaName.Formats {
+		pt, err := strconv.ParseUint(format, 10, 8)
+		if err != nil || pt > 127 {
+			continue
+		}
+		encoding := rtpmaps[format]
+		switch {
+		// 静态载荷类型可以不带rtpmap
+		case pt == 0 || strings.HasPrefix(encoding, "pcmu/8000"):
+			setOffered(offered, codecPCMU, uint8(pt))
+		case pt == 8 || strings.HasPrefix(encoding, "pcma/8000"):
+			setOffered(offered, codecPCMA, uint8(pt))
+		case pt == 9 || strings.HasPrefix(encoding, "g722/8000"):
+			setOffered(offered, codecG722, uint8(pt))
+		case pt >= 96 && strings.HasPrefix(encoding, "opus/48000"):
+			setOffered(offered, newOpusCodec(uint8(pt)), uint8(pt))
+		case pt == 13 || strings.HasPrefix(encoding, "cn/8000"):
+			setOffered(offered, codecCN, uint8(pt))
+		case pt >= 96 && strings.HasPrefix(encoding, "telephone-event/"):
+			clock, err := strconv.Atoi(strings.TrimPrefix(encoding, "telephone-event/"))
+			if err != nil {
+				continue
+			}
+			if _, exists := telephoneEvents[clock]; !exists {
+				telephoneEvents[clock] = uint8(pt)
+			}
+		}
+	}
+	return offered, telephoneEvents, nil
+}
+
+// setOffered 使用offer中的载荷类型，同一编码出现多次时保留靠前的
+func setOffered(offered map[string]rtpCodec, codec rtpCodec, payloadType uint8) {
+	if _, exists := offered[codec.Name]; !exists {
+		codec.PayloadType = payloadType
+		offered[codec.Name] = codec
+	}
+}
+
+// negotiateCodec 按本地优先级从SDP offer中选择编码，priorities为空时使用DefaultCodecPriority；
+// offer无法解析时按PCMU处理，与未协商前的行为一致
+func negotiateCodec(sdpBody string, priorities models.CodecConfigs) (rtpCodec, error) {
+	offered, telephoneEvents, err := parseOfferedCodecs(sdpBody)
+	if err != nil {
+		return codecPCMU, nil
+	}
+
+	if len(priorities) == 0 {
+		priorities = DefaultCodecPriority
+	}
+	ordered := make(models.CodecConfigs, 0, len(priorities))
+	for _, cc := range priorities {
+		if cc.Enabled {
+			ordered = append(ordered, cc)
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Priority < ordered[j].Priority })
+
+	for _, cc := range ordered {
+		if codec, ok := offered[strings.ToLower(cc.Name)]; ok {
+			// telephone-event优先使用与音频相同的时钟频率（RFC 4733），否则用8000
+			for _, clock := range []int{codec.ClockRate, 8000} {
+				if pt, exists := telephoneEvents[clock]; exists {
+					codec.TelephoneEvent, codec.TelephoneEventClock = pt, clock
+					break
+				}
+			}
+			// CN的时钟频率需与音频一致
+			if cn, exists := offered[codecCN.Name]; exists && codec.ClockRate == cn.ClockRate {
+				codec.ComfortNoise = cn.PayloadType
+			}
+			return codec, nil
+		}
+	}
+	return rtpCodec{}, ErrNoCommonCodec
+}
+
+// rtpEncoder 将PCM帧编码为RTP载荷
+type rtpEncoder func(samples []int16) ([]byte, error)
+
+// rtpDecoder 将RTP载荷解码为PCM样本
+type rtpDecoder func(payload []byte) ([]int16, error)
+
+// newRTPEncoder 创建编码器，pcmRate为输入PCM的采样率
+func newRTPEncoder(codec rtpCodec, pcmRate int) (rtpEncoder, error) {
+	if codec.Name == encoder.CodecPCMU && pcmRate == codec.SampleRate {
+		return func(samples []int16) ([]byte, error) {
+			payload := make([]byte, len(samples))
+			for i, sample := range samples {
+				payload[i] = linearToMulaw(sample)
+			}
+			return payload, nil
+		}, nil
+	}
+
+	if codec.Name == encoder.CodecPCM && pcmRate == codec.SampleRate {
+		return func(samples []int16) ([]byte, error) {
+			return samplesToBytes(samples), nil
+		}, nil
+	}
+
+	encode, err := encoder.CreateEncode(
+		media.CodecConfig{Codec: codec.Name, SampleRate: codec.SampleRate, Channels: 1, FrameDuration: "20ms"},
+		media.CodecConfig{Codec: encoder.CodecPCM, SampleRate: pcmRate, Channels: 1},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s encoder: %w", codec.Name, err)
+	}
+	return func(samples []int16) ([]byte, error) {
+		packets, err := encode(&media.AudioPacket{Payload: samplesToBytes(samples)})
+		if err != nil {
+			return nil, err
+		}
+		if len(packets) == 0 {
+			return nil, nil
+		}
+		return packets[0].(*media.AudioPacket).Payload, nil
+	}, nil
+}
+
+// newRTPDecoder 创建解码器，输出pcmRate采样率的PCM
+func newRTPDecoder(codec rtpCodec, pcmRate int) (rtpDecoder, error) {
+	if codec.Name == encoder.CodecPCMU && pcmRate == codec.SampleRate {
+		return func(payload []byte) ([]int16, error) {
+			samples := make([]int16, len(payload))
+			for i, b := range payload {
+				samples[i] = mulawToLinear(b)
+			}
+			return samples, nil
+		}, nil
+	}
+
+	if codec.Name == encoder.CodecPCM && pcmRate == codec.SampleRate {
+		return func(payload []byte) ([]int16, error) {
+			return bytesToSamples(payload), nil
+		}, nil
+	}
+
+	// 解码缓冲按Opus最大帧长120ms分配，兼容对端使用更长的打包时长
+	decode, err := encoder.CreateDecode(
+		media.CodecConfig{Codec: codec.Name, SampleRate: codec.SampleRate, Channels: 1, FrameDuration: "120ms"},
+		media.CodecConfig{Codec: encoder.CodecPCM, SampleRate: pcmRate, Channels: 1},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s decoder: %w", codec.Name, err)
+	}
+	return func(payload []byte) ([]int16, error) {
+		packets, err := decode(&media.AudioPacket{Payload: payload})
+		if err != nil {
+			return nil, err
+		}
+		var samples []int16
+		for _, packet := range packets {
+			samples = append(samples, bytesToSamples(packet.(*media.AudioPacket).Payload)...)
+		}
+		return samples, nil
+	}, nil
+}
+
+// samplesToBytes 16位PCM样本转小端字节
+func samplesToBytes(samples []int16) []byte {
+	data := make([]byte, len(samples)*2)
+	for i, sample := range samples {
+		data[i*2] = byte(sample)
+		data[i*2+1] = byte(sample >> 8)
+	}
+	return data
+}
+
+// bytesToSamples 小端字节转16位PCM样本
+func bytesToSamples(data []byte) []int16 {
+	samples := make([]int16, len(data)/2)
+	for i := range samples {
+		samples[i] = int16(data[i*2]) | int16(data[i*2+1])<<8
+	}
+	return samples
+}
+
+// saveCallCodec 记录通话协商出的编码
+func (as *SipServer) saveCallCodec(callID string, codec rtpCodec) {
+	as.codecsMutex.Lock()
+	defer as.codecsMutex.Unlock()
+	as.codecs[callID] = codec
+}
+
+// getCallCodec 获取通话编码，未协商过的通话使用PCMU
+func (as *SipServer) getCallCodec(callID string) rtpCodec {
+	as.codecsMutex.RLock()
+	defer as.codecsMutex.RUnlock()
+	if codec, exists := as.codecs[callID]; exists {
+		return codec
+	}
+	return codecPCMU
+}
+
+// removeCallCodec 通话结束后清理编码记录
+func (as *SipServer) removeCallCodec(callID string) {
+	as.codecsMutex.Lock()
+	defer as.codecsMutex.Unlock()
+	delete(as.codecs, callID)
+}
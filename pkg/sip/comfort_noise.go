@@ -0,0 +1,107 @@
+package sip1
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// comfortNoiseAmplitude 白噪音幅度，约-60dBov，听感接近线路底噪
+	comfortNoiseAmplitude = 30
+	// comfortNoiseLevel CN包声明的噪音电平（-dBov）
+	comfortNoiseLevel = 60
+	// comfortNoiseSIDInterval CN静音描述包的发送间隔
+	comfortNoiseSIDInterval = 200 * time.Millisecond
+)
+
+// comfortNoiseMode 取中继配置的舒适噪音方式，没有中继或未配置时发送白噪音
+func comfortNoiseMode(trunk *models.SIPTrunk) models.ComfortNoiseMode {
+	if trunk == nil {
+		return models.ComfortNoiseAudio
+	}
+	switch trunk.ComfortNoise {
+	case models.ComfortNoiseNone, models.ComfortNoiseCN:
+		return trunk.ComfortNoise
+	default:
+		return models.ComfortNoiseAudio
+	}
+}
+
+// startComfortNoise 监听期间向主叫发送舒适噪音，返回的函数停止发送并等待发送协程退出
+func (engine *AIPhoneEngine) startComfortNoise(session *ScriptSession) func() {
+	mode := session.ComfortNoise
+	if mode == models.ComfortNoiseNone {
+		return func() {}
+	}
+	// 对端未协商CN时退回白噪音
+	if mode == models.ComfortNoiseCN && session.Codec.ComfortNoise == 0 {
+		mode = models.ComfortNoiseAudio
+	}
+
+	sender, err := engine.newRTPSender(session)
+	if err != nil {
+		logger.Warn("Failed to start comfort noise",
+			zap.String("call_id", session.CallID),
+			zap.Error(err))
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if mode == models.ComfortNoiseCN {
+			sender.runCN(stop)
+		} else {
+			sender.runNoise(stop)
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// runNoise 按20ms节奏用通话编码发送白噪音帧，不计入回声参考
+func (s *rtpSender) runNoise(stop <-chan struct{}) {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	frame := make([]int16, s.frameSamples)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for i := range frame {
+			frame[i] = int16(rand.IntN(2*comfortNoiseAmplitude+1) - comfortNoiseAmplitude)
+		}
+		payload, err := s.encode(frame)
+		if err != nil || len(payload) == 0 {
+			continue
+		}
+		s.writePacket(s.codec.PayloadType, payload, len(frame))
+	}
+}
+
+// runCN 定期发送RFC 3389静音描述包，载荷只含噪音电平
+func (s *rtpSender) runCN(stop <-chan struct{}) {
+	ticker := time.NewTicker(comfortNoiseSIDInterval)
+	defer ticker.Stop()
+
+	samples := s.codec.SampleRate * int(comfortNoiseSIDInterval/time.Millisecond) / 1000
+	for {
+		s.writePacket(s.codec.ComfortNoise, []byte{comfortNoiseLevel}, samples)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
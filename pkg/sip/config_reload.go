@@ -0,0 +1,65 @@
+package sip1
+
+import (
+	"github.com/LingByte/LingSIP/pkg/config"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// configReloader 可按重新加载的全局配置更新自身的服务，如LLM服务
+type configReloader interface {
+	ReloadConfig()
+}
+
+// ReloadConfig 重新读取配置文件，只应用可热更新的配置项（LLM模型、TTS音色、VAD阈值、限流、日志级别等），
+// 进行中的通话不中断，其他配置项的修改要重启后生效
+func (as *SipServer) ReloadConfig() (*config.ReloadResult, error) {
+	return as.applyConfig(config.Reload())
+}
+
+// RefreshSecrets 密钥后端轮换了密钥后重新解析配置和中继密码中的secret:引用
+func (as *SipServer) RefreshSecrets() error {
+	if _, err := as.applyConfig(config.RefreshSecrets()); err != nil {
+		return err
+	}
+	if as.trunkManager != nil {
+		as.trunkManager.RefreshSecrets()
+	}
+	return nil
+}
+
+// applyConfig 把重新加载的配置应用到日志和AI服务
+func (as *SipServer) applyConfig(result *config.ReloadResult, err error) (*config.ReloadResult, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(result.RestartRequired) > 0 {
+		logger.Warn("Config changes require restart", zap.Strings("keys", result.RestartRequired))
+	}
+	if len(result.Applied) == 0 {
+		return result, nil
+	}
+
+	if err := logger.SetLevel(config.GlobalConfig.Log.Level); err != nil {
+		logger.Warn("Invalid log level in reloaded config", zap.String("level", config.GlobalConfig.Log.Level), zap.Error(err))
+	}
+	if as.aiEngine != nil {
+		as.aiEngine.reloadServices()
+	}
+	logger.Info("Config reloaded", zap.Strings("applied", result.Applied))
+	return result, nil
+}
+
+// reloadServices 让按全局配置创建的服务在下次使用时读取新配置
+func (engine *AIPhoneEngine) reloadServices() {
+	services := engine.services()
+	if synth, ok := services.Synthesizer.(*configSynthesizer); ok {
+		synth.reset()
+	}
+	if recognizer, ok := services.Recognizer.(*configRecognizer); ok {
+		recognizer.reload()
+	}
+	if reloader, ok := services.Assistant.(configReloader); ok {
+		reloader.ReloadConfig()
+	}
+}
@@ -0,0 +1,18 @@
+package sip1
+
+import (
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterConfigAPIs 注册配置热更新接口：POST /config/reload 重新读取配置文件并应用可热更新的配置项
+func RegisterConfigAPIs(r gin.IRoutes, server *SipServer) {
+	r.POST("/config/reload", func(c *gin.Context) {
+		result, err := server.ReloadConfig()
+		if err != nil {
+			response.Fail(c, "failed to reload config", err.Error())
+			return
+		}
+		response.Success(c, "ok", result)
+	})
+}
@@ -0,0 +1,168 @@
+package sip1
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultOptOutTimeout 告知后等待拒绝录音的默认时长
+	defaultOptOutTimeout = 5 * time.Second
+	// consentContextKey 录音告知结果写入会话上下文，条件步骤可据此分支
+	consentContextKey = "recording_consent"
+)
+
+// executeConsentStep 执行录音告知步骤：播放告知语后等待拒绝，按拒绝键或说出关键词时关闭录音，通话照常继续
+func (engine *AIPhoneEngine) executeConsentStep(session *ScriptSession, step *models.AIPhoneScriptStep, execution *models.StepExecution) (string, error) {
+	data := step.Data
+
+	// 每通电话只告知一次，脚本开始前已告知时跳过流程中的告知步骤
+	if session.consentDecision() != "" {
+		return data.NextStep, nil
+	}
+
+	if data.AudioFile != "" && session.text == nil {
+		if err := engine.playAudioFile(session, data.AudioFile); err != nil {
+			return "", fmt.Errorf("failed to play consent announcement: %w", err)
+		}
+	} else {
+		text := data.AudioText
+		if text == "" {
+			text = models.DefaultConsentText
+		}
+		if err := engine.playTTSAudio(session, text, data.SpeakerID); err != nil {
+			return "", fmt.Errorf("failed to play consent announcement: %w", err)
+		}
+		execution.TTSText = text
+		session.addMessage("assistant", text, step.StepID)
+	}
+
+	optOutDigits := data.OptOutDigits
+	if optOutDigits == "" {
+		optOutDigits = models.DefaultOptOutDigit
+	}
+	keywords := data.OptOutKeywords
+	if len(keywords) == 0 {
+		keywords = models.ConsentStepTemplate("").Data.OptOutKeywords
+	}
+	timeout := time.Duration(data.OptOutTimeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultOptOutTimeout
+	}
+
+	// 同时监听按键和语音，按键在识别流水线的读取协程里回调
+	var digit string
+	session.setDigitHandler(func(d string) {
+		if digit == "" && strings.Contains(optOutDigits, d) {
+			digit = d
+		}
+	})
+	speech, err := engine.listenForUserInput(session, timeout)
+	session.setDigitHandler(nil)
+	if err != nil {
+		// 识别失败不影响告知，按是否按了拒绝键判断
+		logger.Warn("Failed to recognize consent response", zap.String("call_id", session.CallID), zap.Error(err))
+	}
+
+	decision := models.ConsentAccepted
+	switch {
+	case digit != "":
+		decision = models.ConsentOptedOut
+		execution.UserInput = fmt.Sprintf("DTMF: %s", digit)
+	case speech != "" && contains(speech, keywords):
+		decision = models.ConsentOptedOut
+		execution.UserInput = speech
+	}
+	if execution.UserInput != "" {
+		session.addMessage("user", execution.UserInput, step.StepID)
+	}
+	engine.recordConsent(session, decision)
+
+	if decision == models.ConsentOptedOut {
+		optOutText := data.OptOutText
+		if optOutText == "" {
+			optOutText = models.DefaultOptOutText
+		}
+		if err := engine.playTTSAudio(session, optOutText, data.SpeakerID); err != nil {
+			logger.Warn("Failed to play opt-out confirmation", zap.String("call_id", session.CallID), zap.Error(err))
+		}
+	}
+	return data.NextStep, nil
+}
+
+// recordConsent 保存录音告知结果，拒绝录音时关闭本通电话的录音
+func (engine *AIPhoneEngine) recordConsent(session *ScriptSession, decision models.ConsentDecision) {
+	now := time.Now()
+	session.mutex.Lock()
+	session.DBSession.RecordingConsent = decision
+	session.DBSession.ConsentAt = &now
+	session.mutex.Unlock()
+
+	if err := session.Context.Set(consentContextKey, string(decision)); err != nil {
+		logger.Warn("Failed to save consent to context", zap.String("call_id", session.CallID), zap.Error(err))
+	}
+	if err := models.UpdateAIPhoneSession(engine.db, session.DBSession); err != nil {
+		logger.Error("Failed to save consent decision", zap.String("call_id", session.CallID), zap.Error(err))
+	}
+	logger.Info("Recording consent recorded",
+		zap.String("call_id", session.CallID),
+		zap.String("decision", string(decision)))
+
+	if decision == models.ConsentOptedOut && engine.server != nil {
+		engine.server.disableRecording(session.CallID)
+	}
+}
+
+// disableRecording 关闭通话录音：停止录音并删除已录部分，挂断时不再保存录音地址
+func (as *SipServer) disableRecording(callID string) {
+	info, exists := as.config.GetActiveSession(callID)
+	if !exists {
+		return
+	}
+	file := info.RecordingFile
+	info.RecordingFile = ""
+	select {
+	case info.StopRecording <- true:
+	default:
+	}
+	// 同步到共享存储，其他实例接管时也不再录音
+	as.config.SaveActiveSession(callID, info)
+
+	if file != "" {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			logger.Error("Failed to delete recording after opt-out",
+				zap.String("call_id", callID),
+				zap.String("file", file),
+				zap.Error(err))
+			return
+		}
+	}
+	logger.Info("Recording disabled by caller opt-out", zap.String("call_id", callID))
+}
+
+func (session *ScriptSession) consentDecision() models.ConsentDecision {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	if session.DBSession == nil {
+		return ""
+	}
+	return session.DBSession.RecordingConsent
+}
+
+func (session *ScriptSession) setDigitHandler(onDigit func(digit string)) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.onDigit = onDigit
+}
+
+func (session *ScriptSession) digitHandler() func(digit string) {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	return session.onDigit
+}
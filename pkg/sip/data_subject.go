@@ -0,0 +1,138 @@
+package sip1
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ErrServiceNumber 号码是脚本或中继使用的本方号码，不是个人数据主体
+var ErrServiceNumber = errors.New("number belongs to a script or trunk")
+
+// DataSubjectExport 个人信息查阅请求的导出内容
+type DataSubjectExport struct {
+	*models.DataSubjectRecords
+	Recordings []string  `json:"recordings"` // 录音文件路径
+	ExportedAt time.Time `json:"exportedAt"`
+}
+
+// ExportDataSubject 导出与号码关联的通话、AI会话、对话历史、录音和SIP用户资料
+func ExportDataSubject(db *gorm.DB, number string) (*DataSubjectExport, error) {
+	number = strings.TrimSpace(number)
+	if number == "" {
+		return nil, fmt.Errorf("number is required")
+	}
+	records, err := models.FindDataSubjectRecords(db, number)
+	if err != nil {
+		return nil, err
+	}
+	return &DataSubjectExport{
+		DataSubjectRecords: records,
+		Recordings:         subjectRecordings(records),
+		ExportedAt:         time.Now(),
+	}, nil
+}
+
+// DeleteDataSubject 彻底删除与号码关联的数据和录音文件，并保存删除证明；脚本和中继的号码不允许删除
+func DeleteDataSubject(db *gorm.DB, number, actor, reason string) (*models.DeletionCertificate, error) {
+	number, reason = strings.TrimSpace(number), strings.TrimSpace(reason)
+	if number == "" || actor == "" || reason == "" {
+		return nil, fmt.Errorf("number, actor and reason are required")
+	}
+	if _, err := models.GetScriptPhoneMapping(db, number); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNumber, number)
+	}
+	if _, err := models.GetSIPTrunkByPhoneNumber(db, number); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNumber, number)
+	}
+
+	records, err := models.FindDataSubjectRecords(db, number)
+	if err != nil {
+		return nil, err
+	}
+	recordings := subjectRecordings(records)
+	if err := models.DeleteDataSubjectRecords(db, records); err != nil {
+		return nil, err
+	}
+
+	cert := &models.DeletionCertificate{
+		CertificateID:  uuid.NewString(),
+		Actor:          actor,
+		Reason:         reason,
+		Calls:          len(records.Calls),
+		Sessions:       len(records.Sessions),
+		StepExecutions: records.StepExecutionCount(),
+		SipSessions:    len(records.SipSessions),
+		Profiles:       len(records.Profiles),
+		Messages:       len(records.Messages),
+		LLMExchanges:   len(records.LLMExchanges),
+		Contacts:       len(records.Contacts),
+	}
+	// 数据库记录已删除，录音删除失败只计入证明，由运维按日志补删
+	for _, path := range recordings {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			cert.RecordingErrors++
+			logger.Error("Failed to delete recording of data subject",
+				zap.String("certificate_id", cert.CertificateID),
+				zap.String("file", path),
+				zap.Error(err))
+			continue
+		}
+		cert.Recordings++
+	}
+
+	if err := models.CreateDeletionCertificate(db, cert, number); err != nil {
+		return nil, fmt.Errorf("data deleted but failed to save deletion certificate %s: %w", cert.CertificateID, err)
+	}
+	logger.Info("Data subject deleted",
+		zap.String("certificate_id", cert.CertificateID),
+		zap.String("actor", actor),
+		zap.Int("calls", cert.Calls),
+		zap.Int("sessions", cert.Sessions),
+		zap.Int("recordings", cert.Recordings),
+		zap.Int("recording_errors", cert.RecordingErrors))
+	return cert, nil
+}
+
+// subjectRecordings 记录中引用的录音文件，以及按Call-ID命名的默认录音，只返回存在的文件
+func subjectRecordings(records *models.DataSubjectRecords) []string {
+	seen := make(map[string]bool)
+	var paths []string
+	add := func(path string) {
+		if path == "" || seen[path] {
+			return
+		}
+		seen[path] = true
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	for _, call := range records.Calls {
+		add(recordingPath(call.RecordURL))
+	}
+	for _, session := range records.Sessions {
+		add(recordingPath(session.RecordingURL))
+	}
+	for _, callID := range records.CallIDs() {
+		if !strings.ContainsAny(callID, `/\`) {
+			add(fmt.Sprintf("uploads/audio/recorded_%s.wav", callID))
+		}
+	}
+	return paths
+}
+
+// recordingPath 把saveRecordingURL生成的 /api/uploads/... URL 转回本地路径，外部URL返回空
+func recordingPath(recordURL string) string {
+	if strings.Contains(recordURL, "://") || strings.Contains(recordURL, "..") {
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(recordURL, "/api/"), "/")
+}
@@ -0,0 +1,97 @@
+package sip1
+
+import (
+	"strconv"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/auth"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RegisterDataSubjectAPIs 注册个人信息查阅和删除接口：GET /data-subjects/:number 导出号码关联的全部数据，
+// DELETE /data-subjects/:number {"reason":"工单123"} 彻底删除并返回删除证明，执行人为认证的操作人，
+// GET /deletion-certificates?offset=0&limit=20 列表，GET /deletion-certificates/:id?number=... 校验证明
+func RegisterDataSubjectAPIs(r gin.IRoutes, server *SipServer) {
+	db := func(c *gin.Context) (*gorm.DB, bool) {
+		if server.config.Db == nil {
+			response.Fail(c, "database not configured", nil)
+			return nil, false
+		}
+		return server.config.Db, true
+	}
+
+	r.GET("/data-subjects/:number", func(c *gin.Context) {
+		conn, ok := db(c)
+		if !ok {
+			return
+		}
+		export, err := ExportDataSubject(conn, c.Param("number"))
+		if err != nil {
+			response.Fail(c, "failed to export data subject", err.Error())
+			return
+		}
+		response.Success(c, "ok", export)
+	})
+
+	r.DELETE("/data-subjects/:number", func(c *gin.Context) {
+		conn, ok := db(c)
+		if !ok {
+			return
+		}
+		actor := auth.CurrentActor(c)
+		if actor == "" {
+			response.Fail(c, "authentication required", nil)
+			return
+		}
+		var form struct {
+			Reason string `json:"reason" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		cert, err := DeleteDataSubject(conn, c.Param("number"), actor, form.Reason)
+		if err != nil {
+			response.Fail(c, "failed to delete data subject", err.Error())
+			return
+		}
+		response.Success(c, "ok", cert)
+	})
+
+	r.GET("/deletion-certificates", func(c *gin.Context) {
+		conn, ok := db(c)
+		if !ok {
+			return
+		}
+		offset, _ := strconv.Atoi(c.Query("offset"))
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		if limit <= 0 {
+			limit = sipUserPageSize
+		}
+		certs, total, err := models.ListDeletionCertificates(conn, max(offset, 0), min(limit, maxSipUserPageSize))
+		if err != nil {
+			response.Fail(c, "failed to list deletion certificates", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"items": certs, "total": total})
+	})
+
+	r.GET("/deletion-certificates/:id", func(c *gin.Context) {
+		conn, ok := db(c)
+		if !ok {
+			return
+		}
+		cert, err := models.GetDeletionCertificate(conn, c.Param("id"))
+		if err != nil {
+			response.Fail(c, "deletion certificate not found", err.Error())
+			return
+		}
+		result := gin.H{"certificate": cert, "valid": cert.Verify()}
+		if number := c.Query("number"); number != "" {
+			result["matches"] = cert.Matches(number)
+		}
+		response.Success(c, "ok", result)
+	})
+}
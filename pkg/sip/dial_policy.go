@@ -0,0 +1,151 @@
+package sip1
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"gorm.io/gorm"
+)
+
+// DefaultDialRules 内置全局规则：禁止国际长途和声讯台等高费率号码。
+// 号码和规则前缀都先去掉+86、0086国家码再匹配，+861900与1900命中同一条规则
+var DefaultDialRules = []models.DialRule{
+	{Prefix: "00", Action: models.DialRuleActionBlock, Description: "international"},
+	{Prefix: "+", Action: models.DialRuleActionBlock, Description: "international"},
+	{Prefix: "1900", Action: models.DialRuleActionBlock, Description: "premium rate"},
+	{Prefix: "168", Action: models.DialRuleActionBlock, Description: "premium rate"},
+	{Prefix: "160", Action: models.DialRuleActionBlock, Description: "premium rate"},
+}
+
+// ErrDestinationBlocked 目的号码被拨号规则禁止
+type ErrDestinationBlocked struct {
+	Number string
+	Rule   models.DialRule
+}
+
+func (e *ErrDestinationBlocked) Error() string {
+	return fmt.Sprintf("destination %s blocked by rule %q (%s)", e.Number, e.Rule.Prefix, e.Rule.Description)
+}
+
+// DialPolicy 外呼目的号码策略，组织规则优先于全局规则，同一范围内最长前缀生效
+type DialPolicy struct {
+	global []models.DialRule
+	groups map[uint][]models.DialRule
+	mutex  sync.RWMutex
+}
+
+// NewDialPolicy 使用内置规则创建拨号策略
+func NewDialPolicy() *DialPolicy {
+	policy := &DialPolicy{}
+	policy.SetRules(DefaultDialRules)
+	return policy
+}
+
+// SetRules 替换全部规则，前缀按国内号码格式保存，只有国家码的前缀忽略
+func (p *DialPolicy) SetRules(rules []models.DialRule) {
+	global := make([]models.DialRule, 0, len(rules))
+	groups := make(map[uint][]models.DialRule)
+	for _, rule := range rules {
+		rule.Prefix = domesticNumber(rule.Prefix)
+		if rule.Prefix == "" {
+			continue
+		}
+		if rule.GroupID == 0 {
+			global = append(global, rule)
+		} else {
+			groups[rule.GroupID] = append(groups[rule.GroupID], rule)
+		}
+	}
+
+	p.mutex.Lock()
+	p.global = global
+	p.groups = groups
+	p.mutex.Unlock()
+}
+
+// Load 从数据库加载规则，数据库规则追加在内置规则之后
+func (p *DialPolicy) Load(db *gorm.DB) error {
+	rules, err := models.GetEnabledDialRules(db)
+	if err != nil {
+		return fmt.Errorf("failed to load dial rules: %w", err)
+	}
+	p.SetRules(append(append([]models.DialRule{}, DefaultDialRules...), rules...))
+	return nil
+}
+
+// Check 检查组织是否允许拨打该号码，groupID为0时只使用全局规则
+func (p *DialPolicy) Check(groupID uint, number string) error {
+	normalized := domesticNumber(number)
+	if normalized == "" {
+		return fmt.Errorf("invalid destination number: %q", number)
+	}
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if groupID != 0 {
+		if rule, ok := longestPrefixRule(p.groups[groupID], normalized); ok {
+			return ruleResult(rule, number)
+		}
+	}
+	if rule, ok := longestPrefixRule(p.global, normalized); ok {
+		return ruleResult(rule, number)
+	}
+	return nil
+}
+
+// longestPrefixRule 找到匹配前缀最长的规则，长度相同时禁止优先
+func longestPrefixRule(rules []models.DialRule, number string) (models.DialRule, bool) {
+	var best models.DialRule
+	found := false
+	for _, rule := range rules {
+		if !strings.HasPrefix(number, rule.Prefix) {
+			continue
+		}
+		if !found || len(rule.Prefix) > len(best.Prefix) ||
+			(len(rule.Prefix) == len(best.Prefix) && rule.Action == models.DialRuleActionBlock) {
+			best = rule
+			found = true
+		}
+	}
+	return best, found
+}
+
+func ruleResult(rule models.DialRule, number string) error {
+	if rule.Action == models.DialRuleActionBlock {
+		return &ErrDestinationBlocked{Number: number, Rule: rule}
+	}
+	return nil
+}
+
+// normalizeDialNumber 去掉号码中的空格、横线、括号以及sip:前缀和域名部分
+func normalizeDialNumber(number string) string {
+	number = strings.TrimSpace(number)
+	number = strings.TrimPrefix(strings.TrimPrefix(number, "sip:"), "tel:")
+	if idx := strings.Index(number, "@"); idx >= 0 {
+		number = number[:idx]
+	}
+	var b strings.Builder
+	for i, r := range number {
+		switch {
+		case r >= '0' && r <= '9', r == '*', r == '#':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// domesticNumber 规范化后去掉+86、0086国家码，拨号规则、免打扰名单都按该格式比对
+func domesticNumber(number string) string {
+	number = normalizeDialNumber(number)
+	for _, prefix := range []string{"+86", "0086"} {
+		if rest, ok := strings.CutPrefix(number, prefix); ok {
+			return rest
+		}
+	}
+	return number
+}
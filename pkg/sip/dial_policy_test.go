@@ -0,0 +1,65 @@
+package sip1
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/LingByte/LingSIP/internal/models"
+)
+
+func TestDialPolicyCheck(t *testing.T) {
+	policy := NewDialPolicy()
+	policy.SetRules(append(append([]models.DialRule{}, DefaultDialRules...),
+		models.DialRule{GroupID: 7, Prefix: "+861900123", Action: models.DialRuleActionAllow, Description: "partner hotline"},
+		models.DialRule{GroupID: 7, Prefix: "0086139", Action: models.DialRuleActionBlock, Description: "mobile"},
+	))
+
+	tests := []struct {
+		name    string
+		groupID uint
+		number  string
+		blocked string // 期望命中的禁止规则前缀，为空表示放行
+	}{
+		{"domestic mobile", 0, "13800138000", ""},
+		{"domestic E.164", 0, "+8613800138000", ""},
+		{"domestic 0086", 0, "008613800138000", ""},
+		{"sip uri", 0, "sip:+86 138-0013-8000@example.com", ""},
+		{"premium 1900", 0, "19001234567", "1900"},
+		{"premium +861900", 0, "+8619001234567", "1900"},
+		{"premium 0086168", 0, "0086168123456", "168"},
+		{"premium +86160", 0, "+86160123456", "160"},
+		{"international", 0, "+14155550100", "+"},
+		{"international 00", 0, "0014155550100", "00"},
+		{"group allow beats global block", 7, "+8619001234567", ""},
+		{"group rule prefix normalized", 7, "13912345678", "139"},
+		{"group falls back to global", 7, "19009999999", "1900"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.groupID, tt.number)
+			if tt.blocked == "" {
+				if err != nil {
+					t.Fatalf("Check(%d, %q) = %v, want allowed", tt.groupID, tt.number, err)
+				}
+				return
+			}
+			var blocked *ErrDestinationBlocked
+			if !errors.As(err, &blocked) {
+				t.Fatalf("Check(%d, %q) = %v, want ErrDestinationBlocked", tt.groupID, tt.number, err)
+			}
+			if blocked.Rule.Prefix != tt.blocked {
+				t.Errorf("Check(%d, %q) blocked by %q, want %q", tt.groupID, tt.number, blocked.Rule.Prefix, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestDialPolicyCheckInvalidNumber(t *testing.T) {
+	policy := NewDialPolicy()
+	for _, number := range []string{"", "sip:@example.com", "+86", "abc"} {
+		if err := policy.Check(0, number); err == nil {
+			t.Errorf("Check(0, %q) = nil, want error", number)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package sip1
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/LingByte/LingSIP/pkg/config"
+)
+
+// 识别方言，脚本的dialect按逗号分隔的顺序回退
+const (
+	DialectMandarin     = "mandarin"     // 普通话，回退链到此结束
+	DialectCantonese    = "cantonese"    // 粤语
+	DialectSichuanese   = "sichuanese"   // 四川话
+	DialectShanghainese = "shanghainese" // 上海话
+	DialectMulti        = "dialect"      // 多方言混合识别
+)
+
+// providerDialects 各ASR服务商内置的方言选项：腾讯云为模型，Google为语言代码，七牛不支持方言。
+// 这些模型只有16k版本，电话音质的通话升采样后识别
+var providerDialects = map[string]map[string]string{
+	"qcloud": {
+		DialectCantonese:    "16k_yue",
+		DialectSichuanese:   "16k_zh_dialect",
+		DialectShanghainese: "16k_zh_dialect",
+		DialectMulti:        "16k_zh_dialect",
+	},
+	"google": {
+		DialectCantonese: "yue-Hant-HK",
+	},
+}
+
+type asrDialectKey struct{}
+
+// withASRDialect 识别时按脚本配置的方言回退顺序选择模型
+func withASRDialect(ctx context.Context, dialect string) context.Context {
+	return context.WithValue(ctx, asrDialectKey{}, dialect)
+}
+
+// asrDialectFrom 返回ctx中的方言回退顺序，未设置时为空
+func asrDialectFrom(ctx context.Context) string {
+	dialect, _ := ctx.Value(asrDialectKey{}).(string)
+	return dialect
+}
+
+// dialectProvider 方言选项按服务商查找，tencent同qcloud
+func dialectProvider(provider string) string {
+	if provider == "tencent" {
+		return "qcloud"
+	}
+	return provider
+}
+
+// dialectOptions 当前服务商支持的方言及其模型或语言代码，ASR_DIALECT_MODELS优先于内置选项
+func dialectOptions(asrConfig config.ASRConfig) map[string]string {
+	options := make(map[string]string)
+	for dialect, option := range providerDialects[dialectProvider(asrConfig.Provider)] {
+		options[dialect] = option
+	}
+	for _, item := range strings.Split(asrConfig.DialectModels, ",") {
+		dialect, option, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if dialect, option = strings.TrimSpace(dialect), strings.TrimSpace(option); ok && dialect != "" && option != "" {
+			options[dialect] = option
+		}
+	}
+	return options
+}
+
+// ASRDialects 当前ASR服务商可识别的方言，不含普通话
+func ASRDialects(asrConfig config.ASRConfig) []string {
+	dialects := make([]string, 0)
+	for dialect := range dialectOptions(asrConfig) {
+		dialects = append(dialects, dialect)
+	}
+	sort.Strings(dialects)
+	return dialects
+}
+
+// dialectOption 按回退顺序返回服务商支持的第一个方言及其模型或语言代码；
+// 先遇到mandarin或都不支持时返回false，使用普通话模型
+func dialectOption(asrConfig config.ASRConfig, chain string) (string, string, bool) {
+	if chain == "" {
+		return "", "", false
+	}
+	options := dialectOptions(asrConfig)
+	for _, dialect := range strings.Split(chain, ",") {
+		dialect = strings.ToLower(strings.TrimSpace(dialect))
+		if dialect == DialectMandarin {
+			break
+		}
+		if option, ok := options[dialect]; ok {
+			return dialect, option, true
+		}
+	}
+	return "", "", false
+}
+
+// asrConfigFor 确定本次识别的模型和语言：ctx中的方言服务商支持时使用方言模型（Google为语言代码），
+// 否则按采样率选择普通话模型
+func asrConfigFor(ctx context.Context, asrConfig config.ASRConfig, sampleRate int) config.ASRConfig {
+	asrConfig.ModelType = selectASRModel(ctx, asrConfig, sampleRate)
+	_, option, ok := dialectOption(asrConfig, asrDialectFrom(ctx))
+	if !ok {
+		return asrConfig
+	}
+	if dialectProvider(asrConfig.Provider) == "google" {
+		asrConfig.Language = option
+	} else {
+		asrConfig.ModelType = option
+	}
+	return asrConfig
+}
@@ -0,0 +1,247 @@
+package sip1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+// CallDialog dialog state of an answered INVITE, used to build in-dialog requests (BYE etc.);
+// Local is our side, whether we received the INVITE or sent it (see newOutboundDialog)
+type CallDialog struct {
+	CallID       string
+	LocalTag     string    // To tag we generated in the 2xx
+	RemoteTag    string    // From tag of the caller
+	LocalURI     sip.Uri   // To URI of the INVITE
+	RemoteURI    sip.Uri   // From URI of the INVITE
+	RemoteTarget sip.Uri   // Contact of the INVITE
+	RouteSet     []sip.Uri // Record-Route set, in the order requests follow it
+	Destination  string    // transport address the INVITE came from
+	Transport    string
+
+	localCSeq uint32
+	mutex     sync.Mutex
+
+	// 会话定时器（RFC 4028），未启用时sessionExpires为0
+	sessionExpires time.Duration
+	allowUpdate    bool // 对端支持UPDATE，刷新时不用re-INVITE
+	sessionTimer   *time.Timer
+}
+
+// newCallDialog builds dialog state from the INVITE and our 2xx response
+func newCallDialog(req *sip.Request, res *sip.Response) (*CallDialog, error) {
+	from := req.From()
+	to := res.To()
+	callID := req.CallID()
+	if from == nil || to == nil || callID == nil {
+		return nil, fmt.Errorf("INVITE is missing From, To or Call-ID")
+	}
+
+	dialog := &CallDialog{
+		CallID:      callID.Value(),
+		LocalURI:    to.Address,
+		RemoteURI:   from.Address,
+		Destination: req.Source(),
+		Transport:   req.Transport(),
+	}
+	dialog.LocalTag, _ = to.Params.Get("tag")
+	dialog.RemoteTag, _ = from.Params.Get("tag")
+
+	// 没有Contact时直接向来源地址发送
+	if contact := req.Contact(); contact != nil {
+		dialog.RemoteTarget = contact.Address
+	} else {
+		dialog.RemoteTarget = from.Address
+	}
+
+	dialog.RouteSet = parseRouteSet(req)
+	return dialog, nil
+}
+
+// newOutboundDialog builds dialog state from an INVITE we sent and the 2xx that answered it
+func newOutboundDialog(invite *sip.Request, res *sip.Response) (*CallDialog, error) {
+	from := invite.From()
+	to := res.To()
+	callID := invite.CallID()
+	cseq := invite.CSeq()
+	if from == nil || to == nil || callID == nil || cseq == nil {
+		return nil, fmt.Errorf("INVITE is missing From, To, Call-ID or CSeq")
+	}
+
+	dialog := &CallDialog{
+		CallID:      callID.Value(),
+		LocalURI:    from.Address,
+		RemoteURI:   to.Address,
+		Destination: invite.Destination(),
+		Transport:   invite.Transport(),
+		localCSeq:   cseq.SeqNo,
+	}
+	dialog.LocalTag, _ = from.Params.Get("tag")
+	dialog.RemoteTag, _ = to.Params.Get("tag")
+
+	if contact := res.Contact(); contact != nil {
+		dialog.RemoteTarget = contact.Address
+	} else {
+		dialog.RemoteTarget = *invite.Recipient
+	}
+
+	// the UAC follows the Record-Route set in reverse (RFC 3261 12.1.2)
+	routes := parseRouteSet(res)
+	for i, j := 0, len(routes)-1; i < j; i, j = i+1, j-1 {
+		routes[i], routes[j] = routes[j], routes[i]
+	}
+	dialog.RouteSet = routes
+	return dialog, nil
+}
+
+// parseRouteSet collects every Record-Route entry of the message, including comma separated values
+func parseRouteSet(msg sip.Message) []sip.Uri {
+	var routes []sip.Uri
+	for _, h := range msg.GetHeaders("Record-Route") {
+		for _, value := range strings.Split(h.Value(), ",") {
+			value = strings.TrimSpace(value)
+			if start := strings.Index(value, "<"); start >= 0 {
+				if end := strings.Index(value, ">"); end > start {
+					value = value[start+1 : end]
+				}
+			}
+			if value == "" {
+				continue
+			}
+			var uri sip.Uri
+			if err := parseURI(value, &uri); err != nil {
+				logger.Warn("Skipping unparsable Record-Route entry",
+					zap.String("value", value),
+					zap.Error(err))
+				continue
+			}
+			routes = append(routes, uri)
+		}
+	}
+	return routes
+}
+
+// NewRequest creates an in-dialog request from our side of the dialog
+func (d *CallDialog) NewRequest(method sip.RequestMethod) *sip.Request {
+	d.mutex.Lock()
+	d.localCSeq++
+	seq := d.localCSeq
+	// re-INVITE可能刷新RemoteTarget
+	target := d.RemoteTarget
+	d.mutex.Unlock()
+
+	req := sip.NewRequest(method, &target)
+
+	from := &sip.FromHeader{Address: d.LocalURI, Params: sip.NewParams()}
+	if d.LocalTag != "" {
+		from.Params.Add("tag", d.LocalTag)
+	}
+	to := &sip.ToHeader{Address: d.RemoteURI, Params: sip.NewParams()}
+	if d.RemoteTag != "" {
+		to.Params.Add("tag", d.RemoteTag)
+	}
+	callID := sip.CallIDHeader(d.CallID)
+	cseq := &sip.CSeqHeader{SeqNo: seq, MethodName: method}
+
+	req.AppendHeader(from)
+	req.AppendHeader(to)
+	req.AppendHeader(&callID)
+	req.AppendHeader(cseq)
+	for _, route := range d.RouteSet {
+		req.AppendHeader(&sip.RouteHeader{Address: route})
+	}
+
+	if d.Transport != "" {
+		req.SetTransport(d.Transport)
+	}
+	// 有路由集时交给传输层按Route解析，否则直接回到INVITE的来源地址（适配NAT）
+	if len(d.RouteSet) == 0 && d.Destination != "" {
+		req.SetDestination(d.Destination)
+	}
+
+	return req
+}
+
+// saveDialog 保存已接通的对话
+func (as *SipServer) saveDialog(dialog *CallDialog) {
+	as.dialogsMutex.Lock()
+	defer as.dialogsMutex.Unlock()
+	as.dialogs[dialog.CallID] = dialog
+}
+
+// getDialog 获取对话
+func (as *SipServer) getDialog(callID string) (*CallDialog, bool) {
+	as.dialogsMutex.RLock()
+	defer as.dialogsMutex.RUnlock()
+	dialog, exists := as.dialogs[callID]
+	return dialog, exists
+}
+
+// removeDialog 删除对话
+func (as *SipServer) removeDialog(callID string) {
+	as.dialogsMutex.Lock()
+	dialog, exists := as.dialogs[callID]
+	delete(as.dialogs, callID)
+	as.dialogsMutex.Unlock()
+	if exists {
+		dialog.stopSessionTimer()
+	}
+}
+
+// sendBye 向远端发送BYE请求结束对话
+func (as *SipServer) sendBye(callID string) error {
+	dialog, exists := as.getDialog(callID)
+	if !exists {
+		return fmt.Errorf("dialog not found: %s", callID)
+	}
+	// 无论结果如何，对话都不再可用
+	defer as.removeDialog(callID)
+
+	return as.byeDialog(dialog)
+}
+
+// byeDialog 发送BYE并等待最终响应
+func (as *SipServer) byeDialog(dialog *CallDialog) error {
+	callID := dialog.CallID
+	bye := dialog.NewRequest(sip.BYE)
+
+	ctx, cancel := context.WithTimeout(context.Background(), as.config.TransactionTimeout)
+	defer cancel()
+
+	tx, err := as.client.TransactionRequest(ctx, bye, as.clientMaxForwards, sipgo.ClientRequestBuild)
+	if err != nil {
+		return fmt.Errorf("failed to send BYE: %w", err)
+	}
+	defer tx.Terminate()
+
+	logger.Info("BYE request sent",
+		zap.String("call_id", callID),
+		zap.String("target", dialog.RemoteTarget.String()))
+
+	for {
+		select {
+		case res := <-tx.Responses():
+			if res.IsProvisional() {
+				continue
+			}
+			if !res.IsSuccess() {
+				return fmt.Errorf("BYE rejected: %d %s", res.StatusCode, res.Reason)
+			}
+			logger.Info("BYE accepted by remote",
+				zap.String("call_id", callID),
+				zap.Int("status", int(res.StatusCode)))
+			return nil
+		case <-tx.Done():
+			return fmt.Errorf("BYE transaction ended: %w", tx.Err())
+		case <-ctx.Done():
+			return fmt.Errorf("BYE timeout: %w", ctx.Err())
+		}
+	}
+}
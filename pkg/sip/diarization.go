@@ -0,0 +1,195 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/audio"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/media"
+	"go.uber.org/zap"
+)
+
+const (
+	// diarizeFrame 语音检测的帧长
+	diarizeFrame = 20 * time.Millisecond
+	// diarizeMinSilence 静音超过该时长时切分语段
+	diarizeMinSilence = 600 * time.Millisecond
+	// diarizeMinSpeech 短于该时长的语段视为噪声丢弃
+	diarizeMinSpeech = 300 * time.Millisecond
+	// diarizeMaxSegment 单个语段的最长时长，超过时切开分别识别
+	diarizeMaxSegment = 30 * time.Second
+	// diarizeTimeout 一通录音转录的最长时间
+	diarizeTimeout = 10 * time.Minute
+)
+
+// diarizationSpeakers 多声道录音各声道对应的说话人：桥接录音左声道为来电者，右声道为坐席，第三声道为班长
+var diarizationSpeakers = []string{"caller", "agent", "supervisor"}
+
+// ErrNoRecording 通话没有录音
+var ErrNoRecording = errors.New("call has no recording")
+
+// speechSpan 一个声道中连续语音的样本区间[start, end)
+type speechSpan struct {
+	start, end int
+}
+
+// detectSpeech 按帧做能量检测，合并间隔短于diarizeMinSilence的语音，丢弃短于diarizeMinSpeech的片段，
+// 长于diarizeMaxSegment的语段切开
+func detectSpeech(samples []int16, sampleRate int, vad media.EnergyVAD) []speechSpan {
+	frameSize := sampleRate * int(diarizeFrame/time.Millisecond) / 1000
+	maxGap := sampleRate * int(diarizeMinSilence/time.Millisecond) / 1000
+	minLength := sampleRate * int(diarizeMinSpeech/time.Millisecond) / 1000
+	maxLength := sampleRate * int(diarizeMaxSegment/time.Millisecond) / 1000
+	if frameSize == 0 {
+		return nil
+	}
+
+	var spans []speechSpan
+	current := speechSpan{start: -1}
+	flush := func() {
+		if current.start >= 0 && current.end-current.start >= minLength {
+			spans = append(spans, current)
+		}
+		current = speechSpan{start: -1}
+	}
+	for offset := 0; offset+frameSize <= len(samples); offset += frameSize {
+		frame, _ := vad.Filter(&media.PCMFrame{Samples: samples[offset : offset+frameSize], SampleRate: sampleRate})
+		if !frame.Speech {
+			if current.start >= 0 && offset-current.end > maxGap {
+				flush()
+			}
+			continue
+		}
+		if current.start < 0 {
+			current.start = offset
+		} else if offset+frameSize-current.start > maxLength {
+			flush()
+			current.start = offset
+		}
+		current.end = offset + frameSize
+	}
+	flush()
+	return spans
+}
+
+// Diarize 识别录音并按说话人标注。多声道录音（桥接通话每方一个声道）逐声道检测语音后分段识别，
+// 按开始时间合并；单声道录音无法区分说话人，整体标为来电者
+func (engine *AIPhoneEngine) Diarize(ctx context.Context, recordingPath string) (models.TranscriptSegments, error) {
+	recognizer := engine.services().Recognizer
+	if recognizer == nil {
+		return nil, fmt.Errorf("no recognizer configured")
+	}
+	format, samples, err := audio.ReadFile(recordingPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+
+	vad := receiveVAD()
+	var segments models.TranscriptSegments
+	for channel, channelSamples := range audio.SplitChannels(samples, format.Channels) {
+		speaker := fmt.Sprintf("speaker%d", channel+1)
+		if channel < len(diarizationSpeakers) {
+			speaker = diarizationSpeakers[channel]
+		}
+		for _, span := range detectSpeech(channelSamples, format.SampleRate, vad) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			text, err := recognizer.Recognize(ctx, channelSamples[span.start:span.end], format.SampleRate)
+			if err != nil {
+				return nil, fmt.Errorf("failed to recognize %s at %dms: %w", speaker, span.start*1000/format.SampleRate, err)
+			}
+			if text = strings.TrimSpace(text); text == "" {
+				continue
+			}
+			segments = append(segments, models.TranscriptSegment{
+				Speaker: speaker,
+				StartMs: span.start * 1000 / format.SampleRate,
+				EndMs:   span.end * 1000 / format.SampleRate,
+				Text:    text,
+			})
+		}
+	}
+	slices.SortStableFunc(segments, func(a, b models.TranscriptSegment) int { return a.StartMs - b.StartMs })
+	return segments, nil
+}
+
+// TranscribeCall 后台转录通话录音并按说话人标注，结果保存到通话记录的transcription和transcriptSegments
+func (as *SipServer) TranscribeCall(callID string) error {
+	if as.aiEngine == nil {
+		return fmt.Errorf("AI phone engine not initialized")
+	}
+	sipCall, found := as.config.GetCall(callID)
+	if !found {
+		return fmt.Errorf("call %s not found", callID)
+	}
+	path := recordingPath(sipCall.RecordURL)
+	if path == "" {
+		return ErrNoRecording
+	}
+	if sipCall.TranscriptionStatus == models.TranscriptionProcessing {
+		return fmt.Errorf("call %s is already being transcribed", callID)
+	}
+	if err := as.config.SaveTranscription(callID, &models.CallTranscription{Status: models.TranscriptionProcessing}); err != nil {
+		return fmt.Errorf("failed to save transcription status: %w", err)
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), diarizeTimeout)
+		defer cancel()
+		transcription := &models.CallTranscription{Status: models.TranscriptionCompleted}
+		segments, err := as.aiEngine.Diarize(ctx, path)
+		if err != nil {
+			transcription.Status = models.TranscriptionFailed
+			transcription.Error = err.Error()
+			logger.Warn("Failed to transcribe recording", zap.String("call_id", callID), zap.Error(err))
+		} else {
+			transcription.Segments = segments
+			transcription.Text = segments.Text()
+			logger.Info("Recording transcribed",
+				zap.String("call_id", callID),
+				zap.Int("segments", len(segments)))
+		}
+		if err := as.config.SaveTranscription(callID, transcription); err != nil {
+			logger.Error("Failed to save transcription", zap.String("call_id", callID), zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// TranscriptMatch 检索命中的通话和其中匹配的语段
+type TranscriptMatch struct {
+	CallID    string                     `json:"callId"`
+	StartTime time.Time                  `json:"startTime"`
+	Segments  []models.TranscriptSegment `json:"segments"`
+}
+
+// SearchTranscripts 在已转录的通话中检索关键词，speaker不为空时只匹配该说话人的语段；需要数据库存储
+func (as *SipServer) SearchTranscripts(keyword, speaker string, limit int) ([]TranscriptMatch, error) {
+	if as.config.Db == nil {
+		return nil, fmt.Errorf("transcript search requires database storage")
+	}
+	sipCalls, err := models.SearchSipCallTranscripts(as.config.Db, keyword, limit)
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]TranscriptMatch, 0, len(sipCalls))
+	for _, sipCall := range sipCalls {
+		var segments []models.TranscriptSegment
+		for _, segment := range sipCall.TranscriptSegments {
+			if (speaker == "" || segment.Speaker == speaker) && strings.Contains(segment.Text, keyword) {
+				segments = append(segments, segment)
+			}
+		}
+		if len(segments) > 0 {
+			matches = append(matches, TranscriptMatch{CallID: sipCall.CallID, StartTime: sipCall.StartTime, Segments: segments})
+		}
+	}
+	return matches, nil
+}
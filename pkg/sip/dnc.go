@@ -0,0 +1,201 @@
+package sip1
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxDNCImportSize 免打扰名单CSV上传的最大字节数
+	maxDNCImportSize = 16 << 20
+
+	// contextDoNotCall 会话上下文中标记对端号码在免打扰名单中的键
+	contextDoNotCall = "do_not_call"
+
+	auditTargetDoNotCall = "do_not_call"
+)
+
+// ErrDoNotCall 目的号码在免打扰名单中
+type ErrDoNotCall struct {
+	Number string
+}
+
+func (e *ErrDoNotCall) Error() string {
+	return fmt.Sprintf("destination %s is on the do-not-call list", e.Number)
+}
+
+// checkDoNotCall 目的号码在免打扰名单中时记录拦截并返回ErrDoNotCall；查询失败时不拨打
+func (tm *TrunkManager) checkDoNotCall(groupID uint, toNumber string) error {
+	number := domesticNumber(toNumber)
+	if tm.db == nil || number == "" {
+		return nil
+	}
+	entry, err := models.FindDoNotCallEntry(tm.db, number)
+	if err != nil {
+		return fmt.Errorf("failed to check do-not-call list: %w", err)
+	}
+	if entry == nil {
+		return nil
+	}
+
+	logger.Warn("Outbound call suppressed by do-not-call list",
+		zap.Uint("group_id", groupID),
+		zap.String("to", toNumber),
+		zap.String("source", entry.Source))
+	suppression := &models.DoNotCallSuppression{PhoneNumber: number, EntryID: entry.ID, GroupID: groupID}
+	if err := models.CreateDoNotCallSuppression(tm.db, suppression); err != nil {
+		logger.Error("Failed to log suppressed call", zap.String("to", toNumber), zap.Error(err))
+	}
+	return &ErrDoNotCall{Number: toNumber}
+}
+
+// AddDoNotCall 把号码加入免打扰名单，已在名单中的跳过，返回新增数
+func (as *SipServer) AddDoNotCall(entries []models.DoNotCallEntry, source, actor string) (int64, error) {
+	db := as.config.Db
+	if db == nil {
+		return 0, errors.New("database not configured")
+	}
+	for i := range entries {
+		number := domesticNumber(entries[i].PhoneNumber)
+		if number == "" {
+			return 0, fmt.Errorf("invalid phone number: %q", entries[i].PhoneNumber)
+		}
+		entries[i].PhoneNumber = number
+		entries[i].Source = source
+		entries[i].CreatedBy = actor
+	}
+	added, err := models.AddDoNotCallEntries(db, entries)
+	if err != nil {
+		return 0, err
+	}
+	as.auditDoNotCall(actor, "dnc.added", map[string]interface{}{"source": source, "numbers": len(entries), "added": added})
+	return added, nil
+}
+
+// ImportDoNotCallCSV 从CSV导入免打扰名单，每行为号码和可选的原因，首行不是号码时视为表头
+func (as *SipServer) ImportDoNotCallCSV(r io.Reader, actor string) (int64, int, error) {
+	entries, err := parseDoNotCallCSV(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	added, err := as.AddDoNotCall(entries, models.DoNotCallSourceCSV, actor)
+	return added, len(entries), err
+}
+
+func parseDoNotCallCSV(r io.Reader) ([]models.DoNotCallEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	var entries []models.DoNotCallEntry
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if len(record) == 0 || strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		if domesticNumber(record[0]) == "" {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("invalid phone number %q on line %d", record[0], line)
+		}
+		entry := models.DoNotCallEntry{PhoneNumber: record[0]}
+		if len(record) > 1 {
+			entry.Reason = strings.TrimSpace(record[1])
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// RemoveDoNotCall 把号码移出免打扰名单
+func (as *SipServer) RemoveDoNotCall(number, actor string) error {
+	db := as.config.Db
+	if db == nil {
+		return errors.New("database not configured")
+	}
+	removed, err := models.DeleteDoNotCallEntry(db, domesticNumber(number))
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("%s is not on the do-not-call list", number)
+	}
+	as.auditDoNotCall(actor, "dnc.removed", map[string]interface{}{"number": domesticNumber(number)})
+	return nil
+}
+
+// auditDoNotCall 写入名单变更的审计日志，失败只记录日志
+func (as *SipServer) auditDoNotCall(actor, action string, detail map[string]interface{}) {
+	if err := models.CreateAuditLog(as.config.Db, actor, action, auditTargetDoNotCall, 0, detail); err != nil {
+		logger.Error("Failed to write audit log", zap.String("action", action), zap.Error(err))
+	}
+}
+
+// markInboundDoNotCall 呼入号码在免打扰名单中时在会话上下文中标记，脚本可按条件跳过营销步骤
+func (engine *AIPhoneEngine) markInboundDoNotCall(session *ScriptSession) {
+	number := domesticNumber(session.remoteNumber)
+	if engine.db == nil || number == "" {
+		return
+	}
+	entry, err := models.FindDoNotCallEntry(engine.db, number)
+	if err != nil {
+		logger.Warn("Failed to check do-not-call list", zap.String("call_id", session.CallID), zap.Error(err))
+		return
+	}
+	if entry == nil {
+		return
+	}
+	if err := session.Context.Set(contextDoNotCall, true); err != nil {
+		logger.Warn("Failed to mark do-not-call session", zap.String("call_id", session.CallID), zap.Error(err))
+		return
+	}
+	logger.Info("Inbound caller on do-not-call list", zap.String("call_id", session.CallID), zap.String("caller", session.remoteNumber))
+}
+
+// optOutDoNotCall DTMF步骤中对端按下退订键：号码加入免打扰名单，播放确认语后进入DNCNext，为空时结束脚本
+func (engine *AIPhoneEngine) optOutDoNotCall(session *ScriptSession, step *models.AIPhoneScriptStep, execution *models.StepExecution) (string, error) {
+	data := step.Data
+	number := domesticNumber(session.remoteNumber)
+	if number == "" {
+		return "", errors.New("remote number unknown, cannot opt out")
+	}
+	if engine.db == nil {
+		return "", errors.New("database not configured")
+	}
+	entry := models.DoNotCallEntry{
+		PhoneNumber: number,
+		Source:      models.DoNotCallSourceDTMF,
+		Reason:      fmt.Sprintf("pressed %s in step %s", data.DNCDigit, step.StepID),
+		CallID:      session.CallID,
+	}
+	if _, err := models.AddDoNotCallEntries(engine.db, []models.DoNotCallEntry{entry}); err != nil {
+		return "", fmt.Errorf("failed to add caller to do-not-call list: %w", err)
+	}
+	if err := session.Context.Set(contextDoNotCall, true); err != nil {
+		logger.Warn("Failed to mark do-not-call session", zap.String("call_id", session.CallID), zap.Error(err))
+	}
+	execution.Result = contextDoNotCall
+	logger.Info("Caller opted out to do-not-call list",
+		zap.String("call_id", session.CallID),
+		zap.String("number", session.remoteNumber))
+
+	if data.DNCConfirmText != "" {
+		if err := engine.playTTSAudio(session, data.DNCConfirmText, data.SpeakerID); err != nil {
+			logger.Warn("Failed to play opt-out confirmation", zap.String("call_id", session.CallID), zap.Error(err))
+		}
+		execution.TTSText = data.DNCConfirmText
+	}
+	return data.DNCNext, nil
+}
@@ -0,0 +1,122 @@
+package sip1
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/auth"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// dncPage 解析分页参数
+func dncPage(c *gin.Context) (int, int) {
+	offset, _ := strconv.Atoi(c.Query("offset"))
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 {
+		limit = sipUserPageSize
+	}
+	return max(offset, 0), min(limit, maxSipUserPageSize)
+}
+
+// RegisterDoNotCallAPIs 注册免打扰名单接口：POST /dnc {"numbers":[{"phoneNumber":"13800000000","reason":"投诉"}]} 添加号码，
+// POST /dnc/import（multipart，file为CSV，每行号码和可选的原因）批量导入，
+// GET /dnc?number=138&offset=0&limit=20 按号码前缀查看名单，GET /dnc/:number 查询号码是否在名单中，
+// DELETE /dnc/:number 移出名单，GET /dnc-suppressions?number=13800000000 查看被拦截的外呼；
+// 名单变更写入审计日志，通话中按DTMF步骤的dncDigit退订的号码来源为dtmf
+func RegisterDoNotCallAPIs(r gin.IRoutes, server *SipServer) {
+	r.POST("/dnc", requireActor, func(c *gin.Context) {
+		var form struct {
+			Numbers []struct {
+				PhoneNumber string `json:"phoneNumber" binding:"required"`
+				Reason      string `json:"reason"`
+			} `json:"numbers" binding:"required,min=1,dive"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		entries := make([]models.DoNotCallEntry, 0, len(form.Numbers))
+		for _, number := range form.Numbers {
+			entries = append(entries, models.DoNotCallEntry{PhoneNumber: number.PhoneNumber, Reason: number.Reason})
+		}
+		added, err := server.AddDoNotCall(entries, models.DoNotCallSourceAPI, auth.CurrentActor(c))
+		if err != nil {
+			response.Fail(c, "failed to add do-not-call numbers", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"added": added})
+	})
+
+	r.POST("/dnc/import", requireActor, func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxDNCImportSize)
+		header, err := c.FormFile("file")
+		if err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			response.Fail(c, "failed to read upload", err.Error())
+			return
+		}
+		defer file.Close()
+
+		added, rows, err := server.ImportDoNotCallCSV(file, auth.CurrentActor(c))
+		if err != nil {
+			response.Fail(c, "failed to import do-not-call list", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"rows": rows, "added": added})
+	})
+
+	r.GET("/dnc", func(c *gin.Context) {
+		if server.config.Db == nil {
+			response.Fail(c, "database not configured", nil)
+			return
+		}
+		offset, limit := dncPage(c)
+		entries, total, err := models.ListDoNotCallEntries(server.config.Db, domesticNumber(c.Query("number")), offset, limit)
+		if err != nil {
+			response.Fail(c, "failed to list do-not-call numbers", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"items": entries, "total": total})
+	})
+
+	r.GET("/dnc/:number", func(c *gin.Context) {
+		if server.config.Db == nil {
+			response.Fail(c, "database not configured", nil)
+			return
+		}
+		entry, err := models.FindDoNotCallEntry(server.config.Db, domesticNumber(c.Param("number")))
+		if err != nil {
+			response.Fail(c, "failed to check do-not-call list", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"listed": entry != nil, "entry": entry})
+	})
+
+	r.DELETE("/dnc/:number", requireActor, func(c *gin.Context) {
+		if err := server.RemoveDoNotCall(c.Param("number"), auth.CurrentActor(c)); err != nil {
+			response.Fail(c, "failed to remove do-not-call number", err.Error())
+			return
+		}
+		response.Success(c, "ok", nil)
+	})
+
+	r.GET("/dnc-suppressions", func(c *gin.Context) {
+		if server.config.Db == nil {
+			response.Fail(c, "database not configured", nil)
+			return
+		}
+		offset, limit := dncPage(c)
+		suppressions, total, err := models.ListDoNotCallSuppressions(server.config.Db, domesticNumber(c.Query("number")), offset, limit)
+		if err != nil {
+			response.Fail(c, "failed to list suppressed calls", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"items": suppressions, "total": total})
+	})
+}
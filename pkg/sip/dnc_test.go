@@ -0,0 +1,187 @@
+package sip1
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+	"github.com/gin-gonic/gin"
+)
+
+func newDNCTestServer(t *testing.T) *SipServer {
+	t.Helper()
+	db := newTestDB(t, &models.DoNotCallEntry{}, &models.DoNotCallSuppression{}, &models.AuditLog{})
+	return &SipServer{config: &ua.UAConfig{Db: db}}
+}
+
+func TestParseDoNotCallCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []models.DoNotCallEntry
+		wantErr bool
+	}{
+		{
+			name: "header skipped",
+			csv:  "phone,reason\n13800000000,投诉\n+8613900000000\n",
+			want: []models.DoNotCallEntry{{PhoneNumber: "13800000000", Reason: "投诉"}, {PhoneNumber: "+8613900000000"}},
+		},
+		{
+			name: "no header and blank lines",
+			csv:  "13800000000, 不要再打\n\n,\n13700000000\n",
+			want: []models.DoNotCallEntry{{PhoneNumber: "13800000000", Reason: "不要再打"}, {PhoneNumber: "13700000000"}},
+		},
+		{
+			name:    "invalid number after header",
+			csv:     "phone\n13800000000\nnot-a-number\n",
+			wantErr: true,
+		},
+		{
+			name:    "malformed csv",
+			csv:     "\"13800000000\n",
+			wantErr: true,
+		},
+		{
+			name: "empty",
+			csv:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDoNotCallCSV(strings.NewReader(tt.csv))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDoNotCallCSV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseDoNotCallCSV() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].PhoneNumber != tt.want[i].PhoneNumber || got[i].Reason != tt.want[i].Reason {
+					t.Errorf("entry %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDoNotCallList(t *testing.T) {
+	server := newDNCTestServer(t)
+	db := server.config.Db
+
+	entries := []models.DoNotCallEntry{{PhoneNumber: "+86 138-0000-0000", Reason: "投诉"}, {PhoneNumber: "13900000000"}}
+	added, err := server.AddDoNotCall(entries, models.DoNotCallSourceAPI, "alice")
+	if err != nil || added != 2 {
+		t.Fatalf("AddDoNotCall() = %d, %v, want 2 added", added, err)
+	}
+	// 国家码和分隔符不同的同一号码不会重复加入
+	if added, err := server.AddDoNotCall([]models.DoNotCallEntry{{PhoneNumber: "008613800000000"}}, models.DoNotCallSourceAPI, "alice"); err != nil || added != 0 {
+		t.Errorf("re-adding listed number = %d, %v, want 0 added", added, err)
+	}
+	if _, err := server.AddDoNotCall([]models.DoNotCallEntry{{PhoneNumber: "abc"}}, models.DoNotCallSourceAPI, "alice"); err == nil {
+		t.Error("AddDoNotCall(invalid number) expected error")
+	}
+
+	entry, err := models.FindDoNotCallEntry(db, "13800000000")
+	if err != nil || entry == nil {
+		t.Fatalf("FindDoNotCallEntry() = %v, %v", entry, err)
+	}
+	if entry.Source != models.DoNotCallSourceAPI || entry.CreatedBy != "alice" || entry.Reason != "投诉" {
+		t.Errorf("stored entry = %+v", entry)
+	}
+
+	// 外呼前检查：命中时返回ErrDoNotCall并记录拦截
+	tm := &TrunkManager{db: db}
+	var dncErr *ErrDoNotCall
+	if err := tm.checkDoNotCall(7, "+8613800000000"); !errors.As(err, &dncErr) {
+		t.Fatalf("checkDoNotCall(listed) error = %v, want ErrDoNotCall", err)
+	}
+	if err := tm.checkDoNotCall(7, "13700000000"); err != nil {
+		t.Errorf("checkDoNotCall(unlisted) error = %v", err)
+	}
+	suppressions, total, err := models.ListDoNotCallSuppressions(db, "13800000000", 0, 10)
+	if err != nil || total != 1 || suppressions[0].EntryID != entry.ID || suppressions[0].GroupID != 7 {
+		t.Errorf("suppressions = %+v (total %d, err %v)", suppressions, total, err)
+	}
+
+	if err := server.RemoveDoNotCall("+8613800000000", "bob"); err != nil {
+		t.Fatalf("RemoveDoNotCall() error = %v", err)
+	}
+	if err := server.RemoveDoNotCall("13800000000", "bob"); err == nil {
+		t.Error("removing an unlisted number expected error")
+	}
+	if err := tm.checkDoNotCall(7, "13800000000"); err != nil {
+		t.Errorf("checkDoNotCall(removed) error = %v", err)
+	}
+
+	var logs []models.AuditLog
+	db.Order("id").Find(&logs)
+	if len(logs) != 3 || logs[0].Actor != "alice" || logs[0].Action != "dnc.added" || logs[2].Actor != "bob" || logs[2].Action != "dnc.removed" {
+		t.Errorf("audit logs = %+v", logs)
+	}
+}
+
+func TestDoNotCallAPIs(t *testing.T) {
+	server := newDNCTestServer(t)
+	router := newAPITestRouter(func(r gin.IRoutes) { RegisterDoNotCallAPIs(r, server) })
+
+	add := map[string]interface{}{"actor": "mallory", "numbers": []map[string]string{{"phoneNumber": "13800000000"}}}
+	if res := callAPI(t, router, http.MethodPost, "/api/dnc", "", add); res.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated add code = %d, want 401", res.Code)
+	}
+	if res := callAPI(t, router, http.MethodPost, "/api/dnc", "alice", add); res.Code != 200 {
+		t.Fatalf("add: %+v", res)
+	}
+
+	// CSV导入的操作人同样取认证身份，不取表单字段
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("actor", "mallory")
+	part, _ := form.CreateFormFile("file", "dnc.csv")
+	part.Write([]byte("phone,reason\n13900000000,投诉\n13800000000\n"))
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/dnc/import", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("X-Test-Actor", "bob")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var res apiResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Code != 200 {
+		t.Fatalf("import: %s", w.Body.String())
+	}
+	var imported struct {
+		Added int64 `json:"added"`
+		Rows  int   `json:"rows"`
+	}
+	json.Unmarshal(res.Data, &imported)
+	if imported.Added != 1 || imported.Rows != 2 {
+		t.Errorf("import result = %s, want 2 rows, 1 added", res.Data)
+	}
+
+	res = callAPI(t, router, http.MethodGet, "/api/dnc/+8613900000000", "bob", nil)
+	var lookup struct {
+		Listed bool                   `json:"listed"`
+		Entry  *models.DoNotCallEntry `json:"entry"`
+	}
+	if err := json.Unmarshal(res.Data, &lookup); err != nil || !lookup.Listed || lookup.Entry.CreatedBy != "bob" || lookup.Entry.Source != models.DoNotCallSourceCSV {
+		t.Errorf("lookup imported number = %s", res.Data)
+	}
+
+	if res := callAPI(t, router, http.MethodDelete, "/api/dnc/13800000000", "", nil); res.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated delete code = %d, want 401", res.Code)
+	}
+	if res := callAPI(t, router, http.MethodDelete, "/api/dnc/13800000000", "alice", nil); res.Code != 200 {
+		t.Errorf("delete: %+v", res)
+	}
+	var actors []string
+	server.config.Db.Model(&models.AuditLog{}).Order("id").Pluck("actor", &actors)
+	if strings.Join(actors, ",") != "alice,bob,alice" {
+		t.Errorf("audit actors = %v, want alice,bob,alice", actors)
+	}
+}
@@ -0,0 +1,102 @@
+package sip1
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// echoTail 回声最长延迟（网络往返+终端回声路径）
+	echoTail = 300 * time.Millisecond
+	// echoCorrelationThreshold 入向帧与已播放音频的归一化互相关超过该值时判为回声
+	echoCorrelationThreshold = 0.5
+	// echoDoubleTalkRatio 入向能量超过参考能量该倍数时认为用户在说话（双讲），不做抑制
+	echoDoubleTalkRatio = 4.0
+)
+
+// echoSuppressor 回声抑制：记录最近播放给主叫的音频作为参考，入向帧与参考在回声延迟范围内
+// 相关性高时判为回声，用于屏蔽VAD/ASR，避免提示音回声被当成用户说话
+type echoSuppressor struct {
+	mutex        sync.Mutex
+	sampleRate   int
+	reference    []int16
+	lastPlayback time.Time
+
+	EchoFrames int // 被判为回声的入向帧数
+}
+
+func newEchoSuppressor(sampleRate int) *echoSuppressor {
+	return &echoSuppressor{sampleRate: sampleRate}
+}
+
+// AddReference 记录发送出去的一帧音频
+func (e *echoSuppressor) AddReference(samples []int16) {
+	if e == nil {
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// 保留回声延迟窗口再加一帧的余量
+	maxLen := e.sampleRate*int(echoTail/time.Millisecond)/1000 + len(samples)
+	e.reference = append(e.reference, samples...)
+	if len(e.reference) > maxLen {
+		e.reference = append(e.reference[:0], e.reference[len(e.reference)-maxLen:]...)
+	}
+	e.lastPlayback = time.Now()
+}
+
+// IsEcho 判断入向帧是否主要是播放音频的回声
+func (e *echoSuppressor) IsEcho(frame []int16) bool {
+	if e == nil || len(frame) == 0 {
+		return false
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if time.Since(e.lastPlayback) > echoTail || len(e.reference) < len(frame) {
+		return false
+	}
+
+	frameEnergy := energy(frame)
+	if frameEnergy == 0 {
+		return false
+	}
+	refEnergy := energy(e.reference) * float64(len(frame)) / float64(len(e.reference))
+	if frameEnergy > refEnergy*echoDoubleTalkRatio {
+		return false
+	}
+
+	// 每1ms一个延迟候选，取最大归一化互相关
+	step := max(e.sampleRate/1000, 1)
+	best := 0.0
+	for offset := 0; offset+len(frame) <= len(e.reference); offset += step {
+		window := e.reference[offset : offset+len(frame)]
+		windowEnergy := energy(window)
+		if windowEnergy == 0 {
+			continue
+		}
+		var dot float64
+		for i, sample := range frame {
+			dot += float64(sample) * float64(window[i])
+		}
+		if corr := math.Abs(dot) / math.Sqrt(frameEnergy*windowEnergy); corr > best {
+			best = corr
+		}
+	}
+
+	if best >= echoCorrelationThreshold {
+		e.EchoFrames++
+		return true
+	}
+	return false
+}
+
+func energy(samples []int16) float64 {
+	var sum float64
+	for _, sample := range samples {
+		sum += float64(sample) * float64(sample)
+	}
+	return sum
+}
@@ -0,0 +1,316 @@
+package sip1
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/pion/rtp"
+	"go.uber.org/zap"
+)
+
+const (
+	// embeddedInboundFrames 入向音频缓冲，约1秒；引擎不在监听时写满后丢弃新帧，与UDP接收缓冲一致
+	embeddedInboundFrames = 50
+	// embeddedEventBuffer 通话事件缓冲，写满后丢弃，不阻塞通话
+	embeddedEventBuffer = 256
+)
+
+// EmbeddedOptions 嵌入式通话的参数
+type EmbeddedOptions struct {
+	CallID     string // 为空时自动生成
+	Caller     string // 主叫标识，如App用户或终端编号，写入会话记录
+	SampleRate int    // 输入输出PCM的采样率，8000或16000，默认8000
+}
+
+// EmbeddedCall 不经SIP的通话：调用方以16位小端单声道PCM喂入用户语音，引擎把合成语音写入output，
+// 用于App、自助终端等渠道复用脚本引擎
+type EmbeddedCall struct {
+	CallID string
+
+	engine *AIPhoneEngine
+	conn   *pcmConn
+	events chan MonitorEvent
+	done   chan struct{}
+}
+
+// StartEmbedded 启动被叫号码对应脚本的嵌入式通话。input需按实时节奏提供音频（如麦克风采集），
+// 读到EOF视为用户挂断；output按20ms一帧实时写入合成语音。引擎可不依附SipServer（NewAIPhoneEngine传nil），
+// 此时注入AIServices或先调用config.Load加载识别和合成配置
+func (engine *AIPhoneEngine) StartEmbedded(phoneNumber string, input io.Reader, output io.Writer, opts EmbeddedOptions) (*EmbeddedCall, error) {
+	sampleRate := opts.SampleRate
+	if sampleRate == 0 {
+		sampleRate = 8000
+	}
+	if sampleRate != 8000 && sampleRate != 16000 {
+		return nil, fmt.Errorf("unsupported sample rate %d, want 8000 or 16000", sampleRate)
+	}
+	callID := opts.CallID
+	if callID == "" {
+		callID = "embedded-" + uuid.NewString()
+	}
+	if engine.GetSession(callID) != nil {
+		return nil, fmt.Errorf("call %s already exists", callID)
+	}
+
+	codec := newLinearCodec(sampleRate)
+	conn := newPCMConn(codec, output)
+	conn.onEnd = func() { engine.StopSession(callID) }
+	call := &EmbeddedCall{
+		CallID: callID,
+		engine: engine,
+		conn:   conn,
+		events: make(chan MonitorEvent, embeddedEventBuffer),
+		done:   make(chan struct{}),
+	}
+
+	// 先订阅再启动脚本，不会错过会话开始和结束事件
+	events, unsubscribe := engine.monitor.Subscribe()
+	if _, err := engine.startScript(scriptCall{
+		callID:       callID,
+		clientAddr:   conn.remote.String(),
+		phoneNumber:  phoneNumber,
+		callerNumber: opts.Caller,
+		codec:        codec,
+		media:        conn,
+	}); err != nil {
+		unsubscribe()
+		return nil, err
+	}
+	go conn.readInput(input)
+	go call.forwardEvents(events, unsubscribe)
+
+	logger.Info("Embedded call started",
+		zap.String("call_id", callID),
+		zap.String("phone", phoneNumber),
+		zap.Int("sample_rate", sampleRate))
+	return call, nil
+}
+
+// Events 通话的会话、步骤、识别和对话消息事件，会话结束后关闭
+func (c *EmbeddedCall) Events() <-chan MonitorEvent {
+	return c.events
+}
+
+// Done 会话结束时关闭
+func (c *EmbeddedCall) Done() <-chan struct{} {
+	return c.done
+}
+
+// SendDigit 发送按键（0-9、*、#、A-D），按RFC 4733事件送入引擎，供按键菜单和录音告知使用
+func (c *EmbeddedCall) SendDigit(digit string) error {
+	for event, d := range telephoneEventDigits {
+		if d == digit {
+			return c.conn.injectEvent(event)
+		}
+	}
+	return fmt.Errorf("invalid digit: %q", digit)
+}
+
+// Hangup 用户挂断，停止脚本会话，可重复调用
+func (c *EmbeddedCall) Hangup() {
+	c.engine.StopSession(c.CallID)
+}
+
+// forwardEvents 转发本通话的监控事件，会话结束时关闭连接和事件通道
+func (c *EmbeddedCall) forwardEvents(events <-chan MonitorEvent, unsubscribe func()) {
+	defer func() {
+		unsubscribe()
+		c.conn.Close()
+		close(c.events)
+		close(c.done)
+		logger.Info("Embedded call ended", zap.String("call_id", c.CallID))
+	}()
+	for event := range events {
+		if event.CallID != c.CallID {
+			continue
+		}
+		select {
+		case c.events <- event:
+		default:
+		}
+		if event.Type == MonitorSessionEnded {
+			return
+		}
+	}
+}
+
+// pcmConn 把嵌入式通话的PCM流包装成引擎使用的RTP连接：读取时返回input分帧封装的RTP包，
+// 写入时把引擎发送的音频解出写到output
+type pcmConn struct {
+	codec  rtpCodec
+	remote *net.UDPAddr
+	output io.Writer
+	onEnd  func()
+
+	inbound chan []byte
+	closed  chan struct{}
+
+	mutex     sync.Mutex
+	deadline  time.Time
+	sequence  uint16
+	timestamp uint32
+	closeOnce sync.Once
+	endOnce   sync.Once
+}
+
+func newPCMConn(codec rtpCodec, output io.Writer) *pcmConn {
+	return &pcmConn{
+		codec:   codec,
+		remote:  &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1},
+		output:  output,
+		inbound: make(chan []byte, embeddedInboundFrames),
+		closed:  make(chan struct{}),
+	}
+}
+
+// readInput 按20ms分帧读取input，读完或出错时结束通话
+func (c *pcmConn) readInput(input io.Reader) {
+	frame := make([]byte, c.codec.FrameSamples()*2)
+	for {
+		if _, err := io.ReadFull(input, frame); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				logger.Warn("Embedded call input failed", zap.Error(err))
+			}
+			c.end()
+			return
+		}
+		c.mutex.Lock()
+		c.sequence++
+		header := rtp.Header{
+			Version:        2,
+			PayloadType:    c.codec.PayloadType,
+			SequenceNumber: c.sequence,
+			Timestamp:      c.timestamp,
+			SSRC:           54321,
+		}
+		c.timestamp += uint32(c.codec.FrameSamples())
+		c.mutex.Unlock()
+		c.push(&rtp.Packet{Header: header, Payload: append([]byte(nil), frame...)})
+	}
+}
+
+// injectEvent 追加一次按键的RFC 4733事件包：开始包和重发3次的结束包共享时间戳
+func (c *pcmConn) injectEvent(event byte) error {
+	duration := uint16(c.codec.FrameSamples() * 5) // 100ms
+	c.mutex.Lock()
+	timestamp := c.timestamp
+	c.timestamp += uint32(duration)
+	c.mutex.Unlock()
+	for i := 0; i < 4; i++ {
+		flags := byte(10) // 音量-10dBm0
+		if i > 0 {
+			flags |= 0x80
+		}
+		c.mutex.Lock()
+		c.sequence++
+		sequence := c.sequence
+		c.mutex.Unlock()
+		packet := &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         i == 0,
+				PayloadType:    c.codec.TelephoneEvent,
+				SequenceNumber: sequence,
+				Timestamp:      timestamp,
+				SSRC:           54321,
+			},
+			Payload: []byte{event, flags, byte(duration >> 8), byte(duration)},
+		}
+		if !c.push(packet) {
+			return fmt.Errorf("call ended or input buffer full")
+		}
+	}
+	return nil
+}
+
+// push 放入入向缓冲，缓冲已满或连接已关闭时丢弃
+func (c *pcmConn) push(packet *rtp.Packet) bool {
+	data, err := packet.Marshal()
+	if err != nil {
+		return false
+	}
+	select {
+	case <-c.closed:
+		return false
+	case c.inbound <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// ReadFromUDP 读出下一个入向包，没有包时在截止时间返回超时
+func (c *pcmConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	c.mutex.Lock()
+	deadline := c.deadline
+	c.mutex.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case data := <-c.inbound:
+		return copy(b, data), c.remote, nil
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+// WriteToUDP 解出引擎发送的音频写入output，舒适噪音等其他载荷忽略；output出错时结束通话
+func (c *pcmConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(b); err != nil {
+		return 0, err
+	}
+	if packet.PayloadType != c.codec.PayloadType {
+		return len(b), nil
+	}
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if _, err := c.output.Write(packet.Payload); err != nil {
+		c.end()
+		return 0, fmt.Errorf("failed to write embedded audio: %w", err)
+	}
+	return len(b), nil
+}
+
+// SetReadDeadline 设置读取截止时间，零值表示不超时
+func (c *pcmConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.deadline = t
+	c.mutex.Unlock()
+	return nil
+}
+
+// Close 会话结束后关闭，之后的读写返回net.ErrClosed
+func (c *pcmConn) Close() {
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+// end 输入结束或输出失败时挂断，只触发一次
+func (c *pcmConn) end() {
+	c.endOnce.Do(func() {
+		if c.onEnd != nil {
+			c.onEnd()
+		}
+	})
+}
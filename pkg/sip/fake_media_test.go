@@ -0,0 +1,175 @@
+package sip1
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// fakeRTPConn 内存中的RTP连接：Inject的包按顺序读出，发送的包记录在Sent中。
+// 指定假时钟时，没有待读包的读取会把时钟推进到读取截止时间后返回超时，
+// 使静音检测和超时完全由假时间驱动
+type fakeRTPConn struct {
+	mutex    sync.Mutex
+	remote   *net.UDPAddr
+	clock    *fakeClock
+	inbound  [][]byte
+	sent     []*rtp.Packet
+	deadline time.Time
+	arrived  chan struct{}
+
+	sequence  uint16 // InjectPCM生成包的序列号和时间戳
+	timestamp uint32
+}
+
+// newFakeRTPConn 创建假连接，remote为读出包的来源地址，clock为nil时按真实时间等待截止
+func newFakeRTPConn(remote *net.UDPAddr, clock *fakeClock) *fakeRTPConn {
+	return &fakeRTPConn{
+		remote:  remote,
+		clock:   clock,
+		arrived: make(chan struct{}, 1),
+	}
+}
+
+// Inject 追加一个待读出的RTP包
+func (c *fakeRTPConn) Inject(packet *rtp.Packet) error {
+	data, err := packet.Marshal()
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.inbound = append(c.inbound, data)
+	c.mutex.Unlock()
+	select {
+	case c.arrived <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// InjectPCM 按20ms分帧编码samples并追加为连续的RTP包
+func (c *fakeRTPConn) InjectPCM(codec rtpCodec, samples []int16) error {
+	encode, err := newRTPEncoder(codec, codec.SampleRate)
+	if err != nil {
+		return err
+	}
+	frameSamples := codec.FrameSamples()
+	for offset := 0; offset+frameSamples <= len(samples); offset += frameSamples {
+		payload, err := encode(samples[offset : offset+frameSamples])
+		if err != nil {
+			return err
+		}
+		c.mutex.Lock()
+		c.sequence++
+		header := rtp.Header{
+			Version:        2,
+			PayloadType:    codec.PayloadType,
+			SequenceNumber: c.sequence,
+			Timestamp:      c.timestamp,
+			SSRC:           54321,
+		}
+		c.timestamp += uint32(frameSamples)
+		c.mutex.Unlock()
+		if err := c.Inject(&rtp.Packet{Header: header, Payload: payload}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InjectDigit 追加一个RFC 2833按键的结束包，序列号和时间戳与InjectPCM连续
+func (c *fakeRTPConn) InjectDigit(payloadType, event uint8) error {
+	c.mutex.Lock()
+	c.sequence++
+	header := rtp.Header{
+		Version:        2,
+		Marker:         true,
+		PayloadType:    payloadType,
+		SequenceNumber: c.sequence,
+		Timestamp:      c.timestamp,
+		SSRC:           54321,
+	}
+	c.timestamp += 160
+	c.mutex.Unlock()
+	// 结束位、音量10、持续800个采样
+	return c.Inject(&rtp.Packet{Header: header, Payload: []byte{event, 0x80 | 10, 0x03, 0x20}})
+}
+
+// Sent 返回已发送的RTP包
+func (c *fakeRTPConn) Sent() []*rtp.Packet {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]*rtp.Packet(nil), c.sent...)
+}
+
+// Pending 返回尚未读出的包数量
+func (c *fakeRTPConn) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.inbound)
+}
+
+// ReadFromUDP 读出下一个注入的包，没有包时在截止时间返回超时
+func (c *fakeRTPConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	for {
+		c.mutex.Lock()
+		if len(c.inbound) > 0 {
+			data := c.inbound[0]
+			c.inbound = c.inbound[1:]
+			c.mutex.Unlock()
+			// 假时钟下每读出一个包推进一帧时长
+			if c.clock != nil {
+				c.clock.Advance(20 * time.Millisecond)
+			}
+			return copy(b, data), c.remote, nil
+		}
+		deadline := c.deadline
+		c.mutex.Unlock()
+
+		if c.clock != nil {
+			// 未设置截止时间时按一帧推进，避免被测循环卡住
+			if deadline.IsZero() {
+				deadline = c.clock.Now().Add(20 * time.Millisecond)
+			}
+			c.clock.AdvanceTo(deadline)
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+
+		if deadline.IsZero() {
+			<-c.arrived
+			continue
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+		select {
+		case <-c.arrived:
+		case <-time.After(wait):
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// WriteToUDP 记录发送的RTP包
+func (c *fakeRTPConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(append([]byte(nil), b...)); err != nil {
+		return 0, err
+	}
+	c.mutex.Lock()
+	c.sent = append(c.sent, packet)
+	c.mutex.Unlock()
+	return len(b), nil
+}
+
+// SetReadDeadline 设置读取截止时间，零值表示不超时
+func (c *fakeRTPConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.deadline = t
+	c.mutex.Unlock()
+	return nil
+}
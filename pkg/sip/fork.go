@@ -0,0 +1,229 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+// ErrNoBindings 用户没有已注册的联系地址
+var ErrNoBindings = errors.New("user has no registered contacts")
+
+// ForkAnswer 分叉呼叫中接通的分支
+type ForkAnswer struct {
+	Binding  ua.Binding
+	Invite   *sip.Request
+	Response *sip.Response
+	Dialog   *CallDialog // 已确认并保存，可用sendBye挂断
+}
+
+// inviteRejectedError INVITE收到非2xx最终响应
+type inviteRejectedError struct {
+	res *sip.Response
+}
+
+func (e *inviteRejectedError) Error() string {
+	return fmt.Sprintf("INVITE rejected: %d %s", e.res.StatusCode, e.res.Reason)
+}
+
+// forkResult 一个分支的最终结果，res为2xx或err非空
+type forkResult struct {
+	binding ua.Binding
+	invite  *sip.Request
+	res     *sip.Response
+	err     error
+}
+
+// InviteUser 向用户注册的所有联系地址分叉发送INVITE（RFC 3261 16.6）：按q值从高到低分组，
+// 同组的联系地址同时振铃，第一个2xx接通并取消其余分支，整组都失败时才尝试下一组。
+// newInvite为每个分支创建INVITE，振铃时长由ctx控制，ctx取消后发送CANCEL
+func (as *SipServer) InviteUser(ctx context.Context, username string, newInvite func(ua.Binding) *sip.Request) (*ForkAnswer, error) {
+	bindings, err := as.config.Bindings(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up bindings of %s: %w", username, err)
+	}
+	if len(bindings) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoBindings, username)
+	}
+
+	var lastErr error
+	for _, group := range groupBindingsByQ(bindings) {
+		answer, err := as.forkInvite(ctx, group, newInvite)
+		if err == nil {
+			return answer, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// groupBindingsByQ 把已按q值降序排列的联系地址按相同q值分组
+func groupBindingsByQ(bindings []ua.Binding) [][]ua.Binding {
+	var groups [][]ua.Binding
+	for i, binding := range bindings {
+		if i == 0 || binding.Q != bindings[i-1].Q {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], binding)
+	}
+	return groups
+}
+
+// forkInvite 并行呼叫一组联系地址，返回第一个接通的分支
+func (as *SipServer) forkInvite(ctx context.Context, bindings []ua.Binding, newInvite func(ua.Binding) *sip.Request) (*ForkAnswer, error) {
+	forkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan forkResult, len(bindings))
+	for _, binding := range bindings {
+		invite := newInvite(binding)
+		invite.SetDestination(binding.Contact)
+		go func() {
+			res, err := as.ringBranch(forkCtx, invite)
+			results <- forkResult{binding: binding, invite: invite, res: res, err: err}
+		}()
+	}
+
+	var lastErr error
+	for pending := len(bindings); pending > 0; pending-- {
+		result := <-results
+		if result.err != nil {
+			logger.Info("Fork branch failed",
+				zap.String("username", result.binding.Username),
+				zap.String("contact", result.binding.Contact),
+				zap.Error(result.err))
+			lastErr = result.err
+			continue
+		}
+
+		dialog, err := as.confirmBranch(result)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		as.saveDialog(dialog)
+		as.startOutboundSessionTimer(dialog, result.res)
+		cancel()
+		logger.Info("Fork branch answered",
+			zap.String("call_id", dialog.CallID),
+			zap.String("username", result.binding.Username),
+			zap.String("contact", result.binding.Contact))
+
+		// 取消前已接通的分支仍会返回2xx，确认后立即挂断
+		go func(pending int) {
+			for ; pending > 0; pending-- {
+				if late := <-results; late.err == nil {
+					as.hangupBranch(late)
+				}
+			}
+		}(pending - 1)
+		return &ForkAnswer{Binding: result.binding, Invite: result.invite, Response: result.res, Dialog: dialog}, nil
+	}
+	return nil, lastErr
+}
+
+// ringBranch 发送一个分支的INVITE并等待最终响应；ctx取消后收到过临时响应才能发送CANCEL，
+// 之后最多再等待TransactionTimeout的487等最终响应
+func (as *SipServer) ringBranch(ctx context.Context, invite *sip.Request) (*sip.Response, error) {
+	as.offerSessionTimer(invite)
+	tx, err := as.client.TransactionRequest(context.Background(), invite, as.clientMaxForwards, sipgo.ClientRequestBuild)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send INVITE: %w", err)
+	}
+	defer tx.Terminate()
+
+	cancelled := ctx.Done()
+	var giveUp <-chan time.Time
+	provisional := false
+	for {
+		select {
+		case res := <-tx.Responses():
+			if res.IsProvisional() {
+				if !provisional && giveUp != nil {
+					go as.cancelBranch(invite)
+				}
+				provisional = true
+				continue
+			}
+			if !res.IsSuccess() {
+				return nil, &inviteRejectedError{res: res}
+			}
+			return res, nil
+		case <-tx.Done():
+			return nil, fmt.Errorf("INVITE transaction ended: %w", tx.Err())
+		case <-cancelled:
+			cancelled = nil
+			giveUp = time.After(as.config.TransactionTimeout)
+			if provisional {
+				go as.cancelBranch(invite)
+			}
+		case <-giveUp:
+			return nil, fmt.Errorf("INVITE cancelled: %w", ctx.Err())
+		}
+	}
+}
+
+// cancelBranch 发送CANCEL取消仍在振铃的分支
+func (as *SipServer) cancelBranch(invite *sip.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), as.config.TransactionTimeout)
+	defer cancel()
+
+	tx, err := as.client.TransactionRequest(ctx, sip.NewCancelRequest(invite), as.clientMaxForwards, sipgo.ClientRequestBuild)
+	if err != nil {
+		logger.Warn("Failed to send CANCEL",
+			zap.String("call_id", invite.CallID().Value()),
+			zap.String("destination", invite.Destination()),
+			zap.Error(err))
+		return
+	}
+	defer tx.Terminate()
+
+	select {
+	case <-tx.Responses():
+	case <-tx.Done():
+	case <-ctx.Done():
+	}
+}
+
+// confirmBranch 对分支的2xx发送ACK，返回建立的对话
+func (as *SipServer) confirmBranch(result forkResult) (*CallDialog, error) {
+	dialog, err := newOutboundDialog(result.invite, result.res)
+	if err != nil {
+		return nil, err
+	}
+	ack := sip.NewAckRequest(result.invite, result.res, nil)
+	if dialog.Transport != "" {
+		ack.SetTransport(dialog.Transport)
+	}
+	if len(dialog.RouteSet) == 0 {
+		ack.SetDestination(dialog.Destination)
+	}
+	if err := as.client.WriteRequest(ack, as.clientMaxForwards, sipgo.ClientRequestBuild); err != nil {
+		return nil, fmt.Errorf("failed to send ACK: %w", err)
+	}
+	return dialog, nil
+}
+
+// hangupBranch 确认并挂断另一个分支已接通后才返回2xx的分支，不保存它的对话（各分支可能共用Call-ID）
+func (as *SipServer) hangupBranch(result forkResult) {
+	dialog, err := as.confirmBranch(result)
+	if err == nil {
+		err = as.byeDialog(dialog)
+	}
+	if err != nil {
+		logger.Warn("Failed to hang up late fork branch",
+			zap.String("username", result.binding.Username),
+			zap.String("contact", result.binding.Contact),
+			zap.Error(err))
+	}
+}
@@ -1,14 +1,16 @@
 package sip1
 
 import (
-	"encoding/binary"
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/audio"
 	"github.com/LingByte/LingSIP/pkg/logger"
 	"github.com/LingByte/LingSIP/pkg/sip/ua"
 	"github.com/emiago/sipgo/sip"
@@ -18,21 +20,27 @@ import (
 )
 
 func (as *SipServer) RegisterFunc() {
-	as.server.OnRegister(as.handleRegister) // user login/register will onRegister
-	as.server.OnInvite(as.handleInvite)     // user invite
-	as.server.OnOptions(as.handleOptions)   // return server methods
-	as.server.OnAck(as.handleAck)           // ack （before receive invite 200）
-	as.server.OnCancel(as.handleCancel)
-	as.server.OnBye(as.handleBye)
-	as.server.OnInfo(as.handleInfo)
-	as.server.OnPublish(as.handlePublish)
+	as.server.OnRegister(as.handle(sip.REGISTER, as.handleRegister)) // user login/register will onRegister
+	as.server.OnInvite(as.handle(sip.INVITE, as.handleInvite))       // user invite
+	as.server.OnOptions(as.handle(sip.OPTIONS, as.handleOptions))    // return server methods
+	as.server.OnAck(as.handle(sip.ACK, as.handleAck))                // ack （before receive invite 200）
+	as.server.OnCancel(as.handle(sip.CANCEL, as.handleCancel))
+	as.server.OnBye(as.handle(sip.BYE, as.handleBye))
+	as.server.OnInfo(as.handle(sip.INFO, as.handleInfo))
+	as.server.OnPublish(as.handle(sip.PUBLISH, as.handlePublish))
+	as.server.OnNotify(as.handle(sip.NOTIFY, as.handleNotify))
+	as.server.OnRefer(as.handle(sip.REFER, as.handleRefer))
+	as.server.OnUpdate(as.handle(sip.UPDATE, as.handleUpdate))
+	as.server.OnMessage(as.handle(sip.MESSAGE, as.handleMessage))
+	as.server.OnSubscribe(as.handle(sip.SUBSCRIBE, as.handleSubscribe))
 }
 
-// handleRegister handles SIP REGISTER requests based on configured storage type
+// handleRegister handles SIP REGISTER requests based on configured storage type.
+// Each Contact is a separate binding of the user, so several devices can be registered at once.
 func (as *SipServer) handleRegister(req *sip.Request, tx sip.ServerTransaction) {
-	logger.Info(fmt.Sprintf("RECEIVED REGISTER REQUEST %v", req.StartLine()))
-	// Extract registration information from request
-	info := as.config.ExtractRegistrationInfo(req)
+	// Extract registration information from request, one per Contact
+	infos := as.config.ExtractRegistrations(req)
+	info := infos[0]
 	// Validate username
 	if info.Username == "" {
 		logger.Warn("REGISTER request missing username in From header")
@@ -42,115 +50,137 @@ func (as *SipServer) handleRegister(req *sip.Request, tx sip.ServerTransaction)
 		}
 		return
 	}
+	// Challenge for and verify digest credentials of the user (RFC 3261 22.4)
+	if !as.authorizeRegister(req, tx, info.Username) {
+		return
+	}
 
-	// Process based on storage type configuration
-	var saveErr error
-	switch as.config.StorageType {
-	case ua.StorageTypeDatabase:
-		logger.Info("Processing registration with database storage", zap.String("username", info.Username))
-
-		if as.config.Db == nil {
-			logger.Warn("Database not configured for database storage type, falling back to memory storage")
-			if info.ContactStr != "" {
-				contact := fmt.Sprintf("%s:%d", info.ContactIP, info.ContactPort)
-				as.config.SetRegisteredUser(info.Username, contact)
+	// Contact: * only unregisters all bindings, must be the only Contact and requires Expires: 0 (RFC 3261 10.3)
+	for _, contact := range infos {
+		if contact.Wildcard && (len(infos) > 1 || contact.Expires != 0) {
+			logger.Warn("REGISTER with Contact: * and other contacts or non-zero Expires", zap.String("username", info.Username))
+			if err := tx.Respond(sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil)); err != nil {
+				logger.Error("Failed to send 400 response", zap.Error(err))
 			}
-			break
+			return
 		}
-		// Save to database
-		saveErr = as.config.SaveRegistrationToDatabase(info)
-		if saveErr != nil {
-			logger.Error("Failed to save registration to database", zap.Error(saveErr))
+	}
+	// A REGISTER without Contact queries the current bindings and changes nothing
+	if info.ContactStr == "" && !info.Wildcard {
+		as.respondBindings(req, tx, info.Username, nil)
+		return
+	}
+
+	for _, contact := range infos {
+		if err := as.config.SaveRegistration(contact); err != nil {
+			logger.Error("Failed to save registration", zap.String("username", contact.Username), zap.Error(err))
 			// Determine error type and return appropriate response
-			errMsg := saveErr.Error()
-			if strings.Contains(errMsg, "not found") || strings.Contains(errMsg, "disabled") {
-				status := sip.StatusUnauthorized
-				statusText := "Unauthorized"
-				if strings.Contains(errMsg, "disabled") {
-					status = sip.StatusForbidden
-					statusText = "Forbidden"
-				}
-				res := sip.NewResponseFromRequest(req, status, statusText, nil)
-				if err := tx.Respond(res); err != nil {
-					logger.Error("Failed to send response", zap.Error(err))
-				}
-				return
+			status := sip.StatusInternalServerError
+			statusText := "Internal Server Error"
+			if errMsg := err.Error(); strings.Contains(errMsg, "disabled") {
+				status = sip.StatusForbidden
+				statusText = "Forbidden"
+			} else if strings.Contains(errMsg, "not found") {
+				status = sip.StatusUnauthorized
+				statusText = "Unauthorized"
 			}
-			// Database error
-			res := sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil)
+			res := sip.NewResponseFromRequest(req, status, statusText, nil)
 			if err := tx.Respond(res); err != nil {
-				logger.Error("Failed to send 500 response", zap.Error(err))
+				logger.Error("Failed to send response", zap.Error(err))
 			}
 			return
 		}
 
-		logger.Info("SIP user registered successfully in database",
-			zap.String("username", info.Username),
-			zap.String("contact", info.ContactStr),
-			zap.Int("expires", info.Expires))
-
-	case ua.StorageTypeFile:
-		logger.Info("Processing registration with file storage", zap.String("username", info.Username))
-
-		// Save to file
-		saveErr = as.config.SaveRegistrationToFile(info)
-		if saveErr != nil {
-			logger.Error("Failed to save registration to file", zap.Error(saveErr))
-			res := sip.NewResponseFromRequest(req, sip.StatusInternalServerError, "Internal Server Error", nil)
-			if err := tx.Respond(res); err != nil {
-				logger.Error("Failed to send 500 response", zap.Error(err))
-			}
-			return
+		if contact.Expires <= 0 {
+			logger.Info("SIP user unregistered",
+				zap.String("username", contact.Username),
+				zap.String("contact", contact.ContactStr),
+				zap.Bool("wildcard", contact.Wildcard))
+		} else {
+			logger.Info("SIP user registered successfully",
+				zap.String("username", contact.Username),
+				zap.String("contact", contact.ContactStr),
+				zap.Int("expires", contact.Expires),
+				zap.Float64("q", contact.Q))
 		}
+	}
 
-		logger.Info("SIP user registered successfully in file storage",
-			zap.String("username", info.Username),
-			zap.String("contact", info.ContactStr),
-			zap.Int("expires", info.Expires))
-
-	case ua.StorageTypeMemory:
-		logger.Info("Processing registration with memory storage", zap.String("username", info.Username))
+	as.presenceChanged(info.Username)
 
-		if info.ContactStr != "" {
-			contact := fmt.Sprintf("%s:%d", info.ContactIP, info.ContactPort)
-			as.config.SetRegisteredUser(info.Username, contact)
-		}
-
-		logger.Info("SIP user registered successfully in memory storage",
-			zap.String("username", info.Username),
-			zap.String("contact", info.ContactStr),
-			zap.Int("expires", info.Expires))
+	// Add Expires header using the extracted expires value when a single contact was registered
+	var expires *sip.ExpiresHeader
+	if len(infos) == 1 && info.Expires > 0 {
+		header := sip.ExpiresHeader(info.Expires)
+		expires = &header
+	}
+	as.respondBindings(req, tx, info.Username, expires)
+}
 
-	default:
-		logger.Warn("Unknown storage type, defaulting to memory storage", zap.String("storage_type", string(as.config.StorageType)))
-		logger.Info("SIP user registered successfully in memory storage (default)",
-			zap.String("username", info.Username),
-			zap.String("contact", info.ContactStr),
-			zap.Int("expires", info.Expires))
+// respondBindings answers a REGISTER with 200 OK listing all current bindings of the user,
+// each with its remaining expiry and q-value (RFC 3261 10.3)
+func (as *SipServer) respondBindings(req *sip.Request, tx sip.ServerTransaction, username string, expires *sip.ExpiresHeader) {
+	bindings, err := as.config.Bindings(username)
+	if err != nil {
+		logger.Warn("Failed to load bindings for REGISTER response", zap.String("username", username), zap.Error(err))
+	}
+	if len(bindings) == 0 {
+		as.forgetContact(username)
 	}
 
-	// Accept registration, return 200 OK
 	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
-
-	// Add Contact header (optional)
-	if contact := req.Contact(); contact != nil {
+	now := time.Now()
+	for _, binding := range bindings {
+		contact := &sip.ContactHeader{Params: sip.NewParams()}
+		if err := parseURI(binding.URI, &contact.Address); err != nil {
+			host, portStr, _ := net.SplitHostPort(binding.Contact)
+			port, _ := strconv.Atoi(portStr)
+			contact.Address = sip.Uri{User: username, Host: host, Port: port}
+		}
+		if !binding.ExpiresAt.IsZero() {
+			contact.Params.Add("expires", strconv.Itoa(int(binding.ExpiresAt.Sub(now).Round(time.Second)/time.Second)))
+		}
+		contact.Params.Add("q", strconv.FormatFloat(binding.Q, 'f', -1, 64))
 		res.AppendHeader(contact)
 	}
-
-	// Add Expires header using the extracted expires value
-	expires := sip.ExpiresHeader(info.Expires)
-	res.AppendHeader(&expires)
+	if expires != nil {
+		res.AppendHeader(expires)
+	}
 
 	if err := tx.Respond(res); err != nil {
 		logger.Error("Failed to send REGISTER response", zap.Error(err))
 		return
 	}
-
-	logger.Info("REGISTER 200 OK response sent")
+	logger.Info("REGISTER 200 OK response sent", zap.String("username", username), zap.Int("bindings", len(bindings)))
 }
 
 func (as *SipServer) handleInvite(req *sip.Request, tx sip.ServerTransaction) {
-	logger.Info(fmt.Sprintf("RECEIVED INVITE REQUEST %v", req.StartLine()))
+	// 关闭期间拒绝新呼叫，对话内的re-INVITE照常处理
+	if to := req.To(); as.isDraining() && to != nil && !to.Params.Has("tag") {
+		logger.Info("Rejecting INVITE while shutting down", zap.String("call_id", req.CallID().Value()))
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusServiceUnavailable, "Service Unavailable", nil))
+		return
+	}
+	// 对话内的re-INVITE：保持、恢复或媒体更新，不再新建会话
+	if to := req.To(); to != nil && to.Params.Has("tag") {
+		tag, _ := to.Params.Get("tag")
+		if dialog, exists := as.getDialog(req.CallID().Value()); exists && tag == dialog.LocalTag {
+			as.handleReInvite(req, tx, dialog)
+			return
+		}
+	}
+	if !as.checkSessionInterval(req, tx) {
+		return
+	}
+	as.sendTrying(req, tx)
+
+	// 按来源地址匹配IP认证中继，未知来源已由authorizeSources中间件拒绝
+	trunk := as.inboundTrunk(req)
+	if trunk != nil {
+		logger.Info("INVITE matched IP-authenticated trunk",
+			zap.String("call_id", req.CallID().Value()),
+			zap.String("trunk", trunk.Trunk.Name),
+			zap.String("source", req.Source()))
+	}
 
 	// Parse SDP to get client RTP address
 	sdpBody := string(req.Body())
@@ -167,12 +197,55 @@ func (as *SipServer) handleInvite(req *sip.Request, tx sip.ServerTransaction) {
 
 	// Generate SDP response (use request source address to determine server IP)
 	serverIP := getServerIPFromRequest(req)
-	sdp := generateSDP(serverIP, as.config.LocalRTPPort)
+	// IP认证中继使用中继自己的编码列表，其余使用全局配置
+	priorities := as.config.Codecs
+	if trunk != nil && len(trunk.Trunk.Codecs) > 0 {
+		priorities = trunk.Trunk.Codecs
+	}
+	codec, err := negotiateCodec(sdpBody, priorities)
+	if err != nil {
+		logrus.WithError(err).Warn("Rejecting INVITE without acceptable codec")
+		res := sip.NewResponseFromRequest(req, sip.StatusNotAcceptableHere, "Not Acceptable Here", nil)
+		tx.Respond(res)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"codec":        codec.Name,
+		"payload_type": codec.PayloadType,
+		"wideband":     codec.IsWideband(),
+		"dtmf_pt":      codec.TelephoneEvent,
+	}).Info("Negotiated audio codec")
+	sdp := generateSDP(serverIP, as.config.LocalRTPPort, codec)
+	// 被叫是注册用户或配置了前转时先呼叫其联系地址或前转目标，接通后再应答主叫并桥接，否则由AI脚本接听
+	route, ok := as.routeInvite(req, tx, serverIP, codec)
+	if !ok {
+		return
+	}
+	bridged := route != nil && route.answer != nil
+	// 对端提供ICE时应答本机候选，媒体经连通性检查选出的路径由本机中继收发
+	mediaAddr := clientRTPAddr
+	if as.config.EnableICE && !bridged {
+		if offer := parseICEOffer(sdpBody); offer != nil {
+			if session, err := as.startICE(req.CallID().Value(), offer); err != nil {
+				logger.Warn("ICE setup failed, answering without candidates",
+					zap.String("call_id", req.CallID().Value()), zap.Error(err))
+			} else {
+				sdp = session.answerSDP(codec)
+				mediaAddr = session.relay.LocalAddr().String()
+			}
+		}
+	}
 	sdpBytes := []byte(sdp)
 
 	// Log SDP content for debugging
 	logrus.WithField("sdp", sdp).Debug("Generated SDP")
 
+	// 180/183及振铃时长可配置，振铃期间被取消则不再接听
+	if !bridged && !as.sendProvisionalResponse(req, tx, sdpBytes) {
+		as.stopICE(req.CallID().Value())
+		return
+	}
+
 	// Create 200 OK response
 	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", sdpBytes)
 	cl := sip.ContentLengthHeader(len(sdpBytes))
@@ -185,7 +258,7 @@ func (as *SipServer) handleInvite(req *sip.Request, tx sip.ServerTransaction) {
 	// Add Contact header (some clients need this to send ACK correctly)
 	// Create a Contact header using server IP and port
 	contactURI := sip.Uri{
-		Host: serverIP,
+		Host: uriHost(serverIP),
 		Port: as.config.Port,
 	}
 	contact := &sip.ContactHeader{
@@ -193,10 +266,15 @@ func (as *SipServer) handleInvite(req *sip.Request, tx sip.ServerTransaction) {
 	}
 	res.AppendHeader(contact)
 	logrus.WithField("contact", contact.String()).Debug("Contact header")
+	sessionExpires, refreshSession := as.answerSessionTimer(req, res)
 
 	// Send 200 OK response
 	if err := tx.Respond(res); err != nil {
 		logrus.WithError(err).Error("Failed to send response")
+		as.stopICE(req.CallID().Value())
+		if bridged {
+			as.abortUserRoute(route)
+		}
 		return
 	}
 
@@ -205,13 +283,20 @@ func (as *SipServer) handleInvite(req *sip.Request, tx sip.ServerTransaction) {
 
 	// Save session information, wait for ACK before sending audio
 	callID := req.CallID().Value()
-	if err := as.config.SavePendingSession(callID, clientRTPAddr); err != nil {
-		logrus.WithError(err).WithField("call_id", callID).Error("Failed to save pending session")
+
+	// Keep dialog state so the server can hang up on its own later
+	if dialog, err := newCallDialog(req, res); err != nil {
+		logrus.WithError(err).WithField("call_id", callID).Warn("Failed to build dialog from INVITE")
 	} else {
-		logrus.WithFields(logrus.Fields{
-			"call_id":     callID,
-			"rtp_address": clientRTPAddr,
-		}).Info("Session information saved")
+		dialog.allowUpdate = allowsMethod(req, sip.UPDATE)
+		as.saveDialog(dialog)
+		as.startSessionTimer(dialog, sessionExpires, refreshSession)
+	}
+	as.saveCallCodec(callID, codec)
+	if bridged {
+		as.startUserRoute(callID, route, mediaAddr)
+	} else if route != nil {
+		as.setScriptNumber(callID, route.scriptNumber)
 	}
 
 	// Extract call information from request
@@ -227,7 +312,7 @@ func (as *SipServer) handleInvite(req *sip.Request, tx sip.ServerTransaction) {
 		fromURI = from.Address.String()
 		// 从请求中获取源IP
 		if via := req.Via(); via != nil {
-			fromIP = via.Host
+			fromIP = strings.Trim(via.Host, "[]")
 		}
 	}
 
@@ -236,7 +321,7 @@ func (as *SipServer) handleInvite(req *sip.Request, tx sip.ServerTransaction) {
 		toURI = to.Address.String()
 	}
 
-	localRTPAddr := fmt.Sprintf("%s:%d", serverIP, as.config.LocalRTPPort)
+	localRTPAddr := net.JoinHostPort(serverIP, strconv.Itoa(as.config.LocalRTPPort))
 
 	sipCall := &models.SipCall{
 		CallID:        callID,
@@ -252,57 +337,33 @@ func (as *SipServer) handleInvite(req *sip.Request, tx sip.ServerTransaction) {
 		StartTime:     now,
 	}
 
-	// Process based on storage type configuration
-	var saveErr error
-	switch as.config.StorageType {
-	case ua.StorageTypeDatabase:
-		logrus.WithField("call_id", callID).Info("Processing INVITE with database storage")
-
-		if as.config.Db == nil {
-			logrus.Warn("Database not configured for database storage type, falling back to memory storage")
-			saveErr = as.config.SaveCall(sipCall)
-			break
-		}
-
-		// Save to database
-		saveErr = as.config.SaveInviteToDatabase(sipCall)
-		if saveErr != nil {
-			logrus.WithError(saveErr).WithField("call_id", callID).Error("Failed to save INVITE to database")
-		} else {
-			logrus.WithField("call_id", callID).Info("Inbound call record created in database")
-		}
-
-	case ua.StorageTypeFile:
-		logrus.WithField("call_id", callID).Info("Processing INVITE with file storage")
-
-		// Save to file
-		saveErr = as.config.SaveInviteToFile(sipCall)
-		if saveErr != nil {
-			logrus.WithError(saveErr).WithField("call_id", callID).Error("Failed to save INVITE to file")
-		} else {
-			logrus.WithField("call_id", callID).Info("Inbound call record created in file storage")
-		}
+	// 会话和通话记录的存储交给后台协程，尽快释放INVITE处理协程
+	as.dispatchInvite(&inviteJob{
+		callID:        callID,
+		clientRTPAddr: mediaAddr,
+		sipCall:       sipCall,
+	})
+}
 
-	case ua.StorageTypeMemory:
-		logrus.WithField("call_id", callID).Info("Processing INVITE with memory storage")
+// persistInvite 保存待接通会话（等待ACK）和呼入通话记录
+func (as *SipServer) persistInvite(job *inviteJob) {
+	callID, sipCall := job.callID, job.sipCall
 
-		// Save to memory only
-		saveErr = as.config.SaveCall(sipCall)
-		if saveErr != nil {
-			logrus.WithError(saveErr).WithField("call_id", callID).Error("Failed to save INVITE to memory")
-		} else {
-			logrus.WithField("call_id", callID).Info("Inbound call record created in memory storage")
-		}
+	if err := as.config.SavePendingSession(callID, job.clientRTPAddr); err != nil {
+		logrus.WithError(err).WithField("call_id", callID).Error("Failed to save pending session")
+	} else {
+		logrus.WithFields(logrus.Fields{
+			"call_id":     callID,
+			"rtp_address": job.clientRTPAddr,
+		}).Info("Session information saved")
+	}
 
-	default:
-		logrus.Warnf("Unknown storage type: %s, defaulting to memory storage", as.config.StorageType)
-		saveErr = as.config.SaveCall(sipCall)
-		if saveErr != nil {
-			logrus.WithError(saveErr).WithField("call_id", callID).Error("Failed to save INVITE to memory")
-		} else {
-			logrus.WithField("call_id", callID).Info("Inbound call record created in memory storage (default)")
-		}
+	if err := as.config.SaveCall(sipCall); err != nil {
+		logrus.WithError(err).WithField("call_id", callID).Error("Failed to save INVITE")
+	} else {
+		logrus.WithField("call_id", callID).Info("Inbound call record created")
 	}
+	as.presenceCallStarted(sipCall)
 }
 
 func (as *SipServer) handleOptions(req *sip.Request, tx sip.ServerTransaction) {
@@ -312,8 +373,9 @@ func (as *SipServer) handleOptions(req *sip.Request, tx sip.ServerTransaction) {
 	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
 
 	// Add Allow header, list supported methods
-	allow := sip.NewHeader("Allow", "INVITE, ACK, CANCEL, BYE, OPTIONS, REGISTER")
+	allow := sip.NewHeader("Allow", "INVITE, ACK, CANCEL, BYE, OPTIONS, REGISTER, REFER, UPDATE, MESSAGE, SUBSCRIBE")
 	res.AppendHeader(allow)
+	res.AppendHeader(sip.NewHeader("Allow-Events", presenceEvents))
 
 	if err := tx.Respond(res); err != nil {
 		logrus.WithError(err).Error("Failed to send OPTIONS response")
@@ -331,8 +393,13 @@ func (as *SipServer) handleAck(req *sip.Request, tx sip.ServerTransaction) {
 
 	// ACK request doesn't need a response, but receiving ACK means session is established
 	// Find corresponding session information using config methods
+	as.waitInvitePersisted(callID)
 	clientRTPAddr, exists := as.config.GetPendingSession(callID)
 	if !exists {
+		if _, inDialog := as.getDialog(callID); inDialog {
+			logger.Debug("Received ACK for re-INVITE", zap.String("call_id", callID))
+			return
+		}
 		logger.Warn("Received ACK but could not find corresponding session", zap.String("call_id", callID))
 		return
 	}
@@ -367,21 +434,36 @@ func (as *SipServer) handleAck(req *sip.Request, tx sip.ServerTransaction) {
 		DTMFChannel:   make(chan string, 10), // DTMF channel
 		RecordingFile: recordingFile,
 	})
+	as.startRTCP(callID, clientAddr, as.getCallCodec(callID))
 
-	// 更新数据库状态为已接通（呼入通话）
-	if as.config.Db != nil {
-		now := time.Now()
-		as.updateCallStatusInDB(callID, models.SipCallStatusAnswered, &now)
-	} else {
-		// 如果使用文件或内存存储，也需要更新状态
-		as.updateCallStatus(callID, models.SipCallStatusAnswered, nil)
+	// 更新状态为已接通（呼入通话）
+	now := time.Now()
+	as.updateCallStatus(callID, models.SipCallStatusAnswered, &now)
+
+	// 已桥接到注册用户的通话不启动AI脚本
+	if as.getBridge(callID) != nil {
+		as.queue.remove(callID)
+		logger.Info("Routed call established", zap.String("call_id", callID))
+		return
 	}
 
-	// 获取被叫号码
-	var phoneNumber string
+	// 获取被叫和主叫号码
+	var phoneNumber, callerNumber string
 	if to := req.To(); to != nil {
 		phoneNumber = to.Address.User
 	}
+	if from := req.From(); from != nil {
+		callerNumber = from.Address.User
+	}
+	// 前转到AI脚本的通话按前转规则的号码查找脚本
+	if number := as.takeScriptNumber(callID); number != "" {
+		phoneNumber = number
+	}
+
+	// 超出并发限制排队的呼叫先播放等待音乐，排到后再启动脚本
+	if as.aiEngine != nil && as.parkQueuedCall(callID, clientRTPAddr, phoneNumber, callerNumber) {
+		return
+	}
 
 	// 启动AI电话脚本（必须有AI引擎和脚本）
 	if as.aiEngine != nil && phoneNumber != "" {
@@ -389,7 +471,7 @@ func (as *SipServer) handleAck(req *sip.Request, tx sip.ServerTransaction) {
 			zap.String("call_id", callID),
 			zap.String("phone_number", phoneNumber))
 
-		if err := as.aiEngine.StartScript(callID, clientRTPAddr, phoneNumber); err != nil {
+		if err := as.aiEngine.StartScript(callID, clientRTPAddr, phoneNumber, callerNumber); err != nil {
 			logger.Error("Failed to start AI phone script",
 				zap.String("call_id", callID),
 				zap.Error(err))
@@ -413,45 +495,6 @@ func (as *SipServer) handleAck(req *sip.Request, tx sip.ServerTransaction) {
 	}
 }
 
-// saveWAV 将 PCM 数据保存为 WAV 文件
-func saveWAV(filename string, pcmData []int16, sampleRate int) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// WAV 文件头
-	dataSize := uint32(len(pcmData) * 2) // 每个样本 2 字节
-	fileSize := 36 + dataSize
-
-	// RIFF 头
-	file.WriteString("RIFF")
-	binary.Write(file, binary.LittleEndian, uint32(fileSize))
-	file.WriteString("WAVE")
-
-	// fmt chunk
-	file.WriteString("fmt ")
-	binary.Write(file, binary.LittleEndian, uint32(16)) // fmt chunk size
-	binary.Write(file, binary.LittleEndian, uint16(1))  // audio format (PCM)
-	binary.Write(file, binary.LittleEndian, uint16(1))  // num channels
-	binary.Write(file, binary.LittleEndian, uint32(sampleRate))
-	binary.Write(file, binary.LittleEndian, uint32(sampleRate*2)) // byte rate
-	binary.Write(file, binary.LittleEndian, uint16(2))            // block align
-	binary.Write(file, binary.LittleEndian, uint16(16))           // bits per sample
-
-	// data chunk
-	file.WriteString("data")
-	binary.Write(file, binary.LittleEndian, dataSize)
-
-	// 写入 PCM 数据
-	for _, sample := range pcmData {
-		binary.Write(file, binary.LittleEndian, sample)
-	}
-
-	return nil
-}
-
 // recordAudioContinuous 持续录音（不限制时长，直到收到停止信号）
 func (as *SipServer) recordAudioContinuous(clientAddr string, callID string, filename string) {
 	addr, err := net.ResolveUDPAddr("udp", clientAddr)
@@ -473,42 +516,46 @@ func (as *SipServer) recordAudioContinuous(clientAddr string, callID string, fil
 		return
 	}
 
-	// 创建缓冲区存储 PCM 数据
-	var pcmData []int16
+	// 边收边写，每秒落盘一次
+	sampleRate := 8000
+	recorder, err := audio.Create(filename, audio.Mono16(sampleRate), time.Second)
+	if err != nil {
+		logrus.WithError(err).WithField("call_id", callID).Error("Failed to create recording file")
+		return
+	}
 	buffer := make([]byte, 1500)
 	packetCount := 0
-	sampleRate := 8000
+	samples := make([]int16, 0, 160)
+	var latch atomic.Bool
 
 	// 设置读取超时（用于定期检查停止信号）
-	as.rtpConn.SetReadDeadline(time.Now().Add(1 * time.Second))
+	as.media.SetReadDeadline(time.Now().Add(1 * time.Second))
 
 	for {
 		// 检查是否停止
 		select {
 		case <-session.StopRecording:
 			logrus.WithField("call_id", callID).Info("Recording stopped")
-			as.rtpConn.SetReadDeadline(time.Time{}) // Clear timeout
+			as.media.SetReadDeadline(time.Time{}) // Clear timeout
 			// 保存录音
-			if len(pcmData) > 0 {
-				if err := saveWAV(filename, pcmData, sampleRate); err != nil {
-					logrus.WithError(err).WithField("call_id", callID).Error("Failed to save WAV file")
-				} else {
-					logrus.WithFields(logrus.Fields{
-						"call_id":      callID,
-						"filename":     filename,
-						"samples":      len(pcmData),
-						"packet_count": packetCount,
-					}).Info("Recording saved")
-				}
+			if err := recorder.Close(); err != nil {
+				logrus.WithError(err).WithField("call_id", callID).Error("Failed to save WAV file")
+			} else {
+				logrus.WithFields(logrus.Fields{
+					"call_id":      callID,
+					"filename":     filename,
+					"samples":      recorder.Samples(),
+					"packet_count": packetCount,
+				}).Info("Recording saved")
 			}
 			return
 		default:
 		}
 
 		// 动态更新超时（用于定期检查停止信号）
-		as.rtpConn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		as.media.SetReadDeadline(time.Now().Add(1 * time.Second))
 
-		n, receivedAddr, err := as.rtpConn.ReadFromUDP(buffer)
+		n, receivedAddr, err := as.media.ReadFromUDP(buffer)
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				// 超时是正常的，继续循环检查停止信号
@@ -518,8 +565,8 @@ func (as *SipServer) recordAudioContinuous(clientAddr string, callID string, fil
 			continue
 		}
 
-		// 检查是否来自目标客户端
-		if !receivedAddr.IP.Equal(addr.IP) {
+		// 检查是否来自目标客户端，首个包锁定NAT后的实际地址
+		if !as.acceptRTP(callID, &latch, addr, receivedAddr) {
 			continue
 		}
 
@@ -538,9 +585,12 @@ func (as *SipServer) recordAudioContinuous(clientAddr string, callID string, fil
 		packetCount++
 
 		// 解码 μ-law 为 PCM
+		samples = samples[:0]
 		for _, mulawByte := range packet.Payload {
-			pcm := mulawToLinear(mulawByte)
-			pcmData = append(pcmData, pcm)
+			samples = append(samples, mulawToLinear(mulawByte))
+		}
+		if err := recorder.Write(samples); err != nil {
+			logrus.WithError(err).WithField("call_id", callID).Error("Failed to write recording")
 		}
 	}
 }
@@ -562,48 +612,22 @@ func (as *SipServer) sendAudioWithCallback(clientAddr string, callID string) {
 	_ = session // 避免未使用变量警告
 }
 
-// updateCallStatusInDB updates call status in database
-func (as *SipServer) updateCallStatusInDB(callID string, status models.SipCallStatus, answerTime *time.Time) {
-	if as.config.Db == nil {
-		return
-	}
-
-	var sipCall models.SipCall
-	if err := as.config.Db.Where("call_id = ?", callID).First(&sipCall).Error; err != nil {
-		logrus.WithError(err).WithField("call_id", callID).Error("Failed to find call record for status update")
-		return
-	}
-
-	sipCall.Status = status
-	if answerTime != nil {
-		sipCall.AnswerTime = answerTime
+// updateCallStatus updates call status based on storage type
+func (as *SipServer) updateCallStatus(callID string, status models.SipCallStatus, answerTime *time.Time) {
+	as.monitor.Publish(MonitorEvent{Type: MonitorCallStatus, CallID: callID, Status: string(status)})
+	switch status {
+	case models.SipCallStatusEnded, models.SipCallStatusFailed, models.SipCallStatusCancelled:
+		as.tracer.callEnded(callID)
 	}
+	as.presenceCallStatus(callID, status)
 
-	if err := as.config.Db.Save(&sipCall).Error; err != nil {
-		logrus.WithError(err).WithField("call_id", callID).Error("Failed to update call status in database")
+	if err := as.config.UpdateCallStatus(callID, status, answerTime); err != nil {
+		logrus.WithError(err).WithField("call_id", callID).Error("Failed to update call status")
 	} else {
 		logrus.WithFields(logrus.Fields{
 			"call_id": callID,
 			"status":  status,
-		}).Info("Call status updated in database")
-	}
-}
-
-// updateCallStatus updates call status based on storage type
-func (as *SipServer) updateCallStatus(callID string, status models.SipCallStatus, answerTime *time.Time) {
-	switch as.config.StorageType {
-	case ua.StorageTypeDatabase:
-		as.updateCallStatusInDB(callID, status, answerTime)
-
-	case ua.StorageTypeFile:
-		// Update call file
-		as.config.UpdateCallStatusInFile(callID, status, answerTime)
-
-	case ua.StorageTypeMemory:
-		as.config.UpdateCallStatusInMemory(callID, status, answerTime)
-	default:
-		// Default to memory
-		as.config.UpdateCallStatusInMemory(callID, status, answerTime)
+		}).Info("Call status updated")
 	}
 }
 
@@ -619,6 +643,42 @@ func (as *SipServer) handleInfo(req *sip.Request, tx sip.ServerTransaction) {
 	body := string(req.Body())
 	logrus.WithField("body", body).Debug("INFO request body")
 
+	dtmfDigit := parseInfoDTMF(body)
+
+	if dtmfDigit != "" {
+		logrus.WithFields(logrus.Fields{
+			"dtmf":    dtmfDigit,
+			"call_id": callID,
+		}).Info("Detected DTMF key")
+
+		// Send DTMF to session channel
+		session, exists := as.config.GetActiveSession(callID)
+		if exists {
+			select {
+			case session.DTMFChannel <- dtmfDigit:
+				logrus.WithField("dtmf", dtmfDigit).Debug("DTMF key sent to session channel")
+			default:
+				logrus.WithField("dtmf", dtmfDigit).Warn("DTMF channel full, dropping key")
+			}
+		}
+		if as.aiEngine != nil {
+			as.aiEngine.interruptAssistant(callID)
+		}
+	}
+
+	// Return 200 OK
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	if err := tx.Respond(res); err != nil {
+		logrus.WithError(err).Error("Failed to send INFO response")
+		return
+	}
+
+	logrus.Info("INFO 200 OK response sent")
+}
+
+// parseInfoDTMF extracts the DTMF digit from an INFO body
+// (application/dtmf-relay "Signal=1", "key=1" or a bare digit)
+func parseInfoDTMF(body string) string {
 	// Find DTMF signal (usually in Signal or Key parameter)
 	dtmfDigit := ""
 	if strings.Contains(body, "Signal=") {
@@ -647,33 +707,7 @@ func (as *SipServer) handleInfo(req *sip.Request, tx sip.ServerTransaction) {
 			}
 		}
 	}
-
-	if dtmfDigit != "" {
-		logrus.WithFields(logrus.Fields{
-			"dtmf":    dtmfDigit,
-			"call_id": callID,
-		}).Info("Detected DTMF key")
-
-		// Send DTMF to session channel
-		session, exists := as.config.GetActiveSession(callID)
-		if exists {
-			select {
-			case session.DTMFChannel <- dtmfDigit:
-				logrus.WithField("dtmf", dtmfDigit).Debug("DTMF key sent to session channel")
-			default:
-				logrus.WithField("dtmf", dtmfDigit).Warn("DTMF channel full, dropping key")
-			}
-		}
-	}
-
-	// Return 200 OK
-	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
-	if err := tx.Respond(res); err != nil {
-		logrus.WithError(err).Error("Failed to send INFO response")
-		return
-	}
-
-	logrus.Info("INFO 200 OK response sent")
+	return dtmfDigit
 }
 
 func (as *SipServer) handleCancel(req *sip.Request, tx sip.ServerTransaction) {
@@ -684,6 +718,7 @@ func (as *SipServer) handleCancel(req *sip.Request, tx sip.ServerTransaction) {
 	}).Info("Received CANCEL request")
 
 	// Clean up pending session (CANCEL is sent before ACK)
+	as.waitInvitePersisted(callID)
 	clientRTPAddr, exists := as.config.GetPendingSession(callID)
 	if exists {
 		logrus.WithFields(logrus.Fields{
@@ -713,6 +748,11 @@ func (as *SipServer) handleCancel(req *sip.Request, tx sip.ServerTransaction) {
 		as.config.RemoveActiveSession(callID)
 		logrus.WithField("call_id", callID).Info("Active session terminated due to CANCEL")
 	}
+	as.removeDialog(callID)
+	as.removeCallCodec(callID)
+	as.takeScriptNumber(callID)
+	as.quotas.release(callID)
+	as.leaveQueue(callID)
 
 	// Return 200 OK for CANCEL
 	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
@@ -737,7 +777,8 @@ func (as *SipServer) handleBye(req *sip.Request, tx sip.ServerTransaction) {
 		as.aiEngine.StopSession(callID)
 	}
 
-	// 更新通话状态为已结束
+	// 更新通话状态为已结束，先等INVITE的通话记录写入
+	as.waitInvitePersisted(callID)
 	as.updateCallStatus(callID, models.SipCallStatusEnded, &now)
 
 	// Clean up pending session
@@ -774,6 +815,15 @@ func (as *SipServer) handleBye(req *sip.Request, tx sip.ServerTransaction) {
 		logger.Info("Active session terminated and cleaned up", zap.String("call_id", callID))
 	}
 
+	// Remote ended the dialog, nothing left to hang up
+	as.removeDialog(callID)
+	as.removeCallCodec(callID)
+	as.takeScriptNumber(callID)
+	as.quotas.release(callID)
+	as.leaveQueue(callID)
+	as.stopRTCP(callID)
+	as.stopBridge(callID)
+
 	// 等待一小段时间确保录音已保存
 	if recordingFile != "" {
 		time.Sleep(500 * time.Millisecond)
@@ -792,7 +842,6 @@ func (as *SipServer) handleBye(req *sip.Request, tx sip.ServerTransaction) {
 }
 
 func (as *SipServer) handlePublish(req *sip.Request, tx sip.ServerTransaction) {
-	logger.Info(fmt.Sprintf("RECEIVED PUBLISH REQUEST from %v", req.StartLine()))
 	// Return 200 OK (accept PUBLISH request)
 	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
 	if err := tx.Respond(res); err != nil {
@@ -820,13 +869,8 @@ func (as *SipServer) handleNoRoute(req *sip.Request, tx sip.ServerTransaction) {
 	tx.Respond(res)
 }
 
-// saveRecordingURL 保存录音URL到数据库
+// saveRecordingURL 保存录音URL到通话记录
 func (as *SipServer) saveRecordingURL(callID string, recordingFile string) {
-	if as.config.Db == nil {
-		logrus.WithField("call_id", callID).Warn("Database not configured, skipping recording URL save")
-		return
-	}
-
 	// 检查文件是否存在
 	if _, err := os.Stat(recordingFile); os.IsNotExist(err) {
 		logrus.WithField("call_id", callID).WithField("file", recordingFile).Warn("Recording file does not exist")
@@ -836,28 +880,34 @@ func (as *SipServer) saveRecordingURL(callID string, recordingFile string) {
 	// 生成录音URL（相对路径，前端可以通过API访问）
 	recordURL := fmt.Sprintf("/api/uploads/audio/%s", strings.TrimPrefix(recordingFile, "uploads/audio/"))
 
-	// 更新数据库记录
-	var sipCall models.SipCall
-	if err := as.config.Db.Where("call_id = ?", callID).First(&sipCall).Error; err != nil {
-		logrus.WithError(err).WithField("call_id", callID).Error("Failed to find call record for recording URL")
-		return
-	}
-
-	sipCall.RecordURL = recordURL
-	if err := as.config.Db.Save(&sipCall).Error; err != nil {
+	// 更新通话记录
+	if err := as.config.SaveRecordURL(callID, recordURL); err != nil {
 		logrus.WithError(err).WithField("call_id", callID).Error("Failed to save recording URL")
 	} else {
 		logrus.WithFields(logrus.Fields{
 			"call_id":    callID,
 			"record_url": recordURL,
-		}).Info("Recording URL saved to database")
+		}).Info("Recording URL saved")
 	}
 }
 
 // hangupCall 主动挂断电话
 func (as *SipServer) hangupCall(callID string) {
 	logger.Info("Hanging up call", zap.String("call_id", callID))
+	as.teardownCall(callID)
 
+	// 更新通话状态
+	now := time.Now()
+	as.updateCallStatus(callID, models.SipCallStatusEnded, &now)
+
+	// 发送BYE请求，让远端真正挂断
+	if err := as.sendBye(callID); err != nil {
+		logger.Warn("Failed to send BYE", zap.String("call_id", callID), zap.Error(err))
+	}
+}
+
+// teardownCall 清理通话的待接通和活跃会话、编码、RTCP、ICE和并发名额，通话已桥接时挂断另一条腿
+func (as *SipServer) teardownCall(callID string) {
 	// 清理会话信息
 	as.config.RemovePendingSession(callID)
 
@@ -875,11 +925,10 @@ func (as *SipServer) hangupCall(callID string) {
 		// 移除活跃会话
 		as.config.RemoveActiveSession(callID)
 	}
-
-	// 更新通话状态
-	now := time.Now()
-	as.updateCallStatus(callID, models.SipCallStatusEnded, &now)
-
-	// TODO: 这里可以发送BYE请求来主动挂断
-	// 目前只是清理本地状态，实际的SIP BYE请求需要更复杂的实现
+	as.removeCallCodec(callID)
+	as.quotas.release(callID)
+	as.leaveQueue(callID)
+	as.stopRTCP(callID)
+	as.stopICE(callID)
+	as.stopBridge(callID)
 }
@@ -0,0 +1,114 @@
+package sip1
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+const fuzzSDPOffer = "v=0\r\n" +
+	"o=- 1234 1 IN IP4 192.168.1.10\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 192.168.1.10\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 40000 RTP/AVP 0 8 9 101\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n" +
+	"a=rtpmap:8 PCMA/8000\r\n" +
+	"a=rtpmap:9 G722/8000\r\n" +
+	"a=rtpmap:101 telephone-event/8000\r\n" +
+	"a=fmtp:101 0-16\r\n"
+
+func FuzzParseSDPForRTPAddress(f *testing.F) {
+	f.Add(fuzzSDPOffer)
+	f.Add(strings.ReplaceAll(fuzzSDPOffer, "\r\n", "\n"))
+	f.Add("m=audio\r\nc=IN IP4\r\nc=\r\n")
+	f.Add("c=IN IP4 10.0.0.1\nm=audio 0\nc=IN IP4 10.0.0.2\n")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, body string) {
+		addr, err := ParseSDPForRTPAddress(body)
+		if err == nil && !strings.Contains(addr, ":") {
+			t.Fatalf("address %q has no port", addr)
+		}
+	})
+}
+
+func FuzzNegotiateCodec(f *testing.F) {
+	f.Add(fuzzSDPOffer)
+	f.Add("v=0\r\nm=audio 1 RTP/AVP 96\r\na=rtpmap:96 opus/48000/2\r\na=rtpmap:96\r\n")
+	f.Add("v=0\r\nm=video 1 RTP/AVP 96\r\n")
+	f.Add("v=0\r\n")
+
+	f.Fuzz(func(t *testing.T, body string) {
+		codec, err := negotiateCodec(body, nil)
+		if err == nil && codec.Name == "" {
+			t.Fatal("negotiated codec without name")
+		}
+	})
+}
+
+func FuzzParseInfoDTMF(f *testing.F) {
+	f.Add("Signal=5\r\nDuration=160\r\n")
+	f.Add("Signal=\"#\"\r\n")
+	f.Add("key=9")
+	f.Add("Signal=")
+	f.Add("x1")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, body string) {
+		digit := parseInfoDTMF(body)
+		if body == "" && digit != "" {
+			t.Fatalf("empty body parsed as %q", digit)
+		}
+	})
+}
+
+func FuzzRTPPacket(f *testing.F) {
+	seed := func(pt uint8, payload []byte) []byte {
+		data, err := (&rtp.Packet{
+			Header:  rtp.Header{Version: 2, PayloadType: pt, SequenceNumber: 1, Timestamp: 160, SSRC: 1},
+			Payload: payload,
+		}).Marshal()
+		if err != nil {
+			f.Fatal(err)
+		}
+		return data
+	}
+	f.Add(seed(0, make([]byte, 160)))
+	f.Add(seed(8, make([]byte, 160)))
+	f.Add(seed(9, make([]byte, 160)))
+	f.Add(seed(101, []byte{5, 0x8a, 0x03, 0x20}))
+	f.Add(seed(101, []byte{5}))
+	f.Add([]byte{0x90, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0xbe, 0xde, 0xff, 0xff})
+	f.Add([]byte{0x81, 201, 0, 7})
+	f.Add([]byte{})
+
+	decoders := make(map[uint8]rtpDecoder)
+	for _, codec := range []rtpCodec{codecPCMU, codecPCMA, codecG722} {
+		decode, err := newRTPDecoder(codec, codec.SampleRate)
+		if err != nil {
+			f.Fatal(err)
+		}
+		decoders[codec.PayloadType] = decode
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// RTCP端口收到的同样是不可信数据
+		_, _ = parseReportBlocks(data)
+
+		packet := &rtp.Packet{}
+		if err := packet.Unmarshal(data); err != nil {
+			return
+		}
+
+		newTelephoneEventDetector(defaultTelephoneEventPT).Push(packet)
+
+		jitter := newJitterBuffer(3)
+		for _, released := range append(jitter.Push(packet), jitter.Flush()...) {
+			if decode, ok := decoders[released.PayloadType]; ok {
+				_, _ = decode(released.Payload)
+			}
+		}
+	})
+}
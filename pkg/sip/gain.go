@@ -0,0 +1,64 @@
+package sip1
+
+import (
+	"github.com/LingByte/LingSIP/pkg/audio"
+	"github.com/LingByte/LingSIP/pkg/config"
+	"github.com/LingByte/LingSIP/pkg/media"
+)
+
+// agcConfig 读取AGC配置，ttsEnabled/inboundEnabled分别表示两个方向是否开启
+func agcConfig() (cfg media.AGCConfig, ttsEnabled, inboundEnabled bool) {
+	if config.GlobalConfig == nil {
+		return media.AGCConfig{}, false, false
+	}
+	agc := config.GlobalConfig.Services.AGC
+	cfg = media.AGCConfig{
+		TargetLevel: agc.TargetLevel,
+		MaxGain:     agc.MaxGain,
+		Ceiling:     agc.Ceiling,
+	}
+	return cfg, agc.TTS, agc.Inbound
+}
+
+// newTTSAGC 为一段TTS输出创建AGC，未开启时返回nil
+func newTTSAGC() *media.AGC {
+	cfg, enabled, _ := agcConfig()
+	if !enabled {
+		return nil
+	}
+	return media.NewAGC(cfg)
+}
+
+// normalizeLoudness 把整段提示音或TTS按配置的目标响度归一，未配置目标响度时原样返回，返回实际增益
+func normalizeLoudness(samples []int16, sampleRate int) float64 {
+	if config.GlobalConfig == nil {
+		return 1
+	}
+	agc := config.GlobalConfig.Services.AGC
+	if agc.TargetLUFS == 0 {
+		return 1
+	}
+	return audio.Normalize(samples, sampleRate, agc.TargetLUFS, agc.Ceiling)
+}
+
+// newTTSNormalizer 流式TTS的响度归一，与callTTSService的整段归一使用相同的目标响度和限幅，
+// TTS AGC未开启或未配置目标响度时返回nil
+func newTTSNormalizer(sampleRate int) *audio.StreamNormalizer {
+	if _, enabled, _ := agcConfig(); !enabled {
+		return nil
+	}
+	agc := config.GlobalConfig.Services.AGC
+	if agc.TargetLUFS == 0 {
+		return nil
+	}
+	return audio.NewStreamNormalizer(sampleRate, agc.TargetLUFS, agc.Ceiling)
+}
+
+// newInboundAGC 为会话的入向音频创建AGC，未开启时返回nil
+func newInboundAGC() *media.AGC {
+	cfg, _, enabled := agcConfig()
+	if !enabled {
+		return nil
+	}
+	return media.NewAGC(cfg)
+}
@@ -0,0 +1,135 @@
+package sip1
+
+import (
+	"strings"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// greetingStepID 开场问候写入对话记录时使用的步骤ID
+	greetingStepID = "__greeting"
+	// repeatCallerContextKey 是否回头客写入会话上下文，条件步骤可据此分支
+	repeatCallerContextKey = "repeat_caller"
+)
+
+// playGreeting 脚本第一步之前播放开场问候，失败不影响脚本执行
+func (engine *AIPhoneEngine) playGreeting(session *ScriptSession) {
+	greeting := session.Script.Greeting
+	if !greeting.Enabled() {
+		return
+	}
+
+	repeat := engine.isRepeatCaller(session, greeting.RepeatWithinDays)
+	if err := session.Context.Set(repeatCallerContextKey, repeat); err != nil {
+		logger.Warn("Failed to set repeat caller context", zap.String("call_id", session.CallID), zap.Error(err))
+	}
+
+	text := selectGreeting(greeting, engine.getClock().Now(), repeat)
+	if text == "" {
+		return
+	}
+	speakerID := greeting.SpeakerID
+	if speakerID == "" {
+		speakerID = session.Script.SpeakerID
+	}
+	if err := engine.playTTSAudio(session, text, speakerID); err != nil {
+		logger.Warn("Failed to play greeting", zap.String("call_id", session.CallID), zap.Error(err))
+		return
+	}
+	session.addMessage("assistant", text, greetingStepID)
+	logger.Info("Greeting played",
+		zap.String("call_id", session.CallID),
+		zap.String("text", text),
+		zap.Bool("repeat_caller", repeat))
+}
+
+// isRepeatCaller 主叫号码在withinDays天内（0不限）是否呼叫过同一号码，主叫未知时不算
+func (engine *AIPhoneEngine) isRepeatCaller(session *ScriptSession, withinDays int) bool {
+	if session.DBSession == nil || session.DBSession.CallerNumber == "" {
+		return false
+	}
+	var since time.Time
+	if withinDays > 0 {
+		since = engine.getClock().Now().AddDate(0, 0, -withinDays)
+	}
+	repeat, err := models.HasCalledBefore(engine.db, session.DBSession.CallerNumber, session.DBSession.CalleeNumber, since, session.DBSession.ID)
+	if err != nil {
+		logger.Warn("Failed to look up caller history", zap.String("call_id", session.CallID), zap.Error(err))
+		return false
+	}
+	return repeat
+}
+
+// selectGreeting 按规则选出问候语：节假日优先，其次时段，最后Default；回头客追加RepeatCaller
+func selectGreeting(greeting models.GreetingConfig, now time.Time, repeat bool) string {
+	if greeting.Timezone != "" {
+		if location, err := time.LoadLocation(greeting.Timezone); err == nil {
+			now = now.In(location)
+		} else {
+			logger.Warn("Invalid greeting timezone", zap.String("timezone", greeting.Timezone), zap.Error(err))
+		}
+	}
+
+	text := greeting.Default
+	if holiday := matchHoliday(greeting.Holidays, now); holiday != nil {
+		text = holiday.Text
+	} else if slot := matchTimeSlot(greeting.TimeSlots, now); slot != nil {
+		text = slot.Text
+	}
+	if !repeat || greeting.RepeatCaller == "" {
+		return text
+	}
+	if text = strings.TrimRight(text, "，。！,.! "); text == "" {
+		return greeting.RepeatCaller
+	}
+	return text + "，" + greeting.RepeatCaller
+}
+
+// matchHoliday 返回当天所在的第一个节假日，MM-DD格式的每年节假日可以跨年，如12-31到01-02
+func matchHoliday(holidays []models.GreetingHoliday, now time.Time) *models.GreetingHoliday {
+	for i := range holidays {
+		holiday := &holidays[i]
+		end := holiday.EndDate
+		if end == "" {
+			end = holiday.Date
+		}
+		switch len(holiday.Date) {
+		case len("2006-01-02"):
+			today := now.Format("2006-01-02")
+			if holiday.Date <= today && today <= end {
+				return holiday
+			}
+		case len("01-02"):
+			today := now.Format("01-02")
+			if holiday.Date <= end && holiday.Date <= today && today <= end ||
+				holiday.Date > end && (today >= holiday.Date || today <= end) {
+				return holiday
+			}
+		}
+	}
+	return nil
+}
+
+// matchTimeSlot 返回当前时间所在的第一个时段，格式错误的时段跳过
+func matchTimeSlot(slots []models.GreetingTimeSlot, now time.Time) *models.GreetingTimeSlot {
+	minute := now.Hour()*60 + now.Minute()
+	for i := range slots {
+		start, err := time.Parse("15:04", slots[i].Start)
+		if err != nil {
+			continue
+		}
+		end, err := time.Parse("15:04", slots[i].End)
+		if err != nil {
+			continue
+		}
+		from, to := start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+		if from <= to && minute >= from && minute < to || from > to && (minute >= from || minute < to) {
+			return &slots[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package sip1
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+// SDP媒体方向（RFC 3264 6.1）
+const (
+	sdpSendRecv = "sendrecv"
+	sdpSendOnly = "sendonly"
+	sdpRecvOnly = "recvonly"
+	sdpInactive = "inactive"
+)
+
+// sdpDirection 解析音频的媒体方向，媒体级属性优先于会话级；c=0.0.0.0或::（RFC 2543的保持方式）视为inactive
+func sdpDirection(body string) string {
+	sessionDirection, mediaDirection := "", ""
+	inAudio, seenMedia, nullAddr := false, false, false
+	for _, line := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			// 只看第一个音频流
+			inAudio = !seenMedia && strings.HasPrefix(line, "m=audio")
+			seenMedia = true
+		case strings.HasPrefix(line, "c="):
+			if (inAudio || !seenMedia) && (strings.HasSuffix(line, " 0.0.0.0") || strings.HasSuffix(line, " ::")) {
+				nullAddr = true
+			}
+		case strings.HasPrefix(line, "a="):
+			switch attr := line[2:]; attr {
+			case sdpSendRecv, sdpSendOnly, sdpRecvOnly, sdpInactive:
+				if !seenMedia {
+					sessionDirection = attr
+				} else if inAudio {
+					mediaDirection = attr
+				}
+			}
+		}
+	}
+	switch {
+	case nullAddr:
+		return sdpInactive
+	case mediaDirection != "":
+		return mediaDirection
+	case sessionDirection != "":
+		return sessionDirection
+	default:
+		return sdpSendRecv
+	}
+}
+
+// unspecifiedAddr RTP地址是否为保持时使用的0.0.0.0或::
+func unspecifiedAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && hostIP(host).IsUnspecified()
+}
+
+// answerDirection 应答中与对端方向对应的本端方向
+func answerDirection(offer string) string {
+	switch offer {
+	case sdpSendOnly:
+		return sdpRecvOnly
+	case sdpRecvOnly:
+		return sdpSendOnly
+	case sdpInactive:
+		return sdpInactive
+	default:
+		return sdpSendRecv
+	}
+}
+
+// isHoldDirection 对端不再接收我们的媒体，视为保持
+func isHoldDirection(direction string) bool {
+	return direction == sdpSendOnly || direction == sdpInactive
+}
+
+// handleReInvite 处理对话内的re-INVITE：按SDP方向保持或恢复AI会话，沿用原通话的编码应答，
+// 对端RTP地址变化时更新媒体目标
+func (as *SipServer) handleReInvite(req *sip.Request, tx sip.ServerTransaction, dialog *CallDialog) {
+	callID := req.CallID().Value()
+	if !as.checkSessionInterval(req, tx) {
+		return
+	}
+	offer := string(req.Body())
+	iceSession := as.getICESession(callID)
+	direction := sdpSendRecv
+	if offer != "" {
+		direction = sdpDirection(offer)
+		// 使用ICE的通话媒体路径由连通性检查决定，不随SDP地址变化
+		if rtpAddr, err := ParseSDPForRTPAddress(offer); err == nil && !unspecifiedAddr(rtpAddr) && iceSession == nil {
+			as.updateRTPPeer(callID, rtpAddr)
+		}
+	}
+
+	// 目标刷新（RFC 3261 12.2.2）
+	if contact := req.Contact(); contact != nil {
+		dialog.mutex.Lock()
+		dialog.RemoteTarget = contact.Address
+		dialog.mutex.Unlock()
+	}
+
+	serverIP := getServerIPFromRequest(req)
+	sdp := generateSDP(serverIP, as.config.LocalRTPPort, as.getCallCodec(callID))
+	if iceSession != nil {
+		sdp = iceSession.answerSDP(as.getCallCodec(callID))
+	}
+	sdpBytes := []byte(strings.Replace(sdp, "a="+sdpSendRecv, "a="+answerDirection(direction), 1))
+
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", sdpBytes)
+	contentType := sip.ContentTypeHeader("application/sdp")
+	res.AppendHeader(&contentType)
+	res.AppendHeader(&sip.ContactHeader{Address: sip.Uri{Host: uriHost(serverIP), Port: as.config.Port}})
+	// re-INVITE同时是会话刷新
+	sessionExpires, refresh := as.answerSessionTimer(req, res)
+	if err := tx.Respond(res); err != nil {
+		logger.Error("Failed to answer re-INVITE", zap.String("call_id", callID), zap.Error(err))
+		return
+	}
+	as.startSessionTimer(dialog, sessionExpires, refresh)
+
+	logger.Info("re-INVITE answered",
+		zap.String("call_id", callID),
+		zap.String("direction", direction))
+
+	// 不带SDP的re-INVITE由ACK携带应答，保持状态不变
+	if as.aiEngine == nil || offer == "" {
+		return
+	}
+	if isHoldDirection(direction) {
+		as.aiEngine.HoldSession(callID)
+	} else {
+		as.aiEngine.ResumeSession(callID)
+	}
+}
+
+// updateRTPPeer re-INVITE更换了对端RTP地址时更新活跃会话、AI会话和RTCP的目标，并重新锁定对称RTP
+func (as *SipServer) updateRTPPeer(callID, rtpAddr string) {
+	addr, err := net.ResolveUDPAddr("udp", rtpAddr)
+	if err != nil {
+		logger.Warn("Invalid RTP address in re-INVITE", zap.String("call_id", callID), zap.String("rtp_addr", rtpAddr))
+		return
+	}
+	if current := as.callRTPPeer(callID); current != nil && current.String() == addr.String() {
+		return
+	}
+	if as.aiEngine != nil {
+		if session := as.aiEngine.GetSession(callID); session != nil {
+			session.latchedPeer.Store(nil)
+			session.rtpLatch.Store(false)
+		}
+	}
+	as.setRTPPeer(callID, addr)
+	logger.Info("RTP peer changed by re-INVITE", zap.String("call_id", callID), zap.String("rtp_addr", addr.String()))
+}
+
+// setRTPPeer 更新活跃会话、AI会话、桥接和RTCP的RTP目标
+func (as *SipServer) setRTPPeer(callID string, addr *net.UDPAddr) {
+	if info, exists := as.config.GetActiveSession(callID); exists {
+		info.ClientRTPAddr = addr
+	}
+	if as.aiEngine != nil {
+		if session := as.aiEngine.GetSession(callID); session != nil {
+			session.mutex.Lock()
+			session.ClientAddr = addr.String()
+			session.mutex.Unlock()
+		}
+	}
+	as.setBridgePeer(callID, addr)
+	if as.rtcpSessionFor(callID) != nil {
+		as.stopRTCP(callID)
+		as.startRTCP(callID, addr, as.getCallCodec(callID))
+	}
+}
+
+// HoldSession 对端保持通话：暂停放音和识别，保持超过HoldTimeout时挂断
+func (engine *AIPhoneEngine) HoldSession(callID string) {
+	session := engine.GetSession(callID)
+	if session == nil || !session.enterHold() {
+		return
+	}
+	logger.Info("Session on hold", zap.String("call_id", callID))
+	held := session.monitorEvent(MonitorSessionHeld)
+	held.Status = string(models.SessionStatusHeld)
+	session.publish(held)
+
+	if engine.server == nil || engine.server.config.HoldTimeout <= 0 {
+		return
+	}
+	timeout := engine.server.config.HoldTimeout
+	session.setHoldTimer(time.AfterFunc(timeout, func() {
+		if !session.isHeld() {
+			return
+		}
+		logger.Info("Hold timeout, hanging up", zap.String("call_id", session.CallID), zap.Duration("timeout", timeout))
+		if session.DBSession != nil {
+			session.DBSession.ErrorMessage = "hold timeout"
+		}
+		engine.server.hangupCall(session.CallID)
+		session.requestStop()
+	}))
+}
+
+// ResumeSession 对端恢复通话：继续暂停处的放音，识别重新计时
+func (engine *AIPhoneEngine) ResumeSession(callID string) {
+	session := engine.GetSession(callID)
+	if session == nil || !session.leaveHold() {
+		return
+	}
+	logger.Info("Session resumed", zap.String("call_id", callID))
+	resumed := session.monitorEvent(MonitorSessionResumed)
+	resumed.Status = string(models.SessionStatusRunning)
+	session.publish(resumed)
+}
+
+// enterHold 进入保持状态，已保持或已清理时返回false
+func (session *ScriptSession) enterHold() bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.held || session.closed {
+		return false
+	}
+	session.held = true
+	session.resumed = make(chan struct{})
+	session.Status = models.SessionStatusHeld
+	if session.DBSession != nil {
+		session.DBSession.Status = models.SessionStatusHeld
+	}
+	return true
+}
+
+// leaveHold 退出保持状态，唤醒等待恢复的放音和识别；未保持时返回false
+func (session *ScriptSession) leaveHold() bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if !session.held {
+		return false
+	}
+	session.held = false
+	close(session.resumed)
+	if session.holdTimer != nil {
+		session.holdTimer.Stop()
+		session.holdTimer = nil
+	}
+	session.Status = models.SessionStatusRunning
+	if session.DBSession != nil {
+		session.DBSession.Status = models.SessionStatusRunning
+	}
+	return true
+}
+
+func (session *ScriptSession) setHoldTimer(timer *time.Timer) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if !session.held {
+		timer.Stop()
+		return
+	}
+	session.holdTimer = timer
+}
+
+func (session *ScriptSession) isHeld() bool {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	return session.held
+}
+
+// waitResumed 保持期间阻塞到恢复、停止或stop关闭，返回是否等待过
+func (session *ScriptSession) waitResumed(stop <-chan struct{}) bool {
+	session.mutex.RLock()
+	held, resumed := session.held, session.resumed
+	session.mutex.RUnlock()
+	if !held {
+		return false
+	}
+	select {
+	case <-resumed:
+	case <-stop:
+	}
+	return true
+}
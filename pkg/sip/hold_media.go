@@ -0,0 +1,179 @@
+package sip1
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/audio"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// holdLoopGap 等待音乐每轮之间的间隔，提示语循环时避免连读
+const holdLoopGap = 2 * time.Second
+
+// ErrNoHoldMedia 没有可播放的等待音乐
+var ErrNoHoldMedia = errors.New("no hold media configured")
+
+// holdPlayer 循环播放等待音乐或提示语，直到Stop
+type holdPlayer struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Stop 停止播放并等待播放协程退出，nil安全
+func (p *holdPlayer) Stop() {
+	if p == nil {
+		return
+	}
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	<-p.done
+}
+
+// loadHoldAudio 读取等待音乐，file优先，其次用TTS合成text；WAV文件按采样率缓存
+func (engine *AIPhoneEngine) loadHoldAudio(session *ScriptSession, file, text, speakerID string) ([]int16, error) {
+	sampleRate := session.Codec.SampleRate
+	if file == "" {
+		if text == "" {
+			return nil, ErrNoHoldMedia
+		}
+		samples, err := engine.callTTSService(text, speakerID, sampleRate)
+		if err == nil && session.Cost != nil {
+			session.Cost.AddTTSText(text)
+		}
+		return samples, err
+	}
+
+	key := fmt.Sprintf("%s@%d", file, sampleRate)
+	engine.mutex.RLock()
+	samples, cached := engine.holdAudio[key]
+	engine.mutex.RUnlock()
+	if cached {
+		return samples, nil
+	}
+
+	format, samples, err := audio.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hold music %s: %w", file, err)
+	}
+	if samples, err = audio.Convert(format, samples, sampleRate); err != nil {
+		return nil, fmt.Errorf("failed to convert hold music %s: %w", file, err)
+	}
+	normalizeLoudness(samples, sampleRate)
+	engine.mutex.Lock()
+	engine.holdAudio[key] = samples
+	engine.mutex.Unlock()
+	return samples, nil
+}
+
+// startHold 开始循环播放等待音乐，file和text都为空时使用配置的默认等待音乐；没有可播放内容时返回nil
+func (engine *AIPhoneEngine) startHold(session *ScriptSession, file, text, speakerID string) *holdPlayer {
+	return engine.startAnnouncedHold(session, file, text, speakerID, nil)
+}
+
+// startAnnouncedHold 同startHold，announce不为nil时每隔waitAnnounceInterval在两轮音乐之间合成并播放它返回的提示，返回空串时跳过
+func (engine *AIPhoneEngine) startAnnouncedHold(session *ScriptSession, file, text, speakerID string, announce func() string) *holdPlayer {
+	// 文字会话没有等待音乐，只发送一次等待提示
+	if session.text != nil {
+		session.text.say(text)
+		return nil
+	}
+	if file == "" && text == "" && engine.server != nil {
+		file = engine.server.config.HoldMusicFile
+	}
+	if file == "" && text == "" {
+		return nil
+	}
+
+	player := &holdPlayer{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(player.done)
+
+		samples, err := engine.loadHoldAudio(session, file, text, speakerID)
+		if err != nil || len(samples) == 0 {
+			logger.Warn("Failed to load hold media",
+				zap.String("call_id", session.CallID),
+				zap.String("file", file),
+				zap.Error(err))
+			return
+		}
+
+		logger.Debug("Hold media started", zap.String("call_id", session.CallID), zap.String("file", file))
+		var lastAnnounce time.Time
+		for {
+			if announce != nil && engine.getClock().Now().Sub(lastAnnounce) >= waitAnnounceInterval {
+				lastAnnounce = engine.getClock().Now()
+				if err := engine.playHoldAnnouncement(session, announce(), speakerID, player.stop); err != nil {
+					logger.Warn("Hold announcement failed", zap.String("call_id", session.CallID), zap.Error(err))
+				}
+			}
+			if err := engine.playAudio(session, samples, player.stop); err != nil {
+				logger.Warn("Hold media playback failed", zap.String("call_id", session.CallID), zap.Error(err))
+				return
+			}
+			select {
+			case <-player.stop:
+				logger.Debug("Hold media stopped", zap.String("call_id", session.CallID))
+				return
+			case <-engine.getClock().After(holdLoopGap):
+			}
+		}
+	}()
+	return player
+}
+
+// playHoldAnnouncement 合成并播放等待期间的提示
+func (engine *AIPhoneEngine) playHoldAnnouncement(session *ScriptSession, text, speakerID string, stop <-chan struct{}) error {
+	if text == "" {
+		return nil
+	}
+	samples, err := engine.callTTSService(text, speakerID, session.Codec.SampleRate)
+	if err != nil {
+		return err
+	}
+	if session.Cost != nil {
+		session.Cost.AddTTSText(text)
+	}
+	return engine.playAudio(session, samples, stop)
+}
+
+// StartHold 对通话循环播放等待音乐（停泊、排队时使用），file为空时使用配置的默认等待音乐
+func (engine *AIPhoneEngine) StartHold(callID, file string) error {
+	session := engine.GetSession(callID)
+	if session == nil {
+		return fmt.Errorf("session not found: %s", callID)
+	}
+
+	player := engine.startHold(session, file, "", "")
+	if player == nil {
+		return ErrNoHoldMedia
+	}
+
+	session.mutex.Lock()
+	previous := session.hold
+	session.hold = player
+	session.mutex.Unlock()
+	previous.Stop()
+	return nil
+}
+
+// StopHold 停止通话的等待音乐
+func (engine *AIPhoneEngine) StopHold(callID string) {
+	session := engine.GetSession(callID)
+	if session == nil {
+		return
+	}
+	session.mutex.Lock()
+	player := session.hold
+	session.hold = nil
+	session.mutex.Unlock()
+	player.Stop()
+}
@@ -0,0 +1,327 @@
+package sip1
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/pion/ice/v4"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/stun/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// iceGatherTimeout 等待候选收集完成的最长时间，超时后用已收集的候选应答
+	iceGatherTimeout = 5 * time.Second
+	// iceConnectTimeout 连通性检查的最长时间，超时未选出路径时挂断
+	iceConnectTimeout = 30 * time.Second
+)
+
+// iceOffer INVITE的SDP中对端的ICE参数，只取RTP分量（component 1）的候选
+type iceOffer struct {
+	ufrag      string
+	pwd        string
+	lite       bool
+	candidates []ice.Candidate
+}
+
+// parseICEOffer 解析SDP会话级和音频媒体级的ice-ufrag、ice-pwd、ice-lite和候选，对端未使用ICE时返回nil
+func parseICEOffer(sdpBody string) *iceOffer {
+	var session sdp.SessionDescription
+	if err := session.Unmarshal([]byte(sdpBody)); err != nil {
+		return nil
+	}
+	offer := &iceOffer{}
+	read := func(attributes []sdp.Attribute) {
+		for _, attribute := range attributes {
+			switch attribute.Key {
+			case "ice-ufrag":
+				offer.ufrag = attribute.Value
+			case "ice-pwd":
+				offer.pwd = attribute.Value
+			case "ice-lite":
+				offer.lite = true
+			case "candidate":
+				if candidate, err := ice.UnmarshalCandidate(attribute.Value); err == nil && candidate.Component() == ice.ComponentRTP {
+					offer.candidates = append(offer.candidates, candidate)
+				}
+			}
+		}
+	}
+	read(session.Attributes)
+	for _, media := range session.MediaDescriptions {
+		if media.MediaName.Media == "audio" {
+			read(media.Attributes)
+			break
+		}
+	}
+	if offer.ufrag == "" || offer.pwd == "" {
+		return nil
+	}
+	return offer
+}
+
+// iceSession 一通ICE呼叫的媒体通道：连通性检查选出的路径经本机回环中继接入RTP端口，
+// 引擎和录音把中继地址当作普通RTP对端，与网页通话网关相同
+type iceSession struct {
+	callID      string
+	agent       *ice.Agent
+	relay       *net.UDPConn
+	attributes  []sdp.Attribute // 应答中的ice-ufrag、ice-pwd和候选，re-INVITE应答沿用
+	defaultAddr *net.UDPAddr    // 应答c=和m=行使用的默认候选
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// iceServerURIs 解析配置的STUN/TURN地址，TURN使用配置的用户名和密码
+func (as *SipServer) iceServerURIs() ([]*stun.URI, error) {
+	uris := make([]*stun.URI, 0, len(as.config.ICEServers))
+	for _, raw := range as.config.ICEServers {
+		uri, err := stun.ParseURI(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ICE server %q: %w", raw, err)
+		}
+		if uri.Scheme == stun.SchemeTypeTURN || uri.Scheme == stun.SchemeTypeTURNS {
+			uri.Username, uri.Password = as.config.ICEUsername, as.config.ICECredential
+		}
+		uris = append(uris, uri)
+	}
+	return uris, nil
+}
+
+// startICE 为提供ICE的INVITE收集host、srflx和relay候选，加入对端候选后开始连通性检查；
+// 检查在后台进行，成功后经中继转发媒体，失败时挂断
+func (as *SipServer) startICE(callID string, offer *iceOffer) (*iceSession, error) {
+	uris, err := as.iceServerURIs()
+	if err != nil {
+		return nil, err
+	}
+	candidateTypes := []ice.CandidateType{ice.CandidateTypeHost}
+	if len(uris) > 0 {
+		candidateTypes = append(candidateTypes, ice.CandidateTypeServerReflexive, ice.CandidateTypeRelay)
+	}
+	agent, err := ice.NewAgent(&ice.AgentConfig{
+		Urls:             uris,
+		NetworkTypes:     []ice.NetworkType{ice.NetworkTypeUDP4},
+		CandidateTypes:   candidateTypes,
+		MulticastDNSMode: ice.MulticastDNSModeDisabled,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ICE agent: %w", err)
+	}
+	session := &iceSession{callID: callID, agent: agent, done: make(chan struct{})}
+
+	candidates, err := gatherICECandidates(agent)
+	if err != nil {
+		session.close()
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		session.close()
+		return nil, fmt.Errorf("no ICE candidates gathered")
+	}
+	for _, candidate := range offer.candidates {
+		if err := agent.AddRemoteCandidate(candidate); err != nil {
+			logger.Debug("Ignoring remote ICE candidate", zap.String("call_id", callID), zap.Error(err))
+		}
+	}
+
+	ufrag, pwd, err := agent.GetLocalUserCredentials()
+	if err != nil {
+		session.close()
+		return nil, fmt.Errorf("failed to get ICE credentials: %w", err)
+	}
+	session.attributes = []sdp.Attribute{{Key: "ice-ufrag", Value: ufrag}, {Key: "ice-pwd", Value: pwd}}
+	for _, candidate := range candidates {
+		session.attributes = append(session.attributes, sdp.Attribute{Key: "candidate", Value: candidate.Marshal()})
+	}
+	session.defaultAddr = defaultICECandidate(candidates)
+
+	if session.relay, err = as.webrtc.listenRelay(); err != nil {
+		session.close()
+		return nil, fmt.Errorf("failed to open RTP relay: %w", err)
+	}
+
+	as.iceMutex.Lock()
+	if previous := as.iceSessions[callID]; previous != nil {
+		previous.close()
+	}
+	as.iceSessions[callID] = session
+	as.iceMutex.Unlock()
+
+	go as.connectICE(session, offer)
+
+	logger.Info("ICE candidates gathered",
+		zap.String("call_id", callID),
+		zap.Int("local_candidates", len(candidates)),
+		zap.Int("remote_candidates", len(offer.candidates)),
+		zap.String("default_candidate", session.defaultAddr.String()))
+	return session, nil
+}
+
+// gatherICECandidates 收集全部本地候选，超过iceGatherTimeout时返回已收集的部分
+func gatherICECandidates(agent *ice.Agent) ([]ice.Candidate, error) {
+	var mutex sync.Mutex
+	var candidates []ice.Candidate
+	gathered := make(chan struct{})
+	if err := agent.OnCandidate(func(candidate ice.Candidate) {
+		if candidate == nil {
+			close(gathered)
+			return
+		}
+		mutex.Lock()
+		candidates = append(candidates, candidate)
+		mutex.Unlock()
+	}); err != nil {
+		return nil, fmt.Errorf("failed to watch ICE candidates: %w", err)
+	}
+	if err := agent.GatherCandidates(); err != nil {
+		return nil, fmt.Errorf("failed to gather ICE candidates: %w", err)
+	}
+	select {
+	case <-gathered:
+	case <-time.After(iceGatherTimeout):
+		logger.Warn("ICE gathering timeout, answering with partial candidates")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	return append([]ice.Candidate(nil), candidates...), nil
+}
+
+// defaultICECandidate 选择写入c=和m=行的默认候选：不支持ICE的中间设备也最可能连通的relay，其次srflx，最后host
+func defaultICECandidate(candidates []ice.Candidate) *net.UDPAddr {
+	rank := map[ice.CandidateType]int{ice.CandidateTypeRelay: 3, ice.CandidateTypeServerReflexive: 2, ice.CandidateTypeHost: 1}
+	best := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if rank[candidate.Type()] > rank[best.Type()] {
+			best = candidate
+		}
+	}
+	return &net.UDPAddr{IP: net.ParseIP(best.Address()), Port: best.Port()}
+}
+
+// answerSDP 按默认候选和ICE属性生成应答
+func (s *iceSession) answerSDP(codec rtpCodec) string {
+	return generateSDP(s.defaultAddr.IP.String(), s.defaultAddr.Port, codec, s.attributes...)
+}
+
+// connectICE 进行连通性检查，我方作为应答方是受控方，对端为ice-lite时由我方控制；选出路径后双向转发媒体
+func (as *SipServer) connectICE(session *iceSession, offer *iceOffer) {
+	ctx, cancel := context.WithTimeout(context.Background(), iceConnectTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-session.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var conn *ice.Conn
+	var err error
+	if offer.lite {
+		conn, err = session.agent.Dial(ctx, offer.ufrag, offer.pwd)
+	} else {
+		conn, err = session.agent.Accept(ctx, offer.ufrag, offer.pwd)
+	}
+	if err != nil {
+		select {
+		case <-session.done:
+		default:
+			logger.Warn("ICE connectivity checks failed, hanging up", zap.String("call_id", session.callID), zap.Error(err))
+			as.hangupCall(session.callID)
+		}
+		return
+	}
+	if pair, err := session.agent.GetSelectedCandidatePair(); err == nil && pair != nil {
+		logger.Info("ICE connected",
+			zap.String("call_id", session.callID),
+			zap.String("local", pair.Local.String()),
+			zap.String("remote", pair.Remote.String()))
+	}
+
+	engineAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: as.config.LocalRTPPort}
+	go session.forwardInbound(conn, engineAddr)
+	session.forwardOutbound(conn, as.config.LocalRTPPort)
+}
+
+// forwardInbound 选出路径上收到的RTP转发到本机RTP端口，复用时的RTCP（RFC 5761）不转发
+func (s *iceSession) forwardInbound(conn *ice.Conn, engineAddr *net.UDPAddr) {
+	buffer := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return
+		}
+		if n < 2 || buffer[1] >= 192 && buffer[1] <= 223 {
+			continue
+		}
+		if _, err := s.relay.WriteToUDP(buffer[:n], engineAddr); err != nil {
+			return
+		}
+	}
+}
+
+// forwardOutbound 本机RTP端口发往中继的媒体经选出路径发给对端
+func (s *iceSession) forwardOutbound(conn *ice.Conn, enginePort int) {
+	buffer := make([]byte, 1500)
+	for {
+		n, from, err := s.relay.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		if from.Port != enginePort {
+			continue
+		}
+		if _, err := conn.Write(buffer[:n]); err != nil {
+			logger.Debug("Failed to write ICE media", zap.String("call_id", s.callID), zap.Error(err))
+		}
+	}
+}
+
+// close 关闭ICE代理和中继，可重复调用
+func (s *iceSession) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if err := s.agent.Close(); err != nil {
+			logger.Debug("Failed to close ICE agent", zap.String("call_id", s.callID), zap.Error(err))
+		}
+		if s.relay != nil {
+			s.relay.Close()
+		}
+	})
+}
+
+// getICESession 返回通话的ICE媒体通道，未使用ICE时为nil
+func (as *SipServer) getICESession(callID string) *iceSession {
+	as.iceMutex.Lock()
+	defer as.iceMutex.Unlock()
+	return as.iceSessions[callID]
+}
+
+// stopICE 结束通话的ICE媒体通道
+func (as *SipServer) stopICE(callID string) {
+	as.iceMutex.Lock()
+	session := as.iceSessions[callID]
+	delete(as.iceSessions, callID)
+	as.iceMutex.Unlock()
+	if session != nil {
+		session.close()
+	}
+}
+
+// closeICESessions 服务关闭时结束全部ICE媒体通道
+func (as *SipServer) closeICESessions() {
+	as.iceMutex.Lock()
+	sessions := as.iceSessions
+	as.iceSessions = make(map[string]*iceSession)
+	as.iceMutex.Unlock()
+	for _, session := range sessions {
+		session.close()
+	}
+}
@@ -0,0 +1,204 @@
+package sip1
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/stun/v3"
+)
+
+const testICEOffer = "v=0\r\n" +
+	"o=- 1 1 IN IP4 198.51.100.7\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 198.51.100.7\r\n" +
+	"t=0 0\r\n" +
+	"a=ice-ufrag:F7gI\r\n" +
+	"a=ice-pwd:x9cml/YzichV2+XlhiMu8g\r\n" +
+	"m=audio 49170 RTP/AVP 0\r\n" +
+	"a=candidate:1 1 udp 2130706431 192.168.1.7 49170 typ host\r\n" +
+	"a=candidate:1 2 udp 2130706430 192.168.1.7 49171 typ host\r\n" +
+	"a=candidate:2 1 udp 1694498815 198.51.100.7 49170 typ srflx raddr 192.168.1.7 rport 49170\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n"
+
+func TestParseICEOffer(t *testing.T) {
+	offer := parseICEOffer(testICEOffer)
+	if offer == nil {
+		t.Fatal("parseICEOffer() = nil")
+	}
+	if offer.ufrag != "F7gI" || offer.pwd != "x9cml/YzichV2+XlhiMu8g" || offer.lite {
+		t.Errorf("offer credentials = %+v", offer)
+	}
+	// RTCP分量的候选不使用
+	if len(offer.candidates) != 2 || offer.candidates[0].Type() != ice.CandidateTypeHost || offer.candidates[1].Type() != ice.CandidateTypeServerReflexive {
+		t.Errorf("offer candidates = %v", offer.candidates)
+	}
+
+	lite := strings.Replace(testICEOffer, "t=0 0\r\n", "t=0 0\r\na=ice-lite\r\n", 1)
+	if offer := parseICEOffer(lite); offer == nil || !offer.lite {
+		t.Errorf("ice-lite offer = %+v", offer)
+	}
+	// 媒体级的凭据覆盖会话级
+	mediaLevel := strings.Replace(testICEOffer, "a=rtpmap:0 PCMU/8000\r\n", "a=rtpmap:0 PCMU/8000\r\na=ice-ufrag:Mx9Q\r\n", 1)
+	if offer := parseICEOffer(mediaLevel); offer == nil || offer.ufrag != "Mx9Q" {
+		t.Errorf("media level ufrag = %+v", offer)
+	}
+	for _, body := range []string{
+		generateSDP("192.168.1.7", 4000, codecPCMU),
+		strings.Replace(testICEOffer, "a=ice-pwd:x9cml/YzichV2+XlhiMu8g\r\n", "", 1),
+		"not sdp",
+	} {
+		if offer := parseICEOffer(body); offer != nil {
+			t.Errorf("parseICEOffer(%q) = %+v, want nil", body, offer)
+		}
+	}
+}
+
+func TestICEServerURIs(t *testing.T) {
+	server := newBridgeTestServer(t)
+	server.config.ICEServers = []string{"stun:stun.example.com:3478", " turn:turn.example.com:3478?transport=udp "}
+	server.config.ICEUsername, server.config.ICECredential = "lingsip", "secret"
+	uris, err := server.iceServerURIs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uris) != 2 || uris[0].Scheme != stun.SchemeTypeSTUN || uris[0].Username != "" {
+		t.Fatalf("uris = %v", uris)
+	}
+	if uris[1].Scheme != stun.SchemeTypeTURN || uris[1].Host != "turn.example.com" || uris[1].Username != "lingsip" || uris[1].Password != "secret" {
+		t.Errorf("turn uri = %+v", uris[1])
+	}
+
+	server.config.ICEServers = []string{"http://stun.example.com"}
+	if _, err := server.iceServerURIs(); err == nil {
+		t.Error("iceServerURIs() with an invalid URL expected error")
+	}
+}
+
+func TestDefaultICECandidate(t *testing.T) {
+	host, err := ice.NewCandidateHost(&ice.CandidateHostConfig{Network: "udp", Address: "192.168.1.7", Port: 4000, Component: ice.ComponentRTP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srflx, err := ice.NewCandidateServerReflexive(&ice.CandidateServerReflexiveConfig{Network: "udp", Address: "203.0.113.7", Port: 5000, Component: ice.ComponentRTP, RelAddr: "192.168.1.7", RelPort: 4000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay, err := ice.NewCandidateRelay(&ice.CandidateRelayConfig{Network: "udp", Address: "203.0.113.100", Port: 6000, Component: ice.ComponentRTP, RelAddr: "203.0.113.7", RelPort: 5000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		candidates []ice.Candidate
+		want       string
+	}{
+		{[]ice.Candidate{host}, "192.168.1.7:4000"},
+		{[]ice.Candidate{host, srflx}, "203.0.113.7:5000"},
+		{[]ice.Candidate{relay, host, srflx}, "203.0.113.100:6000"},
+	}
+	for _, tt := range tests {
+		if got := defaultICECandidate(tt.candidates); got.String() != tt.want {
+			t.Errorf("defaultICECandidate(%v) = %s, want %s", tt.candidates, got, tt.want)
+		}
+	}
+}
+
+// TestICEConnectivity 来电者作为控制方与服务器完成连通性检查，媒体经中继双向到达RTP端口
+func TestICEConnectivity(t *testing.T) {
+	server := newBridgeTestServer(t)
+	caller, err := ice.NewAgent(&ice.AgentConfig{
+		NetworkTypes:     []ice.NetworkType{ice.NetworkTypeUDP4},
+		CandidateTypes:   []ice.CandidateType{ice.CandidateTypeHost},
+		MulticastDNSMode: ice.MulticastDNSModeDisabled,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { caller.Close() })
+	candidates, err := gatherICECandidates(caller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) == 0 {
+		t.Skip("no non-loopback interface for ICE host candidates")
+	}
+	ufrag, pwd, err := caller.GetLocalUserCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := server.startICE("ice-call", &iceOffer{ufrag: ufrag, pwd: pwd, candidates: candidates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.getICESession("ice-call") != session {
+		t.Error("ICE session not registered")
+	}
+	// 应答带ice-ufrag、ice-pwd和候选，c=和m=使用默认候选
+	var answer sdp.SessionDescription
+	if err := answer.Unmarshal([]byte(session.answerSDP(codecPCMU))); err != nil {
+		t.Fatal(err)
+	}
+	if answer.ConnectionInformation.Address.Address != session.defaultAddr.IP.String() || answer.MediaDescriptions[0].MediaName.Port.Value != session.defaultAddr.Port {
+		t.Errorf("answer default address = %v", answer)
+	}
+	remote := parseICEOffer(session.answerSDP(codecPCMU))
+	if remote == nil || len(remote.candidates) == 0 {
+		t.Fatalf("answer ICE attributes = %+v", remote)
+	}
+	for _, candidate := range remote.candidates {
+		if err := caller.AddRemoteCandidate(candidate); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := caller.Dial(ctx, remote.ufrag, remote.pwd)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	// 来电者的RTP经中继到达本机RTP端口，RTCP不转发
+	rtcp := []byte{0x80, 200, 0, 1, 0, 0, 0, 1}
+	rtpPacket := []byte{0x80, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0xff}
+	if _, err := conn.Write(rtcp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(rtpPacket); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 1500)
+	server.rtpConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, relayAddr, err := server.rtpConn.ReadFromUDP(buffer)
+	if err != nil || string(buffer[:n]) != string(rtpPacket) {
+		t.Fatalf("RTP port received %x, %v", buffer[:n], err)
+	}
+	if !relayAddr.IP.IsLoopback() || !sameUDPAddr(relayAddr, session.relay.LocalAddr().(*net.UDPAddr)) {
+		t.Errorf("media arrived from %s, want the relay %s", relayAddr, session.relay.LocalAddr())
+	}
+
+	// 引擎发往中继的RTP经选出路径发给来电者
+	reply := []byte{0x80, 0, 0, 2, 0, 0, 0, 160, 0, 0, 0, 2, 0x7f}
+	if _, err := server.rtpConn.WriteToUDP(reply, relayAddr); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := conn.Read(buffer); err != nil || string(buffer[:n]) != string(reply) {
+		t.Fatalf("caller received %x, %v", buffer[:n], err)
+	}
+
+	server.stopICE("ice-call")
+	if server.getICESession("ice-call") != nil {
+		t.Error("ICE session still registered after stopICE")
+	}
+	select {
+	case <-session.done:
+	default:
+		t.Error("ICE session not closed")
+	}
+}
@@ -0,0 +1,84 @@
+package sip1
+
+import (
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// inviteWorkerCount 处理INVITE持久化的协程数
+	inviteWorkerCount = 4
+	// inviteQueueSize 待处理任务上限，队列满时退化为同步处理
+	inviteQueueSize = 256
+	// invitePersistWait ACK/BYE等后续请求等待持久化完成的最长时间
+	invitePersistWait = 2 * time.Second
+)
+
+// inviteJob 200 OK发出后异步执行的会话和通话记录持久化
+type inviteJob struct {
+	callID        string
+	clientRTPAddr string
+	sipCall       *models.SipCall
+	done          chan struct{}
+}
+
+// runInviteWorkers 启动INVITE持久化协程，随服务关闭退出
+func (as *SipServer) runInviteWorkers() {
+	for i := 0; i < inviteWorkerCount; i++ {
+		go func() {
+			for {
+				select {
+				case <-as.stopChan:
+					return
+				case job := <-as.inviteJobs:
+					as.runInviteJob(job)
+				}
+			}
+		}()
+	}
+}
+
+// dispatchInvite 提交持久化任务，INVITE处理协程无需等待数据库写入
+func (as *SipServer) dispatchInvite(job *inviteJob) {
+	job.done = make(chan struct{})
+
+	as.inflightMutex.Lock()
+	as.inflightInvites[job.callID] = job.done
+	as.inflightMutex.Unlock()
+
+	select {
+	case as.inviteJobs <- job:
+	default:
+		logger.Warn("INVITE worker queue full, persisting synchronously", zap.String("call_id", job.callID))
+		as.runInviteJob(job)
+	}
+}
+
+func (as *SipServer) runInviteJob(job *inviteJob) {
+	defer func() {
+		close(job.done)
+		as.inflightMutex.Lock()
+		delete(as.inflightInvites, job.callID)
+		as.inflightMutex.Unlock()
+	}()
+	as.persistInvite(job)
+}
+
+// waitInvitePersisted 等待通话的INVITE持久化完成，保证ACK/BYE/CANCEL能查到待接通会话
+func (as *SipServer) waitInvitePersisted(callID string) {
+	as.inflightMutex.RLock()
+	done, exists := as.inflightInvites[callID]
+	as.inflightMutex.RUnlock()
+	if !exists {
+		return
+	}
+
+	select {
+	case <-done:
+	case <-time.After(invitePersistWait):
+		logger.Warn("Timed out waiting for INVITE persistence", zap.String("call_id", callID))
+	}
+}
@@ -0,0 +1,151 @@
+package sip1
+
+import (
+	"bytes"
+	"net"
+	"strings"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+)
+
+// sipgo的URI解析不支持方括号中的IPv6地址（RFC 3261 19.1.1的IPv6reference），
+// 解析前把[2001:db8::1]替换为等长的~2001-db8--1~，解析后再还原到Host。
+// ~不能出现在主机名中，替换后的地址不会与真实主机名混淆；等长保证逗号分隔的多值头部按原文切分
+
+// maskIPv6Literals 原地替换b中方括号内的IPv6地址，不含冒号的方括号内容保持不变
+func maskIPv6Literals(b []byte) {
+	for start := 0; start < len(b); start++ {
+		if b[start] != '[' {
+			continue
+		}
+		end := bytes.IndexByte(b[start:], ']')
+		if end < 0 {
+			return
+		}
+		end += start
+		if bytes.IndexByte(b[start:end], ':') >= 0 {
+			b[start], b[end] = '~', '~'
+			for i := start + 1; i < end; i++ {
+				if b[i] == ':' {
+					b[i] = '-'
+				}
+			}
+		}
+		start = end
+	}
+}
+
+// maskIPv6String maskIPv6Literals的字符串形式
+func maskIPv6String(s string) string {
+	if !strings.Contains(s, "[") {
+		return s
+	}
+	b := []byte(s)
+	maskIPv6Literals(b)
+	return string(b)
+}
+
+// unmaskIPv6Host 还原maskIPv6Literals替换的主机，其余主机原样返回
+func unmaskIPv6Host(host string) string {
+	if len(host) < 2 || host[0] != '~' || host[len(host)-1] != '~' {
+		return host
+	}
+	return "[" + strings.ReplaceAll(host[1:len(host)-1], "-", ":") + "]"
+}
+
+// maskRequestLine 原地替换收到的请求行中的IPv6地址，请求URI的Host由unmaskRecipient还原
+func maskRequestLine(b []byte) {
+	end := bytes.IndexByte(b, '\n')
+	if end < 0 {
+		end = len(b)
+	}
+	maskIPv6Literals(b[:end])
+}
+
+// unmaskRecipient 还原请求URI中被maskRequestLine替换的IPv6地址，需放在中间件链的最前面
+func unmaskRecipient(next sipgo.RequestHandler) sipgo.RequestHandler {
+	return func(req *sip.Request, tx sip.ServerTransaction) {
+		if req.Recipient != nil {
+			req.Recipient.Host = unmaskIPv6Host(req.Recipient.Host)
+		}
+		next(req, tx)
+	}
+}
+
+// parseURI 支持IPv6地址的sip.ParseUri
+func parseURI(s string, uri *sip.Uri) error {
+	if err := sip.ParseUri(maskIPv6String(s), uri); err != nil {
+		return err
+	}
+	uri.Host = unmaskIPv6Host(uri.Host)
+	return nil
+}
+
+// parseAddressValue 支持IPv6地址的sip.ParseAddressValue
+func parseAddressValue(s string, uri *sip.Uri, params sip.HeaderParams) (string, error) {
+	displayName, err := sip.ParseAddressValue(maskIPv6String(s), uri, params)
+	if err != nil {
+		return "", err
+	}
+	uri.Host = unmaskIPv6Host(uri.Host)
+	return displayName, nil
+}
+
+func init() {
+	// DefaultHeadersParser返回sipgo解析器实际使用的表，覆盖其中含地址的头部
+	parsers := sip.DefaultHeadersParser()
+	for _, name := range []string{"via", "v", "from", "f", "to", "t", "contact", "m", "route", "record-route"} {
+		if parse, ok := parsers[name]; ok {
+			parsers[name] = ipv6HeaderParser(parse)
+		}
+	}
+}
+
+// ipv6HeaderParser 包装sipgo的头部解析，替换IPv6地址后解析并还原Host；逗号分隔时的错误原样返回，切分位置不变
+func ipv6HeaderParser(parse sip.HeaderParser) sip.HeaderParser {
+	return func(headerName, headerData string) (sip.Header, error) {
+		if !strings.Contains(headerData, "[") {
+			return parse(headerName, headerData)
+		}
+		header, err := parse(headerName, maskIPv6String(headerData))
+		switch h := header.(type) {
+		case *sip.ViaHeader:
+			h.Host = unmaskIPv6Host(h.Host)
+		case *sip.FromHeader:
+			h.Address.Host = unmaskIPv6Host(h.Address.Host)
+		case *sip.ToHeader:
+			h.Address.Host = unmaskIPv6Host(h.Address.Host)
+		case *sip.ContactHeader:
+			h.Address.Host = unmaskIPv6Host(h.Address.Host)
+		case *sip.RouteHeader:
+			h.Address.Host = unmaskIPv6Host(h.Address.Host)
+		case *sip.RecordRouteHeader:
+			for ; h != nil; h = h.Next {
+				h.Address.Host = unmaskIPv6Host(h.Address.Host)
+			}
+		}
+		return header, err
+	}
+}
+
+// hostIP 解析主机中的IP地址，IPv6地址可以带方括号，不是IP时返回nil
+func hostIP(host string) net.IP {
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+}
+
+// uriHost IP地址在SIP URI中的主机形式，IPv6地址加方括号
+func uriHost(ip string) string {
+	if strings.Contains(ip, ":") && !strings.HasPrefix(ip, "[") {
+		return "[" + ip + "]"
+	}
+	return ip
+}
+
+// sdpAddressType SDP中c=和o=行的地址类型
+func sdpAddressType(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return "IP6"
+	}
+	return "IP4"
+}
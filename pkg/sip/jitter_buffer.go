@@ -0,0 +1,218 @@
+package sip1
+
+import (
+	"net"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	// defaultJitterBufferDepth 未配置时最多缓存5个包（100ms）等待乱序包
+	defaultJitterBufferDepth = 5
+	// jitterShrinkAfter 连续这么多个顺序包后缩小一格深度
+	jitterShrinkAfter = 50
+	// jitterResyncGap 序列号前后跳变超过这么多个包（约20秒）时视为对端重启了流，重新同步
+	jitterResyncGap = 1000
+)
+
+// jitterBuffer 入向RTP自适应抖动缓冲：按序列号重排，丢弃迟到包，
+// 等待深度随观测到的乱序程度在1到maxDepth之间调整
+type jitterBuffer struct {
+	maxDepth int
+	depth    int
+
+	packets map[uint16]*rtp.Packet
+	nextSeq uint16
+	started bool
+	inOrder int
+	highest uint16
+	ssrc    uint32
+
+	Late      int // 迟到或重复而丢弃的包
+	Lost      int // 等待超过深度后跳过的包
+	Reordered int // 乱序到达的包
+	Resyncs   int // SSRC变化或序列号跳变后重新同步的次数
+}
+
+func newJitterBuffer(maxDepth int) *jitterBuffer {
+	if maxDepth <= 0 {
+		maxDepth = defaultJitterBufferDepth
+	}
+	return &jitterBuffer{
+		maxDepth: maxDepth,
+		depth:    1,
+		packets:  make(map[uint16]*rtp.Packet),
+	}
+}
+
+// seqBefore a在b之前（考虑16位回绕）
+func seqBefore(a, b uint16) bool {
+	return int16(a-b) < 0
+}
+
+// needsResync 包属于另一个流：SSRC变了（对端重新INVITE、媒体服务器切换），
+// 或序列号离当前位置太远，继续按旧位置排序会把新流全部当成迟到包丢弃或等待大段缺包
+func (jb *jitterBuffer) needsResync(packet *rtp.Packet) bool {
+	if packet.SSRC != jb.ssrc {
+		return true
+	}
+	gap := int(int16(packet.SequenceNumber - jb.highest))
+	return gap > jitterResyncGap || gap < -jitterResyncGap
+}
+
+// Push 放入一个包，返回可以按序交给解码的包
+func (jb *jitterBuffer) Push(packet *rtp.Packet) []*rtp.Packet {
+	var flushed []*rtp.Packet
+	if jb.started && jb.needsResync(packet) {
+		// 旧流剩下的包先输出，再从新包重新开始
+		flushed = jb.release(true)
+		jb.started = false
+		jb.depth = 1
+		jb.inOrder = 0
+		jb.Resyncs++
+	}
+	seq := packet.SequenceNumber
+	if !jb.started {
+		jb.started = true
+		jb.nextSeq = seq
+		jb.highest = seq
+		jb.ssrc = packet.SSRC
+	}
+
+	if seqBefore(seq, jb.nextSeq) {
+		jb.Late++
+		return flushed
+	}
+	if _, exists := jb.packets[seq]; exists {
+		jb.Late++
+		return flushed
+	}
+	jb.packets[seq] = packet
+
+	if seqBefore(seq, jb.highest) {
+		// 乱序包，按乱序距离加深缓冲
+		jb.Reordered++
+		jb.inOrder = 0
+		if distance := int(jb.highest - seq); distance+1 > jb.depth {
+			jb.depth = min(distance+1, jb.maxDepth)
+		}
+	} else {
+		jb.highest = seq
+		jb.inOrder++
+		if jb.inOrder >= jitterShrinkAfter && jb.depth > 1 {
+			jb.depth--
+			jb.inOrder = 0
+		}
+	}
+
+	return append(flushed, jb.release(false)...)
+}
+
+// Flush 输出全部缓存的包，跳过缺失的序列号；入向流暂停时调用，避免缺包卡住后续音频
+func (jb *jitterBuffer) Flush() []*rtp.Packet {
+	return jb.release(true)
+}
+
+func (jb *jitterBuffer) release(flush bool) []*rtp.Packet {
+	var ready []*rtp.Packet
+	for len(jb.packets) > 0 {
+		if packet, exists := jb.packets[jb.nextSeq]; exists {
+			ready = append(ready, packet)
+			delete(jb.packets, jb.nextSeq)
+			jb.nextSeq++
+			continue
+		}
+		if !flush && len(jb.packets) <= jb.depth {
+			break
+		}
+		// 缺失的包等待超过深度，跳到最早的已缓存包
+		next := jb.nextSeq
+		first := true
+		for seq := range jb.packets {
+			if first || seqBefore(seq, next) {
+				next, first = seq, false
+			}
+		}
+		jb.Lost += int(next - jb.nextSeq)
+		jb.nextSeq = next
+	}
+	return ready
+}
+
+// inboundRTP 读取指定对端的协商载荷RTP，经抖动缓冲后按序返回
+type inboundRTP struct {
+	conn        RTPConn
+	clock       Clock
+	remote      *net.UDPAddr
+	payloadType uint8
+	jitter      *jitterBuffer
+	ready       []*rtp.Packet
+	buffer      []byte
+
+	// 其他载荷（telephone-event）的回调，为nil时丢弃
+	onEvent func(packet *rtp.Packet)
+
+	// 判断包是否来自对端（对称RTP锁定），为nil时只接受与remote同IP的包
+	accept func(source *net.UDPAddr) bool
+}
+
+func (engine *AIPhoneEngine) newInboundRTP(conn RTPConn, remote *net.UDPAddr, payloadType uint8) *inboundRTP {
+	depth := 0
+	if engine.server != nil {
+		depth = engine.server.config.JitterBufferDepth
+	}
+	return &inboundRTP{
+		conn:        conn,
+		clock:       engine.getClock(),
+		remote:      remote,
+		payloadType: payloadType,
+		jitter:      newJitterBuffer(depth),
+		buffer:      make([]byte, 1500),
+	}
+}
+
+// next 返回下一个按序的包，wait内没有可用包时返回读取超时错误
+func (r *inboundRTP) next(wait time.Duration) (*rtp.Packet, error) {
+	deadline := r.clock.Now().Add(wait)
+	for {
+		if len(r.ready) > 0 {
+			packet := r.ready[0]
+			r.ready = r.ready[1:]
+			return packet, nil
+		}
+
+		r.conn.SetReadDeadline(deadline)
+		n, receivedAddr, err := r.conn.ReadFromUDP(r.buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if r.ready = r.jitter.Flush(); len(r.ready) > 0 {
+					continue
+				}
+			}
+			return nil, err
+		}
+		if r.accept != nil {
+			if !r.accept(receivedAddr) {
+				continue
+			}
+		} else if !receivedAddr.IP.Equal(r.remote.IP) {
+			continue
+		}
+
+		// 缓冲中的包会保留载荷，不能引用复用的读缓冲
+		data := make([]byte, n)
+		copy(data, r.buffer[:n])
+		packet := &rtp.Packet{}
+		if err := packet.Unmarshal(data); err != nil || len(packet.Payload) == 0 {
+			continue
+		}
+		if packet.PayloadType != r.payloadType {
+			if r.onEvent != nil {
+				r.onEvent(packet)
+			}
+			continue
+		}
+		r.ready = r.jitter.Push(packet)
+	}
+}
@@ -0,0 +1,180 @@
+package sip1
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+func TestSeqBefore(t *testing.T) {
+	tests := []struct {
+		a, b uint16
+		want bool
+	}{
+		{1, 2, true},
+		{2, 1, false},
+		{5, 5, false},
+		{65535, 0, true},
+		{0, 65535, false},
+		{65530, 3, true},
+		{3, 65530, false},
+	}
+	for _, tt := range tests {
+		if got := seqBefore(tt.a, tt.b); got != tt.want {
+			t.Errorf("seqBefore(%d, %d) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestJitterBuffer(t *testing.T) {
+	type packet struct {
+		seq  uint16
+		ssrc uint32
+	}
+	stream := func(ssrc uint32, seqs ...uint16) []packet {
+		packets := make([]packet, len(seqs))
+		for i, seq := range seqs {
+			packets[i] = packet{seq, ssrc}
+		}
+		return packets
+	}
+	tests := []struct {
+		name     string
+		depth    int
+		packets  []packet
+		want     []uint16 // Push依次输出的序列号
+		flushed  []uint16 // 最后Flush输出的序列号
+		late     int
+		lost     int
+		reorder  int
+		resyncs  int
+		endDepth int // 期望的当前深度，0表示1
+	}{
+		{
+			name:    "in order",
+			packets: stream(1, 10, 11, 12, 13),
+			want:    []uint16{10, 11, 12, 13},
+		},
+		{
+			name:    "wraparound at 65535",
+			packets: stream(1, 65534, 65535, 0, 1),
+			want:    []uint16{65534, 65535, 0, 1},
+		},
+		{
+			name:     "reordered across wraparound",
+			packets:  stream(1, 65534, 0, 65535, 1),
+			want:     []uint16{65534, 65535, 0, 1},
+			reorder:  1,
+			endDepth: 2,
+		},
+		{
+			name:     "reordered",
+			packets:  stream(1, 1, 3, 2, 4, 5),
+			want:     []uint16{1, 2, 3, 4, 5},
+			reorder:  1,
+			endDepth: 2,
+		},
+		{
+			name:    "late and duplicate dropped",
+			packets: stream(1, 1, 2, 3, 2, 3, 1),
+			want:    []uint16{1, 2, 3},
+			late:    3,
+		},
+		{
+			// 深度为1时缺失的3等到后面又来了两个包才跳过
+			name:    "loss skipped after depth",
+			packets: stream(1, 1, 2, 4, 5, 6),
+			want:    []uint16{1, 2, 4, 5, 6},
+			lost:    1,
+		},
+		{
+			name:    "loss at end flushed",
+			packets: stream(1, 1, 2, 4),
+			want:    []uint16{1, 2},
+			flushed: []uint16{4},
+			lost:    1,
+		},
+		{
+			name:    "ssrc change resyncs",
+			packets: append(stream(1, 100, 101, 103), stream(2, 5000, 5001)...),
+			want:    []uint16{100, 101, 103, 5000, 5001},
+			lost:    1,
+			resyncs: 1,
+		},
+		{
+			// 新流的序列号落在旧流之前，不能当作迟到包全部丢弃
+			name:    "ssrc change to earlier sequence",
+			packets: append(stream(1, 30000, 30001), stream(2, 10, 11)...),
+			want:    []uint16{30000, 30001, 10, 11},
+			resyncs: 1,
+		},
+		{
+			name:    "large forward gap resyncs",
+			packets: stream(1, 1, 2, 20000, 20001),
+			want:    []uint16{1, 2, 20000, 20001},
+			resyncs: 1,
+		},
+		{
+			name:    "large backward gap resyncs",
+			packets: stream(1, 20000, 20001, 1, 2),
+			want:    []uint16{20000, 20001, 1, 2},
+			resyncs: 1,
+		},
+		{
+			name:     "depth capped",
+			depth:    2,
+			packets:  stream(1, 1, 5, 4, 3, 2),
+			want:     []uint16{1, 3, 4, 5},
+			late:     1,
+			lost:     1,
+			reorder:  2,
+			endDepth: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jb := newJitterBuffer(tt.depth)
+			var got []uint16
+			for _, p := range tt.packets {
+				for _, out := range jb.Push(&rtp.Packet{Header: rtp.Header{SequenceNumber: p.seq, SSRC: p.ssrc}}) {
+					got = append(got, out.SequenceNumber)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Push output = %v, want %v", got, tt.want)
+			}
+			var flushed []uint16
+			for _, out := range jb.Flush() {
+				flushed = append(flushed, out.SequenceNumber)
+			}
+			if !reflect.DeepEqual(flushed, tt.flushed) {
+				t.Errorf("Flush output = %v, want %v", flushed, tt.flushed)
+			}
+			if jb.Late != tt.late || jb.Lost != tt.lost || jb.Reordered != tt.reorder || jb.Resyncs != tt.resyncs {
+				t.Errorf("late/lost/reordered/resyncs = %d/%d/%d/%d, want %d/%d/%d/%d",
+					jb.Late, jb.Lost, jb.Reordered, jb.Resyncs, tt.late, tt.lost, tt.reorder, tt.resyncs)
+			}
+			wantDepth := max(tt.endDepth, 1)
+			if jb.depth != wantDepth {
+				t.Errorf("depth = %d, want %d", jb.depth, wantDepth)
+			}
+		})
+	}
+}
+
+func TestJitterBufferShrinks(t *testing.T) {
+	jb := newJitterBuffer(5)
+	jb.Push(&rtp.Packet{Header: rtp.Header{SequenceNumber: 1}})
+	jb.Push(&rtp.Packet{Header: rtp.Header{SequenceNumber: 4}})
+	jb.Push(&rtp.Packet{Header: rtp.Header{SequenceNumber: 2}})
+	if jb.depth != 3 {
+		t.Fatalf("depth after reorder = %d, want 3", jb.depth)
+	}
+	for seq := uint16(5); seq < 5+jitterShrinkAfter; seq++ {
+		jb.Push(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq}})
+	}
+	if jb.depth != 2 {
+		t.Errorf("depth after %d in-order packets = %d, want 2", jitterShrinkAfter, jb.depth)
+	}
+}
@@ -0,0 +1,283 @@
+package sip1
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+// ContactReachability 注册用户一个Contact的可达性
+type ContactReachability struct {
+	Username  string    `json:"username"`
+	URI       string    `json:"uri"`
+	Contact   string    `json:"contact"`
+	Reachable bool      `json:"reachable"`
+	Failures  int       `json:"failures"`
+	LastProbe time.Time `json:"lastProbe"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// runContactProber 按KeepAliveInterval周期探测所有注册用户
+func (as *SipServer) runContactProber() {
+	ticker := time.NewTicker(as.config.KeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-as.stopChan:
+			return
+		case <-ticker.C:
+			as.probeRegisteredContacts()
+		}
+	}
+}
+
+// registeredBindings 获取当前注册用户的所有Contact username -> bindings
+func (as *SipServer) registeredBindings() map[string][]ua.Binding {
+	bindings, err := as.config.RegisteredBindings()
+	if err != nil {
+		logger.Error("Failed to load registered users for probing", zap.Error(err))
+		return nil
+	}
+	return bindings
+}
+
+// probeRegisteredContacts 并发向每个注册的Contact发送OPTIONS并更新可达性，
+// 开启UnbindUnreachable时删除变为不可达的Contact
+func (as *SipServer) probeRegisteredContacts() {
+	users := as.registeredBindings()
+	var wg sync.WaitGroup
+	for _, bindings := range users {
+		for _, binding := range bindings {
+			wg.Add(1)
+			go func(binding ua.Binding) {
+				defer wg.Done()
+				err := as.sendOptionsProbe(binding)
+				if as.recordProbeResult(binding, err) && as.config.UnbindUnreachable {
+					as.unbindUnreachable(binding)
+				}
+			}(binding)
+		}
+	}
+	wg.Wait()
+
+	// 清理已经注销的用户和Contact
+	as.reachabilityMutex.Lock()
+	for username, states := range as.reachability {
+		for uri := range states {
+			if !hasBinding(users[username], uri) {
+				delete(states, uri)
+			}
+		}
+		if len(states) == 0 {
+			delete(as.reachability, username)
+		}
+	}
+	as.reachabilityMutex.Unlock()
+
+	if as.config.StorageType == ua.StorageTypeDatabase && as.config.Db != nil {
+		for username := range users {
+			as.saveUserReachability(username)
+		}
+	}
+}
+
+func hasBinding(bindings []ua.Binding, uri string) bool {
+	for _, binding := range bindings {
+		if binding.URI == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetContact 用户注销或注册过期后清理其可达性记录
+func (as *SipServer) forgetContact(username string) {
+	as.reachabilityMutex.Lock()
+	delete(as.reachability, username)
+	as.reachabilityMutex.Unlock()
+}
+
+// sendOptionsProbe 向Contact发送OPTIONS，收到任何最终响应都视为可达
+func (as *SipServer) sendOptionsProbe(binding ua.Binding) error {
+	var uri sip.Uri
+	if err := parseURI(binding.URI, &uri); err != nil {
+		host, portStr, err := net.SplitHostPort(binding.Contact)
+		if err != nil {
+			return fmt.Errorf("invalid contact %q: %w", binding.Contact, err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return fmt.Errorf("invalid contact port %q: %w", portStr, err)
+		}
+		uri = sip.Uri{User: binding.Username, Host: host, Port: port}
+	}
+
+	req := sip.NewRequest(sip.OPTIONS, &uri)
+	req.SetDestination(binding.Contact)
+
+	ctx, cancel := context.WithTimeout(context.Background(), as.config.RegisterTimeout)
+	defer cancel()
+
+	tx, err := as.client.TransactionRequest(ctx, req, as.clientMaxForwards, sipgo.ClientRequestBuild)
+	if err != nil {
+		return fmt.Errorf("failed to send OPTIONS: %w", err)
+	}
+	defer tx.Terminate()
+
+	for {
+		select {
+		case res := <-tx.Responses():
+			if res.IsProvisional() {
+				continue
+			}
+			return nil
+		case <-tx.Done():
+			return fmt.Errorf("OPTIONS transaction ended: %w", tx.Err())
+		case <-ctx.Done():
+			return fmt.Errorf("OPTIONS timeout: %w", ctx.Err())
+		}
+	}
+}
+
+// recordProbeResult 记录探测结果，状态变化时记录日志，返回Contact是否刚变为不可达
+func (as *SipServer) recordProbeResult(binding ua.Binding, probeErr error) bool {
+	username := binding.Username
+	as.reachabilityMutex.Lock()
+	states, exists := as.reachability[username]
+	if !exists {
+		states = make(map[string]*ContactReachability)
+		as.reachability[username] = states
+	}
+	state, exists := states[binding.URI]
+	if !exists || state.Contact != binding.Contact {
+		state = &ContactReachability{Username: username, URI: binding.URI, Contact: binding.Contact, Reachable: true}
+		states[binding.URI] = state
+	}
+
+	wasReachable := state.Reachable
+	state.LastProbe = time.Now()
+	if probeErr == nil {
+		state.Failures = 0
+		state.Reachable = true
+		state.LastError = ""
+	} else {
+		state.Failures++
+		state.LastError = probeErr.Error()
+		if state.Failures >= as.config.ProbeFailures {
+			state.Reachable = false
+		}
+	}
+	reachable, failures := state.Reachable, state.Failures
+	as.reachabilityMutex.Unlock()
+
+	if wasReachable != reachable {
+		if reachable {
+			logger.Info("Registered contact reachable again",
+				zap.String("username", username),
+				zap.String("contact", binding.Contact))
+		} else {
+			logger.Warn("Registered contact unreachable",
+				zap.String("username", username),
+				zap.String("contact", binding.Contact),
+				zap.Int("failures", failures),
+				zap.Error(probeErr))
+		}
+		as.presenceChanged(username)
+	}
+	return wasReachable && !reachable
+}
+
+// unbindUnreachable 删除不可达的Contact，用户没有其他Contact时即为注销
+func (as *SipServer) unbindUnreachable(binding ua.Binding) {
+	if err := as.config.SaveRegistration(&ua.RegistrationInfo{Username: binding.Username, ContactStr: binding.URI}); err != nil {
+		logger.Error("Failed to remove unreachable binding",
+			zap.String("username", binding.Username),
+			zap.String("contact", binding.URI),
+			zap.Error(err))
+		return
+	}
+	logger.Info("Unreachable binding removed",
+		zap.String("username", binding.Username),
+		zap.String("contact", binding.URI))
+	if bindings, err := as.config.Bindings(binding.Username); err == nil && len(bindings) == 0 {
+		as.forgetContact(binding.Username)
+	}
+	as.presenceChanged(binding.Username)
+}
+
+// saveUserReachability 持久化用户的可达性：任一Contact可达即可达，失败次数取最少的Contact
+func (as *SipServer) saveUserReachability(username string) {
+	as.reachabilityMutex.RLock()
+	states, exists := as.reachability[username]
+	reachable, failures := !exists || len(states) == 0, -1
+	for _, state := range states {
+		reachable = reachable || state.Reachable
+		if failures < 0 || state.Failures < failures {
+			failures = state.Failures
+		}
+	}
+	failures = max(failures, 0)
+	as.reachabilityMutex.RUnlock()
+	if err := models.UpdateSipUserReachability(as.config.Db, username, reachable, failures); err != nil {
+		logger.Error("Failed to save contact reachability",
+			zap.String("username", username),
+			zap.Error(err))
+	}
+}
+
+// IsUserReachable 用户是否有可达的Contact，尚未探测过的用户视为可达
+func (as *SipServer) IsUserReachable(username string) bool {
+	as.reachabilityMutex.RLock()
+	defer as.reachabilityMutex.RUnlock()
+	states, exists := as.reachability[username]
+	if !exists {
+		return true
+	}
+	for _, state := range states {
+		if state.Reachable {
+			return true
+		}
+	}
+	return len(states) == 0
+}
+
+// ListRegisteredUsers 列出注册用户的每个Contact及其可达性，username为空时列出全部
+func (as *SipServer) ListRegisteredUsers(username string) []ContactReachability {
+	users := as.registeredBindings()
+
+	as.reachabilityMutex.RLock()
+	var list []ContactReachability
+	for user, bindings := range users {
+		if username != "" && user != username {
+			continue
+		}
+		for _, binding := range bindings {
+			item := ContactReachability{Username: user, URI: binding.URI, Contact: binding.Contact, Reachable: true}
+			if state, exists := as.reachability[user][binding.URI]; exists && state.Contact == binding.Contact {
+				item = *state
+			}
+			list = append(list, item)
+		}
+	}
+	as.reachabilityMutex.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Username != list[j].Username {
+			return list[i].Username < list[j].Username
+		}
+		return list[i].URI < list[j].URI
+	})
+	return list
+}
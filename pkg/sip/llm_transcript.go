@@ -0,0 +1,181 @@
+package sip1
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/llm"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+// llmRedactedText content脱敏时替换消息文字
+const llmRedactedText = "[redacted]"
+
+// llmRequestRecord 保存的请求内容
+type llmRequestRecord struct {
+	Messages []openai.ChatCompletionMessage `json:"messages"`
+	Tools    []openai.Tool                  `json:"tools,omitempty"`
+}
+
+// llmRecorder 记录一次对话服务请求，未开启记录时为nil，各方法均可在nil上调用
+type llmRecorder struct {
+	engine    *AIPhoneEngine
+	redaction string
+	prompt    string
+	startedAt time.Time
+
+	mutex   sync.Mutex
+	model   string
+	request []byte
+}
+
+// newLLMRecorder 开启LLM请求记录且会话已保存到数据库时创建记录器
+func (engine *AIPhoneEngine) newLLMRecorder(session *ScriptSession, prompt string) *llmRecorder {
+	if engine.db == nil || engine.server == nil || !engine.server.config.LLMTranscript {
+		return nil
+	}
+	if session.DBSession == nil || session.DBSession.ID == 0 {
+		return nil
+	}
+	return &llmRecorder{
+		engine:    engine,
+		redaction: engine.server.config.LLMTranscriptRedaction,
+		prompt:    prompt,
+		startedAt: time.Now(),
+	}
+}
+
+// observe 返回在发送前记录完整请求的ctx；不经过llm.LLMHandler的对话服务只记录提示词
+func (r *llmRecorder) observe(ctx context.Context) context.Context {
+	if r == nil {
+		return ctx
+	}
+	return llm.WithRequestObserver(ctx, func(request openai.ChatCompletionRequest) {
+		// 观察者在处理器加锁期间调用，消息须复制后再脱敏
+		record := llmRequestRecord{
+			Messages: make([]openai.ChatCompletionMessage, len(request.Messages)),
+			Tools:    request.Tools,
+		}
+		for i, message := range request.Messages {
+			record.Messages[i] = redactLLMMessage(message, r.redaction)
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			logger.Warn("Failed to encode LLM request", zap.Error(err))
+			return
+		}
+		r.mutex.Lock()
+		r.model, r.request = request.Model, data
+		r.mutex.Unlock()
+	})
+}
+
+// save 保存请求、回复、耗时和本轮的工具调用，关联当前步骤执行
+func (r *llmRecorder) save(session *ScriptSession, response string, err, cause error) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	model, request := r.model, r.request
+	r.mutex.Unlock()
+	if request == nil {
+		data, encodeErr := json.Marshal(llmRequestRecord{Messages: []openai.ChatCompletionMessage{
+			redactLLMMessage(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: r.prompt}, r.redaction),
+		}})
+		if encodeErr != nil {
+			logger.Warn("Failed to encode LLM request", zap.Error(encodeErr))
+			return
+		}
+		request = data
+	}
+
+	session.mutex.RLock()
+	executionID := session.executionID
+	action := session.assistantAction
+	var stepID string
+	if session.CurrentStep != nil {
+		stepID = session.CurrentStep.StepID
+	}
+	session.mutex.RUnlock()
+
+	exchange := &models.LLMExchange{
+		SessionID:       session.DBSession.ID,
+		StepExecutionID: executionID,
+		CallID:          session.CallID,
+		StepID:          stepID,
+		Model:           model,
+		Redaction:       r.redaction,
+		Request:         string(request),
+		Response:        redactLLMText(response, r.redaction),
+		Latency:         int(time.Since(r.startedAt).Milliseconds()),
+	}
+	if action != nil {
+		if action.hangup {
+			exchange.ToolCall = "hangup: " + redactLLMText(action.reason, r.redaction)
+		} else {
+			exchange.ToolCall = "refer: " + redactLLMTarget(action.referTo, r.redaction)
+		}
+	}
+	switch {
+	case cause != nil:
+		exchange.Error = cause.Error()
+	case err != nil:
+		exchange.Error = err.Error()
+	}
+	if err := models.CreateLLMExchange(r.engine.db, exchange); err != nil {
+		logger.Error("Failed to save LLM exchange", zap.String("call_id", session.CallID), zap.Error(err))
+	}
+}
+
+// redactLLMMessage 按脱敏方式处理一条消息：numbers遮盖号码，content替换文字；角色和工具调用名保留
+func redactLLMMessage(message openai.ChatCompletionMessage, redaction string) openai.ChatCompletionMessage {
+	if redaction == "" {
+		return message
+	}
+	message.Content = redactLLMText(message.Content, redaction)
+	if len(message.MultiContent) > 0 {
+		parts := make([]openai.ChatMessagePart, len(message.MultiContent))
+		for i, part := range message.MultiContent {
+			part.Text = redactLLMText(part.Text, redaction)
+			part.ImageURL = nil
+			parts[i] = part
+		}
+		message.MultiContent = parts
+	}
+	if len(message.ToolCalls) > 0 {
+		calls := make([]openai.ToolCall, len(message.ToolCalls))
+		for i, call := range message.ToolCalls {
+			call.Function.Arguments = redactLLMTarget(call.Function.Arguments, redaction)
+			calls[i] = call
+		}
+		message.ToolCalls = calls
+	}
+	return message
+}
+
+// redactLLMText 按脱敏方式处理消息文字或回复
+func redactLLMText(text, redaction string) string {
+	switch {
+	case text == "":
+		return text
+	case redaction == ua.RedactContent:
+		return llmRedactedText
+	case redaction == ua.RedactNumbers:
+		return logger.MaskNumbers(text)
+	}
+	return text
+}
+
+// redactLLMTarget 处理工具调用参数和转接目标：两种脱敏方式都只遮盖号码，保留调用的结构
+func redactLLMTarget(text, redaction string) string {
+	if redaction == "" {
+		return text
+	}
+	return logger.MaskNumbers(text)
+}
@@ -0,0 +1,43 @@
+package sip1
+
+import (
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterLogLevelAPIs 注册日志级别接口：GET /log-levels 查看全局和组件级别，
+// PUT /log-levels {"component":"sip","level":"debug"} 修改组件级别（component为空时修改全局级别，level为空时恢复全局级别），
+// DELETE /log-levels 清除所有组件级别
+func RegisterLogLevelAPIs(r gin.IRoutes) {
+	r.GET("/log-levels", func(c *gin.Context) {
+		response.Success(c, "ok", logger.Levels())
+	})
+
+	r.PUT("/log-levels", func(c *gin.Context) {
+		var form struct {
+			Component string `json:"component"`
+			Level     string `json:"level"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		var err error
+		if form.Component == "" {
+			err = logger.SetLevel(form.Level)
+		} else {
+			err = logger.SetComponentLevel(form.Component, form.Level)
+		}
+		if err != nil {
+			response.Fail(c, "failed to set log level", err.Error())
+			return
+		}
+		response.Success(c, "ok", logger.Levels())
+	})
+
+	r.DELETE("/log-levels", func(c *gin.Context) {
+		logger.ResetComponentLevels()
+		response.Success(c, "ok", logger.Levels())
+	})
+}
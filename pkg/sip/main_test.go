@@ -0,0 +1,14 @@
+package sip1
+
+import (
+	"os"
+	"testing"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	logger.Lg = zap.NewNop()
+	os.Exit(m.Run())
+}
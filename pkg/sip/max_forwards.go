@@ -0,0 +1,116 @@
+package sip1
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+)
+
+// loopHashLen branch中环路校验值的长度（十六进制字符数）
+const loopHashLen = 16
+
+// checkHops 校验Max-Forwards并按Via检测环路（RFC 3261 16.3），通过时返回0。
+// Max-Forwards为0的OPTIONS由本机直接应答，不视为超限。
+// 带本机sent-by的Via只说明请求经过本机，换了Request-URI的螺旋路由和分叉请求都是合法的，
+// 只有branch中的校验值与按本请求重新计算的一致时才是环路
+func (as *SipServer) checkHops(req *sip.Request) (sip.StatusCode, string) {
+	if maxForwards := req.MaxForwards(); maxForwards != nil && maxForwards.Val() == 0 && req.Method != sip.OPTIONS {
+		return sip.StatusTooManyHops, "Too Many Hops"
+	}
+
+	local := as.localSentBy()
+	prefix := loopBranchPrefix(req)
+	for _, h := range req.GetHeaders("Via") {
+		via, ok := h.(*sip.ViaHeader)
+		if !ok {
+			continue
+		}
+		port := via.Port
+		if port == 0 {
+			port = 5060
+		}
+		if port != as.config.Port || !local[strings.ToLower(strings.Trim(via.Host, "[]"))] {
+			continue
+		}
+		if branch, _ := via.Params.Get("branch"); strings.HasPrefix(branch, prefix) {
+			return sip.StatusLoopDetected, "Loop Detected"
+		}
+	}
+	return 0, ""
+}
+
+// localSentBy 本机可能出现在Via中的地址：配置的Host和各网卡地址
+func (as *SipServer) localSentBy() map[string]bool {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	if as.localHosts != nil {
+		return as.localHosts
+	}
+
+	hosts := make(map[string]bool)
+	if host := as.config.Host; host != "" && host != "0.0.0.0" && host != "::" {
+		hosts[strings.ToLower(host)] = true
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				hosts[ipNet.IP.String()] = true
+			}
+		}
+	}
+	as.localHosts = hosts
+	return hosts
+}
+
+// loopBranchPrefix 本机发出该请求时branch的前缀：magic cookie加环路校验值（RFC 3261 16.6 第8项）。
+// 校验值取To/From tag、Call-ID、CSeq序号、Request-URI和Proxy-Require/Proxy-Authorization；
+// 顶部Via和Route每一跳都会变化，不参与计算，否则绕回的请求永远算不出相同的值
+func loopBranchPrefix(req *sip.Request) string {
+	hash := sha256.New()
+	write := func(value string) {
+		hash.Write([]byte(value))
+		hash.Write([]byte{0})
+	}
+	if to := req.To(); to != nil {
+		tag, _ := to.Params.Get("tag")
+		write(tag)
+	}
+	if from := req.From(); from != nil {
+		tag, _ := from.Params.Get("tag")
+		write(tag)
+	}
+	if callID := req.CallID(); callID != nil {
+		write(callID.Value())
+	}
+	if cseq := req.CSeq(); cseq != nil {
+		write(strconv.FormatUint(uint64(cseq.SeqNo), 10))
+	}
+	write(req.Recipient.String())
+	for _, name := range []string{"Proxy-Require", "Proxy-Authorization"} {
+		for _, h := range req.GetHeaders(name) {
+			write(h.Value())
+		}
+	}
+	return sip.RFC3261BranchMagicCookie + hex.EncodeToString(hash.Sum(nil))[:loopHashLen] + "."
+}
+
+// clientMaxForwards 本机发起的请求使用配置的Max-Forwards，并加上带环路校验值branch的Via，
+// 需放在ClientRequestBuild之前。已带Via的请求（如CANCEL）沿用原branch
+func (as *SipServer) clientMaxForwards(c *sipgo.Client, req *sip.Request) error {
+	if req.MaxForwards() == nil && as.config.MaxForwards > 0 {
+		maxForwards := sip.MaxForwardsHeader(as.config.MaxForwards)
+		req.AppendHeader(&maxForwards)
+	}
+	if req.Via() == nil {
+		if err := sipgo.ClientRequestAddVia(c, req); err != nil {
+			return err
+		}
+		req.Via().Params.Add("branch", loopBranchPrefix(req)+sip.RandString(8))
+	}
+	return nil
+}
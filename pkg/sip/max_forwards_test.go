@@ -0,0 +1,103 @@
+package sip1
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+)
+
+const loopTestHost = "192.0.2.10"
+
+// newLoopTestClient 以本机地址发送请求的客户端，Via的sent-by为loopTestHost
+func newLoopTestClient(t *testing.T, as *SipServer) *sipgo.Client {
+	t.Helper()
+	agent, err := sipgo.NewUA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { agent.Close() })
+	client, err := sipgo.NewClient(agent, sipgo.WithClientHostname(loopTestHost), sipgo.WithClientPort(as.config.Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// sendAndReturn 模拟本机发出的请求经上游转发后又回到本机：加上本机Via再加上游的Via
+func sendAndReturn(t *testing.T, as *SipServer, client *sipgo.Client, req *sip.Request) {
+	t.Helper()
+	req.RemoveHeader("Via")
+	if err := as.clientMaxForwards(client, req); err != nil {
+		t.Fatal(err)
+	}
+	req.PrependHeader(&sip.ViaHeader{ProtocolName: "SIP", ProtocolVersion: "2.0", Transport: "UDP", Host: "198.51.100.7", Port: 5060, Params: sip.HeaderParams{"branch": sip.GenerateBranch()}})
+}
+
+func TestCheckHops(t *testing.T) {
+	as := newGuardTestServer()
+	as.localHosts[loopTestHost] = true
+	client := newLoopTestClient(t, as)
+
+	t.Run("looped request", func(t *testing.T) {
+		req := newTestRequest(t, sip.INVITE, "198.51.100.7:5060", "")
+		sendAndReturn(t, as, client, req)
+		if status, _ := as.checkHops(req); status != sip.StatusLoopDetected {
+			t.Errorf("checkHops() = %d, want 482", status)
+		}
+	})
+
+	t.Run("spiral with a new Request-URI", func(t *testing.T) {
+		req := newTestRequest(t, sip.INVITE, "198.51.100.7:5060", "")
+		sendAndReturn(t, as, client, req)
+		req.Recipient = &sip.Uri{User: "2000", Host: "example.com"}
+		if status, _ := as.checkHops(req); status != 0 {
+			t.Errorf("checkHops() = %d for a spiral, want 0", status)
+		}
+	})
+
+	t.Run("local sent-by with a foreign branch", func(t *testing.T) {
+		req := newTestRequest(t, sip.INVITE, "198.51.100.7:5060", "")
+		req.Via().Host, req.Via().Port = loopTestHost, as.config.Port
+		if status, _ := as.checkHops(req); status != 0 {
+			t.Errorf("checkHops() = %d, want 0", status)
+		}
+	})
+
+	t.Run("Max-Forwards exhausted", func(t *testing.T) {
+		req := newTestRequest(t, sip.INVITE, "198.51.100.7:5060", "")
+		maxForwards := sip.MaxForwardsHeader(0)
+		req.ReplaceHeader(&maxForwards)
+		if status, _ := as.checkHops(req); status != sip.StatusTooManyHops {
+			t.Errorf("checkHops() = %d, want 483", status)
+		}
+		req.Method = sip.OPTIONS
+		if status, _ := as.checkHops(req); status != 0 {
+			t.Errorf("checkHops() = %d for OPTIONS, want 0", status)
+		}
+	})
+}
+
+func TestClientMaxForwardsKeepsVia(t *testing.T) {
+	as := newGuardTestServer()
+	client := newLoopTestClient(t, as)
+
+	req := newTestRequest(t, sip.CANCEL, "198.51.100.7:5060", "")
+	branch, _ := req.Via().Params.Get("branch")
+	if err := as.clientMaxForwards(client, req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.GetHeaders("Via")) != 1 {
+		t.Fatalf("Via count = %d, want 1", len(req.GetHeaders("Via")))
+	}
+	if got, _ := req.Via().Params.Get("branch"); got != branch {
+		t.Errorf("branch = %q, want %q kept", got, branch)
+	}
+
+	req.RemoveHeader("Via")
+	as.clientMaxForwards(client, req)
+	if got, _ := req.Via().Params.Get("branch"); !strings.HasPrefix(got, loopBranchPrefix(req)) || len(got) <= len(loopBranchPrefix(req)) {
+		t.Errorf("branch = %q, want prefix %q and a unique suffix", got, loopBranchPrefix(req))
+	}
+}
@@ -0,0 +1,280 @@
+package sip1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// messageContentType 发出的MESSAGE的内容类型
+	messageContentType = "text/plain;charset=UTF-8"
+	// messageHistoryLimit 自动回复时带入的最近往来短信条数
+	messageHistoryLimit = 10
+	// messageReplyTimeout 自动回复生成和发送的最长时间
+	messageReplyTimeout = 30 * time.Second
+
+	messageSourceAPI = "api"
+	messageSourceLLM = "llm"
+)
+
+// messageTarget MESSAGE的请求地址和实际发送地址
+type messageTarget struct {
+	uri         sip.Uri
+	destination string
+}
+
+// handleMessage 处理MESSAGE（RFC 3428）：回复200后保存，开启MessageAutoReply时由LLM生成回复
+func (as *SipServer) handleMessage(req *sip.Request, tx sip.ServerTransaction) {
+	message := &models.SipMessage{
+		CallID:       req.CallID().Value(),
+		Direction:    models.SipMessageInbound,
+		FromUsername: req.From().Address.User,
+		ToUsername:   req.To().Address.User,
+		Body:         string(req.Body()),
+		Status:       models.SipMessageReceived,
+		RemoteAddr:   req.Source(),
+	}
+	if contentType := req.ContentType(); contentType != nil {
+		message.ContentType = contentType.Value()
+	}
+
+	if err := tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)); err != nil {
+		logger.Error("Failed to respond to MESSAGE", zap.String("call_id", message.CallID), zap.Error(err))
+	}
+
+	if as.config.Db != nil {
+		if err := models.CreateSipMessage(as.config.Db, message); err != nil {
+			logger.Error("Failed to save MESSAGE", zap.String("call_id", message.CallID), zap.Error(err))
+		}
+	}
+	logger.Info("MESSAGE received",
+		zap.String("call_id", message.CallID),
+		zap.String("from", message.FromUsername),
+		zap.String("to", message.ToUsername),
+		zap.Int("size", len(message.Body)))
+
+	if as.config.MessageAutoReply && strings.TrimSpace(message.Body) != "" && message.FromUsername != "" {
+		// 发送方未注册时（如来自中继）回复到请求的来源地址
+		fallback := messageTarget{uri: req.From().Address, destination: req.Source()}
+		fallback.uri.UriParams = nil
+		go as.replyMessage(message, fallback, getServerIPFromRequest(req))
+	}
+}
+
+// replyMessage 把最近往来的短信拼成对话交给LLM，回复发回发送方
+func (as *SipServer) replyMessage(received *models.SipMessage, fallback messageTarget, serverIP string) {
+	if as.aiEngine == nil || as.aiEngine.services().Assistant == nil {
+		logger.Warn("MESSAGE auto reply skipped, no LLM service", zap.String("call_id", received.CallID))
+		return
+	}
+	history := []models.SipMessage{*received}
+	if as.config.Db != nil {
+		if recent, err := models.RecentSipMessages(as.config.Db, received.FromUsername, messageHistoryLimit); err == nil && len(recent) > 0 {
+			history = recent
+		}
+	}
+
+	text, err := as.aiEngine.services().Assistant.Query(buildMessagePrompt(history, received.FromUsername))
+	if err != nil {
+		logger.Error("MESSAGE auto reply failed", zap.String("call_id", received.CallID), zap.Error(err))
+		return
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), messageReplyTimeout)
+	defer cancel()
+	reply := &models.SipMessage{
+		FromUsername: received.ToUsername,
+		ToUsername:   received.FromUsername,
+		Body:         text,
+		ReplyToID:    received.ID,
+		Source:       messageSourceLLM,
+	}
+	targets, err := as.messageTargets(received.FromUsername)
+	if err != nil || len(targets) == 0 {
+		targets = []messageTarget{fallback}
+	}
+	if err := as.deliverMessage(ctx, reply, targets, serverIP); err != nil {
+		logger.Warn("Failed to send MESSAGE auto reply", zap.String("to", reply.ToUsername), zap.Error(err))
+	}
+}
+
+// buildMessagePrompt 按时间顺序列出往来短信，要求LLM以短信口吻回复最后一条
+func buildMessagePrompt(history []models.SipMessage, username string) string {
+	var prompt strings.Builder
+	prompt.WriteString("以下是与用户的短信往来，请用简短的一两句话回复用户的最后一条短信，不要使用Markdown格式。\n\n")
+	for _, message := range history {
+		if message.Direction == models.SipMessageInbound && message.FromUsername == username {
+			prompt.WriteString("用户：")
+		} else {
+			prompt.WriteString("客服：")
+		}
+		prompt.WriteString(strings.TrimSpace(message.Body))
+		prompt.WriteString("\n")
+	}
+	prompt.WriteString("客服：")
+	return prompt.String()
+}
+
+// SendMessage 向已注册用户发送MESSAGE，按q值从高到低依次尝试联系地址，直到一个返回2xx；
+// 配置了数据库时保存发送记录
+func (as *SipServer) SendMessage(ctx context.Context, username, body string) (*models.SipMessage, error) {
+	targets, err := as.messageTargets(username)
+	if err != nil {
+		return nil, err
+	}
+	localIP := getLocalIP()
+	if localIP == "" {
+		localIP = "127.0.0.1"
+	}
+	message := &models.SipMessage{
+		FromUsername: as.config.MessageFrom,
+		ToUsername:   username,
+		Body:         body,
+		Source:       messageSourceAPI,
+	}
+	return message, as.deliverMessage(ctx, message, targets, localIP)
+}
+
+// messageTargets 用户已注册的联系地址
+func (as *SipServer) messageTargets(username string) ([]messageTarget, error) {
+	bindings, err := as.config.Bindings(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up bindings of %s: %w", username, err)
+	}
+	if len(bindings) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoBindings, username)
+	}
+	targets := make([]messageTarget, 0, len(bindings))
+	for _, binding := range bindings {
+		var uri sip.Uri
+		if err := parseURI(binding.URI, &uri); err != nil {
+			logger.Warn("Invalid binding URI", zap.String("username", username), zap.String("uri", binding.URI), zap.Error(err))
+			continue
+		}
+		targets = append(targets, messageTarget{uri: uri, destination: binding.Contact})
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoBindings, username)
+	}
+	return targets, nil
+}
+
+// deliverMessage 依次向targets发送，记录最后一次的响应码或错误
+func (as *SipServer) deliverMessage(ctx context.Context, message *models.SipMessage, targets []messageTarget, serverIP string) error {
+	if message.FromUsername == "" {
+		message.FromUsername = as.config.MessageFrom
+	}
+	message.CallID = uuid.NewString()
+	message.Direction = models.SipMessageOutbound
+	message.ContentType = messageContentType
+	message.Status = models.SipMessageSending
+	as.saveMessage(message, true)
+
+	var lastErr error
+	for _, target := range targets {
+		message.RemoteAddr = target.destination
+		code, err := as.sendMessageRequest(ctx, message, target, serverIP)
+		message.StatusCode = code
+		if err == nil {
+			lastErr = nil
+			break
+		}
+		lastErr = err
+		logger.Warn("MESSAGE delivery failed",
+			zap.String("call_id", message.CallID),
+			zap.String("to", message.ToUsername),
+			zap.String("destination", target.destination),
+			zap.Error(err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if lastErr != nil {
+		message.Status = models.SipMessageFailed
+		message.Error = lastErr.Error()
+	} else {
+		message.Status = models.SipMessageSent
+		message.Error = ""
+		logger.Info("MESSAGE sent",
+			zap.String("call_id", message.CallID),
+			zap.String("to", message.ToUsername),
+			zap.String("destination", message.RemoteAddr))
+	}
+	as.saveMessage(message, false)
+	return lastErr
+}
+
+// saveMessage 保存或更新短信记录，未配置数据库时忽略
+func (as *SipServer) saveMessage(message *models.SipMessage, create bool) {
+	if as.config.Db == nil {
+		return
+	}
+	var err error
+	if create {
+		err = models.CreateSipMessage(as.config.Db, message)
+	} else {
+		err = models.UpdateSipMessage(as.config.Db, message)
+	}
+	if err != nil {
+		logger.Error("Failed to save MESSAGE", zap.String("call_id", message.CallID), zap.Error(err))
+	}
+}
+
+// sendMessageRequest 发送一次MESSAGE并等待最终响应，返回响应码，非2xx返回错误
+func (as *SipServer) sendMessageRequest(ctx context.Context, message *models.SipMessage, target messageTarget, serverIP string) (int, error) {
+	recipient := target.uri
+	req := sip.NewRequest(sip.MESSAGE, &recipient)
+	req.SetDestination(target.destination)
+
+	from := &sip.FromHeader{Address: sip.Uri{User: message.FromUsername, Host: uriHost(serverIP), Port: as.config.Port}, Params: sip.NewParams()}
+	from.Params.Add("tag", sip.GenerateTagN(16))
+	to := &sip.ToHeader{Address: sip.Uri{User: message.ToUsername, Host: recipient.Host, Port: recipient.Port}, Params: sip.NewParams()}
+	callID := sip.CallIDHeader(message.CallID)
+	contentType := sip.ContentTypeHeader(messageContentType)
+	req.AppendHeader(from)
+	req.AppendHeader(to)
+	req.AppendHeader(&callID)
+	req.AppendHeader(&sip.CSeqHeader{SeqNo: 1, MethodName: sip.MESSAGE})
+	req.AppendHeader(&contentType)
+	req.SetBody([]byte(message.Body))
+
+	txCtx, cancel := context.WithTimeout(ctx, as.config.TransactionTimeout)
+	defer cancel()
+
+	tx, err := as.client.TransactionRequest(txCtx, req, as.clientMaxForwards, sipgo.ClientRequestBuild)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send MESSAGE: %w", err)
+	}
+	defer tx.Terminate()
+
+	for {
+		select {
+		case res := <-tx.Responses():
+			if res.IsProvisional() {
+				continue
+			}
+			if !res.IsSuccess() {
+				return int(res.StatusCode), fmt.Errorf("MESSAGE rejected: %d %s", res.StatusCode, res.Reason)
+			}
+			return int(res.StatusCode), nil
+		case <-tx.Done():
+			return 0, fmt.Errorf("MESSAGE transaction ended: %w", tx.Err())
+		case <-txCtx.Done():
+			return 0, fmt.Errorf("MESSAGE timeout: %w", txCtx.Err())
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package sip1
+
+import (
+	"strconv"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterMessageAPIs 注册SIP短信接口：POST /messages {"to":"alice","body":"..."} 向已注册用户发送MESSAGE，
+// 可用于通话后的文字跟进；GET /messages?user=alice&offset=0&limit=20 按时间倒序查看收发记录
+func RegisterMessageAPIs(r gin.IRoutes, server *SipServer) {
+	r.POST("/messages", func(c *gin.Context) {
+		var form struct {
+			To   string `json:"to" binding:"required"`
+			Body string `json:"body" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		message, err := server.SendMessage(c.Request.Context(), form.To, form.Body)
+		if err != nil {
+			response.Fail(c, "failed to send message", err.Error())
+			return
+		}
+		response.Success(c, "ok", message)
+	})
+
+	r.GET("/messages", func(c *gin.Context) {
+		if server.config.Db == nil {
+			response.Fail(c, "database not configured", nil)
+			return
+		}
+		offset, _ := strconv.Atoi(c.Query("offset"))
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		if limit <= 0 {
+			limit = sipUserPageSize
+		}
+		messages, total, err := models.ListSipMessages(server.config.Db, c.Query("user"), max(offset, 0), min(limit, maxSipUserPageSize))
+		if err != nil {
+			response.Fail(c, "failed to list messages", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"items": messages, "total": total})
+	})
+}
@@ -0,0 +1,217 @@
+package sip1
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+// Middleware 包装SIP请求处理器，与HTTP中间件一样在next前后处理横切逻辑，不调用next即拦截请求
+type Middleware func(next sipgo.RequestHandler) sipgo.RequestHandler
+
+// middlewareChain 全局中间件和按方法配置的中间件，组合好的处理器按方法缓存，修改中间件时重建
+type middlewareChain struct {
+	mutex    sync.RWMutex
+	global   []Middleware
+	byMethod map[sip.RequestMethod][]Middleware
+	handlers map[sip.RequestMethod]sipgo.RequestHandler // 方法 -> 业务处理器
+	built    map[sip.RequestMethod]sipgo.RequestHandler
+}
+
+func newMiddlewareChain() *middlewareChain {
+	return &middlewareChain{
+		byMethod: make(map[sip.RequestMethod][]Middleware),
+		handlers: make(map[sip.RequestMethod]sipgo.RequestHandler),
+		built:    make(map[sip.RequestMethod]sipgo.RequestHandler),
+	}
+}
+
+// Use 为所有方法追加中间件，先添加的在外层；运行中调用对之后的请求生效
+func (as *SipServer) Use(middleware ...Middleware) {
+	m := as.middleware
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.global = append(m.global, middleware...)
+	m.built = make(map[sip.RequestMethod]sipgo.RequestHandler)
+}
+
+// UseFor 为指定方法追加中间件，在全局中间件之内执行
+func (as *SipServer) UseFor(method sip.RequestMethod, middleware ...Middleware) {
+	m := as.middleware
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.byMethod[method] = append(m.byMethod[method], middleware...)
+	delete(m.built, method)
+}
+
+// handle 返回经过中间件链的处理器，用于注册到sipgo
+func (as *SipServer) handle(method sip.RequestMethod, handler sipgo.RequestHandler) sipgo.RequestHandler {
+	m := as.middleware
+	m.mutex.Lock()
+	m.handlers[method] = handler
+	delete(m.built, method)
+	m.mutex.Unlock()
+
+	return func(req *sip.Request, tx sip.ServerTransaction) {
+		m.handler(method)(req, tx)
+	}
+}
+
+func (m *middlewareChain) handler(method sip.RequestMethod) sipgo.RequestHandler {
+	m.mutex.RLock()
+	handler, ok := m.built[method]
+	m.mutex.RUnlock()
+	if ok {
+		return handler
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if handler, ok := m.built[method]; ok {
+		return handler
+	}
+	handler = m.handlers[method]
+	chain := append(append([]Middleware{}, m.global...), m.byMethod[method]...)
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i](handler)
+	}
+	m.built[method] = handler
+	return handler
+}
+
+// useDefaultMiddleware 内置中间件：NAT来源标记、统计、请求校验、日志，REGISTER和INVITE先按访问控制规则过滤并按来源IP限流，
+// INVITE再校验来源、负载和并发限制
+func (as *SipServer) useDefaultMiddleware() {
+	as.Use(unmaskRecipient, markReceived, as.countRequests, as.guardRequest, logRequests)
+	as.UseFor(sip.REGISTER, as.enforceAccessRules, as.limitRequestRate)
+	as.UseFor(sip.INVITE, as.enforceAccessRules, as.limitRequestRate, as.authorizeSources, as.shedLoad, as.enforceCallQuotas)
+}
+
+// logRequests 记录收到的请求，OPTIONS探测较频繁只在debug级别记录
+func logRequests(next sipgo.RequestHandler) sipgo.RequestHandler {
+	return func(req *sip.Request, tx sip.ServerTransaction) {
+		log := logger.Info
+		if req.Method == sip.OPTIONS {
+			log = logger.Debug
+		}
+		log("Received SIP request",
+			zap.String("method", string(req.Method)),
+			zap.String("request_uri", req.Recipient.String()),
+			zap.String("call_id", req.CallID().Value()),
+			zap.String("source", req.Source()))
+		next(req, tx)
+	}
+}
+
+// authorizeSources 拒绝未知来源的INVITE，见authorizeInviteSource
+func (as *SipServer) authorizeSources(next sipgo.RequestHandler) sipgo.RequestHandler {
+	return func(req *sip.Request, tx sip.ServerTransaction) {
+		if err := as.authorizeInviteSource(req); err != nil {
+			logger.Warn("Rejecting INVITE from unknown source",
+				zap.String("call_id", req.CallID().Value()),
+				zap.String("source", req.Source()))
+			tx.Respond(sip.NewResponseFromRequest(req, sip.StatusForbidden, "Forbidden", nil))
+			return
+		}
+		next(req, tx)
+	}
+}
+
+// SIPRequestStats 按方法统计的请求数、响应码和处理耗时
+type SIPRequestStats struct {
+	Method        string        `json:"method"`
+	Count         int64         `json:"count"`
+	Responses     map[int]int64 `json:"responses"` // 状态码 -> 次数，包括处理器返回后异步发送的响应
+	TotalDuration time.Duration `json:"totalDuration"`
+	MaxDuration   time.Duration `json:"maxDuration"`
+}
+
+// AvgDuration 平均处理耗时
+func (s SIPRequestStats) AvgDuration() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.TotalDuration / time.Duration(s.Count)
+}
+
+type requestMetrics struct {
+	mutex sync.Mutex
+	stats map[sip.RequestMethod]*SIPRequestStats
+}
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{stats: make(map[sip.RequestMethod]*SIPRequestStats)}
+}
+
+func (m *requestMetrics) get(method sip.RequestMethod) *SIPRequestStats {
+	stats, ok := m.stats[method]
+	if !ok {
+		stats = &SIPRequestStats{Method: string(method), Responses: make(map[int]int64)}
+		m.stats[method] = stats
+	}
+	return stats
+}
+
+func (m *requestMetrics) observe(method sip.RequestMethod, elapsed time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stats := m.get(method)
+	stats.Count++
+	stats.TotalDuration += elapsed
+	if elapsed > stats.MaxDuration {
+		stats.MaxDuration = elapsed
+	}
+}
+
+func (m *requestMetrics) response(method sip.RequestMethod, status sip.StatusCode) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.get(method).Responses[int(status)]++
+}
+
+// metricsTransaction 记录事务上发送的响应码
+type metricsTransaction struct {
+	sip.ServerTransaction
+	method  sip.RequestMethod
+	metrics *requestMetrics
+}
+
+func (tx *metricsTransaction) Respond(res *sip.Response) error {
+	tx.metrics.response(tx.method, res.StatusCode)
+	return tx.ServerTransaction.Respond(res)
+}
+
+// countRequests 统计每个方法的请求数、响应码和处理耗时
+func (as *SipServer) countRequests(next sipgo.RequestHandler) sipgo.RequestHandler {
+	return func(req *sip.Request, tx sip.ServerTransaction) {
+		start := time.Now()
+		defer func() { as.metrics.observe(req.Method, time.Since(start)) }()
+		if tx != nil {
+			tx = &metricsTransaction{ServerTransaction: tx, method: req.Method, metrics: as.metrics}
+		}
+		next(req, tx)
+	}
+}
+
+// RequestStats 返回各方法的请求统计，按方法名排序
+func (as *SipServer) RequestStats() []SIPRequestStats {
+	m := as.metrics
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := make([]SIPRequestStats, 0, len(m.stats))
+	for _, stats := range m.stats {
+		s := *stats
+		s.Responses = make(map[int]int64, len(stats.Responses))
+		for status, count := range stats.Responses {
+			s.Responses[status] = count
+		}
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Method < result[j].Method })
+	return result
+}
@@ -0,0 +1,262 @@
+package sip1
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// MonitorEventType 监控事件类型
+type MonitorEventType string
+
+const (
+	MonitorCallStatus     MonitorEventType = "call_status"     // SIP通话状态变化
+	MonitorSessionStarted MonitorEventType = "session_started" // 脚本会话开始
+	MonitorSessionState   MonitorEventType = "session_state"   // 连接时推送的活跃会话快照
+	MonitorStepChanged    MonitorEventType = "step_changed"    // 进入新步骤
+	MonitorASRPartial     MonitorEventType = "asr_partial"     // 识别中间结果
+	MonitorMessage        MonitorEventType = "message"         // 对话消息，Role为user/assistant/system
+	MonitorSessionHeld    MonitorEventType = "session_held"    // 对端保持通话，脚本暂停
+	MonitorSessionResumed MonitorEventType = "session_resumed" // 对端恢复通话，脚本继续
+	MonitorSessionEnded   MonitorEventType = "session_ended"   // 脚本会话结束
+	MonitorSessionSummary MonitorEventType = "session_summary" // 会话结束后生成的摘要，Text为摘要
+)
+
+const (
+	// monitorBufferSize 每个订阅者的事件缓冲，写满后丢弃，不阻塞通话
+	monitorBufferSize = 256
+	// monitorPingInterval WebSocket心跳间隔
+	monitorPingInterval = 30 * time.Second
+	// monitorWriteTimeout 单次写入超时
+	monitorWriteTimeout = 10 * time.Second
+)
+
+// MonitorEvent 推送给监控端的事件
+type MonitorEvent struct {
+	Type      MonitorEventType `json:"type"`
+	CallID    string           `json:"callId"`
+	SessionID string           `json:"sessionId,omitempty"`
+	Status    string           `json:"status,omitempty"`
+	StepID    string           `json:"stepId,omitempty"`
+	StepName  string           `json:"stepName,omitempty"`
+	Role      string           `json:"role,omitempty"`
+	Text      string           `json:"text,omitempty"`
+	Items     []string         `json:"items,omitempty"` // 摘要的待办事项
+	Timestamp time.Time        `json:"timestamp"`
+}
+
+// CallMonitor 通话实时监控，向订阅者广播通话状态、步骤、识别中间结果和AI回复。
+// 作为http.Handler时把事件以JSON推送到WebSocket，?call_id=只接收指定通话
+type CallMonitor struct {
+	mutex       sync.RWMutex
+	subscribers map[chan MonitorEvent]struct{}
+	snapshot    func() []MonitorEvent
+
+	// AllowedOrigins 允许跨域连接的Origin，为空时只允许同源
+	AllowedOrigins []string
+}
+
+// NewCallMonitor 创建通话监控
+func NewCallMonitor() *CallMonitor {
+	return &CallMonitor{subscribers: make(map[chan MonitorEvent]struct{})}
+}
+
+// Subscribe 订阅事件，返回事件通道和取消函数
+func (m *CallMonitor) Subscribe() (<-chan MonitorEvent, func()) {
+	ch := make(chan MonitorEvent, monitorBufferSize)
+	m.mutex.Lock()
+	m.subscribers[ch] = struct{}{}
+	m.mutex.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			m.mutex.Lock()
+			delete(m.subscribers, ch)
+			m.mutex.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish 广播事件，不阻塞；m为nil时忽略
+func (m *CallMonitor) Publish(event MonitorEvent) {
+	if m == nil {
+		return
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for ch := range m.subscribers {
+		select {
+		case ch <- event:
+		default:
+			logger.Debug("Monitor subscriber too slow, dropping event",
+				zap.String("call_id", event.CallID),
+				zap.String("type", string(event.Type)))
+		}
+	}
+}
+
+// Snapshot 返回当前活跃会话的状态
+func (m *CallMonitor) Snapshot() []MonitorEvent {
+	m.mutex.RLock()
+	snapshot := m.snapshot
+	m.mutex.RUnlock()
+	if snapshot == nil {
+		return nil
+	}
+	return snapshot()
+}
+
+func (m *CallMonitor) setSnapshot(snapshot func() []MonitorEvent) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.snapshot = snapshot
+}
+
+// checkOrigin 校验WebSocket握手的Origin
+func (m *CallMonitor) checkOrigin(r *http.Request) bool {
+	return originAllowed(m.AllowedOrigins, r)
+}
+
+// originAllowed 请求没有Origin、Origin在允许列表中或与Host同源时放行
+func originAllowed(allowed []string, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if slices.Contains(allowed, "*") || slices.Contains(allowed, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// ServeHTTP 升级为WebSocket，先推送活跃会话快照，再持续推送事件
+func (m *CallMonitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: m.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Warn("Monitor websocket upgrade failed", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	callID := r.URL.Query().Get("call_id")
+	events, cancel := m.Subscribe()
+	defer cancel()
+
+	// 读取客户端消息只为感知断开
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(v any) error {
+		conn.SetWriteDeadline(time.Now().Add(monitorWriteTimeout))
+		return conn.WriteJSON(v)
+	}
+
+	for _, event := range m.Snapshot() {
+		if callID != "" && event.CallID != callID {
+			continue
+		}
+		if err := write(event); err != nil {
+			return
+		}
+	}
+
+	ping := time.NewTicker(monitorPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case event := <-events:
+			if callID != "" && event.CallID != callID {
+				continue
+			}
+			if err := write(event); err != nil {
+				logger.Debug("Monitor websocket write failed", zap.Error(err))
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(monitorWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// Monitor 返回通话实时监控
+func (as *SipServer) Monitor() *CallMonitor {
+	return as.monitor
+}
+
+// monitorSnapshot 活跃会话的当前状态
+func (engine *AIPhoneEngine) monitorSnapshot() []MonitorEvent {
+	engine.mutex.RLock()
+	sessions := make([]*ScriptSession, 0, len(engine.sessions))
+	for _, session := range engine.sessions {
+		sessions = append(sessions, session)
+	}
+	engine.mutex.RUnlock()
+
+	events := make([]MonitorEvent, 0, len(sessions))
+	for _, session := range sessions {
+		event := session.monitorEvent(MonitorSessionState)
+		event.Status = string(session.GetStatus())
+		events = append(events, event)
+	}
+	return events
+}
+
+// monitorEvent 创建带会话和当前步骤信息的事件
+func (session *ScriptSession) monitorEvent(eventType MonitorEventType) MonitorEvent {
+	event := MonitorEvent{
+		Type:      eventType,
+		CallID:    session.CallID,
+		SessionID: session.SessionID,
+		Timestamp: time.Now(),
+	}
+	if step := session.currentStep(); step != nil {
+		event.StepID = step.StepID
+		event.StepName = step.Name
+	}
+	return event
+}
+
+// publish 向监控推送会话事件
+func (session *ScriptSession) publish(event MonitorEvent) {
+	session.monitor.Publish(event)
+}
+
+type partialResultsKey struct{}
+
+// withPartialResults 识别过程中的中间结果通过onPartial回调
+func withPartialResults(ctx context.Context, onPartial func(text string)) context.Context {
+	return context.WithValue(ctx, partialResultsKey{}, onPartial)
+}
+
+// partialResultsFrom 返回ctx中的中间结果回调，可能为nil
+func partialResultsFrom(ctx context.Context) func(text string) {
+	onPartial, _ := ctx.Value(partialResultsKey{}).(func(text string))
+	return onPartial
+}
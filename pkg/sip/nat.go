@@ -0,0 +1,108 @@
+package sip1
+
+import (
+	"net"
+	"slices"
+	"sync/atomic"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+// markReceived 按RFC 3261 18.2.1和RFC 3581在顶层Via上记录包的实际来源：sent-by与源IP不同时加received，
+// 带rport时同时填入received和源端口；响应和注册绑定据此回到NAT映射后的地址。
+// 客户端自带的received一律去掉，只保留观察到的源地址，否则可以把响应和绑定引向任意地址
+func markReceived(next sipgo.RequestHandler) sipgo.RequestHandler {
+	return func(req *sip.Request, tx sip.ServerTransaction) {
+		if via := req.Via(); via != nil {
+			if host, port, err := net.SplitHostPort(req.Source()); err == nil {
+				if via.Params == nil {
+					via.Params = sip.NewParams()
+				}
+				via.Params.Remove("received")
+				rport := via.Params.Has("rport")
+				if sentBy := hostIP(via.Host); rport || sentBy == nil || !sentBy.Equal(net.ParseIP(host)) {
+					via.Params.Add("received", host)
+				}
+				if rport {
+					via.Params.Add("rport", port)
+				}
+			}
+		}
+		next(req, tx)
+	}
+}
+
+// acceptRTPSource 判断入向RTP是否来自remote。latch未锁定时，首个来自其他地址且不是其他通话对端的包
+// 把remote改为该源地址（对称RTP），latched返回true；锁定后只接受与remote同IP的包
+func acceptRTPSource(latch *atomic.Bool, remote, source *net.UDPAddr, claimed func(addr *net.UDPAddr) bool) (accepted, latched bool) {
+	if source.IP.Equal(remote.IP) && source.Port == remote.Port {
+		latch.Store(true)
+		return true, false
+	}
+	if latch.Load() || (claimed != nil && claimed(source)) || !latch.CompareAndSwap(false, true) {
+		return source.IP.Equal(remote.IP), false
+	}
+	remote.IP = slices.Clone(source.IP)
+	remote.Port = source.Port
+	remote.Zone = source.Zone
+	return true, true
+}
+
+// acceptRTP 判断入向包是否属于会话，首个包把对端锁定到NAT后的实际地址，见acceptRTPSource
+func (engine *AIPhoneEngine) acceptRTP(session *ScriptSession, remote, source *net.UDPAddr) bool {
+	accepted, latched := acceptRTPSource(&session.rtpLatch, remote, source, func(addr *net.UDPAddr) bool {
+		return engine.rtpPeerClaimed(session.CallID, addr)
+	})
+	if !latched {
+		return accepted
+	}
+
+	peer := *remote
+	if engine.server != nil {
+		engine.server.setRTPPeer(session.CallID, &peer)
+	} else {
+		session.mutex.Lock()
+		session.ClientAddr = peer.String()
+		session.mutex.Unlock()
+	}
+	session.latchedPeer.Store(&peer)
+	logger.Info("RTP peer latched to source address",
+		zap.String("call_id", session.CallID),
+		zap.String("rtp_addr", peer.String()))
+	return true
+}
+
+// acceptRTP 服务端自身接收RTP（录音）时的对称RTP判断，latch由调用方按通话保存
+func (as *SipServer) acceptRTP(callID string, latch *atomic.Bool, remote, source *net.UDPAddr) bool {
+	accepted, latched := acceptRTPSource(latch, remote, source, func(addr *net.UDPAddr) bool {
+		return as.aiEngine != nil && as.aiEngine.rtpPeerClaimed(callID, addr)
+	})
+	if latched {
+		peer := *remote
+		as.setRTPPeer(callID, &peer)
+		logger.Info("RTP peer latched to source address", zap.String("call_id", callID), zap.String("rtp_addr", peer.String()))
+	}
+	return accepted
+}
+
+// rtpPeerClaimed 地址是否已是其他AI会话的RTP对端，共享端口时避免把别的通话的媒体锁定过来
+func (engine *AIPhoneEngine) rtpPeerClaimed(callID string, addr *net.UDPAddr) bool {
+	target := addr.String()
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+	for id, session := range engine.sessions {
+		if id == callID {
+			continue
+		}
+		session.mutex.RLock()
+		clientAddr := session.ClientAddr
+		session.mutex.RUnlock()
+		if clientAddr == target {
+			return true
+		}
+	}
+	return false
+}
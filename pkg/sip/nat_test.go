@@ -0,0 +1,54 @@
+package sip1
+
+import (
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+func TestMarkReceived(t *testing.T) {
+	tests := []struct {
+		name     string
+		sentBy   string
+		params   sip.HeaderParams
+		received string // 为空时不应带received
+		rport    string
+	}{
+		{name: "sent-by matches source", sentBy: "203.0.113.5"},
+		{name: "private sent-by behind NAT", sentBy: "192.168.1.20", received: "203.0.113.5"},
+		{name: "hostname sent-by", sentBy: "phone.example.com", received: "203.0.113.5"},
+		{name: "rport", sentBy: "192.168.1.20", params: sip.HeaderParams{"rport": ""}, received: "203.0.113.5", rport: "40123"},
+		{name: "rport with matching sent-by", sentBy: "203.0.113.5", params: sip.HeaderParams{"rport": ""}, received: "203.0.113.5", rport: "40123"},
+		// 客户端伪造的received被观察到的源地址替换或去掉
+		{name: "forged received with matching sent-by", sentBy: "203.0.113.5", params: sip.HeaderParams{"received": "198.51.100.66"}},
+		{name: "forged received behind NAT", sentBy: "192.168.1.20", params: sip.HeaderParams{"received": "198.51.100.66"}, received: "203.0.113.5"},
+		{name: "forged received and rport", sentBy: "192.168.1.20", params: sip.HeaderParams{"received": "198.51.100.66", "rport": "5060"}, received: "203.0.113.5", rport: "40123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newTestRequest(t, sip.REGISTER, "203.0.113.5:40123", "")
+			via := req.Via()
+			via.Host, via.Port = tt.sentBy, 5060
+			via.Params = sip.HeaderParams{"branch": "z9hG4bK-nat"}
+			for key, value := range tt.params {
+				via.Params.Add(key, value)
+			}
+
+			var seen *sip.ViaHeader
+			markReceived(func(req *sip.Request, tx sip.ServerTransaction) { seen = req.Via() })(req, nil)
+			if seen == nil {
+				t.Fatal("handler not called")
+			}
+			received, ok := seen.Params.Get("received")
+			if received != tt.received || ok != (tt.received != "") {
+				t.Errorf("received = %q (present %v), want %q", received, ok, tt.received)
+			}
+			if rport, _ := seen.Params.Get("rport"); rport != tt.rport {
+				t.Errorf("rport = %q, want %q", rport, tt.rport)
+			}
+			if branch, _ := seen.Params.Get("branch"); branch != "z9hG4bK-nat" {
+				t.Errorf("branch = %q", branch)
+			}
+		})
+	}
+}
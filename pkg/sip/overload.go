@@ -0,0 +1,123 @@
+package sip1
+
+import (
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+// loadSampleInterval CPU使用率和RTP包速率的采样间隔
+const loadSampleInterval = time.Second
+
+// LoadStats 最近一次采样的负载和过载拒绝次数
+type LoadStats struct {
+	CPUPercent       int       `json:"cpuPercent"`    // 进程CPU使用率，占GOMAXPROCS的百分比，不支持的平台为0
+	RTPPacketRate    int       `json:"rtpPacketRate"` // 共享RTP端口每秒收发的包数
+	MaxCPUPercent    int       `json:"maxCpuPercent"`
+	MaxRTPPacketRate int       `json:"maxRtpPacketRate"`
+	Rejected         int64     `json:"rejected"` // 过载回复503的呼叫数
+	SampledAt        time.Time `json:"sampledAt"`
+}
+
+// loadMonitor 按秒采样进程CPU使用率和共享RTP端口的包速率
+type loadMonitor struct {
+	rtpPackets atomic.Int64 // 共享RTP端口收发的包数，由tracedRTPConn累加
+	cpuPercent atomic.Int64
+	rtpRate    atomic.Int64
+	rejected   atomic.Int64
+	sampledAt  atomic.Int64 // UnixNano
+}
+
+// runLoadMonitor 配置了CPU或RTP预算时定期采样，随服务关闭退出
+func (as *SipServer) runLoadMonitor() {
+	if as.config.MaxCPUPercent == 0 && as.config.MaxRTPPacketRate == 0 {
+		return
+	}
+	ticker := time.NewTicker(loadSampleInterval)
+	defer ticker.Stop()
+
+	lastWall := time.Now()
+	lastCPU, cpuOK := processCPUTime()
+	lastPackets := as.load.rtpPackets.Load()
+	for {
+		select {
+		case <-as.stopChan:
+			return
+		case now := <-ticker.C:
+			elapsed := now.Sub(lastWall)
+			if elapsed <= 0 {
+				continue
+			}
+			packets := as.load.rtpPackets.Load()
+			as.load.rtpRate.Store(int64(float64(packets-lastPackets) / elapsed.Seconds()))
+			if cpu, ok := processCPUTime(); ok && cpuOK {
+				budget := float64(elapsed) * float64(runtime.GOMAXPROCS(0))
+				as.load.cpuPercent.Store(int64(float64(cpu-lastCPU) / budget * 100))
+				lastCPU = cpu
+			}
+			as.load.sampledAt.Store(now.UnixNano())
+			lastWall, lastPackets = now, packets
+		}
+	}
+}
+
+// overloaded 最近一次采样超出CPU或RTP预算时返回超出的预算名称
+func (as *SipServer) overloaded() (string, bool) {
+	if limit := as.config.MaxCPUPercent; limit > 0 && as.load.cpuPercent.Load() > int64(limit) {
+		return "cpu", true
+	}
+	if limit := as.config.MaxRTPPacketRate; limit > 0 && as.load.rtpRate.Load() > int64(limit) {
+		return "rtp", true
+	}
+	return "", false
+}
+
+// Load 返回最近一次采样的CPU使用率、RTP包速率和过载拒绝次数
+func (as *SipServer) Load() LoadStats {
+	stats := LoadStats{
+		CPUPercent:       int(as.load.cpuPercent.Load()),
+		RTPPacketRate:    int(as.load.rtpRate.Load()),
+		MaxCPUPercent:    as.config.MaxCPUPercent,
+		MaxRTPPacketRate: as.config.MaxRTPPacketRate,
+		Rejected:         as.load.rejected.Load(),
+	}
+	if at := as.load.sampledAt.Load(); at != 0 {
+		stats.SampledAt = time.Unix(0, at)
+	}
+	return stats
+}
+
+// shedLoad 超出CPU或RTP预算时新呼入回复503并带Retry-After，让中继改投其他节点或稍后重试；
+// 对话内的re-INVITE不受影响
+func (as *SipServer) shedLoad(next sipgo.RequestHandler) sipgo.RequestHandler {
+	return func(req *sip.Request, tx sip.ServerTransaction) {
+		to := req.To()
+		if to.Params.Has("tag") {
+			next(req, tx)
+			return
+		}
+		budget, over := as.overloaded()
+		if !over {
+			next(req, tx)
+			return
+		}
+
+		as.load.rejected.Add(1)
+		retryAfter := int(as.config.OverloadRetryAfter / time.Second)
+		logger.Warn("Rejecting INVITE while overloaded",
+			zap.String("call_id", req.CallID().Value()),
+			zap.String("budget", budget),
+			zap.Int64("cpu_percent", as.load.cpuPercent.Load()),
+			zap.Int64("rtp_packet_rate", as.load.rtpRate.Load()),
+			zap.Int("retry_after", retryAfter))
+		res := sip.NewResponseFromRequest(req, sip.StatusServiceUnavailable, "Service Unavailable", nil)
+		res.AppendHeader(sip.NewHeader("Retry-After", strconv.Itoa(retryAfter)))
+		tx.Respond(res)
+	}
+}
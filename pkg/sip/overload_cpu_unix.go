@@ -0,0 +1,17 @@
+//go:build !windows
+
+package sip1
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime 返回进程累计使用的用户态和内核态CPU时间
+func processCPUTime() (time.Duration, bool) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, false
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), true
+}
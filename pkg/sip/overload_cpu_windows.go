@@ -0,0 +1,10 @@
+//go:build windows
+
+package sip1
+
+import "time"
+
+// processCPUTime Windows上不采样CPU，MaxCPUPercent不生效
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,412 @@
+package sip1
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+const (
+	// presenceDefaultExpires 订阅未带Expires时的有效期，也是允许的最长有效期
+	presenceDefaultExpires = 3600
+	// presenceMinExpires 允许的最短订阅有效期（RFC 6665 Min-Expires）
+	presenceMinExpires = 60
+
+	presenceEventPresence = "presence" // RFC 3856，PIDF表示是否在线和忙闲
+	presenceEventDialog   = "dialog"   // RFC 4235，列出进行中的通话，话务台的忙闲灯（BLF）使用
+)
+
+// presenceEvents 支持订阅的事件包，用于Allow-Events头域
+var presenceEvents = presenceEventPresence + ", " + presenceEventDialog
+
+// presenceSubscription 一个SUBSCRIBE建立的订阅，NOTIFY复用CallDialog构建
+type presenceSubscription struct {
+	dialog *CallDialog
+	event  string
+	user   string // 被订阅的用户或AI线路号码
+	line   bool   // 被订阅的是脚本号码，不需要注册也视为在线
+
+	mutex   sync.Mutex // 串行发送NOTIFY，保证dialog-info版本号递增
+	expires time.Time
+	timer   *time.Timer
+	version int
+}
+
+// presenceCall 影响忙闲状态的通话
+type presenceCall struct {
+	callID string
+	from   string
+	to     string
+	state  string // RFC 4235的early/confirmed
+}
+
+// handleSubscribe 处理presence和dialog事件的SUBSCRIBE：新订阅和刷新都回复200并立即发送当前状态，
+// Expires为0时发送最后一次NOTIFY并结束订阅
+func (as *SipServer) handleSubscribe(req *sip.Request, tx sip.ServerTransaction) {
+	callID := req.CallID().Value()
+	event := ""
+	if h := req.GetHeader("Event"); h != nil {
+		event, _, _ = strings.Cut(h.Value(), ";")
+		event = strings.ToLower(strings.TrimSpace(event))
+	}
+	if event != presenceEventPresence && event != presenceEventDialog {
+		res := sip.NewResponseFromRequest(req, 489, "Bad Event", nil)
+		res.AppendHeader(sip.NewHeader("Allow-Events", presenceEvents))
+		as.respondSubscribe(tx, res)
+		return
+	}
+
+	expires := presenceDefaultExpires
+	if h := req.GetHeader("Expires"); h != nil {
+		value, err := strconv.Atoi(strings.TrimSpace(h.Value()))
+		if err != nil || value < 0 {
+			as.respondSubscribe(tx, sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Invalid Expires", nil))
+			return
+		}
+		expires = min(value, presenceDefaultExpires)
+	}
+	if expires > 0 && expires < presenceMinExpires {
+		res := sip.NewResponseFromRequest(req, sip.StatusIntervalToBrief, "Interval Too Brief", nil)
+		res.AppendHeader(sip.NewHeader("Min-Expires", strconv.Itoa(presenceMinExpires)))
+		as.respondSubscribe(tx, res)
+		return
+	}
+
+	// 带To tag的是对已有订阅的刷新或取消
+	if toTag, _ := req.To().Params.Get("tag"); toTag != "" {
+		sub, exists := as.getSubscription(callID)
+		if !exists || sub.dialog.LocalTag != toTag || sub.event != event {
+			as.respondSubscribe(tx, sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil))
+			return
+		}
+		res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+		res.AppendHeader(sip.NewHeader("Expires", strconv.Itoa(expires)))
+		as.respondSubscribe(tx, res)
+		as.refreshSubscription(sub, expires)
+		return
+	}
+
+	user := req.Recipient.User
+	line := false
+	bindings, _ := as.config.Bindings(user)
+	if len(bindings) == 0 && as.aiEngine != nil {
+		script, err := as.aiEngine.GetScriptByPhoneNumber(user)
+		line = err == nil && script != nil
+	}
+	if user == "" || len(bindings) == 0 && !line {
+		as.respondSubscribe(tx, sip.NewResponseFromRequest(req, sip.StatusNotFound, "Not Found", nil))
+		return
+	}
+
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	res.AppendHeader(sip.NewHeader("Expires", strconv.Itoa(expires)))
+	dialog, err := newCallDialog(req, res)
+	if err != nil {
+		as.respondSubscribe(tx, sip.NewResponseFromRequest(req, sip.StatusBadRequest, "Bad Request", nil))
+		return
+	}
+	as.respondSubscribe(tx, res)
+
+	sub := &presenceSubscription{dialog: dialog, event: event, user: user, line: line}
+	logger.Info("Presence subscription created",
+		zap.String("call_id", callID),
+		zap.String("event", event),
+		zap.String("user", user),
+		zap.String("subscriber", dialog.RemoteURI.String()),
+		zap.Int("expires", expires))
+	as.refreshSubscription(sub, expires)
+}
+
+func (as *SipServer) respondSubscribe(tx sip.ServerTransaction, res *sip.Response) {
+	if err := tx.Respond(res); err != nil {
+		logger.Error("Failed to respond to SUBSCRIBE", zap.Int("status", int(res.StatusCode)), zap.Error(err))
+	}
+}
+
+// refreshSubscription 保存订阅并重设到期时间后发送当前状态，expires为0时结束订阅
+func (as *SipServer) refreshSubscription(sub *presenceSubscription, expires int) {
+	callID := sub.dialog.CallID
+	if expires == 0 {
+		as.removeSubscription(callID)
+		go as.sendPresenceNotify(sub, "timeout")
+		return
+	}
+
+	sub.mutex.Lock()
+	sub.expires = time.Now().Add(time.Duration(expires) * time.Second)
+	if sub.timer != nil {
+		sub.timer.Stop()
+	}
+	sub.timer = time.AfterFunc(time.Duration(expires)*time.Second, func() {
+		if as.removeSubscription(callID) {
+			logger.Info("Presence subscription expired", zap.String("call_id", callID), zap.String("user", sub.user))
+			as.sendPresenceNotify(sub, "timeout")
+		}
+	})
+	sub.mutex.Unlock()
+
+	as.presenceMutex.Lock()
+	as.subscriptions[callID] = sub
+	as.presenceMutex.Unlock()
+	go as.sendPresenceNotify(sub, "")
+}
+
+func (as *SipServer) getSubscription(callID string) (*presenceSubscription, bool) {
+	as.presenceMutex.Lock()
+	defer as.presenceMutex.Unlock()
+	sub, exists := as.subscriptions[callID]
+	return sub, exists
+}
+
+// removeSubscription 删除订阅并停止到期定时器，返回订阅是否存在
+func (as *SipServer) removeSubscription(callID string) bool {
+	as.presenceMutex.Lock()
+	sub, exists := as.subscriptions[callID]
+	delete(as.subscriptions, callID)
+	as.presenceMutex.Unlock()
+	if exists {
+		sub.mutex.Lock()
+		if sub.timer != nil {
+			sub.timer.Stop()
+		}
+		sub.mutex.Unlock()
+	}
+	return exists
+}
+
+// presenceChanged 用户的注册或通话状态变化后通知其订阅者
+func (as *SipServer) presenceChanged(users ...string) {
+	as.presenceMutex.Lock()
+	var subs []*presenceSubscription
+	for _, sub := range as.subscriptions {
+		for _, user := range users {
+			if sub.user == user {
+				subs = append(subs, sub)
+				break
+			}
+		}
+	}
+	as.presenceMutex.Unlock()
+	for _, sub := range subs {
+		go as.sendPresenceNotify(sub, "")
+	}
+}
+
+// presenceCallStarted 记录新通话，主被叫都视为忙；已记录的通话不降级状态
+func (as *SipServer) presenceCallStarted(call *models.SipCall) {
+	state := "early"
+	if call.Status == models.SipCallStatusAnswered {
+		state = "confirmed"
+	}
+	as.presenceMutex.Lock()
+	if _, exists := as.presenceCalls[call.CallID]; exists {
+		as.presenceMutex.Unlock()
+		return
+	}
+	as.presenceCalls[call.CallID] = &presenceCall{callID: call.CallID, from: call.FromUsername, to: call.ToUsername, state: state}
+	as.presenceMutex.Unlock()
+	as.presenceChanged(call.FromUsername, call.ToUsername)
+}
+
+// presenceCallStatus 通话接通或结束时更新忙闲状态
+func (as *SipServer) presenceCallStatus(callID string, status models.SipCallStatus) {
+	switch status {
+	case models.SipCallStatusAnswered:
+		as.presenceMutex.Lock()
+		call, exists := as.presenceCalls[callID]
+		if exists {
+			call.state = "confirmed"
+		}
+		as.presenceMutex.Unlock()
+		if exists {
+			as.presenceChanged(call.from, call.to)
+		} else if sipCall, found := as.config.GetCall(callID); found {
+			sipCall.Status = status
+			as.presenceCallStarted(sipCall)
+		}
+	case models.SipCallStatusEnded, models.SipCallStatusFailed, models.SipCallStatusCancelled:
+		as.presenceMutex.Lock()
+		call, exists := as.presenceCalls[callID]
+		delete(as.presenceCalls, callID)
+		as.presenceMutex.Unlock()
+		if exists {
+			as.presenceChanged(call.from, call.to)
+		}
+	}
+}
+
+// userCalls 返回用户参与的进行中通话
+func (as *SipServer) userCalls(user string) []presenceCall {
+	as.presenceMutex.Lock()
+	defer as.presenceMutex.Unlock()
+	var calls []presenceCall
+	for _, call := range as.presenceCalls {
+		if call.from == user || call.to == user {
+			calls = append(calls, *call)
+		}
+	}
+	return calls
+}
+
+// sendPresenceNotify 发送订阅用户的当前状态，reason不为空时结束订阅；对端回复481时删除订阅
+func (as *SipServer) sendPresenceNotify(sub *presenceSubscription, reason string) {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+
+	state := "terminated;reason=" + reason
+	if reason == "" {
+		state = fmt.Sprintf("active;expires=%d", max(int(time.Until(sub.expires).Seconds()), 0))
+	}
+	contentType, body, err := as.presenceBody(sub)
+	if err != nil {
+		logger.Error("Failed to build presence document", zap.String("call_id", sub.dialog.CallID), zap.Error(err))
+		return
+	}
+
+	notify := sub.dialog.NewRequest(sip.NOTIFY)
+	notify.AppendHeader(sip.NewHeader("Event", sub.event))
+	notify.AppendHeader(sip.NewHeader("Subscription-State", state))
+	header := sip.ContentTypeHeader(contentType)
+	notify.AppendHeader(&header)
+	notify.SetBody(body)
+
+	ctx, cancel := context.WithTimeout(context.Background(), as.config.TransactionTimeout)
+	defer cancel()
+
+	tx, err := as.client.TransactionRequest(ctx, notify, as.clientMaxForwards, sipgo.ClientRequestBuild)
+	if err != nil {
+		logger.Warn("Failed to send presence NOTIFY", zap.String("call_id", sub.dialog.CallID), zap.Error(err))
+		return
+	}
+	defer tx.Terminate()
+
+	for {
+		select {
+		case res := <-tx.Responses():
+			if res.IsProvisional() {
+				continue
+			}
+			if !res.IsSuccess() {
+				logger.Warn("Presence NOTIFY rejected",
+					zap.String("call_id", sub.dialog.CallID),
+					zap.Int("status", int(res.StatusCode)))
+				// 订阅者已不认识该订阅，不再通知（RFC 6665 4.2.2）
+				if res.StatusCode == sip.StatusCallTransactionDoesNotExists {
+					go as.removeSubscription(sub.dialog.CallID)
+				}
+			}
+			return
+		case <-tx.Done():
+			return
+		case <-ctx.Done():
+			logger.Warn("Presence NOTIFY timeout", zap.String("call_id", sub.dialog.CallID))
+			return
+		}
+	}
+}
+
+// pidfDocument RFC 3863 presence文档
+type pidfDocument struct {
+	XMLName xml.Name  `xml:"urn:ietf:params:xml:ns:pidf presence"`
+	Entity  string    `xml:"entity,attr"`
+	Tuple   pidfTuple `xml:"tuple"`
+}
+
+type pidfTuple struct {
+	ID    string `xml:"id,attr"`
+	Basic string `xml:"status>basic"`
+	Note  string `xml:"note,omitempty"`
+}
+
+// dialogInfoDocument RFC 4235 dialog-info文档，每次发送都是完整状态
+type dialogInfoDocument struct {
+	XMLName xml.Name          `xml:"urn:ietf:params:xml:ns:dialog-info dialog-info"`
+	Version int               `xml:"version,attr"`
+	State   string            `xml:"state,attr"`
+	Entity  string            `xml:"entity,attr"`
+	Dialogs []dialogInfoEntry `xml:"dialog"`
+}
+
+type dialogInfoEntry struct {
+	ID        string `xml:"id,attr"`
+	CallID    string `xml:"call-id,attr"`
+	Direction string `xml:"direction,attr"`
+	State     string `xml:"state"`
+}
+
+// presenceBody 按订阅的事件包生成当前状态，需持有sub.mutex
+func (as *SipServer) presenceBody(sub *presenceSubscription) (string, []byte, error) {
+	entity := fmt.Sprintf("sip:%s@%s", sub.user, sub.dialog.LocalURI.Host)
+	calls := as.userCalls(sub.user)
+
+	var doc any
+	var contentType string
+	if sub.event == presenceEventDialog {
+		sub.version++
+		info := dialogInfoDocument{Version: sub.version, State: "full", Entity: entity}
+		for _, call := range calls {
+			direction := "recipient"
+			if call.from == sub.user {
+				direction = "initiator"
+			}
+			info.Dialogs = append(info.Dialogs, dialogInfoEntry{ID: call.callID, CallID: call.callID, Direction: direction, State: call.state})
+		}
+		doc, contentType = info, "application/dialog-info+xml"
+	} else {
+		tuple := pidfTuple{ID: "lingsip", Basic: "closed", Note: "offline"}
+		if bindings, _ := as.config.Bindings(sub.user); sub.line || len(bindings) > 0 {
+			tuple.Basic, tuple.Note = "open", "available"
+			if len(calls) > 0 {
+				tuple.Note = "busy"
+			}
+		}
+		doc, contentType = pidfDocument{Entity: entity, Tuple: tuple}, "application/pidf+xml"
+	}
+
+	body, err := xml.Marshal(doc)
+	if err != nil {
+		return "", nil, err
+	}
+	return contentType, append([]byte(xml.Header), body...), nil
+}
+
+// terminateSubscriptions 关闭时结束所有订阅，订阅者收到后会重新订阅
+func (as *SipServer) terminateSubscriptions(ctx context.Context) {
+	as.presenceMutex.Lock()
+	subs := make([]*presenceSubscription, 0, len(as.subscriptions))
+	for _, sub := range as.subscriptions {
+		subs = append(subs, sub)
+	}
+	as.presenceMutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, sub := range subs {
+		if !as.removeSubscription(sub.dialog.CallID) {
+			continue
+		}
+		wg.Add(1)
+		go func(sub *presenceSubscription) {
+			defer wg.Done()
+			as.sendPresenceNotify(sub, "deactivated")
+		}(sub)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,134 @@
+package sip1
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/audio"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// promptSampleRate 上传的提示音统一保存的采样率，播放时再按通话编码重采样
+	promptSampleRate = 8000
+	// maxPromptUploadSize 提示音上传的最大字节数
+	maxPromptUploadSize = 20 << 20
+)
+
+// promptNamePattern 提示音名称只允许字母、数字、汉字、下划线和连字符，避免路径穿越
+var promptNamePattern = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+
+// PromptFile 已保存的提示音
+type PromptFile struct {
+	Name       string    `json:"name"`
+	File       string    `json:"file"` // 步骤audioFile可直接使用的路径
+	DurationMs int       `json:"durationMs"`
+	ModifiedAt time.Time `json:"modifiedAt,omitempty"`
+	Loudness   *float64  `json:"loudness,omitempty"` // 上传时原始响度（LUFS），静音时为空
+	Gain       float64   `json:"gain,omitempty"`     // 归一时施加的增益
+}
+
+// storePrompt 读取WAV，转为8kHz单声道并按目标响度归一后保存到dir/name.wav
+func storePrompt(dir, name string, r io.Reader) (*PromptFile, error) {
+	format, samples, err := audio.Read(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAV: %w", err)
+	}
+	if samples, err = audio.Convert(format, samples, promptSampleRate); err != nil {
+		return nil, fmt.Errorf("failed to convert prompt: %w", err)
+	}
+
+	prompt := &PromptFile{
+		Name:       name,
+		File:       filepath.Join(dir, name+".wav"),
+		DurationMs: len(samples) * 1000 / promptSampleRate,
+	}
+	if loudness := audio.Loudness(samples, promptSampleRate); !math.IsInf(loudness, -1) {
+		prompt.Loudness = &loudness
+	}
+	prompt.Gain = normalizeLoudness(samples, promptSampleRate)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create prompt directory: %w", err)
+	}
+	if err := audio.WriteFile(prompt.File, audio.Mono16(promptSampleRate), samples); err != nil {
+		return nil, fmt.Errorf("failed to save prompt: %w", err)
+	}
+	prompt.ModifiedAt = time.Now()
+	return prompt, nil
+}
+
+// RegisterPromptAPIs 注册提示音接口：POST /prompts（multipart，file为WAV，name可选）上传并统一响度和采样率，
+// 返回的file可填入步骤的audioFile；GET /prompts 列出已上传的提示音
+func RegisterPromptAPIs(r gin.IRoutes, server *SipServer) {
+	dir := server.config.PromptDir
+
+	r.POST("/prompts", func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPromptUploadSize)
+		header, err := c.FormFile("file")
+		if err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		name := c.PostForm("name")
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename))
+		}
+		if !promptNamePattern.MatchString(name) {
+			response.Fail(c, "invalid prompt name", name)
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			response.Fail(c, "failed to read upload", err.Error())
+			return
+		}
+		defer file.Close()
+
+		prompt, err := storePrompt(dir, name, file)
+		if err != nil {
+			response.Fail(c, "failed to store prompt", err.Error())
+			return
+		}
+		logger.Info("Prompt uploaded",
+			zap.String("file", prompt.File),
+			zap.Int("duration_ms", prompt.DurationMs),
+			zap.Float64("gain", prompt.Gain))
+		response.Success(c, "ok", prompt)
+	})
+
+	r.GET("/prompts", func(c *gin.Context) {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			response.Fail(c, "failed to list prompts", err.Error())
+			return
+		}
+		prompts := make([]PromptFile, 0, len(entries))
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != ".wav" {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			prompts = append(prompts, PromptFile{
+				Name:       strings.TrimSuffix(entry.Name(), ".wav"),
+				File:       filepath.Join(dir, entry.Name()),
+				DurationMs: int(max(info.Size()-audio.HeaderSize, 0) / 2 * 1000 / promptSampleRate),
+				ModifiedAt: info.ModTime(),
+			})
+		}
+		response.Success(c, "ok", prompts)
+	})
+}
@@ -0,0 +1,171 @@
+package sip1
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// promptTemplatePrefix 提示词中引用模板的前缀，如{{template:合规条款}}、{{template:合规条款@2}}
+	promptTemplatePrefix = "template:"
+	// promptTemplateMaxDepth 模板中引用其他模板的最大层数，避免循环引用
+	promptTemplateMaxDepth = 3
+	// auditTargetPromptTemplate 提示词模板审计日志的对象类型
+	auditTargetPromptTemplate = "prompt_template"
+)
+
+// promptPlaceholder 提示词中的{{变量}}和{{template:名称}}
+var promptPlaceholder = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+
+// renderPrompt 展开步骤提示词中引用的模板，再以会话上下文、通话信息和模板默认值替换变量。
+// 每轮对话都重新读取模板，修改后进行中的通话从下一轮起使用新版本；找不到的模板和变量替换为空
+func (engine *AIPhoneEngine) renderPrompt(session *ScriptSession, prompt string) string {
+	if !strings.Contains(prompt, "{{") {
+		return prompt
+	}
+	defaults := make(map[string]string)
+	expanded := engine.expandPromptTemplates(session, prompt, defaults, 0)
+	return promptPlaceholder.ReplaceAllStringFunc(expanded, func(match string) string {
+		name := promptPlaceholder.FindStringSubmatch(match)[1]
+		if value, ok := session.promptVariable(name); ok {
+			return value
+		}
+		if value, ok := defaults[name]; ok {
+			return value
+		}
+		logger.Warn("Prompt variable not set", zap.String("call_id", session.CallID), zap.String("variable", name))
+		return ""
+	})
+}
+
+// expandPromptTemplates 替换text中的模板引用，外层模板的变量默认值优先
+func (engine *AIPhoneEngine) expandPromptTemplates(session *ScriptSession, text string, defaults map[string]string, depth int) string {
+	return promptPlaceholder.ReplaceAllStringFunc(text, func(match string) string {
+		ref, ok := strings.CutPrefix(promptPlaceholder.FindStringSubmatch(match)[1], promptTemplatePrefix)
+		if !ok {
+			return match
+		}
+		if depth >= promptTemplateMaxDepth {
+			logger.Warn("Prompt template nested too deep", zap.String("call_id", session.CallID), zap.String("template", ref))
+			return ""
+		}
+		template, err := engine.lookupPromptTemplate(ref)
+		if err != nil {
+			logger.Error("Failed to load prompt template",
+				zap.String("call_id", session.CallID),
+				zap.String("template", ref),
+				zap.Error(err))
+			return ""
+		}
+		for name, value := range template.Variables {
+			if _, exists := defaults[name]; !exists {
+				defaults[name] = value
+			}
+		}
+		return engine.expandPromptTemplates(session, template.Content, defaults, depth+1)
+	})
+}
+
+// lookupPromptTemplate 按"名称"或"名称@版本"读取模板
+func (engine *AIPhoneEngine) lookupPromptTemplate(ref string) (*models.PromptTemplate, error) {
+	if engine.db == nil {
+		return nil, fmt.Errorf("database not configured")
+	}
+	name, version := strings.TrimSpace(ref), 0
+	if base, suffix, ok := strings.Cut(name, "@"); ok {
+		n, err := strconv.Atoi(suffix)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid template version %q", suffix)
+		}
+		name, version = base, n
+	}
+	return models.GetPromptTemplate(engine.db, name, version)
+}
+
+// promptVariable 提示词变量的值：会话上下文优先，其次是主叫、被叫号码和脚本名称
+func (session *ScriptSession) promptVariable(name string) (string, bool) {
+	if session.Context != nil {
+		if value, ok := session.Context.Get(name); ok && value != nil {
+			return fmt.Sprint(value), true
+		}
+	}
+	switch name {
+	case "caller_number":
+		if session.DBSession != nil {
+			return session.DBSession.CallerNumber, true
+		}
+	case "callee_number":
+		if session.DBSession != nil {
+			return session.DBSession.CalleeNumber, true
+		}
+	case "script_name":
+		if session.Script != nil {
+			return session.Script.Name, true
+		}
+	}
+	return "", false
+}
+
+// SavePromptTemplate 保存模板的新版本，引用最新版本的脚本下一轮对话起使用新内容
+func (engine *AIPhoneEngine) SavePromptTemplate(template *models.PromptTemplate) error {
+	if !promptNamePattern.MatchString(template.Name) {
+		return fmt.Errorf("invalid template name %q", template.Name)
+	}
+	if strings.TrimSpace(template.Content) == "" {
+		return fmt.Errorf("template content is empty")
+	}
+	if err := models.CreatePromptTemplateVersion(engine.db, template); err != nil {
+		return err
+	}
+	engine.auditPromptTemplate(template, "prompt_template.saved", 0)
+	logger.Info("Prompt template saved",
+		zap.String("template", template.Name),
+		zap.Int("version", template.Version),
+		zap.String("actor", template.CreatedBy))
+	return nil
+}
+
+// RollbackPromptTemplate 以指定版本的内容保存为新版本
+func (engine *AIPhoneEngine) RollbackPromptTemplate(name string, version int, actor string) (*models.PromptTemplate, error) {
+	previous, err := models.GetPromptTemplate(engine.db, name, version)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("template %s version %d not found", name, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	template := &models.PromptTemplate{
+		Name:        previous.Name,
+		Content:     previous.Content,
+		Variables:   previous.Variables,
+		Description: fmt.Sprintf("rollback to version %d", previous.Version),
+		CreatedBy:   actor,
+	}
+	if err := models.CreatePromptTemplateVersion(engine.db, template); err != nil {
+		return nil, err
+	}
+	engine.auditPromptTemplate(template, "prompt_template.rolled_back", previous.Version)
+	return template, nil
+}
+
+// auditPromptTemplate 写入模板审计日志，回滚时fromVersion为恢复的版本，失败只记录日志
+func (engine *AIPhoneEngine) auditPromptTemplate(template *models.PromptTemplate, action string, fromVersion int) {
+	detail := map[string]interface{}{"name": template.Name, "version": template.Version}
+	if fromVersion > 0 {
+		detail["fromVersion"] = fromVersion
+	}
+	if err := models.CreateAuditLog(engine.db, template.CreatedBy, action, auditTargetPromptTemplate, template.ID, detail); err != nil {
+		logger.Error("Failed to write audit log",
+			zap.String("action", action),
+			zap.String("template", template.Name),
+			zap.Error(err))
+	}
+}
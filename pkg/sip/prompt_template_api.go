@@ -0,0 +1,117 @@
+package sip1
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/auth"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RegisterPromptTemplateAPIs 注册提示词模板接口：GET /prompt-templates 列出各模板的最新版本，
+// GET /prompt-templates/:name 列出模板的所有版本（?version=2 只返回该版本），
+// POST /prompt-templates {"name":"合规条款","content":"...{{product}}...","variables":{"product":"信用卡"},"description":"..."}
+// 保存为新版本，POST /prompt-templates/:name/rollback {"version":2} 以旧版本内容保存为新版本。
+// 步骤提示词以{{template:名称}}引用最新版本，{{template:名称@版本}}固定版本
+func RegisterPromptTemplateAPIs(r gin.IRoutes, server *SipServer) {
+	r.GET("/prompt-templates", func(c *gin.Context) {
+		engine, _, ok := scriptEngine(c, server)
+		if !ok {
+			return
+		}
+		templates, err := models.GetLatestPromptTemplates(engine.db)
+		if err != nil {
+			response.Fail(c, "failed to list prompt templates", err.Error())
+			return
+		}
+		response.Success(c, "ok", templates)
+	})
+
+	r.GET("/prompt-templates/:name", func(c *gin.Context) {
+		engine, _, ok := scriptEngine(c, server)
+		if !ok {
+			return
+		}
+		name := c.Param("name")
+		if value := c.Query("version"); value != "" {
+			version, err := strconv.Atoi(value)
+			if err != nil || version <= 0 {
+				response.Fail(c, "invalid version", nil)
+				return
+			}
+			template, err := models.GetPromptTemplate(engine.db, name, version)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				response.Fail(c, "prompt template not found", nil)
+				return
+			}
+			if err != nil {
+				response.Fail(c, "failed to load prompt template", err.Error())
+				return
+			}
+			response.Success(c, "ok", template)
+			return
+		}
+		versions, err := models.GetPromptTemplateVersions(engine.db, name)
+		if err != nil {
+			response.Fail(c, "failed to load prompt template", err.Error())
+			return
+		}
+		if len(versions) == 0 {
+			response.Fail(c, "prompt template not found", nil)
+			return
+		}
+		response.Success(c, "ok", versions)
+	})
+
+	r.POST("/prompt-templates", requireActor, func(c *gin.Context) {
+		engine, _, ok := scriptEngine(c, server)
+		if !ok {
+			return
+		}
+		var form struct {
+			Name        string                 `json:"name" binding:"required"`
+			Content     string                 `json:"content" binding:"required"`
+			Variables   models.PromptVariables `json:"variables"`
+			Description string                 `json:"description"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		template := &models.PromptTemplate{
+			Name:        form.Name,
+			Content:     form.Content,
+			Variables:   form.Variables,
+			Description: form.Description,
+			CreatedBy:   auth.CurrentActor(c),
+		}
+		if err := engine.SavePromptTemplate(template); err != nil {
+			response.Fail(c, "failed to save prompt template", err.Error())
+			return
+		}
+		response.Success(c, "ok", template)
+	})
+
+	r.POST("/prompt-templates/:name/rollback", requireActor, func(c *gin.Context) {
+		engine, _, ok := scriptEngine(c, server)
+		if !ok {
+			return
+		}
+		var form struct {
+			Version int `json:"version" binding:"required,min=1"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		template, err := engine.RollbackPromptTemplate(c.Param("name"), form.Version, auth.CurrentActor(c))
+		if err != nil {
+			response.Fail(c, "failed to roll back prompt template", err.Error())
+			return
+		}
+		response.Success(c, "ok", template)
+	})
+}
@@ -0,0 +1,436 @@
+package sip1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/config"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/notification"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// providerUsageFlushInterval 用量写入数据库并检查额度的间隔
+	providerUsageFlushInterval = time.Minute
+
+	// quotaAlertTimeout 发送额度告警的超时
+	quotaAlertTimeout = 10 * time.Second
+)
+
+// quotaAlertLevels 用量达到月度额度的这些百分比时告警，达到100%时切换到备用服务商
+var quotaAlertLevels = []int{80, 95, 100}
+
+type usageKey struct {
+	month    string
+	service  string
+	provider string
+}
+
+// QuotaAlert 服务商用量达到月度额度的告警
+type QuotaAlert struct {
+	Month    string  `json:"month"`
+	Service  string  `json:"service"`
+	Provider string  `json:"provider"`
+	Amount   float64 `json:"amount"`
+	Quota    float64 `json:"quota"`
+	Percent  int     `json:"percent"`            // 达到的告警级别
+	Fallback string  `json:"fallback,omitempty"` // 已切换到的备用服务商
+}
+
+// ProviderUsageStatus 服务商当月用量和额度，Quota为0表示不限
+type ProviderUsageStatus struct {
+	models.ProviderUsage
+	Quota   float64 `json:"quota"`
+	Percent float64 `json:"percent"`
+	Primary bool    `json:"primary"` // 是否为配置的主服务商，额度只对主服务商生效
+}
+
+// providerUsage 按服务商累计当月用量，定期写入数据库并检查额度；没有数据库时只在内存中累计
+type providerUsage struct {
+	db      *gorm.DB
+	mutex   sync.Mutex
+	pending map[usageKey]float64 // 尚未写入数据库的用量
+	totals  map[usageKey]float64 // 当月累计用量，写入后按数据库中所有实例的合计更新
+	alerted map[usageKey]int     // 没有数据库时已发出的告警级别
+	notify  func(QuotaAlert)
+}
+
+func newProviderUsage(db *gorm.DB) *providerUsage {
+	return &providerUsage{
+		db:      db,
+		pending: make(map[usageKey]float64),
+		totals:  make(map[usageKey]float64),
+		alerted: make(map[usageKey]int),
+		notify:  sendQuotaAlert,
+	}
+}
+
+func usageMonth(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+// serviceQuota 服务的月度额度，0为不限
+func serviceQuota(service string) float64 {
+	if config.GlobalConfig == nil {
+		return 0
+	}
+	quotas := config.GlobalConfig.Services.Quotas
+	switch service {
+	case models.ProviderServiceASR:
+		return quotas.ASRMinutes
+	case models.ProviderServiceTTS:
+		return float64(quotas.TTSChars)
+	case models.ProviderServiceLLM:
+		return float64(quotas.LLMTokens)
+	}
+	return 0
+}
+
+// primaryProvider 全局配置的服务商
+func primaryProvider(service string) string {
+	if config.GlobalConfig == nil {
+		return ""
+	}
+	services := config.GlobalConfig.Services
+	switch service {
+	case models.ProviderServiceASR:
+		return services.ASR.Provider
+	case models.ProviderServiceTTS:
+		return services.TTS.Provider
+	case models.ProviderServiceLLM:
+		return services.LLM.Provider
+	}
+	return ""
+}
+
+// fallbackProvider 额度用完后使用的服务商，LLM不支持切换
+func fallbackProvider(service string) config.FallbackProvider {
+	if config.GlobalConfig == nil {
+		return config.FallbackProvider{}
+	}
+	quotas := config.GlobalConfig.Services.Quotas
+	switch service {
+	case models.ProviderServiceASR:
+		return quotas.ASRFallback
+	case models.ProviderServiceTTS:
+		return quotas.TTSFallback
+	}
+	return config.FallbackProvider{}
+}
+
+// active 返回当前使用的服务商：主服务商的当月额度用完且配置了备用服务商时返回备用服务商
+func (u *providerUsage) active(service string) (string, *config.FallbackProvider) {
+	primary := primaryProvider(service)
+	if u == nil {
+		return primary, nil
+	}
+	fallback := fallbackProvider(service)
+	if fallback.Provider == "" {
+		return primary, nil
+	}
+	quota := serviceQuota(service)
+	if quota <= 0 {
+		return primary, nil
+	}
+
+	u.mutex.Lock()
+	used := u.totals[usageKey{usageMonth(time.Now()), service, primary}]
+	u.mutex.Unlock()
+	if used < quota {
+		return primary, nil
+	}
+	return fallback.Provider, &fallback
+}
+
+// record 累计当前使用的服务商的用量
+func (u *providerUsage) record(service string, amount float64) {
+	if u == nil || amount <= 0 {
+		return
+	}
+	provider, _ := u.active(service)
+	if provider == "" {
+		return
+	}
+	key := usageKey{usageMonth(time.Now()), service, provider}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.pending[key] += amount
+	u.totals[key] += amount
+}
+
+// asrConfig 主服务商额度用完时换成备用服务商的凭证
+func (u *providerUsage) asrConfig(asrConfig config.ASRConfig) config.ASRConfig {
+	if _, fallback := u.active(models.ProviderServiceASR); fallback != nil {
+		asrConfig.Provider = fallback.Provider
+		asrConfig.AppID = fallback.AppID
+		asrConfig.SecretID = fallback.SecretID
+		asrConfig.SecretKey = fallback.SecretKey
+	}
+	return asrConfig
+}
+
+// ttsConfig 主服务商额度用完时换成备用服务商的凭证
+func (u *providerUsage) ttsConfig(ttsConfig config.TTSConfig) config.TTSConfig {
+	if _, fallback := u.active(models.ProviderServiceTTS); fallback != nil {
+		ttsConfig.Provider = fallback.Provider
+		ttsConfig.AppID = fallback.AppID
+		ttsConfig.SecretID = fallback.SecretID
+		ttsConfig.SecretKey = fallback.SecretKey
+	}
+	return ttsConfig
+}
+
+// flush 把累计的用量写入数据库，按所有实例的合计更新当月用量并检查额度
+func (u *providerUsage) flush() {
+	month := usageMonth(time.Now())
+	u.mutex.Lock()
+	pending := u.pending
+	u.pending = make(map[usageKey]float64)
+	for key := range u.totals {
+		if key.month != month {
+			delete(u.totals, key)
+		}
+	}
+	u.mutex.Unlock()
+
+	if u.db == nil {
+		u.checkQuotas(u.memoryUsage(month))
+		return
+	}
+
+	for key, amount := range pending {
+		if err := models.AddProviderUsage(u.db, key.month, key.service, key.provider, amount); err != nil {
+			logger.Warn("Failed to save provider usage", zap.String("service", key.service), zap.String("provider", key.provider), zap.Error(err))
+			u.mutex.Lock()
+			u.pending[key] += amount
+			u.mutex.Unlock()
+		}
+	}
+	rows, err := models.ListProviderUsage(u.db, month)
+	if err != nil {
+		logger.Warn("Failed to load provider usage", zap.Error(err))
+		return
+	}
+
+	u.mutex.Lock()
+	for _, row := range rows {
+		key := usageKey{month, row.Service, row.Provider}
+		u.totals[key] = row.Amount + u.pending[key]
+	}
+	u.mutex.Unlock()
+	u.checkQuotas(rows)
+}
+
+// memoryUsage 没有数据库时由内存中的累计用量构造当月用量
+func (u *providerUsage) memoryUsage(month string) []models.ProviderUsage {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	rows := make([]models.ProviderUsage, 0, len(u.totals))
+	for key, amount := range u.totals {
+		if key.month == month {
+			rows = append(rows, models.ProviderUsage{
+				Month:          month,
+				Service:        key.service,
+				Provider:       key.provider,
+				Amount:         amount,
+				AlertedPercent: u.alerted[key],
+			})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Service != rows[j].Service {
+			return rows[i].Service < rows[j].Service
+		}
+		return rows[i].Provider < rows[j].Provider
+	})
+	return rows
+}
+
+// checkQuotas 主服务商用量达到新的告警级别时告警，每个级别每月只告警一次
+func (u *providerUsage) checkQuotas(rows []models.ProviderUsage) {
+	for _, row := range rows {
+		if row.Provider != primaryProvider(row.Service) {
+			continue
+		}
+		quota := serviceQuota(row.Service)
+		if quota <= 0 {
+			continue
+		}
+		level := 0
+		for _, percent := range quotaAlertLevels {
+			if row.Amount >= quota*float64(percent)/100 {
+				level = percent
+			}
+		}
+		if level <= row.AlertedPercent || !u.claimAlert(row, level) {
+			continue
+		}
+
+		alert := QuotaAlert{
+			Month:    row.Month,
+			Service:  row.Service,
+			Provider: row.Provider,
+			Amount:   row.Amount,
+			Quota:    quota,
+			Percent:  level,
+		}
+		if level >= 100 {
+			alert.Fallback = fallbackProvider(row.Service).Provider
+		}
+		logger.Warn("Provider usage reached monthly quota threshold",
+			zap.String("service", alert.Service),
+			zap.String("provider", alert.Provider),
+			zap.Float64("amount", alert.Amount),
+			zap.Float64("quota", alert.Quota),
+			zap.Int("percent", alert.Percent),
+			zap.String("fallback", alert.Fallback))
+		if u.notify != nil {
+			go u.notify(alert)
+		}
+	}
+}
+
+// claimAlert 记录发出的告警级别，返回false表示其他实例已经告警
+func (u *providerUsage) claimAlert(row models.ProviderUsage, level int) bool {
+	if u.db == nil {
+		u.mutex.Lock()
+		defer u.mutex.Unlock()
+		key := usageKey{row.Month, row.Service, row.Provider}
+		if u.alerted[key] >= level {
+			return false
+		}
+		u.alerted[key] = level
+		return true
+	}
+	claimed, err := models.MarkProviderUsageAlerted(u.db, row.ID, level)
+	if err != nil {
+		logger.Warn("Failed to mark provider usage alert", zap.String("provider", row.Provider), zap.Error(err))
+		return false
+	}
+	return claimed
+}
+
+// report 某月各服务商的用量和额度，当月的用量先写入数据库
+func (u *providerUsage) report(month string) ([]ProviderUsageStatus, error) {
+	if month == "" {
+		month = usageMonth(time.Now())
+	}
+	if month == usageMonth(time.Now()) {
+		u.flush()
+	}
+
+	var rows []models.ProviderUsage
+	if u.db == nil {
+		rows = u.memoryUsage(month)
+	} else {
+		var err error
+		if rows, err = models.ListProviderUsage(u.db, month); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]ProviderUsageStatus, 0, len(rows))
+	for _, row := range rows {
+		status := ProviderUsageStatus{ProviderUsage: row, Primary: row.Provider == primaryProvider(row.Service)}
+		if status.Primary {
+			status.Quota = serviceQuota(row.Service)
+		}
+		if status.Quota > 0 {
+			status.Percent = row.Amount / status.Quota * 100
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// runProviderUsageFlusher 启动时加载当月用量，之后定期写入用量并检查额度，停止时写入剩余的用量
+func (as *SipServer) runProviderUsageFlusher() {
+	as.usage.flush()
+	ticker := time.NewTicker(providerUsageFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-as.stopChan:
+			as.usage.flush()
+			return
+		case <-ticker.C:
+			as.usage.flush()
+		}
+	}
+}
+
+// ProviderUsage 某月（格式2006-01，为空时为当月）各服务商的用量和额度
+func (as *SipServer) ProviderUsage(month string) ([]ProviderUsageStatus, error) {
+	if month != "" {
+		if _, err := time.Parse("2006-01", month); err != nil {
+			return nil, fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+		}
+	}
+	return as.usage.report(month)
+}
+
+// ActiveProviders 各服务当前使用的服务商
+func (as *SipServer) ActiveProviders() map[string]string {
+	active := make(map[string]string)
+	for _, service := range []string{models.ProviderServiceASR, models.ProviderServiceTTS, models.ProviderServiceLLM} {
+		active[service], _ = as.usage.active(service)
+	}
+	return active
+}
+
+// sendQuotaAlert 通过邮件和webhook发送额度告警，未配置的渠道跳过
+func sendQuotaAlert(alert QuotaAlert) {
+	if config.GlobalConfig == nil {
+		return
+	}
+	quotas := config.GlobalConfig.Services.Quotas
+	subject := fmt.Sprintf("%s provider %s reached %d%% of its %s quota", alert.Service, alert.Provider, alert.Percent, alert.Month)
+	body := fmt.Sprintf("Used %.2f of %.2f this month.", alert.Amount, alert.Quota)
+	if alert.Fallback != "" {
+		body += fmt.Sprintf(" Switched to fallback provider %s.", alert.Fallback)
+	}
+
+	if mail := config.GlobalConfig.Services.Mail; quotas.AlertEmail != "" && mail.Host != "" {
+		if err := notification.NewMailNotification(mail).Send(quotas.AlertEmail, subject, body); err != nil {
+			logger.Warn("Failed to send quota alert email", zap.String("to", quotas.AlertEmail), zap.Error(err))
+		}
+	}
+	if quotas.AlertWebhook != "" {
+		if err := postQuotaAlert(quotas.AlertWebhook, alert); err != nil {
+			logger.Warn("Failed to send quota alert webhook", zap.Error(err))
+		}
+	}
+}
+
+func postQuotaAlert(url string, alert QuotaAlert) error {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), quotaAlertTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
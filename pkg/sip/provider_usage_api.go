@@ -0,0 +1,26 @@
+package sip1
+
+import (
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterProviderUsageAPIs 注册服务商用量接口：GET /provider-usage?month=2026-01 查看各服务商某月（默认当月）的用量、
+// 月度额度和使用比例，以及ASR/TTS/LLM当前使用的服务商；用量达到额度的80%、95%、100%时通过日志、邮件和webhook告警，
+// 达到100%且配置了备用服务商时ASR和TTS自动切换
+func RegisterProviderUsageAPIs(r gin.IRoutes, server *SipServer) {
+	r.GET("/provider-usage", func(c *gin.Context) {
+		month := c.Query("month")
+		usage, err := server.ProviderUsage(month)
+		if err != nil {
+			response.Fail(c, "failed to load provider usage", err.Error())
+			return
+		}
+		if month == "" {
+			month = usageMonth(time.Now())
+		}
+		response.Success(c, "ok", gin.H{"month": month, "items": usage, "active": server.ActiveProviders()})
+	})
+}
@@ -0,0 +1,63 @@
+package sip1
+
+import (
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+// sendTrying 收到INVITE后立即回复100 Trying，阻止上游在处理期间重传
+func (as *SipServer) sendTrying(req *sip.Request, tx sip.ServerTransaction) {
+	res := sip.NewResponseFromRequest(req, sip.StatusTrying, "Trying", nil)
+	if err := tx.Respond(res); err != nil {
+		logger.Warn("Failed to send 100 Trying",
+			zap.String("call_id", req.CallID().Value()),
+			zap.Error(err))
+	}
+}
+
+// sendProvisionalResponse 按配置发送180 Ringing或带SDP的183 Session Progress，
+// 然后等待AnswerDelay再接听；等待期间主叫取消时回复487并返回false
+func (as *SipServer) sendProvisionalResponse(req *sip.Request, tx sip.ServerTransaction, sdpBytes []byte) bool {
+	callID := req.CallID().Value()
+
+	switch as.config.ProvisionalResponse {
+	case int(sip.StatusRinging):
+		res := sip.NewResponseFromRequest(req, sip.StatusRinging, "Ringing", nil)
+		if err := tx.Respond(res); err != nil {
+			logger.Warn("Failed to send 180 Ringing", zap.String("call_id", callID), zap.Error(err))
+		}
+	case int(sip.StatusSessionInProgress):
+		// 183携带SDP，允许早期媒体
+		res := sip.NewResponseFromRequest(req, sip.StatusSessionInProgress, "Session Progress", sdpBytes)
+		contentType := sip.ContentTypeHeader("application/sdp")
+		res.AppendHeader(&contentType)
+		if err := tx.Respond(res); err != nil {
+			logger.Warn("Failed to send 183 Session Progress", zap.String("call_id", callID), zap.Error(err))
+		}
+	}
+
+	if as.config.AnswerDelay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(as.config.AnswerDelay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-tx.Cancels():
+		logger.Info("INVITE cancelled before answer", zap.String("call_id", callID))
+		res := sip.NewResponseFromRequest(req, sip.StatusRequestTerminated, "Request Terminated", nil)
+		if err := tx.Respond(res); err != nil {
+			logger.Warn("Failed to send 487 Request Terminated", zap.String("call_id", callID), zap.Error(err))
+		}
+		return false
+	case <-tx.Done():
+		logger.Info("INVITE transaction ended before answer", zap.String("call_id", callID))
+		return false
+	}
+}
@@ -0,0 +1,135 @@
+package sip1
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/config"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+// rateLimitSweepInterval 清理已回满的令牌桶的间隔
+const rateLimitSweepInterval = time.Minute
+
+type rateLimitKey struct {
+	method sip.RequestMethod
+	ip     string
+}
+
+// tokenBucket 单个来源IP某个方法的令牌桶，limited表示上次请求被拒绝，只在开始限流时记录日志
+type tokenBucket struct {
+	tokens  float64
+	last    time.Time
+	limited bool
+}
+
+// sipRateLimiter 按来源IP和方法的令牌桶限流
+type sipRateLimiter struct {
+	mutex     sync.Mutex
+	buckets   map[rateLimitKey]*tokenBucket
+	lastSweep time.Time
+}
+
+func newSIPRateLimiter() *sipRateLimiter {
+	return &sipRateLimiter{buckets: make(map[rateLimitKey]*tokenBucket)}
+}
+
+// sipRateLimit 方法的每秒速率和突发数，未启用或速率为0时返回0
+func sipRateLimit(method sip.RequestMethod) (float64, int) {
+	if config.GlobalConfig == nil || !config.GlobalConfig.Middleware.EnableSIPRateLimit {
+		return 0, 0
+	}
+	limits := config.GlobalConfig.Middleware.SIPRateLimit
+	switch method {
+	case sip.REGISTER:
+		return limits.RegisterRPS, limits.RegisterBurst
+	case sip.INVITE:
+		return limits.InviteRPS, limits.InviteBurst
+	}
+	return 0, 0
+}
+
+// bucketCapacity 令牌桶容量，未配置突发数时为一秒的速率
+func bucketCapacity(rps float64, burst int) float64 {
+	if burst <= 0 {
+		return math.Max(1, math.Ceil(rps))
+	}
+	return float64(burst)
+}
+
+// allow 取一个令牌，令牌不足时返回false和下一个令牌可用前的等待时间；first表示该来源刚开始被限流
+func (l *sipRateLimiter) allow(key rateLimitKey, rps float64, burst int, now time.Time) (ok, first bool, wait time.Duration) {
+	capacity := bucketCapacity(rps, burst)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.last).Seconds()*rps)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.limited = false
+		return true, false, 0
+	}
+	first = !bucket.limited
+	bucket.limited = true
+	return false, first, time.Duration((1 - bucket.tokens) / rps * float64(time.Second))
+}
+
+// sweep 删除已回满或不再限流的令牌桶，重新创建时状态相同
+func (l *sipRateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		rps, burst := sipRateLimit(key.method)
+		if rps <= 0 || bucket.tokens+now.Sub(bucket.last).Seconds()*rps >= bucketCapacity(rps, burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// limitRequestRate 同一来源IP新的REGISTER、INVITE超出令牌桶速率时回复503并带Retry-After，对话内的请求不限流
+func (as *SipServer) limitRequestRate(next sipgo.RequestHandler) sipgo.RequestHandler {
+	return func(req *sip.Request, tx sip.ServerTransaction) {
+		rps, burst := sipRateLimit(req.Method)
+		if rps <= 0 || req.To().Params.Has("tag") {
+			next(req, tx)
+			return
+		}
+
+		sourceIP := requestSourceIP(req)
+		ok, first, wait := as.rateLimiter.allow(rateLimitKey{req.Method, sourceIP}, rps, burst, time.Now())
+		if ok {
+			next(req, tx)
+			return
+		}
+
+		retryAfter := max(1, int(math.Ceil(wait.Seconds())))
+		log := logger.Debug
+		if first {
+			log = logger.Warn
+		}
+		log("Rate limiting SIP requests from source",
+			zap.String("method", req.Method.String()),
+			zap.String("source", sourceIP),
+			zap.Float64("rps", rps),
+			zap.Int("burst", burst),
+			zap.Int("retry_after", retryAfter))
+		res := sip.NewResponseFromRequest(req, sip.StatusServiceUnavailable, "Service Unavailable", nil)
+		res.AppendHeader(sip.NewHeader("Retry-After", strconv.Itoa(retryAfter)))
+		tx.Respond(res)
+	}
+}
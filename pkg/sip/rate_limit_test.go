@@ -0,0 +1,289 @@
+package sip1
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/config"
+	"github.com/emiago/sipgo/sip"
+)
+
+// recordingTx 记录响应的服务端事务
+type recordingTx struct {
+	responses []*sip.Response
+	done      chan struct{}
+}
+
+func newRecordingTx() *recordingTx {
+	return &recordingTx{done: make(chan struct{})}
+}
+
+func (tx *recordingTx) Respond(res *sip.Response) error {
+	tx.responses = append(tx.responses, res)
+	return nil
+}
+
+func (tx *recordingTx) Terminate()                   {}
+func (tx *recordingTx) Done() <-chan struct{}        { return tx.done }
+func (tx *recordingTx) Err() error                   { return nil }
+func (tx *recordingTx) Acks() <-chan *sip.Request    { return nil }
+func (tx *recordingTx) Cancels() <-chan *sip.Request { return nil }
+
+// newTestRequest 构造来自source的请求，toTag非空时为对话内请求
+func newTestRequest(t *testing.T, method sip.RequestMethod, source, toTag string, extra ...string) *sip.Request {
+	t.Helper()
+	to := "<sip:1000@example.com>"
+	if toTag != "" {
+		to += ";tag=" + toTag
+	}
+	raw := fmt.Sprintf("%s sip:1000@example.com SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP %s;branch=z9hG4bK-%d\r\n"+
+		"From: <sip:1001@example.com>;tag=caller\r\n"+
+		"To: %s\r\n"+
+		"Call-ID: call-%d@example.com\r\n"+
+		"CSeq: 1 %s\r\n"+
+		"Max-Forwards: 70\r\n", method, source, time.Now().UnixNano(), to, time.Now().UnixNano(), method)
+	for _, header := range extra {
+		raw += header + "\r\n"
+	}
+	msg, err := sip.ParseMessage([]byte(raw + "Content-Length: 0\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("parse request: %v", err)
+	}
+	req := msg.(*sip.Request)
+	req.SetSource(source)
+	return req
+}
+
+// useGlobalConfig 测试期间替换全局配置
+func useGlobalConfig(t *testing.T, cfg *config.Config) {
+	t.Helper()
+	previous := config.GlobalConfig
+	config.GlobalConfig = cfg
+	t.Cleanup(func() { config.GlobalConfig = previous })
+}
+
+func TestSIPRateLimiterAllow(t *testing.T) {
+	type attempt struct {
+		at        time.Duration // 相对第一次请求的时间
+		ok        bool
+		first     bool
+		wait      time.Duration
+		otherHost bool
+	}
+	tests := []struct {
+		name     string
+		rps      float64
+		burst    int
+		attempts []attempt
+	}{
+		{
+			name: "burst then limited",
+			rps:  1, burst: 3,
+			attempts: []attempt{
+				{at: 0, ok: true},
+				{at: 0, ok: true},
+				{at: 0, ok: true},
+				{at: 0, first: true, wait: time.Second},
+				{at: 500 * time.Millisecond, wait: 500 * time.Millisecond},
+			},
+		},
+		{
+			name: "refill at rate",
+			rps:  2, burst: 1,
+			attempts: []attempt{
+				{at: 0, ok: true},
+				{at: 100 * time.Millisecond, first: true, wait: 400 * time.Millisecond},
+				{at: 500 * time.Millisecond, ok: true},
+				{at: 600 * time.Millisecond, first: true, wait: 400 * time.Millisecond},
+			},
+		},
+		{
+			name: "refill capped at burst",
+			rps:  10, burst: 2,
+			attempts: []attempt{
+				{at: 0, ok: true},
+				{at: 0, ok: true},
+				{at: 10 * time.Second, ok: true},
+				{at: 10 * time.Second, ok: true},
+				{at: 10 * time.Second, first: true, wait: 100 * time.Millisecond},
+			},
+		},
+		{
+			name: "zero burst uses one second of rate",
+			rps:  2,
+			attempts: []attempt{
+				{at: 0, ok: true},
+				{at: 0, ok: true},
+				{at: 0, first: true, wait: 500 * time.Millisecond},
+			},
+		},
+		{
+			name: "sources limited independently",
+			rps:  1, burst: 1,
+			attempts: []attempt{
+				{at: 0, ok: true},
+				{at: 0, first: true, wait: time.Second},
+				{at: 0, ok: true, otherHost: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newSIPRateLimiter()
+			start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+			limiter.lastSweep = start
+			for i, a := range tt.attempts {
+				key := rateLimitKey{sip.INVITE, "10.0.0.1"}
+				if a.otherHost {
+					key.ip = "10.0.0.2"
+				}
+				ok, first, wait := limiter.allow(key, tt.rps, tt.burst, start.Add(a.at))
+				if ok != a.ok || first != a.first {
+					t.Fatalf("attempt %d: allow() ok=%v first=%v, want ok=%v first=%v", i, ok, first, a.ok, a.first)
+				}
+				if diff := wait - a.wait; diff < -time.Millisecond || diff > time.Millisecond {
+					t.Errorf("attempt %d: wait = %v, want %v", i, wait, a.wait)
+				}
+			}
+		})
+	}
+}
+
+func TestSIPRateLimiterSweep(t *testing.T) {
+	useGlobalConfig(t, &config.Config{Middleware: config.MiddlewareConfig{
+		EnableSIPRateLimit: true,
+		SIPRateLimit:       config.SIPRateLimitConfig{InviteRPS: 1, InviteBurst: 2},
+	}})
+	limiter := newSIPRateLimiter()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter.lastSweep = start
+	full := rateLimitKey{sip.INVITE, "10.0.0.1"}
+	empty := rateLimitKey{sip.INVITE, "10.0.0.2"}
+	limiter.allow(full, 1, 2, start)
+	for i := 0; i < 3; i++ {
+		limiter.allow(empty, 1, 2, start.Add(rateLimitSweepInterval-time.Second))
+	}
+
+	// full已回满被清理，empty仍欠令牌保留
+	limiter.allow(rateLimitKey{sip.INVITE, "10.0.0.3"}, 1, 2, start.Add(rateLimitSweepInterval))
+	if _, exists := limiter.buckets[full]; exists {
+		t.Error("refilled bucket was not swept")
+	}
+	if _, exists := limiter.buckets[empty]; !exists {
+		t.Error("limited bucket was swept")
+	}
+}
+
+func TestLimitRequestRate(t *testing.T) {
+	useGlobalConfig(t, &config.Config{Middleware: config.MiddlewareConfig{
+		EnableSIPRateLimit: true,
+		SIPRateLimit: config.SIPRateLimitConfig{
+			RegisterRPS: 0.5, RegisterBurst: 2,
+			InviteRPS: 0.25, InviteBurst: 1,
+		},
+	}})
+
+	type request struct {
+		method sip.RequestMethod
+		source string
+		toTag  string
+		status sip.StatusCode // 0表示交给下一个处理器
+		retry  string         // 503的Retry-After
+	}
+	tests := []struct {
+		name     string
+		requests []request
+	}{
+		{
+			name: "register burst then 503",
+			requests: []request{
+				{method: sip.REGISTER, source: "10.0.0.1:5060"},
+				{method: sip.REGISTER, source: "10.0.0.1:5060"},
+				{method: sip.REGISTER, source: "10.0.0.1:5060", status: 503, retry: "2"},
+				{method: sip.REGISTER, source: "10.0.0.1:5061", status: 503, retry: "2"},
+				{method: sip.REGISTER, source: "10.0.0.2:5060"},
+			},
+		},
+		{
+			name: "invite retry after rounds up",
+			requests: []request{
+				{method: sip.INVITE, source: "10.0.0.1:5060"},
+				{method: sip.INVITE, source: "10.0.0.1:5060", status: 503, retry: "4"},
+			},
+		},
+		{
+			name: "methods limited separately",
+			requests: []request{
+				{method: sip.INVITE, source: "10.0.0.1:5060"},
+				{method: sip.REGISTER, source: "10.0.0.1:5060"},
+				{method: sip.INVITE, source: "10.0.0.1:5060", status: 503, retry: "4"},
+			},
+		},
+		{
+			name: "in-dialog requests bypass",
+			requests: []request{
+				{method: sip.INVITE, source: "10.0.0.1:5060"},
+				{method: sip.INVITE, source: "10.0.0.1:5060", toTag: "callee"},
+				{method: sip.INVITE, source: "10.0.0.1:5060", toTag: "callee"},
+				{method: sip.INVITE, source: "10.0.0.1:5060", status: 503, retry: "4"},
+			},
+		},
+		{
+			name: "other methods not limited",
+			requests: []request{
+				{method: sip.OPTIONS, source: "10.0.0.1:5060"},
+				{method: sip.OPTIONS, source: "10.0.0.1:5060"},
+				{method: sip.OPTIONS, source: "10.0.0.1:5060"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &SipServer{rateLimiter: newSIPRateLimiter()}
+			passed := 0
+			handler := server.limitRequestRate(func(req *sip.Request, tx sip.ServerTransaction) { passed++ })
+			for i, r := range tt.requests {
+				tx := newRecordingTx()
+				before := passed
+				handler(newTestRequest(t, r.method, r.source, r.toTag), tx)
+
+				if r.status == 0 {
+					if passed != before+1 || len(tx.responses) != 0 {
+						t.Fatalf("request %d: expected to pass, got responses %v", i, tx.responses)
+					}
+					continue
+				}
+				if passed != before || len(tx.responses) != 1 {
+					t.Fatalf("request %d: expected a %d response, passed=%v responses=%d", i, r.status, passed != before, len(tx.responses))
+				}
+				res := tx.responses[0]
+				if res.StatusCode != r.status {
+					t.Errorf("request %d: status = %d, want %d", i, res.StatusCode, r.status)
+				}
+				header := res.GetHeader("Retry-After")
+				if header == nil || header.Value() != r.retry {
+					t.Errorf("request %d: Retry-After = %v, want %s", i, header, r.retry)
+				}
+			}
+		})
+	}
+}
+
+func TestLimitRequestRateDisabled(t *testing.T) {
+	useGlobalConfig(t, &config.Config{Middleware: config.MiddlewareConfig{
+		SIPRateLimit: config.SIPRateLimitConfig{InviteRPS: 1, InviteBurst: 1},
+	}})
+	server := &SipServer{rateLimiter: newSIPRateLimiter()}
+	passed := 0
+	handler := server.limitRequestRate(func(req *sip.Request, tx sip.ServerTransaction) { passed++ })
+	for i := 0; i < 5; i++ {
+		handler(newTestRequest(t, sip.INVITE, "10.0.0.1:5060", ""), newRecordingTx())
+	}
+	if passed != 5 {
+		t.Errorf("passed = %d, want 5 when rate limiting is disabled", passed)
+	}
+}
@@ -0,0 +1,403 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// sipgo没有定义的状态码
+	statusAccepted       sip.StatusCode = 202
+	statusRequestPending sip.StatusCode = 491
+
+	// referRingTimeout 收到REFER后呼叫转接目标的最长振铃时间
+	referRingTimeout = 30 * time.Second
+	// referSubscriptionExpires REFER隐式订阅的有效期（秒）
+	referSubscriptionExpires = 60
+)
+
+// referral 收到的REFER转接请求
+type referral struct {
+	dialog   *CallDialog
+	session  *ScriptSession
+	target   sip.Uri // Refer-To去掉内嵌头部后的地址
+	replaces string  // 咨询转接时Refer-To内嵌的Replaces
+	referee  string  // Referred-By
+	serverIP string
+	event    string // NOTIFY的Event，带REFER的CSeq作为id
+	notify   bool   // Refer-Sub: false时不发送NOTIFY（RFC 4488）
+}
+
+// parseReferTo 解析Refer-To，只接受一个sip/sips地址；内嵌的Replaces头部单独返回
+func parseReferTo(req *sip.Request) (sip.Uri, string, error) {
+	headers := req.GetHeaders("Refer-To")
+	if len(headers) != 1 {
+		return sip.Uri{}, "", fmt.Errorf("expected one Refer-To header, got %d", len(headers))
+	}
+	var target sip.Uri
+	if _, err := parseAddressValue(headers[0].Value(), &target, sip.NewParams()); err != nil {
+		return sip.Uri{}, "", fmt.Errorf("invalid Refer-To %q: %w", headers[0].Value(), err)
+	}
+	if target.Wildcard || target.Host == "" || strings.HasPrefix(strings.ToLower(headers[0].Value()), "<tel:") {
+		return sip.Uri{}, "", fmt.Errorf("unsupported Refer-To %q", headers[0].Value())
+	}
+	if method, ok := target.UriParams.Get("method"); ok && !strings.EqualFold(method, string(sip.INVITE)) {
+		return sip.Uri{}, "", fmt.Errorf("unsupported Refer-To method %q", method)
+	}
+
+	var replaces string
+	for key, value := range target.Headers {
+		if !strings.EqualFold(key, "Replaces") {
+			continue
+		}
+		unescaped, err := url.PathUnescape(value)
+		if err != nil {
+			return sip.Uri{}, "", fmt.Errorf("invalid Replaces in Refer-To: %w", err)
+		}
+		replaces = unescaped
+	}
+	target.Headers = nil
+	return target, replaces, nil
+}
+
+// handleRefer 处理对话内收到的REFER（RFC 3515）：校验Refer-To后回复202，呼叫转接目标建立新通话并用NOTIFY上报进度。
+// 新通话接通后AI会话迁移过去，原通话挂断；Refer-To带Replaces时为咨询转接，Replaces随INVITE发给目标
+func (as *SipServer) handleRefer(req *sip.Request, tx sip.ServerTransaction) {
+	callID := req.CallID().Value()
+	respond := func(code sip.StatusCode, reason string) {
+		if err := tx.Respond(sip.NewResponseFromRequest(req, code, reason, nil)); err != nil {
+			logger.Error("Failed to send REFER response", zap.String("call_id", callID), zap.Error(err))
+		}
+	}
+
+	dialog, exists := as.getDialog(callID)
+	if tag, _ := req.To().Params.Get("tag"); !exists || tag != dialog.LocalTag {
+		respond(sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist")
+		return
+	}
+	target, replaces, err := parseReferTo(req)
+	if err != nil {
+		logger.Warn("Rejecting REFER", zap.String("call_id", callID), zap.Error(err))
+		respond(sip.StatusBadRequest, "Bad Refer-To")
+		return
+	}
+	var session *ScriptSession
+	if as.aiEngine != nil {
+		session = as.aiEngine.GetSession(callID)
+	}
+	if session == nil {
+		logger.Warn("Rejecting REFER without AI session", zap.String("call_id", callID))
+		respond(sip.StatusForbidden, "Forbidden")
+		return
+	}
+	// 转接目标同样受拨号规则限制
+	if as.trunkManager != nil && target.User != "" {
+		if err := as.trunkManager.CheckDestination(0, target.User); err != nil {
+			logger.Warn("REFER target blocked by dial policy",
+				zap.String("call_id", callID),
+				zap.String("refer_to", target.String()),
+				zap.Error(err))
+			respond(sip.StatusForbidden, "Forbidden")
+			return
+		}
+	}
+	if target.User != "" && !as.IsUserReachable(target.User) {
+		respond(sip.StatusTemporarilyUnavailable, "Temporarily Unavailable")
+		return
+	}
+	if !as.startReferral(callID) {
+		respond(statusRequestPending, "Request Pending")
+		return
+	}
+
+	ref := &referral{
+		dialog:   dialog,
+		session:  session,
+		target:   target,
+		replaces: replaces,
+		serverIP: getServerIPFromRequest(req),
+		event:    "refer",
+		notify:   true,
+	}
+	if referredBy := req.GetHeader("Referred-By"); referredBy != nil {
+		ref.referee = referredBy.Value()
+	}
+	if cseq := req.CSeq(); cseq != nil {
+		ref.event = fmt.Sprintf("refer;id=%d", cseq.SeqNo)
+	}
+
+	res := sip.NewResponseFromRequest(req, statusAccepted, "Accepted", nil)
+	if referSub := req.GetHeader("Refer-Sub"); referSub != nil && strings.EqualFold(strings.TrimSpace(referSub.Value()), "false") {
+		ref.notify = false
+		res.AppendHeader(sip.NewHeader("Refer-Sub", "false"))
+	}
+	if err := tx.Respond(res); err != nil {
+		logger.Error("Failed to send REFER response", zap.String("call_id", callID), zap.Error(err))
+		as.finishReferral(callID)
+		return
+	}
+
+	logger.Info("REFER accepted",
+		zap.String("call_id", callID),
+		zap.String("refer_to", target.String()),
+		zap.Bool("attended", replaces != ""))
+	go as.runReferral(ref)
+}
+
+// startReferral 登记通话上进行中的REFER，同一通话同时只处理一个
+func (as *SipServer) startReferral(callID string) bool {
+	as.transfersMutex.Lock()
+	defer as.transfersMutex.Unlock()
+	if as.referrals[callID] {
+		return false
+	}
+	as.referrals[callID] = true
+	return true
+}
+
+func (as *SipServer) finishReferral(callID string) {
+	as.transfersMutex.Lock()
+	defer as.transfersMutex.Unlock()
+	delete(as.referrals, callID)
+}
+
+// runReferral 呼叫转接目标，接通后迁移AI会话并挂断原通话；失败时原通话和AI会话保持不变
+func (as *SipServer) runReferral(ref *referral) {
+	callID := ref.dialog.CallID
+	defer as.finishReferral(callID)
+
+	as.notifyReferral(ref, 100, "Trying", false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), referRingTimeout)
+	answer, err := as.inviteReferTarget(ctx, ref)
+	cancel()
+	if err != nil {
+		code, reason := referFailureStatus(err)
+		logger.Warn("Referred transfer failed",
+			zap.String("call_id", callID),
+			zap.String("refer_to", ref.target.String()),
+			zap.Error(err))
+		as.notifyReferral(ref, code, reason, true)
+		return
+	}
+
+	newCallID := answer.Dialog.CallID
+	rtpAddr, err := referAnswerRTPAddr(answer.Response)
+	if err == nil {
+		err = as.migrateCall(callID, newCallID, rtpAddr)
+	}
+	if err != nil {
+		// 新通话无法接管AI会话，挂断新通话，原通话继续
+		logger.Warn("Failed to move AI session to transferred call",
+			zap.String("call_id", callID),
+			zap.String("new_call_id", newCallID),
+			zap.Error(err))
+		if err := as.sendBye(newCallID); err != nil {
+			logger.Warn("Failed to hang up transferred call", zap.String("call_id", newCallID), zap.Error(err))
+		}
+		as.notifyReferral(ref, sip.StatusServiceUnavailable, "Service Unavailable", true)
+		return
+	}
+
+	as.saveReferredCall(ref.dialog.LocalURI, ref.serverIP, answer, rtpAddr, fmt.Sprintf("transferred from %s", callID))
+	ref.session.addMessage("system", fmt.Sprintf("Transferred by REFER to %s", ref.target.String()), "")
+	logger.Info("Referred transfer completed",
+		zap.String("call_id", callID),
+		zap.String("new_call_id", newCallID),
+		zap.String("refer_to", ref.target.String()),
+		zap.String("referred_by", ref.referee))
+
+	// 先上报成功再挂断原通话，转接方也可能自己发送BYE
+	as.notifyReferral(ref, sip.StatusOK, "OK", true)
+	as.hangupCall(callID)
+}
+
+// inviteReferTarget 呼叫转接目标：注册用户向其所有联系地址分叉呼叫，其余地址直接发送INVITE；振铃时长由ctx控制
+func (as *SipServer) inviteReferTarget(ctx context.Context, ref *referral) (*ForkAnswer, error) {
+	return as.inviteTarget(ctx, ref.target, func(recipient sip.Uri) *sip.Request {
+		return as.newReferInvite(ref, recipient)
+	})
+}
+
+// inviteTarget 呼叫target：本机注册用户向其所有联系地址分叉呼叫，其余地址直接发送newInvite创建的INVITE
+func (as *SipServer) inviteTarget(ctx context.Context, target sip.Uri, newInvite func(recipient sip.Uri) *sip.Request) (*ForkAnswer, error) {
+	local := as.localSentBy()
+	if target.User != "" && (local[strings.ToLower(strings.Trim(target.Host, "[]"))] || target.Host == as.config.AuthenticationRealm) {
+		answer, err := as.InviteUser(ctx, target.User, func(binding ua.Binding) *sip.Request {
+			recipient := target
+			if err := parseURI(binding.URI, &recipient); err != nil {
+				recipient = target
+			}
+			return newInvite(recipient)
+		})
+		if !errors.Is(err, ErrNoBindings) {
+			return answer, err
+		}
+	}
+
+	invite := newInvite(target)
+	res, err := as.ringBranch(ctx, invite)
+	if err != nil {
+		return nil, err
+	}
+	result := forkResult{invite: invite, res: res}
+	dialog, err := as.confirmBranch(result)
+	if err != nil {
+		return nil, err
+	}
+	as.saveDialog(dialog)
+	as.startOutboundSessionTimer(dialog, res)
+	return &ForkAnswer{Invite: invite, Response: res, Dialog: dialog}, nil
+}
+
+// newReferInvite 创建发往转接目标的INVITE，只提供原通话使用的编码，这样AI会话的音频无需转换
+func (as *SipServer) newReferInvite(ref *referral, recipient sip.Uri) *sip.Request {
+	invite := sip.NewRequest(sip.INVITE, &recipient)
+
+	from := &sip.FromHeader{Address: ref.dialog.LocalURI, Params: sip.NewParams()}
+	from.Params.Add("tag", sip.GenerateTagN(16))
+	to := &sip.ToHeader{Address: ref.target, Params: sip.NewParams()}
+	callID := sip.CallIDHeader(uuid.NewString())
+	invite.AppendHeader(from)
+	invite.AppendHeader(to)
+	invite.AppendHeader(&callID)
+	invite.AppendHeader(&sip.CSeqHeader{SeqNo: 1, MethodName: sip.INVITE})
+	invite.AppendHeader(&sip.ContactHeader{Address: sip.Uri{Host: uriHost(ref.serverIP), Port: as.config.Port}})
+	if ref.referee != "" {
+		invite.AppendHeader(sip.NewHeader("Referred-By", ref.referee))
+	}
+	if ref.replaces != "" {
+		invite.AppendHeader(sip.NewHeader("Replaces", ref.replaces))
+	}
+
+	sdp := []byte(generateSDP(ref.serverIP, as.config.LocalRTPPort, as.getCallCodec(ref.dialog.CallID)))
+	contentType := sip.ContentTypeHeader("application/sdp")
+	invite.AppendHeader(&contentType)
+	invite.SetBody(sdp)
+	return invite
+}
+
+// referAnswerRTPAddr 从转接目标的2xx应答中取RTP地址
+func referAnswerRTPAddr(res *sip.Response) (*net.UDPAddr, error) {
+	addr, err := ParseSDPForRTPAddress(string(res.Body()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SDP answer: %w", err)
+	}
+	return net.ResolveUDPAddr("udp", addr)
+}
+
+// referFailureStatus 转接失败时NOTIFY上报的状态，目标拒绝时原样上报
+func referFailureStatus(err error) (sip.StatusCode, string) {
+	var rejected *inviteRejectedError
+	switch {
+	case errors.As(err, &rejected):
+		return rejected.res.StatusCode, rejected.res.Reason
+	case errors.Is(err, context.DeadlineExceeded):
+		return sip.StatusRequestTimeout, "Request Timeout"
+	default:
+		return sip.StatusServiceUnavailable, "Service Unavailable"
+	}
+}
+
+// migrateCall 把原通话的AI会话、活跃会话、编码、RTCP和并发名额迁移到转接后的新通话
+func (as *SipServer) migrateCall(oldCallID, newCallID string, rtpAddr *net.UDPAddr) error {
+	if !as.aiEngine.migrateSession(oldCallID, newCallID, rtpAddr.String()) {
+		return fmt.Errorf("AI session of %s already ended", oldCallID)
+	}
+	if info, exists := as.config.GetActiveSession(oldCallID); exists {
+		as.config.RemoveActiveSession(oldCallID)
+		info.ClientRTPAddr = rtpAddr
+		as.config.SaveActiveSession(newCallID, info)
+	}
+	codec := as.getCallCodec(oldCallID)
+	as.removeCallCodec(oldCallID)
+	as.saveCallCodec(newCallID, codec)
+	as.quotas.rekey(oldCallID, newCallID)
+	as.stopRTCP(oldCallID)
+	as.startRTCP(newCallID, rtpAddr, codec)
+	return nil
+}
+
+// saveReferredCall 保存转接、桥接或呼叫注册用户建立的新通话的呼出记录，from为INVITE的主叫地址
+func (as *SipServer) saveReferredCall(from sip.Uri, serverIP string, answer *ForkAnswer, rtpAddr *net.UDPAddr, notes string) {
+	now := time.Now()
+	sipCall := &models.SipCall{
+		CallID:        answer.Dialog.CallID,
+		Direction:     models.SipCallDirectionOutbound,
+		Status:        models.SipCallStatusAnswered,
+		FromUsername:  from.User,
+		FromURI:       from.String(),
+		ToUsername:    answer.Dialog.RemoteURI.User,
+		ToURI:         answer.Dialog.RemoteURI.String(),
+		LocalRTPAddr:  net.JoinHostPort(serverIP, strconv.Itoa(as.config.LocalRTPPort)),
+		RemoteRTPAddr: rtpAddr.String(),
+		StartTime:     now,
+		AnswerTime:    &now,
+		Notes:         notes,
+	}
+	if err := as.config.SaveCall(sipCall); err != nil {
+		logger.Error("Failed to save transferred call", zap.String("call_id", sipCall.CallID), zap.Error(err))
+	}
+	as.presenceCallStarted(sipCall)
+}
+
+// notifyReferral 通过NOTIFY上报转接进度（message/sipfrag），final为true时结束订阅
+func (as *SipServer) notifyReferral(ref *referral, code sip.StatusCode, reason string, final bool) {
+	if !ref.notify {
+		return
+	}
+	notify := ref.dialog.NewRequest(sip.NOTIFY)
+	notify.AppendHeader(sip.NewHeader("Event", ref.event))
+	if final {
+		notify.AppendHeader(sip.NewHeader("Subscription-State", "terminated;reason=noresource"))
+	} else {
+		notify.AppendHeader(sip.NewHeader("Subscription-State", fmt.Sprintf("active;expires=%d", referSubscriptionExpires)))
+	}
+	contentType := sip.ContentTypeHeader("message/sipfrag;version=2.0")
+	notify.AppendHeader(&contentType)
+	notify.SetBody([]byte(fmt.Sprintf("SIP/2.0 %d %s\r\n", code, reason)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), as.config.TransactionTimeout)
+	defer cancel()
+
+	tx, err := as.client.TransactionRequest(ctx, notify, as.clientMaxForwards, sipgo.ClientRequestBuild)
+	if err != nil {
+		logger.Warn("Failed to send REFER NOTIFY", zap.String("call_id", ref.dialog.CallID), zap.Error(err))
+		return
+	}
+	defer tx.Terminate()
+
+	for {
+		select {
+		case res := <-tx.Responses():
+			if res.IsProvisional() {
+				continue
+			}
+			if !res.IsSuccess() {
+				logger.Warn("REFER NOTIFY rejected",
+					zap.String("call_id", ref.dialog.CallID),
+					zap.Int("status", int(res.StatusCode)))
+			}
+			return
+		case <-tx.Done():
+			return
+		case <-ctx.Done():
+			logger.Warn("REFER NOTIFY timeout", zap.String("call_id", ref.dialog.CallID))
+			return
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package sip1
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo/sip"
+	"github.com/icholy/digest"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// digestNonceLifetime nonce的有效期，过期后回复stale=true的质询，终端用原密码重新计算
+const digestNonceLifetime = 5 * time.Minute
+
+// digestAuth REGISTER的摘要认证（RFC 3261 22.4），nonce为签发时间加HMAC，无需在服务端保存；
+// 只记录已使用nonce的nc，在nonce有效期内拒绝重放
+type digestAuth struct {
+	secret []byte
+	now    func() time.Time
+
+	mutex     sync.Mutex
+	counts    map[string]nonceCount // nonce -> 已接受的最大nc
+	lastSweep time.Time
+}
+
+// nonceCount 一个nonce已接受的最大nc和签发时间
+type nonceCount struct {
+	nc     int
+	issued time.Time
+}
+
+func newDigestAuth() *digestAuth {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("digest auth: %v", err))
+	}
+	return &digestAuth{secret: secret, now: time.Now, counts: make(map[string]nonceCount)}
+}
+
+// nonce 签发nonce：十六进制的签发时间.随机数.HMAC，随机数使同一秒签发的nonce互不相同，nc才能按nonce计数
+func (d *digestAuth) nonce() string {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		panic(fmt.Sprintf("digest auth: %v", err))
+	}
+	payload := strconv.FormatInt(d.now().Unix(), 16) + "." + hex.EncodeToString(salt)
+	return payload + "." + d.sign(payload)
+}
+
+func (d *digestAuth) sign(payload string) string {
+	mac := hmac.New(sha256.New, d.secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// nonceIssued 返回本实例签发的nonce的签发时间
+func (d *digestAuth) nonceIssued(nonce string) (time.Time, bool) {
+	dot := strings.LastIndexByte(nonce, '.')
+	if dot < 0 || !hmac.Equal([]byte(nonce[dot+1:]), []byte(d.sign(nonce[:dot]))) {
+		return time.Time{}, false
+	}
+	issued, _, _ := strings.Cut(nonce[:dot], ".")
+	seconds, err := strconv.ParseInt(issued, 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+// checkNonce 校验nonce是否由本实例签发，stale表示签名正确但已过期
+func (d *digestAuth) checkNonce(nonce string) (valid, stale bool) {
+	issued, ok := d.nonceIssued(nonce)
+	if !ok {
+		return false, false
+	}
+	if d.now().Sub(issued) > digestNonceLifetime {
+		return false, true
+	}
+	return true, false
+}
+
+// acceptCount 记录通过校验的nc（RFC 2617 3.2.2），nc不大于该nonce已接受的值时是重放；
+// 不带qop的响应没有nc，每个nonce只能使用一次
+func (d *digestAuth) acceptCount(nonce string, nc int) bool {
+	issued, _ := d.nonceIssued(nonce)
+	now := d.now()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if now.Sub(d.lastSweep) > digestNonceLifetime {
+		// 过期的nonce已被checkNonce拒绝，不再需要记录
+		for key, count := range d.counts {
+			if now.Sub(count.issued) > digestNonceLifetime {
+				delete(d.counts, key)
+			}
+		}
+		d.lastSweep = now
+	}
+	if count, seen := d.counts[nonce]; seen && nc <= count.nc {
+		return false
+	}
+	d.counts[nonce] = nonceCount{nc: nc, issued: issued}
+	return true
+}
+
+// challenge 回复401和WWW-Authenticate质询
+func (d *digestAuth) challenge(req *sip.Request, tx sip.ServerTransaction, realm string, stale bool) {
+	chal := &digest.Challenge{Realm: realm, Nonce: d.nonce(), Algorithm: "MD5", QOP: []string{"auth"}, Stale: stale}
+	res := sip.NewResponseFromRequest(req, sip.StatusUnauthorized, "Unauthorized", nil)
+	res.AppendHeader(sip.NewHeader("WWW-Authenticate", chal.String()))
+	if err := tx.Respond(res); err != nil {
+		logger.Error("Failed to send 401 response", zap.Error(err))
+	}
+}
+
+// errDigestMismatch 摘要响应与用户的凭据不符
+var errDigestMismatch = errors.New("digest response mismatch")
+
+// verifyDigest 按RFC 2617校验Authorization中的response，ha1为用户在realm下的MD5(username:realm:password)
+func verifyDigest(cred *digest.Credentials, method sip.RequestMethod, ha1 string) error {
+	if cred.Algorithm != "" && !strings.EqualFold(cred.Algorithm, "MD5") {
+		return fmt.Errorf("unsupported digest algorithm %s", cred.Algorithm)
+	}
+	ha2 := md5Hex(method.String() + ":" + cred.URI)
+	var expected string
+	switch cred.QOP {
+	case "":
+		expected = md5Hex(ha1 + ":" + cred.Nonce + ":" + ha2)
+	case "auth":
+		expected = md5Hex(fmt.Sprintf("%s:%s:%08x:%s:%s:%s", ha1, cred.Nonce, cred.Nc, cred.Cnonce, cred.QOP, ha2))
+	default:
+		return fmt.Errorf("unsupported digest qop %s", cred.QOP)
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.ToLower(cred.Response)), []byte(expected)) != 1 {
+		return errDigestMismatch
+	}
+	return nil
+}
+
+func md5Hex(value string) string {
+	sum := md5.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// authorizeRegister 校验REGISTER的Authorization，没有、nonce过期或重放时回复401质询；
+// 用户不存在、已停用、没有可用凭据或响应不符时回复403。未配置数据库时无法校验凭据，回复503
+func (as *SipServer) authorizeRegister(req *sip.Request, tx sip.ServerTransaction, username string) bool {
+	db := as.config.Db
+	if db == nil {
+		logger.Warn("REGISTER rejected, no database to check credentials", zap.String("username", username), zap.String("source", req.Source()))
+		if err := tx.Respond(sip.NewResponseFromRequest(req, sip.StatusServiceUnavailable, "Service Unavailable", nil)); err != nil {
+			logger.Error("Failed to send 503 response", zap.Error(err))
+		}
+		return false
+	}
+	realm := as.config.AuthenticationRealm
+	header := req.GetHeader("Authorization")
+	if header == nil {
+		as.digest.challenge(req, tx, realm, false)
+		return false
+	}
+	cred, err := digest.ParseCredentials(header.Value())
+	if err != nil || cred.Realm != realm {
+		logger.Warn("REGISTER with invalid Authorization", zap.String("username", username), zap.String("source", req.Source()))
+		as.digest.challenge(req, tx, realm, false)
+		return false
+	}
+	if valid, stale := as.digest.checkNonce(cred.Nonce); !valid {
+		as.digest.challenge(req, tx, realm, stale)
+		return false
+	}
+
+	err = as.checkRegisterCredentials(db, req, cred, username, realm)
+	if err == nil {
+		if !as.digest.acceptCount(cred.Nonce, cred.Nc) {
+			logger.Warn("REGISTER with replayed nonce count",
+				zap.String("username", username),
+				zap.String("source", req.Source()),
+				zap.Int("nc", cred.Nc))
+			as.digest.challenge(req, tx, realm, true)
+			return false
+		}
+		return true
+	}
+	logger.Warn("REGISTER authentication failed",
+		zap.String("username", username),
+		zap.String("source", req.Source()),
+		zap.Error(err))
+	if err := tx.Respond(sip.NewResponseFromRequest(req, sip.StatusForbidden, "Forbidden", nil)); err != nil {
+		logger.Error("Failed to send 403 response", zap.Error(err))
+	}
+	return false
+}
+
+// checkRegisterCredentials 摘要用户名须与注册的用户一致，并与用户在realm下的HA1匹配
+func (as *SipServer) checkRegisterCredentials(db *gorm.DB, req *sip.Request, cred *digest.Credentials, username, realm string) error {
+	if cred.Username != username {
+		return fmt.Errorf("digest username %q does not match %q", cred.Username, username)
+	}
+	user, err := models.GetSipUserByUsername(db, username)
+	if err != nil {
+		return fmt.Errorf("user not found: %w", err)
+	}
+	if !user.Enabled {
+		return errors.New("user is disabled")
+	}
+	ha1, ok := user.DigestCredentials(realm)
+	if !ok {
+		return fmt.Errorf("user has no password for realm %s", realm)
+	}
+	return verifyDigest(cred, req.Method, ha1)
+}
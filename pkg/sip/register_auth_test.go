@@ -0,0 +1,206 @@
+package sip1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+	"github.com/emiago/sipgo/sip"
+	"github.com/glebarez/sqlite"
+	"github.com/icholy/digest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testRealm    = "test-realm"
+	testUsername = "1000"
+	testPassword = "s3cret-pass"
+)
+
+// newRegisterAuthServer 带一个设置了密码的SIP用户的服务器
+func newRegisterAuthServer(t *testing.T) *SipServer {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.SipUser{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	user := &models.SipUser{Username: testUsername, Enabled: true}
+	if err := user.SetPassword(testPassword, testRealm); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	if err := models.CreateSipUser(db, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return &SipServer{config: &ua.UAConfig{Db: db, AuthenticationRealm: testRealm}, digest: newDigestAuth()}
+}
+
+// challengeOf 取401响应中的质询
+func challengeOf(t *testing.T, res *sip.Response) *digest.Challenge {
+	t.Helper()
+	header := res.GetHeader("WWW-Authenticate")
+	if header == nil {
+		t.Fatal("401 without WWW-Authenticate")
+	}
+	chal, err := digest.ParseChallenge(header.Value())
+	if err != nil {
+		t.Fatalf("parse challenge: %v", err)
+	}
+	return chal
+}
+
+// authorization 按质询计算Authorization头
+func authorization(t *testing.T, chal *digest.Challenge, username, password string) string {
+	t.Helper()
+	cred, err := digest.Digest(chal, digest.Options{Method: "REGISTER", URI: "sip:1000@example.com", Username: username, Password: password})
+	if err != nil {
+		t.Fatalf("digest: %v", err)
+	}
+	return "Authorization: " + cred.String()
+}
+
+func TestAuthorizeRegister(t *testing.T) {
+	server := newRegisterAuthServer(t)
+	tx := newRecordingTx()
+	if server.authorizeRegister(newTestRequest(t, sip.REGISTER, "10.0.0.1:5060", ""), tx, testUsername) {
+		t.Fatal("REGISTER without Authorization was accepted")
+	}
+	if len(tx.responses) != 1 || tx.responses[0].StatusCode != sip.StatusUnauthorized {
+		t.Fatalf("responses = %v, want one 401", tx.responses)
+	}
+	chal := challengeOf(t, tx.responses[0])
+	if chal.Realm != testRealm || chal.Nonce == "" || !chal.SupportsQOP("auth") || chal.Stale {
+		t.Fatalf("challenge = %+v", chal)
+	}
+
+	other := newDigestAuth()
+	tests := []struct {
+		name     string
+		header   string
+		username string
+		status   sip.StatusCode // 0表示通过
+	}{
+		{"valid credentials", authorization(t, chal, testUsername, testPassword), testUsername, 0},
+		{"no qop", authorization(t, &digest.Challenge{Realm: testRealm, Nonce: server.digest.nonce()}, testUsername, testPassword), testUsername, 0},
+		{"wrong password", authorization(t, chal, testUsername, "wrong-pass"), testUsername, sip.StatusForbidden},
+		{"digest user differs from From user", authorization(t, chal, testUsername, testPassword), "1001", sip.StatusForbidden},
+		{"unknown user", authorization(t, chal, "1001", testPassword), "1001", sip.StatusForbidden},
+		{"other realm", authorization(t, &digest.Challenge{Realm: "other", Nonce: chal.Nonce, QOP: []string{"auth"}}, testUsername, testPassword), testUsername, sip.StatusUnauthorized},
+		{"nonce not issued by server", authorization(t, &digest.Challenge{Realm: testRealm, Nonce: other.nonce(), QOP: []string{"auth"}}, testUsername, testPassword), testUsername, sip.StatusUnauthorized},
+		{"malformed header", "Authorization: Basic MTAwMDpzZWNyZXQ=", testUsername, sip.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newRecordingTx()
+			ok := server.authorizeRegister(newTestRequest(t, sip.REGISTER, "10.0.0.1:5060", "", tt.header), tx, tt.username)
+			if tt.status == 0 {
+				if !ok || len(tx.responses) != 0 {
+					t.Fatalf("expected to pass, got responses %v", tx.responses)
+				}
+				return
+			}
+			if ok || len(tx.responses) != 1 {
+				t.Fatalf("expected a %d response, ok=%v responses=%v", tt.status, ok, tx.responses)
+			}
+			if res := tx.responses[0]; res.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestAuthorizeRegisterStaleNonce(t *testing.T) {
+	server := newRegisterAuthServer(t)
+	issued := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := issued
+	server.digest.now = func() time.Time { return now }
+	header := authorization(t, &digest.Challenge{Realm: testRealm, Nonce: server.digest.nonce(), QOP: []string{"auth"}}, testUsername, testPassword)
+
+	now = issued.Add(digestNonceLifetime + time.Second)
+	tx := newRecordingTx()
+	if server.authorizeRegister(newTestRequest(t, sip.REGISTER, "10.0.0.1:5060", "", header), tx, testUsername) {
+		t.Fatal("expired nonce was accepted")
+	}
+	if len(tx.responses) != 1 || tx.responses[0].StatusCode != sip.StatusUnauthorized {
+		t.Fatalf("responses = %v, want one 401", tx.responses)
+	}
+	if chal := challengeOf(t, tx.responses[0]); !chal.Stale {
+		t.Error("expired nonce challenge without stale=true")
+	}
+}
+
+func TestAuthorizeRegisterReplay(t *testing.T) {
+	server := newRegisterAuthServer(t)
+	chal := &digest.Challenge{Realm: testRealm, Nonce: server.digest.nonce(), Algorithm: "MD5", QOP: []string{"auth"}}
+	withCount := func(nc int) string {
+		cred, err := digest.Digest(chal, digest.Options{Method: "REGISTER", URI: "sip:1000@example.com", Username: testUsername, Password: testPassword, Count: nc})
+		if err != nil {
+			t.Fatalf("digest: %v", err)
+		}
+		return "Authorization: " + cred.String()
+	}
+	noQOP := authorization(t, &digest.Challenge{Realm: testRealm, Nonce: server.digest.nonce()}, testUsername, testPassword)
+	first := withCount(1)
+
+	tests := []struct {
+		name   string
+		header string
+		ok     bool
+	}{
+		{"first use", first, true},
+		{"same nc replayed", first, false},
+		{"next nc", withCount(2), true},
+		{"lower nc", withCount(1), false},
+		{"skipped nc", withCount(5), true},
+		{"no qop first use", noQOP, true},
+		{"no qop nonce reused", noQOP, false},
+	}
+	for _, tt := range tests {
+		tx := newRecordingTx()
+		ok := server.authorizeRegister(newTestRequest(t, sip.REGISTER, "10.0.0.1:5060", "", tt.header), tx, testUsername)
+		if ok != tt.ok {
+			t.Fatalf("%s: accepted = %v, want %v (responses %v)", tt.name, ok, tt.ok, tx.responses)
+		}
+		if !ok {
+			// 重放的请求得到新的质询，终端可以直接用新nonce重试
+			if len(tx.responses) != 1 || tx.responses[0].StatusCode != sip.StatusUnauthorized || !challengeOf(t, tx.responses[0]).Stale {
+				t.Errorf("%s: responses = %v, want a stale 401 challenge", tt.name, tx.responses)
+			}
+		}
+	}
+
+	if a, b := server.digest.nonce(), server.digest.nonce(); a == b {
+		t.Errorf("nonces issued in the same second are equal: %s", a)
+	}
+}
+
+func TestAuthorizeRegisterDisabledUser(t *testing.T) {
+	server := newRegisterAuthServer(t)
+	if err := server.config.Db.Model(&models.SipUser{}).Where("username = ?", testUsername).Update("enabled", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	header := authorization(t, &digest.Challenge{Realm: testRealm, Nonce: server.digest.nonce(), QOP: []string{"auth"}}, testUsername, testPassword)
+	tx := newRecordingTx()
+	if server.authorizeRegister(newTestRequest(t, sip.REGISTER, "10.0.0.1:5060", "", header), tx, testUsername) {
+		t.Fatal("disabled user was allowed to register")
+	}
+	if len(tx.responses) != 1 || tx.responses[0].StatusCode != sip.StatusForbidden {
+		t.Errorf("responses = %v, want one 403", tx.responses)
+	}
+}
+
+func TestAuthorizeRegisterWithoutDatabase(t *testing.T) {
+	server := &SipServer{config: &ua.UAConfig{AuthenticationRealm: testRealm}, digest: newDigestAuth()}
+	tx := newRecordingTx()
+	if server.authorizeRegister(newTestRequest(t, sip.REGISTER, "10.0.0.1:5060", ""), tx, testUsername) {
+		t.Fatal("REGISTER accepted without a database to check credentials")
+	}
+	if len(tx.responses) != 1 || tx.responses[0].StatusCode != sip.StatusServiceUnavailable {
+		t.Errorf("responses = %v, want one 503", tx.responses)
+	}
+}
@@ -0,0 +1,18 @@
+package sip1
+
+import (
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRegistrationAPIs 注册在线注册接口：GET /registrations?user=alice 列出已注册的Contact及OPTIONS探测的可达性，
+// user为空时列出全部用户
+func RegisterRegistrationAPIs(r gin.IRoutes, server *SipServer) {
+	r.GET("/registrations", func(c *gin.Context) {
+		contacts := server.ListRegisteredUsers(c.Query("user"))
+		if contacts == nil {
+			contacts = []ContactReachability{}
+		}
+		response.Success(c, "ok", contacts)
+	})
+}
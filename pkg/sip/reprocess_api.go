@@ -0,0 +1,155 @@
+package sip1
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/auth"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// reprocessDB 返回数据库和路径中的任务ID，失败时已写入响应
+func reprocessDB(c *gin.Context, server *SipServer) (*gorm.DB, uint, bool) {
+	if server.config.Db == nil {
+		response.Fail(c, "database not configured", nil)
+		return nil, 0, false
+	}
+	if c.Param("id") == "" {
+		return server.config.Db, 0, true
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		response.Fail(c, "invalid reprocess job id", err.Error())
+		return nil, 0, false
+	}
+	return server.config.Db, uint(id), true
+}
+
+// RegisterReprocessAPIs 注册重新处理任务接口：POST /reprocess-jobs
+// {"kind":"transcription","from":"2026-01-01T00:00:00+08:00","to":"...","scriptId":0,"apply":false}
+// 对范围内的历史录音重新转录（kind为scoring时对已结束的会话重新评分），apply为false时只记录新旧结果、不修改通话记录，
+// GET /reprocess-jobs?status=running 列出任务，GET /reprocess-jobs/:id 查看进度和平均相似度、新旧评分，
+// GET /reprocess-jobs/:id/results?offset=0&limit=20 查看每条记录的新旧结果，
+// POST /reprocess-jobs/:id/compare {"references":{"callId":"人工校对的文本"}} 计算新旧转录的字错误率，
+// POST /reprocess-jobs/:id/cancel 取消任务，创建和取消均写入审计日志
+func RegisterReprocessAPIs(r gin.IRoutes, server *SipServer) {
+	r.POST("/reprocess-jobs", requireActor, func(c *gin.Context) {
+		if _, _, ok := reprocessDB(c, server); !ok {
+			return
+		}
+		var form struct {
+			Kind     models.ReprocessKind `json:"kind" binding:"required"`
+			From     *time.Time           `json:"from"`
+			To       *time.Time           `json:"to"`
+			ScriptID uint                 `json:"scriptId"`
+			Apply    bool                 `json:"apply"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		job := &models.ReprocessJob{
+			Kind:      form.Kind,
+			From:      form.From,
+			To:        form.To,
+			ScriptID:  form.ScriptID,
+			Apply:     form.Apply,
+			CreatedBy: auth.CurrentActor(c),
+		}
+		if err := server.StartReprocessJob(job); err != nil {
+			response.Fail(c, "failed to start reprocess job", err.Error())
+			return
+		}
+		response.Success(c, "ok", job)
+	})
+
+	r.GET("/reprocess-jobs", func(c *gin.Context) {
+		db, _, ok := reprocessDB(c, server)
+		if !ok {
+			return
+		}
+		jobs, err := models.ListReprocessJobs(db, models.ReprocessJobStatus(c.Query("status")))
+		if err != nil {
+			response.Fail(c, "failed to list reprocess jobs", err.Error())
+			return
+		}
+		response.Success(c, "ok", jobs)
+	})
+
+	r.GET("/reprocess-jobs/:id", func(c *gin.Context) {
+		db, id, ok := reprocessDB(c, server)
+		if !ok {
+			return
+		}
+		job, err := models.GetReprocessJob(db, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			response.Fail(c, "reprocess job not found", nil)
+			return
+		}
+		if err != nil {
+			response.Fail(c, "failed to load reprocess job", err.Error())
+			return
+		}
+		summary, err := models.SummarizeReprocessResults(db, id)
+		if err != nil {
+			response.Fail(c, "failed to summarize reprocess results", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"job": job, "summary": summary})
+	})
+
+	r.GET("/reprocess-jobs/:id/results", func(c *gin.Context) {
+		db, id, ok := reprocessDB(c, server)
+		if !ok {
+			return
+		}
+		offset, _ := strconv.Atoi(c.Query("offset"))
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		if limit <= 0 {
+			limit = sipUserPageSize
+		}
+		results, total, err := models.ListReprocessResults(db, id, max(offset, 0), min(limit, maxSipUserPageSize))
+		if err != nil {
+			response.Fail(c, "failed to list reprocess results", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"items": results, "total": total})
+	})
+
+	r.POST("/reprocess-jobs/:id/compare", func(c *gin.Context) {
+		_, id, ok := reprocessDB(c, server)
+		if !ok {
+			return
+		}
+		var form struct {
+			References map[string]string `json:"references" binding:"required,min=1"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		accuracy, err := server.CompareTranscriptAccuracy(id, form.References)
+		if err != nil {
+			response.Fail(c, "failed to compare transcripts", err.Error())
+			return
+		}
+		response.Success(c, "ok", accuracy)
+	})
+
+	r.POST("/reprocess-jobs/:id/cancel", requireActor, func(c *gin.Context) {
+		_, id, ok := reprocessDB(c, server)
+		if !ok {
+			return
+		}
+		job, err := server.CancelReprocessJob(id, auth.CurrentActor(c))
+		if err != nil {
+			response.Fail(c, "failed to cancel reprocess job", err.Error())
+			return
+		}
+		response.Success(c, "ok", job)
+	})
+}
@@ -0,0 +1,374 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// reprocessBatchSize 每次从数据库读取的待处理记录数
+	reprocessBatchSize = 20
+
+	auditTargetReprocessJob = "reprocess_job"
+)
+
+// reprocessRunner 本实例上运行中的重新处理任务，每个任务一个协程依次处理
+type reprocessRunner struct {
+	mutex sync.Mutex
+	runs  map[uint]context.CancelFunc
+}
+
+func newReprocessRunner() *reprocessRunner {
+	return &reprocessRunner{runs: make(map[uint]context.CancelFunc)}
+}
+
+// start 任务未在运行时返回新的ctx
+func (r *reprocessRunner) start(id uint) (context.Context, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, running := r.runs[id]; running {
+		return nil, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.runs[id] = cancel
+	return ctx, true
+}
+
+// stop 取消任务，正在处理的记录中止且不保存结果；处理协程退出时也调用以移除任务
+func (r *reprocessRunner) stop(id uint) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if cancel, running := r.runs[id]; running {
+		cancel()
+		delete(r.runs, id)
+	}
+}
+
+// StartReprocessJob 创建任务并在后台依次处理范围内的历史录音或会话，如切换ASR服务商后重新转录；
+// Apply为false时只记录新旧结果用于对比
+func (as *SipServer) StartReprocessJob(job *models.ReprocessJob) error {
+	db := as.config.Db
+	if db == nil {
+		return errors.New("database not configured")
+	}
+	if as.aiEngine == nil {
+		return errors.New("AI phone engine not initialized")
+	}
+	switch job.Kind {
+	case models.ReprocessTranscription:
+	case models.ReprocessScoring:
+		if as.aiEngine.services().Assistant == nil {
+			return errors.New("quality scoring requires an assistant")
+		}
+	default:
+		return fmt.Errorf("unknown reprocess kind: %q", job.Kind)
+	}
+	if job.From != nil && job.To != nil && !job.To.After(*job.From) {
+		return errors.New("to must be after from")
+	}
+
+	total, err := models.CountReprocessTargets(db, job)
+	if err != nil {
+		return fmt.Errorf("failed to count records: %w", err)
+	}
+	job.Status = models.ReprocessJobRunning
+	job.Total = total
+	if err := models.CreateReprocessJob(db, job); err != nil {
+		return err
+	}
+	as.auditReprocessJob(job, job.CreatedBy, "reprocess.started", map[string]interface{}{"total": total, "apply": job.Apply})
+	as.runReprocessJob(job)
+	return nil
+}
+
+// CancelReprocessJob 取消运行中的任务，已处理的结果保留
+func (as *SipServer) CancelReprocessJob(id uint, actor string) (*models.ReprocessJob, error) {
+	db := as.config.Db
+	if db == nil {
+		return nil, errors.New("database not configured")
+	}
+	cancelled, err := models.FinishReprocessJob(db, id, models.ReprocessJobCancelled, "")
+	if err != nil {
+		return nil, err
+	}
+	job, err := models.GetReprocessJob(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if !cancelled {
+		return nil, fmt.Errorf("reprocess job %d is %s", id, job.Status)
+	}
+	as.reprocess.stop(id)
+	as.auditReprocessJob(job, actor, "reprocess.cancelled", map[string]interface{}{"processed": job.Processed})
+	return job, nil
+}
+
+// auditReprocessJob 写入任务审计日志，失败只记录日志
+func (as *SipServer) auditReprocessJob(job *models.ReprocessJob, actor, action string, detail map[string]interface{}) {
+	detail["kind"] = job.Kind
+	if err := models.CreateAuditLog(as.config.Db, actor, action, auditTargetReprocessJob, job.ID, detail); err != nil {
+		logger.Error("Failed to write audit log",
+			zap.String("action", action),
+			zap.Uint("job_id", job.ID),
+			zap.Error(err))
+	}
+}
+
+// resumeReprocessJobs 服务启动时从游标继续运行中的任务
+func (as *SipServer) resumeReprocessJobs() {
+	if as.config.Db == nil || as.aiEngine == nil {
+		return
+	}
+	jobs, err := models.ListReprocessJobs(as.config.Db, models.ReprocessJobRunning)
+	if err != nil {
+		logger.Error("Failed to load running reprocess jobs", zap.Error(err))
+		return
+	}
+	for i := range jobs {
+		logger.Info("Resuming reprocess job", zap.Uint("job_id", jobs[i].ID), zap.Int("processed", jobs[i].Processed))
+		as.runReprocessJob(&jobs[i])
+	}
+}
+
+// runReprocessJob 任务未在本实例运行时启动处理协程
+func (as *SipServer) runReprocessJob(job *models.ReprocessJob) {
+	ctx, ok := as.reprocess.start(job.ID)
+	if !ok {
+		return
+	}
+	go func() {
+		defer as.reprocess.stop(job.ID)
+		err := as.processReprocessJob(ctx, job)
+		if ctx.Err() != nil {
+			return
+		}
+		status, reason := models.ReprocessJobCompleted, ""
+		if err != nil {
+			status, reason = models.ReprocessJobFailed, err.Error()
+			logger.Error("Reprocess job failed", zap.Uint("job_id", job.ID), zap.Error(err))
+		}
+		if _, err := models.FinishReprocessJob(as.config.Db, job.ID, status, reason); err != nil {
+			logger.Error("Failed to finish reprocess job", zap.Uint("job_id", job.ID), zap.Error(err))
+			return
+		}
+		logger.Info("Reprocess job finished",
+			zap.Uint("job_id", job.ID),
+			zap.String("status", string(status)),
+			zap.Int("processed", job.Processed),
+			zap.Int("failed", job.Failed),
+			zap.Int("changed", job.Changed))
+	}()
+}
+
+// processReprocessJob 按ID顺序分批处理游标之后的记录，每条处理后保存结果和进度；取消时返回ctx的错误
+func (as *SipServer) processReprocessJob(ctx context.Context, job *models.ReprocessJob) error {
+	db := as.config.Db
+	for {
+		var results []*models.ReprocessResult
+		var cursors []uint
+		switch job.Kind {
+		case models.ReprocessScoring:
+			sessions, err := models.NextReprocessSessions(db, job, reprocessBatchSize)
+			if err != nil {
+				return err
+			}
+			for i := range sessions {
+				if ctx.Err() != nil {
+					break
+				}
+				results = append(results, as.rescoreSession(job, &sessions[i]))
+				cursors = append(cursors, sessions[i].ID)
+			}
+		default:
+			calls, err := models.NextReprocessCalls(db, job, reprocessBatchSize)
+			if err != nil {
+				return err
+			}
+			for i := range calls {
+				result := as.retranscribeCall(ctx, job, &calls[i])
+				if ctx.Err() != nil {
+					break
+				}
+				results = append(results, result)
+				cursors = append(cursors, calls[i].ID)
+			}
+		}
+		if ctx.Err() != nil && len(results) == 0 {
+			return ctx.Err()
+		}
+		if len(results) == 0 {
+			return nil
+		}
+
+		for i, result := range results {
+			result.JobID = job.ID
+			if err := models.CreateReprocessResult(db, result); err != nil {
+				return fmt.Errorf("failed to save result for %s: %w", result.TargetID, err)
+			}
+			job.Processed++
+			job.Cursor = cursors[i]
+			if result.Error != "" {
+				job.Failed++
+			} else if result.OldScore != result.NewScore || normalizeTranscript(result.OldText) != normalizeTranscript(result.NewText) {
+				job.Changed++
+			}
+		}
+		if err := models.UpdateReprocessProgress(db, job); err != nil {
+			return fmt.Errorf("failed to save progress: %w", err)
+		}
+	}
+}
+
+// retranscribeCall 重新转录一通录音并与原转录对比，Apply时保存新转录
+func (as *SipServer) retranscribeCall(ctx context.Context, job *models.ReprocessJob, call *models.SipCall) *models.ReprocessResult {
+	result := &models.ReprocessResult{TargetID: call.CallID, OldText: call.Transcription}
+	path := recordingPath(call.RecordURL)
+	if path == "" {
+		result.Error = ErrNoRecording.Error()
+		return result
+	}
+	ctx, cancel := context.WithTimeout(ctx, diarizeTimeout)
+	defer cancel()
+	segments, err := as.aiEngine.Diarize(ctx, path)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.NewText = segments.Text()
+	result.Similarity = max(0, 1-characterErrorRate(result.OldText, result.NewText))
+
+	if job.Apply {
+		transcription := &models.CallTranscription{Status: models.TranscriptionCompleted, Text: result.NewText, Segments: segments}
+		if err := as.config.SaveTranscription(call.CallID, transcription); err != nil {
+			result.Error = fmt.Sprintf("failed to save transcription: %v", err)
+		}
+	}
+	return result
+}
+
+// rescoreSession 重新评估一个会话的质量，Apply时保存新评分
+func (as *SipServer) rescoreSession(job *models.ReprocessJob, session *models.AIPhoneSession) *models.ReprocessResult {
+	result := &models.ReprocessResult{TargetID: session.SessionID, OldScore: session.QualityScore}
+	score, err := as.aiEngine.scoreConversation(session.Conversation)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.NewScore = score
+	if job.Apply {
+		if err := models.UpdateAIPhoneSessionQualityScore(as.config.Db, session.ID, score); err != nil {
+			result.Error = fmt.Sprintf("failed to save quality score: %v", err)
+		}
+	}
+	return result
+}
+
+// TranscriptAccuracy 一通录音新旧转录相对人工校对文本的字错误率
+type TranscriptAccuracy struct {
+	TargetID     string  `json:"targetId"`
+	OldErrorRate float64 `json:"oldErrorRate"`
+	NewErrorRate float64 `json:"newErrorRate"`
+}
+
+// ReprocessAccuracy 按校对文本总字数加权的新旧字错误率，Calls为有结果的录音
+type ReprocessAccuracy struct {
+	OldErrorRate float64              `json:"oldErrorRate"`
+	NewErrorRate float64              `json:"newErrorRate"`
+	Calls        []TranscriptAccuracy `json:"calls"`
+}
+
+// CompareTranscriptAccuracy 用人工校对的文本（Call-ID到文本）对比任务中新旧转录的准确率
+func (as *SipServer) CompareTranscriptAccuracy(id uint, references map[string]string) (*ReprocessAccuracy, error) {
+	db := as.config.Db
+	if db == nil {
+		return nil, errors.New("database not configured")
+	}
+	job, err := models.GetReprocessJob(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Kind != models.ReprocessTranscription {
+		return nil, fmt.Errorf("reprocess job %d is not a transcription job", id)
+	}
+	targetIDs := make([]string, 0, len(references))
+	for targetID := range references {
+		targetIDs = append(targetIDs, targetID)
+	}
+	results, err := models.GetReprocessResults(db, id, targetIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	accuracy := &ReprocessAccuracy{Calls: make([]TranscriptAccuracy, 0, len(results))}
+	var oldEdits, newEdits, length int
+	for _, result := range results {
+		reference := []rune(normalizeTranscript(references[result.TargetID]))
+		if len(reference) == 0 {
+			continue
+		}
+		oldDistance := editDistance(reference, []rune(normalizeTranscript(result.OldText)))
+		newDistance := editDistance(reference, []rune(normalizeTranscript(result.NewText)))
+		oldEdits, newEdits, length = oldEdits+oldDistance, newEdits+newDistance, length+len(reference)
+		accuracy.Calls = append(accuracy.Calls, TranscriptAccuracy{
+			TargetID:     result.TargetID,
+			OldErrorRate: float64(oldDistance) / float64(len(reference)),
+			NewErrorRate: float64(newDistance) / float64(len(reference)),
+		})
+	}
+	if length > 0 {
+		accuracy.OldErrorRate = float64(oldEdits) / float64(length)
+		accuracy.NewErrorRate = float64(newEdits) / float64(length)
+	}
+	return accuracy, nil
+}
+
+// normalizeTranscript 去掉空白和标点并转为小写，按字对比
+func normalizeTranscript(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, text)
+}
+
+// characterErrorRate 以reference为准计算hypothesis的字错误率，可能大于1；reference为空时hypothesis也为空才是0
+func characterErrorRate(reference, hypothesis string) float64 {
+	ref, hyp := []rune(normalizeTranscript(reference)), []rune(normalizeTranscript(hypothesis))
+	if len(ref) == 0 {
+		if len(hyp) == 0 {
+			return 0
+		}
+		return 1
+	}
+	return float64(editDistance(ref, hyp)) / float64(len(ref))
+}
+
+// editDistance 两段文字的编辑距离（插入、删除、替换各计1）
+func editDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,303 @@
+package sip1
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+)
+
+// blockingAssistant 回复前等待release关闭
+type blockingAssistant struct {
+	reply   string
+	release chan struct{}
+}
+
+func (a *blockingAssistant) Query(text string) (string, error) {
+	<-a.release
+	return a.reply, nil
+}
+func (a *blockingAssistant) Reset() {}
+
+func newReprocessTestServer(t *testing.T, services AIServices) *SipServer {
+	t.Helper()
+	db := newTestDB(t, &models.ReprocessJob{}, &models.ReprocessResult{}, &models.AIPhoneSession{}, &models.SipCall{}, &models.AuditLog{})
+	// 处理协程与测试共用同一个内存数据库
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	config := ua.DefaultUAConfig()
+	config.Db = db
+	config.StorageType = ua.StorageTypeDatabase
+	return &SipServer{config: config, aiEngine: NewAIPhoneEngine(nil, db, services), reprocess: newReprocessRunner()}
+}
+
+// waitReprocessJob 等待任务结束并返回最终状态
+func waitReprocessJob(t *testing.T, server *SipServer, id uint) *models.ReprocessJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := models.GetReprocessJob(server.config.Db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.reprocess.mutex.Lock()
+		_, running := server.reprocess.runs[id]
+		server.reprocess.mutex.Unlock()
+		if job.Status != models.ReprocessJobRunning && !running {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("reprocess job %d still running", id)
+	return nil
+}
+
+func conversation(caller string) models.ConversationHistory {
+	return models.ConversationHistory{{Role: "assistant", Content: "您好，请问有什么可以帮您"}, {Role: "user", Content: caller}}
+}
+
+func TestReprocessScoringJob(t *testing.T) {
+	server := newReprocessTestServer(t, AIServices{Assistant: &fakeAssistant{reply: "```json\n{\"score\": 90}\n```"}})
+	db := server.config.Db
+	ended := time.Date(2026, 1, 5, 10, 0, 0, 0, time.Local)
+	// 超过一批的会话，确认按游标分批处理
+	var sessions []models.AIPhoneSession
+	for i := 0; i < reprocessBatchSize+3; i++ {
+		sessions = append(sessions, models.AIPhoneSession{SessionID: fmt.Sprintf("s-%02d", i), ScriptID: 1, QualityScore: 50, Conversation: conversation("查一下话费")})
+	}
+	sessions = append(sessions,
+		models.AIPhoneSession{SessionID: "unchanged", ScriptID: 1, QualityScore: 90, Conversation: conversation("办理宽带")},
+		models.AIPhoneSession{SessionID: "silent", ScriptID: 1, QualityScore: 40, Conversation: conversation(" ")},
+		models.AIPhoneSession{SessionID: "other-script", ScriptID: 2, Conversation: conversation("你好")},
+		models.AIPhoneSession{SessionID: "in-progress", ScriptID: 1, Conversation: conversation("你好")},
+	)
+	for i := range sessions {
+		sessions[i].StartTime = ended.Add(-time.Minute)
+		if sessions[i].SessionID != "in-progress" {
+			sessions[i].EndTime = &ended
+		}
+		if err := db.Create(&sessions[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	job := &models.ReprocessJob{Kind: models.ReprocessScoring, ScriptID: 1, Apply: true, CreatedBy: "alice"}
+	if err := server.StartReprocessJob(job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Total != int64(reprocessBatchSize+5) {
+		t.Errorf("Total = %d, want %d", job.Total, reprocessBatchSize+5)
+	}
+	done := waitReprocessJob(t, server, job.ID)
+	if done.Status != models.ReprocessJobCompleted || done.Processed != reprocessBatchSize+5 || done.Failed != 1 || done.Changed != reprocessBatchSize+3 || done.CompletedAt == nil {
+		t.Errorf("finished job = %+v", done)
+	}
+
+	var stored models.AIPhoneSession
+	db.Where("session_id = ?", "s-00").First(&stored)
+	if stored.QualityScore != 90 {
+		t.Errorf("applied score = %v, want 90", stored.QualityScore)
+	}
+	var silent models.AIPhoneSession
+	db.Where("session_id = ?", "silent").First(&silent)
+	if silent.QualityScore != 40 {
+		t.Errorf("failed session score changed to %v", silent.QualityScore)
+	}
+	summary, err := models.SummarizeReprocessResults(db, job.ID)
+	if err != nil || summary.Count != int64(reprocessBatchSize+4) || summary.NewScore != 90 {
+		t.Errorf("summary = %+v, %v", summary, err)
+	}
+	if _, err := server.CancelReprocessJob(job.ID, "alice"); err == nil {
+		t.Error("cancelling a completed job expected error")
+	}
+}
+
+func TestReprocessJobDryRunAndCancel(t *testing.T) {
+	assistant := &blockingAssistant{reply: `{"score": 70}`, release: make(chan struct{})}
+	server := newReprocessTestServer(t, AIServices{Assistant: assistant})
+	db := server.config.Db
+	ended := time.Now()
+	for i := 0; i < 3; i++ {
+		session := models.AIPhoneSession{SessionID: fmt.Sprintf("s-%d", i), ScriptID: 1, QualityScore: 60, StartTime: ended, EndTime: &ended, Conversation: conversation("你好")}
+		if err := db.Create(&session).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	job := &models.ReprocessJob{Kind: models.ReprocessScoring, CreatedBy: "alice"}
+	if err := server.StartReprocessJob(job); err != nil {
+		t.Fatal(err)
+	}
+	cancelled, err := server.CancelReprocessJob(job.ID, "bob")
+	if err != nil || cancelled.Status != models.ReprocessJobCancelled {
+		t.Fatalf("CancelReprocessJob() = %+v, %v", cancelled, err)
+	}
+	close(assistant.release)
+	done := waitReprocessJob(t, server, job.ID)
+	// 取消前已开始的记录仍保存结果，之后不再处理，状态保持已取消
+	if done.Status != models.ReprocessJobCancelled || done.Processed > 1 {
+		t.Errorf("cancelled job = %+v", done)
+	}
+	var scores []float32
+	db.Model(&models.AIPhoneSession{}).Pluck("quality_score", &scores)
+	for _, score := range scores {
+		if score != 60 {
+			t.Errorf("dry run changed a score to %v", score)
+		}
+	}
+	var actions []string
+	db.Model(&models.AuditLog{}).Order("id").Pluck("actor", &actions)
+	if strings.Join(actions, ",") != "alice,bob" {
+		t.Errorf("audit actors = %v", actions)
+	}
+}
+
+func TestStartReprocessJobValidates(t *testing.T) {
+	from := time.Now()
+	tests := []struct {
+		name     string
+		services AIServices
+		job      models.ReprocessJob
+	}{
+		{name: "unknown kind", job: models.ReprocessJob{Kind: "summary"}},
+		{name: "scoring without assistant", job: models.ReprocessJob{Kind: models.ReprocessScoring}},
+		{name: "empty range", job: models.ReprocessJob{Kind: models.ReprocessTranscription, From: &from, To: &from}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newReprocessTestServer(t, tt.services)
+			if err := server.StartReprocessJob(&tt.job); err == nil {
+				t.Error("StartReprocessJob() expected error")
+			}
+		})
+	}
+}
+
+func TestReprocessTranscriptionJob(t *testing.T) {
+	server := newReprocessTestServer(t, AIServices{Recognizer: &fakeRecognizer{text: testRecognizedText}})
+	db := server.config.Db
+	start := time.Date(2026, 1, 5, 10, 0, 0, 0, time.Local)
+	calls := []models.SipCall{
+		{CallID: "remote", RecordURL: "https://cdn.example.com/a.wav", Transcription: "旧的转录"},
+		{CallID: "missing", RecordURL: "/api/uploads/recordings/missing.wav", Transcription: "旧的转录"},
+		{CallID: "no-recording"},
+		{CallID: "too-early", RecordURL: "/api/uploads/recordings/old.wav", StartTime: start.Add(-48 * time.Hour)},
+	}
+	for i := range calls {
+		if calls[i].StartTime.IsZero() {
+			calls[i].StartTime = start
+		}
+		if err := db.Create(&calls[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	from := start.Add(-time.Hour)
+	job := &models.ReprocessJob{Kind: models.ReprocessTranscription, From: &from}
+	if err := server.StartReprocessJob(job); err != nil {
+		t.Fatal(err)
+	}
+	done := waitReprocessJob(t, server, job.ID)
+	if done.Status != models.ReprocessJobCompleted || done.Total != 2 || done.Processed != 2 || done.Failed != 2 {
+		t.Errorf("finished job = %+v", done)
+	}
+	results, _, err := models.ListReprocessResults(db, job.ID, 0, 10)
+	if err != nil || len(results) != 2 || results[0].Error != ErrNoRecording.Error() || !strings.Contains(results[1].Error, "failed to read recording") {
+		t.Errorf("results = %+v, %v", results, err)
+	}
+}
+
+func TestCompareTranscriptAccuracy(t *testing.T) {
+	server := newReprocessTestServer(t, AIServices{})
+	db := server.config.Db
+	job := &models.ReprocessJob{Kind: models.ReprocessTranscription, Status: models.ReprocessJobCompleted}
+	if err := models.CreateReprocessJob(db, job); err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range []models.ReprocessResult{
+		{JobID: job.ID, TargetID: "a", OldText: "我想查话费", NewText: "我想查一下话费"},
+		{JobID: job.ID, TargetID: "b", OldText: "办理宽带", NewText: "办理宽带。"},
+		{JobID: job.ID, TargetID: "c", OldText: "x", NewText: "y", Error: "failed"},
+	} {
+		if err := models.CreateReprocessResult(db, &result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	accuracy, err := server.CompareTranscriptAccuracy(job.ID, map[string]string{
+		"a": "我想查一下话费",
+		"b": "办理宽带业务",
+		"c": "忽略失败的结果",
+		"d": "没有结果",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a：旧转录少2字，新转录正确；b：新旧都少2字。总计13字
+	if len(accuracy.Calls) != 2 || math.Abs(accuracy.OldErrorRate-4.0/13) > 1e-9 || math.Abs(accuracy.NewErrorRate-2.0/13) > 1e-9 {
+		t.Errorf("accuracy = %+v", accuracy)
+	}
+
+	scoring := &models.ReprocessJob{Kind: models.ReprocessScoring}
+	models.CreateReprocessJob(db, scoring)
+	if _, err := server.CompareTranscriptAccuracy(scoring.ID, map[string]string{"a": "x"}); err == nil {
+		t.Error("comparing a scoring job expected error")
+	}
+}
+
+func TestCharacterErrorRate(t *testing.T) {
+	tests := []struct {
+		reference, hypothesis string
+		want                  float64
+	}{
+		{"你好", "你好", 0},
+		{"你好，世界！", "你好 世界", 0},
+		{"Hello World", "hello world", 0},
+		{"你好世界", "你好", 0.5},
+		{"你好", "你好世界", 1},
+		{"abc", "abd", 1.0 / 3},
+		{"", "", 0},
+		{"", "多余", 1},
+		{"短", "完全不同的长句", 7},
+	}
+	for _, tt := range tests {
+		if got := characterErrorRate(tt.reference, tt.hypothesis); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("characterErrorRate(%q, %q) = %v, want %v", tt.reference, tt.hypothesis, got, tt.want)
+		}
+	}
+}
+
+func TestParseQualityScore(t *testing.T) {
+	tests := []struct {
+		response string
+		want     float32
+		wantErr  bool
+	}{
+		{`{"score":85}`, 85, false},
+		{"```json\n{\"score\": 72.5}\n```", 72.5, false},
+		{`评分如下：{"score": 60}，主要问题是没有解决来电者的问题`, 60, false},
+		{`{"score": 150}`, 100, false},
+		{`{"score": -5}`, 0, false},
+		{"我给85分", 85, false},
+		{`{"rating": 3}`, 3, false},
+		{"无法评分", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseQualityScore(tt.response)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseQualityScore(%q) = %v, %v, want %v (err %v)", tt.response, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	if _, ok := buildScorePrompt(conversation("  ")); ok {
+		t.Error("buildScorePrompt() accepted a conversation without caller speech")
+	}
+	prompt, ok := buildScorePrompt(append(conversation("查话费"), models.ConversationMessage{Role: "system", Content: "内部提示"}))
+	if !ok || !strings.Contains(prompt, "来电者：查话费") || !strings.Contains(prompt, "客服：您好") || strings.Contains(prompt, "内部提示") {
+		t.Errorf("buildScorePrompt() = %q, %v", prompt, ok)
+	}
+}
@@ -0,0 +1,239 @@
+package sip1
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"runtime/debug"
+	"strings"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+// acceptedBodyTypes 会解析请求体的方法及其接受的Content-Type，未列出的方法忽略请求体
+var acceptedBodyTypes = map[sip.RequestMethod][]string{
+	sip.INVITE:  {"application/sdp", "multipart/mixed"},
+	sip.INFO:    {"application/dtmf-relay", "application/dtmf"},
+	sip.NOTIFY:  {"message/sipfrag"},
+	sip.MESSAGE: {"text/plain"},
+}
+
+// unsupportedBodyType 请求体的Content-Type不被该方法接受时返回接受的类型
+func unsupportedBodyType(req *sip.Request) ([]string, bool) {
+	accepted, ok := acceptedBodyTypes[req.Method]
+	if !ok || len(req.Body()) == 0 {
+		return nil, false
+	}
+	contentType := req.ContentType()
+	if contentType == nil {
+		return accepted, true
+	}
+	mediaType := bodyMediaType(contentType.Value())
+	for _, t := range accepted {
+		if mediaType == t {
+			return nil, false
+		}
+	}
+	return accepted, true
+}
+
+// bodyMediaType Content-Type去掉参数后的小写媒体类型
+func bodyMediaType(value string) string {
+	mediaType, _, _ := strings.Cut(value, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+// errNoSDPPart multipart请求体中没有application/sdp部分
+var errNoSDPPart = errors.New("multipart body has no application/sdp part")
+
+// extractMultipartSDP 运营商的INVITE可能是multipart/mixed（SDP加ISUP、位置信息等，RFC 5621），
+// 只保留application/sdp部分改写为请求体，后续处理器按普通SDP处理；其他部分丢弃
+func extractMultipartSDP(req *sip.Request) error {
+	contentType := req.ContentType()
+	if contentType == nil || bodyMediaType(contentType.Value()) != "multipart/mixed" {
+		return nil
+	}
+	_, params, err := mime.ParseMediaType(contentType.Value())
+	if err != nil || params["boundary"] == "" {
+		return fmt.Errorf("invalid multipart Content-Type %q", contentType.Value())
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(req.Body()), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return errNoSDPPart
+		}
+		if err != nil {
+			return fmt.Errorf("invalid multipart body: %w", err)
+		}
+		if bodyMediaType(part.Header.Get("Content-Type")) != "application/sdp" {
+			continue
+		}
+		sdp, err := io.ReadAll(part)
+		if err != nil {
+			return fmt.Errorf("invalid multipart body: %w", err)
+		}
+		req.RemoveHeader("Content-Type")
+		sdpType := sip.ContentTypeHeader("application/sdp")
+		req.AppendHeader(&sdpType)
+		req.SetBody(sdp)
+		return nil
+	}
+}
+
+// missingHeaders 返回请求缺少的必需头（RFC 3261 8.1.1）
+func missingHeaders(req *sip.Request) []string {
+	var missing []string
+	if req.Via() == nil {
+		missing = append(missing, "Via")
+	}
+	if req.From() == nil {
+		missing = append(missing, "From")
+	}
+	if req.To() == nil {
+		missing = append(missing, "To")
+	}
+	if req.CallID() == nil {
+		missing = append(missing, "Call-ID")
+	}
+	if req.CSeq() == nil {
+		missing = append(missing, "CSeq")
+	}
+	return missing
+}
+
+// newErrorResponse 构造错误响应，请求缺少To时NewResponseFromRequest无法添加tag，按已有头手工构造
+func newErrorResponse(req *sip.Request, statusCode sip.StatusCode, reason string) *sip.Response {
+	if req.To() != nil {
+		return sip.NewResponseFromRequest(req, statusCode, reason, nil)
+	}
+	res := sip.NewResponse(statusCode, reason)
+	res.SipVersion = req.SipVersion
+	sip.CopyHeaders("Via", req, res)
+	if h := req.From(); h != nil {
+		res.AppendHeader(sip.HeaderClone(h))
+	}
+	if h := req.CallID(); h != nil {
+		res.AppendHeader(sip.HeaderClone(h))
+	}
+	if h := req.CSeq(); h != nil {
+		res.AppendHeader(sip.HeaderClone(h))
+	}
+	res.SetBody(nil)
+	res.SetTransport(req.Transport())
+	res.SetSource(req.Destination())
+	res.SetDestination(req.Source())
+	return res
+}
+
+// guardRequest 内置中间件：缺少必需头的请求回复400，Max-Forwards耗尽回复483，环路回复482，
+// 请求体超过MaxBodySize回复413，请求体类型不被接受回复415，multipart的INVITE取出SDP部分，
+// 处理器panic时记录并回复500，避免单个畸形请求导致处理协程崩溃。
+// 中间件运行时sipgo已经读入并解析了整条消息：服务只监听UDP，单个数据报最大65535字节，
+// 读缓冲由传输层限定，MaxBodySize限制的是交给处理器的请求体，而不是读入的字节数
+func (as *SipServer) guardRequest(handler sipgo.RequestHandler) sipgo.RequestHandler {
+	return func(req *sip.Request, tx sip.ServerTransaction) {
+		// ACK没有响应，只记录后丢弃
+		canRespond := tx != nil && !req.IsAck()
+
+		if missing := missingHeaders(req); len(missing) > 0 {
+			logger.Warn("Malformed SIP request",
+				zap.String("method", string(req.Method)),
+				zap.String("source", req.Source()),
+				zap.Strings("missing", missing))
+			if canRespond {
+				res := newErrorResponse(req, sip.StatusBadRequest, fmt.Sprintf("Missing %s", strings.Join(missing, ", ")))
+				if err := tx.Respond(res); err != nil {
+					logger.Error("Failed to send 400 response", zap.Error(err))
+				}
+			}
+			return
+		}
+
+		if status, reason := as.checkHops(req); status != 0 {
+			logger.Warn("SIP request rejected by hop check",
+				zap.String("method", string(req.Method)),
+				zap.String("call_id", req.CallID().Value()),
+				zap.String("source", req.Source()),
+				zap.Int("status", int(status)))
+			if canRespond {
+				if err := tx.Respond(newErrorResponse(req, status, reason)); err != nil {
+					logger.Error("Failed to send hop check response", zap.Error(err))
+				}
+			}
+			return
+		}
+
+		if maxBodySize := as.config.MaxBodySize; maxBodySize > 0 && len(req.Body()) > maxBodySize {
+			logger.Warn("SIP request body too large",
+				zap.String("method", string(req.Method)),
+				zap.String("call_id", req.CallID().Value()),
+				zap.String("source", req.Source()),
+				zap.Int("size", len(req.Body())),
+				zap.Int("limit", maxBodySize))
+			if canRespond {
+				if err := tx.Respond(newErrorResponse(req, sip.StatusRequestEntityTooLarge, "Request Entity Too Large")); err != nil {
+					logger.Error("Failed to send 413 response", zap.Error(err))
+				}
+			}
+			return
+		}
+
+		if accepted, unsupported := unsupportedBodyType(req); unsupported && canRespond {
+			logger.Warn("Unsupported SIP request body type",
+				zap.String("method", string(req.Method)),
+				zap.String("call_id", req.CallID().Value()),
+				zap.String("source", req.Source()),
+				zap.Strings("accepted", accepted))
+			res := newErrorResponse(req, sip.StatusUnsupportedMediaType, "Unsupported Media Type")
+			res.AppendHeader(sip.NewHeader("Accept", strings.Join(accepted, ", ")))
+			if err := tx.Respond(res); err != nil {
+				logger.Error("Failed to send 415 response", zap.Error(err))
+			}
+			return
+		}
+
+		if req.IsInvite() {
+			if err := extractMultipartSDP(req); err != nil {
+				logger.Warn("Invalid multipart INVITE body",
+					zap.String("call_id", req.CallID().Value()),
+					zap.String("source", req.Source()),
+					zap.Error(err))
+				if canRespond {
+					res := newErrorResponse(req, sip.StatusBadRequest, "Bad Request")
+					if errors.Is(err, errNoSDPPart) {
+						res = newErrorResponse(req, sip.StatusUnsupportedMediaType, "Unsupported Media Type")
+						res.AppendHeader(sip.NewHeader("Accept", "application/sdp"))
+					}
+					if err := tx.Respond(res); err != nil {
+						logger.Error("Failed to send multipart INVITE response", zap.Error(err))
+					}
+				}
+				return
+			}
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("SIP request handler panic",
+					zap.String("method", string(req.Method)),
+					zap.String("call_id", req.CallID().Value()),
+					zap.String("source", req.Source()),
+					zap.Any("panic", r),
+					zap.ByteString("stack", debug.Stack()))
+				if canRespond {
+					// 处理器可能已经回复过最终响应，此时发送失败可以忽略
+					tx.Respond(newErrorResponse(req, sip.StatusInternalServerError, "Server Internal Error"))
+				}
+			}
+		}()
+		handler(req, tx)
+	}
+}
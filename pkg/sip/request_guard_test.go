@@ -0,0 +1,161 @@
+package sip1
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+	"github.com/emiago/sipgo/sip"
+)
+
+func newGuardTestServer() *SipServer {
+	return &SipServer{config: ua.DefaultUAConfig(), localHosts: map[string]bool{}}
+}
+
+func TestGuardRequestMissingHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		method sip.RequestMethod
+		remove []string
+		reason string
+		wantTo bool
+	}{
+		{name: "no From", method: sip.INVITE, remove: []string{"From"}, reason: "Missing From", wantTo: true},
+		{name: "no To", method: sip.BYE, remove: []string{"To"}, reason: "Missing To"},
+		{name: "no Call-ID and CSeq", method: sip.OPTIONS, remove: []string{"Call-ID", "CSeq"}, reason: "Missing Call-ID, CSeq", wantTo: true},
+		{name: "no Via", method: sip.REGISTER, remove: []string{"Via"}, reason: "Missing Via", wantTo: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newTestRequest(t, tt.method, "10.0.0.9:5062", "")
+			for _, header := range tt.remove {
+				req.RemoveHeader(header)
+			}
+			called := false
+			tx := newRecordingTx()
+			newGuardTestServer().guardRequest(func(req *sip.Request, tx sip.ServerTransaction) { called = true })(req, tx)
+			if called {
+				t.Fatal("handler called for a malformed request")
+			}
+			if len(tx.responses) != 1 {
+				t.Fatalf("got %d responses, want 1", len(tx.responses))
+			}
+			res := tx.responses[0]
+			if res.StatusCode != sip.StatusBadRequest || res.Reason != tt.reason {
+				t.Errorf("response = %d %s, want 400 %s", res.StatusCode, res.Reason, tt.reason)
+			}
+			// 缺少To时手工构造响应，其余头按请求原样带回
+			if to := res.To(); (to != nil) != tt.wantTo {
+				t.Errorf("response To = %v", to)
+			}
+			if req.CallID() != nil && res.CallID().Value() != req.CallID().Value() {
+				t.Errorf("response Call-ID = %v", res.CallID())
+			}
+		})
+	}
+
+	t.Run("ACK is dropped", func(t *testing.T) {
+		req := newTestRequest(t, sip.ACK, "10.0.0.9:5062", "callee")
+		req.RemoveHeader("Call-ID")
+		tx := newRecordingTx()
+		newGuardTestServer().guardRequest(func(req *sip.Request, tx sip.ServerTransaction) { t.Error("handler called") })(req, tx)
+		if len(tx.responses) != 0 {
+			t.Errorf("ACK answered with %d", tx.responses[0].StatusCode)
+		}
+	})
+}
+
+func TestGuardRequestRecoversPanic(t *testing.T) {
+	server := newGuardTestServer()
+	req := newTestRequest(t, sip.INVITE, "10.0.0.9:5062", "")
+	tx := newRecordingTx()
+	server.guardRequest(func(req *sip.Request, tx sip.ServerTransaction) {
+		var header *sip.ContactHeader
+		_ = header.Address.User // 处理器访问缺失的可选头
+	})(req, tx)
+	if len(tx.responses) != 1 || tx.responses[0].StatusCode != sip.StatusInternalServerError {
+		t.Fatalf("responses after panic = %v", tx.responses)
+	}
+
+	// 没有事务的请求panic时只记录
+	server.guardRequest(func(req *sip.Request, tx sip.ServerTransaction) { panic("boom") })(req, nil)
+
+	handled := newRecordingTx()
+	server.guardRequest(func(req *sip.Request, tx sip.ServerTransaction) {
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+	})(newTestRequest(t, sip.OPTIONS, "10.0.0.9:5062", ""), handled)
+	if len(handled.responses) != 1 || handled.responses[0].StatusCode != sip.StatusOK || strings.Contains(handled.responses[0].Reason, "Missing") {
+		t.Errorf("well-formed request responses = %v", handled.responses)
+	}
+}
+
+const multipartSDP = "v=0\r\no=- 1 1 IN IP4 203.0.113.5\r\ns=-\r\nc=IN IP4 203.0.113.5\r\nt=0 0\r\nm=audio 4000 RTP/AVP 0\r\n"
+
+// multipartBody 运营商INVITE常见的SDP加ISUP请求体
+func multipartBody(parts ...string) string {
+	body := ""
+	for _, part := range parts {
+		body += "--unique-boundary-1\r\n" + part
+	}
+	return body + "--unique-boundary-1--\r\n"
+}
+
+func TestGuardRequestBodies(t *testing.T) {
+	sdpPart := "Content-Type: application/sdp\r\n\r\n" + multipartSDP + "\r\n"
+	isupPart := "Content-Type: application/isup;version=itu-t92+\r\nContent-Disposition: signal;handling=optional\r\n\r\n\x01\x00\x49\x00\r\n"
+	tests := []struct {
+		name        string
+		method      sip.RequestMethod
+		contentType string
+		body        string
+		maxBody     int
+		status      sip.StatusCode // 0表示交给处理器
+		accept      string
+	}{
+		{name: "SDP", method: sip.INVITE, contentType: "application/sdp", body: multipartSDP},
+		{name: "multipart SDP and ISUP", method: sip.INVITE, contentType: `multipart/mixed;boundary=unique-boundary-1`, body: multipartBody(isupPart, sdpPart)},
+		{name: "multipart without SDP", method: sip.INVITE, contentType: "multipart/mixed;boundary=unique-boundary-1", body: multipartBody(isupPart), status: sip.StatusUnsupportedMediaType, accept: "application/sdp"},
+		{name: "multipart without boundary", method: sip.INVITE, contentType: "multipart/mixed", body: multipartBody(sdpPart), status: sip.StatusBadRequest},
+		{name: "multipart on INFO", method: sip.INFO, contentType: "multipart/mixed;boundary=unique-boundary-1", body: multipartBody(sdpPart), status: sip.StatusUnsupportedMediaType, accept: "application/dtmf-relay, application/dtmf"},
+		{name: "HTML INVITE", method: sip.INVITE, contentType: "text/html", body: "<p>hi</p>", status: sip.StatusUnsupportedMediaType, accept: "application/sdp, multipart/mixed"},
+		{name: "body over the limit", method: sip.MESSAGE, contentType: "text/plain", body: strings.Repeat("x", 65), maxBody: 64, status: sip.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newTestRequest(t, tt.method, "203.0.113.5:5060", "")
+			contentType := sip.ContentTypeHeader(tt.contentType)
+			req.AppendHeader(&contentType)
+			req.SetBody([]byte(tt.body))
+			server := newGuardTestServer()
+			if tt.maxBody > 0 {
+				server.config.MaxBodySize = tt.maxBody
+			}
+
+			var handled *sip.Request
+			tx := newRecordingTx()
+			server.guardRequest(func(req *sip.Request, tx sip.ServerTransaction) { handled = req })(req, tx)
+			if tt.status == 0 {
+				if handled == nil {
+					t.Fatalf("request not handled, responses %v", tx.responses)
+				}
+				// 处理器拿到的始终是单独的SDP
+				if string(handled.Body()) != multipartSDP || handled.ContentType().Value() != "application/sdp" {
+					t.Errorf("handler got %s body %q", handled.ContentType().Value(), handled.Body())
+				}
+				if length := handled.ContentLength(); length == nil || int(*length) != len(multipartSDP) {
+					t.Errorf("Content-Length = %v, want %d", length, len(multipartSDP))
+				}
+				return
+			}
+			if handled != nil {
+				t.Fatal("rejected request reached the handler")
+			}
+			if len(tx.responses) != 1 || tx.responses[0].StatusCode != tt.status {
+				t.Fatalf("responses = %v, want %d", tx.responses, tt.status)
+			}
+			if accept := tx.responses[0].GetHeader("Accept"); (accept == nil && tt.accept != "") || (accept != nil && accept.Value() != tt.accept) {
+				t.Errorf("Accept = %v, want %q", accept, tt.accept)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package sip1
+
+import (
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRequestStatsAPIs 注册SIP请求统计接口：GET /sip-requests 按方法查看请求数、响应码和处理耗时
+func RegisterRequestStatsAPIs(r gin.IRoutes, server *SipServer) {
+	r.GET("/sip-requests", func(c *gin.Context) {
+		response.Success(c, "ok", server.RequestStats())
+	})
+}
@@ -1,9 +1,11 @@
 package sip1
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/secrets"
 	"gorm.io/gorm"
 )
 
@@ -170,10 +172,15 @@ func GetRongLianAPIConfig(trunk *models.SIPTrunk) (*RongLianAPIConfig, error) {
 	// 从Metadata中解析AppID和AppToken
 	// 这里需要实现JSON解析逻辑
 
+	authToken, err := secrets.Resolve(context.Background(), trunk.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve RongLian auth token: %w", err)
+	}
+
 	return &RongLianAPIConfig{
 		BaseURL:    "https://app.cloopen.com:8883",
 		AccountSID: trunk.Username,
-		AuthToken:  trunk.Password,
+		AuthToken:  authToken,
 		// AppID和AppToken需要从Metadata中解析
 	}, nil
 }
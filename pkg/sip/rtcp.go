@@ -0,0 +1,373 @@
+package sip1
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	rtcpTypeSR   = 200
+	rtcpTypeRR   = 201
+	rtcpTypeSDES = 202
+
+	// rtcpReportInterval 发送SR/RR的间隔（RFC 3550建议最小5秒）
+	rtcpReportInterval = 5 * time.Second
+
+	// ntpEpochOffset 1900年到1970年的秒数
+	ntpEpochOffset = 2208988800
+)
+
+// CallQuality 通话质量统计，来自对端RTCP接收报告
+type CallQuality struct {
+	PacketsSent     uint32  `json:"packetsSent"`
+	OctetsSent      uint32  `json:"octetsSent"`
+	ReportsReceived int     `json:"reportsReceived"`
+	FractionLost    float64 `json:"fractionLost"`   // 最近一个报告周期的丢包率 0-1
+	CumulativeLost  int32   `json:"cumulativeLost"` // 累计丢包数
+	JitterMs        float64 `json:"jitterMs"`
+	RTTMs           float64 `json:"rttMs,omitempty"`
+	MaxJitterMs     float64 `json:"maxJitterMs"`
+	MaxRTTMs        float64 `json:"maxRttMs,omitempty"`
+}
+
+// rtcpReportBlock RFC 3550 接收报告块
+type rtcpReportBlock struct {
+	SSRC           uint32
+	FractionLost   uint8
+	CumulativeLost int32
+	HighestSeq     uint32
+	Jitter         uint32
+	LastSR         uint32
+	DelaySinceSR   uint32
+}
+
+// rtcpSession 单个通话的RTCP状态
+type rtcpSession struct {
+	callID     string
+	remoteAddr *net.UDPAddr
+	clockRate  int
+	ssrc       uint32
+
+	mutex         sync.Mutex
+	quality       CallQuality
+	lastSentTime  time.Time
+	lastTimestamp uint32
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// ntpTime 转换为64位NTP时间戳
+func ntpTime(t time.Time) uint64 {
+	secs := uint64(t.Unix()) + ntpEpochOffset
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+// rtcpPacketHeader 写入RTCP公共头，length为整个包的字节数
+func rtcpPacketHeader(buf []byte, count uint8, packetType uint8, length int) {
+	buf[0] = 2<<6 | count&0x1f
+	buf[1] = packetType
+	binary.BigEndian.PutUint16(buf[2:4], uint16(length/4-1))
+}
+
+// marshalSenderReport 生成不带接收报告块的SR
+func marshalSenderReport(ssrc uint32, ntp uint64, rtpTimestamp, packets, octets uint32) []byte {
+	buf := make([]byte, 28)
+	rtcpPacketHeader(buf, 0, rtcpTypeSR, len(buf))
+	binary.BigEndian.PutUint32(buf[4:8], ssrc)
+	binary.BigEndian.PutUint64(buf[8:16], ntp)
+	binary.BigEndian.PutUint32(buf[16:20], rtpTimestamp)
+	binary.BigEndian.PutUint32(buf[20:24], packets)
+	binary.BigEndian.PutUint32(buf[24:28], octets)
+	return buf
+}
+
+// marshalReceiverReport 生成不带接收报告块的RR，还未发送媒体时使用
+func marshalReceiverReport(ssrc uint32) []byte {
+	buf := make([]byte, 8)
+	rtcpPacketHeader(buf, 0, rtcpTypeRR, len(buf))
+	binary.BigEndian.PutUint32(buf[4:8], ssrc)
+	return buf
+}
+
+// marshalSDES 生成只含CNAME的SDES，复合RTCP包必须携带
+func marshalSDES(ssrc uint32, cname string) []byte {
+	if len(cname) > 255 {
+		cname = cname[:255]
+	}
+	// SSRC + CNAME(type,len,text) + 结束符，按4字节对齐
+	chunkLen := 4 + 2 + len(cname) + 1
+	chunkLen += (4 - chunkLen%4) % 4
+	buf := make([]byte, 4+chunkLen)
+	rtcpPacketHeader(buf, 1, rtcpTypeSDES, len(buf))
+	binary.BigEndian.PutUint32(buf[4:8], ssrc)
+	buf[8] = 1 // CNAME
+	buf[9] = byte(len(cname))
+	copy(buf[10:], cname)
+	return buf
+}
+
+// parseReportBlocks 从复合RTCP包中提取SR/RR的接收报告块
+func parseReportBlocks(data []byte) ([]rtcpReportBlock, error) {
+	var blocks []rtcpReportBlock
+	for len(data) >= 4 {
+		if data[0]>>6 != 2 {
+			return nil, errors.New("invalid RTCP version")
+		}
+		count := int(data[0] & 0x1f)
+		packetType := data[1]
+		length := (int(binary.BigEndian.Uint16(data[2:4])) + 1) * 4
+		if length > len(data) {
+			return nil, errors.New("truncated RTCP packet")
+		}
+		packet := data[:length]
+		data = data[length:]
+
+		var offset int
+		switch packetType {
+		case rtcpTypeSR:
+			offset = 28
+		case rtcpTypeRR:
+			offset = 8
+		default:
+			continue
+		}
+
+		for i := 0; i < count && offset+24 <= len(packet); i++ {
+			b := packet[offset : offset+24]
+			lost := int32(uint32(b[5])<<16 | uint32(b[6])<<8 | uint32(b[7]))
+			if lost&0x800000 != 0 {
+				lost -= 1 << 24
+			}
+			blocks = append(blocks, rtcpReportBlock{
+				SSRC:           binary.BigEndian.Uint32(b[0:4]),
+				FractionLost:   b[4],
+				CumulativeLost: lost,
+				HighestSeq:     binary.BigEndian.Uint32(b[8:12]),
+				Jitter:         binary.BigEndian.Uint32(b[12:16]),
+				LastSR:         binary.BigEndian.Uint32(b[16:20]),
+				DelaySinceSR:   binary.BigEndian.Uint32(b[20:24]),
+			})
+			offset += 24
+		}
+	}
+	return blocks, nil
+}
+
+// onSent 记录发出的RTP包，用于SR的发送统计和时间戳映射
+func (rs *rtcpSession) onSent(ssrc, timestamp uint32, payloadLen int) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rs.ssrc = ssrc
+	rs.quality.PacketsSent++
+	rs.quality.OctetsSent += uint32(payloadLen)
+	rs.lastSentTime = time.Now()
+	rs.lastTimestamp = timestamp
+}
+
+// onReport 根据对端报告更新丢包、抖动和往返时延
+func (rs *rtcpSession) onReport(block rtcpReportBlock, received time.Time) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	q := &rs.quality
+	q.ReportsReceived++
+	q.FractionLost = float64(block.FractionLost) / 256
+	q.CumulativeLost = block.CumulativeLost
+	if rs.clockRate > 0 {
+		q.JitterMs = float64(block.Jitter) * 1000 / float64(rs.clockRate)
+		if q.JitterMs > q.MaxJitterMs {
+			q.MaxJitterMs = q.JitterMs
+		}
+	}
+
+	// RTT = 收到时间 - LSR - DLSR，单位1/65536秒
+	if block.LastSR != 0 {
+		now := uint32(ntpTime(received) >> 16)
+		if rtt := now - block.LastSR - block.DelaySinceSR; int32(rtt) > 0 {
+			q.RTTMs = float64(rtt) * 1000 / 65536
+			if q.RTTMs > q.MaxRTTMs {
+				q.MaxRTTMs = q.RTTMs
+			}
+		}
+	}
+}
+
+// report 生成本周期的复合RTCP包
+func (rs *rtcpSession) report(cname string) []byte {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	var packet []byte
+	if rs.quality.PacketsSent > 0 {
+		now := time.Now()
+		// 按当前时间推算RTP时间戳，保持与NTP时间对应
+		timestamp := rs.lastTimestamp + uint32(now.Sub(rs.lastSentTime).Seconds()*float64(rs.clockRate))
+		packet = marshalSenderReport(rs.ssrc, ntpTime(now), timestamp, rs.quality.PacketsSent, rs.quality.OctetsSent)
+	} else {
+		packet = marshalReceiverReport(rs.ssrc)
+	}
+	return append(packet, marshalSDES(rs.ssrc, cname)...)
+}
+
+// localSSRC 本端SSRC
+func (rs *rtcpSession) localSSRC() uint32 {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return rs.ssrc
+}
+
+func (rs *rtcpSession) snapshot() CallQuality {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return rs.quality
+}
+
+// rtcpCNAME 本端RTCP CNAME
+func rtcpCNAME() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return "lingsip@" + host
+}
+
+// startRTCP 通话建立后开始周期发送RTCP报告，对端RTCP地址为RTP端口+1
+func (as *SipServer) startRTCP(callID string, clientRTPAddr *net.UDPAddr, codec rtpCodec) {
+	if as.rtcpConn == nil {
+		return
+	}
+
+	rs := &rtcpSession{
+		callID:     callID,
+		remoteAddr: &net.UDPAddr{IP: clientRTPAddr.IP, Port: clientRTPAddr.Port + 1, Zone: clientRTPAddr.Zone},
+		clockRate:  codec.ClockRate,
+		ssrc:       rand.Uint32(),
+		stopChan:   make(chan struct{}),
+	}
+
+	as.rtcpMutex.Lock()
+	as.rtcpSessions[callID] = rs
+	as.rtcpMutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(rtcpReportInterval)
+		defer ticker.Stop()
+		cname := rtcpCNAME()
+
+		for {
+			select {
+			case <-rs.stopChan:
+				return
+			case <-as.stopChan:
+				return
+			case <-ticker.C:
+				if _, err := as.rtcpConn.WriteToUDP(rs.report(cname), rs.remoteAddr); err != nil {
+					logger.Debug("Failed to send RTCP report", zap.String("call_id", callID), zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// stopRTCP 停止RTCP报告，并把通话质量写入通话记录的Metadata
+func (as *SipServer) stopRTCP(callID string) {
+	as.rtcpMutex.Lock()
+	rs, exists := as.rtcpSessions[callID]
+	delete(as.rtcpSessions, callID)
+	as.rtcpMutex.Unlock()
+	if !exists {
+		return
+	}
+	rs.stopOnce.Do(func() { close(rs.stopChan) })
+
+	quality := rs.snapshot()
+	logger.Info("Call quality",
+		zap.String("call_id", callID),
+		zap.Uint32("packets_sent", quality.PacketsSent),
+		zap.Int("reports_received", quality.ReportsReceived),
+		zap.Float64("fraction_lost", quality.FractionLost),
+		zap.Int32("cumulative_lost", quality.CumulativeLost),
+		zap.Float64("jitter_ms", quality.JitterMs),
+		zap.Float64("rtt_ms", quality.RTTMs))
+
+	if err := as.config.UpdateCallMetadata(callID, "rtcp", quality); err != nil {
+		logger.Warn("Failed to record call quality", zap.String("call_id", callID), zap.Error(err))
+	}
+}
+
+// GetCallQuality 获取通话中的RTCP质量统计
+func (as *SipServer) GetCallQuality(callID string) (CallQuality, bool) {
+	as.rtcpMutex.RLock()
+	rs, exists := as.rtcpSessions[callID]
+	as.rtcpMutex.RUnlock()
+	if !exists {
+		return CallQuality{}, false
+	}
+	return rs.snapshot(), true
+}
+
+// rtcpSessionFor 获取通话的RTCP状态，通话未建立时返回nil
+func (as *SipServer) rtcpSessionFor(callID string) *rtcpSession {
+	as.rtcpMutex.RLock()
+	defer as.rtcpMutex.RUnlock()
+	return as.rtcpSessions[callID]
+}
+
+// matchRTCPSession 按接收报告块的SSRC匹配通话：每个通话的本端SSRC不同，
+// 同一对端（同一台网关或NAT后的多个终端）的多路通话也不会串到一起
+func (as *SipServer) matchRTCPSession(ssrc uint32) *rtcpSession {
+	as.rtcpMutex.RLock()
+	defer as.rtcpMutex.RUnlock()
+	for _, rs := range as.rtcpSessions {
+		if rs.localSSRC() == ssrc {
+			return rs
+		}
+	}
+	return nil
+}
+
+// runRTCPReceiver 接收对端的SR/RR并更新通话质量
+func (as *SipServer) runRTCPReceiver() {
+	if as.rtcpConn == nil {
+		return
+	}
+
+	buffer := make([]byte, 1500)
+	for {
+		n, addr, err := as.rtcpConn.ReadFromUDP(buffer)
+		if err != nil {
+			select {
+			case <-as.stopChan:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		blocks, err := parseReportBlocks(buffer[:n])
+		if err != nil {
+			logger.Debug("Invalid RTCP packet", zap.String("from", addr.String()), zap.Error(err))
+			continue
+		}
+
+		received := time.Now()
+		for _, block := range blocks {
+			if rs := as.matchRTCPSession(block.SSRC); rs != nil {
+				rs.onReport(block, received)
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package sip1
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// marshalReportBlock 生成一个接收报告块
+func marshalReportBlock(block rtcpReportBlock) []byte {
+	buf := make([]byte, 24)
+	binary.BigEndian.PutUint32(buf[0:4], block.SSRC)
+	buf[4] = block.FractionLost
+	lost := uint32(block.CumulativeLost) & 0xffffff
+	buf[5], buf[6], buf[7] = byte(lost>>16), byte(lost>>8), byte(lost)
+	binary.BigEndian.PutUint32(buf[8:12], block.HighestSeq)
+	binary.BigEndian.PutUint32(buf[12:16], block.Jitter)
+	binary.BigEndian.PutUint32(buf[16:20], block.LastSR)
+	binary.BigEndian.PutUint32(buf[20:24], block.DelaySinceSR)
+	return buf
+}
+
+// withReportBlocks 在不带报告块的SR/RR后追加报告块并更新头部
+func withReportBlocks(packet []byte, blocks ...rtcpReportBlock) []byte {
+	for _, block := range blocks {
+		packet = append(packet, marshalReportBlock(block)...)
+	}
+	rtcpPacketHeader(packet, uint8(len(blocks)), packet[1], len(packet))
+	return packet
+}
+
+func TestParseReportBlocks(t *testing.T) {
+	first := rtcpReportBlock{SSRC: 0xdeadbeef, FractionLost: 64, CumulativeLost: 12, HighestSeq: 70000, Jitter: 80, LastSR: 0x12345678, DelaySinceSR: 6553}
+	second := rtcpReportBlock{SSRC: 42, CumulativeLost: -3}
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []rtcpReportBlock
+		wantErr bool
+	}{
+		{
+			name: "sender report",
+			data: append(withReportBlocks(marshalSenderReport(1, 0, 0, 10, 1600), first), marshalSDES(1, "peer")...),
+			want: []rtcpReportBlock{first},
+		},
+		{
+			name: "receiver report with negative cumulative lost",
+			data: withReportBlocks(marshalReceiverReport(1), first, second),
+			want: []rtcpReportBlock{first, second},
+		},
+		{
+			name: "sdes first",
+			data: append(marshalSDES(1, "peer"), withReportBlocks(marshalReceiverReport(1), second)...),
+			want: []rtcpReportBlock{second},
+		},
+		{
+			name: "no report blocks",
+			data: append(marshalReceiverReport(1), marshalSDES(1, "peer")...),
+		},
+		{
+			name:    "truncated",
+			data:    withReportBlocks(marshalReceiverReport(1), first)[:20],
+			wantErr: true,
+		},
+		{
+			name:    "bad version",
+			data:    append([]byte{0x41}, marshalReceiverReport(1)[1:]...),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseReportBlocks(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseReportBlocks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseReportBlocks() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("block %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMatchRTCPSessionBySSRC(t *testing.T) {
+	// 两路通话来自同一个对端地址，只能靠报告块的SSRC区分
+	peer := &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40001}
+	a := &rtcpSession{callID: "a", remoteAddr: peer, clockRate: 8000, ssrc: 1111}
+	b := &rtcpSession{callID: "b", remoteAddr: peer, clockRate: 8000, ssrc: 2222}
+	server := &SipServer{rtcpSessions: map[string]*rtcpSession{"a": a, "b": b}}
+
+	tests := []struct {
+		ssrc uint32
+		want *rtcpSession
+	}{
+		{1111, a},
+		{2222, b},
+		{3333, nil},
+	}
+	for _, tt := range tests {
+		if got := server.matchRTCPSession(tt.ssrc); got != tt.want {
+			t.Errorf("matchRTCPSession(%d) = %v, want %v", tt.ssrc, got, tt.want)
+		}
+	}
+
+	b.onReport(rtcpReportBlock{SSRC: 2222, FractionLost: 128, CumulativeLost: 5, Jitter: 160}, time.Now())
+	if q := b.snapshot(); q.ReportsReceived != 1 || q.FractionLost != 0.5 || q.CumulativeLost != 5 || q.JitterMs != 20 {
+		t.Errorf("quality after report = %+v", q)
+	}
+	if q := a.snapshot(); q.ReportsReceived != 0 {
+		t.Errorf("other call received the report: %+v", q)
+	}
+}
+
+func TestCallSSRC(t *testing.T) {
+	rs := &rtcpSession{ssrc: 0xcafe}
+	engine := &AIPhoneEngine{server: &SipServer{rtcpSessions: map[string]*rtcpSession{"with-rtcp": rs}}}
+	if got := engine.callSSRC("with-rtcp"); got != 0xcafe {
+		t.Errorf("callSSRC() = %#x, want the RTCP session SSRC %#x", got, 0xcafe)
+	}
+	// 没有RTCP会话时随机生成，不再是所有通话共用的固定值
+	seen := make(map[uint32]bool)
+	for i := 0; i < 8; i++ {
+		seen[engine.callSSRC("no-rtcp")] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("callSSRC() returned the same SSRC for every call: %v", seen)
+	}
+}
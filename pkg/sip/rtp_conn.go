@@ -0,0 +1,44 @@
+package sip1
+
+import (
+	"net"
+	"time"
+)
+
+// RTPConn 引擎收发RTP使用的连接，*net.UDPConn即满足
+type RTPConn interface {
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	SetReadDeadline(t time.Time) error
+}
+
+// SetRTPConn 替换引擎收发RTP的连接，测试中使用内存连接
+func (engine *AIPhoneEngine) SetRTPConn(conn RTPConn) {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+	engine.media = conn
+}
+
+// rtpConn 返回RTP连接，未替换时使用SIP服务的共享连接
+func (engine *AIPhoneEngine) rtpConn() RTPConn {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+	if engine.media != nil {
+		return engine.media
+	}
+	if engine.server == nil {
+		return nil
+	}
+	if engine.server.media != nil {
+		return engine.server.media
+	}
+	return engine.server.rtpConn
+}
+
+// sessionConn 返回会话收发RTP的连接，嵌入式通话使用自己的连接
+func (engine *AIPhoneEngine) sessionConn(session *ScriptSession) RTPConn {
+	if session != nil && session.media != nil {
+		return session.media
+	}
+	return engine.rtpConn()
+}
@@ -0,0 +1,38 @@
+package sip1
+
+import (
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterScriptAnalyticsAPIs 注册脚本分析接口：GET /scripts/:id/drop-off?since=2025-01-01T00:00:00Z&until=...&channel=voice
+// 按步骤顺序返回进入每个步骤的会话数和在该步骤挂断、失败的会话数，用于找出来电者放弃的步骤；
+// since、until为RFC3339时间，按会话开始时间过滤，可省略
+func RegisterScriptAnalyticsAPIs(r gin.IRoutes, server *SipServer) {
+	r.GET("/scripts/:id/drop-off", func(c *gin.Context) {
+		engine, id, ok := scriptEngine(c, server)
+		if !ok {
+			return
+		}
+		filter := models.DropOffFilter{Channel: models.SessionChannel(c.Query("channel"))}
+		for name, value := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if query := c.Query(name); query != "" {
+				parsed, err := time.Parse(time.RFC3339, query)
+				if err != nil {
+					response.Fail(c, "invalid "+name, err.Error())
+					return
+				}
+				*value = parsed
+			}
+		}
+		steps, err := models.GetScriptStepDropOff(engine.db, id, filter)
+		if err != nil {
+			response.Fail(c, "failed to compute drop-off", err.Error())
+			return
+		}
+		response.Success(c, "ok", steps)
+	})
+}
@@ -0,0 +1,216 @@
+package sip1
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// canaryMinCalls 灰度版本至少结束这么多通话后才按失败率判断是否回滚
+	canaryMinCalls = 10
+	// defaultCanaryMaxFailureRate 脚本未配置失败率阈值时使用
+	defaultCanaryMaxFailureRate = 0.2
+)
+
+// CanaryStats 灰度脚本在观察期内已结束通话的结果
+type CanaryStats struct {
+	ScriptID         uint      `json:"scriptId"`
+	PreviousScriptID uint      `json:"previousScriptId"`
+	Percent          int       `json:"percent"`
+	Until            time.Time `json:"until"`
+	Calls            int       `json:"calls"`
+	Failures         int       `json:"failures"`
+	MaxFailureRate   float64   `json:"maxFailureRate"`
+}
+
+// FailureRate 失败通话占比
+func (s CanaryStats) FailureRate() float64 {
+	if s.Calls == 0 {
+		return 0
+	}
+	return float64(s.Failures) / float64(s.Calls)
+}
+
+// canaryTracker 按脚本统计灰度通话结果，只统计本实例处理的通话
+type canaryTracker struct {
+	mutex sync.Mutex
+	stats map[uint]*CanaryStats
+}
+
+func newCanaryTracker() *canaryTracker {
+	return &canaryTracker{stats: make(map[uint]*CanaryStats)}
+}
+
+// record 记录一次灰度通话结果，失败率超过阈值时返回true并清空统计，只回滚一次
+func (t *canaryTracker) record(script *models.AIPhoneScript, failed bool) (CanaryStats, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	stats, ok := t.stats[script.ID]
+	// 重新开始的灰度从零统计
+	if !ok || !stats.Until.Equal(*script.CanaryUntil) {
+		stats = &CanaryStats{
+			ScriptID:         script.ID,
+			PreviousScriptID: script.PreviousScriptID,
+			Percent:          script.CanaryPercent,
+			Until:            *script.CanaryUntil,
+			MaxFailureRate:   script.CanaryMaxFailureRate,
+		}
+		if stats.MaxFailureRate <= 0 {
+			stats.MaxFailureRate = defaultCanaryMaxFailureRate
+		}
+		t.stats[script.ID] = stats
+	}
+	stats.Calls++
+	if failed {
+		stats.Failures++
+	}
+	if stats.Calls < canaryMinCalls || stats.FailureRate() <= stats.MaxFailureRate {
+		return *stats, false
+	}
+	delete(t.stats, script.ID)
+	return *stats, true
+}
+
+func (t *canaryTracker) forget(scriptID uint) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.stats, scriptID)
+}
+
+func (t *canaryTracker) list() []CanaryStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	result := make([]CanaryStats, 0, len(t.stats))
+	for _, stats := range t.stats {
+		result = append(result, *stats)
+	}
+	return result
+}
+
+// StartCanary 以灰度方式上线已批准的新版本脚本：号码映射从旧版本切到新版本，观察期内新版本只接收percent%的呼叫，
+// 失败率超过maxFailureRate（0使用默认值）时自动回滚，观察期结束后全部呼叫使用新版本
+func (engine *AIPhoneEngine) StartCanary(scriptID, previousID uint, percent int, window time.Duration, maxFailureRate float64, actor string) error {
+	if scriptID == previousID || previousID == 0 {
+		return fmt.Errorf("canary needs a different previous script")
+	}
+	if percent <= 0 || percent > 100 {
+		return fmt.Errorf("canary percent must be between 1 and 100: %d", percent)
+	}
+	if window <= 0 {
+		return fmt.Errorf("canary window must be positive")
+	}
+	if _, err := engine.approvedScript(scriptID); err != nil {
+		return err
+	}
+	until := time.Now().Add(window)
+	if err := models.StartScriptCanary(engine.db, scriptID, previousID, percent, until, maxFailureRate); err != nil {
+		return fmt.Errorf("failed to start canary: %w", err)
+	}
+	engine.canary.forget(scriptID)
+	engine.audit(actor, "script.canary_started", scriptID, map[string]interface{}{
+		"previousScriptId": previousID,
+		"percent":          percent,
+		"until":            until,
+		"maxFailureRate":   maxFailureRate,
+	})
+	logger.Info("Script canary started",
+		zap.Uint("script_id", scriptID),
+		zap.Uint("previous_script_id", previousID),
+		zap.Int("percent", percent),
+		zap.Time("until", until))
+	return nil
+}
+
+// RollbackCanary 回滚灰度中的脚本，呼叫全部回到旧版本
+func (engine *AIPhoneEngine) RollbackCanary(scriptID uint, actor string) error {
+	engine.canary.forget(scriptID)
+	rolledBack, err := models.RollbackScriptCanary(engine.db, scriptID)
+	if err != nil {
+		return fmt.Errorf("failed to roll back canary: %w", err)
+	}
+	if rolledBack {
+		engine.audit(actor, "script.canary_rolled_back", scriptID, nil)
+	}
+	return nil
+}
+
+// PromoteCanary 结束观察期，全部呼叫使用新版本
+func (engine *AIPhoneEngine) PromoteCanary(scriptID uint, actor string) error {
+	engine.canary.forget(scriptID)
+	promoted, err := models.PromoteScriptCanary(engine.db, scriptID)
+	if err != nil {
+		return fmt.Errorf("failed to promote canary: %w", err)
+	}
+	if promoted {
+		engine.audit(actor, "script.canary_promoted", scriptID, nil)
+	}
+	return nil
+}
+
+// Canaries 返回本实例统计的灰度脚本通话结果
+func (engine *AIPhoneEngine) Canaries() []CanaryStats {
+	return engine.canary.list()
+}
+
+// canaryScript 为新呼叫选择脚本版本：观察期内按比例把其余呼叫交给旧版本，观察期已过则转正
+func (engine *AIPhoneEngine) canaryScript(script *models.AIPhoneScript) *models.AIPhoneScript {
+	if script.PreviousScriptID == 0 || script.CanaryUntil == nil {
+		return script
+	}
+	if !script.InCanary(time.Now()) {
+		if err := engine.PromoteCanary(script.ID, auditActorSystem); err != nil {
+			logger.Error("Failed to promote canary script", zap.Uint("script_id", script.ID), zap.Error(err))
+		} else {
+			logger.Info("Script canary promoted", zap.Uint("script_id", script.ID))
+		}
+		return script
+	}
+	if rand.IntN(100) < script.CanaryPercent {
+		return script
+	}
+	previous, err := models.GetAIPhoneScriptByID(engine.db, script.PreviousScriptID)
+	if err != nil {
+		logger.Warn("Failed to load previous script version, using canary",
+			zap.Uint("script_id", script.ID),
+			zap.Uint("previous_script_id", script.PreviousScriptID),
+			zap.Error(err))
+		return script
+	}
+	return previous
+}
+
+// recordCanaryOutcome 统计灰度版本处理的通话，失败或超时计为失败，失败率超过阈值时自动回滚
+func (engine *AIPhoneEngine) recordCanaryOutcome(session *ScriptSession) {
+	script := session.Script
+	if script == nil || !script.InCanary(time.Now()) {
+		return
+	}
+	status := session.GetStatus()
+	failed := status == models.SessionStatusFailed || status == models.SessionStatusTimeout
+	stats, rollback := engine.canary.record(script, failed)
+	if !rollback {
+		return
+	}
+
+	rolledBack, err := models.RollbackScriptCanary(engine.db, script.ID)
+	if err != nil {
+		logger.Error("Failed to roll back canary script", zap.Uint("script_id", script.ID), zap.Error(err))
+		return
+	}
+	if rolledBack {
+		engine.audit(auditActorSystem, "script.canary_rolled_back", script.ID, stats)
+		logger.Warn("Script canary rolled back, failure rate exceeded threshold",
+			zap.Uint("script_id", script.ID),
+			zap.Uint("previous_script_id", stats.PreviousScriptID),
+			zap.Int("calls", stats.Calls),
+			zap.Int("failures", stats.Failures),
+			zap.Float64("max_failure_rate", stats.MaxFailureRate))
+	}
+}
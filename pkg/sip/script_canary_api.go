@@ -0,0 +1,89 @@
+package sip1
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/auth"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// scriptEngine 返回AI电话引擎和路径中的脚本ID，失败时已写入响应
+func scriptEngine(c *gin.Context, server *SipServer) (*AIPhoneEngine, uint, bool) {
+	engine := server.GetAIPhoneEngine()
+	if engine == nil {
+		response.Fail(c, "AI phone engine not configured", nil)
+		return nil, 0, false
+	}
+	if c.Param("id") == "" {
+		return engine, 0, true
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		response.Fail(c, "invalid script id", err.Error())
+		return nil, 0, false
+	}
+	return engine, uint(id), true
+}
+
+// RegisterScriptCanaryAPIs 注册脚本灰度发布接口：GET /script-canaries 查看本实例的灰度统计，
+// POST /scripts/:id/canary {"previousScriptId":1,"percent":10,"windowMinutes":60,"maxFailureRate":0.2}
+// 开始灰度（脚本须已批准），POST /scripts/:id/canary/promote 提前转正，
+// DELETE /scripts/:id/canary 回滚，操作均写入审计日志
+func RegisterScriptCanaryAPIs(r gin.IRoutes, server *SipServer) {
+	r.GET("/script-canaries", func(c *gin.Context) {
+		engine, _, ok := scriptEngine(c, server)
+		if !ok {
+			return
+		}
+		response.Success(c, "ok", engine.Canaries())
+	})
+
+	r.POST("/scripts/:id/canary", requireActor, func(c *gin.Context) {
+		engine, id, ok := scriptEngine(c, server)
+		if !ok {
+			return
+		}
+		var form struct {
+			PreviousScriptID uint    `json:"previousScriptId" binding:"required"`
+			Percent          int     `json:"percent" binding:"required"`
+			WindowMinutes    int     `json:"windowMinutes" binding:"required"`
+			MaxFailureRate   float64 `json:"maxFailureRate"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		window := time.Duration(form.WindowMinutes) * time.Minute
+		if err := engine.StartCanary(id, form.PreviousScriptID, form.Percent, window, form.MaxFailureRate, auth.CurrentActor(c)); err != nil {
+			response.Fail(c, "failed to start canary", err.Error())
+			return
+		}
+		response.Success(c, "ok", nil)
+	})
+
+	r.POST("/scripts/:id/canary/promote", requireActor, func(c *gin.Context) {
+		engine, id, ok := scriptEngine(c, server)
+		if !ok {
+			return
+		}
+		if err := engine.PromoteCanary(id, auth.CurrentActor(c)); err != nil {
+			response.Fail(c, "failed to promote canary", err.Error())
+			return
+		}
+		response.Success(c, "ok", nil)
+	})
+
+	r.DELETE("/scripts/:id/canary", requireActor, func(c *gin.Context) {
+		engine, id, ok := scriptEngine(c, server)
+		if !ok {
+			return
+		}
+		if err := engine.RollbackCanary(id, auth.CurrentActor(c)); err != nil {
+			response.Fail(c, "failed to roll back canary", err.Error())
+			return
+		}
+		response.Success(c, "ok", nil)
+	})
+}
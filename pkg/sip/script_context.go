@@ -0,0 +1,178 @@
+package sip1
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+)
+
+// ContextChange 上下文变更通知，Deleted为true时New无意义
+type ContextChange struct {
+	Key     string
+	Old     interface{}
+	New     interface{}
+	Deleted bool
+}
+
+// ScriptContext 会话上下文，并发安全。写入的值先经过一次JSON往返，
+// 内存中的值与落库后再读回的值一致（数字统一为float64），取值方法按类型安全转换
+type ScriptContext struct {
+	mutex    sync.RWMutex
+	values   map[string]interface{}
+	watchers []func(ContextChange)
+}
+
+// NewScriptContext 创建空上下文
+func NewScriptContext() *ScriptContext {
+	return &ScriptContext{values: make(map[string]interface{})}
+}
+
+// LoadScriptContext 从落库的上下文恢复
+func LoadScriptContext(values map[string]interface{}) (*ScriptContext, error) {
+	c := NewScriptContext()
+	for key, value := range values {
+		normalized, err := normalizeContextValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("context key %s: %w", key, err)
+		}
+		c.values[key] = normalized
+	}
+	return c, nil
+}
+
+// normalizeContextValue 经JSON往返得到落库后的形态
+func normalizeContextValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("value is not JSON serializable: %w", err)
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// Watch 注册变更回调，回调在写入方的协程中、锁外执行
+func (c *ScriptContext) Watch(fn func(ContextChange)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.watchers = append(c.watchers, fn)
+}
+
+func (c *ScriptContext) notify(watchers []func(ContextChange), change ContextChange) {
+	for _, fn := range watchers {
+		fn(change)
+	}
+}
+
+// Set 写入值，无法JSON序列化的值返回错误且不写入
+func (c *ScriptContext) Set(key string, value interface{}) error {
+	normalized, err := normalizeContextValue(value)
+	if err != nil {
+		return fmt.Errorf("context key %s: %w", key, err)
+	}
+
+	c.mutex.Lock()
+	old := c.values[key]
+	c.values[key] = normalized
+	watchers := c.watchers
+	c.mutex.Unlock()
+
+	c.notify(watchers, ContextChange{Key: key, Old: old, New: normalized})
+	return nil
+}
+
+// Delete 删除键，不存在时不通知
+func (c *ScriptContext) Delete(key string) {
+	c.mutex.Lock()
+	old, exists := c.values[key]
+	delete(c.values, key)
+	watchers := c.watchers
+	c.mutex.Unlock()
+
+	if exists {
+		c.notify(watchers, ContextChange{Key: key, Old: old, Deleted: true})
+	}
+}
+
+// Get 读取原始值
+func (c *ScriptContext) Get(key string) (interface{}, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	value, exists := c.values[key]
+	return value, exists
+}
+
+// GetString 读取字符串，数字和布尔值会格式化为字符串
+func (c *ScriptContext) GetString(key string) (string, bool) {
+	value, exists := c.Get(key)
+	if !exists {
+		return "", false
+	}
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+// GetBool 读取布尔值，缺失或类型不符时返回false
+func (c *ScriptContext) GetBool(key string) bool {
+	value, exists := c.Get(key)
+	if !exists {
+		return false
+	}
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	default:
+		return false
+	}
+}
+
+// GetInt 读取整数，接受整数值的数字和数字字符串
+func (c *ScriptContext) GetInt(key string) (int, bool) {
+	value, exists := c.Get(key)
+	if !exists {
+		return 0, false
+	}
+	switch v := value.(type) {
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, false
+		}
+		return int(v), true
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// Snapshot 返回当前值的副本，用于落库和序列化
+func (c *ScriptContext) Snapshot() map[string]interface{} {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	snapshot := make(map[string]interface{}, len(c.values))
+	for key, value := range c.values {
+		snapshot[key] = value
+	}
+	return snapshot
+}
+
+// MarshalJSON 按普通对象序列化
+func (c *ScriptContext) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Snapshot())
+}
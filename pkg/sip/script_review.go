@@ -0,0 +1,345 @@
+package sip1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// auditTargetScript 脚本审计日志的对象类型
+	auditTargetScript = "script"
+	// auditActorSystem 自动回滚、转正等系统操作的操作人
+	auditActorSystem = "system"
+	// scriptAuditLogLimit 审批详情中返回的审计日志条数
+	scriptAuditLogLimit = 100
+)
+
+var (
+	// ErrScriptNotApproved 脚本版本未通过审批，不能上线
+	ErrScriptNotApproved = errors.New("script version is not approved")
+	// ErrSelfApproval 提交人不能批准自己提交的版本
+	ErrSelfApproval = errors.New("script version must be approved by someone other than the submitter")
+)
+
+// FieldChange 一个字段在两个版本间的变化，值为文本形式
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// StepChange 同一步骤ID在两个版本间的变化
+type StepChange struct {
+	StepID  string        `json:"stepId"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// ScriptDiff 两个脚本版本的结构差异：脚本配置、新增和删除的步骤、步骤配置及提示词的修改
+type ScriptDiff struct {
+	BaseScriptID uint          `json:"baseScriptId"`
+	ScriptID     uint          `json:"scriptId"`
+	Script       []FieldChange `json:"script,omitempty"`
+	StepsAdded   []string      `json:"stepsAdded,omitempty"`
+	StepsRemoved []string      `json:"stepsRemoved,omitempty"`
+	StepsChanged []StepChange  `json:"stepsChanged,omitempty"`
+}
+
+// Empty 两个版本是否没有差异
+func (d ScriptDiff) Empty() bool {
+	return len(d.Script) == 0 && len(d.StepsAdded) == 0 && len(d.StepsRemoved) == 0 && len(d.StepsChanged) == 0
+}
+
+// ScriptReview 审批人查看的脚本版本、与上一版本的差异和审计记录
+type ScriptReview struct {
+	Script    *models.AIPhoneScript `json:"script"`
+	Diff      ScriptDiff            `json:"diff"`
+	AuditLogs []models.AuditLog     `json:"auditLogs"`
+}
+
+// DiffScripts 比较脚本版本，base为nil时所有步骤都算新增
+func DiffScripts(base, script *models.AIPhoneScript) ScriptDiff {
+	diff := ScriptDiff{ScriptID: script.ID}
+	if base == nil {
+		base = &models.AIPhoneScript{}
+	} else {
+		diff.BaseScriptID = base.ID
+	}
+
+	diff.Script = diffFields(
+		map[string]string{
+			"name":          base.Name,
+			"speakerId":     base.SpeakerID,
+			"startStepId":   base.StartStepID,
+			"maxDuration":   strconv.Itoa(base.MaxDuration),
+			"maxSteps":      strconv.Itoa(base.MaxSteps),
+			"timeoutAction": base.TimeoutAction,
+		},
+		map[string]string{
+			"name":          script.Name,
+			"speakerId":     script.SpeakerID,
+			"startStepId":   script.StartStepID,
+			"maxDuration":   strconv.Itoa(script.MaxDuration),
+			"maxSteps":      strconv.Itoa(script.MaxSteps),
+			"timeoutAction": script.TimeoutAction,
+		})
+
+	baseSteps := make(map[string]*models.AIPhoneScriptStep, len(base.Steps))
+	for i := range base.Steps {
+		baseSteps[base.Steps[i].StepID] = &base.Steps[i]
+	}
+	seen := make(map[string]bool, len(script.Steps))
+	for i := range script.Steps {
+		step := &script.Steps[i]
+		seen[step.StepID] = true
+		old, exists := baseSteps[step.StepID]
+		if !exists {
+			diff.StepsAdded = append(diff.StepsAdded, step.StepID)
+			continue
+		}
+		if changes := diffFields(stepFields(old), stepFields(step)); len(changes) > 0 {
+			diff.StepsChanged = append(diff.StepsChanged, StepChange{StepID: step.StepID, Changes: changes})
+		}
+	}
+	for _, step := range base.Steps {
+		if !seen[step.StepID] {
+			diff.StepsRemoved = append(diff.StepsRemoved, step.StepID)
+		}
+	}
+	sort.Strings(diff.StepsAdded)
+	sort.Strings(diff.StepsRemoved)
+	sort.Slice(diff.StepsChanged, func(i, j int) bool { return diff.StepsChanged[i].StepID < diff.StepsChanged[j].StepID })
+	return diff
+}
+
+// stepFields 步骤配置的文本形式，步骤数据按JSON字段名展开，提示词等修改逐字段列出
+func stepFields(step *models.AIPhoneScriptStep) map[string]string {
+	fields := map[string]string{
+		"name":    step.Name,
+		"type":    string(step.Type),
+		"groupId": step.GroupID,
+		"order":   strconv.Itoa(step.Order),
+		"enabled": strconv.FormatBool(step.Enabled),
+		"timeout": strconv.Itoa(step.Timeout),
+	}
+	data, err := json.Marshal(step.Data)
+	if err != nil {
+		return fields
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fields
+	}
+	for key, value := range values {
+		if text, ok := value.(string); ok {
+			fields["data."+key] = text
+			continue
+		}
+		encoded, _ := json.Marshal(value)
+		fields["data."+key] = string(encoded)
+	}
+	return fields
+}
+
+// diffFields 按字段名排序返回值不同的字段，缺少的字段按空值比较
+func diffFields(old, next map[string]string) []FieldChange {
+	names := make([]string, 0, len(next))
+	for name := range next {
+		names = append(names, name)
+	}
+	for name := range old {
+		if _, ok := next[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []FieldChange
+	for _, name := range names {
+		if old[name] != next[name] {
+			changes = append(changes, FieldChange{Field: name, Old: old[name], New: next[name]})
+		}
+	}
+	return changes
+}
+
+// audit 写入脚本审计日志，失败只记录日志
+func (engine *AIPhoneEngine) audit(actor, action string, scriptID uint, detail interface{}) {
+	if err := models.CreateAuditLog(engine.db, actor, action, auditTargetScript, scriptID, detail); err != nil {
+		logger.Error("Failed to write audit log",
+			zap.String("action", action),
+			zap.Uint("script_id", scriptID),
+			zap.Error(err))
+	}
+}
+
+// scriptDiff 加载脚本并与它的上一版本比较
+func (engine *AIPhoneEngine) scriptDiff(script *models.AIPhoneScript) (ScriptDiff, error) {
+	if script.BaseScriptID == 0 {
+		return DiffScripts(nil, script), nil
+	}
+	base, err := models.GetAIPhoneScriptByID(engine.db, script.BaseScriptID)
+	if err != nil {
+		return ScriptDiff{}, fmt.Errorf("failed to load base script %d: %w", script.BaseScriptID, err)
+	}
+	return DiffScripts(base, script), nil
+}
+
+// SubmitScript 编辑提交草稿或被驳回的脚本版本等待审批，baseScriptID为对比差异的上一版本，0保留原设置
+func (engine *AIPhoneEngine) SubmitScript(scriptID, baseScriptID uint, editor string) (*ScriptDiff, error) {
+	if editor == "" {
+		return nil, fmt.Errorf("editor is required")
+	}
+	script, err := models.GetAIPhoneScriptByID(engine.db, scriptID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load script: %w", err)
+	}
+	if script.Status != models.ScriptStatusDraft && script.Status != models.ScriptStatusRejected {
+		return nil, fmt.Errorf("script in status %s cannot be submitted", script.Status)
+	}
+	if baseScriptID != 0 {
+		script.BaseScriptID = baseScriptID
+	}
+	diff, err := engine.scriptDiff(script)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = engine.db.Model(script).Updates(map[string]interface{}{
+		"status":         models.ScriptStatusPendingReview,
+		"base_script_id": script.BaseScriptID,
+		"submitted_by":   editor,
+		"submitted_at":   now,
+		"approved_by":    "",
+		"approved_at":    nil,
+		"review_comment": "",
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit script: %w", err)
+	}
+	engine.audit(editor, "script.submitted", scriptID, diff)
+	logger.Info("Script submitted for approval",
+		zap.Uint("script_id", scriptID),
+		zap.Uint("base_script_id", script.BaseScriptID),
+		zap.String("editor", editor))
+	return &diff, nil
+}
+
+// ReviewScript 返回脚本版本、与上一版本的差异和审计记录
+func (engine *AIPhoneEngine) ReviewScript(scriptID uint) (*ScriptReview, error) {
+	script, err := models.GetAIPhoneScriptByID(engine.db, scriptID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load script: %w", err)
+	}
+	diff, err := engine.scriptDiff(script)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := models.GetAuditLogs(engine.db, auditTargetScript, scriptID, scriptAuditLogLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load audit logs: %w", err)
+	}
+	return &ScriptReview{Script: script, Diff: diff, AuditLogs: logs}, nil
+}
+
+// ApproveScript 批准待审批的脚本版本，批准人不能是提交人
+func (engine *AIPhoneEngine) ApproveScript(scriptID uint, approver, comment string) error {
+	script, err := engine.pendingReview(scriptID, approver)
+	if err != nil {
+		return err
+	}
+	if approver == script.SubmittedBy {
+		return ErrSelfApproval
+	}
+	diff, err := engine.scriptDiff(script)
+	if err != nil {
+		return err
+	}
+
+	err = engine.db.Model(script).Updates(map[string]interface{}{
+		"status":         models.ScriptStatusApproved,
+		"approved_by":    approver,
+		"approved_at":    time.Now(),
+		"review_comment": comment,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to approve script: %w", err)
+	}
+	engine.audit(approver, "script.approved", scriptID, map[string]interface{}{"comment": comment, "diff": diff})
+	logger.Info("Script approved",
+		zap.Uint("script_id", scriptID),
+		zap.String("submitter", script.SubmittedBy),
+		zap.String("approver", approver))
+	return nil
+}
+
+// RejectScript 驳回待审批的脚本版本，编辑修改后可重新提交
+func (engine *AIPhoneEngine) RejectScript(scriptID uint, reviewer, comment string) error {
+	if _, err := engine.pendingReview(scriptID, reviewer); err != nil {
+		return err
+	}
+	err := engine.db.Model(&models.AIPhoneScript{}).Where("id = ?", scriptID).Updates(map[string]interface{}{
+		"status":         models.ScriptStatusRejected,
+		"review_comment": comment,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to reject script: %w", err)
+	}
+	engine.audit(reviewer, "script.rejected", scriptID, map[string]interface{}{"comment": comment})
+	logger.Info("Script rejected", zap.Uint("script_id", scriptID), zap.String("reviewer", reviewer))
+	return nil
+}
+
+func (engine *AIPhoneEngine) pendingReview(scriptID uint, reviewer string) (*models.AIPhoneScript, error) {
+	if reviewer == "" {
+		return nil, fmt.Errorf("reviewer is required")
+	}
+	script, err := models.GetAIPhoneScriptByID(engine.db, scriptID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load script: %w", err)
+	}
+	if script.Status != models.ScriptStatusPendingReview {
+		return nil, fmt.Errorf("script in status %s is not pending review", script.Status)
+	}
+	return script, nil
+}
+
+// approvedScript 加载可上线的脚本版本：已批准且未在运行
+func (engine *AIPhoneEngine) approvedScript(scriptID uint) (*models.AIPhoneScript, error) {
+	script, err := models.GetAIPhoneScriptByID(engine.db, scriptID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load script: %w", err)
+	}
+	if !script.IsApproved() {
+		return nil, ErrScriptNotApproved
+	}
+	if script.Status != models.ScriptStatusApproved && script.Status != models.ScriptStatusInactive {
+		return nil, fmt.Errorf("script in status %s cannot be activated", script.Status)
+	}
+	return script, nil
+}
+
+// ActivateScript 上线已批准的脚本版本，全部呼叫从上一版本切换到该版本
+func (engine *AIPhoneEngine) ActivateScript(scriptID uint, actor string) error {
+	script, err := engine.approvedScript(scriptID)
+	if err != nil {
+		return err
+	}
+	if err := models.ActivateAIPhoneScript(engine.db, scriptID, script.BaseScriptID); err != nil {
+		return fmt.Errorf("failed to activate script: %w", err)
+	}
+	engine.audit(actor, "script.activated", scriptID, map[string]interface{}{"previousScriptId": script.BaseScriptID})
+	logger.Info("Script activated",
+		zap.Uint("script_id", scriptID),
+		zap.Uint("previous_script_id", script.BaseScriptID),
+		zap.String("actor", actor))
+	return nil
+}
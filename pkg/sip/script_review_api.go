@@ -0,0 +1,82 @@
+package sip1
+
+import (
+	"github.com/LingByte/LingSIP/pkg/auth"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterScriptReviewAPIs 注册脚本审批接口：POST /scripts/:id/submit {"baseScriptId":1}
+// 提交版本，GET /scripts/:id/review 查看与上一版本的差异和审计记录，POST /scripts/:id/approve
+// {"comment":"..."} 批准（批准人不能是提交人），POST /scripts/:id/reject 驳回，
+// POST /scripts/:id/activate 上线已批准的版本。操作人取认证的令牌名，不接受请求体中的自报身份，
+// 否则同一个人换个名字就能自己批准
+func RegisterScriptReviewAPIs(r gin.IRoutes, server *SipServer) {
+	r.POST("/scripts/:id/submit", requireActor, func(c *gin.Context) {
+		engine, id, ok := scriptEngine(c, server)
+		if !ok {
+			return
+		}
+		var form struct {
+			BaseScriptID uint `json:"baseScriptId"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		diff, err := engine.SubmitScript(id, form.BaseScriptID, auth.CurrentActor(c))
+		if err != nil {
+			response.Fail(c, "failed to submit script", err.Error())
+			return
+		}
+		response.Success(c, "ok", diff)
+	})
+
+	r.GET("/scripts/:id/review", func(c *gin.Context) {
+		engine, id, ok := scriptEngine(c, server)
+		if !ok {
+			return
+		}
+		review, err := engine.ReviewScript(id)
+		if err != nil {
+			response.Fail(c, "failed to load script review", err.Error())
+			return
+		}
+		response.Success(c, "ok", review)
+	})
+
+	review := func(decide func(engine *AIPhoneEngine, id uint, actor, comment string) error, failure string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			engine, id, ok := scriptEngine(c, server)
+			if !ok {
+				return
+			}
+			var form struct {
+				Comment string `json:"comment"`
+			}
+			if err := c.ShouldBindJSON(&form); err != nil {
+				response.Fail(c, "invalid request", err.Error())
+				return
+			}
+			if err := decide(engine, id, auth.CurrentActor(c), form.Comment); err != nil {
+				response.Fail(c, failure, err.Error())
+				return
+			}
+			response.Success(c, "ok", nil)
+		}
+	}
+	r.POST("/scripts/:id/approve", requireActor, review((*AIPhoneEngine).ApproveScript, "failed to approve script"))
+	r.POST("/scripts/:id/reject", requireActor, review((*AIPhoneEngine).RejectScript, "failed to reject script"))
+
+	r.POST("/scripts/:id/activate", requireActor, func(c *gin.Context) {
+		engine, id, ok := scriptEngine(c, server)
+		if !ok {
+			return
+		}
+		if err := engine.ActivateScript(id, auth.CurrentActor(c)); err != nil {
+			response.Fail(c, "failed to activate script", err.Error())
+			return
+		}
+		response.Success(c, "ok", nil)
+	})
+}
@@ -0,0 +1,115 @@
+package sip1
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB 迁移了tables的内存数据库
+func newTestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// apiResponse 接口返回的统一格式
+type apiResponse struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data"`
+}
+
+// newAPITestRouter 注册接口的路由，请求头X-Test-Actor模拟auth.RequireAdmin认证出的操作人
+func newAPITestRouter(register func(r gin.IRoutes)) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	register(router.Group("/api", func(c *gin.Context) {
+		if actor := c.GetHeader("X-Test-Actor"); actor != "" {
+			auth.SetActor(c, actor)
+		}
+	}))
+	return router
+}
+
+// callAPI 以actor身份发送JSON请求，body为nil时不带请求体
+func callAPI(t *testing.T, router *gin.Engine, method, path, actor string, body interface{}) apiResponse {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if actor != "" {
+		req.Header.Set("X-Test-Actor", actor)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var res apiResponse
+	if w.Code != http.StatusOK {
+		res.Code = w.Code
+		return res
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("%s %s: invalid response %q: %v", method, path, w.Body.String(), err)
+	}
+	return res
+}
+
+func TestScriptReviewUsesAuthenticatedActor(t *testing.T) {
+	db := newTestDB(t, &models.AIPhoneScript{}, &models.AIPhoneScriptStep{}, &models.ScriptPhoneMapping{}, &models.AuditLog{})
+	script := &models.AIPhoneScript{Name: "review", Status: models.ScriptStatusDraft}
+	if err := db.Create(script).Error; err != nil {
+		t.Fatal(err)
+	}
+	server := &SipServer{aiEngine: &AIPhoneEngine{db: db}}
+	router := newAPITestRouter(func(r gin.IRoutes) { RegisterScriptReviewAPIs(r, server) })
+
+	if res := callAPI(t, router, http.MethodPost, "/api/scripts/1/submit", "", map[string]interface{}{"actor": "bob"}); res.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated submit code = %d, want 401", res.Code)
+	}
+	// 请求体里的actor不再被采用：alice自称bob提交后仍不能自己批准
+	if res := callAPI(t, router, http.MethodPost, "/api/scripts/1/submit", "alice", map[string]interface{}{"actor": "bob"}); res.Code != 200 {
+		t.Fatalf("submit: %+v", res)
+	}
+	if res := callAPI(t, router, http.MethodPost, "/api/scripts/1/approve", "alice", map[string]interface{}{"actor": "carol"}); string(res.Data) != strconv.Quote(ErrSelfApproval.Error()) {
+		t.Fatalf("self approval claiming another actor: %+v, want %v", res, ErrSelfApproval)
+	}
+	stored, err := models.GetAIPhoneScriptByID(db, script.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.SubmittedBy != "alice" || stored.Status != models.ScriptStatusPendingReview {
+		t.Fatalf("after self approval: submitted by %q, status %s", stored.SubmittedBy, stored.Status)
+	}
+
+	if res := callAPI(t, router, http.MethodPost, "/api/scripts/1/approve", "bob", map[string]interface{}{"comment": "lgtm"}); res.Code != 200 {
+		t.Fatalf("approve by another operator: %+v", res)
+	}
+	if stored, _ = models.GetAIPhoneScriptByID(db, script.ID); stored.ApprovedBy != "bob" || stored.Status != models.ScriptStatusApproved {
+		t.Errorf("approved by %q, status %s", stored.ApprovedBy, stored.Status)
+	}
+}
@@ -0,0 +1,83 @@
+package sip1
+
+import (
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// sessionJanitorInterval 清理过期会话的间隔
+const sessionJanitorInterval = time.Minute
+
+// runSessionJanitor 定期清理超过SessionTimeout的待接通会话、失去对话的活跃会话和过期的注册
+func (as *SipServer) runSessionJanitor() {
+	ticker := time.NewTicker(sessionJanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-as.stopChan:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			as.expireSessions(now)
+			as.expireRegistrations(now)
+		}
+	}
+}
+
+// expireSessions 200 OK后一直没有ACK或CANCEL的待接通会话（包括文件存储中遗留的会话文件）
+// 与对话已经不存在的活跃会话保存超过SessionTimeout后清理
+func (as *SipServer) expireSessions(now time.Time) {
+	before := now.Add(-as.config.SessionTimeout)
+
+	expired, err := as.config.ExpirePendingSessions(before)
+	if err != nil {
+		logger.Warn("Failed to expire pending sessions", zap.Error(err))
+	}
+	for _, callID := range expired {
+		logger.Info("Expired pending session without ACK", zap.String("call_id", callID))
+		as.removeDialog(callID)
+		as.removeCallCodec(callID)
+		as.quotas.release(callID)
+		as.leaveQueue(callID)
+		as.stopICE(callID)
+		as.stopBridge(callID)
+		as.takeScriptNumber(callID)
+		as.updateCallStatus(callID, models.SipCallStatusFailed, nil)
+	}
+
+	// 通话中的活跃会话都有对话，没有对话说明挂断时没有清理干净
+	for _, callID := range as.config.ActiveSessionsStartedBefore(before) {
+		if _, exists := as.getDialog(callID); exists {
+			continue
+		}
+		logger.Info("Expired stale active session", zap.String("call_id", callID))
+		if as.aiEngine != nil {
+			as.aiEngine.StopSession(callID)
+		}
+		as.teardownCall(callID)
+		as.updateCallStatus(callID, models.SipCallStatusEnded, &now)
+	}
+	// 共享到redis的活跃会话按SessionTimeout过期，仍在通话中的续期
+	as.config.RefreshSharedSessions()
+}
+
+// expireRegistrations 清理Expires到期后没有续订的注册
+func (as *SipServer) expireRegistrations(now time.Time) {
+	expired, err := as.config.ExpireRegistrations(now)
+	if err != nil {
+		logger.Warn("Failed to expire registrations", zap.Error(err))
+	}
+	for _, binding := range expired {
+		logger.Info("SIP user registration expired",
+			zap.String("username", binding.Username),
+			zap.String("contact", binding.URI))
+		if bindings, err := as.config.Bindings(binding.Username); err == nil && len(bindings) == 0 {
+			as.forgetContact(binding.Username)
+		}
+		as.presenceChanged(binding.Username)
+	}
+}
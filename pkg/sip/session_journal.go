@@ -0,0 +1,362 @@
+package sip1
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// sessionJournalFile 会话预写日志文件名，位于JournalDir（未配置时为存储目录）下
+const sessionJournalFile = "session_journal.jsonl"
+
+// interruptedMessage 重启后补记未结束会话和步骤的错误信息
+const interruptedMessage = "Interrupted by server restart"
+
+// sessionJournalCompactSize 日志超过该大小时，会话结束后只保留进行中会话的事件
+const sessionJournalCompactSize = 4 << 20
+
+type sessionJournalEventType string
+
+const (
+	journalSessionStarted sessionJournalEventType = "session_started"
+	journalStepStarted    sessionJournalEventType = "step_started"
+	journalStepFinished   sessionJournalEventType = "step_finished"
+	journalSessionEnded   sessionJournalEventType = "session_ended"
+)
+
+// sessionJournalEvent 会话或步骤事件，发生时先写日志再更新数据库
+type sessionJournalEvent struct {
+	Type        sessionJournalEventType `json:"type"`
+	At          time.Time               `json:"at"`
+	SessionID   string                  `json:"sessionId"`
+	DBID        uint                    `json:"dbId,omitempty"`
+	CallID      string                  `json:"callId,omitempty"`
+	StepID      string                  `json:"stepId,omitempty"`
+	ExecutionID uint                    `json:"executionId,omitempty"`
+	Status      string                  `json:"status,omitempty"`
+	Error       string                  `json:"error,omitempty"`
+}
+
+// sessionJournal 会话和步骤事件的预写日志。进程崩溃后数据库中会留下运行中的会话，
+// 重启时按日志补齐结束状态和时间；没有进行中的会话时清空日志，一直有会话进行时
+// 超过compactSize后改写为只含进行中会话的事件
+type sessionJournal struct {
+	mutex       sync.Mutex
+	path        string
+	file        *os.File
+	sync        bool
+	open        map[string]bool // 尚未落库结束的会话
+	size        int64
+	compactSize int64
+}
+
+// openSessionJournal 打开日志用于追加
+func openSessionJournal(path string, sync bool) (*sessionJournal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session journal: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat session journal: %w", err)
+	}
+	return &sessionJournal{
+		path:        path,
+		file:        file,
+		sync:        sync,
+		open:        make(map[string]bool),
+		size:        info.Size(),
+		compactSize: sessionJournalCompactSize,
+	}, nil
+}
+
+// record 追加一条事件，journal为nil时忽略
+func (j *sessionJournal) record(event sessionJournalEvent) {
+	if j == nil {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("Failed to marshal session journal event", zap.Error(err))
+		return
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if event.Type == journalSessionStarted {
+		j.open[event.SessionID] = true
+	}
+	n, err := j.file.Write(append(data, '\n'))
+	j.size += int64(n)
+	if err != nil {
+		logger.Error("Failed to write session journal",
+			zap.String("session_id", event.SessionID),
+			zap.Error(err))
+		return
+	}
+	if j.sync {
+		j.file.Sync()
+	}
+}
+
+// finalize 会话结束状态已写入数据库，所有会话都结束后清空日志，日志过大时压缩
+func (j *sessionJournal) finalize(sessionID string) {
+	if j == nil {
+		return
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	delete(j.open, sessionID)
+	if len(j.open) == 0 {
+		if err := j.file.Truncate(0); err != nil {
+			logger.Warn("Failed to compact session journal", zap.Error(err))
+			return
+		}
+		j.size = 0
+		return
+	}
+	if j.size >= j.compactSize {
+		if err := j.compact(); err != nil {
+			logger.Warn("Failed to compact session journal", zap.Error(err))
+		}
+	}
+}
+
+// compact 把进行中会话的事件写入临时文件后替换日志，崩溃时旧日志仍完整。调用方持有mutex
+func (j *sessionJournal) compact() error {
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		return err
+	}
+	var kept bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var event struct {
+			SessionID string `json:"sessionId"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &event) == nil && j.open[event.SessionID] {
+			kept.Write(scanner.Bytes())
+			kept.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	tmpPath := j.path + ".tmp"
+	if err := os.WriteFile(tmpPath, kept.Bytes(), 0644); err != nil {
+		return err
+	}
+	if j.sync {
+		if tmp, err := os.Open(tmpPath); err == nil {
+			tmp.Sync()
+			tmp.Close()
+		}
+	}
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	logger.Info("Compacted session journal",
+		zap.Int64("from_bytes", j.size),
+		zap.Int("to_bytes", kept.Len()),
+		zap.Int("open_sessions", len(j.open)))
+	j.size = int64(kept.Len())
+	return nil
+}
+
+// journaledStep 日志中的一次步骤执行
+type journaledStep struct {
+	finished bool
+	status   string
+	at       time.Time
+	errorMsg string
+}
+
+// journaledSession 按日志重建的会话状态
+type journaledSession struct {
+	dbID     uint
+	callID   string
+	stepID   string
+	lastAt   time.Time // 最后一次有记录的时间，未结束的会话以此作为结束时间
+	ended    bool
+	status   string
+	endAt    time.Time
+	errorMsg string
+	steps    map[uint]*journaledStep
+}
+
+// readSessionJournal 读取日志并按会话归并，崩溃可能留下不完整的最后一行
+func readSessionJournal(path string) (map[string]*journaledSession, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sessions := make(map[string]*journaledSession)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var event sessionJournalEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.SessionID == "" {
+			continue
+		}
+		session, exists := sessions[event.SessionID]
+		if !exists {
+			session = &journaledSession{steps: make(map[uint]*journaledStep)}
+			sessions[event.SessionID] = session
+		}
+		if event.At.After(session.lastAt) {
+			session.lastAt = event.At
+		}
+		switch event.Type {
+		case journalSessionStarted:
+			session.dbID = event.DBID
+			session.callID = event.CallID
+		case journalStepStarted:
+			session.stepID = event.StepID
+			if event.ExecutionID != 0 {
+				session.steps[event.ExecutionID] = &journaledStep{}
+			}
+		case journalStepFinished:
+			if event.ExecutionID != 0 {
+				session.steps[event.ExecutionID] = &journaledStep{
+					finished: true,
+					status:   event.Status,
+					at:       event.At,
+					errorMsg: event.Error,
+				}
+			}
+		case journalSessionEnded:
+			session.ended = true
+			session.status = event.Status
+			session.endAt = event.At
+			session.errorMsg = event.Error
+		}
+	}
+	return sessions, scanner.Err()
+}
+
+// recoverSessions 按上次运行的日志修正数据库：已记录结束但未落库的会话补写结束状态，
+// 没有结束记录的会话和步骤标记为失败，结束时间取最后一条事件的时间
+func recoverSessions(db *gorm.DB, path string) (int, error) {
+	sessions, err := readSessionJournal(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read session journal: %w", err)
+	}
+
+	ids := make([]string, 0, len(sessions))
+	for sessionID := range sessions {
+		ids = append(ids, sessionID)
+	}
+	sort.Strings(ids)
+
+	recovered := 0
+	for _, sessionID := range ids {
+		journaled := sessions[sessionID]
+		if journaled.dbID == 0 {
+			continue
+		}
+		if err := recoverSteps(db, journaled); err != nil {
+			return recovered, err
+		}
+
+		var dbSession models.AIPhoneSession
+		if err := db.First(&dbSession, journaled.dbID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return recovered, fmt.Errorf("failed to load session %s: %w", sessionID, err)
+		}
+		if dbSession.EndTime != nil && !dbSession.IsRunning() {
+			continue
+		}
+
+		if journaled.ended {
+			endAt := journaled.endAt
+			dbSession.Status = models.SessionStatus(journaled.status)
+			dbSession.EndTime = &endAt
+			if journaled.errorMsg != "" {
+				dbSession.ErrorMessage = journaled.errorMsg
+			}
+		} else {
+			lastAt := journaled.lastAt
+			dbSession.Status = models.SessionStatusFailed
+			dbSession.EndTime = &lastAt
+			dbSession.ErrorMessage = interruptedMessage
+		}
+		if journaled.stepID != "" {
+			dbSession.CurrentStepID = journaled.stepID
+		}
+		dbSession.CalculateDuration()
+		if err := models.UpdateAIPhoneSession(db, &dbSession); err != nil {
+			return recovered, fmt.Errorf("failed to finalize session %s: %w", sessionID, err)
+		}
+		recovered++
+		logger.Info("Recovered AI phone session from journal",
+			zap.String("session_id", sessionID),
+			zap.String("call_id", journaled.callID),
+			zap.String("status", string(dbSession.Status)),
+			zap.Time("end_time", *dbSession.EndTime))
+	}
+	return recovered, nil
+}
+
+// recoverSteps 结束日志中仍在执行的步骤
+func recoverSteps(db *gorm.DB, journaled *journaledSession) error {
+	for executionID, step := range journaled.steps {
+		var execution models.StepExecution
+		if err := db.First(&execution, executionID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return fmt.Errorf("failed to load step execution %d: %w", executionID, err)
+		}
+		if execution.Status != models.StepStatusRunning && execution.Status != models.StepStatusPending {
+			continue
+		}
+
+		endAt := journaled.lastAt
+		if step.finished {
+			endAt = step.at
+			execution.Status = models.StepExecutionStatus(step.status)
+			execution.ErrorMessage = step.errorMsg
+		} else {
+			execution.Status = models.StepStatusFailed
+			execution.ErrorMessage = interruptedMessage
+		}
+		execution.EndTime = &endAt
+		execution.Duration = int(endAt.Sub(execution.StartTime).Milliseconds())
+		if err := db.Save(&execution).Error; err != nil {
+			return fmt.Errorf("failed to finalize step execution %d: %w", executionID, err)
+		}
+	}
+	return nil
+}
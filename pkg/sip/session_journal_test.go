@@ -0,0 +1,128 @@
+package sip1
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+)
+
+// newTestJournal 在临时目录中打开会话日志
+func newTestJournal(t *testing.T) *sessionJournal {
+	t.Helper()
+	journal, err := openSessionJournal(filepath.Join(t.TempDir(), sessionJournalFile), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { journal.file.Close() })
+	return journal
+}
+
+func journalSize(t *testing.T, journal *sessionJournal) int64 {
+	t.Helper()
+	info, err := os.Stat(journal.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestSessionJournalCompaction(t *testing.T) {
+	journal := newTestJournal(t)
+	journal.compactSize = 512
+	start := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	journal.record(sessionJournalEvent{Type: journalSessionStarted, At: start, SessionID: "long", DBID: 1, CallID: "call-long"})
+
+	// 一直有会话进行时日志不会被清空，超过阈值后只保留进行中会话的事件
+	for i, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		at := start.Add(time.Duration(i) * time.Minute)
+		journal.record(sessionJournalEvent{Type: journalSessionStarted, At: at, SessionID: id, DBID: uint(i + 2)})
+		journal.record(sessionJournalEvent{Type: journalStepStarted, At: at, SessionID: id, StepID: "greeting", ExecutionID: uint(i + 10)})
+		journal.record(sessionJournalEvent{Type: journalSessionEnded, At: at.Add(time.Second), SessionID: id, Status: "completed"})
+		journal.finalize(id)
+		if size := journalSize(t, journal); size != journal.size || size >= journal.compactSize+200 {
+			t.Fatalf("journal size %d (tracked %d) after session %s", size, journal.size, id)
+		}
+	}
+	journal.record(sessionJournalEvent{Type: journalStepStarted, At: start.Add(time.Hour), SessionID: "long", StepID: "survey", ExecutionID: 99})
+
+	sessions, err := readSessionJournal(journal.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := sessions["long"]
+	if long == nil || long.dbID != 1 || long.callID != "call-long" || long.stepID != "survey" || long.steps[99] == nil {
+		t.Fatalf("long session after compaction = %+v", long)
+	}
+	if len(sessions) > 3 {
+		t.Errorf("journal still holds %d sessions, want finished ones compacted away", len(sessions))
+	}
+	if _, err := os.Stat(journal.path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temp file left after compaction: %v", err)
+	}
+
+	journal.finalize("long")
+	if size := journalSize(t, journal); size != 0 || journal.size != 0 {
+		t.Errorf("journal size after all sessions ended = %d (tracked %d), want 0", size, journal.size)
+	}
+}
+
+func TestRecoverSessions(t *testing.T) {
+	db := newTestDB(t, &models.AIPhoneSession{}, &models.StepExecution{})
+	start := time.Date(2026, 1, 5, 9, 0, 0, 0, time.Local)
+	finishedAt := start.Add(time.Minute)
+	sessions := []models.AIPhoneSession{
+		{SessionID: "ended-in-journal", ScriptID: 1, Status: models.SessionStatusRunning, StartTime: start},
+		{SessionID: "interrupted", ScriptID: 1, Status: models.SessionStatusRunning, StartTime: start},
+		{SessionID: "already-ended", ScriptID: 1, Status: models.SessionStatusCompleted, StartTime: start, EndTime: &finishedAt},
+	}
+	for i := range sessions {
+		if err := db.Create(&sessions[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	execution := models.StepExecution{SessionID: sessions[1].ID, StepID: "greeting", StepName: "问候", StepType: models.StepTypeCallout, Status: models.StepStatusRunning, StartTime: start}
+	if err := db.Create(&execution).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	journal := newTestJournal(t)
+	for _, event := range []sessionJournalEvent{
+		{Type: journalSessionStarted, At: start, SessionID: "ended-in-journal", DBID: sessions[0].ID},
+		{Type: journalSessionEnded, At: start.Add(2 * time.Minute), SessionID: "ended-in-journal", Status: string(models.SessionStatusCompleted)},
+		{Type: journalSessionStarted, At: start, SessionID: "interrupted", DBID: sessions[1].ID},
+		{Type: journalStepStarted, At: start.Add(30 * time.Second), SessionID: "interrupted", StepID: "greeting", ExecutionID: execution.ID},
+		{Type: journalSessionStarted, At: start, SessionID: "already-ended", DBID: sessions[2].ID},
+	} {
+		journal.record(event)
+	}
+	// 崩溃留下不完整的最后一行
+	journal.file.WriteString(`{"type":"session_ended","sessionId":"interr`)
+
+	recovered, err := recoverSessions(db, journal.path)
+	if err != nil || recovered != 2 {
+		t.Fatalf("recoverSessions() = %d, %v, want 2", recovered, err)
+	}
+	var got []models.AIPhoneSession
+	db.Order("id").Find(&got)
+	if got[0].Status != models.SessionStatusCompleted || !got[0].EndTime.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("session ended in journal = %s %v", got[0].Status, got[0].EndTime)
+	}
+	if got[1].Status != models.SessionStatusFailed || got[1].ErrorMessage != interruptedMessage || got[1].CurrentStepID != "greeting" || !got[1].EndTime.Equal(start.Add(30*time.Second)) {
+		t.Errorf("interrupted session = %s %q %s %v", got[1].Status, got[1].ErrorMessage, got[1].CurrentStepID, got[1].EndTime)
+	}
+	if !got[2].EndTime.Equal(finishedAt) {
+		t.Errorf("already ended session end time changed to %v", got[2].EndTime)
+	}
+	var step models.StepExecution
+	db.First(&step, execution.ID)
+	if step.Status != models.StepStatusFailed || step.Duration != 30000 {
+		t.Errorf("interrupted step = %s, %dms", step.Status, step.Duration)
+	}
+
+	if recovered, err := recoverSessions(db, filepath.Join(t.TempDir(), "missing.jsonl")); err != nil || recovered != 0 {
+		t.Errorf("recoverSessions() without a journal = %d, %v", recovered, err)
+	}
+}
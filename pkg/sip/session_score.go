@@ -0,0 +1,79 @@
+package sip1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/LingByte/LingSIP/internal/models"
+)
+
+// errNoConversation 会话中来电者没有说话，无法评分
+var errNoConversation = errors.New("session has no caller speech")
+
+// scoreConversation 用LLM按0-100评估AI客服在对话中的服务质量
+func (engine *AIPhoneEngine) scoreConversation(conversation []models.ConversationMessage) (float32, error) {
+	assistant := engine.services().Assistant
+	if assistant == nil {
+		return 0, errors.New("no assistant configured")
+	}
+	prompt, ok := buildScorePrompt(conversation)
+	if !ok {
+		return 0, errNoConversation
+	}
+	response, err := assistant.Query(prompt)
+	if err != nil {
+		return 0, err
+	}
+	return parseQualityScore(response)
+}
+
+// buildScorePrompt 把对话记录交给LLM，要求按JSON返回评分；来电者没有说话时返回false
+func buildScorePrompt(conversation []models.ConversationMessage) (string, bool) {
+	var prompt strings.Builder
+	prompt.WriteString("以下是一通电话中来电者与AI客服的对话记录。请从是否理解来电者、回答是否准确有用、语气是否礼貌、")
+	prompt.WriteString("问题是否得到解决四个方面评估AI客服的服务质量，给出0到100的整数分数。")
+	prompt.WriteString(`只返回JSON，格式为{"score":85}，不要使用Markdown。`)
+	prompt.WriteString("\n\n")
+	spoke := false
+	for _, message := range conversation {
+		switch message.Role {
+		case "user":
+			prompt.WriteString("来电者：")
+			spoke = spoke || strings.TrimSpace(message.Content) != ""
+		case "assistant":
+			prompt.WriteString("客服：")
+		default:
+			continue
+		}
+		prompt.WriteString(strings.TrimSpace(message.Content))
+		prompt.WriteString("\n")
+	}
+	return prompt.String(), spoke
+}
+
+// parseQualityScore 解析LLM回复中的分数，容忍代码块和前后的说明文字，不是JSON时取第一个数字
+func parseQualityScore(response string) (float32, error) {
+	text := strings.TrimSpace(response)
+	var parsed struct {
+		Score *float64 `json:"score"`
+	}
+	if start, end := strings.Index(text, "{"), strings.LastIndex(text, "}"); start >= 0 && end > start {
+		if err := json.Unmarshal([]byte(text[start:end+1]), &parsed); err == nil && parsed.Score != nil {
+			return clampScore(*parsed.Score), nil
+		}
+	}
+	fields := strings.FieldsFunc(text, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	for _, field := range fields {
+		if score, err := strconv.ParseFloat(field, 64); err == nil {
+			return clampScore(score), nil
+		}
+	}
+	return 0, fmt.Errorf("no score in response: %q", text)
+}
+
+func clampScore(score float64) float32 {
+	return float32(min(max(score, 0), 100))
+}
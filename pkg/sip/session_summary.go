@@ -0,0 +1,104 @@
+package sip1
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// maxSummaryActionItems 摘要最多保留的待办事项数
+const maxSummaryActionItems = 10
+
+// sessionSummary LLM返回的摘要
+type sessionSummary struct {
+	Summary     string   `json:"summary"`
+	ActionItems []string `json:"actionItems"`
+}
+
+// summaryEnabled 是否在会话结束后生成摘要
+func (engine *AIPhoneEngine) summaryEnabled() bool {
+	return engine.server != nil && engine.server.config.SessionSummary && engine.services().Assistant != nil
+}
+
+// summarizeSession 会话结束后用LLM生成摘要和待办事项，保存到会话并推送到监控；用户未说话的会话跳过
+func (engine *AIPhoneEngine) summarizeSession(session *ScriptSession) {
+	conversation := session.conversation()
+	spoke := slices.ContainsFunc(conversation, func(message models.ConversationMessage) bool {
+		return message.Role == "user" && strings.TrimSpace(message.Content) != ""
+	})
+	if !spoke {
+		return
+	}
+
+	response, err := engine.services().Assistant.Query(buildSummaryPrompt(conversation))
+	if err != nil {
+		logger.Warn("Failed to summarize session", zap.String("call_id", session.CallID), zap.Error(err))
+		return
+	}
+	summary := parseSessionSummary(response)
+	if summary.Summary == "" {
+		return
+	}
+
+	if session.DBSession != nil && session.DBSession.ID != 0 {
+		if err := models.UpdateAIPhoneSessionSummary(engine.db, session.DBSession.ID, summary.Summary, summary.ActionItems); err != nil {
+			logger.Error("Failed to save session summary", zap.String("call_id", session.CallID), zap.Error(err))
+		}
+	}
+
+	event := session.monitorEvent(MonitorSessionSummary)
+	event.Text = summary.Summary
+	event.Items = summary.ActionItems
+	session.publish(event)
+
+	logger.Info("Session summarized",
+		zap.String("call_id", session.CallID),
+		zap.Int("action_items", len(summary.ActionItems)))
+}
+
+// buildSummaryPrompt 把对话记录交给LLM，要求按JSON返回摘要和待办事项
+func buildSummaryPrompt(conversation []models.ConversationMessage) string {
+	var prompt strings.Builder
+	prompt.WriteString("以下是一通电话中来电者与AI客服的对话记录。请用两到三句话概括来电者的诉求和处理结果，")
+	prompt.WriteString("并列出需要工作人员后续跟进的待办事项（没有则为空列表）。")
+	prompt.WriteString(`只返回JSON，格式为{"summary":"...","actionItems":["..."]}，不要使用Markdown。`)
+	prompt.WriteString("\n\n")
+	for _, message := range conversation {
+		switch message.Role {
+		case "user":
+			prompt.WriteString("来电者：")
+		case "assistant":
+			prompt.WriteString("客服：")
+		default:
+			continue
+		}
+		prompt.WriteString(strings.TrimSpace(message.Content))
+		prompt.WriteString("\n")
+	}
+	return prompt.String()
+}
+
+// parseSessionSummary 解析LLM的回复，容忍代码块和前后的说明文字；不是JSON时整段作为摘要
+func parseSessionSummary(response string) sessionSummary {
+	text := strings.TrimSpace(response)
+	var summary sessionSummary
+	if start, end := strings.Index(text, "{"), strings.LastIndex(text, "}"); start >= 0 && end > start {
+		if err := json.Unmarshal([]byte(text[start:end+1]), &summary); err == nil {
+			summary.Summary = strings.TrimSpace(summary.Summary)
+			items := summary.ActionItems[:0]
+			for _, item := range summary.ActionItems {
+				if item = strings.TrimSpace(item); item != "" && len(items) < maxSummaryActionItems {
+					items = append(items, item)
+				}
+			}
+			summary.ActionItems = items
+			return summary
+		}
+	}
+	text = strings.TrimSpace(strings.Trim(text, "`"))
+	return sessionSummary{Summary: text}
+}
@@ -0,0 +1,365 @@
+package sip1
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+// 会话定时器（RFC 4028）
+const (
+	headerSessionExpires   = "Session-Expires"
+	headerMinSE            = "Min-SE"
+	statusIntervalTooSmall = 422
+	refresherUAC           = "uac"
+	refresherUAS           = "uas"
+	// sessionRefreshRetry 刷新请求失败（408、481以外）后重试的间隔
+	sessionRefreshRetry = 10 * time.Second
+)
+
+// sipHeaders 请求和响应共有的头域访问
+type sipHeaders interface {
+	GetHeader(name string) sip.Header
+	GetHeaders(name string) []sip.Header
+}
+
+// sessionInterval 解析Session-Expires（紧凑形式x），返回间隔和refresher参数
+func sessionInterval(msg sipHeaders) (time.Duration, string, bool) {
+	header := msg.GetHeader(headerSessionExpires)
+	if header == nil {
+		header = msg.GetHeader("x")
+	}
+	if header == nil {
+		return 0, "", false
+	}
+	parts := strings.Split(header.Value(), ";")
+	seconds, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || seconds <= 0 {
+		return 0, "", false
+	}
+	refresher := ""
+	for _, param := range parts[1:] {
+		if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.EqualFold(key, "refresher") {
+			refresher = strings.ToLower(strings.TrimSpace(value))
+		}
+	}
+	return time.Duration(seconds) * time.Second, refresher, true
+}
+
+// minSessionInterval 解析Min-SE，没有时返回0
+func minSessionInterval(msg sipHeaders) time.Duration {
+	header := msg.GetHeader(headerMinSE)
+	if header == nil {
+		return 0
+	}
+	value, _, _ := strings.Cut(header.Value(), ";")
+	seconds, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// hasOptionTag Supported/Require等头域中是否包含option tag
+func hasOptionTag(msg sipHeaders, name, tag string) bool {
+	for _, header := range msg.GetHeaders(name) {
+		for _, value := range strings.Split(header.Value(), ",") {
+			if strings.EqualFold(strings.TrimSpace(value), tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowsMethod 对端的Allow头域中是否包含method
+func allowsMethod(msg sipHeaders, method sip.RequestMethod) bool {
+	return hasOptionTag(msg, "Allow", string(method))
+}
+
+func sessionExpiresValue(interval time.Duration, refresher string) string {
+	value := strconv.Itoa(int(interval / time.Second))
+	if refresher != "" {
+		value += ";refresher=" + refresher
+	}
+	return value
+}
+
+// checkSessionInterval 请求的会话间隔小于Min-SE时回复422，返回是否继续处理
+func (as *SipServer) checkSessionInterval(req *sip.Request, tx sip.ServerTransaction) bool {
+	interval, _, ok := sessionInterval(req)
+	if !ok || interval >= as.config.MinSE {
+		return true
+	}
+	logger.Info("Rejecting session interval below Min-SE",
+		zap.String("call_id", req.CallID().Value()),
+		zap.Duration("session_expires", interval),
+		zap.Duration("min_se", as.config.MinSE))
+	res := sip.NewResponseFromRequest(req, statusIntervalTooSmall, "Session Interval Too Small", nil)
+	res.AppendHeader(sip.NewHeader(headerMinSE, sessionExpiresValue(as.config.MinSE, "")))
+	if err := tx.Respond(res); err != nil {
+		logger.Error("Failed to send 422 response", zap.Error(err))
+	}
+	return false
+}
+
+// answerSessionTimer 为INVITE或刷新请求的2xx协商会话间隔并添加头域，返回间隔（0为不启用）和是否由本端刷新。
+// 对端没有请求时按SessionExpires由本端刷新；对端支持timer且未指定时由对端刷新
+func (as *SipServer) answerSessionTimer(req *sip.Request, res *sip.Response) (time.Duration, bool) {
+	interval, refresher, requested := sessionInterval(req)
+	supported := hasOptionTag(req, "Supported", "timer") || hasOptionTag(req, "Require", "timer")
+	switch {
+	case !requested && as.config.SessionExpires <= 0:
+		return 0, false
+	case !requested:
+		interval, refresher = as.config.SessionExpires, refresherUAS
+	case as.config.SessionExpires > 0 && interval > as.config.SessionExpires:
+		// 可以缩短，但不能低于对端的Min-SE
+		interval = max(as.config.SessionExpires, minSessionInterval(req))
+	}
+	if !supported {
+		refresher = refresherUAS
+	} else if refresher != refresherUAS {
+		refresher = refresherUAC
+	}
+
+	res.AppendHeader(sip.NewHeader(headerSessionExpires, sessionExpiresValue(interval, refresher)))
+	if supported {
+		res.AppendHeader(sip.NewHeader("Require", "timer"))
+	}
+	return interval, refresher == refresherUAS
+}
+
+// offerSessionTimer 在发出的INVITE中声明支持timer，并按SessionExpires请求会话定时器，由对端选择刷新方
+func (as *SipServer) offerSessionTimer(invite *sip.Request) {
+	if !hasOptionTag(invite, "Supported", "timer") {
+		invite.AppendHeader(sip.NewHeader("Supported", "timer"))
+	}
+	if as.config.SessionExpires > 0 && invite.GetHeader(headerSessionExpires) == nil {
+		invite.AppendHeader(sip.NewHeader(headerSessionExpires, sessionExpiresValue(as.config.SessionExpires, "")))
+		invite.AppendHeader(sip.NewHeader(headerMinSE, sessionExpiresValue(as.config.MinSE, "")))
+	}
+}
+
+// startOutboundSessionTimer 按对端2xx中的Session-Expires启动本端发起通话的定时器，对端未启用时按SessionExpires自行刷新
+func (as *SipServer) startOutboundSessionTimer(dialog *CallDialog, res *sip.Response) {
+	interval, refresher, ok := sessionInterval(res)
+	if !ok {
+		interval, refresher = as.config.SessionExpires, refresherUAC
+	}
+	dialog.mutex.Lock()
+	dialog.allowUpdate = allowsMethod(res, sip.UPDATE)
+	dialog.mutex.Unlock()
+	as.startSessionTimer(dialog, interval, refresher == refresherUAC)
+}
+
+// startSessionTimer 重新开始会话定时：本端刷新时在间隔一半时发送刷新请求，
+// 否则在到期前（间隔减去min(32秒, 间隔/3)）仍未收到刷新则挂断；interval为0时停止
+func (as *SipServer) startSessionTimer(dialog *CallDialog, interval time.Duration, refresh bool) {
+	if interval <= 0 {
+		dialog.stopSessionTimer()
+		return
+	}
+	wait := interval / 2
+	if !refresh {
+		wait = interval - min(32*time.Second, interval/3)
+	}
+	dialog.mutex.Lock()
+	dialog.sessionExpires = interval
+	dialog.mutex.Unlock()
+	as.armSessionTimer(dialog, wait, refresh)
+
+	logger.Debug("Session timer started",
+		zap.String("call_id", dialog.CallID),
+		zap.Duration("session_expires", interval),
+		zap.Bool("refresher", refresh))
+}
+
+func (as *SipServer) armSessionTimer(dialog *CallDialog, wait time.Duration, refresh bool) {
+	dialog.mutex.Lock()
+	defer dialog.mutex.Unlock()
+	if dialog.sessionTimer != nil {
+		dialog.sessionTimer.Stop()
+	}
+	dialog.sessionTimer = time.AfterFunc(wait, func() { as.sessionTimerFired(dialog, refresh) })
+}
+
+// stopSessionTimer 对话结束或对端关闭定时器时停止
+func (d *CallDialog) stopSessionTimer() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.sessionTimer != nil {
+		d.sessionTimer.Stop()
+		d.sessionTimer = nil
+	}
+	d.sessionExpires = 0
+}
+
+func (as *SipServer) sessionTimerFired(dialog *CallDialog, refresh bool) {
+	// 对话已结束或已被转接后的新对话替换
+	if current, exists := as.getDialog(dialog.CallID); !exists || current != dialog {
+		return
+	}
+	if !refresh {
+		logger.Warn("Session expired without refresh, hanging up", zap.String("call_id", dialog.CallID))
+		as.expireSession(dialog.CallID)
+		return
+	}
+	as.refreshSession(dialog)
+}
+
+// refreshSession 发送会话刷新：对端支持UPDATE时用不带SDP的UPDATE，否则用携带当前SDP的re-INVITE。
+// 408、481或无响应时挂断，其他失败稍后重试
+func (as *SipServer) refreshSession(dialog *CallDialog) {
+	callID := dialog.CallID
+	dialog.mutex.Lock()
+	interval, useUpdate := dialog.sessionExpires, dialog.allowUpdate
+	dialog.mutex.Unlock()
+
+	method := sip.INVITE
+	if useUpdate {
+		method = sip.UPDATE
+	}
+	req := as.newSessionRefresh(dialog, method, interval)
+	res, err := as.sendSessionRefresh(req, dialog)
+	if err != nil {
+		logger.Warn("Session refresh failed, hanging up", zap.String("call_id", callID), zap.Error(err))
+		as.expireSession(callID)
+		return
+	}
+
+	switch {
+	case res.IsSuccess():
+		next, refresher, ok := sessionInterval(res)
+		if !ok {
+			next, refresher = interval, refresherUAC
+		}
+		logger.Info("Session refreshed",
+			zap.String("call_id", callID),
+			zap.String("method", string(method)),
+			zap.Duration("session_expires", next))
+		as.startSessionTimer(dialog, next, refresher == refresherUAC)
+	case res.StatusCode == sip.StatusRequestTimeout || res.StatusCode == sip.StatusCallTransactionDoesNotExists:
+		logger.Warn("Session refresh rejected, hanging up",
+			zap.String("call_id", callID),
+			zap.Int("status", int(res.StatusCode)))
+		as.expireSession(callID)
+	case res.StatusCode == statusIntervalTooSmall && minSessionInterval(res) > interval:
+		// 对端要求更长的间隔，立即按其Min-SE重新刷新
+		dialog.mutex.Lock()
+		dialog.sessionExpires = minSessionInterval(res)
+		dialog.mutex.Unlock()
+		as.armSessionTimer(dialog, 0, true)
+	default:
+		logger.Warn("Session refresh rejected, retrying",
+			zap.String("call_id", callID),
+			zap.Int("status", int(res.StatusCode)))
+		as.armSessionTimer(dialog, sessionRefreshRetry, true)
+	}
+}
+
+// newSessionRefresh 创建刷新请求，本端继续作为刷新方
+func (as *SipServer) newSessionRefresh(dialog *CallDialog, method sip.RequestMethod, interval time.Duration) *sip.Request {
+	req := dialog.NewRequest(method)
+	localIP := localIPFor(dialog.RemoteTarget.Host)
+	if localIP == "" {
+		localIP = "127.0.0.1"
+	}
+	req.AppendHeader(&sip.ContactHeader{Address: sip.Uri{Host: uriHost(localIP), Port: as.config.Port}})
+	req.AppendHeader(sip.NewHeader("Supported", "timer"))
+	req.AppendHeader(sip.NewHeader(headerSessionExpires, sessionExpiresValue(interval, refresherUAC)))
+	req.AppendHeader(sip.NewHeader(headerMinSE, sessionExpiresValue(as.config.MinSE, "")))
+	if method == sip.INVITE {
+		req.SetBody([]byte(generateSDP(localIP, as.config.LocalRTPPort, as.getCallCodec(dialog.CallID))))
+		contentType := sip.ContentTypeHeader("application/sdp")
+		req.AppendHeader(&contentType)
+	}
+	return req
+}
+
+// sendSessionRefresh 发送刷新请求并等待最终响应，re-INVITE的2xx同时发送ACK
+func (as *SipServer) sendSessionRefresh(req *sip.Request, dialog *CallDialog) (*sip.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), as.config.TransactionTimeout)
+	defer cancel()
+
+	tx, err := as.client.TransactionRequest(ctx, req, as.clientMaxForwards, sipgo.ClientRequestBuild)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s: %w", req.Method, err)
+	}
+	defer tx.Terminate()
+
+	for {
+		select {
+		case res := <-tx.Responses():
+			if res.IsProvisional() {
+				continue
+			}
+			if res.IsSuccess() && req.IsInvite() {
+				ack := sip.NewAckRequest(req, res, nil)
+				if dialog.Transport != "" {
+					ack.SetTransport(dialog.Transport)
+				}
+				if len(dialog.RouteSet) == 0 && dialog.Destination != "" {
+					ack.SetDestination(dialog.Destination)
+				}
+				if err := as.client.WriteRequest(ack, as.clientMaxForwards, sipgo.ClientRequestBuild); err != nil {
+					logger.Warn("Failed to send ACK for session refresh", zap.String("call_id", dialog.CallID), zap.Error(err))
+				}
+			}
+			return res, nil
+		case <-tx.Done():
+			return nil, fmt.Errorf("%s transaction ended: %w", req.Method, tx.Err())
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s timeout: %w", req.Method, ctx.Err())
+		}
+	}
+}
+
+// expireSession 会话定时器到期：停止AI会话并挂断
+func (as *SipServer) expireSession(callID string) {
+	if as.aiEngine != nil {
+		if session := as.aiEngine.GetSession(callID); session != nil && session.DBSession != nil {
+			session.DBSession.ErrorMessage = "session timer expired"
+		}
+		as.aiEngine.StopSession(callID)
+	}
+	as.hangupCall(callID)
+}
+
+// handleUpdate 处理对话内的UPDATE（RFC 3311），用于会话刷新；不支持带SDP的媒体更新
+func (as *SipServer) handleUpdate(req *sip.Request, tx sip.ServerTransaction) {
+	callID := req.CallID().Value()
+	dialog, exists := as.getDialog(callID)
+	if tag, _ := req.To().Params.Get("tag"); !exists || tag != dialog.LocalTag {
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil))
+		return
+	}
+	if len(req.Body()) > 0 {
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusNotAcceptableHere, "Not Acceptable Here", nil))
+		return
+	}
+	if !as.checkSessionInterval(req, tx) {
+		return
+	}
+
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	res.AppendHeader(&sip.ContactHeader{Address: sip.Uri{Host: uriHost(getServerIPFromRequest(req)), Port: as.config.Port}})
+	interval, refresh := as.answerSessionTimer(req, res)
+	if err := tx.Respond(res); err != nil {
+		logger.Error("Failed to send UPDATE response", zap.String("call_id", callID), zap.Error(err))
+		return
+	}
+	if contact := req.Contact(); contact != nil {
+		dialog.mutex.Lock()
+		dialog.RemoteTarget = contact.Address
+		dialog.mutex.Unlock()
+	}
+	as.startSessionTimer(dialog, interval, refresh)
+}
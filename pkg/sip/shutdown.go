@@ -0,0 +1,176 @@
+package sip1
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// drainPollInterval 排空期间检查活跃通话的间隔
+	drainPollInterval = 200 * time.Millisecond
+	// goodbyeStopWait 播放告别语前等待脚本停止的最长时间
+	goodbyeStopWait = 2 * time.Second
+)
+
+// isDraining 是否正在关闭，关闭期间拒绝新呼叫
+func (as *SipServer) isDraining() bool {
+	as.mutex.RLock()
+	defer as.mutex.RUnlock()
+	return as.draining
+}
+
+// activeCalls 返回仍在进行的通话：已接通的对话和AI会话
+func (as *SipServer) activeCalls() []string {
+	calls := make(map[string]bool)
+	as.dialogsMutex.RLock()
+	for callID := range as.dialogs {
+		calls[callID] = true
+	}
+	as.dialogsMutex.RUnlock()
+	if as.aiEngine != nil {
+		as.aiEngine.mutex.RLock()
+		for callID := range as.aiEngine.sessions {
+			calls[callID] = true
+		}
+		as.aiEngine.mutex.RUnlock()
+	}
+
+	callIDs := make([]string, 0, len(calls))
+	for callID := range calls {
+		callIDs = append(callIDs, callID)
+	}
+	return callIDs
+}
+
+// Shutdown 优雅关闭：停止接受新的INVITE，等待进行中的通话在DrainTimeout内结束，
+// 超时后播放告别语并发送BYE，等待通话记录写完后关闭连接。ctx到期时立即关闭
+func (as *SipServer) Shutdown(ctx context.Context) error {
+	as.mutex.Lock()
+	as.draining = true
+	as.mutex.Unlock()
+	defer as.Close()
+
+	logger.Info("Draining active calls",
+		zap.Int("active_calls", len(as.activeCalls())),
+		zap.Duration("drain_timeout", as.config.DrainTimeout))
+
+	drainCtx, cancel := context.WithTimeout(ctx, as.config.DrainTimeout)
+	as.waitForCalls(drainCtx)
+	cancel()
+
+	if remaining := as.activeCalls(); len(remaining) > 0 {
+		logger.Warn("Drain timeout reached, hanging up remaining calls", zap.Int("calls", len(remaining)))
+		var wg sync.WaitGroup
+		for _, callID := range remaining {
+			wg.Add(1)
+			go func(callID string) {
+				defer wg.Done()
+				as.endCallForShutdown(callID)
+			}(callID)
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}
+
+	// 等待后台的INVITE持久化写完
+	as.waitInviteJobs(ctx)
+	// 通知订阅者订阅已结束
+	as.terminateSubscriptions(ctx)
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("shutdown deadline exceeded: %w", err)
+	}
+	logger.Info("All calls drained")
+	return nil
+}
+
+// waitForCalls 等待所有通话结束或ctx到期
+func (as *SipServer) waitForCalls(ctx context.Context) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for len(as.activeCalls()) > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// endCallForShutdown 停止脚本，向仍在通话的一方播放告别语后挂断
+func (as *SipServer) endCallForShutdown(callID string) {
+	_, hasDialog := as.getDialog(callID)
+
+	if as.aiEngine != nil {
+		if session := as.aiEngine.GetSession(callID); session != nil {
+			session.requestStop()
+			session.waitClosed(goodbyeStopWait)
+			if hasDialog && as.config.ShutdownMessage != "" {
+				if err := as.aiEngine.playTTSAudio(session, as.config.ShutdownMessage, session.speakerID()); err != nil {
+					logger.Warn("Failed to play shutdown message", zap.String("call_id", callID), zap.Error(err))
+				}
+			}
+		}
+	}
+
+	if hasDialog {
+		as.hangupCall(callID)
+	}
+}
+
+// waitInviteJobs 等待排队和处理中的INVITE持久化任务完成
+func (as *SipServer) waitInviteJobs(ctx context.Context) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		as.inflightMutex.RLock()
+		pending := len(as.inflightInvites)
+		as.inflightMutex.RUnlock()
+		if pending == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			logger.Warn("Shutdown before INVITE persistence finished", zap.Int("pending", pending))
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitClosed 等待会话清理完成
+func (session *ScriptSession) waitClosed(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		session.mutex.RLock()
+		closed := session.closed
+		session.mutex.RUnlock()
+		if closed {
+			return
+		}
+		time.Sleep(drainPollInterval)
+	}
+}
+
+// speakerID 当前步骤的说话人，未设置时使用脚本默认说话人
+func (session *ScriptSession) speakerID() string {
+	if step := session.currentStep(); step != nil && step.Data.SpeakerID != "" {
+		return step.Data.SpeakerID
+	}
+	if session.Script != nil {
+		return session.Script.SpeakerID
+	}
+	return ""
+}
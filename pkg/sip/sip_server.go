@@ -1,9 +1,9 @@
 package sip1
 
 import (
-	"context"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 
 	"github.com/LingByte/LingSIP/pkg/logger"
@@ -21,11 +21,100 @@ type SipServer struct {
 	mutex   sync.RWMutex
 	running bool
 
+	// 正在优雅关闭，拒绝新呼叫
+	draining bool
+	// SIP监听连接，Start中创建
+	sipConn net.PacketConn
+
 	// AI电话引擎
 	aiEngine *AIPhoneEngine
 
 	// SIP中继管理器
 	trunkManager *TrunkManager
+
+	// 已接通的对话 callID -> dialog，用于发送BYE等对话内请求
+	dialogs      map[string]*CallDialog
+	dialogsMutex sync.RWMutex
+
+	// 进行中的REFER转接 callID -> transfer
+	transfers      map[string]*callTransfer
+	transfersMutex sync.RWMutex
+
+	// 正在处理收到的REFER的通话，与transfers共用transfersMutex
+	referrals map[string]bool
+
+	// 注册用户Contact可达性 username -> Contact URI -> reachability
+	reachability      map[string]map[string]*ContactReachability
+	reachabilityMutex sync.RWMutex
+
+	// 通话协商出的RTP编码 callID -> codec
+	codecs      map[string]rtpCodec
+	codecsMutex sync.RWMutex
+
+	// INVITE接听后的异步持久化任务，以及仍在处理中的通话 callID -> done
+	inviteJobs      chan *inviteJob
+	inflightInvites map[string]chan struct{}
+	inflightMutex   sync.RWMutex
+
+	// RTCP端口（RTP端口+1）及各通话的报告状态 callID -> session
+	rtcpConn     *net.UDPConn
+	rtcpSessions map[string]*rtcpSession
+	rtcpMutex    sync.RWMutex
+
+	// 通话实时监控
+	monitor *CallMonitor
+
+	// 本机在Via中的地址，用于环路检测
+	localHosts map[string]bool
+
+	// SIP消息跟踪和抓包，media为经过抓包包装的rtpConn
+	tracer *SIPTracer
+	media  RTPConn
+
+	// SIP请求中间件链和请求统计
+	middleware *middlewareChain
+	metrics    *requestMetrics
+
+	// 按服务、DID、脚本、租户的并发呼入限制
+	quotas *callQuotas
+	// REGISTER/INVITE的来源IP和From号码访问控制
+	accessControl *AccessControl
+	// 按来源IP限制REGISTER/INVITE的速率
+	rateLimiter *sipRateLimiter
+	// REGISTER的摘要认证
+	digest *digestAuth
+	// 超出并发限制后排队等待的呼入
+	queue *callQueue
+	// campaigns 本实例上运行中的外呼任务
+	campaigns *campaignDialer
+	// reprocess 本实例上运行中的重新转录、重新评分任务
+	reprocess *reprocessRunner
+	// usage ASR/TTS/LLM服务商的月度用量和额度
+	usage *providerUsage
+	// CPU和RTP负载采样，超出预算时拒绝新呼入
+	load *loadMonitor
+
+	// 网页点击通话网关
+	webrtc *WebRTCGateway
+
+	// presence/dialog事件订阅 callID -> subscription，以及影响忙闲的通话 callID -> call
+	subscriptions map[string]*presenceSubscription
+	presenceCalls map[string]*presenceCall
+	presenceMutex sync.Mutex
+
+	// 使用ICE的通话的媒体通道 callID -> session
+	iceSessions map[string]*iceSession
+	iceMutex    sync.Mutex
+
+	// 背靠背桥接 callID（两条腿各一项）-> bridge，bridgeRelaying表示中继协程正在运行
+	bridges        map[string]*callBridge
+	bridgeRelaying bool
+	bridgeMutex    sync.Mutex
+	// 前转到AI脚本、等待ACK的通话 callID -> 查找脚本的号码，与bridges共用bridgeMutex
+	scriptNumbers map[string]string
+
+	stopChan  chan struct{}
+	closeOnce sync.Once
 }
 
 func NewSipServer(rptPort, sipPort int, uaConfig *ua.UAConfig) (*SipServer, error) {
@@ -52,7 +141,8 @@ func NewSipServer(rptPort, sipPort int, uaConfig *ua.UAConfig) (*SipServer, erro
 	}
 
 	// Create RTP UDP connection
-	rtpAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("0.0.0.0:%d", rptPort))
+	// RTP与SIP监听同一地址，Host为::时同时接收IPv4和IPv6
+	rtpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(uaConfig.Host, strconv.Itoa(rptPort)))
 	if err != nil {
 		logger.Fatal("Failed to resolve RTP address", zap.Error(err))
 	}
@@ -62,22 +152,71 @@ func NewSipServer(rptPort, sipPort int, uaConfig *ua.UAConfig) (*SipServer, erro
 		logger.Fatal("Failed to create RTP UDP connection", zap.Error(err))
 	}
 
+	// RTCP使用相邻端口，打开失败时只影响质量统计
+	rtcpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: rtpAddr.IP, Port: rptPort + 1})
+	if err != nil {
+		logger.Warn("Failed to create RTCP UDP connection, call quality reports disabled", zap.Error(err))
+		rtcpConn = nil
+	}
+
 	client, err := sipgo.NewClient(userAgent)
 	if err != nil {
 		logger.Fatal("Create SIP Client Failed", zap.Error(err))
 	}
 
+	tracer := NewSIPTracer(uaConfig.TraceDir, uaConfig.SIPTrace)
+	tracer.localIP = userAgent.GetIP()
+
+	load := &loadMonitor{}
 	sipServer := &SipServer{
-		config:  uaConfig,
-		server:  server,
-		rtpConn: rtpConn,
-		client:  client,
-		ua:      userAgent,
+		config:          uaConfig,
+		server:          server,
+		rtpConn:         rtpConn,
+		client:          client,
+		ua:              userAgent,
+		dialogs:         make(map[string]*CallDialog),
+		transfers:       make(map[string]*callTransfer),
+		referrals:       make(map[string]bool),
+		reachability:    make(map[string]map[string]*ContactReachability),
+		codecs:          make(map[string]rtpCodec),
+		inviteJobs:      make(chan *inviteJob, inviteQueueSize),
+		inflightInvites: make(map[string]chan struct{}),
+		rtcpConn:        rtcpConn,
+		rtcpSessions:    make(map[string]*rtcpSession),
+		monitor:         NewCallMonitor(),
+		tracer:          tracer,
+		media:           &tracedRTPConn{UDPConn: rtpConn, tracer: tracer, packets: &load.rtpPackets},
+		middleware:      newMiddlewareChain(),
+		metrics:         newRequestMetrics(),
+		quotas:          newCallQuotas(),
+		accessControl:   NewAccessControl(),
+		rateLimiter:     newSIPRateLimiter(),
+		digest:          newDigestAuth(),
+		queue:           newCallQueue(),
+		campaigns:       newCampaignDialer(),
+		reprocess:       newReprocessRunner(),
+		usage:           newProviderUsage(uaConfig.Db),
+		load:            load,
+		subscriptions:   make(map[string]*presenceSubscription),
+		presenceCalls:   make(map[string]*presenceCall),
+		iceSessions:     make(map[string]*iceSession),
+		bridges:         make(map[string]*callBridge),
+		scriptNumbers:   make(map[string]string),
+		stopChan:        make(chan struct{}),
+	}
+
+	tracer.rtpPeer = sipServer.callRTPPeer
+	sipServer.webrtc = newWebRTCGateway(sipServer)
+	sipServer.useDefaultMiddleware()
+
+	// 回放上次运行时数据库不可用期间的写入
+	if uaConfig.StorageType == ua.StorageTypeDatabase || uaConfig.StorageType == ua.StorageTypeRedis {
+		uaConfig.ResumeDBJournal()
 	}
 
 	// 初始化AI电话引擎
 	if uaConfig.Db != nil {
-		sipServer.aiEngine = NewAIPhoneEngine(sipServer, uaConfig.Db)
+		sipServer.aiEngine = NewAIPhoneEngine(sipServer, uaConfig.Db, AIServices{})
 		logger.Info("AI phone engine initialized")
 
 		// 初始化SIP中继管理器
@@ -89,11 +228,35 @@ func NewSipServer(rptPort, sipPort int, uaConfig *ua.UAConfig) (*SipServer, erro
 				logger.Info("SIP trunk manager initialized")
 			}
 		}
+
+		if err := sipServer.accessControl.Load(uaConfig.Db); err != nil {
+			logger.Error("Failed to load access rules", zap.Error(err))
+		}
 	}
 
 	return sipServer, nil
 }
 
+// Tracer 返回SIP消息跟踪器
+func (as *SipServer) Tracer() *SIPTracer {
+	return as.tracer
+}
+
+// callRTPPeer 返回通话的RTP对端地址
+func (as *SipServer) callRTPPeer(callID string) *net.UDPAddr {
+	if session, exists := as.config.GetActiveSession(callID); exists && session.ClientRTPAddr != nil {
+		return session.ClientRTPAddr
+	}
+	if as.aiEngine != nil {
+		if session := as.aiEngine.GetSession(callID); session != nil {
+			if addr, err := net.ResolveUDPAddr("udp", session.ClientAddr); err == nil {
+				return addr
+			}
+		}
+	}
+	return nil
+}
+
 // GetAIPhoneEngine 获取AI电话引擎
 func (as *SipServer) GetAIPhoneEngine() *AIPhoneEngine {
 	return as.aiEngine
@@ -104,26 +267,88 @@ func (as *SipServer) GetTrunkManager() *TrunkManager {
 	return as.trunkManager
 }
 
+// Close 立即关闭所有连接，可重复调用；需要排空通话时使用Shutdown
 func (as *SipServer) Close() {
-	as.server.Close()
-	as.rtpConn.Close()
-	as.client.Close()
-	as.ua.Close()
-
-	// 关闭中继管理器
-	if as.trunkManager != nil {
-		as.trunkManager.Close()
-	}
+	as.closeOnce.Do(func() {
+		close(as.stopChan)
+		as.server.Close()
+		as.mutex.RLock()
+		if as.sipConn != nil {
+			as.sipConn.Close()
+		}
+		as.mutex.RUnlock()
+		as.rtpConn.Close()
+		if as.rtcpConn != nil {
+			as.rtcpConn.Close()
+		}
+		as.webrtc.closeAll()
+		as.closeICESessions()
+		as.client.Close()
+		as.ua.Close()
 
-	as.running = false
-	logger.Info("SIP Server Closed")
+		// 关闭中继管理器
+		if as.trunkManager != nil {
+			as.trunkManager.Close()
+		}
+
+		as.mutex.Lock()
+		as.running = false
+		as.mutex.Unlock()
+		logger.Info("SIP Server Closed")
+	})
 }
 
 func (as *SipServer) Start() {
 	as.RegisterFunc()
 
-	ctx := context.Background()
-	if err := as.server.ListenAndServe(ctx, "udp", fmt.Sprintf("%s:%d", as.config.Host, as.config.Port)); err != nil {
+	// 定期OPTIONS探测注册用户
+	go as.runContactProber()
+
+	// INVITE接听后的持久化在后台完成
+	as.runInviteWorkers()
+
+	// 定期输出存储耗时统计
+	go as.runStorageReporter()
+
+	// 定期清理过期的待接通会话和活跃会话
+	go as.runSessionJanitor()
+
+	// 文件存储定期清理
+	go as.runFilePruner()
+
+	// 接收对端RTCP报告
+	go as.runRTCPReceiver()
+
+	// 采样CPU和RTP负载
+	go as.runLoadMonitor()
+
+	// 名额释放后接通排队的呼入
+	go as.runCallQueue()
+
+	// 累计服务商用量，检查月度额度
+	go as.runProviderUsageFlusher()
+
+	// 继续拨打重启前运行中的外呼任务
+	go as.resumeCampaigns()
+
+	// 继续重启前运行中的重新处理任务
+	go as.resumeReprocessJobs()
+
+	// 自行创建监听连接，收发的SIP消息经过跟踪器
+	conn, err := net.ListenPacket("udp", as.config.GetSIPAddress())
+	if err != nil {
+		logger.Fatal("Failed to start server", zap.Error(err))
+	}
+	as.mutex.Lock()
+	as.sipConn = conn
+	as.mutex.Unlock()
+	if err := as.server.ServeUDP(&tracedPacketConn{PacketConn: conn, tracer: as.tracer}); err != nil {
+		// 关闭时监听连接被关闭，属于正常退出
+		select {
+		case <-as.stopChan:
+			return
+		default:
+		}
 		logger.Fatal("Failed to start server", zap.Error(err))
 	}
 }
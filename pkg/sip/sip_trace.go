@@ -0,0 +1,384 @@
+package sip1
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// traceEndGrace 通话结束后继续抓包的时间，收齐BYE的响应和重传
+const traceEndGrace = 5 * time.Second
+
+// authCredentials 鉴权头中由密码计算出的字段
+var authCredentials = regexp.MustCompile(`(?i)\b(response|cnonce)\s*=\s*("[^"]*"|[^,\s]+)`)
+
+// SIPTracer SIP消息跟踪：开启后每条收发的SIP消息（脱敏后）追加到按Call-ID命名的跟踪文件，
+// 单独跟踪的通话可以同时写出包含SIP和RTP的pcap
+type SIPTracer struct {
+	mutex   sync.RWMutex
+	dir     string
+	enabled bool                  // 跟踪所有通话
+	calls   map[string]*callTrace // 单独跟踪的通话 callID -> trace
+	localIP net.IP                // 监听在通配地址时pcap中使用的本机地址
+
+	// rtpPeer 返回通话的RTP对端地址，用于把共享RTP端口上的包归到通话
+	rtpPeer func(callID string) *net.UDPAddr
+}
+
+type callTrace struct {
+	pcap    *pcapWriter
+	rtpPeer *net.UDPAddr
+	ended   *time.Timer
+}
+
+// CallTraceStatus 单独跟踪的通话
+type CallTraceStatus struct {
+	CallID string `json:"callId"`
+	PCAP   bool   `json:"pcap"`
+}
+
+// SIPTraceStatus 跟踪状态
+type SIPTraceStatus struct {
+	Enabled bool              `json:"enabled"`
+	Dir     string            `json:"dir"`
+	Calls   []CallTraceStatus `json:"calls"`
+}
+
+// NewSIPTracer 创建跟踪器，跟踪文件写入dir
+func NewSIPTracer(dir string, enabled bool) *SIPTracer {
+	return &SIPTracer{
+		dir:     dir,
+		enabled: enabled,
+		calls:   make(map[string]*callTrace),
+	}
+}
+
+// SetEnabled 开关所有通话的跟踪
+func (t *SIPTracer) SetEnabled(enabled bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.enabled = enabled
+	logger.Info("SIP trace mode changed", zap.Bool("enabled", enabled), zap.String("dir", t.dir))
+}
+
+// StartCall 单独跟踪一通电话，pcap为true时同时抓取SIP和RTP
+func (t *SIPTracer) StartCall(callID string, pcap bool) error {
+	if callID == "" {
+		return fmt.Errorf("call id is required")
+	}
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create trace dir: %w", err)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	trace, exists := t.calls[callID]
+	if !exists {
+		trace = &callTrace{}
+		t.calls[callID] = trace
+	}
+	if pcap && trace.pcap == nil {
+		writer, err := newPcapWriter(t.tracePath(callID, ".pcap"))
+		if err != nil {
+			if !exists {
+				delete(t.calls, callID)
+			}
+			return fmt.Errorf("failed to create pcap: %w", err)
+		}
+		trace.pcap = writer
+	}
+	logger.Info("SIP call trace started", zap.String("call_id", callID), zap.Bool("pcap", pcap))
+	return nil
+}
+
+// StopCall 停止单独跟踪，关闭pcap
+func (t *SIPTracer) StopCall(callID string) {
+	t.mutex.Lock()
+	trace, exists := t.calls[callID]
+	delete(t.calls, callID)
+	t.mutex.Unlock()
+	if !exists {
+		return
+	}
+	if trace.ended != nil {
+		trace.ended.Stop()
+	}
+	if trace.pcap != nil {
+		if err := trace.pcap.Close(); err != nil {
+			logger.Warn("Failed to close pcap", zap.String("call_id", callID), zap.Error(err))
+		}
+	}
+	logger.Info("SIP call trace stopped", zap.String("call_id", callID))
+}
+
+// Status 返回跟踪状态
+func (t *SIPTracer) Status() SIPTraceStatus {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	status := SIPTraceStatus{Enabled: t.enabled, Dir: t.dir, Calls: []CallTraceStatus{}}
+	for callID, trace := range t.calls {
+		status.Calls = append(status.Calls, CallTraceStatus{CallID: callID, PCAP: trace.pcap != nil})
+	}
+	sort.Slice(status.Calls, func(i, j int) bool { return status.Calls[i].CallID < status.Calls[j].CallID })
+	return status
+}
+
+// callEnded 通话结束，宽限期后停止单独跟踪
+func (t *SIPTracer) callEnded(callID string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if trace, exists := t.calls[callID]; exists && trace.ended == nil {
+		trace.ended = time.AfterFunc(traceEndGrace, func() { t.StopCall(callID) })
+	}
+}
+
+// traceSIP 记录一条SIP消息，outbound表示本机发出
+func (t *SIPTracer) traceSIP(outbound bool, local, remote net.Addr, data []byte) {
+	callID := sipCallID(data)
+	if callID == "" {
+		return
+	}
+
+	t.mutex.RLock()
+	enabled := t.enabled
+	trace := t.calls[callID]
+	t.mutex.RUnlock()
+	if !enabled && trace == nil {
+		return
+	}
+
+	now := time.Now()
+	direction := "<-"
+	if outbound {
+		direction = "->"
+	}
+	entry := fmt.Sprintf("%s %s %s %s\n%s\n\n", now.Format(time.RFC3339Nano), local, direction, remote, sanitizeSIP(data))
+	if err := t.appendTrace(callID, entry); err != nil {
+		logger.Warn("Failed to write SIP trace", zap.String("call_id", callID), zap.Error(err))
+	}
+
+	if trace != nil && trace.pcap != nil {
+		localAddr, remoteAddr := t.udpAddr(local), t.udpAddr(remote)
+		if localAddr != nil && remoteAddr != nil {
+			src, dst := remoteAddr, localAddr
+			if outbound {
+				src, dst = localAddr, remoteAddr
+			}
+			trace.pcap.WriteUDP(now, src, dst, data)
+		}
+	}
+}
+
+// traceRTP 把RTP包写入对端匹配的通话的pcap
+func (t *SIPTracer) traceRTP(outbound bool, local net.Addr, remote *net.UDPAddr, data []byte) {
+	if remote == nil {
+		return
+	}
+	t.mutex.RLock()
+	if len(t.calls) == 0 {
+		t.mutex.RUnlock()
+		return
+	}
+	var matched []*pcapWriter
+	var unresolved []string
+	for callID, trace := range t.calls {
+		switch {
+		case trace.pcap == nil:
+		case trace.rtpPeer == nil:
+			unresolved = append(unresolved, callID)
+		case sameUDPAddr(trace.rtpPeer, remote):
+			matched = append(matched, trace.pcap)
+		}
+	}
+	rtpPeer := t.rtpPeer
+	t.mutex.RUnlock()
+
+	// 抓包可能在媒体协商前开启，解析到对端地址后缓存
+	for _, callID := range unresolved {
+		if rtpPeer == nil {
+			break
+		}
+		peer := rtpPeer(callID)
+		if peer == nil {
+			continue
+		}
+		t.mutex.Lock()
+		if trace, exists := t.calls[callID]; exists && trace.pcap != nil {
+			trace.rtpPeer = peer
+			if sameUDPAddr(peer, remote) {
+				matched = append(matched, trace.pcap)
+			}
+		}
+		t.mutex.Unlock()
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	localAddr := t.udpAddr(local)
+	if localAddr == nil {
+		return
+	}
+	src, dst := remote, localAddr
+	if outbound {
+		src, dst = localAddr, remote
+	}
+	now := time.Now()
+	for _, writer := range matched {
+		writer.WriteUDP(now, src, dst, data)
+	}
+}
+
+// appendTrace 追加到通话的跟踪文件
+func (t *SIPTracer) appendTrace(callID, entry string) error {
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(t.tracePath(callID, ".sip.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(entry)
+	return err
+}
+
+// tracePath 按Call-ID生成文件路径，去掉文件名中不安全的字符
+func (t *SIPTracer) tracePath(callID, ext string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.', r == '@':
+			return r
+		}
+		return '_'
+	}, callID)
+	if len(name) > 128 {
+		name = name[:128]
+	}
+	return filepath.Join(t.dir, name+ext)
+}
+
+// udpAddr 转为UDP地址，通配地址替换为本机地址
+func (t *SIPTracer) udpAddr(addr net.Addr) *net.UDPAddr {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	if udpAddr.IP.IsUnspecified() && t.localIP != nil {
+		return &net.UDPAddr{IP: t.localIP, Port: udpAddr.Port}
+	}
+	return udpAddr
+}
+
+func sameUDPAddr(a, b *net.UDPAddr) bool {
+	return a.Port == b.Port && a.IP.Equal(b.IP)
+}
+
+// sipCallID 从原始SIP消息中取Call-ID（含紧凑形式i:）
+func sipCallID(data []byte) string {
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 {
+			break
+		}
+		name, value, ok := bytes.Cut(line, []byte(":"))
+		if !ok {
+			continue
+		}
+		name = bytes.TrimSpace(name)
+		if bytes.EqualFold(name, []byte("Call-ID")) || bytes.EqualFold(name, []byte("i")) {
+			return string(bytes.TrimSpace(value))
+		}
+	}
+	return ""
+}
+
+// sanitizeSIP 去掉鉴权头中的摘要响应
+func sanitizeSIP(data []byte) string {
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		name, _, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "authorization", "proxy-authorization":
+			lines[i] = authCredentials.ReplaceAllString(line, `$1="***"`)
+		}
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\r\n")
+}
+
+// tracedPacketConn SIP监听连接，收发的消息交给跟踪器；收到的请求行按maskRequestLine替换IPv6地址
+type tracedPacketConn struct {
+	net.PacketConn
+	tracer *SIPTracer
+}
+
+func (c *tracedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err == nil {
+		c.tracer.traceSIP(false, c.LocalAddr(), addr, b[:n])
+		maskRequestLine(b[:n])
+	}
+	return n, addr, err
+}
+
+func (c *tracedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err == nil {
+		c.tracer.traceSIP(true, c.LocalAddr(), addr, b[:n])
+	}
+	return n, err
+}
+
+// tracedRTPConn RTP连接，收发的包写入匹配通话的pcap并计入packets
+type tracedRTPConn struct {
+	*net.UDPConn
+	tracer  *SIPTracer
+	packets *atomic.Int64
+}
+
+func (c *tracedRTPConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	n, addr, err := c.UDPConn.ReadFromUDP(b)
+	if err == nil {
+		c.count()
+		c.tracer.traceRTP(false, c.LocalAddr(), addr, b[:n])
+	}
+	return n, addr, err
+}
+
+func (c *tracedRTPConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	n, err := c.UDPConn.WriteToUDP(b, addr)
+	if err == nil {
+		c.count()
+		c.tracer.traceRTP(true, c.LocalAddr(), addr, b[:n])
+	}
+	return n, err
+}
+
+func (c *tracedRTPConn) count() {
+	if c.packets != nil {
+		c.packets.Add(1)
+	}
+}
@@ -0,0 +1,48 @@
+package sip1
+
+import (
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterTraceAPIs 注册SIP跟踪开关：GET /sip-trace 查看状态，PUT /sip-trace {"enabled":true}
+// 开关所有通话的跟踪，POST /sip-trace/calls/:callId {"pcap":true} 单独跟踪并抓包，DELETE停止
+func RegisterTraceAPIs(r gin.IRoutes, tracer *SIPTracer) {
+	r.GET("/sip-trace", func(c *gin.Context) {
+		response.Success(c, "ok", tracer.Status())
+	})
+
+	r.PUT("/sip-trace", func(c *gin.Context) {
+		var form struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		tracer.SetEnabled(form.Enabled)
+		response.Success(c, "ok", tracer.Status())
+	})
+
+	r.POST("/sip-trace/calls/:callId", func(c *gin.Context) {
+		var form struct {
+			PCAP bool `json:"pcap"`
+		}
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&form); err != nil {
+				response.Fail(c, "invalid request", err.Error())
+				return
+			}
+		}
+		if err := tracer.StartCall(c.Param("callId"), form.PCAP); err != nil {
+			response.Fail(c, "failed to start trace", err.Error())
+			return
+		}
+		response.Success(c, "ok", tracer.Status())
+	})
+
+	r.DELETE("/sip-trace/calls/:callId", func(c *gin.Context) {
+		tracer.StopCall(c.Param("callId"))
+		response.Success(c, "ok", tracer.Status())
+	})
+}
@@ -0,0 +1,270 @@
+package sip1
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/auth"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// minSipPasswordLength SIP用户密码的最小长度
+	minSipPasswordLength = 8
+	// sipUserPageSize 列表接口未指定limit时的数量，最多maxSipUserPageSize
+	sipUserPageSize    = 20
+	maxSipUserPageSize = 500
+)
+
+// sipUserForm 创建、修改SIP用户的请求，修改时只更新非nil字段
+type sipUserForm struct {
+	Username         string                 `json:"username"`
+	Password         *string                `json:"password"`
+	SchemeName       *string                `json:"schemeName"`
+	DisplayName      *string                `json:"displayName"`
+	Alias            *string                `json:"alias"`
+	BoundPhoneNumber *string                `json:"boundPhoneNumber"`
+	Forwarding       *models.CallForwarding `json:"forwarding"`
+	Enabled          *bool                  `json:"enabled"`
+	Notes            *string                `json:"notes"`
+}
+
+// apply 把表单字段写入用户，密码只保存哈希
+func (form *sipUserForm) apply(sipUser *models.SipUser, realm string) error {
+	if form.Password != nil {
+		if len(*form.Password) < minSipPasswordLength {
+			return fmt.Errorf("password must be at least %d characters long", minSipPasswordLength)
+		}
+		if err := sipUser.SetPassword(*form.Password, realm); err != nil {
+			return fmt.Errorf("failed to hash password: %w", err)
+		}
+	}
+	if form.SchemeName != nil {
+		sipUser.SchemeName = *form.SchemeName
+	}
+	if form.DisplayName != nil {
+		sipUser.DisplayName = *form.DisplayName
+	}
+	if form.Alias != nil {
+		sipUser.Alias = *form.Alias
+	}
+	if form.BoundPhoneNumber != nil {
+		sipUser.BoundPhoneNumber = *form.BoundPhoneNumber
+	}
+	if form.Forwarding != nil {
+		if form.Forwarding.NoAnswerTimeout < 0 || form.Forwarding.ForwardTimeout < 0 {
+			return fmt.Errorf("forwarding timeouts must not be negative")
+		}
+		sipUser.Forwarding = *form.Forwarding
+	}
+	if form.Enabled != nil {
+		sipUser.Enabled = *form.Enabled
+	}
+	if form.Notes != nil {
+		sipUser.Notes = *form.Notes
+	}
+	return nil
+}
+
+// requireActor 拒绝未经auth.RequireAdmin认证的请求，接口误注册在未认证的路由组上时也不放行
+func requireActor(c *gin.Context) {
+	if auth.CurrentActor(c) == "" {
+		response.AbortWithStatusJSON(c, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	c.Next()
+}
+
+// RegisterSipUserAPIs 注册SIP用户管理接口：GET /sip-users?enabled=true&offset=0&limit=20 列表，
+// GET /sip-users/:id，POST /sip-users {"username":"1001","password":"..."} 创建，PUT /sip-users/:id
+// 修改（{"enabled":false}停用并注销已注册的联系地址，{"forwarding":{"busy":"script"}}设置呼叫前转），DELETE /sip-users/:id 删除。密码只保存哈希，接口不返回；
+// 均需认证，修改记录操作人
+func RegisterSipUserAPIs(r gin.IRoutes, server *SipServer) {
+	db := func(c *gin.Context) (*gorm.DB, bool) {
+		if server.config.Db == nil {
+			response.Fail(c, "database not configured", nil)
+			return nil, false
+		}
+		return server.config.Db, true
+	}
+	load := func(c *gin.Context) (*gorm.DB, *models.SipUser, bool) {
+		conn, ok := db(c)
+		if !ok {
+			return nil, nil, false
+		}
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			response.Fail(c, "invalid user id", err.Error())
+			return nil, nil, false
+		}
+		sipUser, err := models.GetSipUserByID(conn, uint(id))
+		if err != nil {
+			response.Fail(c, "SIP user not found", err.Error())
+			return nil, nil, false
+		}
+		return conn, sipUser, true
+	}
+
+	r.GET("/sip-users", requireActor, func(c *gin.Context) {
+		conn, ok := db(c)
+		if !ok {
+			return
+		}
+		var enabled *bool
+		if value := c.Query("enabled"); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				response.Fail(c, "invalid enabled filter", err.Error())
+				return
+			}
+			enabled = &parsed
+		}
+		offset, _ := strconv.Atoi(c.Query("offset"))
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		if limit <= 0 {
+			limit = sipUserPageSize
+		}
+		limit = min(limit, maxSipUserPageSize)
+
+		sipUsers, total, err := models.ListSipUsers(conn, enabled, max(offset, 0), limit)
+		if err != nil {
+			response.Fail(c, "failed to list SIP users", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"items": sipUsers, "total": total})
+	})
+
+	r.GET("/sip-users/:id", requireActor, func(c *gin.Context) {
+		if _, sipUser, ok := load(c); ok {
+			response.Success(c, "ok", sipUser)
+		}
+	})
+
+	r.POST("/sip-users", requireActor, func(c *gin.Context) {
+		conn, ok := db(c)
+		if !ok {
+			return
+		}
+		var form sipUserForm
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		if form.Username == "" || form.Password == nil {
+			response.Fail(c, "invalid request", "username and password are required")
+			return
+		}
+		if _, err := models.GetSipUserByUsername(conn, form.Username); err == nil {
+			response.Fail(c, "SIP user already exists", form.Username)
+			return
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			response.Fail(c, "failed to check SIP user", err.Error())
+			return
+		}
+
+		sipUser := &models.SipUser{
+			Username:   form.Username,
+			SchemeName: form.Username,
+			Status:     models.SipUserStatusUnregistered,
+			Enabled:    true,
+		}
+		if err := form.apply(sipUser, server.config.AuthenticationRealm); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		enabled := sipUser.Enabled
+		if err := models.CreateSipUser(conn, sipUser); err != nil {
+			response.Fail(c, "failed to create SIP user", err.Error())
+			return
+		}
+		// gorm对有默认值的字段不写入false，创建后按表单修正
+		if !enabled {
+			if err := conn.Model(sipUser).Update("enabled", false).Error; err != nil {
+				response.Fail(c, "failed to disable SIP user", err.Error())
+				return
+			}
+			sipUser.Enabled = false
+		}
+		logger.Info("SIP user created",
+			zap.String("username", sipUser.Username),
+			zap.Bool("enabled", sipUser.Enabled),
+			zap.String("actor", auth.CurrentActor(c)))
+		response.Success(c, "ok", sipUser)
+	})
+
+	r.PUT("/sip-users/:id", requireActor, func(c *gin.Context) {
+		conn, sipUser, ok := load(c)
+		if !ok {
+			return
+		}
+		var form sipUserForm
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		if form.Username != "" && form.Username != sipUser.Username {
+			response.Fail(c, "invalid request", "username cannot be changed")
+			return
+		}
+		// 停用前先注销并重新读取注册状态，停用的用户不能再修改注册
+		if form.Enabled != nil && !*form.Enabled && sipUser.Enabled {
+			server.unbindSipUser(sipUser.Username)
+			reloaded, err := models.GetSipUserByID(conn, sipUser.ID)
+			if err != nil {
+				response.Fail(c, "SIP user not found", err.Error())
+				return
+			}
+			sipUser = reloaded
+		}
+		if err := form.apply(sipUser, server.config.AuthenticationRealm); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		if err := models.UpdateSipUser(conn, sipUser); err != nil {
+			response.Fail(c, "failed to update SIP user", err.Error())
+			return
+		}
+		logger.Info("SIP user updated",
+			zap.String("username", sipUser.Username),
+			zap.Bool("enabled", sipUser.Enabled),
+			zap.Bool("password_changed", form.Password != nil),
+			zap.String("actor", auth.CurrentActor(c)))
+		response.Success(c, "ok", sipUser)
+	})
+
+	r.DELETE("/sip-users/:id", requireActor, func(c *gin.Context) {
+		conn, sipUser, ok := load(c)
+		if !ok {
+			return
+		}
+		if sipUser.Enabled {
+			server.unbindSipUser(sipUser.Username)
+		}
+		if err := models.DeleteSipRegistrations(conn, sipUser.Username); err != nil {
+			logger.Warn("Failed to remove registrations of deleted SIP user", zap.String("username", sipUser.Username), zap.Error(err))
+		}
+		if err := models.DeleteSipUser(conn, sipUser.ID); err != nil {
+			response.Fail(c, "failed to delete SIP user", err.Error())
+			return
+		}
+		logger.Info("SIP user deleted", zap.String("username", sipUser.Username), zap.String("actor", auth.CurrentActor(c)))
+		response.Success(c, "ok", nil)
+	})
+}
+
+// unbindSipUser 注销用户的所有联系地址，用于停用或删除用户
+func (as *SipServer) unbindSipUser(username string) {
+	if err := as.config.SaveRegistration(&ua.RegistrationInfo{Username: username, Wildcard: true}); err != nil {
+		logger.Warn("Failed to remove bindings of SIP user", zap.String("username", username), zap.Error(err))
+	}
+	as.forgetContact(username)
+	as.presenceChanged(username)
+}
@@ -0,0 +1,59 @@
+package sip1
+
+import (
+	"errors"
+	"net"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+// ErrUnknownSource INVITE来源既不是IP认证中继也不是已注册用户
+var ErrUnknownSource = errors.New("unknown INVITE source")
+
+// requestSourceIP 获取请求的来源IP（传输层地址，而非可伪造的Via）
+func requestSourceIP(req *sip.Request) string {
+	source := req.Source()
+	if host, _, err := net.SplitHostPort(source); err == nil {
+		return host
+	}
+	return source
+}
+
+// inboundTrunk 按来源地址匹配IP认证中继
+func (as *SipServer) inboundTrunk(req *sip.Request) *TrunkConnection {
+	if as.trunkManager == nil {
+		return nil
+	}
+	conn, _ := as.trunkManager.MatchInboundSource(requestSourceIP(req))
+	return conn
+}
+
+// authorizeInviteSource 校验INVITE来源是IP认证中继或已注册用户；
+// 未开启RejectUnknownSources时未知来源也放行
+func (as *SipServer) authorizeInviteSource(req *sip.Request) error {
+	if as.inboundTrunk(req) != nil || !as.config.RejectUnknownSources {
+		return nil
+	}
+
+	// 已注册的话机/软电话直接呼入，同一用户的每个终端都可以
+	sourceIP := requestSourceIP(req)
+	bindings, err := as.config.RegisteredBindings()
+	if err != nil {
+		logger.Error("Failed to load registered users for source check", zap.Error(err))
+	}
+	for _, userBindings := range bindings {
+		for _, binding := range userBindings {
+			host, _, err := net.SplitHostPort(binding.Contact)
+			if err != nil {
+				host = binding.Contact
+			}
+			if host == sourceIP {
+				return nil
+			}
+		}
+	}
+
+	return ErrUnknownSource
+}
@@ -0,0 +1,74 @@
+package sip1
+
+import (
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+	"go.uber.org/zap"
+)
+
+const (
+	// storageReportInterval 存储耗时统计的输出间隔
+	storageReportInterval = 5 * time.Minute
+	// filePruneInterval 文件存储清理间隔
+	filePruneInterval = time.Hour
+)
+
+// runStorageReporter 定期输出各存储后端的操作耗时和错误率
+func (as *SipServer) runStorageReporter() {
+	ticker := time.NewTicker(storageReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-as.stopChan:
+			return
+		case <-ticker.C:
+			as.logStorageStats()
+		}
+	}
+}
+
+func (as *SipServer) logStorageStats() {
+	for _, stats := range as.config.StorageStats() {
+		logger.Info("Storage operation stats",
+			zap.String("backend", string(stats.Backend)),
+			zap.String("operation", stats.Operation),
+			zap.Int64("count", stats.Count),
+			zap.Int64("errors", stats.Errors),
+			zap.Float64("error_rate", stats.ErrorRate()),
+			zap.Int64("slow", stats.Slow),
+			zap.Duration("avg", stats.AvgDuration()),
+			zap.Duration("max", stats.MaxDuration))
+	}
+}
+
+// runFilePruner 文件存储模式下按保留时长和总大小定期清理记录
+func (as *SipServer) runFilePruner() {
+	if as.config.StorageType != ua.StorageTypeFile || (as.config.FileRetention <= 0 && as.config.FileMaxBytes <= 0) {
+		return
+	}
+
+	ticker := time.NewTicker(filePruneInterval)
+	defer ticker.Stop()
+
+	for {
+		as.pruneFileStorage()
+		select {
+		case <-as.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (as *SipServer) pruneFileStorage() {
+	removed, err := as.config.PruneFileStorage(as.config.FileRetention, as.config.FileMaxBytes)
+	if err != nil {
+		logger.Warn("Failed to prune file storage", zap.Error(err))
+	}
+	if removed > 0 {
+		logger.Info("Pruned file storage", zap.Int("removed", removed))
+	}
+}
@@ -0,0 +1,73 @@
+package sip1
+
+import "github.com/pion/rtp"
+
+// defaultTelephoneEventPT 对端offer未声明telephone-event时常见的载荷类型
+const defaultTelephoneEventPT = 101
+
+// telephoneEventDigits RFC 4733 事件码到按键
+var telephoneEventDigits = map[byte]string{
+	0: "0", 1: "1", 2: "2", 3: "3", 4: "4", 5: "5", 6: "6", 7: "7", 8: "8", 9: "9",
+	10: "*", 11: "#", 12: "A", 13: "B", 14: "C", 15: "D",
+}
+
+// telephoneEventDetector 将RFC 4733事件包还原为按键：同一次按键的所有包共享RTP时间戳，
+// 结束包会重发3次，按时间戳去重只上报一次；结束包全部丢失时在下一个事件开始时补报
+type telephoneEventDetector struct {
+	payloadType uint8
+
+	active    bool
+	timestamp uint32
+	event     byte
+	reported  bool
+	lastEnded uint32
+	hasEnded  bool
+}
+
+func newTelephoneEventDetector(payloadType uint8) *telephoneEventDetector {
+	if payloadType == 0 {
+		payloadType = defaultTelephoneEventPT
+	}
+	return &telephoneEventDetector{payloadType: payloadType}
+}
+
+// Push 处理一个RTP包，返回本包确认的按键（可能为上一个未收到结束包的按键）
+func (d *telephoneEventDetector) Push(packet *rtp.Packet) []string {
+	if packet.PayloadType != d.payloadType || len(packet.Payload) < 4 {
+		return nil
+	}
+	event := packet.Payload[0]
+	end := packet.Payload[1]&0x80 != 0
+	timestamp := packet.Timestamp
+
+	// 已经上报过的事件的重发结束包
+	if d.hasEnded && timestamp == d.lastEnded {
+		return nil
+	}
+
+	var digits []string
+	if d.active && timestamp != d.timestamp {
+		// 新事件开始，上一个事件没收到结束包
+		if digit, ok := telephoneEventDigits[d.event]; ok && !d.reported {
+			digits = append(digits, digit)
+		}
+		d.active = false
+	}
+
+	if !d.active {
+		d.active = true
+		d.timestamp = timestamp
+		d.event = event
+		d.reported = false
+	}
+
+	if end {
+		if digit, ok := telephoneEventDigits[d.event]; ok && !d.reported {
+			digits = append(digits, digit)
+		}
+		d.active = false
+		d.lastEnded = timestamp
+		d.hasEnded = true
+	}
+	return digits
+}
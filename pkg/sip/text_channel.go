@@ -0,0 +1,246 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// defaultTextReplyTimeout 文字会话等待用户回复的最短时间，步骤按语音设置的等待时间对打字来说太短
+const defaultTextReplyTimeout = 2 * time.Minute
+
+// ErrTextSessionEnded 文字会话已结束
+var ErrTextSessionEnded = errors.New("text session ended")
+
+// TextOptions 文字会话的参数
+type TextOptions struct {
+	SessionID    string        // 为空时自动生成，作为会话的CallID
+	User         string        // 用户标识，如网页访客ID或微信OpenID，写入会话记录的主叫号码
+	ReplyTimeout time.Duration // 每次等待用户回复的最短时间，默认2分钟
+}
+
+// TextConversation 文字渠道的脚本会话：与电话使用同一脚本，播报改为发送文字，
+// 语音识别和按键改为读取用户发来的文字，会话、步骤和对话记录与电话共用
+type TextConversation struct {
+	CallID string
+
+	engine  *AIPhoneEngine
+	channel *textChannel
+}
+
+// StartText 启动被叫号码对应脚本的文字会话，用Next取得开场的回复
+func (engine *AIPhoneEngine) StartText(phoneNumber string, opts TextOptions) (*TextConversation, error) {
+	callID := opts.SessionID
+	if callID == "" {
+		callID = "text-" + uuid.NewString()
+	}
+	if engine.GetSession(callID) != nil {
+		return nil, fmt.Errorf("session %s already exists", callID)
+	}
+	replyTimeout := opts.ReplyTimeout
+	if replyTimeout <= 0 {
+		replyTimeout = defaultTextReplyTimeout
+	}
+
+	channel := newTextChannel(replyTimeout)
+	if _, err := engine.startScript(scriptCall{
+		callID:       callID,
+		phoneNumber:  phoneNumber,
+		callerNumber: opts.User,
+		codec:        codecPCMU,
+		text:         channel,
+	}); err != nil {
+		return nil, err
+	}
+
+	logger.Info("Text session started",
+		zap.String("call_id", callID),
+		zap.String("phone", phoneNumber),
+		zap.String("user", opts.User))
+	return &TextConversation{CallID: callID, engine: engine, channel: channel}, nil
+}
+
+// TextConversation 获取进行中的文字会话，会话不存在或不是文字会话时返回nil
+func (engine *AIPhoneEngine) TextConversation(callID string) *TextConversation {
+	session := engine.GetSession(callID)
+	if session == nil || session.text == nil {
+		return nil
+	}
+	return &TextConversation{CallID: callID, engine: engine, channel: session.text}
+}
+
+// Send 发送一条用户消息；脚本未在等待输入时排队，下次等待时读取
+func (c *TextConversation) Send(text string) error {
+	return c.channel.send(text)
+}
+
+// Next 等待脚本处理完已发送的消息并再次等待用户输入，返回期间的回复；
+// 会话结束时ended为true，ctx结束时返回已有的回复
+func (c *TextConversation) Next(ctx context.Context) (replies []string, ended bool) {
+	return c.channel.next(ctx)
+}
+
+// End 用户离开，停止脚本会话，可重复调用
+func (c *TextConversation) End() {
+	c.channel.close()
+	c.engine.StopSession(c.CallID)
+}
+
+// textChannel 文字会话的收发通道：脚本的播报追加到replies，监听时读取inbound
+type textChannel struct {
+	replyTimeout time.Duration
+
+	mutex   sync.Mutex
+	inbound []string
+	replies []string
+	sent    int  // 用户已发送的消息数
+	read    int  // 脚本已读取的消息数
+	waiting bool // 脚本正在等待用户输入
+	ended   bool
+	changed chan struct{} // 状态变化时关闭并重建
+}
+
+func newTextChannel(replyTimeout time.Duration) *textChannel {
+	return &textChannel{replyTimeout: replyTimeout, changed: make(chan struct{})}
+}
+
+// notifyLocked 唤醒等待状态变化的一方，需持有锁
+func (c *textChannel) notifyLocked() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *textChannel) send(text string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ended {
+		return ErrTextSessionEnded
+	}
+	c.inbound = append(c.inbound, text)
+	c.sent++
+	c.notifyLocked()
+	return nil
+}
+
+// say 追加一条回复，会话结束后丢弃；nil安全
+func (c *textChannel) say(text string) {
+	if c == nil || text == "" {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ended {
+		return
+	}
+	c.replies = append(c.replies, text)
+	c.notifyLocked()
+}
+
+func (c *textChannel) next(ctx context.Context) ([]string, bool) {
+	for {
+		c.mutex.Lock()
+		if c.ended || c.waiting && c.read == c.sent || ctx.Err() != nil {
+			replies, ended := c.replies, c.ended
+			c.replies = nil
+			c.mutex.Unlock()
+			return replies, ended
+		}
+		changed := c.changed
+		c.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// receive 读取下一条用户消息，超时返回空串，会话结束返回ErrTextSessionEnded
+func (c *textChannel) receive(timeout <-chan time.Time) (string, error) {
+	for {
+		c.mutex.Lock()
+		if c.ended {
+			c.mutex.Unlock()
+			return "", ErrTextSessionEnded
+		}
+		if len(c.inbound) > 0 {
+			text := c.inbound[0]
+			c.inbound = c.inbound[1:]
+			c.read++
+			c.waiting = false
+			c.notifyLocked()
+			c.mutex.Unlock()
+			return text, nil
+		}
+		if !c.waiting {
+			c.waiting = true
+			c.notifyLocked()
+		}
+		changed := c.changed
+		c.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout:
+			c.mutex.Lock()
+			c.waiting = false
+			c.notifyLocked()
+			c.mutex.Unlock()
+			return "", nil
+		}
+	}
+}
+
+// close 结束会话，唤醒等待的双方；nil安全，可重复调用
+func (c *textChannel) close() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.ended {
+		c.ended = true
+		c.notifyLocked()
+	}
+}
+
+// listenForText 文字会话中代替语音识别，读取用户的下一条消息；设置了按键回调时单个按键字符同时按按键回调
+func (engine *AIPhoneEngine) listenForText(session *ScriptSession, timeout time.Duration) (string, error) {
+	text, err := session.text.receive(engine.getClock().After(max(timeout, session.text.replyTimeout)))
+	if err != nil {
+		return "", err
+	}
+	text = strings.TrimSpace(text)
+	if onDigit := session.digitHandler(); onDigit != nil && len(text) == 1 && strings.ContainsAny(text, "0123456789*#ABCD") {
+		onDigit(text)
+	}
+	return text, nil
+}
+
+// listenForTextDigits 文字会话中代替按键检测，从用户消息中取出按键字符
+func (engine *AIPhoneEngine) listenForTextDigits(session *ScriptSession, timeout time.Duration, maxDigits int, terminator string) (string, error) {
+	text, err := engine.listenForText(session, timeout)
+	if err != nil {
+		return "", err
+	}
+	digits := ""
+	for _, r := range strings.ToUpper(text) {
+		digit := string(r)
+		if digit == terminator {
+			break
+		}
+		if strings.ContainsRune("0123456789*#ABCD", r) {
+			digits += digit
+		}
+		if len(digits) >= maxDigits {
+			break
+		}
+	}
+	return digits, nil
+}
@@ -0,0 +1,82 @@
+package sip1
+
+import (
+	"strconv"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterTranscriptAPIs 注册录音转录接口：POST /calls/:callId/transcription 后台转录录音并按说话人标注，
+// GET /calls/:callId/transcription 返回转录状态、分角色语段和各说话人的讲话时长，
+// GET /transcripts/search?q=退款&speaker=agent&limit=20 检索已转录的通话，
+// GET /calls/:callId/llm-exchanges?stepExecutionId=12 按时间顺序列出发送给LLM的请求与回复（需开启LLM请求记录）
+func RegisterTranscriptAPIs(r gin.IRoutes, server *SipServer) {
+	r.POST("/calls/:callId/transcription", func(c *gin.Context) {
+		if err := server.TranscribeCall(c.Param("callId")); err != nil {
+			response.Fail(c, "failed to transcribe call", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"status": models.TranscriptionProcessing})
+	})
+
+	r.GET("/calls/:callId/transcription", func(c *gin.Context) {
+		sipCall, found := server.config.GetCall(c.Param("callId"))
+		if !found {
+			response.Fail(c, "call not found", nil)
+			return
+		}
+		status := sipCall.TranscriptionStatus
+		if status == "" {
+			status = models.TranscriptionPending
+		}
+		segments := sipCall.TranscriptSegments
+		if segments == nil {
+			segments = models.TranscriptSegments{}
+		}
+		response.Success(c, "ok", gin.H{
+			"status":   status,
+			"error":    sipCall.TranscriptionError,
+			"segments": segments,
+			"talkTime": segments.TalkTime(),
+		})
+	})
+
+	r.GET("/transcripts/search", func(c *gin.Context) {
+		keyword := c.Query("q")
+		if keyword == "" {
+			response.Fail(c, "q is required", nil)
+			return
+		}
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		matches, err := server.SearchTranscripts(keyword, c.Query("speaker"), limit)
+		if err != nil {
+			response.Fail(c, "failed to search transcripts", err.Error())
+			return
+		}
+		response.Success(c, "ok", matches)
+	})
+
+	r.GET("/calls/:callId/llm-exchanges", func(c *gin.Context) {
+		if server.config.Db == nil {
+			response.Fail(c, "database not configured", nil)
+			return
+		}
+		var stepExecutionID uint64
+		if value := c.Query("stepExecutionId"); value != "" {
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				response.Fail(c, "invalid stepExecutionId", err.Error())
+				return
+			}
+			stepExecutionID = id
+		}
+		exchanges, err := models.GetLLMExchangesByCallID(server.config.Db, c.Param("callId"), uint(stepExecutionID))
+		if err != nil {
+			response.Fail(c, "failed to list LLM exchanges", err.Error())
+			return
+		}
+		response.Success(c, "ok", exchanges)
+	})
+}
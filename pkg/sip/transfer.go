@@ -0,0 +1,240 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"go.uber.org/zap"
+)
+
+// errTransferStopped 转接等待期间会话被停止，executeScript按正常停止处理
+var errTransferStopped = errors.New("session stopped during transfer")
+
+// transferTypeAttended 咨询转接：先接通目标，再让来电者用Replaces替换咨询通话
+const transferTypeAttended = "attended"
+
+// callTransfer 进行中的REFER转接，progress接收NOTIFY中sipfrag的状态码
+type callTransfer struct {
+	CallID   string
+	ReferTo  string
+	progress chan int
+}
+
+// normalizeTransferTarget 将转接目标转换为Refer-To URI，纯号码使用对端域名补全
+func normalizeTransferTarget(dialog *CallDialog, target string) string {
+	return normalizeTarget(target, dialog.RemoteURI)
+}
+
+// normalizeTarget 把号码、用户名或user@host补全为SIP地址，只有号码时使用peer的主机
+func normalizeTarget(target string, peer sip.Uri) string {
+	target = strings.TrimSpace(target)
+	lower := strings.ToLower(target)
+	if strings.HasPrefix(lower, "sip:") || strings.HasPrefix(lower, "sips:") || strings.HasPrefix(lower, "tel:") {
+		return target
+	}
+	if strings.Contains(target, "@") {
+		return "sip:" + target
+	}
+	host := peer.Host
+	if peer.Port > 0 {
+		host = fmt.Sprintf("%s:%d", host, peer.Port)
+	}
+	return fmt.Sprintf("sip:%s@%s", target, host)
+}
+
+// sendRefer 在对话内发送REFER，远端接受(202)后返回，后续进度通过NOTIFY上报
+func (as *SipServer) sendRefer(callID, target string) (*callTransfer, error) {
+	dialog, exists := as.getDialog(callID)
+	if !exists {
+		return nil, fmt.Errorf("dialog not found: %s", callID)
+	}
+
+	// 不要把电话转给已经失联的注册分机
+	if !as.IsUserReachable(target) {
+		return nil, fmt.Errorf("transfer target %s is unreachable", target)
+	}
+
+	return as.referDialog(dialog, normalizeTransferTarget(dialog, target))
+}
+
+// sendAttendedRefer 咨询转接：呼叫目标建立咨询通话，接通后向来电者发送Refer-To带Replaces的REFER，
+// 来电者呼叫目标并替换掉咨询通话（RFC 5589 7.）。返回转接和咨询通话的callID，REFER失败时咨询通话已挂断
+func (as *SipServer) sendAttendedRefer(ctx context.Context, callID, target string) (*callTransfer, string, error) {
+	dialog, exists := as.getDialog(callID)
+	if !exists {
+		return nil, "", fmt.Errorf("dialog not found: %s", callID)
+	}
+
+	consultID, _, _, err := as.dialBridgeLeg(ctx, callID, dialog, target, fmt.Sprintf("consultation for %s", callID))
+	if err != nil {
+		return nil, "", fmt.Errorf("consultation call failed: %w", err)
+	}
+	consult, exists := as.getDialog(consultID)
+	if !exists {
+		return nil, "", fmt.Errorf("consultation call %s ended", consultID)
+	}
+
+	transfer, err := as.referDialog(dialog, attendedReferTo(consult))
+	if err != nil {
+		as.hangupCall(consultID)
+		return nil, "", err
+	}
+	return transfer, consultID, nil
+}
+
+// attendedReferTo 咨询转接的Refer-To：目标的Contact，内嵌转义后的Replaces。
+// Replaces按目标一侧描述咨询对话，to-tag为目标的标签，from-tag为我方的（RFC 3891 3.）
+func attendedReferTo(consult *CallDialog) string {
+	replaces := fmt.Sprintf("%s;to-tag=%s;from-tag=%s", consult.CallID, consult.RemoteTag, consult.LocalTag)
+	target := consult.RemoteTarget
+	target.Headers = nil
+	return target.String() + "?Replaces=" + url.QueryEscape(replaces)
+}
+
+// referDialog 发送Refer-To为referTo的REFER，先登记转接以接收NOTIFY
+func (as *SipServer) referDialog(dialog *CallDialog, referTo string) (*callTransfer, error) {
+	refer := dialog.NewRequest(sip.REFER)
+	refer.AppendHeader(sip.NewHeader("Refer-To", "<"+referTo+">"))
+	refer.AppendHeader(sip.NewHeader("Referred-By", "<"+dialog.LocalURI.String()+">"))
+
+	transfer := &callTransfer{
+		CallID:   dialog.CallID,
+		ReferTo:  referTo,
+		progress: make(chan int, 8),
+	}
+	// 先登记再发送，避免NOTIFY先于202到达时丢失
+	as.transfersMutex.Lock()
+	as.transfers[dialog.CallID] = transfer
+	as.transfersMutex.Unlock()
+
+	if err := as.waitReferAccepted(refer); err != nil {
+		as.removeTransfer(dialog.CallID)
+		return nil, err
+	}
+
+	logger.Info("REFER accepted by remote",
+		zap.String("call_id", dialog.CallID),
+		zap.String("refer_to", referTo))
+	return transfer, nil
+}
+
+// waitReferAccepted 发送REFER并等待最终响应
+func (as *SipServer) waitReferAccepted(refer *sip.Request) error {
+	ctx, cancel := context.WithTimeout(context.Background(), as.config.TransactionTimeout)
+	defer cancel()
+
+	tx, err := as.client.TransactionRequest(ctx, refer, as.clientMaxForwards, sipgo.ClientRequestBuild)
+	if err != nil {
+		return fmt.Errorf("failed to send REFER: %w", err)
+	}
+	defer tx.Terminate()
+
+	for {
+		select {
+		case res := <-tx.Responses():
+			if res.IsProvisional() {
+				continue
+			}
+			if !res.IsSuccess() {
+				return fmt.Errorf("REFER rejected: %d %s", res.StatusCode, res.Reason)
+			}
+			return nil
+		case <-tx.Done():
+			return fmt.Errorf("REFER transaction ended: %w", tx.Err())
+		case <-ctx.Done():
+			return fmt.Errorf("REFER timeout: %w", ctx.Err())
+		}
+	}
+}
+
+// waitTransferResult 等待转接最终结果，返回sipfrag中的最终状态码；ctx取消时返回errTransferStopped
+func (as *SipServer) waitTransferResult(ctx context.Context, transfer *callTransfer, timeout time.Duration) (int, error) {
+	defer as.removeTransfer(transfer.CallID)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case code := <-transfer.progress:
+			if code >= 200 {
+				return code, nil
+			}
+		case <-timer.C:
+			return 0, fmt.Errorf("transfer timeout after %v", timeout)
+		case <-ctx.Done():
+			return 0, errTransferStopped
+		}
+	}
+}
+
+// removeTransfer 删除转接记录
+func (as *SipServer) removeTransfer(callID string) {
+	as.transfersMutex.Lock()
+	defer as.transfersMutex.Unlock()
+	delete(as.transfers, callID)
+}
+
+// handleNotify 处理REFER订阅的NOTIFY（RFC 3515）
+func (as *SipServer) handleNotify(req *sip.Request, tx sip.ServerTransaction) {
+	callID := req.CallID().Value()
+
+	as.transfersMutex.RLock()
+	transfer, exists := as.transfers[callID]
+	as.transfersMutex.RUnlock()
+
+	event := req.GetHeader("Event")
+	if !exists || event == nil || !strings.HasPrefix(strings.ToLower(strings.TrimSpace(event.Value())), "refer") {
+		res := sip.NewResponseFromRequest(req, sip.StatusCallTransactionDoesNotExists, "Call/Transaction Does Not Exist", nil)
+		if err := tx.Respond(res); err != nil {
+			logger.Error("Failed to send 481 response", zap.Error(err))
+		}
+		return
+	}
+
+	res := sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil)
+	if err := tx.Respond(res); err != nil {
+		logger.Error("Failed to send NOTIFY response", zap.Error(err))
+	}
+
+	code, err := parseSipfragStatus(req.Body())
+	if err != nil {
+		logger.Warn("Invalid sipfrag in NOTIFY", zap.String("call_id", callID), zap.Error(err))
+		return
+	}
+
+	logger.Info("Transfer progress",
+		zap.String("call_id", callID),
+		zap.String("refer_to", transfer.ReferTo),
+		zap.Int("status", code))
+
+	select {
+	case transfer.progress <- code:
+	default:
+	}
+}
+
+// parseSipfragStatus 解析message/sipfrag中的状态行，如 "SIP/2.0 200 OK"
+func parseSipfragStatus(body []byte) (int, error) {
+	line := strings.TrimSpace(string(body))
+	if idx := strings.IndexAny(line, "\r\n"); idx >= 0 {
+		line = line[:idx]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(strings.ToUpper(fields[0]), "SIP/") {
+		return 0, fmt.Errorf("unexpected status line: %q", line)
+	}
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid status code %q: %w", fields[1], err)
+	}
+	return code, nil
+}
@@ -0,0 +1,85 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+func TestWaitTransferResult(t *testing.T) {
+	server := &SipServer{transfers: make(map[string]*callTransfer)}
+	newTransfer := func(codes ...int) *callTransfer {
+		transfer := &callTransfer{CallID: "call-1", progress: make(chan int, 8)}
+		server.transfers[transfer.CallID] = transfer
+		for _, code := range codes {
+			transfer.progress <- code
+		}
+		return transfer
+	}
+
+	code, err := server.waitTransferResult(context.Background(), newTransfer(100, 180, 200), time.Second)
+	if err != nil || code != 200 {
+		t.Errorf("waitTransferResult() = %d, %v, want 200", code, err)
+	}
+	if _, exists := server.transfers["call-1"]; exists {
+		t.Error("transfer still registered after the final NOTIFY")
+	}
+	if code, err := server.waitTransferResult(context.Background(), newTransfer(180, 486), time.Second); err != nil || code != 486 {
+		t.Errorf("waitTransferResult() = %d, %v, want 486", code, err)
+	}
+	if _, err := server.waitTransferResult(context.Background(), newTransfer(100), 20*time.Millisecond); err == nil || errors.Is(err, errTransferStopped) {
+		t.Errorf("waitTransferResult() without a final NOTIFY = %v, want timeout", err)
+	}
+}
+
+// TestWaitTransferResultStop 等待期间停止会话返回errTransferStopped，停止信号仍留给脚本循环
+func TestWaitTransferResultStop(t *testing.T) {
+	server := &SipServer{transfers: make(map[string]*callTransfer)}
+	session := &ScriptSession{CallID: "call-1", StopChan: make(chan bool, 1)}
+	transfer := &callTransfer{CallID: "call-1", progress: make(chan int, 8)}
+	server.transfers["call-1"] = transfer
+
+	time.AfterFunc(20*time.Millisecond, session.requestStop)
+	if _, err := server.waitTransferResult(session.stopContext(), transfer, 5*time.Second); !errors.Is(err, errTransferStopped) {
+		t.Fatalf("waitTransferResult() = %v, want errTransferStopped", err)
+	}
+	select {
+	case <-session.StopChan:
+	default:
+		t.Error("stop signal consumed by the transfer wait")
+	}
+
+	// 停止后才取用的context同样已取消
+	stopped := &ScriptSession{StopChan: make(chan bool, 1)}
+	stopped.requestStop()
+	if stopped.stopContext().Err() == nil {
+		t.Error("stopContext() of a stopped session not cancelled")
+	}
+}
+
+func TestAttendedReferTo(t *testing.T) {
+	consult := &CallDialog{
+		CallID:       "4f2a@10.0.0.1",
+		LocalTag:     "lingsip-tag",
+		RemoteTag:    "agent-tag",
+		RemoteTarget: sip.Uri{User: "1001", Host: "10.0.0.5", Port: 5062, UriParams: sip.HeaderParams{"transport": "udp"}},
+	}
+	referTo := attendedReferTo(consult)
+
+	// 来电者解析出的目标和Replaces与咨询通话一致
+	req := sip.NewRequest(sip.REFER, &sip.Uri{Host: "10.0.0.9"})
+	req.AppendHeader(sip.NewHeader("Refer-To", "<"+referTo+">"))
+	target, replaces, err := parseReferTo(req)
+	if err != nil {
+		t.Fatalf("parseReferTo(%s) error = %v", referTo, err)
+	}
+	if target.User != "1001" || target.Host != "10.0.0.5" || target.Port != 5062 {
+		t.Errorf("Refer-To target = %s", target.String())
+	}
+	if replaces != "4f2a@10.0.0.1;to-tag=agent-tag;from-tag=lingsip-tag" {
+		t.Errorf("Replaces = %q", replaces)
+	}
+}
@@ -0,0 +1,180 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBotLanguage = "中文"
+	translateTimeout   = 10 * time.Second
+)
+
+// stepTranslation 翻译对话步骤的语言设置
+type stepTranslation struct {
+	caller  string // 来电者的语言
+	bot     string // 提示词和AI回复的语言
+	dialect string // 识别来电者语音的方言回退顺序
+}
+
+// executeTranslateStep 执行翻译对话步骤：来电者的话译成BotLanguage后交给AI，AI回复译成来电者的语言后播放，
+// 流程与AI对话步骤相同
+func (engine *AIPhoneEngine) executeTranslateStep(session *ScriptSession, step *models.AIPhoneScriptStep, execution *models.StepExecution) (string, error) {
+	data := step.Data
+	if strings.TrimSpace(data.CallerLanguage) == "" {
+		return "", errors.New("translate step requires callerLanguage")
+	}
+	translation := &stepTranslation{
+		caller:  strings.TrimSpace(data.CallerLanguage),
+		bot:     strings.TrimSpace(data.BotLanguage),
+		dialect: data.CallerDialect,
+	}
+	if translation.bot == "" {
+		translation.bot = defaultBotLanguage
+	}
+	execution.Input = translation.bot + " <-> " + translation.caller
+
+	session.mutex.Lock()
+	session.translation = translation
+	session.mutex.Unlock()
+	defer func() {
+		session.mutex.Lock()
+		session.translation = nil
+		session.mutex.Unlock()
+	}()
+	return engine.executeCalloutStep(session, step, execution)
+}
+
+// translating 返回当前步骤的语言设置，不在翻译对话步骤时为nil
+func (session *ScriptSession) translating() *stepTranslation {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	return session.translation
+}
+
+// asrDialect 识别使用的方言回退顺序，翻译对话步骤配置的优先于脚本
+func (session *ScriptSession) asrDialect() string {
+	if translation := session.translating(); translation != nil && translation.dialect != "" {
+		return translation.dialect
+	}
+	if session.Script != nil {
+		return session.Script.Dialect
+	}
+	return ""
+}
+
+// toCallerLanguage 翻译对话步骤中把要播放的文字译成来电者的语言，其余步骤原样返回
+func (engine *AIPhoneEngine) toCallerLanguage(session *ScriptSession, text string) string {
+	translation := session.translating()
+	if translation == nil {
+		return text
+	}
+	return engine.translate(session, text, translation.bot, translation.caller)
+}
+
+// toBotLanguage 翻译对话步骤中把来电者的话译成提示词的语言，其余步骤原样返回
+func (engine *AIPhoneEngine) toBotLanguage(session *ScriptSession, text string) string {
+	translation := session.translating()
+	if translation == nil {
+		return text
+	}
+	return engine.translate(session, text, "", translation.bot)
+}
+
+// translate 优先使用翻译服务，未配置时由对话服务翻译；失败时返回原文，不中断通话
+func (engine *AIPhoneEngine) translate(session *ScriptSession, text, from, to string) string {
+	if strings.TrimSpace(text) == "" || from == to {
+		return text
+	}
+	services := engine.services()
+	translator := services.Translator
+	if translator == nil && services.Assistant != nil {
+		translator = assistantTranslator{services.Assistant}
+	}
+	if translator == nil {
+		logger.Warn("No translation service configured", zap.String("call_id", session.CallID))
+		return text
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), translateTimeout)
+	defer cancel()
+	translated, err := translator.Translate(ctx, text, from, to)
+	translated = strings.TrimSpace(translated)
+	if err != nil || translated == "" {
+		logger.Warn("Translation failed, using original text",
+			zap.String("call_id", session.CallID),
+			zap.String("to", to),
+			zap.Error(err))
+		return text
+	}
+	if _, ok := translator.(assistantTranslator); ok && session.Cost != nil {
+		session.Cost.AddLLMText(buildTranslatePrompt(text, from, to), translated)
+	}
+
+	logger.Debug("Text translated",
+		zap.String("call_id", session.CallID),
+		zap.String("to", to),
+		zap.String("text", text),
+		zap.String("translated", translated))
+	return translated
+}
+
+// addTranslatedMessage 添加对话消息，callerText为来电者语言的文字，与content不同时存入元数据
+func (session *ScriptSession) addTranslatedMessage(role, content, callerText, stepID string) {
+	message := models.ConversationMessage{
+		Role:      role,
+		Content:   content,
+		Timestamp: time.Now(),
+		StepID:    stepID,
+	}
+	if translation := session.translating(); translation != nil && callerText != content {
+		message.Metadata = map[string]interface{}{
+			"callerLanguage": translation.caller,
+			"callerText":     callerText,
+		}
+	}
+	session.appendMessage(message)
+}
+
+// assistantTranslator 用对话服务翻译，不支持取消的服务超时后丢弃结果
+type assistantTranslator struct {
+	assistant Assistant
+}
+
+func (t assistantTranslator) Translate(ctx context.Context, text, from, to string) (string, error) {
+	type reply struct {
+		text string
+		err  error
+	}
+	replies := make(chan reply, 1)
+	go func() {
+		text, err := t.assistant.Query(buildTranslatePrompt(text, from, to))
+		replies <- reply{text, err}
+	}()
+	select {
+	case r := <-replies:
+		return r.text, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// buildTranslatePrompt 要求LLM只返回译文，便于直接播放
+func buildTranslatePrompt(text, from, to string) string {
+	var prompt strings.Builder
+	if from == "" {
+		fmt.Fprintf(&prompt, "请把下面这段电话中的话翻译成%s", to)
+	} else {
+		fmt.Fprintf(&prompt, "请把下面这段电话中的话从%s翻译成%s", from, to)
+	}
+	prompt.WriteString("，保留原意和语气，数字、姓名和专有名词照实翻译。只返回译文，不要解释，不要使用引号或Markdown。\n\n")
+	prompt.WriteString(text)
+	return prompt.String()
+}
@@ -1,12 +1,15 @@
 package sip1
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"sync"
 	"time"
 
 	"github.com/LingByte/LingSIP/internal/models"
 	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/secrets"
 	"github.com/emiago/sipgo"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
@@ -21,6 +24,12 @@ type TrunkManager struct {
 	// SIP客户端
 	userAgent *sipgo.UserAgent
 	client    *sipgo.Client
+
+	// 外呼目的号码策略
+	dialPolicy *DialPolicy
+
+	// IP认证中继来源中主机名的解析缓存
+	sources sourceResolver
 }
 
 // TrunkConnection SIP中继连接
@@ -36,6 +45,9 @@ type TrunkConnection struct {
 	SuccessCount int
 	FailedCount  int
 
+	// password 解析secret:引用后的认证密码，不写回数据库
+	password string
+
 	mutex sync.RWMutex
 }
 
@@ -48,10 +60,11 @@ func NewTrunkManager(db *gorm.DB, userAgent *sipgo.UserAgent) *TrunkManager {
 	}
 
 	return &TrunkManager{
-		db:        db,
-		trunks:    make(map[uint]*TrunkConnection),
-		userAgent: userAgent,
-		client:    client,
+		db:         db,
+		trunks:     make(map[uint]*TrunkConnection),
+		userAgent:  userAgent,
+		client:     client,
+		dialPolicy: NewDialPolicy(),
 	}
 }
 
@@ -62,6 +75,10 @@ func (tm *TrunkManager) LoadTrunks() error {
 		return fmt.Errorf("failed to load SIP trunks: %w", err)
 	}
 
+	if err := tm.dialPolicy.Load(tm.db); err != nil {
+		logger.Error("Failed to load dial rules, using built-in rules", zap.Error(err))
+	}
+
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
 
@@ -80,9 +97,15 @@ func (tm *TrunkManager) LoadTrunks() error {
 
 // addTrunk 添加SIP中继连接
 func (tm *TrunkManager) addTrunk(trunk *models.SIPTrunk) error {
+	password, err := secrets.Resolve(context.Background(), trunk.Password)
+	if err != nil {
+		return fmt.Errorf("failed to resolve password for trunk %s: %w", trunk.Name, err)
+	}
+
 	// 创建中继连接
 	conn := &TrunkConnection{
-		Trunk: trunk,
+		Trunk:    trunk,
+		password: password,
 	}
 
 	// 创建专用的SIP客户端
@@ -95,8 +118,12 @@ func (tm *TrunkManager) addTrunk(trunk *models.SIPTrunk) error {
 	// 存储连接
 	tm.trunks[trunk.ID] = conn
 
-	// 如果需要注册，启动注册
-	if trunk.Username != "" && trunk.Password != "" {
+	// IP认证中继由运营商按源地址放行，无需注册
+	if trunk.UsesIPAuth() {
+		conn.IsRegistered = true
+		conn.LastRegister = time.Now()
+	} else if trunk.Username != "" && password != "" {
+		// 如果需要注册，启动注册
 		go tm.registerTrunk(conn)
 	}
 
@@ -132,6 +159,41 @@ func (tm *TrunkManager) registerTrunk(conn *TrunkConnection) {
 		zap.String("name", trunk.Name))
 }
 
+// Password 中继的认证密码，secret:引用已解析
+func (conn *TrunkConnection) Password() string {
+	conn.mutex.RLock()
+	defer conn.mutex.RUnlock()
+	return conn.password
+}
+
+// RefreshSecrets 密钥轮换后重新解析中继密码，密码变化的中继重新注册
+func (tm *TrunkManager) RefreshSecrets() {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	for _, conn := range tm.trunks {
+		if !secrets.IsRef(conn.Trunk.Password) {
+			continue
+		}
+		password, err := secrets.Resolve(context.Background(), conn.Trunk.Password)
+		if err != nil {
+			logger.Error("Failed to resolve rotated trunk password", zap.String("name", conn.Trunk.Name), zap.Error(err))
+			continue
+		}
+		conn.mutex.Lock()
+		changed := conn.password != password
+		conn.password = password
+		conn.mutex.Unlock()
+		if !changed {
+			continue
+		}
+		logger.Info("SIP trunk password rotated", zap.String("name", conn.Trunk.Name))
+		if !conn.Trunk.UsesIPAuth() && conn.Trunk.Username != "" && password != "" {
+			go tm.registerTrunk(conn)
+		}
+	}
+}
+
 // startPeriodicRegistration 启动定期重新注册
 func (tm *TrunkManager) startPeriodicRegistration(conn *TrunkConnection) {
 	// TODO: 实现定期重新注册
@@ -155,6 +217,31 @@ func (tm *TrunkManager) GetTrunkByPhoneNumber(phoneNumber string) (*TrunkConnect
 	return nil, fmt.Errorf("no trunk found for phone number: %s", phoneNumber)
 }
 
+// MatchInboundSource 根据来源IP匹配IP认证的SIP中继
+func (tm *TrunkManager) MatchInboundSource(sourceIP string) (*TrunkConnection, bool) {
+	ip := net.ParseIP(sourceIP)
+	if ip == nil {
+		return nil, false
+	}
+
+	tm.mutex.RLock()
+	candidates := make([]*TrunkConnection, 0, len(tm.trunks))
+	for _, conn := range tm.trunks {
+		if conn.Trunk.UsesIPAuth() {
+			candidates = append(candidates, conn)
+		}
+	}
+	tm.mutex.RUnlock()
+
+	// 主机名可能需要DNS解析，不持有中继表的锁
+	for _, conn := range candidates {
+		if conn.Trunk.MatchesSource(ip, tm.sources.resolve) {
+			return conn, true
+		}
+	}
+	return nil, false
+}
+
 // GetDefaultTrunk 获取默认SIP中继
 func (tm *TrunkManager) GetDefaultTrunk() (*TrunkConnection, error) {
 	tm.mutex.RLock()
@@ -176,8 +263,61 @@ func (tm *TrunkManager) GetDefaultTrunk() (*TrunkConnection, error) {
 	return nil, fmt.Errorf("no active trunk available")
 }
 
-// MakeCall 通过SIP中继发起呼叫
+// GetTrunk 获取已注册的中继
+func (tm *TrunkManager) GetTrunk(trunkID uint) (*TrunkConnection, error) {
+	tm.mutex.RLock()
+	conn, exists := tm.trunks[trunkID]
+	tm.mutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("trunk not found: %d", trunkID)
+	}
+	if !conn.IsRegistered {
+		return nil, fmt.Errorf("trunk not registered: %s", conn.Trunk.Name)
+	}
+	return conn, nil
+}
+
+// countCall 记录一次经中继的外呼及是否接通
+func (conn *TrunkConnection) countCall(answered bool) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.CallCount++
+	if answered {
+		conn.SuccessCount++
+	} else {
+		conn.FailedCount++
+	}
+}
+
+// CheckDestination 检查组织是否允许拨打目的号码，并且号码不在免打扰名单中
+func (tm *TrunkManager) CheckDestination(groupID uint, toNumber string) error {
+	if err := tm.dialPolicy.Check(groupID, toNumber); err != nil {
+		return err
+	}
+	return tm.checkDoNotCall(groupID, toNumber)
+}
+
+// GetDialPolicy 获取外呼目的号码策略
+func (tm *TrunkManager) GetDialPolicy() *DialPolicy {
+	return tm.dialPolicy
+}
+
+// MakeCall 通过SIP中继发起呼叫（仅使用全局拨号规则）
 func (tm *TrunkManager) MakeCall(trunkID uint, fromNumber, toNumber string) error {
+	return tm.MakeCallForGroup(0, trunkID, fromNumber, toNumber)
+}
+
+// MakeCallForGroup 以组织身份通过SIP中继发起呼叫，目的号码需通过该组织的拨号规则
+func (tm *TrunkManager) MakeCallForGroup(groupID, trunkID uint, fromNumber, toNumber string) error {
+	if err := tm.CheckDestination(groupID, toNumber); err != nil {
+		logger.Warn("Outbound call blocked by dial policy",
+			zap.Uint("group_id", groupID),
+			zap.String("to", toNumber),
+			zap.Error(err))
+		return err
+	}
+
 	tm.mutex.RLock()
 	conn, exists := tm.trunks[trunkID]
 	tm.mutex.RUnlock()
@@ -0,0 +1,76 @@
+package sip1
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// sourceHostTTL 中继主机名解析结果的缓存时间
+	sourceHostTTL = 5 * time.Minute
+	// sourceHostRetry 解析失败后重试的间隔，期间沿用上次成功的结果
+	sourceHostRetry = 30 * time.Second
+	// sourceLookupTimeout 单次DNS解析的超时，解析在INVITE处理路径上
+	sourceLookupTimeout = 2 * time.Second
+)
+
+// sourceResolver 带缓存的中继来源主机名解析，零值可用
+type sourceResolver struct {
+	mutex sync.Mutex
+	hosts map[string]*resolvedSourceHost
+
+	// lookup 解析主机名，为nil时使用系统解析器
+	lookup func(ctx context.Context, host string) ([]net.IP, error)
+	now    func() time.Time
+}
+
+type resolvedSourceHost struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// resolve 返回主机名的地址，缓存过期后重新解析；解析失败时沿用旧结果并在sourceHostRetry后重试
+func (r *sourceResolver) resolve(host string) []net.IP {
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if cached, ok := r.hosts[host]; ok && now().Before(cached.expires) {
+		return cached.ips
+	}
+
+	lookup := r.lookup
+	if lookup == nil {
+		lookup = func(ctx context.Context, host string) ([]net.IP, error) {
+			return net.DefaultResolver.LookupIP(ctx, "ip", host)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sourceLookupTimeout)
+	defer cancel()
+	ips, err := lookup(ctx, host)
+
+	if r.hosts == nil {
+		r.hosts = make(map[string]*resolvedSourceHost)
+	}
+	entry := r.hosts[host]
+	if entry == nil {
+		entry = &resolvedSourceHost{}
+		r.hosts[host] = entry
+	}
+	if err != nil {
+		logger.Warn("Failed to resolve trunk source host", zap.String("host", host), zap.Int("cached", len(entry.ips)), zap.Error(err))
+		entry.expires = now().Add(sourceHostRetry)
+		return entry.ips
+	}
+	entry.ips = ips
+	entry.expires = now().Add(sourceHostTTL)
+	return ips
+}
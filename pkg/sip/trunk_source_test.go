@@ -0,0 +1,119 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+)
+
+// fakeSourceLookup 记录解析次数，按表返回地址
+type fakeSourceLookup struct {
+	calls map[string]int
+	hosts map[string][]net.IP
+	err   error
+}
+
+func (f *fakeSourceLookup) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	f.calls[host]++
+	if f.err != nil {
+		return nil, f.err
+	}
+	ips, ok := f.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+func TestSourceResolverCaches(t *testing.T) {
+	now := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	fake := &fakeSourceLookup{calls: map[string]int{}, hosts: map[string][]net.IP{"sip.carrier.example": {net.ParseIP("203.0.113.10")}}}
+	resolver := &sourceResolver{lookup: fake.lookup, now: func() time.Time { return now }}
+
+	for i := 0; i < 3; i++ {
+		if ips := resolver.resolve("sip.carrier.example"); len(ips) != 1 || !ips[0].Equal(net.ParseIP("203.0.113.10")) {
+			t.Fatalf("resolve() = %v", ips)
+		}
+	}
+	if fake.calls["sip.carrier.example"] != 1 {
+		t.Errorf("looked up %d times within the TTL, want 1", fake.calls["sip.carrier.example"])
+	}
+
+	// 过期后重新解析，运营商换了地址
+	now = now.Add(sourceHostTTL)
+	fake.hosts["sip.carrier.example"] = []net.IP{net.ParseIP("203.0.113.20")}
+	if ips := resolver.resolve("sip.carrier.example"); len(ips) != 1 || !ips[0].Equal(net.ParseIP("203.0.113.20")) {
+		t.Errorf("resolve() after TTL = %v", ips)
+	}
+
+	// DNS故障时沿用上次的结果，重试间隔内不再解析
+	now = now.Add(sourceHostTTL)
+	fake.err = errors.New("server misbehaving")
+	if ips := resolver.resolve("sip.carrier.example"); len(ips) != 1 || !ips[0].Equal(net.ParseIP("203.0.113.20")) {
+		t.Errorf("resolve() during DNS outage = %v", ips)
+	}
+	resolver.resolve("sip.carrier.example")
+	if fake.calls["sip.carrier.example"] != 3 {
+		t.Errorf("looked up %d times, want 3", fake.calls["sip.carrier.example"])
+	}
+	now = now.Add(sourceHostRetry)
+	fake.err = nil
+	resolver.resolve("sip.carrier.example")
+	if fake.calls["sip.carrier.example"] != 4 {
+		t.Errorf("not retried after %s", sourceHostRetry)
+	}
+
+	// 不存在的主机名也缓存，来源伪造的INVITE不会每次触发DNS
+	resolver.resolve("missing.example")
+	if ips := resolver.resolve("missing.example"); len(ips) != 0 || fake.calls["missing.example"] != 1 {
+		t.Errorf("unknown host = %v after %d lookups", ips, fake.calls["missing.example"])
+	}
+}
+
+func TestMatchInboundSource(t *testing.T) {
+	fake := &fakeSourceLookup{calls: map[string]int{}, hosts: map[string][]net.IP{
+		"sip.carrier.example": {net.ParseIP("203.0.113.10"), net.ParseIP("2001:db8::10")},
+	}}
+	tm := &TrunkManager{trunks: map[uint]*TrunkConnection{
+		1: {Trunk: &models.SIPTrunk{ID: 1, AuthMode: models.SIPTrunkAuthIP, SIPServer: "sip.carrier.example"}},
+		2: {Trunk: &models.SIPTrunk{ID: 2, AuthMode: models.SIPTrunkAuthIP, SIPServer: "198.51.100.100", AllowedSources: models.IPRanges{" 198.51.100.0/28 ", "192.0.2.5"}}},
+		3: {Trunk: &models.SIPTrunk{ID: 3, AuthMode: models.SIPTrunkAuthRegister, SIPServer: "192.0.2.99"}},
+	}}
+	tm.sources.lookup = fake.lookup
+
+	tests := []struct {
+		source string
+		want   uint
+	}{
+		{"203.0.113.10", 1},
+		{"2001:db8::10", 1},
+		{"198.51.100.9", 2},
+		{"192.0.2.5", 2},
+		{"198.51.100.100", 0}, // 配置了AllowedSources时不再使用SIP服务器地址
+		{"192.0.2.99", 0},     // 注册认证的中继不按来源匹配
+		{"203.0.113.11", 0},
+		{"not-an-ip", 0},
+	}
+	for _, tt := range tests {
+		conn, ok := tm.MatchInboundSource(tt.source)
+		got := uint(0)
+		if ok {
+			got = conn.Trunk.ID
+		}
+		if got != tt.want {
+			t.Errorf("MatchInboundSource(%s) = trunk %d, want %d", tt.source, got, tt.want)
+		}
+	}
+	if fake.calls["sip.carrier.example"] != 1 {
+		t.Errorf("trunk host looked up %d times, want 1", fake.calls["sip.carrier.example"])
+	}
+
+	trunk := models.SIPTrunk{SIPServer: "sip.carrier.example"}
+	if trunk.MatchesSource(net.ParseIP("203.0.113.10"), nil) {
+		t.Error("MatchesSource() without a resolver matched a hostname")
+	}
+}
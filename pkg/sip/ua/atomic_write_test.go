@@ -0,0 +1,111 @@
+package ua
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LingByte/LingSIP/internal/models"
+)
+
+func TestWriteFile(t *testing.T) {
+	for _, fileSync := range []bool{false, true} {
+		dir := t.TempDir()
+		c := &UAConfig{FileSync: fileSync}
+		path := filepath.Join(dir, "call-1.json")
+		if err := os.WriteFile(path, []byte(`{"status":"ringing"}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.writeFile(path, []byte(`{"status":"answered"}`), 0644); err != nil {
+			t.Fatalf("writeFile() FileSync=%v error = %v", fileSync, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != `{"status":"answered"}` {
+			t.Errorf("file after writeFile() = %q, %v", data, err)
+		}
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0644 {
+			t.Errorf("file mode = %v, %v, want 0644", info.Mode().Perm(), err)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("directory has %d entries after writeFile(), want only the target", len(entries))
+		}
+	}
+}
+
+func TestWriteFileFailureKeepsTarget(t *testing.T) {
+	dir := t.TempDir()
+	c := &UAConfig{}
+	if err := c.writeFile(filepath.Join(dir, "missing", "call-1.json"), []byte("{}"), 0644); err == nil {
+		t.Error("writeFile() into a missing directory expected error")
+	}
+
+	// the rename fails when the target is a non-empty directory; the temp file is removed
+	target := filepath.Join(dir, "call-1.json")
+	if err := os.MkdirAll(filepath.Join(target, "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.writeFile(target, []byte("{}"), 0644); err == nil {
+		t.Error("writeFile() over a directory expected error")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "call-1.json" || !entries[0].IsDir() {
+		t.Errorf("directory after failed writeFile() = %v", entries)
+	}
+}
+
+func TestFileStorageSkipsCorruptRecords(t *testing.T) {
+	c := DefaultUAConfig()
+	c.StorageType = StorageTypeFile
+	c.StoragePath = t.TempDir()
+	if err := c.SaveCall(&models.SipCall{CallID: "call-1", Status: models.SipCallStatusRinging}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SavePendingSession("call-2", "10.0.0.5:4000"); err != nil {
+		t.Fatal(err)
+	}
+
+	// records truncated by a crash, a corrupt index and a temp file left by an interrupted write
+	callsDir := filepath.Join(c.StoragePath, FileKindCalls)
+	files := map[string]string{
+		filepath.Join(callsDir, "call-3.json"):                            `{"callId":"call-3","sta`,
+		filepath.Join(callsDir, "call-4.json.123.tmp"):                    `{"callId":"call-4"}`,
+		filepath.Join(c.StoragePath, FileKindSessions, "call-2.json"):     `{"callId":`,
+		filepath.Join(c.StoragePath, fileIndexName):                       `{"entries":`,
+		filepath.Join(c.StoragePath, FileKindRegistrations, "alice.json"): ``,
+	}
+	if err := os.MkdirAll(filepath.Join(c.StoragePath, FileKindRegistrations), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a restarted server rebuilds the index from the valid records
+	restarted := DefaultUAConfig()
+	restarted.StorageType = StorageTypeFile
+	restarted.StoragePath = c.StoragePath
+	calls := restarted.ListCallsFromFile()
+	if len(calls) != 1 || calls[0].CallID != "call-1" {
+		t.Errorf("ListCallsFromFile() = %+v, want only call-1", calls)
+	}
+	if _, err := os.Stat(filepath.Join(callsDir, "call-4.json.123.tmp")); !os.IsNotExist(err) {
+		t.Errorf("temp file not removed: %v", err)
+	}
+	if _, ok := restarted.GetPendingSession("call-2"); ok {
+		t.Error("GetPendingSession() returned a corrupt session")
+	}
+	contacts, err := restarted.RegisteredContacts()
+	if err != nil || len(contacts) != 0 {
+		t.Errorf("RegisteredContacts() = %v, %v, want none", contacts, err)
+	}
+
+	// the corrupt record is replaced by the next write
+	if err := restarted.SaveCall(&models.SipCall{CallID: "call-3", Status: models.SipCallStatusEnded}); err != nil {
+		t.Fatal(err)
+	}
+	if call, ok := restarted.GetCall("call-3"); !ok || call.Status != models.SipCallStatusEnded {
+		t.Errorf("GetCall(call-3) after rewrite = %+v, %v", call, ok)
+	}
+}
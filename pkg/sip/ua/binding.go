@@ -0,0 +1,86 @@
+package ua
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DefaultBindingQ is the q-value of a Contact registered without a q parameter
+const DefaultBindingQ = 1.0
+
+// Binding is one registered Contact of a user; a user may register several devices
+type Binding struct {
+	Username  string    `json:"username"`
+	URI       string    `json:"uri"`     // Contact URI, identifies the binding
+	Contact   string    `json:"contact"` // host:port used for routing
+	Q         float64   `json:"q"`
+	ExpiresAt time.Time `json:"expiresAt"` // zero never expires
+	UserAgent string    `json:"userAgent,omitempty"`
+	RemoteIP  string    `json:"remoteIp,omitempty"`
+}
+
+// Expired reports whether the binding has expired by now
+func (b Binding) Expired(now time.Time) bool {
+	return !b.ExpiresAt.IsZero() && now.After(b.ExpiresAt)
+}
+
+// Binding returns the binding a registration made at now creates
+func (info *RegistrationInfo) Binding(now time.Time) Binding {
+	return Binding{
+		Username:  info.Username,
+		URI:       info.ContactStr,
+		Contact:   net.JoinHostPort(info.ContactIP, strconv.Itoa(info.ContactPort)),
+		Q:         info.Q,
+		ExpiresAt: info.ExpiresAt(now),
+		UserAgent: info.UserAgent,
+		RemoteIP:  info.RemoteIP,
+	}
+}
+
+// applyRegistration returns a user's bindings after a registration made at now: Wildcard removes
+// all, Expires 0 removes the binding with the same Contact URI, otherwise it is added or refreshed
+func applyRegistration(bindings []Binding, info *RegistrationInfo, now time.Time) []Binding {
+	if info.Wildcard {
+		return nil
+	}
+	result := make([]Binding, 0, len(bindings)+1)
+	for _, binding := range bindings {
+		if binding.URI != info.ContactStr && !binding.Expired(now) {
+			result = append(result, binding)
+		}
+	}
+	if info.Expires > 0 && info.ContactStr != "" {
+		result = append(result, info.Binding(now))
+	}
+	sortBindings(result)
+	return result
+}
+
+// unexpiredBindings returns the bindings that have not expired by now, keeping their order
+func unexpiredBindings(bindings []Binding, now time.Time) []Binding {
+	var live []Binding
+	for _, binding := range bindings {
+		if !binding.Expired(now) {
+			live = append(live, binding)
+		}
+	}
+	return live
+}
+
+// sortBindings orders bindings by q-value, highest first; equal q-values keep their order
+func sortBindings(bindings []Binding) {
+	sort.SliceStable(bindings, func(i, j int) bool { return bindings[i].Q > bindings[j].Q })
+}
+
+// preferredContacts returns username -> host:port of each user's first binding
+func preferredContacts(users map[string][]Binding) map[string]string {
+	contacts := make(map[string]string, len(users))
+	for username, bindings := range users {
+		if len(bindings) > 0 {
+			contacts[username] = bindings[0].Contact
+		}
+	}
+	return contacts
+}
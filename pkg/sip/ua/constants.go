@@ -1,6 +1,48 @@
 package ua
 
+import "time"
+
 var (
 	DEFAULT_REALM_NAME = "lingecho-realm"
 	DEFAULT_USER_AGENT = "LingEcho SIP Server"
 )
+
+// DefaultMaxBodySize is the request body limit used when MaxBodySize is not set
+const DefaultMaxBodySize = 64 << 10
+
+// DefaultTraceDir is where SIP trace files and pcaps are written when TraceDir is not set
+const DefaultTraceDir = "./sip_traces"
+
+// DefaultPromptDir is where uploaded prompts are stored when PromptDir is not set
+const DefaultPromptDir = "./prompts"
+
+// DefaultDrainTimeout is how long shutdown waits for active calls when DrainTimeout is not set
+const DefaultDrainTimeout = 30 * time.Second
+
+// DefaultMinSE is the smallest accepted session interval when MinSE is not set, the RFC 4028 minimum
+const DefaultMinSE = 90 * time.Second
+
+// DefaultOverloadRetryAfter is the Retry-After of 503 answers to INVITEs rejected over the CPU or RTP budget
+const DefaultOverloadRetryAfter = 30 * time.Second
+
+// DefaultProbeFailures is how many OPTIONS probes in a row a contact may miss before it is unreachable
+const DefaultProbeFailures = 3
+
+// DefaultMessageFrom is the From user of outgoing MESSAGE requests when MessageFrom is not set
+const DefaultMessageFrom = "lingsip"
+
+// DefaultShutdownMessage is played to calls still active when the drain timeout expires
+const DefaultShutdownMessage = "系统即将维护，本次通话将结束，感谢您的来电，再见。"
+
+// DefaultRedisKeyPrefix prefixes redis storage keys when RedisKeyPrefix is not set
+const DefaultRedisKeyPrefix = "lingsip:"
+
+// DefaultPendingSessionTTL is how long a pending session waits for its ACK in redis storage
+const DefaultPendingSessionTTL = 2 * time.Minute
+
+// LLM transcript redaction modes: RedactNumbers masks phone numbers in message text and replies,
+// RedactContent drops the text and keeps roles, tool definitions and tool calls
+const (
+	RedactNumbers = "numbers"
+	RedactContent = "content"
+)
@@ -0,0 +1,329 @@
+package ua
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"gorm.io/gorm"
+)
+
+// databaseStorage keeps SIP state in the database; while it is unreachable writes go to
+// memory and the journal (see db_fallback.go)
+type databaseStorage struct {
+	c *UAConfig
+}
+
+func (s databaseStorage) Backend() StorageType { return StorageTypeDatabase }
+
+func (s databaseStorage) SaveRegistration(info *RegistrationInfo) error {
+	c := s.c
+	return c.degradedWrite(journalEntry{Op: journalRegistration, Registration: info},
+		func() error { return c.writeRegistration(info, time.Now()) },
+		func() { c.saveRegistrationToMemory(info, time.Now()) })
+}
+
+func (s databaseStorage) ExpireRegistrations(now time.Time) ([]Binding, error) {
+	c := s.c
+	expired := c.expireRegistrationsInMemory(now)
+	if c.DatabaseDegraded() {
+		return expired, nil
+	}
+	registrations, err := models.DeleteExpiredSipRegistrations(c.Db, now)
+	if err != nil {
+		return expired, fmt.Errorf("failed to expire registrations: %w", err)
+	}
+	for _, registration := range registrations {
+		expired = append(expired, bindingFromModel(registration))
+	}
+	// users whose last binding expired
+	if _, err := models.ExpireSipUserRegistrations(c.Db, now); err != nil {
+		return expired, fmt.Errorf("failed to expire registered users: %w", err)
+	}
+	return expired, nil
+}
+
+func (s databaseStorage) Bindings(username string) ([]Binding, error) {
+	c := s.c
+	now := time.Now()
+	// Registrations made while the database is down are only in memory
+	if c.DatabaseDegraded() {
+		return c.getBindingsFromMemory(username, now), nil
+	}
+	registrations, err := models.GetSipRegistrations(c.Db, username, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load registrations: %w", err)
+	}
+	bindings := make([]Binding, 0, len(registrations))
+	for _, registration := range registrations {
+		bindings = append(bindings, bindingFromModel(registration))
+	}
+	return bindings, nil
+}
+
+func (s databaseStorage) RegisteredBindings() (map[string][]Binding, error) {
+	c := s.c
+	now := time.Now()
+	if c.DatabaseDegraded() {
+		return c.listBindingsFromMemory(now), nil
+	}
+	registrations, err := models.GetActiveSipRegistrations(c.Db, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load registrations: %w", err)
+	}
+	users := make(map[string][]Binding)
+	for _, registration := range registrations {
+		users[registration.Username] = append(users[registration.Username], bindingFromModel(registration))
+	}
+	return users, nil
+}
+
+// bindingFromModel converts a registration row to a Binding
+func bindingFromModel(registration models.SipRegistration) Binding {
+	return Binding{
+		Username:  registration.Username,
+		URI:       registration.Contact,
+		Contact:   net.JoinHostPort(registration.ContactIP, strconv.Itoa(registration.ContactPort)),
+		Q:         registration.Q,
+		ExpiresAt: registration.ExpiresAt,
+		UserAgent: registration.UserAgent,
+		RemoteIP:  registration.RemoteIP,
+	}
+}
+
+// writeRegistration saves a binding registered at the given time and updates the user's row;
+// the user is marked unregistered once its last binding is removed
+func (c *UAConfig) writeRegistration(info *RegistrationInfo, now time.Time) error {
+	sipUser, err := models.GetSipUserByUsername(c.Db, info.Username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("SIP user %s not found", info.Username)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load SIP user: %w", err)
+	}
+	if !sipUser.Enabled {
+		return fmt.Errorf("SIP user %s is disabled", info.Username)
+	}
+
+	if info.Wildcard || info.Expires <= 0 {
+		if info.Wildcard {
+			err = models.DeleteSipRegistrations(c.Db, info.Username)
+		} else {
+			err = models.DeleteSipRegistration(c.Db, info.Username, info.ContactStr)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to remove registration: %w", err)
+		}
+		remaining, err := models.CountSipRegistrations(c.Db, info.Username, now)
+		if err != nil {
+			return fmt.Errorf("failed to count registrations: %w", err)
+		}
+		if remaining > 0 {
+			return nil
+		}
+		sipUser.Status = models.SipUserStatusUnregistered
+		sipUser.LastUnregister = &now
+		sipUser.ExpiresAt = nil
+	} else {
+		binding := info.Binding(now)
+		registration := &models.SipRegistration{
+			Username:    info.Username,
+			Contact:     info.ContactStr,
+			ContactIP:   info.ContactIP,
+			ContactPort: info.ContactPort,
+			Q:           binding.Q,
+			ExpiresAt:   binding.ExpiresAt,
+			UserAgent:   info.UserAgent,
+			RemoteIP:    info.RemoteIP,
+		}
+		if err := models.SaveSipRegistration(c.Db, registration); err != nil {
+			return fmt.Errorf("failed to save registration: %w", err)
+		}
+
+		// Update user information with the latest binding; the user expires with its last binding
+		if sipUser.Status != models.SipUserStatusRegistered || sipUser.ExpiresAt == nil || sipUser.ExpiresAt.Before(binding.ExpiresAt) {
+			sipUser.ExpiresAt = &binding.ExpiresAt
+		}
+		sipUser.Contact = info.ContactStr
+		sipUser.ContactIP = info.ContactIP
+		sipUser.ContactPort = info.ContactPort
+		sipUser.Expires = info.Expires
+		sipUser.Status = models.SipUserStatusRegistered
+		sipUser.LastRegister = &now
+		sipUser.RegisterCount++
+		sipUser.UserAgent = info.UserAgent
+		sipUser.RemoteIP = info.RemoteIP
+	}
+
+	// Save to database
+	if err := models.UpdateSipUser(c.Db, sipUser); err != nil {
+		return fmt.Errorf("failed to update SIP user in database: %w", err)
+	}
+
+	return nil
+}
+
+func (s databaseStorage) SavePendingSession(callID, clientRTPAddr string) error {
+	c := s.c
+	// Pending sessions only live until the ACK, keep them in memory while the database is down
+	if c.DatabaseDegraded() {
+		return c.savePendingSessionToMemory(callID, clientRTPAddr)
+	}
+	// Save to database
+	session := &models.SipSession{
+		CallID:        callID,
+		Status:        models.SipSessionStatusPending,
+		RemoteRTPAddr: clientRTPAddr,
+		CreatedTime:   time.Now(),
+	}
+	if err := models.CreateSipSession(c.Db, session); err != nil {
+		if c.dbDown(err) {
+			return c.savePendingSessionToMemory(callID, clientRTPAddr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s databaseStorage) GetPendingSession(callID string) (string, bool) {
+	c := s.c
+	// Sessions saved while the database was down are only in memory
+	if addr, exists := c.getPendingSessionFromMemory(callID); exists {
+		return addr, true
+	}
+	if c.DatabaseDegraded() {
+		return "", false
+	}
+	// Get from database
+	session, err := models.GetSipSessionByCallID(c.Db, callID)
+	if err != nil {
+		return "", false
+	}
+	if session.Status != models.SipSessionStatusPending {
+		return "", false
+	}
+	return session.RemoteRTPAddr, true
+}
+
+func (s databaseStorage) RemovePendingSession(callID string) error {
+	c := s.c
+	c.removePendingSessionFromMemory(callID)
+	if c.DatabaseDegraded() {
+		return nil
+	}
+	// Delete from database
+	if err := models.DeleteSipSessionByCallID(c.Db, callID); err != nil && !c.dbDown(err) {
+		return err
+	}
+	return nil
+}
+
+func (s databaseStorage) ExpirePendingSessions(before time.Time) ([]string, error) {
+	c := s.c
+	// Sessions saved while the database was down are only in memory
+	expired := c.expirePendingSessionsInMemory(before)
+	if c.DatabaseDegraded() {
+		return expired, nil
+	}
+	callIDs, err := models.DeletePendingSipSessionsBefore(c.Db, before)
+	if err != nil {
+		if c.dbDown(err) {
+			return expired, nil
+		}
+		return expired, fmt.Errorf("failed to expire pending sessions: %w", err)
+	}
+	return append(expired, callIDs...), nil
+}
+
+func (s databaseStorage) SaveCall(sipCall *models.SipCall) error {
+	c := s.c
+	err := c.degradedWrite(journalEntry{Op: journalCall, Call: sipCall},
+		func() error { return models.CreateSipCall(c.Db, sipCall) },
+		func() { c.saveCallToMemory(sipCall) })
+	if err != nil {
+		return fmt.Errorf("failed to create SIP call in database: %w", err)
+	}
+	return nil
+}
+
+func (s databaseStorage) GetCall(callID string) (*models.SipCall, bool) {
+	c := s.c
+	call, err := models.GetSipCallByCallID(c.Db, callID)
+	if err != nil {
+		// Calls created while the database was down are only in memory
+		return c.getCallFromMemory(callID)
+	}
+	return call, true
+}
+
+func (s databaseStorage) UpdateCallStatus(callID string, status models.SipCallStatus, answerTime *time.Time) error {
+	c := s.c
+	return c.degradedWrite(journalEntry{Op: journalCallStatus, CallID: callID, Status: status, AnswerTime: answerTime},
+		func() error { return c.writeCallStatus(callID, status, answerTime) },
+		func() { c.updateCallStatusInMemory(callID, status, answerTime) })
+}
+
+func (c *UAConfig) writeCallStatus(callID string, status models.SipCallStatus, answerTime *time.Time) error {
+	var sipCall models.SipCall
+	if err := c.Db.Where("call_id = ?", callID).First(&sipCall).Error; err != nil {
+		return fmt.Errorf("failed to find call record: %w", err)
+	}
+
+	sipCall.Status = status
+	if answerTime != nil {
+		sipCall.AnswerTime = answerTime
+	}
+	if status == models.SipCallStatusAnswered {
+		sipCall.Disposition = models.SipCallDispositionAnswered
+	}
+	return c.Db.Save(&sipCall).Error
+}
+
+func (s databaseStorage) SaveRecordURL(callID, recordURL string) error {
+	c := s.c
+	return c.degradedWrite(journalEntry{Op: journalRecordURL, CallID: callID, RecordURL: recordURL},
+		func() error { return c.writeRecordURL(callID, recordURL) },
+		func() { c.saveRecordURLToMemory(callID, recordURL) })
+}
+
+func (c *UAConfig) writeRecordURL(callID, recordURL string) error {
+	var sipCall models.SipCall
+	if err := c.Db.Where("call_id = ?", callID).First(&sipCall).Error; err != nil {
+		return fmt.Errorf("failed to find call record: %w", err)
+	}
+
+	sipCall.RecordURL = recordURL
+	return c.Db.Save(&sipCall).Error
+}
+
+func (s databaseStorage) SaveTranscription(callID string, transcription *models.CallTranscription) error {
+	result := s.c.Db.Model(&models.SipCall{}).Where("call_id = ?", callID).Updates(map[string]interface{}{
+		"transcription_status": transcription.Status,
+		"transcription":        transcription.Text,
+		"transcription_error":  transcription.Error,
+		"transcript_segments":  transcription.Segments,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("call %s not found", callID)
+	}
+	return nil
+}
+
+func (s databaseStorage) UpdateCallMetadata(callID, key string, value interface{}) error {
+	c := s.c
+	sipCall, err := models.GetSipCallByCallID(c.Db, callID)
+	if err != nil {
+		return fmt.Errorf("failed to find call record: %w", err)
+	}
+	metadata, err := mergeMetadata(sipCall.Metadata, key, value)
+	if err != nil {
+		return err
+	}
+	return c.Db.Model(sipCall).Update("metadata", metadata).Error
+}
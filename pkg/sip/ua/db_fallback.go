@@ -0,0 +1,279 @@
+package ua
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// DefaultDBProbeInterval is how often an unreachable database is probed when DBProbeInterval is not set
+const DefaultDBProbeInterval = 10 * time.Second
+
+const (
+	dbJournalFile = "db_journal.jsonl"
+	dbPingTimeout = 2 * time.Second
+)
+
+type journalOp string
+
+const (
+	journalRegistration journalOp = "registration"
+	journalCall         journalOp = "call"
+	journalCallStatus   journalOp = "call_status"
+	journalRecordURL    journalOp = "record_url"
+)
+
+// journalEntry is one database write deferred while the database was unreachable
+type journalEntry struct {
+	Op           journalOp            `json:"op"`
+	At           time.Time            `json:"at"`
+	Registration *RegistrationInfo    `json:"registration,omitempty"`
+	Call         *models.SipCall      `json:"call,omitempty"`
+	CallID       string               `json:"callId,omitempty"`
+	Status       models.SipCallStatus `json:"status,omitempty"`
+	AnswerTime   *time.Time           `json:"answerTime,omitempty"`
+	RecordURL    string               `json:"recordUrl,omitempty"`
+}
+
+// DatabaseDegraded reports whether database writes are currently journaled instead of applied
+func (c *UAConfig) DatabaseDegraded() bool {
+	c.dbJournalMutex.Lock()
+	defer c.dbJournalMutex.Unlock()
+	return c.dbDegraded
+}
+
+// ResumeDBJournal replays a journal left by an earlier run, entering degraded mode when the
+// database is still unreachable
+func (c *UAConfig) ResumeDBJournal() {
+	if c.Db == nil {
+		return
+	}
+	if _, err := os.Stat(c.dbJournalPath()); err != nil {
+		return
+	}
+	if err := c.pingDatabase(); err != nil {
+		c.enterDegraded(err)
+		return
+	}
+	c.dbJournalMutex.Lock()
+	defer c.dbJournalMutex.Unlock()
+	if err := c.replayJournal(); err != nil {
+		logrus.WithError(err).Error("Failed to replay database journal")
+	}
+}
+
+// degradedWrite applies write to the database. While the database is unreachable the entry is
+// journaled and fallback keeps the record in memory; other database errors are returned.
+func (c *UAConfig) degradedWrite(entry journalEntry, write func() error, fallback func()) error {
+	if !c.DatabaseDegraded() {
+		err := write()
+		if err == nil || !c.dbDown(err) {
+			return err
+		}
+	}
+	entry.At = time.Now()
+	journaled, err := c.appendJournal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to journal database write: %w", err)
+	}
+	if !journaled {
+		// recovered in the meantime
+		return write()
+	}
+	fallback()
+	return nil
+}
+
+// dbDown reports whether err came from an unreachable database, entering degraded mode if so
+func (c *UAConfig) dbDown(err error) bool {
+	if err == nil || c.Db == nil || errors.Is(err, gorm.ErrRecordNotFound) {
+		return false
+	}
+	pingErr := c.pingDatabase()
+	if pingErr == nil {
+		return false
+	}
+	c.enterDegraded(pingErr)
+	return true
+}
+
+func (c *UAConfig) pingDatabase() error {
+	sqlDB, err := c.Db.DB()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+	defer cancel()
+	return sqlDB.PingContext(ctx)
+}
+
+func (c *UAConfig) enterDegraded(err error) {
+	c.dbJournalMutex.Lock()
+	if c.dbDegraded {
+		c.dbJournalMutex.Unlock()
+		return
+	}
+	c.dbDegraded = true
+	c.dbJournalMutex.Unlock()
+
+	logrus.WithError(err).Warn("Database unavailable, falling back to memory storage with journal")
+	go c.watchDatabase()
+}
+
+// watchDatabase probes the database until it answers, then replays the journal and leaves degraded mode
+func (c *UAConfig) watchDatabase() {
+	interval := c.DBProbeInterval
+	if interval <= 0 {
+		interval = DefaultDBProbeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := c.pingDatabase(); err != nil {
+			continue
+		}
+		// writers wait on the mutex, so nothing is journaled after the replay
+		c.dbJournalMutex.Lock()
+		err := c.replayJournal()
+		if err == nil {
+			c.dbDegraded = false
+		}
+		c.dbJournalMutex.Unlock()
+		if err != nil {
+			logrus.WithError(err).Warn("Database journal replay incomplete, staying degraded")
+			continue
+		}
+		logrus.Info("Database recovered, leaving degraded mode")
+		return
+	}
+}
+
+func (c *UAConfig) dbJournalPath() string {
+	return c.JournalPath(dbJournalFile)
+}
+
+// JournalPath returns the path of the named journal file in JournalDir, or StoragePath when JournalDir is empty
+func (c *UAConfig) JournalPath(name string) string {
+	if c.JournalDir != "" {
+		return filepath.Join(c.JournalDir, name)
+	}
+	return filepath.Join(c.StoragePath, name)
+}
+
+// appendJournal appends entry to the journal, returns false when no longer degraded
+func (c *UAConfig) appendJournal(entry journalEntry) (bool, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return false, err
+	}
+
+	c.dbJournalMutex.Lock()
+	defer c.dbJournalMutex.Unlock()
+	if !c.dbDegraded {
+		return false, nil
+	}
+	path := c.dbJournalPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return false, err
+	}
+	// the journal is the only copy until the database is back
+	return true, file.Sync()
+}
+
+// replayJournal applies journaled writes in order, keeping the unapplied remainder when the
+// database goes away again. Caller holds dbJournalMutex.
+func (c *UAConfig) replayJournal() error {
+	data, err := os.ReadFile(c.dbJournalPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read database journal: %w", err)
+	}
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lines = append(lines, append([]byte(nil), line...))
+		}
+	}
+
+	applied := 0
+	for i, line := range lines {
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			// a crash can leave the last line incomplete
+			logrus.WithError(err).WithField("line", i+1).Warn("Skipping corrupt database journal entry")
+			applied++
+			continue
+		}
+		if err := c.applyJournalEntry(&entry); err != nil {
+			if pingErr := c.pingDatabase(); pingErr != nil {
+				remaining := bytes.Join(lines[i:], []byte("\n"))
+				if err := c.writeFile(c.dbJournalPath(), append(remaining, '\n'), 0644); err != nil {
+					return fmt.Errorf("failed to rewrite database journal: %w", err)
+				}
+				return fmt.Errorf("database unavailable after %d journal entries: %w", applied, pingErr)
+			}
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"op":      entry.Op,
+				"call_id": entry.CallID,
+			}).Warn("Dropping database journal entry that cannot be applied")
+		}
+		applied++
+	}
+
+	if err := os.Remove(c.dbJournalPath()); err != nil {
+		return fmt.Errorf("failed to remove database journal: %w", err)
+	}
+	logrus.WithField("entries", applied).Info("Replayed database journal")
+	return nil
+}
+
+func (c *UAConfig) applyJournalEntry(entry *journalEntry) error {
+	switch entry.Op {
+	case journalRegistration:
+		if entry.Registration == nil {
+			return fmt.Errorf("registration entry without registration")
+		}
+		return c.writeRegistration(entry.Registration, entry.At)
+	case journalCall:
+		if entry.Call == nil {
+			return fmt.Errorf("call entry without call")
+		}
+		if err := models.CreateSipCall(c.Db, entry.Call); err != nil {
+			return err
+		}
+		c.memoryCallsMutex.Lock()
+		delete(c.MemoryCalls, entry.Call.CallID)
+		c.memoryCallsMutex.Unlock()
+		return nil
+	case journalCallStatus:
+		return c.writeCallStatus(entry.CallID, entry.Status, entry.AnswerTime)
+	case journalRecordURL:
+		return c.writeRecordURL(entry.CallID, entry.RecordURL)
+	default:
+		return fmt.Errorf("unknown journal op %q", entry.Op)
+	}
+}
@@ -0,0 +1,248 @@
+package ua
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestAppendJournalCreatesJournalDir(t *testing.T) {
+	dir := t.TempDir()
+	c := &UAConfig{
+		StoragePath: filepath.Join(dir, "storage"),
+		JournalDir:  filepath.Join(dir, "journal", "nested"),
+		dbDegraded:  true,
+	}
+	journaled, err := c.appendJournal(journalEntry{Op: journalCallStatus, CallID: "call-1"})
+	if err != nil || !journaled {
+		t.Fatalf("appendJournal() = %v, %v, want journaled", journaled, err)
+	}
+	if _, err := os.Stat(filepath.Join(c.JournalDir, dbJournalFile)); err != nil {
+		t.Errorf("journal not written to JournalDir: %v", err)
+	}
+	if _, err := os.Stat(c.StoragePath); !os.IsNotExist(err) {
+		t.Errorf("StoragePath created although JournalDir is set: %v", err)
+	}
+}
+
+// flakyConnector opens SQLite connections that fail while down is set, like a database server going away
+type flakyConnector struct {
+	dsn  string
+	base driver.Driver
+	down *atomic.Bool
+}
+
+func (c *flakyConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if c.down.Load() {
+		return nil, errors.New("connection refused")
+	}
+	conn, err := c.base.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyConn{Conn: conn, down: c.down}, nil
+}
+
+func (c *flakyConnector) Driver() driver.Driver { return c.base }
+
+type flakyConn struct {
+	driver.Conn
+	down *atomic.Bool
+}
+
+func (c *flakyConn) Prepare(query string) (driver.Stmt, error) {
+	if c.down.Load() {
+		return nil, driver.ErrBadConn
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *flakyConn) Begin() (driver.Tx, error) {
+	if c.down.Load() {
+		return nil, driver.ErrBadConn
+	}
+	return c.Conn.Begin()
+}
+
+func (c *flakyConn) Ping(ctx context.Context) error {
+	if c.down.Load() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// newFlakyDB returns a database storage config whose database is unreachable while the returned flag is set
+func newFlakyDB(t *testing.T) (*UAConfig, *atomic.Bool) {
+	t.Helper()
+	dir := t.TempDir()
+	probe, err := sql.Open(sqlite.DriverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := probe.Driver()
+	probe.Close()
+
+	down := &atomic.Bool{}
+	sqlDB := sql.OpenDB(&flakyConnector{dsn: filepath.Join(dir, "lingsip.db"), base: base, down: down})
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(&sqlite.Dialector{Conn: sqlDB}, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.SipCall{}, &models.SipUser{}, &models.SipRegistration{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.SipUser{SchemeName: "default", Username: "alice", Enabled: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	c := DefaultUAConfig()
+	c.StorageType = StorageTypeDatabase
+	c.StoragePath = filepath.Join(dir, "storage")
+	c.Db = db
+	c.DBProbeInterval = 10 * time.Millisecond
+	return c, down
+}
+
+// waitRecovered waits for the database probe to replay the journal and leave degraded mode
+func waitRecovered(t *testing.T, c *UAConfig) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.DatabaseDegraded() {
+		if time.Now().After(deadline) {
+			t.Fatal("still degraded after the database came back")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func journalLines(t *testing.T, c *UAConfig) int {
+	t.Helper()
+	data, err := os.ReadFile(c.dbJournalPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestDegradedWritesReplayOnRecovery(t *testing.T) {
+	c, down := newFlakyDB(t)
+	if err := c.SaveCall(&models.SipCall{CallID: "call-1", Status: models.SipCallStatusRinging}); err != nil {
+		t.Fatal(err)
+	}
+
+	down.Store(true)
+	if err := c.SaveCall(&models.SipCall{CallID: "call-2", Status: models.SipCallStatusRinging}); err != nil {
+		t.Fatalf("SaveCall() while the database is down error = %v", err)
+	}
+	if !c.DatabaseDegraded() {
+		t.Fatal("not degraded after a failed write")
+	}
+	answered := time.Now().Truncate(time.Second)
+	writes := []error{
+		c.UpdateCallStatus("call-1", models.SipCallStatusAnswered, &answered),
+		c.SaveRecordURL("call-2", "/api/uploads/recordings/call-2.wav"),
+		c.SaveRegistration(&RegistrationInfo{Username: "alice", ContactStr: "sip:alice@10.0.0.5", ContactIP: "10.0.0.5", ContactPort: 5060, Expires: 3600}),
+	}
+	for i, err := range writes {
+		if err != nil {
+			t.Errorf("write %d while degraded error = %v", i, err)
+		}
+	}
+	if n := journalLines(t, c); n != 4 {
+		t.Errorf("journal has %d entries, want 4", n)
+	}
+	// calls created during the outage are served from memory
+	if call, ok := c.GetCall("call-2"); !ok || call.RecordURL != "/api/uploads/recordings/call-2.wav" {
+		t.Errorf("GetCall(call-2) while degraded = %+v, %v", call, ok)
+	}
+
+	down.Store(false)
+	waitRecovered(t, c)
+	if _, err := os.Stat(c.dbJournalPath()); !os.IsNotExist(err) {
+		t.Errorf("journal left after replay: %v", err)
+	}
+	call1, err := models.GetSipCallByCallID(c.Db, "call-1")
+	if err != nil || call1.Status != models.SipCallStatusAnswered || call1.AnswerTime == nil {
+		t.Errorf("call-1 after replay = %+v, %v", call1, err)
+	}
+	call2, err := models.GetSipCallByCallID(c.Db, "call-2")
+	if err != nil || call2.RecordURL != "/api/uploads/recordings/call-2.wav" {
+		t.Errorf("call-2 after replay = %+v, %v", call2, err)
+	}
+	var registrations int64
+	c.Db.Model(&models.SipRegistration{}).Where("username = ?", "alice").Count(&registrations)
+	if registrations != 1 {
+		t.Errorf("alice has %d registrations after replay, want 1", registrations)
+	}
+	c.memoryCallsMutex.RLock()
+	_, inMemory := c.MemoryCalls["call-2"]
+	c.memoryCallsMutex.RUnlock()
+	if inMemory {
+		t.Error("replayed call still kept in memory")
+	}
+}
+
+func TestResumeDBJournal(t *testing.T) {
+	c, down := newFlakyDB(t)
+	var journal bytes.Buffer
+	for _, entry := range []journalEntry{
+		{Op: journalCall, Call: &models.SipCall{CallID: "call-1", Status: models.SipCallStatusEnded}},
+		// cannot be applied and is dropped rather than blocking the replay
+		{Op: journalCallStatus, CallID: "unknown", Status: models.SipCallStatusEnded},
+		{Op: journalRecordURL, CallID: "call-1", RecordURL: "/api/uploads/recordings/call-1.wav"},
+	} {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		journal.Write(append(data, '\n'))
+	}
+	// a crash while appending leaves the last line incomplete
+	journal.WriteString(`{"op":"call","call":{"callId":`)
+	if err := os.MkdirAll(filepath.Dir(c.dbJournalPath()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.dbJournalPath(), journal.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// still down at startup: degraded until the probe succeeds, journal kept
+	down.Store(true)
+	c.ResumeDBJournal()
+	if !c.DatabaseDegraded() || journalLines(t, c) != 3 {
+		t.Fatalf("after resume while down degraded = %v, journal lines = %d", c.DatabaseDegraded(), journalLines(t, c))
+	}
+
+	down.Store(false)
+	waitRecovered(t, c)
+	call, err := models.GetSipCallByCallID(c.Db, "call-1")
+	if err != nil || call.RecordURL != "/api/uploads/recordings/call-1.wav" {
+		t.Errorf("call-1 after replay = %+v, %v", call, err)
+	}
+	if _, err := os.Stat(c.dbJournalPath()); !os.IsNotExist(err) {
+		t.Errorf("journal left after replay: %v", err)
+	}
+
+	// nothing to replay
+	c.ResumeDBJournal()
+	if c.DatabaseDegraded() {
+		t.Error("degraded after resuming without a journal")
+	}
+}
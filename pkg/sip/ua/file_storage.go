@@ -0,0 +1,633 @@
+package ua
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// File storage record kinds, each stored under StoragePath/<kind>/<id>.json
+const (
+	FileKindCalls         = "calls"
+	FileKindSessions      = "sessions"
+	FileKindRegistrations = "registrations"
+)
+
+var fileKinds = []string{FileKindCalls, FileKindSessions, FileKindRegistrations}
+
+const fileIndexName = "index.json"
+
+// FileIndexEntry describes one JSON record in file storage
+type FileIndexEntry struct {
+	Kind      string    `json:"kind"`
+	ID        string    `json:"id"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type fileIndex struct {
+	Entries map[string]map[string]FileIndexEntry `json:"entries"` // kind -> id -> entry
+}
+
+// fileRecordPath returns the path of a record in file storage
+func (c *UAConfig) fileRecordPath(kind, id string) string {
+	return filepath.Join(c.StoragePath, kind, fmt.Sprintf("%s.json", id))
+}
+
+// loadFileIndex loads the index on first use, rebuilding it from the storage
+// directories when the index file is missing or unreadable. Caller holds fileIndexMutex.
+func (c *UAConfig) loadFileIndex() *fileIndex {
+	if c.fileIndex != nil {
+		return c.fileIndex
+	}
+
+	index := &fileIndex{}
+	data, err := os.ReadFile(filepath.Join(c.StoragePath, fileIndexName))
+	if err == nil {
+		if err := json.Unmarshal(data, index); err != nil {
+			logrus.WithError(err).Warn("File storage index is corrupt, rebuilding")
+			index = &fileIndex{}
+		}
+	}
+	if index.Entries == nil {
+		index = c.scanFileStorage()
+	}
+	c.fileIndex = index
+	return index
+}
+
+// scanFileStorage builds an index from the records on disk, removing temp files left by
+// interrupted writes and skipping records that are not valid JSON
+func (c *UAConfig) scanFileStorage() *fileIndex {
+	index := &fileIndex{Entries: make(map[string]map[string]FileIndexEntry)}
+	for _, kind := range fileKinds {
+		index.Entries[kind] = make(map[string]FileIndexEntry)
+		files, err := os.ReadDir(filepath.Join(c.StoragePath, kind))
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			path := filepath.Join(c.StoragePath, kind, file.Name())
+			if !file.IsDir() && strings.HasSuffix(file.Name(), ".tmp") {
+				os.Remove(path)
+				continue
+			}
+			if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+				continue
+			}
+			info, err := file.Info()
+			if err != nil {
+				continue
+			}
+			if data, err := os.ReadFile(path); err != nil || !json.Valid(data) {
+				logrus.WithField("file", path).Warn("Skipping corrupt storage file")
+				continue
+			}
+			id := strings.TrimSuffix(file.Name(), ".json")
+			index.Entries[kind][id] = FileIndexEntry{Kind: kind, ID: id, Size: info.Size(), UpdatedAt: info.ModTime()}
+		}
+	}
+	return index
+}
+
+// saveFileIndex persists the index. Caller holds fileIndexMutex.
+func (c *UAConfig) saveFileIndex(index *fileIndex) error {
+	if err := os.MkdirAll(c.StoragePath, 0755); err != nil {
+		return fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal file index: %w", err)
+	}
+	if err := c.writeFile(filepath.Join(c.StoragePath, fileIndexName), data, 0644); err != nil {
+		return fmt.Errorf("failed to write file index: %w", err)
+	}
+	return nil
+}
+
+// indexFileRecord records a written file in the index
+func (c *UAConfig) indexFileRecord(kind, id string, size int64) {
+	c.fileIndexMutex.Lock()
+	defer c.fileIndexMutex.Unlock()
+
+	index := c.loadFileIndex()
+	if index.Entries[kind] == nil {
+		index.Entries[kind] = make(map[string]FileIndexEntry)
+	}
+	index.Entries[kind][id] = FileIndexEntry{Kind: kind, ID: id, Size: size, UpdatedAt: time.Now()}
+	if err := c.saveFileIndex(index); err != nil {
+		logrus.WithError(err).WithField("id", id).Warn("Failed to update file storage index")
+	}
+}
+
+// unindexFileRecord removes a deleted file from the index
+func (c *UAConfig) unindexFileRecord(kind, id string) {
+	c.fileIndexMutex.Lock()
+	defer c.fileIndexMutex.Unlock()
+
+	index := c.loadFileIndex()
+	if _, exists := index.Entries[kind][id]; !exists {
+		return
+	}
+	delete(index.Entries[kind], id)
+	if err := c.saveFileIndex(index); err != nil {
+		logrus.WithError(err).WithField("id", id).Warn("Failed to update file storage index")
+	}
+}
+
+// ListFileRecords returns the indexed records of one kind, newest first
+func (c *UAConfig) ListFileRecords(kind string) []FileIndexEntry {
+	c.fileIndexMutex.Lock()
+	defer c.fileIndexMutex.Unlock()
+
+	index := c.loadFileIndex()
+	entries := make([]FileIndexEntry, 0, len(index.Entries[kind]))
+	for _, entry := range index.Entries[kind] {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].UpdatedAt.After(entries[j].UpdatedAt) })
+	return entries
+}
+
+// ListCallsFromFile returns call records from file storage, newest first
+func (c *UAConfig) ListCallsFromFile() []*models.SipCall {
+	defer c.observeStorage(StorageTypeFile, "list_calls", "", time.Now(), nil)
+
+	var calls []*models.SipCall
+	for _, entry := range c.ListFileRecords(FileKindCalls) {
+		if call, exists := c.getCallFromFile(entry.ID); exists {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (c *UAConfig) getCallFromFile(callID string) (*models.SipCall, bool) {
+	data, err := os.ReadFile(c.fileRecordPath(FileKindCalls, callID))
+	if err != nil {
+		return nil, false
+	}
+
+	var callData map[string]interface{}
+	if err := json.Unmarshal(data, &callData); err != nil {
+		logrus.WithError(err).WithField("call_id", callID).Warn("Failed to parse call file")
+		return nil, false
+	}
+
+	str := func(key string) string {
+		value, _ := callData[key].(string)
+		return value
+	}
+	timeField := func(key string) *time.Time {
+		t, err := time.Parse(time.RFC3339, str(key))
+		if err != nil {
+			return nil
+		}
+		return &t
+	}
+	number := func(key string) int {
+		value, _ := callData[key].(float64)
+		return int(value)
+	}
+
+	call := &models.SipCall{
+		CallID:        str("callId"),
+		Direction:     models.SipCallDirection(str("direction")),
+		Status:        models.SipCallStatus(str("status")),
+		FromUsername:  str("fromUsername"),
+		FromURI:       str("fromUri"),
+		FromIP:        str("fromIp"),
+		ToUsername:    str("toUsername"),
+		ToURI:         str("toUri"),
+		LocalRTPAddr:  str("localRtpAddr"),
+		RemoteRTPAddr: str("remoteRtpAddr"),
+		AnswerTime:    timeField("answerTime"),
+		EndTime:       timeField("endTime"),
+		Duration:      number("duration"),
+		ErrorCode:     number("errorCode"),
+		ErrorMessage:  str("errorMessage"),
+		RecordURL:     str("recordUrl"),
+		Metadata:      str("metadata"),
+		Notes:         str("notes"),
+
+		Transcription:       str("transcription"),
+		TranscriptionStatus: str("transcriptionStatus"),
+		TranscriptionError:  str("transcriptionError"),
+	}
+	// segments are read back as generic JSON values, round-trip them into the typed slice
+	if segments, ok := callData["transcriptSegments"]; ok && segments != nil {
+		if data, err := json.Marshal(segments); err == nil {
+			json.Unmarshal(data, &call.TranscriptSegments)
+		}
+	}
+	if startTime := timeField("startTime"); startTime != nil {
+		call.StartTime = *startTime
+	}
+	return call, true
+}
+
+// PruneFileStorage deletes records older than maxAge, then the oldest records until
+// the total size is within maxBytes. Zero disables either limit.
+func (c *UAConfig) PruneFileStorage(maxAge time.Duration, maxBytes int64) (removed int, err error) {
+	defer c.observeStorage(StorageTypeFile, "prune", "", time.Now(), &err)
+
+	c.fileIndexMutex.Lock()
+	defer c.fileIndexMutex.Unlock()
+
+	index := c.loadFileIndex()
+	var entries []FileIndexEntry
+	var totalSize int64
+	for _, byID := range index.Entries {
+		for _, entry := range byID {
+			entries = append(entries, entry)
+			totalSize += entry.Size
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].UpdatedAt.Before(entries[j].UpdatedAt) })
+
+	cutoff := time.Now().Add(-maxAge)
+	for _, entry := range entries {
+		expired := maxAge > 0 && entry.UpdatedAt.Before(cutoff)
+		oversized := maxBytes > 0 && totalSize > maxBytes
+		if !expired && !oversized {
+			break
+		}
+		if rmErr := os.Remove(c.fileRecordPath(entry.Kind, entry.ID)); rmErr != nil && !os.IsNotExist(rmErr) {
+			logrus.WithError(rmErr).WithField("id", entry.ID).Warn("Failed to prune storage file")
+			continue
+		}
+		delete(index.Entries[entry.Kind], entry.ID)
+		totalSize -= entry.Size
+		removed++
+	}
+
+	if removed > 0 {
+		err = c.saveFileIndex(index)
+	}
+	return removed, err
+}
+
+// fileStorage keeps SIP state as JSON records under StoragePath
+type fileStorage struct {
+	c *UAConfig
+}
+
+func (s fileStorage) Backend() StorageType { return StorageTypeFile }
+
+func (s fileStorage) SaveRegistration(info *RegistrationInfo) error {
+	c := s.c
+	c.fileRegMutex.Lock()
+	defer c.fileRegMutex.Unlock()
+
+	now := time.Now()
+	var bindings []Binding
+	if regData, err := c.readFileRecord(FileKindRegistrations, info.Username); err == nil {
+		bindings = fileBindings(regData)
+	}
+	bindings = applyRegistration(bindings, info, now)
+	if len(bindings) == 0 {
+		return c.removeFileRecord(FileKindRegistrations, info.Username)
+	}
+	if err := c.writeRegistrationFile(info.Username, bindings, now); err != nil {
+		return fmt.Errorf("failed to write registration file: %w", err)
+	}
+	return nil
+}
+
+// writeRegistrationFile writes a user's bindings; the top-level contact fields describe the
+// preferred binding for readers of the older single-contact format
+func (c *UAConfig) writeRegistrationFile(username string, bindings []Binding, now time.Time) error {
+	preferred := bindings[0]
+	host, port, _ := net.SplitHostPort(preferred.Contact)
+	contactPort, _ := strconv.Atoi(port)
+	expiresAt := preferred.ExpiresAt
+	for _, binding := range bindings {
+		if binding.ExpiresAt.After(expiresAt) {
+			expiresAt = binding.ExpiresAt
+		}
+	}
+	// Prepare registration data
+	regData := map[string]interface{}{
+		"username":     username,
+		"contact":      preferred.URI,
+		"contactIP":    host,
+		"contactPort":  contactPort,
+		"expiresAt":    expiresAt.Format(time.RFC3339),
+		"userAgent":    preferred.UserAgent,
+		"remoteIP":     preferred.RemoteIP,
+		"bindings":     bindings,
+		"status":       "registered",
+		"lastRegister": now.Format(time.RFC3339),
+	}
+	return c.writeFileRecord(FileKindRegistrations, username, regData)
+}
+
+// fileBindings reads the bindings of a registration record, including records written
+// before a user could have several bindings
+func fileBindings(regData map[string]interface{}) []Binding {
+	if raw, ok := regData["bindings"]; ok {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil
+		}
+		var bindings []Binding
+		if err := json.Unmarshal(data, &bindings); err != nil {
+			return nil
+		}
+		return bindings
+	}
+
+	ip, _ := regData["contactIP"].(string)
+	port, _ := regData["contactPort"].(float64)
+	if ip == "" {
+		return nil
+	}
+	binding := Binding{Contact: net.JoinHostPort(ip, strconv.Itoa(int(port))), Q: DefaultBindingQ}
+	binding.Username, _ = regData["username"].(string)
+	binding.URI, _ = regData["contact"].(string)
+	binding.UserAgent, _ = regData["userAgent"].(string)
+	binding.RemoteIP, _ = regData["remoteIP"].(string)
+	if expiresAt, err := time.Parse(time.RFC3339, fmt.Sprint(regData["expiresAt"])); err == nil {
+		binding.ExpiresAt = expiresAt
+	}
+	return []Binding{binding}
+}
+
+// ExpireRegistrations removes expired bindings from the registration files, and the files
+// of users with no binding left
+func (s fileStorage) ExpireRegistrations(now time.Time) ([]Binding, error) {
+	c := s.c
+	c.fileRegMutex.Lock()
+	defer c.fileRegMutex.Unlock()
+
+	var expired []Binding
+	for _, entry := range c.ListFileRecords(FileKindRegistrations) {
+		regData, err := c.readFileRecord(FileKindRegistrations, entry.ID)
+		if err != nil {
+			continue
+		}
+		bindings := fileBindings(regData)
+		live := unexpiredBindings(bindings, now)
+		if len(live) == len(bindings) && len(bindings) > 0 {
+			continue
+		}
+		if len(live) == 0 {
+			err = c.removeFileRecord(FileKindRegistrations, entry.ID)
+		} else {
+			err = c.writeRegistrationFile(entry.ID, live, now)
+		}
+		if err != nil {
+			logrus.WithError(err).WithField("username", entry.ID).Warn("Failed to remove expired registration")
+			continue
+		}
+		for _, binding := range bindings {
+			if binding.Expired(now) {
+				expired = append(expired, binding)
+			}
+		}
+	}
+	return expired, nil
+}
+
+func (s fileStorage) Bindings(username string) ([]Binding, error) {
+	regData, err := s.c.readFileRecord(FileKindRegistrations, username)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unexpiredBindings(fileBindings(regData), time.Now()), nil
+}
+
+func (s fileStorage) RegisteredBindings() (map[string][]Binding, error) {
+	users := make(map[string][]Binding)
+	now := time.Now()
+	for _, entry := range s.c.ListFileRecords(FileKindRegistrations) {
+		regData, err := s.c.readFileRecord(FileKindRegistrations, entry.ID)
+		if err != nil {
+			continue
+		}
+		if bindings := unexpiredBindings(fileBindings(regData), now); len(bindings) > 0 {
+			users[entry.ID] = bindings
+		}
+	}
+	return users, nil
+}
+
+func (s fileStorage) SavePendingSession(callID, clientRTPAddr string) error {
+	sessionData := map[string]interface{}{
+		"callId":        callID,
+		"remoteRtpAddr": clientRTPAddr,
+		"status":        "pending",
+		"createdTime":   time.Now().Format(time.RFC3339),
+	}
+	if err := s.c.writeFileRecord(FileKindSessions, callID, sessionData); err != nil {
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
+	return nil
+}
+
+func (s fileStorage) GetPendingSession(callID string) (string, bool) {
+	sessionData, err := s.c.readFileRecord(FileKindSessions, callID)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.WithError(err).WithField("call_id", callID).Warn("Skipping corrupt session file")
+		}
+		return "", false
+	}
+	addr, ok := sessionData["remoteRtpAddr"].(string)
+	return addr, ok
+}
+
+func (s fileStorage) RemovePendingSession(callID string) error {
+	if err := os.Remove(s.c.fileRecordPath(FileKindSessions, callID)); err != nil {
+		return err
+	}
+	s.c.unindexFileRecord(FileKindSessions, callID)
+	return nil
+}
+
+// ExpirePendingSessions removes session files written before the given time, including corrupt
+// files and files missing from the index
+func (s fileStorage) ExpirePendingSessions(before time.Time) ([]string, error) {
+	c := s.c
+	dir := filepath.Join(c.StoragePath, FileKindSessions)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read session directory: %w", err)
+	}
+	var expired []string
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil || !info.ModTime().Before(before) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, file.Name())); err != nil && !os.IsNotExist(err) {
+			logrus.WithError(err).WithField("file", file.Name()).Warn("Failed to remove expired session file")
+			continue
+		}
+		callID := strings.TrimSuffix(file.Name(), ".json")
+		c.unindexFileRecord(FileKindSessions, callID)
+		expired = append(expired, callID)
+	}
+	return expired, nil
+}
+
+func (s fileStorage) SaveCall(sipCall *models.SipCall) error {
+	// Prepare call data
+	callData := map[string]interface{}{
+		"callId":        sipCall.CallID,
+		"direction":     string(sipCall.Direction),
+		"status":        string(sipCall.Status),
+		"fromUsername":  sipCall.FromUsername,
+		"fromUri":       sipCall.FromURI,
+		"fromIp":        sipCall.FromIP,
+		"toUsername":    sipCall.ToUsername,
+		"toUri":         sipCall.ToURI,
+		"localRtpAddr":  sipCall.LocalRTPAddr,
+		"remoteRtpAddr": sipCall.RemoteRTPAddr,
+		"startTime":     sipCall.StartTime.Format(time.RFC3339),
+	}
+
+	if sipCall.AnswerTime != nil {
+		callData["answerTime"] = sipCall.AnswerTime.Format(time.RFC3339)
+	}
+	if sipCall.EndTime != nil {
+		callData["endTime"] = sipCall.EndTime.Format(time.RFC3339)
+	}
+	if sipCall.Duration > 0 {
+		callData["duration"] = sipCall.Duration
+	}
+	if sipCall.ErrorCode != 0 {
+		callData["errorCode"] = sipCall.ErrorCode
+	}
+	if sipCall.ErrorMessage != "" {
+		callData["errorMessage"] = sipCall.ErrorMessage
+	}
+	if sipCall.RecordURL != "" {
+		callData["recordUrl"] = sipCall.RecordURL
+	}
+	if sipCall.Metadata != "" {
+		callData["metadata"] = sipCall.Metadata
+	}
+	if sipCall.Notes != "" {
+		callData["notes"] = sipCall.Notes
+	}
+
+	if err := s.c.writeFileRecord(FileKindCalls, sipCall.CallID, callData); err != nil {
+		return fmt.Errorf("failed to write call file: %w", err)
+	}
+	return nil
+}
+
+func (s fileStorage) GetCall(callID string) (*models.SipCall, bool) {
+	return s.c.getCallFromFile(callID)
+}
+
+func (s fileStorage) UpdateCallStatus(callID string, status models.SipCallStatus, answerTime *time.Time) error {
+	return s.updateCall(callID, func(callData map[string]interface{}) error {
+		callData["status"] = string(status)
+		if answerTime != nil {
+			callData["answerTime"] = answerTime.Format(time.RFC3339)
+		}
+		return nil
+	})
+}
+
+func (s fileStorage) SaveRecordURL(callID, recordURL string) error {
+	return s.updateCall(callID, func(callData map[string]interface{}) error {
+		callData["recordUrl"] = recordURL
+		return nil
+	})
+}
+
+func (s fileStorage) UpdateCallMetadata(callID, key string, value interface{}) error {
+	return s.updateCall(callID, func(callData map[string]interface{}) error {
+		existing, _ := callData["metadata"].(string)
+		metadata, err := mergeMetadata(existing, key, value)
+		if err != nil {
+			return err
+		}
+		callData["metadata"] = metadata
+		return nil
+	})
+}
+
+func (s fileStorage) SaveTranscription(callID string, transcription *models.CallTranscription) error {
+	return s.updateCall(callID, func(callData map[string]interface{}) error {
+		callData["transcriptionStatus"] = transcription.Status
+		callData["transcription"] = transcription.Text
+		callData["transcriptionError"] = transcription.Error
+		callData["transcriptSegments"] = transcription.Segments
+		return nil
+	})
+}
+
+// updateCall rewrites a call record after update modifies its fields
+func (s fileStorage) updateCall(callID string, update func(callData map[string]interface{}) error) error {
+	callData, err := s.c.readFileRecord(FileKindCalls, callID)
+	if err != nil {
+		return fmt.Errorf("failed to read call file: %w", err)
+	}
+	if err := update(callData); err != nil {
+		return err
+	}
+	if err := s.c.writeFileRecord(FileKindCalls, callID, callData); err != nil {
+		return fmt.Errorf("failed to write call file: %w", err)
+	}
+	return nil
+}
+
+// readFileRecord reads one JSON record
+func (c *UAConfig) readFileRecord(kind, id string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(c.fileRecordPath(kind, id))
+	if err != nil {
+		return nil, err
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s record: %w", kind, err)
+	}
+	return record, nil
+}
+
+// removeFileRecord removes one JSON record and its index entry, a missing record is not an error
+func (c *UAConfig) removeFileRecord(kind, id string) error {
+	if err := os.Remove(c.fileRecordPath(kind, id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s record: %w", kind, err)
+	}
+	c.unindexFileRecord(kind, id)
+	return nil
+}
+
+// writeFileRecord writes one JSON record and indexes it
+func (c *UAConfig) writeFileRecord(kind, id string, record map[string]interface{}) error {
+	if err := os.MkdirAll(filepath.Join(c.StoragePath, kind), 0755); err != nil {
+		return fmt.Errorf("failed to create %s directory: %w", kind, err)
+	}
+	jsonData, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s record: %w", kind, err)
+	}
+	if err := c.writeFile(c.fileRecordPath(kind, id), jsonData, 0644); err != nil {
+		return err
+	}
+	c.indexFileRecord(kind, id, int64(len(jsonData)))
+	return nil
+}
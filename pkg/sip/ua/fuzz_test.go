@@ -0,0 +1,39 @@
+package ua
+
+import (
+	"testing"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+func FuzzExtractRegistrationInfo(f *testing.F) {
+	f.Add([]byte("REGISTER sip:example.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP 192.168.1.10:5060;branch=z9hG4bK776asdhds;received=203.0.113.5\r\n" +
+		"From: <sip:1001@example.com>;tag=1928301774\r\n" +
+		"To: <sip:1001@example.com>\r\n" +
+		"Call-ID: a84b4c76e66710\r\n" +
+		"CSeq: 1 REGISTER\r\n" +
+		"Contact: <sip:1001@192.168.1.10:5060>\r\n" +
+		"Expires: 3600\r\n" +
+		"User-Agent: Linphone\r\n" +
+		"Content-Length: 0\r\n\r\n"))
+	f.Add([]byte("REGISTER sip:example.com SIP/2.0\r\n" +
+		"Contact: *\r\n" +
+		"Expires: -1\r\n\r\n"))
+	f.Add([]byte("REGISTER sip:x SIP/2.0\r\nVia: SIP/2.0/UDP\r\nFrom: <>\r\n\r\n"))
+
+	config := &UAConfig{}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := sip.ParseMessage(data)
+		if err != nil {
+			return
+		}
+		req, ok := msg.(*sip.Request)
+		if !ok {
+			return
+		}
+		if info := config.ExtractRegistrationInfo(req); info == nil {
+			t.Fatal("nil registration info")
+		}
+	})
+}
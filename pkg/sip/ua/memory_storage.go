@@ -0,0 +1,183 @@
+package ua
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+)
+
+// memoryStorage keeps SIP state in the UAConfig maps, lost on restart
+type memoryStorage struct {
+	c *UAConfig
+}
+
+func (s memoryStorage) Backend() StorageType { return StorageTypeMemory }
+
+func (s memoryStorage) SaveRegistration(info *RegistrationInfo) error {
+	s.c.saveRegistrationToMemory(info, time.Now())
+	return nil
+}
+
+func (s memoryStorage) ExpireRegistrations(now time.Time) ([]Binding, error) {
+	return s.c.expireRegistrationsInMemory(now), nil
+}
+
+func (s memoryStorage) Bindings(username string) ([]Binding, error) {
+	return s.c.getBindingsFromMemory(username, time.Now()), nil
+}
+
+func (s memoryStorage) RegisteredBindings() (map[string][]Binding, error) {
+	return s.c.listBindingsFromMemory(time.Now()), nil
+}
+
+func (s memoryStorage) SavePendingSession(callID, clientRTPAddr string) error {
+	return s.c.savePendingSessionToMemory(callID, clientRTPAddr)
+}
+
+func (s memoryStorage) GetPendingSession(callID string) (string, bool) {
+	return s.c.getPendingSessionFromMemory(callID)
+}
+
+func (s memoryStorage) RemovePendingSession(callID string) error {
+	return s.c.removePendingSessionFromMemory(callID)
+}
+
+func (s memoryStorage) ExpirePendingSessions(before time.Time) ([]string, error) {
+	return s.c.expirePendingSessionsInMemory(before), nil
+}
+
+func (s memoryStorage) SaveCall(sipCall *models.SipCall) error {
+	return s.c.saveCallToMemory(sipCall)
+}
+
+func (s memoryStorage) GetCall(callID string) (*models.SipCall, bool) {
+	return s.c.getCallFromMemory(callID)
+}
+
+func (s memoryStorage) UpdateCallStatus(callID string, status models.SipCallStatus, answerTime *time.Time) error {
+	s.c.updateCallStatusInMemory(callID, status, answerTime)
+	return nil
+}
+
+func (s memoryStorage) SaveRecordURL(callID, recordURL string) error {
+	s.c.saveRecordURLToMemory(callID, recordURL)
+	return nil
+}
+
+func (s memoryStorage) UpdateCallMetadata(callID, key string, value interface{}) error {
+	s.c.memoryCallsMutex.Lock()
+	defer s.c.memoryCallsMutex.Unlock()
+	call, exists := s.c.MemoryCalls[callID]
+	if !exists {
+		return fmt.Errorf("call %s not found", callID)
+	}
+	metadata, err := mergeMetadata(call.Metadata, key, value)
+	if err != nil {
+		return err
+	}
+	call.Metadata = metadata
+	return nil
+}
+
+func (s memoryStorage) SaveTranscription(callID string, transcription *models.CallTranscription) error {
+	s.c.memoryCallsMutex.Lock()
+	defer s.c.memoryCallsMutex.Unlock()
+	call, exists := s.c.MemoryCalls[callID]
+	if !exists {
+		return fmt.Errorf("call %s not found", callID)
+	}
+	call.TranscriptionStatus = transcription.Status
+	call.Transcription = transcription.Text
+	call.TranscriptionError = transcription.Error
+	call.TranscriptSegments = transcription.Segments
+	return nil
+}
+
+func (c *UAConfig) savePendingSessionToMemory(callID, clientRTPAddr string) error {
+	c.sessionsMutex.Lock()
+	defer c.sessionsMutex.Unlock()
+	c.PendingSessions[callID] = clientRTPAddr
+	if c.pendingSince == nil {
+		c.pendingSince = make(map[string]time.Time)
+	}
+	c.pendingSince[callID] = time.Now()
+	return nil
+}
+
+func (c *UAConfig) getPendingSessionFromMemory(callID string) (string, bool) {
+	c.sessionsMutex.RLock()
+	defer c.sessionsMutex.RUnlock()
+	addr, exists := c.PendingSessions[callID]
+	return addr, exists
+}
+
+func (c *UAConfig) removePendingSessionFromMemory(callID string) error {
+	c.sessionsMutex.Lock()
+	defer c.sessionsMutex.Unlock()
+	delete(c.PendingSessions, callID)
+	delete(c.pendingSince, callID)
+	return nil
+}
+
+// expirePendingSessionsInMemory removes pending sessions saved before the given time
+func (c *UAConfig) expirePendingSessionsInMemory(before time.Time) []string {
+	c.sessionsMutex.Lock()
+	defer c.sessionsMutex.Unlock()
+	var expired []string
+	for callID := range c.PendingSessions {
+		// sessions added to the map directly have no timestamp, their timeout starts now
+		since, ok := c.pendingSince[callID]
+		if !ok {
+			if c.pendingSince == nil {
+				c.pendingSince = make(map[string]time.Time)
+			}
+			c.pendingSince[callID] = time.Now()
+			continue
+		}
+		if since.Before(before) {
+			delete(c.PendingSessions, callID)
+			delete(c.pendingSince, callID)
+			expired = append(expired, callID)
+		}
+	}
+	return expired
+}
+
+func (c *UAConfig) saveCallToMemory(sipCall *models.SipCall) error {
+	c.memoryCallsMutex.Lock()
+	defer c.memoryCallsMutex.Unlock()
+	callCopy := *sipCall
+	c.MemoryCalls[sipCall.CallID] = &callCopy
+	return nil
+}
+
+func (c *UAConfig) getCallFromMemory(callID string) (*models.SipCall, bool) {
+	c.memoryCallsMutex.RLock()
+	defer c.memoryCallsMutex.RUnlock()
+	call, exists := c.MemoryCalls[callID]
+	if !exists {
+		return nil, false
+	}
+	callCopy := *call
+	return &callCopy, true
+}
+
+func (c *UAConfig) updateCallStatusInMemory(callID string, status models.SipCallStatus, answerTime *time.Time) {
+	c.memoryCallsMutex.Lock()
+	defer c.memoryCallsMutex.Unlock()
+	if call, exists := c.MemoryCalls[callID]; exists {
+		call.Status = status
+		if answerTime != nil {
+			call.AnswerTime = answerTime
+		}
+	}
+}
+
+func (c *UAConfig) saveRecordURLToMemory(callID, recordURL string) {
+	c.memoryCallsMutex.Lock()
+	defer c.memoryCallsMutex.Unlock()
+	if call, exists := c.MemoryCalls[callID]; exists {
+		call.RecordURL = recordURL
+	}
+}
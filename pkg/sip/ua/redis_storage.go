@@ -0,0 +1,457 @@
+package ua
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// redisTimeout bounds a single Redis operation so a slow server does not stall signalling
+const redisTimeout = 2 * time.Second
+
+// Redis key kinds, each stored under <prefix><kind>:<id>
+const (
+	redisKindPending      = "pending"
+	redisKindRegistration = "registration" // single binding per user, written before multiple bindings
+	redisKindBindings     = "bindings"
+	redisKindActive       = "active"
+)
+
+// RedisRegistration is the registration record shared between instances, one per binding
+// in the hash <prefix>bindings:<username> under its contact URI
+type RedisRegistration struct {
+	Username  string    `json:"username"`
+	Contact   string    `json:"contact"` // host:port used for routing
+	URI       string    `json:"uri"`
+	Q         float64   `json:"q"`
+	UserAgent string    `json:"userAgent"`
+	RemoteIP  string    `json:"remoteIp"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (r RedisRegistration) binding() Binding {
+	return Binding{
+		Username:  r.Username,
+		URI:       r.URI,
+		Contact:   r.Contact,
+		Q:         r.Q,
+		ExpiresAt: r.ExpiresAt,
+		UserAgent: r.UserAgent,
+		RemoteIP:  r.RemoteIP,
+	}
+}
+
+// SharedSession is the part of an active session visible to other instances; channels
+// and recording handles stay with the instance that owns the call
+type SharedSession struct {
+	CallID        string    `json:"callId"`
+	Instance      string    `json:"instance"`
+	ClientRTPAddr string    `json:"clientRtpAddr,omitempty"`
+	RecordingFile string    `json:"recordingFile,omitempty"`
+	StartedAt     time.Time `json:"startedAt"`
+}
+
+// redisEnabled reports whether redis storage is configured with a client
+func (c *UAConfig) redisEnabled() bool {
+	return c.StorageType == StorageTypeRedis && c.Redis != nil
+}
+
+func (c *UAConfig) redisKey(kind, id string) string {
+	prefix := c.RedisKeyPrefix
+	if prefix == "" {
+		prefix = DefaultRedisKeyPrefix
+	}
+	return prefix + kind + ":" + id
+}
+
+func redisContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), redisTimeout)
+}
+
+// instanceID returns the ID this instance writes into shared session state
+func (c *UAConfig) instanceID() string {
+	if c.InstanceID != "" {
+		return c.InstanceID
+	}
+	host, _ := os.Hostname()
+	return host + ":" + strconv.Itoa(os.Getpid())
+}
+
+// redisStorage shares registrations and pending sessions between instances with TTLs;
+// call records go to the embedded CallStore
+type redisStorage struct {
+	CallStore
+	c *UAConfig
+}
+
+func (s redisStorage) Backend() StorageType { return StorageTypeRedis }
+
+func (s redisStorage) SavePendingSession(callID, clientRTPAddr string) error {
+	ctx, cancel := redisContext()
+	defer cancel()
+	if err := s.c.Redis.Set(ctx, s.c.redisKey(redisKindPending, callID), clientRTPAddr, DefaultPendingSessionTTL).Err(); err != nil {
+		return fmt.Errorf("failed to save pending session to redis: %w", err)
+	}
+	return nil
+}
+
+func (s redisStorage) GetPendingSession(callID string) (string, bool) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	addr, err := s.c.Redis.Get(ctx, s.c.redisKey(redisKindPending, callID)).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			logrus.WithError(err).WithField("call_id", callID).Warn("Failed to read pending session from redis")
+		}
+		return "", false
+	}
+	return addr, true
+}
+
+func (s redisStorage) RemovePendingSession(callID string) error {
+	ctx, cancel := redisContext()
+	defer cancel()
+	if err := s.c.Redis.Del(ctx, s.c.redisKey(redisKindPending, callID)).Err(); err != nil {
+		return fmt.Errorf("failed to remove pending session from redis: %w", err)
+	}
+	return nil
+}
+
+// ExpirePendingSessions does nothing, pending sessions expire with their DefaultPendingSessionTTL
+func (s redisStorage) ExpirePendingSessions(before time.Time) ([]string, error) {
+	return nil, nil
+}
+
+// saveBindingScript stores one binding in the user's hash and extends the hash TTL to the
+// latest binding expiry, never shortening it for bindings that expire later
+var saveBindingScript = redis.NewScript(`
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[3]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 1
+`)
+
+// SaveRegistration saves a binding that expires with its Expires value; Expires 0 removes it
+// and Contact: * removes all of the user's bindings
+func (s redisStorage) SaveRegistration(info *RegistrationInfo) error {
+	c := s.c
+	now := time.Now()
+	ctx, cancel := redisContext()
+	defer cancel()
+
+	if info.Wildcard || info.Expires <= 0 {
+		var err error
+		if info.Wildcard {
+			// also the single key written before users could have several bindings
+			err = c.Redis.Del(ctx, c.redisBindingsKey(info.Username), c.redisKey(redisKindRegistration, info.Username)).Err()
+		} else {
+			err = c.Redis.HDel(ctx, c.redisBindingsKey(info.Username), info.ContactStr).Err()
+		}
+		c.saveRegistrationToMemory(info, now)
+		if err != nil {
+			return fmt.Errorf("failed to remove registration from redis: %w", err)
+		}
+		return nil
+	}
+	if info.ContactStr == "" {
+		return nil
+	}
+
+	binding := info.Binding(now)
+	record := RedisRegistration{
+		Username:  info.Username,
+		Contact:   binding.Contact,
+		URI:       info.ContactStr,
+		Q:         binding.Q,
+		UserAgent: info.UserAgent,
+		RemoteIP:  info.RemoteIP,
+		ExpiresAt: binding.ExpiresAt,
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal registration: %w", err)
+	}
+	ttl := time.Duration(info.Expires) * time.Second
+	key := c.redisBindingsKey(info.Username)
+	if err := saveBindingScript.Run(ctx, c.Redis, []string{key}, info.ContactStr, data, ttl.Milliseconds()).Err(); err != nil {
+		return fmt.Errorf("failed to save registration to redis: %w", err)
+	}
+	c.saveRegistrationToMemory(info, now)
+	return nil
+}
+
+// redisBindingsKey is the hash holding all bindings of a user, keyed by contact URI
+func (c *UAConfig) redisBindingsKey(username string) string {
+	return c.redisKey(redisKindBindings, username)
+}
+
+// ExpireRegistrations only cleans the local copies, shared registrations expire with their TTL
+func (s redisStorage) ExpireRegistrations(now time.Time) ([]Binding, error) {
+	return s.c.expireRegistrationsInMemory(now), nil
+}
+
+// Bindings looks up the bindings a user registered on any instance, falling back to the
+// local registrations when redis is unavailable
+func (s redisStorage) Bindings(username string) ([]Binding, error) {
+	c := s.c
+	now := time.Now()
+	ctx, cancel := redisContext()
+	defer cancel()
+
+	key := c.redisBindingsKey(username)
+	fields, err := c.Redis.HGetAll(ctx, key).Result()
+	if err != nil {
+		logrus.WithError(err).WithField("username", username).Warn("Failed to read registration from redis, using local registrations")
+		return c.getBindingsFromMemory(username, now), nil
+	}
+	bindings, expired := liveBindings(fields, now)
+	if len(expired) > 0 {
+		// the hash lives as long as its latest binding, drop the ones that lapsed before it
+		if err := c.Redis.HDel(ctx, key, expired...).Err(); err != nil {
+			logrus.WithError(err).WithField("username", username).Debug("Failed to remove expired bindings from redis")
+		}
+	}
+	err = c.getRedis(c.redisKey(redisKindRegistration, username), func(data []byte) {
+		var record RedisRegistration
+		if err := json.Unmarshal(data, &record); err == nil && record.Contact != "" && record.Username == username {
+			bindings = append(bindings, record.binding())
+		}
+	})
+	if err != nil {
+		logrus.WithError(err).WithField("username", username).Warn("Failed to read registration from redis, using local registrations")
+		return c.getBindingsFromMemory(username, now), nil
+	}
+	sortBindings(bindings)
+	return bindings, nil
+}
+
+// liveBindings decodes a user's binding hash, returning the unexpired bindings and the
+// contact URIs of expired ones
+func liveBindings(fields map[string]string, now time.Time) ([]Binding, []string) {
+	var bindings []Binding
+	var expired []string
+	for uri, data := range fields {
+		var record RedisRegistration
+		if err := json.Unmarshal([]byte(data), &record); err != nil || record.Contact == "" {
+			continue
+		}
+		if !record.ExpiresAt.IsZero() && !record.ExpiresAt.After(now) {
+			expired = append(expired, uri)
+			continue
+		}
+		bindings = append(bindings, record.binding())
+	}
+	return bindings, expired
+}
+
+// RegisteredBindings returns the unexpired bindings of all instances
+func (s redisStorage) RegisteredBindings() (map[string][]Binding, error) {
+	c := s.c
+	now := time.Now()
+	users := make(map[string][]Binding)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*redisTimeout)
+	defer cancel()
+
+	keys, err := c.scanRedisKeys(ctx, c.redisKey(redisKindBindings, "*"))
+	if err != nil {
+		return users, err
+	}
+	for start := 0; start < len(keys); start += 100 {
+		pipe := c.Redis.Pipeline()
+		var results []*redis.MapStringStringCmd
+		for _, key := range keys[start:min(start+100, len(keys))] {
+			results = append(results, pipe.HGetAll(ctx, key))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return users, fmt.Errorf("failed to read redis bindings: %w", err)
+		}
+		for _, result := range results {
+			bindings, _ := liveBindings(result.Val(), now)
+			for _, binding := range bindings {
+				users[binding.Username] = append(users[binding.Username], binding)
+			}
+		}
+	}
+	err = c.scanRedis(redisKindRegistration, "*", func(data []byte) {
+		var record RedisRegistration
+		if err := json.Unmarshal(data, &record); err == nil && record.Contact != "" {
+			users[record.Username] = append(users[record.Username], record.binding())
+		}
+	})
+	for _, bindings := range users {
+		sortBindings(bindings)
+	}
+	return users, err
+}
+
+// getRedis calls fn with the value of one key, a missing key is not an error
+func (c *UAConfig) getRedis(key string, fn func(data []byte)) error {
+	ctx, cancel := redisContext()
+	defer cancel()
+	data, err := c.Redis.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fn(data)
+	return nil
+}
+
+// ==================== Active Sessions ====================
+
+// saveSharedSession publishes an active session for other instances, expiring after SessionTimeout
+// unless RefreshSharedSessions extends it
+func (c *UAConfig) saveSharedSession(callID string, session *SessionInfo) {
+	shared := SharedSession{
+		CallID:        callID,
+		Instance:      c.instanceID(),
+		RecordingFile: session.RecordingFile,
+		StartedAt:     session.StartedAt,
+	}
+	if session.ClientRTPAddr != nil {
+		shared.ClientRTPAddr = session.ClientRTPAddr.String()
+	}
+	data, err := json.Marshal(shared)
+	if err != nil {
+		return
+	}
+	ctx, cancel := redisContext()
+	defer cancel()
+	if err := c.Redis.Set(ctx, c.redisKey(redisKindActive, callID), data, c.SessionTimeout).Err(); err != nil {
+		logrus.WithError(err).WithField("call_id", callID).Warn("Failed to share active session in redis")
+	}
+}
+
+func (c *UAConfig) removeSharedSession(callID string) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	if err := c.Redis.Del(ctx, c.redisKey(redisKindActive, callID)).Err(); err != nil {
+		logrus.WithError(err).WithField("call_id", callID).Warn("Failed to remove shared session from redis")
+	}
+}
+
+// RefreshSharedSessions extends the TTL of the shared copies of this instance's active sessions,
+// so calls longer than SessionTimeout stay visible to other instances until the BYE removes
+// them; copies lost from redis are published again
+func (c *UAConfig) RefreshSharedSessions() {
+	if !c.redisEnabled() {
+		return
+	}
+	c.activeMutex.RLock()
+	sessions := make(map[string]*SessionInfo, len(c.ActiveSessions))
+	for callID, session := range c.ActiveSessions {
+		sessions[callID] = session
+	}
+	c.activeMutex.RUnlock()
+	if len(sessions) == 0 {
+		return
+	}
+
+	ctx, cancel := redisContext()
+	defer cancel()
+	pipe := c.Redis.Pipeline()
+	refreshed := make(map[string]*redis.BoolCmd, len(sessions))
+	for callID := range sessions {
+		refreshed[callID] = pipe.Expire(ctx, c.redisKey(redisKindActive, callID), c.SessionTimeout)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logrus.WithError(err).Warn("Failed to refresh shared sessions in redis")
+		return
+	}
+	for callID, result := range refreshed {
+		if !result.Val() {
+			c.saveSharedSession(callID, sessions[callID])
+		}
+	}
+}
+
+// GetSharedSession returns an active session owned by any instance; without redis
+// storage only local sessions are found
+func (c *UAConfig) GetSharedSession(callID string) (*SharedSession, bool) {
+	if !c.redisEnabled() {
+		session, exists := c.GetActiveSession(callID)
+		if !exists {
+			return nil, false
+		}
+		shared := &SharedSession{CallID: callID, Instance: c.instanceID(), RecordingFile: session.RecordingFile}
+		if session.ClientRTPAddr != nil {
+			shared.ClientRTPAddr = session.ClientRTPAddr.String()
+		}
+		return shared, true
+	}
+
+	ctx, cancel := redisContext()
+	defer cancel()
+	data, err := c.Redis.Get(ctx, c.redisKey(redisKindActive, callID)).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	var shared SharedSession
+	if err := json.Unmarshal(data, &shared); err != nil {
+		return nil, false
+	}
+	return &shared, true
+}
+
+// ListSharedSessions returns the active sessions of all instances sharing the redis storage
+func (c *UAConfig) ListSharedSessions() ([]SharedSession, error) {
+	if !c.redisEnabled() {
+		return nil, fmt.Errorf("redis storage not configured")
+	}
+	var sessions []SharedSession
+	err := c.scanRedis(redisKindActive, "*", func(data []byte) {
+		var shared SharedSession
+		if err := json.Unmarshal(data, &shared); err == nil {
+			sessions = append(sessions, shared)
+		}
+	})
+	return sessions, err
+}
+
+// scanRedis calls fn with the value of every key of one kind whose id matches the pattern
+func (c *UAConfig) scanRedis(kind, pattern string, fn func(data []byte)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*redisTimeout)
+	defer cancel()
+
+	keys, err := c.scanRedisKeys(ctx, c.redisKey(kind, pattern))
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(keys); start += 100 {
+		end := min(start+100, len(keys))
+		values, err := c.Redis.MGet(ctx, keys[start:end]...).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read redis keys: %w", err)
+		}
+		for _, value := range values {
+			// keys that expired between SCAN and MGET come back nil
+			if s, ok := value.(string); ok {
+				fn([]byte(s))
+			}
+		}
+	}
+	return nil
+}
+
+// scanRedisKeys returns the keys matching a pattern
+func (c *UAConfig) scanRedisKeys(ctx context.Context, pattern string) ([]string, error) {
+	iter := c.Redis.Scan(ctx, 0, pattern, 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan redis: %w", err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,134 @@
+package ua
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedisInstances returns two instances sharing one miniredis server
+func newRedisInstances(t *testing.T) (*miniredis.Miniredis, *UAConfig, *UAConfig) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	newInstance := func(id string) *UAConfig {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+		c := DefaultUAConfig()
+		c.StorageType = StorageTypeRedis
+		c.Redis = client
+		c.InstanceID = id
+		c.StoragePath = t.TempDir()
+		return c
+	}
+	return server, newInstance("a"), newInstance("b")
+}
+
+func TestRedisSharedSessions(t *testing.T) {
+	server, a, b := newRedisInstances(t)
+
+	a.SaveActiveSession("call-1", &SessionInfo{ClientRTPAddr: &net.UDPAddr{IP: net.ParseIP("10.0.0.5"), Port: 4000}})
+	shared, ok := b.GetSharedSession("call-1")
+	if !ok || shared.Instance != "a" || shared.ClientRTPAddr != "10.0.0.5:4000" {
+		t.Fatalf("GetSharedSession() on other instance = %+v, %v", shared, ok)
+	}
+
+	// 通话超过SessionTimeout时由拥有它的实例续期
+	server.FastForward(a.SessionTimeout - time.Second)
+	a.RefreshSharedSessions()
+	server.FastForward(a.SessionTimeout - time.Second)
+	if _, ok := b.GetSharedSession("call-1"); !ok {
+		t.Fatal("shared session expired while the call was still active")
+	}
+
+	// redis丢失的副本在续期时重新写入
+	server.Del(a.redisKey(redisKindActive, "call-1"))
+	a.RefreshSharedSessions()
+	if sessions, err := b.ListSharedSessions(); err != nil || len(sessions) != 1 || sessions[0].CallID != "call-1" {
+		t.Fatalf("ListSharedSessions() after republish = %+v, %v", sessions, err)
+	}
+
+	a.RemoveActiveSession("call-1")
+	if _, ok := b.GetSharedSession("call-1"); ok {
+		t.Error("shared session still visible after the owner removed it")
+	}
+
+	// 没有续期的会话按SessionTimeout过期
+	a.SaveActiveSession("call-2", &SessionInfo{})
+	a.activeMutex.Lock()
+	delete(a.ActiveSessions, "call-2")
+	a.activeMutex.Unlock()
+	server.FastForward(a.SessionTimeout + time.Second)
+	if _, ok := b.GetSharedSession("call-2"); ok {
+		t.Error("abandoned shared session did not expire")
+	}
+}
+
+func TestRedisRegistrations(t *testing.T) {
+	server, a, b := newRedisInstances(t)
+
+	desk := &RegistrationInfo{Username: "alice", ContactStr: "sip:alice@10.0.0.4", ContactIP: "10.0.0.4", ContactPort: 5060, Expires: 60, Q: 0.5}
+	phone := &RegistrationInfo{Username: "alice", ContactStr: "sip:alice@10.0.0.5:5062", ContactIP: "10.0.0.5", ContactPort: 5062, Expires: 3600, Q: 1}
+	// 用户名中的通配符不能匹配到其他用户
+	other := &RegistrationInfo{Username: "alice2", ContactStr: "sip:alice2@10.0.0.6", ContactIP: "10.0.0.6", ContactPort: 5060, Expires: 3600}
+	glob := &RegistrationInfo{Username: "alice*", ContactStr: "sip:x@10.0.0.7", ContactIP: "10.0.0.7", ContactPort: 5060, Expires: 3600}
+	if err := a.SaveRegistration(desk); err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range []*RegistrationInfo{phone, other, glob} {
+		if err := b.SaveRegistration(info); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bindings, err := a.Bindings("alice")
+	if err != nil || len(bindings) != 2 || bindings[0].URI != phone.ContactStr || bindings[1].URI != desk.ContactStr {
+		t.Fatalf("Bindings(alice) across instances = %+v, %v", bindings, err)
+	}
+	// 较短的绑定不会缩短用户哈希的TTL
+	if ttl := server.TTL(a.redisBindingsKey("alice")); ttl != time.Hour {
+		t.Errorf("bindings TTL = %v, want the latest expiry %v", ttl, time.Hour)
+	}
+
+	// 已过期但还在哈希中的绑定不返回，并被清理
+	lapsed, _ := json.Marshal(RedisRegistration{Username: "alice", Contact: "10.0.0.9:5060", URI: "sip:alice@10.0.0.9", ExpiresAt: time.Now().Add(-time.Second)})
+	server.HSet(a.redisBindingsKey("alice"), "sip:alice@10.0.0.9", string(lapsed))
+	if bindings, _ := b.Bindings("alice"); len(bindings) != 2 {
+		t.Errorf("Bindings(alice) with a lapsed binding = %+v", bindings)
+	}
+	if server.HGet(a.redisBindingsKey("alice"), "sip:alice@10.0.0.9") != "" {
+		t.Error("lapsed binding not removed from the hash")
+	}
+
+	all, err := b.RegisteredBindings()
+	if err != nil || len(all["alice"]) != 2 || len(all["alice2"]) != 1 || len(all["alice*"]) != 1 {
+		t.Errorf("RegisteredBindings() = %+v, %v", all, err)
+	}
+
+	// Expires 0 只删除对应的一个绑定
+	if err := b.SaveRegistration(&RegistrationInfo{Username: "alice", ContactStr: desk.ContactStr}); err != nil {
+		t.Fatal(err)
+	}
+	if bindings, _ := a.Bindings("alice"); len(bindings) != 1 || bindings[0].URI != phone.ContactStr {
+		t.Errorf("Bindings(alice) after unregistering one contact = %+v", bindings)
+	}
+
+	// Contact: * 只删除该用户的绑定，不按用户名做模式匹配
+	if err := a.SaveRegistration(&RegistrationInfo{Username: "alice*", Wildcard: true}); err != nil {
+		t.Fatal(err)
+	}
+	for username, want := range map[string]int{"alice*": 0, "alice": 1, "alice2": 1} {
+		if bindings, _ := b.Bindings(username); len(bindings) != want {
+			t.Errorf("Bindings(%s) after wildcard unregister of alice* = %+v, want %d", username, bindings, want)
+		}
+	}
+	if err := a.SaveRegistration(&RegistrationInfo{Username: "alice", Wildcard: true}); err != nil {
+		t.Fatal(err)
+	}
+	if bindings, _ := b.Bindings("alice"); len(bindings) != 0 {
+		t.Errorf("Bindings(alice) after wildcard unregister = %+v", bindings)
+	}
+}
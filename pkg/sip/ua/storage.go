@@ -3,12 +3,9 @@ package ua
 import (
 	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/LingByte/LingSIP/internal/models"
-	"github.com/sirupsen/logrus"
 )
 
 // RegistrationInfo contains extracted registration information from SIP REGISTER request
@@ -20,491 +17,247 @@ type RegistrationInfo struct {
 	Expires     int
 	UserAgent   string
 	RemoteIP    string
+	Wildcard    bool    // Contact: *, removes all of the user's bindings
+	Q           float64 // q-value of the binding, higher is tried first
 }
 
-// SavePendingSession saves a pending session based on storage type
-func (c *UAConfig) SavePendingSession(callID, clientRTPAddr string) error {
-	switch c.StorageType {
-	case StorageTypeDatabase:
-		return c.savePendingSessionToDatabase(callID, clientRTPAddr)
-
-	case StorageTypeFile:
-		return c.savePendingSessionToFile(callID, clientRTPAddr)
-
-	case StorageTypeMemory:
-		return c.savePendingSessionToMemory(callID, clientRTPAddr)
-
-	default:
-		// Default to memory
-		return c.savePendingSessionToMemory(callID, clientRTPAddr)
-	}
+// ExpiresAt returns when a registration made at now expires
+func (info *RegistrationInfo) ExpiresAt(now time.Time) time.Time {
+	return now.Add(time.Duration(info.Expires) * time.Second)
 }
 
-// GetPendingSession gets a pending session based on storage type
-func (c *UAConfig) GetPendingSession(callID string) (string, bool) {
-	switch c.StorageType {
-	case StorageTypeDatabase:
-		return c.getPendingSessionFromDatabase(callID)
-
-	case StorageTypeFile:
-		return c.getPendingSessionFromFile(callID)
-
-	case StorageTypeMemory:
-		return c.getPendingSessionFromMemory(callID)
-
-	default:
-		// Default to memory
-		return c.getPendingSessionFromMemory(callID)
-	}
+// RegisterStore keeps registrations from REGISTER requests; a user has one binding per Contact URI
+type RegisterStore interface {
+	// SaveRegistration saves one binding of a user; Expires 0 removes the binding and
+	// Wildcard removes all of the user's bindings
+	SaveRegistration(info *RegistrationInfo) error
+	// ExpireRegistrations removes bindings that expired by now and returns them
+	ExpireRegistrations(now time.Time) ([]Binding, error)
+	// Bindings returns the unexpired bindings of one user, highest q first
+	Bindings(username string) ([]Binding, error)
+	// RegisteredBindings returns username -> unexpired bindings, highest q first
+	RegisteredBindings() (map[string][]Binding, error)
 }
 
-// RemovePendingSession removes a pending session based on storage type
-func (c *UAConfig) RemovePendingSession(callID string) error {
-	switch c.StorageType {
-	case StorageTypeDatabase:
-		return c.removePendingSessionFromDatabase(callID)
-
-	case StorageTypeFile:
-		return c.removePendingSessionFromFile(callID)
-
-	case StorageTypeMemory:
-		return c.removePendingSessionFromMemory(callID)
-
-	default:
-		// Default to memory
-		return c.removePendingSessionFromMemory(callID)
-	}
+// SessionStore keeps pending sessions between the INVITE and its ACK
+type SessionStore interface {
+	SavePendingSession(callID, clientRTPAddr string) error
+	GetPendingSession(callID string) (string, bool)
+	RemovePendingSession(callID string) error
+	// ExpirePendingSessions removes pending sessions saved before the given time and returns their Call-IDs
+	ExpirePendingSessions(before time.Time) ([]string, error)
 }
 
-// ==================== Call Storage ====================
-
-// SaveCall saves a call record based on storage type
-func (c *UAConfig) SaveCall(sipCall *models.SipCall) error {
-	switch c.StorageType {
-	case StorageTypeDatabase:
-		return c.saveCallToDatabase(sipCall)
-
-	case StorageTypeFile:
-		// File storage for calls should be handled by saveInviteToFile in func_register.go
-		return fmt.Errorf("file storage for calls should be handled by saveInviteToFile")
-
-	case StorageTypeMemory:
-		return c.saveCallToMemory(sipCall)
+// CallStore keeps call records
+type CallStore interface {
+	SaveCall(sipCall *models.SipCall) error
+	GetCall(callID string) (*models.SipCall, bool)
+	UpdateCallStatus(callID string, status models.SipCallStatus, answerTime *time.Time) error
+	SaveRecordURL(callID, recordURL string) error
+	UpdateCallMetadata(callID, key string, value interface{}) error
+	SaveTranscription(callID string, transcription *models.CallTranscription) error
+}
 
-	default:
-		// Default to memory
-		return c.saveCallToMemory(sipCall)
-	}
+// Storage is a storage backend for SIP state. Backend names it in storage metrics.
+type Storage interface {
+	Backend() StorageType
+	RegisterStore
+	SessionStore
+	CallStore
 }
 
-// GetCall gets a call record based on storage type
-func (c *UAConfig) GetCall(callID string) (*models.SipCall, bool) {
-	switch c.StorageType {
+// storage returns the backend serving requests: Storage when set, otherwise the built-in
+// backend for StorageType
+func (c *UAConfig) storage() Storage {
+	if c.Storage != nil {
+		return c.Storage
+	}
+	switch c.storageBackend() {
 	case StorageTypeDatabase:
-		return c.getCallFromDatabase(callID)
-
+		return databaseStorage{c}
 	case StorageTypeFile:
-		// File storage retrieval not implemented
-		return nil, false
-
-	case StorageTypeMemory:
-		return c.getCallFromMemory(callID)
-
-	default:
-		// Default to memory
-		return c.getCallFromMemory(callID)
-	}
-}
-
-// UpdateCallStatusInMemory updates call status in memory storage
-func (c *UAConfig) UpdateCallStatusInMemory(callID string, status models.SipCallStatus, answerTime *time.Time) {
-	c.memoryCallsMutex.Lock()
-	defer c.memoryCallsMutex.Unlock()
-	if call, exists := c.MemoryCalls[callID]; exists {
-		call.Status = status
-		if answerTime != nil {
-			call.AnswerTime = answerTime
+		return fileStorage{c}
+	case StorageTypeRedis:
+		// call records stay in the database, or in memory without one
+		if c.Db != nil {
+			return redisStorage{CallStore: databaseStorage{c}, c: c}
 		}
+		return redisStorage{CallStore: memoryStorage{c}, c: c}
+	default:
+		return memoryStorage{c}
 	}
 }
 
-// ==================== Active Session Storage ====================
-
-// SaveActiveSession saves an active session
-func (c *UAConfig) SaveActiveSession(callID string, session *SessionInfo) {
-	c.activeMutex.Lock()
-	defer c.activeMutex.Unlock()
-	c.ActiveSessions[callID] = session
+// SaveRegistration saves a registration to the configured storage
+func (c *UAConfig) SaveRegistration(info *RegistrationInfo) (err error) {
+	store := c.storage()
+	defer c.observeStorage(store.Backend(), "save_registration", info.Username, time.Now(), &err)
+	return store.SaveRegistration(info)
 }
 
-// GetActiveSession gets an active session
-func (c *UAConfig) GetActiveSession(callID string) (*SessionInfo, bool) {
-	c.activeMutex.RLock()
-	defer c.activeMutex.RUnlock()
-	session, exists := c.ActiveSessions[callID]
-	if !exists {
-		return nil, false
-	}
-	return session, true
-}
-
-// RemoveActiveSession removes an active session
-func (c *UAConfig) RemoveActiveSession(callID string) {
-	c.activeMutex.Lock()
-	defer c.activeMutex.Unlock()
-	delete(c.ActiveSessions, callID)
+// Bindings returns a user's bindings from the configured storage
+func (c *UAConfig) Bindings(username string) (bindings []Binding, err error) {
+	store := c.storage()
+	defer c.observeStorage(store.Backend(), "get_registration", username, time.Now(), &err)
+	return store.Bindings(username)
 }
 
-// ==================== Database Storage Implementation ====================
-
-func (c *UAConfig) savePendingSessionToDatabase(callID, clientRTPAddr string) error {
-	if c.Db == nil {
-		// Fallback to memory if database not configured
-		return c.savePendingSessionToMemory(callID, clientRTPAddr)
-	}
-	// Save to database
-	session := &models.SipSession{
-		CallID:        callID,
-		Status:        models.SipSessionStatusPending,
-		RemoteRTPAddr: clientRTPAddr,
-		CreatedTime:   time.Now(),
-	}
-	return models.CreateSipSession(c.Db, session)
+// RegisteredBindings returns the bindings of all registered users from the configured storage
+func (c *UAConfig) RegisteredBindings() (bindings map[string][]Binding, err error) {
+	store := c.storage()
+	defer c.observeStorage(store.Backend(), "list_registrations", "", time.Now(), &err)
+	return store.RegisteredBindings()
 }
 
-func (c *UAConfig) getPendingSessionFromDatabase(callID string) (string, bool) {
-	if c.Db == nil {
-		// Fallback to memory
-		return c.getPendingSessionFromMemory(callID)
-	}
-	// Get from database
-	session, err := models.GetSipSessionByCallID(c.Db, callID)
+// RegisteredContacts returns username -> host:port of each registered user's preferred binding
+func (c *UAConfig) RegisteredContacts() (map[string]string, error) {
+	bindings, err := c.RegisteredBindings()
 	if err != nil {
-		return "", false
-	}
-	if session.Status != models.SipSessionStatusPending {
-		return "", false
+		return nil, err
 	}
-	return session.RemoteRTPAddr, true
+	return preferredContacts(bindings), nil
 }
 
-func (c *UAConfig) removePendingSessionFromDatabase(callID string) error {
-	if c.Db == nil {
-		// Fallback to memory
-		return c.removePendingSessionFromMemory(callID)
-	}
-	// Delete from database
-	return models.DeleteSipSessionByCallID(c.Db, callID)
+// ExpireRegistrations removes bindings whose Expires has passed
+func (c *UAConfig) ExpireRegistrations(now time.Time) (expired []Binding, err error) {
+	store := c.storage()
+	defer c.observeStorage(store.Backend(), "expire_registrations", "", time.Now(), &err)
+	return store.ExpireRegistrations(now)
 }
 
-func (c *UAConfig) saveCallToDatabase(sipCall *models.SipCall) error {
-	if c.Db == nil {
-		// Fallback to memory
-		return c.saveCallToMemory(sipCall)
-	}
-	// Save to database
-	return models.CreateSipCall(c.Db, sipCall)
+// SavePendingSession saves a pending session to the configured storage
+func (c *UAConfig) SavePendingSession(callID, clientRTPAddr string) (err error) {
+	store := c.storage()
+	defer c.observeStorage(store.Backend(), "save_pending_session", callID, time.Now(), &err)
+	return store.SavePendingSession(callID, clientRTPAddr)
 }
 
-func (c *UAConfig) getCallFromDatabase(callID string) (*models.SipCall, bool) {
-	if c.Db == nil {
-		// Fallback to memory
-		return c.getCallFromMemory(callID)
-	}
-	// Get from database
-	call, err := models.GetSipCallByCallID(c.Db, callID)
-	if err != nil {
-		return nil, false
-	}
-	return call, true
-}
-
-// ==================== File Storage Implementation ====================
-
-func (c *UAConfig) savePendingSessionToFile(callID, clientRTPAddr string) error {
-	if err := os.MkdirAll(c.StoragePath, 0755); err != nil {
-		return fmt.Errorf("failed to create storage directory: %w", err)
-	}
-
-	sessionsDir := filepath.Join(c.StoragePath, "sessions")
-	if err := os.MkdirAll(sessionsDir, 0755); err != nil {
-		return fmt.Errorf("failed to create sessions directory: %w", err)
-	}
-
-	filePath := filepath.Join(sessionsDir, fmt.Sprintf("%s.json", callID))
-
-	sessionData := map[string]interface{}{
-		"callId":        callID,
-		"remoteRtpAddr": clientRTPAddr,
-		"status":        "pending",
-		"createdTime":   time.Now().Format(time.RFC3339),
-	}
-
-	jsonData, err := json.MarshalIndent(sessionData, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal session data: %w", err)
-	}
-
-	if err := os.WriteFile(filePath, jsonData, 0644); err != nil {
-		return fmt.Errorf("failed to write session file: %w", err)
-	}
-
-	return nil
+// GetPendingSession gets a pending session from the configured storage
+func (c *UAConfig) GetPendingSession(callID string) (string, bool) {
+	store := c.storage()
+	defer c.observeStorage(store.Backend(), "get_pending_session", callID, time.Now(), nil)
+	return store.GetPendingSession(callID)
 }
 
-func (c *UAConfig) getPendingSessionFromFile(callID string) (string, bool) {
-	sessionsDir := filepath.Join(c.StoragePath, "sessions")
-	filePath := filepath.Join(sessionsDir, fmt.Sprintf("%s.json", callID))
-
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return "", false
-	}
-
-	var sessionData map[string]interface{}
-	if err := json.Unmarshal(data, &sessionData); err != nil {
-		return "", false
-	}
-
-	addr, ok := sessionData["remoteRtpAddr"].(string)
-	return addr, ok
+// RemovePendingSession removes a pending session from the configured storage
+func (c *UAConfig) RemovePendingSession(callID string) (err error) {
+	store := c.storage()
+	defer c.observeStorage(store.Backend(), "remove_pending_session", callID, time.Now(), &err)
+	return store.RemovePendingSession(callID)
 }
 
-func (c *UAConfig) removePendingSessionFromFile(callID string) error {
-	sessionsDir := filepath.Join(c.StoragePath, "sessions")
-	filePath := filepath.Join(sessionsDir, fmt.Sprintf("%s.json", callID))
-	return os.Remove(filePath)
+// ExpirePendingSessions removes pending sessions that got no ACK or CANCEL before the given time
+func (c *UAConfig) ExpirePendingSessions(before time.Time) (expired []string, err error) {
+	store := c.storage()
+	defer c.observeStorage(store.Backend(), "expire_pending_sessions", "", time.Now(), &err)
+	return store.ExpirePendingSessions(before)
 }
 
-// ==================== Memory Storage Implementation ====================
+// ==================== Call Storage ====================
 
-func (c *UAConfig) savePendingSessionToMemory(callID, clientRTPAddr string) error {
-	c.sessionsMutex.Lock()
-	defer c.sessionsMutex.Unlock()
-	c.PendingSessions[callID] = clientRTPAddr
-	return nil
+// SaveCall saves a call record to the configured storage
+func (c *UAConfig) SaveCall(sipCall *models.SipCall) (err error) {
+	store := c.storage()
+	defer c.observeStorage(store.Backend(), "save_call", sipCall.CallID, time.Now(), &err)
+	return store.SaveCall(sipCall)
 }
 
-func (c *UAConfig) getPendingSessionFromMemory(callID string) (string, bool) {
-	c.sessionsMutex.RLock()
-	defer c.sessionsMutex.RUnlock()
-	addr, exists := c.PendingSessions[callID]
-	return addr, exists
+// GetCall gets a call record from the configured storage
+func (c *UAConfig) GetCall(callID string) (*models.SipCall, bool) {
+	store := c.storage()
+	defer c.observeStorage(store.Backend(), "get_call", callID, time.Now(), nil)
+	return store.GetCall(callID)
 }
 
-func (c *UAConfig) removePendingSessionFromMemory(callID string) error {
-	c.sessionsMutex.Lock()
-	defer c.sessionsMutex.Unlock()
-	delete(c.PendingSessions, callID)
-	return nil
+// UpdateCallStatus updates a call's status in the configured storage
+func (c *UAConfig) UpdateCallStatus(callID string, status models.SipCallStatus, answerTime *time.Time) (err error) {
+	store := c.storage()
+	defer c.observeStorage(store.Backend(), "update_call_status", callID, time.Now(), &err)
+	return store.UpdateCallStatus(callID, status, answerTime)
 }
 
-func (c *UAConfig) saveCallToMemory(sipCall *models.SipCall) error {
-	c.memoryCallsMutex.Lock()
-	defer c.memoryCallsMutex.Unlock()
-	callCopy := *sipCall
-	c.MemoryCalls[sipCall.CallID] = &callCopy
-	return nil
+// SaveRecordURL sets a call's recording URL in the configured storage
+func (c *UAConfig) SaveRecordURL(callID, recordURL string) (err error) {
+	store := c.storage()
+	defer c.observeStorage(store.Backend(), "save_recording_url", callID, time.Now(), &err)
+	return store.SaveRecordURL(callID, recordURL)
 }
 
-func (c *UAConfig) getCallFromMemory(callID string) (*models.SipCall, bool) {
-	c.memoryCallsMutex.RLock()
-	defer c.memoryCallsMutex.RUnlock()
-	call, exists := c.MemoryCalls[callID]
-	if !exists {
-		return nil, false
-	}
-	callCopy := *call
-	return &callCopy, true
+// UpdateCallMetadata sets one key in the call record's JSON metadata in the configured storage
+func (c *UAConfig) UpdateCallMetadata(callID, key string, value interface{}) (err error) {
+	store := c.storage()
+	defer c.observeStorage(store.Backend(), "update_call_metadata", callID, time.Now(), &err)
+	return store.UpdateCallMetadata(callID, key, value)
 }
 
-// SaveRegistrationToFile saves registration information to file
-func (c *UAConfig) SaveRegistrationToFile(info *RegistrationInfo) error {
-	// Ensure storage directory exists
-	if err := os.MkdirAll(c.StoragePath, 0755); err != nil {
-		return fmt.Errorf("failed to create storage directory: %w", err)
-	}
-
-	// Create file path: storagePath/registrations/username.json
-	registrationsDir := filepath.Join(c.StoragePath, "registrations")
-	if err := os.MkdirAll(registrationsDir, 0755); err != nil {
-		return fmt.Errorf("failed to create registrations directory: %w", err)
-	}
-
-	filePath := filepath.Join(registrationsDir, fmt.Sprintf("%s.json", info.Username))
+// SaveTranscription sets a call's recording transcription status and result in the configured storage
+func (c *UAConfig) SaveTranscription(callID string, transcription *models.CallTranscription) (err error) {
+	store := c.storage()
+	defer c.observeStorage(store.Backend(), "save_transcription", callID, time.Now(), &err)
+	return store.SaveTranscription(callID, transcription)
+}
 
-	// Prepare registration data
-	regData := map[string]interface{}{
-		"username":     info.Username,
-		"contact":      info.ContactStr,
-		"contactIP":    info.ContactIP,
-		"contactPort":  info.ContactPort,
-		"expires":      info.Expires,
-		"expiresAt":    time.Now().Add(time.Duration(info.Expires) * time.Second).Format(time.RFC3339),
-		"userAgent":    info.UserAgent,
-		"remoteIP":     info.RemoteIP,
-		"status":       "registered",
-		"lastRegister": time.Now().Format(time.RFC3339),
-	}
+// ==================== Active Session Storage ====================
 
-	// Marshal to JSON
-	jsonData, err := json.MarshalIndent(regData, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal registration data: %w", err)
+// SaveActiveSession saves an active session
+func (c *UAConfig) SaveActiveSession(callID string, session *SessionInfo) {
+	if session.StartedAt.IsZero() {
+		session.StartedAt = time.Now()
 	}
-
-	// Write to file
-	if err := os.WriteFile(filePath, jsonData, 0644); err != nil {
-		return fmt.Errorf("failed to write registration file: %w", err)
+	c.activeMutex.Lock()
+	c.ActiveSessions[callID] = session
+	c.activeMutex.Unlock()
+	if c.redisEnabled() {
+		c.saveSharedSession(callID, session)
 	}
-
-	return nil
 }
 
-// SaveRegistrationToDatabase saves registration information to database
-func (c *UAConfig) SaveRegistrationToDatabase(info *RegistrationInfo) error {
-	if c.Db == nil {
-		return fmt.Errorf("database not configured")
-	}
-
-	var sipUser models.SipUser
-	// Update user information
-	now := time.Now()
-	sipUser.Contact = info.ContactStr
-	sipUser.ContactIP = info.ContactIP
-	sipUser.ContactPort = info.ContactPort
-	sipUser.Expires = info.Expires
-	sipUser.Status = models.SipUserStatusRegistered
-	sipUser.LastRegister = &now
-	sipUser.RegisterCount++
-	sipUser.UserAgent = info.UserAgent
-	sipUser.RemoteIP = info.RemoteIP
-	sipUser.UpdateExpiresAt()
-
-	// Save to database
-	if err := c.Db.Save(&sipUser).Error; err != nil {
-		return fmt.Errorf("failed to update SIP user in database: %w", err)
+// GetActiveSession gets an active session
+func (c *UAConfig) GetActiveSession(callID string) (*SessionInfo, bool) {
+	c.activeMutex.RLock()
+	defer c.activeMutex.RUnlock()
+	session, exists := c.ActiveSessions[callID]
+	if !exists {
+		return nil, false
 	}
-
-	return nil
+	return session, true
 }
 
-// SaveInviteToFile saves INVITE call information to file
-func (c *UAConfig) SaveInviteToFile(sipCall *models.SipCall) error {
-	// Ensure storage directory exists
-	if err := os.MkdirAll(c.StoragePath, 0755); err != nil {
-		return fmt.Errorf("failed to create storage directory: %w", err)
-	}
-
-	// Create file path: storagePath/calls/callID.json
-	callsDir := filepath.Join(c.StoragePath, "calls")
-	if err := os.MkdirAll(callsDir, 0755); err != nil {
-		return fmt.Errorf("failed to create calls directory: %w", err)
-	}
-
-	filePath := filepath.Join(callsDir, fmt.Sprintf("%s.json", sipCall.CallID))
-
-	// Prepare call data
-	callData := map[string]interface{}{
-		"callId":        sipCall.CallID,
-		"direction":     string(sipCall.Direction),
-		"status":        string(sipCall.Status),
-		"fromUsername":  sipCall.FromUsername,
-		"fromUri":       sipCall.FromURI,
-		"fromIp":        sipCall.FromIP,
-		"toUsername":    sipCall.ToUsername,
-		"toUri":         sipCall.ToURI,
-		"localRtpAddr":  sipCall.LocalRTPAddr,
-		"remoteRtpAddr": sipCall.RemoteRTPAddr,
-		"startTime":     sipCall.StartTime.Format(time.RFC3339),
-	}
-
-	if sipCall.AnswerTime != nil {
-		callData["answerTime"] = sipCall.AnswerTime.Format(time.RFC3339)
-	}
-	if sipCall.EndTime != nil {
-		callData["endTime"] = sipCall.EndTime.Format(time.RFC3339)
-	}
-	if sipCall.Duration > 0 {
-		callData["duration"] = sipCall.Duration
-	}
-	if sipCall.ErrorCode != 0 {
-		callData["errorCode"] = sipCall.ErrorCode
-	}
-	if sipCall.ErrorMessage != "" {
-		callData["errorMessage"] = sipCall.ErrorMessage
-	}
-	if sipCall.RecordURL != "" {
-		callData["recordUrl"] = sipCall.RecordURL
-	}
-	if sipCall.Metadata != "" {
-		callData["metadata"] = sipCall.Metadata
-	}
-	if sipCall.Notes != "" {
-		callData["notes"] = sipCall.Notes
-	}
-
-	// Marshal to JSON
-	jsonData, err := json.MarshalIndent(callData, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal call data: %w", err)
-	}
-
-	// Write to file
-	if err := os.WriteFile(filePath, jsonData, 0644); err != nil {
-		return fmt.Errorf("failed to write call file: %w", err)
+// RemoveActiveSession removes an active session
+func (c *UAConfig) RemoveActiveSession(callID string) {
+	c.activeMutex.Lock()
+	delete(c.ActiveSessions, callID)
+	c.activeMutex.Unlock()
+	if c.redisEnabled() {
+		c.removeSharedSession(callID)
 	}
-
-	return nil
 }
 
-// SaveInviteToDatabase saves INVITE call information to database
-func (c *UAConfig) SaveInviteToDatabase(sipCall *models.SipCall) error {
-	if c.Db == nil {
-		return fmt.Errorf("database not configured")
-	}
-
-	if err := c.Db.Create(sipCall).Error; err != nil {
-		return fmt.Errorf("failed to create SIP call in database: %w", err)
+// ActiveSessionsStartedBefore returns the Call-IDs of active sessions started before the given time
+func (c *UAConfig) ActiveSessionsStartedBefore(before time.Time) []string {
+	c.activeMutex.RLock()
+	defer c.activeMutex.RUnlock()
+	var callIDs []string
+	for callID, session := range c.ActiveSessions {
+		if session.StartedAt.Before(before) {
+			callIDs = append(callIDs, callID)
+		}
 	}
-
-	return nil
+	return callIDs
 }
 
-// UpdateCallStatusInFile updates call status in file
-func (c *UAConfig) UpdateCallStatusInFile(callID string, status models.SipCallStatus, answerTime *time.Time) {
-	callsDir := filepath.Join(c.StoragePath, "calls")
-	filePath := filepath.Join(callsDir, fmt.Sprintf("%s.json", callID))
-
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		logrus.WithError(err).WithField("call_id", callID).Error("Failed to read call file for status update")
-		return
-	}
-
-	var callData map[string]interface{}
-	if err := json.Unmarshal(data, &callData); err != nil {
-		logrus.WithError(err).WithField("call_id", callID).Error("Failed to unmarshal call data")
-		return
-	}
-
-	callData["status"] = string(status)
-	if answerTime != nil {
-		callData["answerTime"] = answerTime.Format(time.RFC3339)
+// mergeMetadata sets key in a JSON object string, starting a new object when empty
+func mergeMetadata(existing, key string, value interface{}) (string, error) {
+	fields := make(map[string]interface{})
+	if existing != "" {
+		if err := json.Unmarshal([]byte(existing), &fields); err != nil {
+			return "", fmt.Errorf("failed to parse call metadata: %w", err)
+		}
 	}
-
-	jsonData, err := json.MarshalIndent(callData, "", "  ")
+	fields[key] = value
+	data, err := json.Marshal(fields)
 	if err != nil {
-		logrus.WithError(err).WithField("call_id", callID).Error("Failed to marshal call data")
-		return
-	}
-
-	if err := os.WriteFile(filePath, jsonData, 0644); err != nil {
-		logrus.WithError(err).WithField("call_id", callID).Error("Failed to write call file")
-	} else {
-		logrus.WithFields(logrus.Fields{
-			"call_id": callID,
-			"status":  status,
-		}).Info("Call status updated in file")
+		return "", fmt.Errorf("failed to marshal call metadata: %w", err)
 	}
+	return string(data), nil
 }
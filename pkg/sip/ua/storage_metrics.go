@@ -0,0 +1,140 @@
+package ua
+
+import (
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultSlowStorageThreshold is used when SlowStorageThreshold is not set
+const DefaultSlowStorageThreshold = 200 * time.Millisecond
+
+// StorageOpStats aggregates timings for one operation on one storage backend
+type StorageOpStats struct {
+	Backend       StorageType
+	Operation     string
+	Count         int64
+	Errors        int64
+	Slow          int64
+	TotalDuration time.Duration
+	MaxDuration   time.Duration
+}
+
+// AvgDuration returns the mean duration of the operation
+func (s StorageOpStats) AvgDuration() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.TotalDuration / time.Duration(s.Count)
+}
+
+// ErrorRate returns the fraction of calls that failed
+func (s StorageOpStats) ErrorRate() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Count)
+}
+
+type storageOpKey struct {
+	backend   StorageType
+	operation string
+}
+
+// storageBackend returns the backend that actually serves requests; database storage
+// without a connection falls back to memory
+func (c *UAConfig) storageBackend() StorageType {
+	switch c.StorageType {
+	case StorageTypeDatabase:
+		if c.Db == nil {
+			return StorageTypeMemory
+		}
+		return StorageTypeDatabase
+	case StorageTypeFile:
+		return StorageTypeFile
+	case StorageTypeRedis:
+		if c.Redis == nil {
+			return StorageTypeMemory
+		}
+		return StorageTypeRedis
+	default:
+		return StorageTypeMemory
+	}
+}
+
+// observeStorage records one storage operation and logs it when slower than the threshold.
+// Intended to be deferred: defer c.observeStorage(backend, "op", callID, time.Now(), &err)
+func (c *UAConfig) observeStorage(backend StorageType, operation, key string, start time.Time, errp *error) {
+	elapsed := time.Since(start)
+	var err error
+	if errp != nil {
+		err = *errp
+	}
+
+	threshold := c.SlowStorageThreshold
+	if threshold <= 0 {
+		threshold = DefaultSlowStorageThreshold
+	}
+	slow := elapsed >= threshold
+
+	c.storageStatsMutex.Lock()
+	if c.storageStats == nil {
+		c.storageStats = make(map[storageOpKey]*StorageOpStats)
+	}
+	k := storageOpKey{backend: backend, operation: operation}
+	stats, exists := c.storageStats[k]
+	if !exists {
+		stats = &StorageOpStats{Backend: backend, Operation: operation}
+		c.storageStats[k] = stats
+	}
+	stats.Count++
+	stats.TotalDuration += elapsed
+	if elapsed > stats.MaxDuration {
+		stats.MaxDuration = elapsed
+	}
+	if err != nil {
+		stats.Errors++
+	}
+	if slow {
+		stats.Slow++
+	}
+	c.storageStatsMutex.Unlock()
+
+	if slow {
+		entry := logrus.WithFields(logrus.Fields{
+			"backend":     backend,
+			"operation":   operation,
+			"key":         key,
+			"duration_ms": elapsed.Milliseconds(),
+		})
+		if err != nil {
+			entry = entry.WithError(err)
+		}
+		entry.Warn("Slow storage operation")
+	}
+}
+
+// StorageStats returns a snapshot of storage operation metrics, sorted by backend and operation
+func (c *UAConfig) StorageStats() []StorageOpStats {
+	c.storageStatsMutex.Lock()
+	defer c.storageStatsMutex.Unlock()
+
+	snapshot := make([]StorageOpStats, 0, len(c.storageStats))
+	for _, stats := range c.storageStats {
+		snapshot = append(snapshot, *stats)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		if snapshot[i].Backend != snapshot[j].Backend {
+			return snapshot[i].Backend < snapshot[j].Backend
+		}
+		return snapshot[i].Operation < snapshot[j].Operation
+	})
+	return snapshot
+}
+
+// TrackStorage runs fn as a timed storage operation, for callers that access the backend directly
+func (c *UAConfig) TrackStorage(backend StorageType, operation, key string, fn func() error) (err error) {
+	defer c.observeStorage(backend, operation, key, time.Now(), &err)
+	return fn()
+}
@@ -0,0 +1,185 @@
+package ua
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+)
+
+func TestStorageBackends(t *testing.T) {
+	tests := []struct {
+		name        string
+		storageType StorageType
+	}{
+		{"memory", StorageTypeMemory},
+		{"file", StorageTypeFile},
+		// database without a connection serves from memory
+		{"database without db", StorageTypeDatabase},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultUAConfig()
+			c.StorageType = tt.storageType
+			c.StoragePath = t.TempDir()
+
+			desk := &RegistrationInfo{Username: "alice", ContactStr: "sip:alice@10.0.0.4", ContactIP: "10.0.0.4", ContactPort: 5060, Expires: 3600, Q: 0.5}
+			info := &RegistrationInfo{Username: "alice", ContactStr: "sip:alice@10.0.0.5:5062", ContactIP: "10.0.0.5", ContactPort: 5062, Expires: 3600, Q: 1}
+			for _, binding := range []*RegistrationInfo{desk, info} {
+				if err := c.SaveRegistration(binding); err != nil {
+					t.Fatalf("SaveRegistration() error = %v", err)
+				}
+			}
+			contacts, err := c.RegisteredContacts()
+			if err != nil {
+				t.Fatalf("RegisteredContacts() error = %v", err)
+			}
+			if got := contacts["alice"]; got != "10.0.0.5:5062" {
+				t.Errorf("RegisteredContacts()[alice] = %q, want %q", got, "10.0.0.5:5062")
+			}
+			bindings, err := c.Bindings("alice")
+			if err != nil || len(bindings) != 2 || bindings[0].Contact != "10.0.0.5:5062" || bindings[1].Contact != "10.0.0.4:5060" {
+				t.Errorf("Bindings(alice) = %+v, %v, want both contacts with the higher q first", bindings, err)
+			}
+
+			// Expires 0 removes only the matching binding
+			if err := c.SaveRegistration(&RegistrationInfo{Username: "alice", ContactStr: desk.ContactStr, Expires: 0}); err != nil {
+				t.Fatalf("SaveRegistration() unregister error = %v", err)
+			}
+			if bindings, err := c.Bindings("alice"); err != nil || len(bindings) != 1 || bindings[0].URI != info.ContactStr {
+				t.Errorf("Bindings(alice) after unregistering one contact = %+v, %v", bindings, err)
+			}
+
+			bob := &RegistrationInfo{Username: "bob", ContactStr: "<sip:bob@10.0.0.6>", ContactIP: "10.0.0.6", ContactPort: 5060, Expires: 60}
+			if err := c.SaveRegistration(bob); err != nil {
+				t.Fatalf("SaveRegistration() error = %v", err)
+			}
+			if expired, err := c.ExpireRegistrations(time.Now()); err != nil || len(expired) != 0 {
+				t.Errorf("ExpireRegistrations() before expiry = %v, %v, want none", expired, err)
+			}
+			expiredBindings, err := c.ExpireRegistrations(time.Now().Add(2 * time.Minute))
+			if err != nil || len(expiredBindings) != 1 || expiredBindings[0].Username != "bob" {
+				t.Errorf("ExpireRegistrations() = %v, %v, want bob's binding", expiredBindings, err)
+			}
+
+			if err := c.SaveRegistration(&RegistrationInfo{Username: "alice", Wildcard: true}); err != nil {
+				t.Fatalf("SaveRegistration() wildcard error = %v", err)
+			}
+			contacts, err = c.RegisteredContacts()
+			if err != nil {
+				t.Fatalf("RegisteredContacts() error = %v", err)
+			}
+			if len(contacts) != 0 {
+				t.Errorf("RegisteredContacts() after unregister and expiry = %v, want none", contacts)
+			}
+
+			if err := c.SavePendingSession("call-1", "10.0.0.5:4000"); err != nil {
+				t.Fatalf("SavePendingSession() error = %v", err)
+			}
+			if addr, ok := c.GetPendingSession("call-1"); !ok || addr != "10.0.0.5:4000" {
+				t.Errorf("GetPendingSession() = %q, %v, want %q, true", addr, ok, "10.0.0.5:4000")
+			}
+			if err := c.RemovePendingSession("call-1"); err != nil {
+				t.Fatalf("RemovePendingSession() error = %v", err)
+			}
+			if _, ok := c.GetPendingSession("call-1"); ok {
+				t.Error("GetPendingSession() found a removed session")
+			}
+
+			if err := c.SavePendingSession("call-stale", "10.0.0.5:4002"); err != nil {
+				t.Fatalf("SavePendingSession() error = %v", err)
+			}
+			if expired, err := c.ExpirePendingSessions(time.Now().Add(-time.Minute)); err != nil || len(expired) != 0 {
+				t.Errorf("ExpirePendingSessions() before save = %v, %v, want none", expired, err)
+			}
+			expired, err := c.ExpirePendingSessions(time.Now().Add(time.Second))
+			if err != nil || len(expired) != 1 || expired[0] != "call-stale" {
+				t.Errorf("ExpirePendingSessions() = %v, %v, want [call-stale]", expired, err)
+			}
+			if _, ok := c.GetPendingSession("call-stale"); ok {
+				t.Error("GetPendingSession() found an expired session")
+			}
+
+			call := &models.SipCall{CallID: "call-1", Direction: models.SipCallDirectionInbound, Status: models.SipCallStatusRinging, StartTime: time.Now()}
+			if err := c.SaveCall(call); err != nil {
+				t.Fatalf("SaveCall() error = %v", err)
+			}
+			answered := time.Now()
+			if err := c.UpdateCallStatus("call-1", models.SipCallStatusAnswered, &answered); err != nil {
+				t.Fatalf("UpdateCallStatus() error = %v", err)
+			}
+			if err := c.SaveRecordURL("call-1", "/recordings/call-1.wav"); err != nil {
+				t.Fatalf("SaveRecordURL() error = %v", err)
+			}
+			if err := c.UpdateCallMetadata("call-1", "campaign", "spring"); err != nil {
+				t.Fatalf("UpdateCallMetadata() error = %v", err)
+			}
+
+			got, ok := c.GetCall("call-1")
+			if !ok {
+				t.Fatal("GetCall() did not find the saved call")
+			}
+			if got.Status != models.SipCallStatusAnswered || got.AnswerTime == nil {
+				t.Errorf("GetCall() status = %q, answer time %v, want answered", got.Status, got.AnswerTime)
+			}
+			if got.RecordURL != "/recordings/call-1.wav" {
+				t.Errorf("GetCall() RecordURL = %q", got.RecordURL)
+			}
+			if got.Metadata != `{"campaign":"spring"}` {
+				t.Errorf("GetCall() Metadata = %q", got.Metadata)
+			}
+		})
+	}
+}
+
+// recordingStorage is a Storage double that records the calls made to it
+type recordingStorage struct {
+	memoryStorage
+	calls []string
+}
+
+func (s *recordingStorage) Backend() StorageType { return "recording" }
+
+func (s *recordingStorage) SaveRegistration(info *RegistrationInfo) error {
+	s.calls = append(s.calls, "save_registration:"+info.Username)
+	return s.memoryStorage.SaveRegistration(info)
+}
+
+func (s *recordingStorage) SaveCall(sipCall *models.SipCall) error {
+	s.calls = append(s.calls, "save_call:"+sipCall.CallID)
+	return s.memoryStorage.SaveCall(sipCall)
+}
+
+func TestCustomStorage(t *testing.T) {
+	c := DefaultUAConfig()
+	c.StorageType = StorageTypeFile
+	store := &recordingStorage{memoryStorage: memoryStorage{c}}
+	c.Storage = store
+
+	if err := c.SaveRegistration(&RegistrationInfo{Username: "bob", ContactStr: "<sip:bob@10.0.0.6>", ContactIP: "10.0.0.6", ContactPort: 5060}); err != nil {
+		t.Fatalf("SaveRegistration() error = %v", err)
+	}
+	if err := c.SaveCall(&models.SipCall{CallID: "call-2"}); err != nil {
+		t.Fatalf("SaveCall() error = %v", err)
+	}
+	if _, ok := c.GetCall("call-2"); !ok {
+		t.Error("GetCall() did not find the call saved through the custom storage")
+	}
+
+	want := []string{"save_registration:bob", "save_call:call-2"}
+	if len(store.calls) != len(want) {
+		t.Fatalf("custom storage calls = %v, want %v", store.calls, want)
+	}
+	for i := range want {
+		if store.calls[i] != want[i] {
+			t.Errorf("custom storage call %d = %q, want %q", i, store.calls[i], want[i])
+		}
+	}
+
+	for _, stats := range c.StorageStats() {
+		if stats.Backend != "recording" {
+			t.Errorf("storage stats backend = %q, want %q", stats.Backend, "recording")
+		}
+	}
+}
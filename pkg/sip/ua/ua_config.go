@@ -1,13 +1,17 @@
 package ua
 
 import (
+	"fmt"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/LingByte/LingSIP/internal/models"
 	"github.com/emiago/sipgo/sip"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
@@ -18,6 +22,7 @@ const (
 	StorageTypeFile     StorageType = "file"     // storage by file
 	StorageTypeMemory   StorageType = "memory"   // storage by memory
 	StorageTypeDatabase StorageType = "database" // storage by database
+	StorageTypeRedis    StorageType = "redis"    // pending sessions, registrations and active sessions shared in redis
 )
 
 // UAConfig represents the configuration for a User Agent
@@ -41,21 +46,146 @@ type UAConfig struct {
 	MaxConcurrentSessions int           // max concurrent sessions
 	SessionTimeout        time.Duration // session timeout
 	NetworkInterface      string        // network interface
-	EnableICE             bool          // enable ice
+	EnableICE             bool          // answer INVITEs offering ICE with local candidates and run connectivity checks
 	StorageType           StorageType   // storage type
+	RejectUnknownSources  bool          // reject INVITEs not from an IP-authenticated trunk or registered contact
+	ProvisionalResponse   int           // provisional response sent before answering: 0 (none), 180 or 183
+	AnswerDelay           time.Duration // how long to ring before answering with 200 OK
 	Db                    *gorm.DB
-	RegisteredUsers       map[string]string // username -> Contact address (从 REGISTER 请求中获取)
+	RegisteredUsers       map[string][]Binding // username -> bindings, highest q first (从 REGISTER 请求中获取)
 	registerMutex         sync.RWMutex
 	StoragePath           string                     // storage path for file
 	PendingSessions       map[string]string          // Call-ID -> client RTP address (for memory storage)
 	sessionsMutex         sync.RWMutex               // Protects concurrent access to PendingSessions
+	pendingSince          map[string]time.Time       // Call-ID -> when the pending session was saved to memory
 	MemoryCalls           map[string]*models.SipCall // Call-ID -> SipCall (for memory storage)
 	memoryCallsMutex      sync.RWMutex               // Protects concurrent access to MemoryCalls
 	ActiveSessions        map[string]*SessionInfo    // Call-ID -> session info
 	activeMutex           sync.RWMutex
+
+	// codec priority for SDP answers, empty uses the built-in wideband-first order
+	Codecs models.CodecConfigs
+
+	// max inbound RTP packets held waiting for a reordered packet, zero uses the built-in default
+	JitterBufferDepth int
+	// gate VAD/ASR on inbound frames that correlate with recently played prompt audio
+	EchoSuppression bool
+
+	// file storage pruning: records older than FileRetention are removed, then the oldest
+	// until the total size is within FileMaxBytes; zero disables each limit
+	FileRetention  time.Duration
+	FileMaxBytes   int64
+	FileSync       bool // fsync each file write and its directory before returning
+	fileIndex      *fileIndex
+	fileIndexMutex sync.Mutex
+	fileRegMutex   sync.Mutex // serializes read-modify-write of registration files
+
+	// storage operations slower than this are logged, zero uses DefaultSlowStorageThreshold
+	SlowStorageThreshold time.Duration
+	storageStats         map[storageOpKey]*StorageOpStats
+	storageStatsMutex    sync.Mutex
+
+	// WAV file looped for parked, queued and transferring calls when the script gives none
+	HoldMusicFile string
+	// a call the remote side keeps on hold (re-INVITE with sendonly/inactive SDP) longer than
+	// HoldTimeout is hung up, zero waits until the remote resumes or hangs up
+	HoldTimeout time.Duration
+
+	// session timers (RFC 4028): SessionExpires is the interval requested on our INVITEs and the largest
+	// granted to the remote, MinSE the smallest accepted (smaller requests are answered 422). Zero SessionExpires
+	// only runs a timer when the remote asks for one, zero MinSE uses DefaultMinSE
+	SessionExpires time.Duration
+	MinSE          time.Duration
+
+	// MESSAGE (RFC 3428): received messages are stored; when MessageAutoReply is set they are answered by the LLM.
+	// MessageFrom is the user part of the From header on messages we send, empty uses DefaultMessageFrom
+	MessageAutoReply bool
+	MessageFrom      string
+
+	// registered contacts are probed with OPTIONS every KeepAliveInterval; a contact failing ProbeFailures
+	// probes in a row is marked unreachable and, with UnbindUnreachable, its binding is removed.
+	// Zero ProbeFailures uses DefaultProbeFailures
+	ProbeFailures     int
+	UnbindUnreachable bool
+
+	// after each script session the LLM writes a short summary and action items onto the session
+	// and publishes them as a session_summary monitor event
+	SessionSummary bool
+
+	// numbers on the do-not-call list are never dialed out; with DNCCheckInbound, script sessions of inbound calls
+	// from a listed number get do_not_call=true in their context so scripts can skip marketing steps
+	DNCCheckInbound bool
+
+	// every LLM request of a script session (system prompt, history and tools, as sent) is stored with its reply
+	// and linked to the step execution; LLMTranscriptRedaction is RedactNumbers, RedactContent or empty to store as sent
+	LLMTranscript          bool
+	LLMTranscriptRedaction string
+
+	// overload protection: INVITEs are answered 486 once MaxConcurrentSessions calls are in progress (negative
+	// disables the limit), and 503 with Retry-After while process CPU is above MaxCPUPercent of GOMAXPROCS or the
+	// shared RTP socket carries more than MaxRTPPacketRate packets per second (zero disables each).
+	// Zero OverloadRetryAfter uses DefaultOverloadRetryAfter
+	MaxCPUPercent      int
+	MaxRTPPacketRate   int
+	OverloadRetryAfter time.Duration
+
+	// inbound call queue: with CallQueueSize > 0, INVITEs over a concurrent call limit are answered and wait in a
+	// FIFO queue per limit (up to CallQueueSize callers each) listening to hold music and position announcements,
+	// then start their script in order as calls end. Callers are hung up after CallQueueTimeout (zero waits until
+	// they hang up); a full queue answers 486 as before
+	CallQueueSize    int
+	CallQueueTimeout time.Duration
+
+	// ICE (RFC 8445) for callers behind far NATs: with EnableICE, host candidates are always gathered and
+	// server reflexive and relay candidates through ICEServers (stun: and turn: URLs); ICEUsername and
+	// ICECredential authenticate to the TURN servers
+	ICEServers    []string
+	ICEUsername   string
+	ICECredential string
+
+	// largest accepted request body in bytes, larger requests are answered 413; zero uses DefaultMaxBodySize.
+	// Checked after sipgo has parsed the message: reads are bounded by the UDP datagram size (65535 bytes),
+	// this limit bounds what handlers are given
+	MaxBodySize int
+
+	// on shutdown active calls get DrainTimeout to finish, then hear ShutdownMessage and a BYE;
+	// zero DrainTimeout uses DefaultDrainTimeout, empty ShutdownMessage uses DefaultShutdownMessage
+	DrainTimeout    time.Duration
+	ShutdownMessage string
+
+	// while the database is unreachable its writes go to memory and a journal in JournalDir,
+	// replayed once a probe succeeds; zero DBProbeInterval uses DefaultDBProbeInterval
+	DBProbeInterval time.Duration
+	dbDegraded      bool
+	dbJournalMutex  sync.Mutex
+
+	// directory for the database and AI session journals, empty uses StoragePath
+	JournalDir string
+
+	// log every SIP message to per-call trace files in TraceDir from startup, togglable at runtime; empty TraceDir uses DefaultTraceDir
+	SIPTrace bool
+	TraceDir string
+
+	// uploaded prompts are normalized and stored here for use as step audio files; empty uses DefaultPromptDir
+	PromptDir string
+
+	// redis storage shares pending sessions, registrations and active-session state between
+	// instances with TTLs; call records still go to Db when set. Empty RedisKeyPrefix uses
+	// DefaultRedisKeyPrefix, empty InstanceID uses hostname:pid
+	Redis          *redis.Client
+	RedisKeyPrefix string
+	InstanceID     string
+
+	// concurrent inbound call limits per tenant (AIPhoneScript.Tenant), on top of the per-DID and
+	// per-script MaxConcurrentCalls; calls over a limit are answered 486
+	TenantCallLimits map[string]int
+
+	// replaces the built-in backend selected by StorageType, e.g. with a custom backend or a test double
+	Storage Storage
 }
 
 type SessionInfo struct {
+	StartedAt     time.Time // set by SaveActiveSession when zero
 	ClientRTPAddr *net.UDPAddr
 	StopRecording chan bool
 	DTMFChannel   chan string // DTMF 按键通道
@@ -87,7 +217,16 @@ func DefaultUAConfig() *UAConfig {
 		EnableICE:             false,
 		StorageType:           StorageTypeMemory,
 		StoragePath:           "./sip_data",
-		RegisteredUsers:       make(map[string]string),
+		MaxBodySize:           DefaultMaxBodySize,
+		TraceDir:              DefaultTraceDir,
+		PromptDir:             DefaultPromptDir,
+		DrainTimeout:          DefaultDrainTimeout,
+		ShutdownMessage:       DefaultShutdownMessage,
+		MinSE:                 DefaultMinSE,
+		MessageFrom:           DefaultMessageFrom,
+		ProbeFailures:         DefaultProbeFailures,
+		OverloadRetryAfter:    DefaultOverloadRetryAfter,
+		RegisteredUsers:       make(map[string][]Binding),
 		PendingSessions:       make(map[string]string),
 		MemoryCalls:           make(map[string]*models.SipCall),
 		ActiveSessions:        make(map[string]*SessionInfo),
@@ -154,9 +293,45 @@ func (c *UAConfig) ApplyDefaults() {
 		c.StoragePath = defaultConfig.StoragePath
 	}
 
+	if c.ProbeFailures == 0 {
+		c.ProbeFailures = defaultConfig.ProbeFailures
+	}
+
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = defaultConfig.MaxBodySize
+	}
+
+	if c.TraceDir == "" {
+		c.TraceDir = defaultConfig.TraceDir
+	}
+
+	if c.PromptDir == "" {
+		c.PromptDir = defaultConfig.PromptDir
+	}
+
+	if c.DrainTimeout == 0 {
+		c.DrainTimeout = defaultConfig.DrainTimeout
+	}
+
+	if c.OverloadRetryAfter == 0 {
+		c.OverloadRetryAfter = defaultConfig.OverloadRetryAfter
+	}
+
+	if c.ShutdownMessage == "" {
+		c.ShutdownMessage = defaultConfig.ShutdownMessage
+	}
+
+	if c.MinSE == 0 {
+		c.MinSE = defaultConfig.MinSE
+	}
+
+	if c.MessageFrom == "" {
+		c.MessageFrom = defaultConfig.MessageFrom
+	}
+
 	// Initialize registeredUsers map if not initialized
 	if c.RegisteredUsers == nil {
-		c.RegisteredUsers = make(map[string]string)
+		c.RegisteredUsers = make(map[string][]Binding)
 	}
 
 	// Initialize pendingSessions map if not initialized
@@ -175,36 +350,102 @@ func (c *UAConfig) ApplyDefaults() {
 	}
 }
 
-// GetSIPAddress returns the full SIP address
-func (c *UAConfig) GextSIPAddress() string {
-	return c.Host + ":" + string(rune(c.Port))
+// GetSIPAddress returns the full SIP address, IPv6 hosts are bracketed
+func (c *UAConfig) GetSIPAddress() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
 }
 
-// SetRegisteredUser sets a registered user's contact address
+// SetRegisteredUser replaces a user's bindings with one contact address that does not expire
 func (c *UAConfig) SetRegisteredUser(username, contact string) {
 	c.registerMutex.Lock()
 	defer c.registerMutex.Unlock()
-	c.RegisteredUsers[username] = contact
+	c.RegisteredUsers[username] = []Binding{{Username: username, Contact: contact, Q: DefaultBindingQ}}
 }
 
-// GetRegisteredUser gets a registered user's contact address
-func (c *UAConfig) GetRegisteredUser(username string) (string, bool) {
+// saveRegistrationToMemory applies a registration to the in-memory bindings
+func (c *UAConfig) saveRegistrationToMemory(info *RegistrationInfo, now time.Time) {
+	c.registerMutex.Lock()
+	defer c.registerMutex.Unlock()
+	bindings := applyRegistration(c.RegisteredUsers[info.Username], info, now)
+	if len(bindings) == 0 {
+		delete(c.RegisteredUsers, info.Username)
+		return
+	}
+	c.RegisteredUsers[info.Username] = bindings
+}
+
+// getBindingsFromMemory returns the unexpired in-memory bindings of a user
+func (c *UAConfig) getBindingsFromMemory(username string, now time.Time) []Binding {
 	c.registerMutex.RLock()
 	defer c.registerMutex.RUnlock()
-	contact, exists := c.RegisteredUsers[username]
-	return contact, exists
+	return unexpiredBindings(c.RegisteredUsers[username], now)
 }
 
-// RemoveRegisteredUser removes a registered user
+// listBindingsFromMemory returns the unexpired in-memory bindings of all users
+func (c *UAConfig) listBindingsFromMemory(now time.Time) map[string][]Binding {
+	c.registerMutex.RLock()
+	defer c.registerMutex.RUnlock()
+	users := make(map[string][]Binding, len(c.RegisteredUsers))
+	for username, bindings := range c.RegisteredUsers {
+		if live := unexpiredBindings(bindings, now); len(live) > 0 {
+			users[username] = live
+		}
+	}
+	return users
+}
+
+// expireRegistrationsInMemory removes in-memory bindings that expired by now and returns them
+func (c *UAConfig) expireRegistrationsInMemory(now time.Time) []Binding {
+	c.registerMutex.Lock()
+	defer c.registerMutex.Unlock()
+	var expired []Binding
+	for username, bindings := range c.RegisteredUsers {
+		live := unexpiredBindings(bindings, now)
+		if len(live) == len(bindings) {
+			continue
+		}
+		for _, binding := range bindings {
+			if binding.Expired(now) {
+				expired = append(expired, binding)
+			}
+		}
+		if len(live) == 0 {
+			delete(c.RegisteredUsers, username)
+		} else {
+			c.RegisteredUsers[username] = live
+		}
+	}
+	return expired
+}
+
+// GetRegisteredUser gets the contact address of a user's preferred (highest q) binding
+func (c *UAConfig) GetRegisteredUser(username string) (string, bool) {
+	bindings, err := c.Bindings(username)
+	if err != nil {
+		logrus.WithError(err).WithField("username", username).Warn("Failed to read registration")
+		return "", false
+	}
+	if len(bindings) == 0 {
+		return "", false
+	}
+	return bindings[0].Contact, true
+}
+
+// GetRegisteredUsers returns a snapshot of the preferred in-memory contact address of each registered user
+func (c *UAConfig) GetRegisteredUsers() map[string]string {
+	return preferredContacts(c.listBindingsFromMemory(time.Now()))
+}
+
+// RemoveRegisteredUser removes all bindings of a user
 func (c *UAConfig) RemoveRegisteredUser(username string) {
 	c.registerMutex.Lock()
 	defer c.registerMutex.Unlock()
 	delete(c.RegisteredUsers, username)
 }
 
-// GetRTPAddress returns the RTP address
+// GetRTPAddress returns the RTP address, IPv6 hosts are bracketed
 func (c *UAConfig) GetRTPAddress() string {
-	return c.Host + ":" + string(rune(c.LocalRTPPort))
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.LocalRTPPort))
 }
 
 // SetDBConfig set db config
@@ -220,7 +461,7 @@ type ConfigError struct {
 }
 
 func (e *ConfigError) Error() string {
-	return "Config Error [" + e.Field + " = " + e.Value.(string) + "]: " + e.Message
+	return "Config Error [" + e.Field + " = " + fmt.Sprint(e.Value) + "]: " + e.Message
 }
 
 // Validate validates the configuration
@@ -241,50 +482,183 @@ func (c *UAConfig) Validate() error {
 		return &ConfigError{Field: "MaxForwards", Value: c.MaxForwards, Message: "Max forwards must be greater than 0"}
 	}
 
+	// zero keeps the default from ApplyDefaults; negative would panic the keepalive ticker
+	if c.KeepAliveInterval < 0 {
+		return &ConfigError{Field: "KeepAliveInterval", Value: c.KeepAliveInterval, Message: "Keep alive interval must be greater than 0, or 0 for the default"}
+	}
+
+	if c.ProbeFailures < 0 {
+		return &ConfigError{Field: "ProbeFailures", Value: c.ProbeFailures, Message: "Probe failures must not be negative"}
+	}
+
+	switch c.ProvisionalResponse {
+	case 0, 180, 183:
+	default:
+		return &ConfigError{Field: "ProvisionalResponse", Value: c.ProvisionalResponse, Message: "Provisional response must be 0, 180 or 183"}
+	}
+
+	if c.AnswerDelay < 0 {
+		return &ConfigError{Field: "AnswerDelay", Value: c.AnswerDelay, Message: "Answer delay must not be negative"}
+	}
+
+	if c.FileRetention < 0 {
+		return &ConfigError{Field: "FileRetention", Value: c.FileRetention, Message: "File retention must not be negative"}
+	}
+
+	if c.FileMaxBytes < 0 {
+		return &ConfigError{Field: "FileMaxBytes", Value: c.FileMaxBytes, Message: "File storage size limit must not be negative"}
+	}
+
+	if c.JitterBufferDepth < 0 {
+		return &ConfigError{Field: "JitterBufferDepth", Value: c.JitterBufferDepth, Message: "Jitter buffer depth must not be negative"}
+	}
+
+	if c.DrainTimeout < 0 {
+		return &ConfigError{Field: "DrainTimeout", Value: c.DrainTimeout, Message: "Drain timeout must not be negative"}
+	}
+
+	if c.MinSE != 0 && c.MinSE < DefaultMinSE {
+		return &ConfigError{Field: "MinSE", Value: c.MinSE, Message: "Min-SE must be at least 90 seconds"}
+	}
+
+	if c.SessionExpires != 0 && c.SessionExpires < max(c.MinSE, DefaultMinSE) {
+		return &ConfigError{Field: "SessionExpires", Value: c.SessionExpires, Message: "Session expires must not be below Min-SE"}
+	}
+
+	if c.MaxBodySize < 0 {
+		return &ConfigError{Field: "MaxBodySize", Value: c.MaxBodySize, Message: "Max body size must not be negative"}
+	}
+
+	if c.MaxCPUPercent < 0 || c.MaxCPUPercent > 100 {
+		return &ConfigError{Field: "MaxCPUPercent", Value: c.MaxCPUPercent, Message: "Max CPU percent must be between 0-100"}
+	}
+
+	if c.MaxRTPPacketRate < 0 {
+		return &ConfigError{Field: "MaxRTPPacketRate", Value: c.MaxRTPPacketRate, Message: "Max RTP packet rate must not be negative"}
+	}
+
+	if c.OverloadRetryAfter < 0 {
+		return &ConfigError{Field: "OverloadRetryAfter", Value: c.OverloadRetryAfter, Message: "Overload Retry-After must not be negative"}
+	}
+
+	if c.CallQueueSize < 0 {
+		return &ConfigError{Field: "CallQueueSize", Value: c.CallQueueSize, Message: "Call queue size must not be negative"}
+	}
+
+	if c.CallQueueTimeout < 0 {
+		return &ConfigError{Field: "CallQueueTimeout", Value: c.CallQueueTimeout, Message: "Call queue timeout must not be negative"}
+	}
+
+	if c.LLMTranscriptRedaction != "" && c.LLMTranscriptRedaction != RedactNumbers && c.LLMTranscriptRedaction != RedactContent {
+		return &ConfigError{Field: "LLMTranscriptRedaction", Value: c.LLMTranscriptRedaction, Message: "LLM transcript redaction must be numbers, content or empty"}
+	}
+
+	if c.StorageType == StorageTypeRedis && c.Redis == nil {
+		return &ConfigError{Field: "Redis", Value: nil, Message: "Redis client is required for redis storage"}
+	}
+
 	return nil
 }
 
-// ExtractRegistrationInfo extracts registration information from SIP REGISTER request
+// ExtractRegistrationInfo extracts registration information of the first Contact from SIP REGISTER request
 func (c *UAConfig) ExtractRegistrationInfo(req *sip.Request) *RegistrationInfo {
-	info := &RegistrationInfo{
+	return c.ExtractRegistrations(req)[0]
+}
+
+// ExtractRegistrations extracts one RegistrationInfo per Contact of a SIP REGISTER request;
+// a REGISTER without Contact (a query) gives one RegistrationInfo without ContactStr.
+// ContactIP and ContactPort are where requests to the binding are sent: the Via received/rport
+// address when the client uses rport, otherwise the Contact host and port. IPv6 addresses in
+// RemoteIP and ContactIP are not bracketed
+func (c *UAConfig) ExtractRegistrations(req *sip.Request) []*RegistrationInfo {
+	base := RegistrationInfo{
 		Expires: 3600, // Default 1 hour
+		Q:       DefaultBindingQ,
 	}
 
 	// Extract username from From header
 	if from := req.From(); from != nil {
-		info.Username = from.Address.User
-	}
-
-	// Extract contact information
-	if contact := req.Contact(); contact != nil {
-		info.ContactStr = contact.Address.String()
-		info.ContactIP = contact.Address.Host
-		info.ContactPort = contact.Address.Port
-		if info.ContactPort == 0 {
-			info.ContactPort = 5060 // Default SIP port
-		}
+		base.Username = from.Address.User
 	}
 
 	// Extract expires from request
 	if expiresHeader := req.GetHeader("Expires"); expiresHeader != nil {
 		if expiresValue, err := strconv.Atoi(expiresHeader.Value()); err == nil {
-			info.Expires = expiresValue
+			base.Expires = expiresValue
 		}
 	}
 
 	// Extract User-Agent
 	if uaHeader := req.GetHeader("User-Agent"); uaHeader != nil {
-		info.UserAgent = uaHeader.Value()
+		base.UserAgent = uaHeader.Value()
 	}
 
-	// Extract remote IP from Via header
+	// Extract remote IP from Via header; a client asking for rport (RFC 3581) is behind NAT and
+	// reachable only at the received address and port, not the private address in its Contact
+	natPort := 0
 	if via := req.Via(); via != nil {
 		if received, exists := via.Params.Get("received"); exists && received != "" {
-			info.RemoteIP = received
+			base.RemoteIP = received
+			if rport, exists := via.Params.Get("rport"); exists {
+				natPort, _ = strconv.Atoi(rport)
+			}
 		} else if via.Host != "" {
-			info.RemoteIP = via.Host
+			base.RemoteIP = strings.Trim(via.Host, "[]")
+		}
+	}
+
+	var infos []*RegistrationInfo
+	for _, header := range req.GetHeaders("Contact") {
+		contact, ok := header.(*sip.ContactHeader)
+		if !ok {
+			continue
+		}
+		info := base
+		// sipgo parses "Contact: *" as the host "*"
+		if contact.Address.Wildcard || contact.Address.Host == "*" {
+			info.Wildcard = true
+		} else {
+			info.ContactStr = contact.Address.String()
+			info.ContactIP = strings.Trim(contact.Address.Host, "[]")
+			info.ContactPort = contact.Address.Port
+			if info.ContactPort == 0 {
+				info.ContactPort = 5060 // Default SIP port
+			}
+			if natPort > 0 {
+				info.ContactIP, info.ContactPort = info.RemoteIP, natPort
+			}
+		}
+		// The Contact expires parameter takes precedence over the Expires header (RFC 3261 10.2.1.1)
+		params := contactParams(contact)
+		if value, ok := params.Get("expires"); ok {
+			if expiresValue, err := strconv.Atoi(value); err == nil {
+				info.Expires = expiresValue
+			}
 		}
+		if value, ok := params.Get("q"); ok {
+			if q, err := strconv.ParseFloat(value, 64); err == nil && q >= 0 && q <= 1 {
+				info.Q = q
+			}
+		}
+		infos = append(infos, &info)
+	}
+	if len(infos) == 0 {
+		infos = append(infos, &base)
 	}
+	return infos
+}
 
-	return info
+// contactParams returns the parameters of a Contact. sipgo parses the parameters of an
+// entry of a comma separated Contact list up to the end of the header; such an entry keeps
+// only its last parameter, cut at the comma, as the rest belongs to the entries after it.
+func contactParams(contact *sip.ContactHeader) sip.HeaderParams {
+	for key, value := range contact.Params {
+		if strings.Contains(key, ",") {
+			return sip.NewParams()
+		}
+		if own, _, found := strings.Cut(value, ","); found {
+			return sip.HeaderParams{key: strings.TrimSpace(own)}
+		}
+	}
+	return contact.Params
 }
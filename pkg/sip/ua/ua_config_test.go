@@ -0,0 +1,217 @@
+package ua
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo/sip"
+)
+
+func TestAddressHelpers(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		port    int
+		rtpPort int
+		sip     string
+		rtp     string
+	}{
+		{"ipv4", "192.168.1.10", 5060, 10000, "192.168.1.10:5060", "192.168.1.10:10000"},
+		{"hostname", "sip.example.com", 5080, 20000, "sip.example.com:5080", "sip.example.com:20000"},
+		{"wildcard", "0.0.0.0", 65535, 1, "0.0.0.0:65535", "0.0.0.0:1"},
+		{"ipv6", "2001:db8::1", 5060, 10000, "[2001:db8::1]:5060", "[2001:db8::1]:10000"},
+		{"empty host", "", 5060, 10000, ":5060", ":10000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &UAConfig{Host: tt.host, Port: tt.port, LocalRTPPort: tt.rtpPort}
+			if got := c.GetSIPAddress(); got != tt.sip {
+				t.Errorf("GetSIPAddress() = %q, want %q", got, tt.sip)
+			}
+			if got := c.GetRTPAddress(); got != tt.rtp {
+				t.Errorf("GetRTPAddress() = %q, want %q", got, tt.rtp)
+			}
+		})
+	}
+}
+
+func TestExtractRegistrationInfo(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  string
+		contact  string
+		expires  int
+		wildcard bool
+	}{
+		{"default expires", "Contact: <sip:1001@192.168.1.10:5062>\r\n", "192.168.1.10", 3600, false},
+		{"expires header", "Contact: <sip:1001@192.168.1.10>\r\nExpires: 600\r\n", "192.168.1.10", 600, false},
+		{"contact param over header", "Contact: <sip:1001@192.168.1.10>;expires=120\r\nExpires: 600\r\n", "192.168.1.10", 120, false},
+		{"unregister", "Contact: <sip:1001@192.168.1.10>\r\nExpires: 0\r\n", "192.168.1.10", 0, false},
+		{"wildcard", "Contact: *\r\nExpires: 0\r\n", "", 0, true},
+		{"query", "", "", 3600, false},
+		{"first of several contacts", "Contact: <sip:1001@192.168.1.10>;q=0.7, <sip:1001@192.168.1.11>;q=0.3\r\n", "192.168.1.10", 3600, false},
+	}
+
+	config := &UAConfig{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := sip.ParseMessage([]byte("REGISTER sip:example.com SIP/2.0\r\n" +
+				"Via: SIP/2.0/UDP 192.168.1.10:5060;branch=z9hG4bK776asdhds\r\n" +
+				"From: <sip:1001@example.com>;tag=1928301774\r\n" +
+				"To: <sip:1001@example.com>\r\n" +
+				"Call-ID: a84b4c76e66710\r\n" +
+				"CSeq: 1 REGISTER\r\n" +
+				tt.headers +
+				"Content-Length: 0\r\n\r\n"))
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+			info := config.ExtractRegistrationInfo(msg.(*sip.Request))
+			if info.Username != "1001" {
+				t.Errorf("Username = %q, want %q", info.Username, "1001")
+			}
+			if info.ContactIP != tt.contact {
+				t.Errorf("ContactIP = %q, want %q", info.ContactIP, tt.contact)
+			}
+			if info.Expires != tt.expires {
+				t.Errorf("Expires = %d, want %d", info.Expires, tt.expires)
+			}
+			if info.Wildcard != tt.wildcard {
+				t.Errorf("Wildcard = %v, want %v", info.Wildcard, tt.wildcard)
+			}
+		})
+	}
+}
+
+func TestExtractRegistrations(t *testing.T) {
+	msg, err := sip.ParseMessage([]byte("REGISTER sip:example.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP 192.168.1.10:5060;branch=z9hG4bK776asdhds\r\n" +
+		"From: <sip:1001@example.com>;tag=1928301774\r\n" +
+		"To: <sip:1001@example.com>\r\n" +
+		"Call-ID: a84b4c76e66710\r\n" +
+		"CSeq: 1 REGISTER\r\n" +
+		"Contact: <sip:1001@192.168.1.10>;q=0.7\r\n" +
+		"Contact: <sip:1001@192.168.1.11>, <sip:1001@192.168.1.12>;q=2;expires=60\r\n" +
+		"Contact: <sip:1001@192.168.1.13>;q=0.2, <sip:1001@192.168.1.14>;expires=30\r\n" +
+		"Expires: 600\r\n" +
+		"Content-Length: 0\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("ParseMessage() error = %v", err)
+	}
+
+	infos := (&UAConfig{}).ExtractRegistrations(msg.(*sip.Request))
+	want := []struct {
+		ip      string
+		expires int
+		q       float64
+	}{
+		{"192.168.1.10", 600, 0.7},
+		{"192.168.1.11", 600, DefaultBindingQ},
+		// out of range q-values are ignored
+		{"192.168.1.12", 60, DefaultBindingQ},
+		{"192.168.1.13", 600, 0.2},
+		{"192.168.1.14", 30, DefaultBindingQ},
+	}
+	if len(infos) != len(want) {
+		t.Fatalf("ExtractRegistrations() returned %d contacts, want %d", len(infos), len(want))
+	}
+	for i, w := range want {
+		if infos[i].ContactIP != w.ip || infos[i].Expires != w.expires || infos[i].Q != w.q {
+			t.Errorf("contact %d = %s expires %d q %v, want %s expires %d q %v",
+				i, infos[i].ContactIP, infos[i].Expires, infos[i].Q, w.ip, w.expires, w.q)
+		}
+	}
+}
+
+func TestExtractRegistrationsBehindNAT(t *testing.T) {
+	tests := []struct {
+		name string
+		via  string
+		ip   string
+		port int
+	}{
+		{"rport", "192.168.1.10:5060;branch=z9hG4bK776asdhds;rport=40123;received=203.0.113.7", "203.0.113.7", 40123},
+		{"received without rport", "192.168.1.10:5060;branch=z9hG4bK776asdhds;received=203.0.113.7", "192.168.1.10", 5062},
+		{"not behind NAT", "192.168.1.10:5062;branch=z9hG4bK776asdhds", "192.168.1.10", 5062},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := sip.ParseMessage([]byte("REGISTER sip:example.com SIP/2.0\r\n" +
+				"Via: SIP/2.0/UDP " + tt.via + "\r\n" +
+				"From: <sip:1001@example.com>;tag=1928301774\r\n" +
+				"To: <sip:1001@example.com>\r\n" +
+				"Call-ID: a84b4c76e66710\r\n" +
+				"CSeq: 1 REGISTER\r\n" +
+				"Contact: <sip:1001@192.168.1.10:5062>\r\n" +
+				"Content-Length: 0\r\n\r\n"))
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+			info := (&UAConfig{}).ExtractRegistrationInfo(msg.(*sip.Request))
+			if info.ContactIP != tt.ip || info.ContactPort != tt.port {
+				t.Errorf("contact address = %s:%d, want %s:%d", info.ContactIP, info.ContactPort, tt.ip, tt.port)
+			}
+			if info.ContactStr != "sip:1001@192.168.1.10:5062" {
+				t.Errorf("ContactStr = %q, want the original Contact URI", info.ContactStr)
+			}
+		})
+	}
+}
+
+func TestExtractRegistrationsIPv6(t *testing.T) {
+	req := sip.NewRequest(sip.REGISTER, &sip.Uri{Host: "example.com"})
+	req.AppendHeader(&sip.ViaHeader{ProtocolName: "SIP", ProtocolVersion: "2.0", Transport: "UDP", Host: "[2001:db8::10]", Port: 5060, Params: sip.NewParams()})
+	req.AppendHeader(&sip.FromHeader{Address: sip.Uri{User: "1001", Host: "example.com"}, Params: sip.NewParams()})
+	req.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: "1001", Host: "[2001:db8::10]", Port: 5062}})
+
+	info := (&UAConfig{}).ExtractRegistrationInfo(req)
+	if info.ContactIP != "2001:db8::10" || info.ContactPort != 5062 {
+		t.Errorf("contact address = %s:%d, want 2001:db8::10:5062", info.ContactIP, info.ContactPort)
+	}
+	if info.RemoteIP != "2001:db8::10" {
+		t.Errorf("RemoteIP = %q, want 2001:db8::10", info.RemoteIP)
+	}
+	if binding := info.Binding(time.Now()); binding.Contact != "[2001:db8::10]:5062" {
+		t.Errorf("binding contact = %q, want [2001:db8::10]:5062", binding.Contact)
+	}
+}
+
+func TestValidateLivenessSettings(t *testing.T) {
+	tests := []struct {
+		name      string
+		keepAlive time.Duration
+		failures  int
+		field     string // 期望出错的字段，为空表示通过
+	}{
+		{"defaults", 0, 0, ""},
+		{"configured", 30 * time.Second, 5, ""},
+		{"negative keepalive", -time.Second, 0, "KeepAliveInterval"},
+		{"negative probe failures", 0, -1, "ProbeFailures"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultUAConfig()
+			c.KeepAliveInterval = tt.keepAlive
+			c.ProbeFailures = tt.failures
+			err := c.Validate()
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				// 0在ApplyDefaults后取默认值，保活定时器的间隔必须为正
+				c.ApplyDefaults()
+				if c.KeepAliveInterval <= 0 || c.ProbeFailures <= 0 {
+					t.Errorf("after ApplyDefaults keepalive = %v, probe failures = %d", c.KeepAliveInterval, c.ProbeFailures)
+				}
+				return
+			}
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) || configErr.Field != tt.field {
+				t.Errorf("Validate() error = %v, want a %s config error", err, tt.field)
+			}
+		})
+	}
+}
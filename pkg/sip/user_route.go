@@ -0,0 +1,297 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/LingByte/LingSIP/internal/models"
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/LingByte/LingSIP/pkg/sip/ua"
+	"github.com/emiago/sipgo/sip"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// userRouteRingTimeout 呼叫注册用户或前转目标的默认最长振铃时间
+	userRouteRingTimeout = 60 * time.Second
+	// forwardToScript 前转目标取该值（或"script:<号码>"）时转入AI脚本
+	forwardToScript = "script"
+)
+
+// userRoute 被叫是注册用户或配置了前转时的路由结果：answer为已接通的被叫腿，主叫应答后与之桥接；
+// 前转到AI脚本时answer为nil，scriptNumber为查找脚本使用的号码
+type userRoute struct {
+	username     string
+	from         sip.Uri
+	serverIP     string
+	answer       *ForkAnswer
+	rtpAddr      *net.UDPAddr
+	scriptNumber string
+}
+
+// routeInvite 按被叫用户的注册状态和前转规则路由呼入。被叫不是注册用户且没有前转时返回nil，由AI脚本接听；
+// 无法接通时向主叫回复最终响应并返回false
+func (as *SipServer) routeInvite(req *sip.Request, tx sip.ServerTransaction, serverIP string, codec rtpCodec) (*userRoute, bool) {
+	to := req.To()
+	if to == nil || to.Address.User == "" {
+		return nil, true
+	}
+	username := to.Address.User
+	bindings, err := as.config.Bindings(username)
+	if err != nil {
+		logger.Warn("Failed to look up bindings of callee", zap.String("username", username), zap.Error(err))
+	}
+	forwarding := as.callForwarding(username)
+	forwardTo := forwarding.Always
+	if forwardTo == "" && (len(bindings) == 0 || !as.IsUserReachable(username)) {
+		forwardTo = forwarding.Unreachable
+	}
+	if forwardTo == "" && len(bindings) == 0 {
+		return nil, true
+	}
+
+	callID := req.CallID().Value()
+	route := &userRoute{username: username, serverIP: serverIP}
+	if from := req.From(); from != nil {
+		route.from = from.Address
+	}
+	if err := tx.Respond(sip.NewResponseFromRequest(req, sip.StatusRinging, "Ringing", nil)); err != nil {
+		logger.Warn("Failed to send 180 Ringing", zap.String("call_id", callID), zap.Error(err))
+	}
+
+	// 主叫取消时停止振铃
+	callCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var cancelled atomic.Bool
+	go func() {
+		select {
+		case <-tx.Cancels():
+			cancelled.Store(true)
+			cancel()
+		case <-tx.Done():
+			cancelled.Store(true)
+			cancel()
+		case <-callCtx.Done():
+		}
+	}()
+
+	if forwardTo == "" {
+		logger.Info("Routing call to registered user", zap.String("call_id", callID), zap.String("username", username))
+		timeout := time.Duration(forwarding.NoAnswerTimeout) * time.Second
+		err = as.ringRoute(callCtx, route, timeout, func(ctx context.Context) (*ForkAnswer, error) {
+			return as.InviteUser(ctx, username, func(binding ua.Binding) *sip.Request {
+				recipient := to.Address
+				if err := parseURI(binding.URI, &recipient); err != nil {
+					recipient = to.Address
+				}
+				return as.newUserRouteInvite(req, recipient, to.Address, serverIP, codec)
+			})
+		})
+		if err != nil && !cancelled.Load() {
+			forwardTo = forwardTarget(forwarding, err)
+		}
+	}
+
+	if forwardTo != "" && !cancelled.Load() {
+		logger.Info("Forwarding call",
+			zap.String("call_id", callID),
+			zap.String("username", username),
+			zap.String("forward_to", forwardTo),
+			zap.NamedError("cause", err))
+		if number, ok := scriptForwardNumber(forwardTo, username); ok {
+			route.scriptNumber = number
+			return route, true
+		}
+		err = as.forwardRoute(callCtx, req, route, forwardTo, time.Duration(forwarding.ForwardTimeout)*time.Second, codec)
+	}
+
+	if cancelled.Load() {
+		if route.answer != nil {
+			as.abortUserRoute(route)
+		}
+		logger.Info("INVITE cancelled while routing to registered user", zap.String("call_id", callID), zap.String("username", username))
+		if err := tx.Respond(sip.NewResponseFromRequest(req, sip.StatusRequestTerminated, "Request Terminated", nil)); err != nil {
+			logger.Warn("Failed to send 487 Request Terminated", zap.String("call_id", callID), zap.Error(err))
+		}
+		return nil, false
+	}
+	if err != nil {
+		code, reason := referFailureStatus(err)
+		logger.Info("Registered user did not answer",
+			zap.String("call_id", callID),
+			zap.String("username", username),
+			zap.Int("status", int(code)),
+			zap.Error(err))
+		if err := tx.Respond(sip.NewResponseFromRequest(req, code, reason, nil)); err != nil {
+			logger.Warn("Failed to send final response", zap.String("call_id", callID), zap.Error(err))
+		}
+		return nil, false
+	}
+	return route, true
+}
+
+// ringRoute 在timeout（0使用默认值）内呼叫被叫腿，接通后记录到route；应答没有可用的SDP时挂断被叫腿
+func (as *SipServer) ringRoute(callCtx context.Context, route *userRoute, timeout time.Duration, ring func(ctx context.Context) (*ForkAnswer, error)) error {
+	if timeout <= 0 {
+		timeout = userRouteRingTimeout
+	}
+	ctx, cancel := context.WithTimeout(callCtx, timeout)
+	defer cancel()
+	answer, err := ring(ctx)
+	if err != nil {
+		return err
+	}
+	rtpAddr, err := referAnswerRTPAddr(answer.Response)
+	if err != nil {
+		if byeErr := as.sendBye(answer.Dialog.CallID); byeErr != nil {
+			logger.Warn("Failed to hang up routed call", zap.String("call_id", answer.Dialog.CallID), zap.Error(byeErr))
+		}
+		return err
+	}
+	route.answer, route.rtpAddr = answer, rtpAddr
+	return nil
+}
+
+// forwardRoute 呼叫前转目标，号码和用户名补全为主叫所在主机上的地址；前转目标同样受拨号规则限制，
+// 不再应用目标用户自己的前转规则，避免循环
+func (as *SipServer) forwardRoute(callCtx context.Context, req *sip.Request, route *userRoute, forwardTo string, timeout time.Duration, codec rtpCodec) error {
+	var target sip.Uri
+	if err := parseURI(normalizeTarget(forwardTo, route.from), &target); err != nil {
+		return fmt.Errorf("invalid forwarding target %q: %w", forwardTo, err)
+	}
+	if as.trunkManager != nil && target.User != "" {
+		if err := as.trunkManager.CheckDestination(0, target.User); err != nil {
+			return err
+		}
+	}
+	return as.ringRoute(callCtx, route, timeout, func(ctx context.Context) (*ForkAnswer, error) {
+		return as.inviteTarget(ctx, target, func(recipient sip.Uri) *sip.Request {
+			return as.newUserRouteInvite(req, recipient, target, route.serverIP, codec)
+		})
+	})
+}
+
+// forwardTarget 振铃本用户失败后的前转目标：遇忙、无应答，其余失败按不可达
+func forwardTarget(cf models.CallForwarding, err error) string {
+	var rejected *inviteRejectedError
+	switch {
+	case errors.As(err, &rejected) && (rejected.res.StatusCode == sip.StatusBusyHere || rejected.res.StatusCode == sip.StatusGlobalBusyEverywhere):
+		return cf.Busy
+	case errors.Is(err, context.DeadlineExceeded):
+		return cf.NoAnswer
+	default:
+		return cf.Unreachable
+	}
+}
+
+// scriptForwardNumber 前转目标为AI脚本时返回查找脚本的号码，"script"使用被叫号码
+func scriptForwardNumber(forwardTo, callee string) (string, bool) {
+	if forwardTo == forwardToScript {
+		return callee, true
+	}
+	number, ok := strings.CutPrefix(forwardTo, forwardToScript+":")
+	return number, ok && number != ""
+}
+
+// callForwarding 读取启用的SIP用户的前转规则，未配置数据库或用户不存在时为空
+func (as *SipServer) callForwarding(username string) models.CallForwarding {
+	if as.config.Db == nil {
+		return models.CallForwarding{}
+	}
+	sipUser, err := models.GetSipUserByUsername(as.config.Db, username)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Warn("Failed to load call forwarding", zap.String("username", username), zap.Error(err))
+		}
+		return models.CallForwarding{}
+	}
+	if !sipUser.Enabled {
+		return models.CallForwarding{}
+	}
+	return sipUser.Forwarding
+}
+
+// newUserRouteInvite 创建发往被叫联系地址或前转目标的INVITE，主叫身份沿用来电的From
+func (as *SipServer) newUserRouteInvite(req *sip.Request, recipient, toURI sip.Uri, serverIP string, codec rtpCodec) *sip.Request {
+	invite := sip.NewRequest(sip.INVITE, &recipient)
+
+	from := &sip.FromHeader{Params: sip.NewParams()}
+	if header := req.From(); header != nil {
+		from.DisplayName = header.DisplayName
+		from.Address = header.Address
+	}
+	from.Params.Add("tag", sip.GenerateTagN(16))
+	to := &sip.ToHeader{Address: toURI, Params: sip.NewParams()}
+	callID := sip.CallIDHeader(uuid.NewString())
+	invite.AppendHeader(from)
+	invite.AppendHeader(to)
+	invite.AppendHeader(&callID)
+	invite.AppendHeader(&sip.CSeqHeader{SeqNo: 1, MethodName: sip.INVITE})
+	invite.AppendHeader(&sip.ContactHeader{Address: sip.Uri{Host: uriHost(serverIP), Port: as.config.Port}})
+
+	sdp := []byte(generateSDP(serverIP, as.config.LocalRTPPort, codec))
+	contentType := sip.ContentTypeHeader("application/sdp")
+	invite.AppendHeader(&contentType)
+	invite.SetBody(sdp)
+	return invite
+}
+
+// setScriptNumber 记录前转到AI脚本的通话查找脚本使用的号码，ACK后启动脚本时取出
+func (as *SipServer) setScriptNumber(callID, number string) {
+	as.bridgeMutex.Lock()
+	defer as.bridgeMutex.Unlock()
+	as.scriptNumbers[callID] = number
+}
+
+// takeScriptNumber 取出并清除通话前转到的AI脚本号码，没有前转时为空
+func (as *SipServer) takeScriptNumber(callID string) string {
+	as.bridgeMutex.Lock()
+	defer as.bridgeMutex.Unlock()
+	number := as.scriptNumbers[callID]
+	delete(as.scriptNumbers, callID)
+	return number
+}
+
+// startUserRoute 主叫应答后保存被叫腿并桥接两条腿的RTP，任意一方挂断时另一方随之挂断
+func (as *SipServer) startUserRoute(callID string, route *userRoute, callerRTPAddr string) {
+	agentID := route.answer.Dialog.CallID
+	callerAddr, err := net.ResolveUDPAddr("udp", callerRTPAddr)
+	if err != nil {
+		logger.Warn("Failed to resolve caller RTP address of routed call", zap.String("call_id", callID), zap.Error(err))
+		as.abortUserRoute(route)
+		as.hangupCall(callID)
+		return
+	}
+
+	codec := as.getCallCodec(callID)
+	as.saveCallCodec(agentID, codec)
+	as.saveReferredCall(route.from, route.serverIP, route.answer, route.rtpAddr, fmt.Sprintf("routed from %s", callID))
+	as.startRTCP(agentID, route.rtpAddr, codec)
+	as.addBridge(&callBridge{
+		callerID:  callID,
+		agentID:   agentID,
+		target:    route.answer.Dialog.RemoteURI.String(),
+		caller:    callerAddr,
+		agent:     route.rtpAddr,
+		startedAt: time.Now(),
+	})
+	logger.Info("Call routed to registered user",
+		zap.String("call_id", callID),
+		zap.String("agent_call_id", agentID),
+		zap.String("username", route.username),
+		zap.String("contact", route.answer.Binding.Contact))
+}
+
+// abortUserRoute 主叫未能应答时挂断已接通的被叫腿
+func (as *SipServer) abortUserRoute(route *userRoute) {
+	if err := as.sendBye(route.answer.Dialog.CallID); err != nil {
+		logger.Warn("Failed to hang up routed call", zap.String("call_id", route.answer.Dialog.CallID), zap.Error(err))
+	}
+}
@@ -3,6 +3,7 @@ package sip1
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,10 +29,10 @@ func ParseSDPForRTPAddress(sdpBody string) (string, error) {
 			continue
 		}
 
-		// Find connection information c=IN IP4 x.x.x.x
+		// Find connection information c=IN IP4 x.x.x.x or c=IN IP6 x:x::x
 		if strings.HasPrefix(line, "c=") {
 			parts := strings.Fields(line[2:])
-			if len(parts) >= 3 && parts[0] == "IN" && parts[1] == "IP4" {
+			if len(parts) >= 3 && parts[0] == "IN" && (parts[1] == "IP4" || parts[1] == "IP6") {
 				if foundMedia {
 					// Media-level connection information
 					mediaIP = parts[2]
@@ -61,31 +62,18 @@ func ParseSDPForRTPAddress(sdpBody string) (string, error) {
 		return "", fmt.Errorf("failed to parse IP and port from SDP: IP=%s, Port=%s", ip, port)
 	}
 
-	return fmt.Sprintf("%s:%s", ip, port), nil
+	return net.JoinHostPort(ip, port), nil
 }
 
+// getServerIPFromRequest 本机用于SDP和Contact的地址，与请求来源的地址族相同。
+// 顶层Via的received是对端的源地址（见markReceived），不能使用
 func getServerIPFromRequest(req *sip.Request) string {
-	// Try to get server IP from Via header's received parameter
-	if via := req.Via(); via != nil {
-		if received, exists := via.Params.Get("received"); exists && received != "" {
-			ip := received
-			if net.ParseIP(ip) != nil {
-				logrus.WithField("ip", ip).Info("Using IP from Via header received parameter")
-				return ip
-			}
-		}
-	}
-
-	// If no received parameter in Via header, use local IP address
-	// This is the fallback when the SIP proxy/server doesn't add the received parameter
-	localIP := getLocalIP()
+	host, _, _ := net.SplitHostPort(req.Source())
+	localIP := localIPFor(host)
 	if localIP == "" {
 		logrus.Warn("Failed to get local IP, using 127.0.0.1 as fallback")
 		localIP = "127.0.0.1"
-	} else {
-		logrus.WithField("ip", localIP).Info("Using local IP address (Via received parameter not available)")
 	}
-
 	return localIP
 }
 
@@ -104,10 +92,66 @@ func getLocalIP() string {
 	return ""
 }
 
-func generateSDP(serverIP string, rtpPort int) string {
+// getLocalIPv6 本机的全局IPv6地址，不含链路本地地址
+func getLocalIPv6() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() == nil && ipNet.IP.IsGlobalUnicast() {
+			return ipNet.IP.String()
+		}
+	}
+	return ""
+}
+
+// localIPFor 与对端地址族相同的本机地址，对端为IPv6而本机没有IPv6地址时使用IPv4地址
+func localIPFor(remoteHost string) string {
+	if ip := hostIP(remoteHost); ip != nil && ip.To4() == nil {
+		if localIP := getLocalIPv6(); localIP != "" {
+			return localIP
+		}
+	}
+	return getLocalIP()
+}
+
+// generateSDP 生成应答SDP，extra追加在音频媒体属性的sendrecv之前，如ICE的ice-ufrag、ice-pwd和候选
+func generateSDP(serverIP string, rtpPort int, codec rtpCodec, extra ...sdp.Attribute) string {
 	// Use pion/sdp library to generate standard SDP response
 	sessionID := time.Now().Unix()
 
+	attributes := []sdp.Attribute{{Key: "rtpmap", Value: codec.rtpmap()}}
+	if fmtp := codec.fmtp(); fmtp != "" {
+		attributes = append(attributes, sdp.Attribute{Key: "fmtp", Value: fmtp})
+	}
+	payloadType := strconv.Itoa(int(codec.PayloadType))
+	formats := []string{payloadType}
+
+	// 对端提供了telephone-event时按其载荷类型应答
+	var eventLines string
+	if codec.TelephoneEvent != 0 {
+		eventPT := strconv.Itoa(int(codec.TelephoneEvent))
+		formats = append(formats, eventPT)
+		attributes = append(attributes,
+			sdp.Attribute{Key: "rtpmap", Value: codec.telephoneEventRTPMap()},
+			sdp.Attribute{Key: "fmtp", Value: eventPT + " 0-16"})
+		eventLines = "a=rtpmap:" + codec.telephoneEventRTPMap() + "\r\n" +
+			"a=fmtp:" + eventPT + " 0-16\r\n"
+	}
+	// 对端提供了CN时一并应答，监听期间可以发送CN包
+	if codec.ComfortNoise != 0 {
+		formats = append(formats, strconv.Itoa(int(codec.ComfortNoise)))
+		attributes = append(attributes, sdp.Attribute{Key: "rtpmap", Value: codec.comfortNoiseRTPMap()})
+		eventLines += "a=rtpmap:" + codec.comfortNoiseRTPMap() + "\r\n"
+	}
+	for _, attribute := range extra {
+		attributes = append(attributes, attribute)
+		eventLines += "a=" + attribute.String() + "\r\n"
+	}
+	attributes = append(attributes, sdp.Attribute{Key: "sendrecv", Value: ""})
+
+	addressType := sdpAddressType(serverIP)
 	session := sdp.SessionDescription{
 		Version: 0,
 		Origin: sdp.Origin{
@@ -115,13 +159,13 @@ func generateSDP(serverIP string, rtpPort int) string {
 			SessionID:      uint64(sessionID),
 			SessionVersion: uint64(sessionID),
 			NetworkType:    "IN",
-			AddressType:    "IP4",
+			AddressType:    addressType,
 			UnicastAddress: serverIP,
 		},
 		SessionName: "SIP Call",
 		ConnectionInformation: &sdp.ConnectionInformation{
 			NetworkType: "IN",
-			AddressType: "IP4",
+			AddressType: addressType,
 			Address:     &sdp.Address{Address: serverIP},
 		},
 		TimeDescriptions: []sdp.TimeDescription{
@@ -133,12 +177,9 @@ func generateSDP(serverIP string, rtpPort int) string {
 					Media:   "audio",
 					Port:    sdp.RangedPort{Value: rtpPort},
 					Protos:  []string{"RTP", "AVP"},
-					Formats: []string{"0"},
-				},
-				Attributes: []sdp.Attribute{
-					{Key: "rtpmap", Value: "0 PCMU/8000/1"},
-					{Key: "sendrecv", Value: ""},
+					Formats: formats,
 				},
+				Attributes: attributes,
 			},
 		},
 	}
@@ -149,14 +190,15 @@ func generateSDP(serverIP string, rtpPort int) string {
 		logrus.WithError(err).Warn("Failed to generate SDP, using fallback method")
 		// If serialization fails, use string concatenation as fallback
 		return fmt.Sprintf("v=0\r\n"+
-			"o=- %d %d IN IP4 %s\r\n"+
+			"o=- %d %d IN %s %s\r\n"+
 			"s=SIP Call\r\n"+
-			"c=IN IP4 %s\r\n"+
+			"c=IN %s %s\r\n"+
 			"t=0 0\r\n"+
-			"m=audio %d RTP/AVP 0\r\n"+
-			"a=rtpmap:0 PCMU/8000/1\r\n"+
+			"m=audio %d RTP/AVP %s\r\n"+
+			"a=rtpmap:%s\r\n"+
+			"%s"+
 			"a=sendrecv\r\n",
-			sessionID, sessionID, serverIP, serverIP, rtpPort)
+			sessionID, sessionID, addressType, serverIP, addressType, serverIP, rtpPort, strings.Join(formats, " "), codec.rtpmap(), eventLines)
 	}
 
 	return string(sdpBytes)
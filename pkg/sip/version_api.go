@@ -0,0 +1,79 @@
+package sip1
+
+import (
+	"github.com/LingByte/LingSIP/pkg/buildinfo"
+	"github.com/LingByte/LingSIP/pkg/config"
+	"github.com/LingByte/LingSIP/pkg/features"
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// VersionInfo 版本信息和本次部署启用的功能
+type VersionInfo struct {
+	buildinfo.Info
+	Features BuildFeatures `json:"features"`
+}
+
+// BuildFeatures 启用的传输能力、支持的服务商和功能开关
+type BuildFeatures struct {
+	TLS          bool            `json:"tls"`   // SIP over TLS
+	HTTPS        bool            `json:"https"` // HTTP API over TLS
+	ICE          bool            `json:"ice"`
+	ASRProviders []string        `json:"asrProviders"`
+	TTSProviders []string        `json:"ttsProviders"`
+	ASRProvider  string          `json:"asrProvider"` // 当前使用的服务商
+	ASRDialects  []string        `json:"asrDialects"` // 当前服务商可识别的方言，脚本的dialect从中选择
+	TTSProvider  string          `json:"ttsProvider"`
+	LLMProvider  string          `json:"llmProvider"`
+	Flags        map[string]bool `json:"flags"`
+}
+
+// Version 返回版本信息和启用的功能
+func (as *SipServer) Version() VersionInfo {
+	info := VersionInfo{
+		Info: buildinfo.Get(),
+		Features: BuildFeatures{
+			TLS:          as.config.EnableTLS,
+			ICE:          as.config.EnableICE,
+			ASRProviders: ASRProviders,
+			TTSProviders: TTSProviders,
+			Flags:        features.Snapshot(),
+		},
+	}
+	if cfg := config.GlobalConfig; cfg != nil {
+		info.Features.HTTPS = cfg.Server.SSLEnabled
+		info.Features.ASRProvider = cfg.Services.ASR.Provider
+		info.Features.ASRDialects = ASRDialects(cfg.Services.ASR)
+		info.Features.TTSProvider = cfg.Services.TTS.Provider
+		info.Features.LLMProvider = cfg.Services.LLM.Provider
+	}
+	return info
+}
+
+// RegisterVersionAPIs 注册版本和功能开关接口：GET /version 查看版本和启用的功能，
+// GET /features 查看所有功能开关，PUT /features {"name":"barge_in","enabled":false} 运行时切换开关（重启后恢复为环境变量的值）
+func RegisterVersionAPIs(r gin.IRoutes, server *SipServer) {
+	r.GET("/version", func(c *gin.Context) {
+		response.Success(c, "ok", server.Version())
+	})
+
+	r.GET("/features", func(c *gin.Context) {
+		response.Success(c, "ok", features.All())
+	})
+
+	r.PUT("/features", func(c *gin.Context) {
+		var form struct {
+			Name    string `json:"name" binding:"required"`
+			Enabled *bool  `json:"enabled" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		if err := features.Set(form.Name, *form.Enabled); err != nil {
+			response.Fail(c, "failed to set feature flag", err.Error())
+			return
+		}
+		response.Success(c, "ok", features.All())
+	})
+}
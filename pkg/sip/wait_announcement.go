@@ -0,0 +1,116 @@
+package sip1
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// waitAnnounceInterval 排队等待期间播报预计等待时间的间隔
+	waitAnnounceInterval = time.Minute
+	// waitSampleSize 按每个队列最近接通的呼叫数计算平均等待时长
+	waitSampleSize = 50
+)
+
+// WaitStats 按队列统计排队呼叫接通前的实际等待时长
+type WaitStats struct {
+	mutex sync.Mutex
+	waits map[string][]time.Duration // 队列 -> 最近的等待时长
+}
+
+// NewWaitStats 创建等待时长统计
+func NewWaitStats() *WaitStats {
+	return &WaitStats{waits: make(map[string][]time.Duration)}
+}
+
+// Record 记录一次排队呼叫接通前的等待时长，只保留最近waitSampleSize个
+func (s *WaitStats) Record(queue string, wait time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	waits := append(s.waits[queue], wait)
+	if len(waits) > waitSampleSize {
+		waits = waits[len(waits)-waitSampleSize:]
+	}
+	s.waits[queue] = waits
+}
+
+// Average 返回队列最近的平均等待时长，还没有接通过的队列返回false
+func (s *WaitStats) Average(queue string) (time.Duration, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	waits := s.waits[queue]
+	if len(waits) == 0 {
+		return 0, false
+	}
+	var total time.Duration
+	for _, wait := range waits {
+		total += wait
+	}
+	return total / time.Duration(len(waits)), true
+}
+
+// waitAnnouncement 按平均等待时长生成播报，如"预计等待约两分钟"
+func waitAnnouncement(average time.Duration) string {
+	minutes := int((average + time.Minute/2) / time.Minute)
+	switch {
+	case minutes < 1:
+		return "预计等待不到一分钟，请稍候"
+	case minutes >= 60:
+		return "预计等待超过一小时，您也可以稍后再拨"
+	default:
+		return fmt.Sprintf("预计等待约%s分钟，请稍候", chineseCount(minutes))
+	}
+}
+
+// chineseCount 1到99的中文数量词，单独的2读作"两"
+func chineseCount(n int) string {
+	digits := []string{"零", "一", "二", "三", "四", "五", "六", "七", "八", "九"}
+	switch {
+	case n == 2:
+		return "两"
+	case n < 10:
+		return digits[n]
+	case n == 10:
+		return "十"
+	case n < 20:
+		return "十" + digits[n%10]
+	case n%10 == 0:
+		return digits[n/10] + "十"
+	default:
+		return digits[n/10] + "十" + digits[n%10]
+	}
+}
+
+// RecordQueueWait 排队的呼叫接通时记录它实际等待的时长，用于之后的等待时间播报
+func (engine *AIPhoneEngine) RecordQueueWait(queue string, wait time.Duration) {
+	engine.waits.Record(queue, wait)
+}
+
+// StartQueueHold 对排队中的通话循环播放等待音乐，每隔waitAnnounceInterval按队列最近的实际平均等待时长
+// 播报预计等待时间；队列还没有接通记录时只播放音乐，不播报没有依据的时间
+func (engine *AIPhoneEngine) StartQueueHold(callID, queue, file string) error {
+	session := engine.GetSession(callID)
+	if session == nil {
+		return fmt.Errorf("session not found: %s", callID)
+	}
+
+	announce := func() string {
+		average, ok := engine.waits.Average(queue)
+		if !ok {
+			return ""
+		}
+		return waitAnnouncement(average)
+	}
+	player := engine.startAnnouncedHold(session, file, "", session.speakerID(), announce)
+	if player == nil {
+		return ErrNoHoldMedia
+	}
+
+	session.mutex.Lock()
+	previous := session.hold
+	session.hold = player
+	session.mutex.Unlock()
+	previous.Stop()
+	return nil
+}
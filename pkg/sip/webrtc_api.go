@@ -0,0 +1,70 @@
+package sip1
+
+import (
+	"net/http"
+
+	"github.com/LingByte/LingSIP/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterWebRTCAPIs 注册网页点击通话接口：POST /webrtc/offer {"number":"4001","sdp":"v=0..."} 建立通话并返回answer，
+// DELETE /webrtc/sessions/:id 挂断，GET /webrtc/sessions 查看进行中的网页通话；
+// 网页所在域名需配置在网关的AllowedOrigins中；网页调用的接口注册在r上，查看会话的接口注册在需认证的admin上
+func RegisterWebRTCAPIs(r, admin gin.IRoutes, server *SipServer) {
+	gateway := server.WebRTC()
+	cors := func(c *gin.Context) bool {
+		if !gateway.checkOrigin(c.Request) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return false
+		}
+		if origin := c.GetHeader("Origin"); origin != "" {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Content-Type")
+			c.Header("Vary", "Origin")
+		}
+		return true
+	}
+	preflight := func(c *gin.Context) {
+		if cors(c) {
+			c.Status(http.StatusNoContent)
+		}
+	}
+	r.OPTIONS("/webrtc/offer", preflight)
+	r.OPTIONS("/webrtc/sessions/:id", preflight)
+
+	r.POST("/webrtc/offer", func(c *gin.Context) {
+		if !cors(c) {
+			return
+		}
+		var form struct {
+			Number string `json:"number" binding:"required"`
+			SDP    string `json:"sdp" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&form); err != nil {
+			response.Fail(c, "invalid request", err.Error())
+			return
+		}
+		session, answer, err := gateway.Offer(c.Request.Context(), form.SDP, form.Number)
+		if err != nil {
+			response.Fail(c, "failed to start web call", err.Error())
+			return
+		}
+		response.Success(c, "ok", gin.H{"sessionId": session.ID, "callId": session.CallID, "type": "answer", "sdp": answer})
+	})
+
+	r.DELETE("/webrtc/sessions/:id", func(c *gin.Context) {
+		if !cors(c) {
+			return
+		}
+		if !gateway.Hangup(c.Param("id")) {
+			response.Fail(c, "session not found", nil)
+			return
+		}
+		response.Success(c, "ok", nil)
+	})
+
+	admin.GET("/webrtc/sessions", func(c *gin.Context) {
+		response.Success(c, "ok", gateway.Sessions())
+	})
+}
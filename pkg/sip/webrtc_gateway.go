@@ -0,0 +1,371 @@
+package sip1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LingByte/LingSIP/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrWebRTCDisabled 未配置允许浏览器呼叫的号码
+	ErrWebRTCDisabled = errors.New("webrtc gateway disabled")
+	// ErrWebRTCNumber 号码不在允许浏览器呼叫的列表中
+	ErrWebRTCNumber = errors.New("number not available for web calls")
+	// ErrWebRTCBusy 网关会话数或号码并发达到上限
+	ErrWebRTCBusy = errors.New("too many web calls")
+)
+
+// webrtcGatherTimeout 等待ICE候选收集完成的最长时间，超时后用已收集的候选应答
+const webrtcGatherTimeout = 5 * time.Second
+
+// WebRTCGateway 网页点击通话网关：HTTP交换SDP，浏览器音频经本机UDP中继接入AI引擎，
+// 引擎把中继地址当作普通RTP对端，无需区分网页和电话
+type WebRTCGateway struct {
+	server *SipServer
+
+	Numbers        []string // 允许网页呼叫的脚本号码，为空时关闭网关
+	ICEServers     []string // STUN/TURN地址，如 stun:stun.l.google.com:19302
+	AllowedOrigins []string // 允许跨域发起呼叫的页面Origin，*为全部允许，为空只允许同源
+	MaxSessions    int      // 网关同时进行的通话数上限，0不限制
+
+	mutex    sync.Mutex
+	sessions map[string]*WebRTCSession
+	nextHost byte
+}
+
+// WebRTCSession 一通网页通话
+type WebRTCSession struct {
+	ID        string    `json:"id"`
+	CallID    string    `json:"callId"`
+	Number    string    `json:"number"`
+	StartedAt time.Time `json:"startedAt"`
+
+	pc        *webrtc.PeerConnection
+	track     *webrtc.TrackLocalStaticRTP
+	relay     *net.UDPConn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newWebRTCGateway(server *SipServer) *WebRTCGateway {
+	return &WebRTCGateway{server: server, sessions: make(map[string]*WebRTCSession)}
+}
+
+// WebRTC 返回网页点击通话网关
+func (as *SipServer) WebRTC() *WebRTCGateway {
+	return as.webrtc
+}
+
+// Enabled 是否配置了允许网页呼叫的号码
+func (g *WebRTCGateway) Enabled() bool {
+	return len(g.Numbers) > 0
+}
+
+// checkOrigin 校验浏览器请求的Origin
+func (g *WebRTCGateway) checkOrigin(r *http.Request) bool {
+	return originAllowed(g.AllowedOrigins, r)
+}
+
+// Offer 用浏览器的SDP offer建立通话并启动号码对应的脚本，返回包含全部ICE候选的answer
+func (g *WebRTCGateway) Offer(ctx context.Context, offerSDP, number string) (*WebRTCSession, string, error) {
+	as := g.server
+	number = strings.TrimSpace(number)
+	switch {
+	case !g.Enabled():
+		return nil, "", ErrWebRTCDisabled
+	case !slices.Contains(g.Numbers, number):
+		return nil, "", fmt.Errorf("%w: %s", ErrWebRTCNumber, number)
+	case as.aiEngine == nil:
+		return nil, "", fmt.Errorf("AI phone engine not initialized")
+	case as.isDraining():
+		return nil, "", fmt.Errorf("server is shutting down")
+	}
+
+	session := &WebRTCSession{
+		ID:        uuid.NewString(),
+		CallID:    "webrtc-" + uuid.NewString(),
+		Number:    number,
+		StartedAt: time.Now(),
+		done:      make(chan struct{}),
+	}
+	if !g.add(session) {
+		return nil, "", ErrWebRTCBusy
+	}
+	if exceeded, ok := as.quotas.acquire(session.CallID, as.inviteQuotas(number)); !ok {
+		g.remove(session.ID)
+		return nil, "", fmt.Errorf("%w: %s", ErrWebRTCBusy, exceeded.id())
+	}
+
+	answer, err := g.connect(ctx, session, offerSDP)
+	if err != nil {
+		g.close(session)
+		return nil, "", err
+	}
+
+	// 先订阅再启动脚本，不会错过会话结束事件
+	events, unsubscribe := as.monitor.Subscribe()
+	as.saveCallCodec(session.CallID, codecPCMU)
+	if err := as.aiEngine.StartScript(session.CallID, session.relay.LocalAddr().String(), number, ""); err != nil {
+		unsubscribe()
+		g.close(session)
+		return nil, "", fmt.Errorf("failed to start script: %w", err)
+	}
+	go g.watchSession(session, events, unsubscribe)
+
+	logger.Info("WebRTC call started",
+		zap.String("session_id", session.ID),
+		zap.String("call_id", session.CallID),
+		zap.String("number", number),
+		zap.String("relay", session.relay.LocalAddr().String()))
+	return session, answer, nil
+}
+
+// connect 创建PeerConnection和本机中继，完成offer/answer
+func (g *WebRTCGateway) connect(ctx context.Context, session *WebRTCSession, offerSDP string) (string, error) {
+	relay, err := g.listenRelay()
+	if err != nil {
+		return "", fmt.Errorf("failed to open RTP relay: %w", err)
+	}
+	session.relay = relay
+
+	api, err := newWebRTCAPI()
+	if err != nil {
+		return "", err
+	}
+	config := webrtc.Configuration{}
+	if len(g.ICEServers) > 0 {
+		config.ICEServers = []webrtc.ICEServer{{URLs: g.ICEServers}}
+	}
+	pc, err := api.NewPeerConnection(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to create peer connection: %w", err)
+	}
+	session.pc = pc
+
+	// AI引擎按协商的编码发送，网关只提供G.711 μ-law，浏览器都支持，无需转码
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtcPCMU, "audio", "lingsip")
+	if err != nil {
+		return "", fmt.Errorf("failed to create audio track: %w", err)
+	}
+	session.track = track
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		return "", fmt.Errorf("failed to add audio track: %w", err)
+	}
+	// 读取RTCP，拦截器才会处理浏览器的接收报告
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buffer); err != nil {
+				return
+			}
+		}
+	}()
+
+	engineAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: g.server.config.LocalRTPPort}
+	pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if remote.Kind() == webrtc.RTPCodecTypeAudio {
+			go session.forwardInbound(remote, engineAddr)
+		}
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		logger.Debug("WebRTC connection state changed",
+			zap.String("call_id", session.CallID),
+			zap.String("state", state.String()))
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			g.Hangup(session.ID)
+		}
+	})
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSDP}); err != nil {
+		return "", fmt.Errorf("invalid offer: %w", err)
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create answer: %w", err)
+	}
+	// 不使用trickle ICE，收集完候选再一次性应答
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return "", fmt.Errorf("failed to set local description: %w", err)
+	}
+	select {
+	case <-gathered:
+	case <-time.After(webrtcGatherTimeout):
+		logger.Warn("ICE gathering timeout, answering with partial candidates", zap.String("call_id", session.CallID))
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	go session.forwardOutbound(g.server.config.LocalRTPPort)
+	return pc.LocalDescription().SDP, nil
+}
+
+// webrtcPCMU 网关使用的音频编码
+var webrtcPCMU = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: uint32(codecPCMU.ClockRate), Channels: 1}
+
+// newWebRTCAPI 只注册PCMU的媒体引擎，并启用默认的NACK和RTCP报告拦截器
+func newWebRTCAPI() (*webrtc.API, error) {
+	media := &webrtc.MediaEngine{}
+	if err := media.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtcPCMU,
+		PayloadType:        webrtc.PayloadType(codecPCMU.PayloadType),
+	}, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, fmt.Errorf("failed to register PCMU: %w", err)
+	}
+	registry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(media, registry); err != nil {
+		return nil, fmt.Errorf("failed to register interceptors: %w", err)
+	}
+	return webrtc.NewAPI(webrtc.WithMediaEngine(media), webrtc.WithInterceptorRegistry(registry)), nil
+}
+
+// listenRelay 在本机回环地址上打开中继端口。AI引擎按IP匹配RTP来源，
+// 每通网页通话轮流使用127.0.0.2~254中的不同地址，不支持时退回127.0.0.1
+func (g *WebRTCGateway) listenRelay() (*net.UDPConn, error) {
+	g.mutex.Lock()
+	g.nextHost = g.nextHost%253 + 1
+	host := g.nextHost + 1
+	g.mutex.Unlock()
+
+	if conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, host)}); err == nil {
+		return conn, nil
+	}
+	return net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+}
+
+// forwardInbound 浏览器音频转发到AI引擎的RTP端口
+func (s *WebRTCSession) forwardInbound(remote *webrtc.TrackRemote, engineAddr *net.UDPAddr) {
+	for {
+		packet, _, err := remote.ReadRTP()
+		if err != nil {
+			return
+		}
+		packet.PayloadType = codecPCMU.PayloadType
+		data, err := packet.Marshal()
+		if err != nil {
+			continue
+		}
+		if _, err := s.relay.WriteToUDP(data, engineAddr); err != nil {
+			return
+		}
+	}
+}
+
+// forwardOutbound AI引擎发往中继的音频写入浏览器的音轨
+func (s *WebRTCSession) forwardOutbound(enginePort int) {
+	buffer := make([]byte, 1500)
+	for {
+		n, from, err := s.relay.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		if from.Port != enginePort {
+			continue
+		}
+		packet := &rtp.Packet{}
+		if err := packet.Unmarshal(buffer[:n]); err != nil {
+			continue
+		}
+		if err := s.track.WriteRTP(packet); err != nil && !errors.Is(err, net.ErrClosed) {
+			logger.Debug("Failed to write WebRTC audio", zap.String("call_id", s.CallID), zap.Error(err))
+		}
+	}
+}
+
+// watchSession AI会话结束时断开浏览器，浏览器先挂断时随之返回
+func (g *WebRTCGateway) watchSession(session *WebRTCSession, events <-chan MonitorEvent, unsubscribe func()) {
+	defer unsubscribe()
+	defer logger.Info("WebRTC call ended", zap.String("session_id", session.ID), zap.String("call_id", session.CallID))
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok || event.Type == MonitorSessionEnded && event.CallID == session.CallID {
+				g.close(session)
+				return
+			}
+		case <-session.done:
+			return
+		}
+	}
+}
+
+// Hangup 浏览器挂断：停止AI会话并断开，会话不存在时返回false
+func (g *WebRTCGateway) Hangup(id string) bool {
+	g.mutex.Lock()
+	session, exists := g.sessions[id]
+	g.mutex.Unlock()
+	if !exists {
+		return false
+	}
+	if g.server.aiEngine != nil {
+		g.server.aiEngine.StopSession(session.CallID)
+	}
+	g.close(session)
+	return true
+}
+
+// Sessions 进行中的网页通话
+func (g *WebRTCGateway) Sessions() []*WebRTCSession {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	sessions := make([]*WebRTCSession, 0, len(g.sessions))
+	for _, session := range g.sessions {
+		sessions = append(sessions, session)
+	}
+	slices.SortFunc(sessions, func(a, b *WebRTCSession) int { return a.StartedAt.Compare(b.StartedAt) })
+	return sessions
+}
+
+// closeAll 服务关闭时断开全部网页通话
+func (g *WebRTCGateway) closeAll() {
+	for _, session := range g.Sessions() {
+		g.close(session)
+	}
+}
+
+// close 关闭PeerConnection和中继，释放通话的编码和并发名额，可重复调用
+func (g *WebRTCGateway) close(session *WebRTCSession) {
+	session.closeOnce.Do(func() {
+		g.remove(session.ID)
+		if session.pc != nil {
+			if err := session.pc.Close(); err != nil {
+				logger.Debug("Failed to close peer connection", zap.String("call_id", session.CallID), zap.Error(err))
+			}
+		}
+		if session.relay != nil {
+			session.relay.Close()
+		}
+		close(session.done)
+		g.server.teardownCall(session.CallID)
+	})
+}
+
+func (g *WebRTCGateway) add(session *WebRTCSession) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.MaxSessions > 0 && len(g.sessions) >= g.MaxSessions {
+		return false
+	}
+	g.sessions[session.ID] = session
+	return true
+}
+
+func (g *WebRTCGateway) remove(id string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.sessions, id)
+}
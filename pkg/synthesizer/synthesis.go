@@ -482,6 +482,38 @@ func (s *SynthesisBuffer) OnTimestamp(timestamp SentenceTimestamp) {
 	s.Timestamp = timestamp
 }
 
+// SynthesisStream forwards synthesized audio chunks through a channel as soon as the provider returns them
+type SynthesisStream struct {
+	chunks chan []byte
+	once   sync.Once
+}
+
+// NewSynthesisStream creates a stream with the given channel buffer size
+func NewSynthesisStream(size int) *SynthesisStream {
+	return &SynthesisStream{chunks: make(chan []byte, size)}
+}
+
+func (s *SynthesisStream) OnMessage(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	chunk := make([]byte, len(data))
+	copy(chunk, data)
+	s.chunks <- chunk
+}
+
+func (s *SynthesisStream) OnTimestamp(timestamp SentenceTimestamp) {}
+
+// Chunks returns the channel of audio chunks, closed after Close
+func (s *SynthesisStream) Chunks() <-chan []byte {
+	return s.chunks
+}
+
+// Close marks the end of synthesis, safe to call more than once
+func (s *SynthesisStream) Close() {
+	s.once.Do(func() { close(s.chunks) })
+}
+
 func NewSynthesisService(name string, options map[string]any) (SynthesisService, error) {
 	switch name {
 	case TTS_QCLOUD:
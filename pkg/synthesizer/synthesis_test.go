@@ -0,0 +1,25 @@
+package synthesizer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSynthesisStream(t *testing.T) {
+	stream := NewSynthesisStream(4)
+
+	data := []byte{1, 2, 3}
+	stream.OnMessage(data)
+	stream.OnMessage(nil)
+	data[0] = 9 // chunk must be copied
+	stream.OnMessage([]byte{4})
+	stream.Close()
+	stream.Close()
+
+	var chunks [][]byte
+	for chunk := range stream.Chunks() {
+		chunks = append(chunks, chunk)
+	}
+	assert.Equal(t, [][]byte{{1, 2, 3}, {4}}, chunks)
+}
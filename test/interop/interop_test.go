@@ -0,0 +1,454 @@
+//go:build interop
+
+// Package interop drives a running LingSIP server with the SIP dialogs that
+// common softphones and PBXs produce: REGISTER, INVITE with each peer's SDP
+// offer, DTMF over INFO and RFC 2833, hold via re-INVITE, and BYE.
+//
+// Run against a server started separately:
+//
+//	LINGSIP_INTEROP_ADDR=127.0.0.1:5060 go test -tags interop ./test/interop/
+//
+// LINGSIP_INTEROP_CALLEE selects the number dialed (default 1000) and
+// LINGSIP_INTEROP_LOCAL_IP the address advertised in Contact and SDP.
+//
+// REGISTER needs the server's database: each peer registers as a SIP user
+// named after its profile (linphone, pjsip, freeswitch, asterisk) with the
+// password in LINGSIP_INTEROP_PASSWORD. With LINGSIP_INTEROP_API set to the
+// HTTP API base URL (and LINGSIP_INTEROP_API_TOKEN when ADMIN_TOKENS is set)
+// the users are created before registering.
+//
+// docker-compose.yml in this directory starts the server next to Asterisk,
+// FreeSWITCH, PJSUA and Linphone so the real stacks can be exercised too; see
+// run.sh.
+package interop
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+	"github.com/icholy/digest"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+)
+
+const requestTimeout = 10 * time.Second
+
+type harness struct {
+	target  string
+	callee  string
+	localIP string
+	sipPort int
+
+	ua     *sipgo.UserAgent
+	client *sipgo.Client
+	dialog *sipgo.DialogClient
+}
+
+func env(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// newHarness listens on a local UDP port so the server can reach us with
+// in-dialog requests, and returns a dialog client bound to it
+func newHarness(t *testing.T, peer peerProfile) *harness {
+	t.Helper()
+	target := os.Getenv("LINGSIP_INTEROP_ADDR")
+	if target == "" {
+		t.Skip("LINGSIP_INTEROP_ADDR not set")
+	}
+	h := &harness{
+		target:  target,
+		callee:  env("LINGSIP_INTEROP_CALLEE", "1000"),
+		localIP: env("LINGSIP_INTEROP_LOCAL_IP", "127.0.0.1"),
+	}
+
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(h.localIP)})
+	if err != nil {
+		t.Fatalf("reserve SIP port: %v", err)
+	}
+	h.sipPort = probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+
+	if h.ua, err = sipgo.NewUA(sipgo.WithUserAgent(peer.UserAgent)); err != nil {
+		t.Fatalf("create UA: %v", err)
+	}
+	server, err := sipgo.NewServer(h.ua)
+	if err != nil {
+		t.Fatalf("create server: %v", err)
+	}
+	ok := func(req *sip.Request, tx sip.ServerTransaction) {
+		tx.Respond(sip.NewResponseFromRequest(req, sip.StatusOK, "OK", nil))
+	}
+	server.OnBye(ok)
+	server.OnOptions(ok)
+	server.OnNotify(ok)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	listenAddr := net.JoinHostPort(h.localIP, strconv.Itoa(h.sipPort))
+	go server.ListenAndServe(ctx, "udp", listenAddr)
+	time.Sleep(100 * time.Millisecond)
+
+	if h.client, err = sipgo.NewClient(h.ua, sipgo.WithClientAddr(listenAddr)); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	h.dialog = sipgo.NewDialogClient(h.client, sip.ContactHeader{
+		Address: sip.Uri{User: peer.Name, Host: h.localIP, Port: h.sipPort},
+	})
+
+	t.Cleanup(func() {
+		cancel()
+		h.client.Close()
+		h.ua.Close()
+	})
+	return h
+}
+
+func (h *harness) uri(user string) sip.Uri {
+	host, port, _ := net.SplitHostPort(h.target)
+	p, _ := strconv.Atoi(port)
+	return sip.Uri{User: user, Host: host, Port: p}
+}
+
+// request sends a non-INVITE request and waits for the final response
+func (h *harness) request(ctx context.Context, req *sip.Request) (*sip.Response, error) {
+	tx, err := h.client.TransactionRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Terminate()
+	for {
+		select {
+		case res := <-tx.Responses():
+			if res.StatusCode < 200 {
+				continue
+			}
+			return res, nil
+		case <-tx.Done():
+			return nil, tx.Err()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// call is an answered INVITE dialog with its RTP socket
+type call struct {
+	h       *harness
+	session *sipgo.DialogClientSession
+	rtp     *net.UDPConn
+	remote  *net.UDPAddr
+	cseq    uint32
+	seq     uint16
+	ts      uint32
+}
+
+func (h *harness) invite(t *testing.T, peer peerProfile) *call {
+	t.Helper()
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(h.localIP)})
+	if err != nil {
+		t.Fatalf("open RTP socket: %v", err)
+	}
+	t.Cleanup(func() { rtpConn.Close() })
+	rtpPort := rtpConn.LocalAddr().(*net.UDPAddr).Port
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	recipient := h.uri(h.callee)
+	session, err := h.dialog.Invite(ctx, &recipient, peer.offer(h.localIP, rtpPort, "sendrecv"),
+		sip.NewHeader("Content-Type", "application/sdp"),
+		&sip.FromHeader{Address: sip.Uri{User: peer.Name, Host: h.localIP, Port: h.sipPort}, Params: sip.HeaderParams{"tag": sip.GenerateTagN(10)}},
+	)
+	if err != nil {
+		t.Fatalf("send INVITE: %v", err)
+	}
+	if err := session.WaitAnswer(ctx, sipgo.AnswerOptions{}); err != nil {
+		t.Fatalf("INVITE not answered: %v", err)
+	}
+	if err := session.Ack(ctx); err != nil {
+		t.Fatalf("send ACK: %v", err)
+	}
+
+	remote, err := answerRTPAddr(session.InviteResponse.Body())
+	if err != nil {
+		t.Fatalf("parse SDP answer: %v", err)
+	}
+	cseq := uint32(1)
+	if h := session.InviteRequest.CSeq(); h != nil {
+		cseq = h.SeqNo
+	}
+	return &call{h: h, session: session, rtp: rtpConn, remote: remote, cseq: cseq}
+}
+
+// answerRTPAddr returns the media address of the SDP answer
+func answerRTPAddr(body []byte) (*net.UDPAddr, error) {
+	var desc sdp.SessionDescription
+	if err := desc.Unmarshal(body); err != nil {
+		return nil, err
+	}
+	if len(desc.MediaDescriptions) == 0 {
+		return nil, fmt.Errorf("answer has no media")
+	}
+	media := desc.MediaDescriptions[0]
+	conn := desc.ConnectionInformation
+	if media.ConnectionInformation != nil {
+		conn = media.ConnectionInformation
+	}
+	if conn == nil || conn.Address == nil {
+		return nil, fmt.Errorf("answer has no connection address")
+	}
+	return &net.UDPAddr{IP: net.ParseIP(conn.Address.Address), Port: media.MediaName.Port.Value}, nil
+}
+
+// inDialog builds a request inside the confirmed dialog
+func (c *call) inDialog(method sip.RequestMethod, contentType string, body []byte) *sip.Request {
+	invite, answer := c.session.InviteRequest, c.session.InviteResponse
+	target := *invite.Recipient
+	if contact := answer.Contact(); contact != nil {
+		target = contact.Address
+	}
+	req := sip.NewRequest(method, &target)
+	req.AppendHeader(sip.HeaderClone(invite.From()))
+	req.AppendHeader(sip.HeaderClone(answer.To()))
+	req.AppendHeader(sip.HeaderClone(invite.CallID()))
+	c.cseq++
+	req.AppendHeader(&sip.CSeqHeader{SeqNo: c.cseq, MethodName: method})
+	if contentType != "" {
+		req.AppendHeader(sip.NewHeader("Content-Type", contentType))
+	}
+	req.SetBody(body)
+	req.SetDestination(c.h.target)
+	return req
+}
+
+func (c *call) expectOK(t *testing.T, req *sip.Request) *sip.Response {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	res, err := c.h.request(ctx, req)
+	if err != nil {
+		t.Fatalf("%s failed: %v", req.Method, err)
+	}
+	if res.StatusCode != sip.StatusOK {
+		t.Fatalf("%s answered %d %s", req.Method, res.StatusCode, res.Reason)
+	}
+	return res
+}
+
+// sendINFO sends one digit as application/dtmf-relay
+func (c *call) sendINFO(t *testing.T, digit string) {
+	t.Helper()
+	body := fmt.Sprintf("Signal=%s\r\nDuration=160\r\n", digit)
+	c.expectOK(t, c.inDialog(sip.INFO, "application/dtmf-relay", []byte(body)))
+}
+
+// sendRFC2833 sends one digit as telephone-event packets: three updates and
+// three end packets, all with the same timestamp
+func (c *call) sendRFC2833(t *testing.T, pt uint8, digit string) {
+	t.Helper()
+	events := map[string]byte{"0": 0, "1": 1, "2": 2, "3": 3, "4": 4, "5": 5, "6": 6, "7": 7, "8": 8, "9": 9, "*": 10, "#": 11}
+	event, ok := events[digit]
+	if !ok {
+		t.Fatalf("unsupported digit %q", digit)
+	}
+	c.ts += 1600
+	for i := 0; i < 6; i++ {
+		end := i >= 3
+		duration := uint16(160 * (i + 1))
+		payload := make([]byte, 4)
+		payload[0] = event
+		payload[1] = 10 // volume
+		if end {
+			payload[1] |= 0x80
+		}
+		binary.BigEndian.PutUint16(payload[2:], duration)
+		c.seq++
+		packet := &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         i == 0,
+				PayloadType:    pt,
+				SequenceNumber: c.seq,
+				Timestamp:      c.ts,
+				SSRC:           0x1234abcd,
+			},
+			Payload: payload,
+		}
+		data, err := packet.Marshal()
+		if err != nil {
+			t.Fatalf("marshal RTP: %v", err)
+		}
+		if _, err := c.rtp.WriteToUDP(data, c.remote); err != nil {
+			t.Fatalf("send RTP: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// hold puts the call on hold with a=sendonly and resumes it with sendrecv
+func (c *call) hold(t *testing.T, peer peerProfile, direction string) {
+	t.Helper()
+	port := c.rtp.LocalAddr().(*net.UDPAddr).Port
+	req := c.inDialog(sip.INVITE, "application/sdp", peer.offer(c.h.localIP, port, direction))
+	res := c.expectOK(t, req)
+	ack := sip.NewAckRequest(req, res, nil)
+	if err := c.h.client.WriteRequest(ack); err != nil {
+		t.Fatalf("send ACK for re-INVITE: %v", err)
+	}
+}
+
+func (c *call) bye(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if err := c.session.Bye(ctx); err != nil {
+		t.Fatalf("BYE failed: %v", err)
+	}
+}
+
+// seedUser creates the peer's SIP user through the HTTP API when
+// LINGSIP_INTEROP_API is set; otherwise the user must already exist.
+// A user left by an earlier run is kept, it was created with the same password.
+func seedUser(t *testing.T, username, password string) {
+	t.Helper()
+	api := os.Getenv("LINGSIP_INTEROP_API")
+	if api == "" {
+		return
+	}
+	body, _ := json.Marshal(map[string]string{"username": username, "password": password})
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(api, "/")+"/api/sip-users", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("build seed request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("LINGSIP_INTEROP_API_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("seed SIP user %s: %v", username, err)
+	}
+	defer res.Body.Close()
+	var result struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatalf("seed SIP user %s: HTTP %d: %v", username, res.StatusCode, err)
+	}
+	if result.Code != http.StatusOK && result.Msg != "SIP user already exists" {
+		t.Fatalf("seed SIP user %s: %d %s", username, result.Code, result.Msg)
+	}
+}
+
+// register sends REGISTER for user and answers the 401 challenge with digest
+// credentials, the way a softphone does, returning the final response
+func (h *harness) register(t *testing.T, user, password string, expires int) *sip.Response {
+	t.Helper()
+	recipient := h.uri("")
+	req := sip.NewRequest(sip.REGISTER, &recipient)
+	aor := sip.Uri{User: user, Host: strings.Split(h.target, ":")[0]}
+	req.AppendHeader(&sip.FromHeader{Address: aor, Params: sip.HeaderParams{"tag": sip.GenerateTagN(10)}})
+	req.AppendHeader(&sip.ToHeader{Address: aor})
+	req.AppendHeader(&sip.ContactHeader{Address: sip.Uri{User: user, Host: h.localIP, Port: h.sipPort}})
+	req.AppendHeader(sip.NewHeader("Expires", strconv.Itoa(expires)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	res, err := h.request(ctx, req)
+	if err != nil {
+		t.Fatalf("REGISTER failed: %v", err)
+	}
+	if res.StatusCode != sip.StatusUnauthorized {
+		return res
+	}
+
+	wwwAuth := res.GetHeader("WWW-Authenticate")
+	if wwwAuth == nil {
+		t.Fatal("401 without WWW-Authenticate")
+	}
+	chal, err := digest.ParseChallenge(wwwAuth.Value())
+	if err != nil {
+		t.Fatalf("parse challenge %q: %v", wwwAuth.Value(), err)
+	}
+	cred, err := digest.Digest(chal, digest.Options{Method: string(sip.REGISTER), URI: recipient.String(), Username: user, Password: password})
+	if err != nil {
+		t.Fatalf("build digest: %v", err)
+	}
+	req.CSeq().SeqNo++
+	req.AppendHeader(sip.NewHeader("Authorization", cred.String()))
+	req.RemoveHeader("Via")
+	if res, err = h.request(ctx, req); err != nil {
+		t.Fatalf("authenticated REGISTER failed: %v", err)
+	}
+	return res
+}
+
+func TestRegister(t *testing.T) {
+	password := os.Getenv("LINGSIP_INTEROP_PASSWORD")
+	for _, peer := range peerProfiles {
+		t.Run(peer.Name, func(t *testing.T) {
+			h := newHarness(t, peer)
+			if password == "" {
+				t.Skip("LINGSIP_INTEROP_PASSWORD not set")
+			}
+			seedUser(t, peer.Name, password)
+
+			res := h.register(t, peer.Name, password, 60)
+			if res.StatusCode != sip.StatusOK {
+				t.Fatalf("REGISTER answered %d %s", res.StatusCode, res.Reason)
+			}
+			// 200 OK lists every binding of the user, ours among them
+			bound := false
+			for _, header := range res.GetHeaders("Contact") {
+				if contact, ok := header.(*sip.ContactHeader); ok && contact.Address.Port == h.sipPort {
+					bound = true
+				}
+			}
+			if !bound {
+				t.Errorf("200 OK Contact = %v, want the binding on port %d", res.GetHeaders("Contact"), h.sipPort)
+			}
+
+			if res := h.register(t, peer.Name, password, 0); res.StatusCode != sip.StatusOK {
+				t.Errorf("unREGISTER answered %d %s", res.StatusCode, res.Reason)
+			}
+		})
+	}
+}
+
+func TestCallFlow(t *testing.T) {
+	for _, peer := range peerProfiles {
+		t.Run(peer.Name, func(t *testing.T) {
+			h := newHarness(t, peer)
+			c := h.invite(t, peer)
+
+			switch peer.DTMF {
+			case dtmfINFO:
+				c.sendINFO(t, "1")
+				c.sendINFO(t, "#")
+			default:
+				c.sendRFC2833(t, peer.TelephoneEvent, "1")
+				c.sendRFC2833(t, peer.TelephoneEvent, "#")
+			}
+
+			c.hold(t, peer, "sendonly")
+			c.hold(t, peer, "sendrecv")
+			c.bye(t)
+		})
+	}
+}
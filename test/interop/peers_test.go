@@ -0,0 +1,91 @@
+//go:build interop
+
+package interop
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dtmfMode how a peer sends DTMF by default
+type dtmfMode int
+
+const (
+	dtmfRFC2833 dtmfMode = iota
+	dtmfINFO
+)
+
+// peerProfile describes the INVITE a softphone or PBX sends by default:
+// offered payload types, telephone-event payload type, ptime and DTMF style.
+type peerProfile struct {
+	Name           string
+	UserAgent      string
+	Codecs         []string // rtpmap entries "pt name/clock"
+	TelephoneEvent uint8    // 0 when not offered
+	PTime          int
+	DTMF           dtmfMode
+}
+
+var peerProfiles = []peerProfile{
+	{
+		Name:           "linphone",
+		UserAgent:      "Linphone Desktop/5.2.0 (belle-sip/5.2.0)",
+		Codecs:         []string{"96 opus/48000/2", "0 PCMU/8000", "8 PCMA/8000"},
+		TelephoneEvent: 101,
+		PTime:          20,
+		DTMF:           dtmfRFC2833,
+	},
+	{
+		Name:           "pjsip",
+		UserAgent:      "PJSUA v2.14 Linux",
+		Codecs:         []string{"9 G722/8000", "0 PCMU/8000", "8 PCMA/8000"},
+		TelephoneEvent: 101,
+		PTime:          20,
+		DTMF:           dtmfRFC2833,
+	},
+	{
+		Name:           "freeswitch",
+		UserAgent:      "FreeSWITCH-mod_sofia/1.10.11",
+		Codecs:         []string{"8 PCMA/8000", "0 PCMU/8000", "13 CN/8000"},
+		TelephoneEvent: 101,
+		PTime:          20,
+		DTMF:           dtmfINFO,
+	},
+	{
+		Name:           "asterisk",
+		UserAgent:      "Asterisk PBX 20.5.0",
+		Codecs:         []string{"0 PCMU/8000", "8 PCMA/8000"},
+		TelephoneEvent: 96,
+		PTime:          20,
+		DTMF:           dtmfRFC2833,
+	},
+}
+
+// offer builds the peer's SDP offer for an RTP socket at ip:port
+func (p peerProfile) offer(ip string, port int, direction string) []byte {
+	var pts []string
+	for _, codec := range p.Codecs {
+		pts = append(pts, strings.Fields(codec)[0])
+	}
+	if p.TelephoneEvent != 0 {
+		pts = append(pts, fmt.Sprint(p.TelephoneEvent))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "v=0\r\n")
+	fmt.Fprintf(&b, "o=%s 1234 1 IN IP4 %s\r\n", p.Name, ip)
+	fmt.Fprintf(&b, "s=%s\r\n", p.Name)
+	fmt.Fprintf(&b, "c=IN IP4 %s\r\n", ip)
+	fmt.Fprintf(&b, "t=0 0\r\n")
+	fmt.Fprintf(&b, "m=audio %d RTP/AVP %s\r\n", port, strings.Join(pts, " "))
+	for _, codec := range p.Codecs {
+		fmt.Fprintf(&b, "a=rtpmap:%s\r\n", codec)
+	}
+	if p.TelephoneEvent != 0 {
+		fmt.Fprintf(&b, "a=rtpmap:%d telephone-event/8000\r\n", p.TelephoneEvent)
+		fmt.Fprintf(&b, "a=fmtp:%d 0-16\r\n", p.TelephoneEvent)
+	}
+	fmt.Fprintf(&b, "a=ptime:%d\r\n", p.PTime)
+	fmt.Fprintf(&b, "a=%s\r\n", direction)
+	return []byte(b.String())
+}
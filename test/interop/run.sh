@@ -0,0 +1,63 @@
+#!/bin/sh
+# Starts the interop harness, seeds one SIP user per peer, checks that Asterisk
+# and FreeSWITCH register and complete a call, then runs the Go interop tests
+# (which replay Linphone, PJSIP, FreeSWITCH and Asterisk dialogs) against the
+# same server. pjsua and linphonec are exercised too when installed locally.
+#
+#   LINGSIP_INTEROP_PASSWORD=secret test/interop/run.sh
+set -eu
+
+cd "$(dirname "$0")"
+: "${LINGSIP_INTEROP_PASSWORD:?set LINGSIP_INTEROP_PASSWORD}"
+API=http://127.0.0.1:7072/api
+PEERS="linphone pjsip freeswitch asterisk"
+
+cleanup() {
+	[ "${KEEP:-}" = 1 ] || docker compose down -v
+}
+trap cleanup EXIT
+
+docker compose up -d --wait lingsip
+
+for user in $PEERS; do
+	curl -sf -H 'Content-Type: application/json' \
+		-d "{\"username\":\"$user\",\"password\":\"$LINGSIP_INTEROP_PASSWORD\"}" \
+		"$API/sip-users" >/dev/null
+done
+
+docker compose up -d asterisk freeswitch
+
+# wait_registered <user>: the PBX retries REGISTER every 5 seconds
+wait_registered() {
+	for _ in $(seq 30); do
+		if curl -sf "$API/registrations?user=$1" | grep -q '"contact"'; then
+			return 0
+		fi
+		sleep 2
+	done
+	echo "$1 did not register" >&2
+	docker compose logs "$1" | tail -50 >&2
+	return 1
+}
+wait_registered asterisk
+wait_registered freeswitch
+
+docker compose exec -T asterisk asterisk -rx 'channel originate Local/call@interop application Wait 5'
+docker compose exec -T freeswitch fs_cli -x 'originate {ignore_early_media=true}sofia/gateway/lingsip/1000 &wait_for_silence(1000 5 1 5000)'
+
+if command -v pjsua >/dev/null; then
+	pjsua --null-audio --local-port=5090 --id=sip:pjsip@127.0.0.1 --registrar=sip:127.0.0.1:5060 \
+		--realm='*' --username=pjsip --password="$LINGSIP_INTEROP_PASSWORD" \
+		--duration=5 --auto-quit sip:1000@127.0.0.1:5060 </dev/null
+fi
+if command -v linphonec >/dev/null; then
+	linphonecsh init -c /dev/null
+	linphonecsh register --host 127.0.0.1 --username linphone --password "$LINGSIP_INTEROP_PASSWORD"
+	linphonecsh dial sip:1000@127.0.0.1
+	sleep 5
+	linphonecsh exit
+fi
+
+cd ../..
+LINGSIP_INTEROP_ADDR=127.0.0.1:5060 LINGSIP_INTEROP_API=http://127.0.0.1:7072 \
+	go test -tags interop -count=1 -v ./test/interop/